@@ -10,6 +10,7 @@ import (
 	"io" // MODIFICA: Aggiunto import per io.ReadAll
 	"log"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
@@ -67,6 +68,10 @@ func InitAzureAD(cfg *config.Config) error {
 	}
 	log.Printf("[DEBUG] InitAzureAD: oauth2Config initialized with ClientID: '%s', RedirectURL: '%s', Auth Endpoint URL: '%s'", oauth2Config.ClientID, oauth2Config.RedirectURL, oauth2Config.Endpoint.AuthURL)
 
+	if err := initSessionKey(cfg.AzureAD.SessionSecret); err != nil {
+		return err
+	}
+
 	go cleanupExpiredStates()
 
 	if config.IsLogLevel(config.LogLevelInfo) {
@@ -160,22 +165,47 @@ func GetLoginURL() (string, error) {
 	return loginURL, nil
 }
 
+// GetLogoutURL returns the end_session_endpoint of the OIDC discovery document with post_logout_redirect_uri,
+// to terminate the session with Microsoft Entra ID as well.
+func GetLogoutURL(postLogoutRedirectURL string) (string, error) {
+	if provider == nil {
+		return "", errors.New("Azure AD authentication not initialized")
+	}
+	var discovery struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&discovery); err != nil {
+		return "", fmt.Errorf("unable to read the OIDC discovery document: %w", err)
+	}
+	if discovery.EndSessionEndpoint == "" {
+		return "", errors.New("the OIDC discovery document has no end_session_endpoint")
+	}
+	logoutURL, err := url.Parse(discovery.EndSessionEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid end_session_endpoint: %w", err)
+	}
+	query := logoutURL.Query()
+	query.Set("post_logout_redirect_uri", postLogoutRedirectURL)
+	logoutURL.RawQuery = query.Encode()
+	return logoutURL.String(), nil
+}
+
 // HandleCallback handles the callback after authentication with Microsoft Entra ID.
-// Restituisce l'ID Token, l'Access Token e un errore.
-func HandleCallback(ctx context.Context, r *http.Request) (*oidc.IDToken, string, error) {
+// Restituisce l'ID Token, l'Access Token, il Refresh Token (vuoto se Entra ID non lo ha emesso) e un errore.
+func HandleCallback(ctx context.Context, r *http.Request) (*oidc.IDToken, string, string, error) {
 	state := r.URL.Query().Get("state")
 	if config.IsLogLevel(config.LogLevelDebug) {
 		log.Printf("[DEBUG] HandleCallback: Received state from callback: %s", state)
 	}
 	if !VerifyState(state) {
 		log.Println("[ERROR] HandleCallback: State verification failed")
-		return nil, "", errors.New("invalid or missing OIDC state")
+		return nil, "", "", errors.New("invalid or missing OIDC state")
 	}
 
 	oauth2Token, err := oauth2Config.Exchange(ctx, r.URL.Query().Get("code"))
 	if err != nil {
 		log.Printf("Error exchanging OAuth2 code: %v", err)
-		return nil, "", fmt.Errorf("unable to exchange OAuth2 code: %w", err)
+		return nil, "", "", fmt.Errorf("unable to exchange OAuth2 code: %w", err)
 	}
 	if config.IsLogLevel(config.LogLevelDebug) {
 		log.Printf("[DEBUG] HandleCallback: OAuth2 Token received. Expires in: %s", oauth2Token.Expiry.Sub(time.Now()))
@@ -183,7 +213,7 @@ func HandleCallback(ctx context.Context, r *http.Request) (*oidc.IDToken, string
 
 	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
 	if !ok {
-		return nil, "", errors.New("no id_token present in OAuth2 response")
+		return nil, "", "", errors.New("no id_token present in OAuth2 response")
 	}
 	if config.IsLogLevel(config.LogLevelDebug) {
 		log.Printf("[DEBUG] HandleCallback: Raw ID Token received (length: %d)", len(rawIDToken))
@@ -191,7 +221,7 @@ func HandleCallback(ctx context.Context, r *http.Request) (*oidc.IDToken, string
 
 	accessToken, ok := oauth2Token.Extra("access_token").(string)
 	if !ok {
-		return nil, "", errors.New("no access_token present in OAuth2 response")
+		return nil, "", "", errors.New("no access_token present in OAuth2 response")
 	}
 	if config.IsLogLevel(config.LogLevelDebug) {
 		log.Printf("[DEBUG] HandleCallback: Access Token received (length: %d)", len(accessToken))
@@ -204,13 +234,13 @@ func HandleCallback(ctx context.Context, r *http.Request) (*oidc.IDToken, string
 	idToken, err := provider.Verifier(oidcConfig).Verify(ctx, rawIDToken)
 	if err != nil {
 		log.Printf("Error verifying id_token: %v", err)
-		return nil, "", fmt.Errorf("unable to verify id_token: %w", err)
+		return nil, "", "", fmt.Errorf("unable to verify id_token: %w", err)
 	}
 	if config.IsLogLevel(config.LogLevelDebug) {
 		log.Printf("[DEBUG] HandleCallback: ID Token verified successfully. Subject: %s, Issuer: %s", idToken.Subject, idToken.Issuer)
 	}
 
-	return idToken, accessToken, nil
+	return idToken, accessToken, oauth2Token.RefreshToken, nil
 }
 
 // UserClaims represents the user information extracted from the ID Token.
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"clouddav/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// refreshResultReuse is how long the outcome of a refresh is reused for the same refresh token: il browser
+// invia in parallelo più richieste con i vecchi cookie, che altrimenti rinnoverebbero tutte la sessione.
+const refreshResultReuse = 30 * time.Second
+
+// sessionAEAD encrypts the session data stored in cookies (SealSessionValue), initialized by InitAzureAD.
+var sessionAEAD cipher.AEAD
+
+// claimsCookieMACKey signs the user_claims cookie (EncodeClaimsCookie), derived from the session key.
+var claimsCookieMACKey []byte
+
+// Prefissi del valore del cookie user_claims: firmato (JSON leggibile) o cifrato.
+const (
+	claimsCookieSigned    = "s."
+	claimsCookieEncrypted = "e."
+)
+
+// ErrInvalidSessionCookie is returned for a user_claims cookie that is not signed or encrypted with the
+// current session key: alterato, nel vecchio formato senza firma o emesso con un altro session_secret.
+var ErrInvalidSessionCookie = errors.New("invalid session cookie")
+
+// claimsCookiePayload is the signed or encrypted content of the user_claims cookie. IssuedAt ed ExpiresAt
+// (Unix, come iat ed exp dei JWT) sono protetti dalla firma: la scadenza del cookie nel browser non basta,
+// perché un valore copiato resterebbe valido per sempre.
+type claimsCookiePayload struct {
+	UserClaims
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// refreshCall is a refresh in progress or just completed, shared by the requests with the same refresh token.
+type refreshCall struct {
+	done         chan struct{}
+	claims       *UserClaims
+	refreshToken string
+	err          error
+}
+
+var (
+	refreshCalls   = make(map[string]*refreshCall)
+	refreshCallsMu sync.Mutex
+)
+
+// initSessionKey derives the AES-256 key of the session cookies from azure_ad.session_secret, or generates a
+// random one if the secret is empty.
+func initSessionKey(secret string) error {
+	var key []byte
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		key = sum[:]
+	} else {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("failed to generate the session key: %w", err)
+		}
+		log.Println("Warning: azure_ad.session_secret not set, using a random key: sessions end at every restart.")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("user_claims"))
+	claimsCookieMACKey = mac.Sum(nil)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("failed to create the session cipher: %w", err)
+	}
+	sessionAEAD, err = cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("failed to create the session cipher: %w", err)
+	}
+	return nil
+}
+
+// SealSessionValue encrypts and authenticates plaintext (AES-GCM) for a cookie value.
+func SealSessionValue(plaintext []byte) (string, error) {
+	if sessionAEAD == nil {
+		return "", errors.New("session key not initialized")
+	}
+	nonce := make([]byte, sessionAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(sessionAEAD.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// OpenSessionValue decrypts a value of SealSessionValue. Fallisce se il valore è stato alterato o è stato
+// cifrato con un'altra chiave (session_secret cambiato o casuale di un avvio precedente).
+func OpenSessionValue(value string) ([]byte, error) {
+	if sessionAEAD == nil {
+		return nil, errors.New("session key not initialized")
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < sessionAEAD.NonceSize() {
+		return nil, errors.New("malformed session value")
+	}
+	nonce, ciphertext := sealed[:sessionAEAD.NonceSize()], sealed[sessionAEAD.NonceSize():]
+	plaintext, err := sessionAEAD.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("invalid session value")
+	}
+	return plaintext, nil
+}
+
+// EncodeClaimsCookie returns the value of the user_claims cookie, valid until expiresAt: i claims in JSON
+// firmati con HMAC-SHA256 oppure, con encrypt, cifrati con AES-GCM così che gruppi ed email non siano
+// leggibili dal cookie.
+func EncodeClaimsCookie(claims *UserClaims, encrypt bool, expiresAt time.Time) (string, error) {
+	claimsJSON, err := json.Marshal(claimsCookiePayload{UserClaims: *claims, IssuedAt: time.Now().Unix(), ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %w", err)
+	}
+	if encrypt {
+		sealed, err := SealSessionValue(claimsJSON)
+		if err != nil {
+			return "", err
+		}
+		return claimsCookieEncrypted + sealed, nil
+	}
+	if claimsCookieMACKey == nil {
+		return "", errors.New("session key not initialized")
+	}
+	mac := hmac.New(sha256.New, claimsCookieMACKey)
+	mac.Write(claimsJSON)
+	return claimsCookieSigned + base64.RawURLEncoding.EncodeToString(claimsJSON) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// DecodeClaimsCookie verifies and decodes a value of EncodeClaimsCookie, in either format (così cambiare
+// encrypt_session_cookie non chiude le sessioni aperte), or returns ErrInvalidSessionCookie, anche per un
+// valore scaduto o senza scadenza.
+func DecodeClaimsCookie(value string) (*UserClaims, error) {
+	var claimsJSON []byte
+	switch {
+	case strings.HasPrefix(value, claimsCookieEncrypted):
+		plaintext, err := OpenSessionValue(strings.TrimPrefix(value, claimsCookieEncrypted))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSessionCookie, err)
+		}
+		claimsJSON = plaintext
+	case strings.HasPrefix(value, claimsCookieSigned) && claimsCookieMACKey != nil:
+		payload, signature, found := strings.Cut(strings.TrimPrefix(value, claimsCookieSigned), ".")
+		decodedPayload, payloadErr := base64.RawURLEncoding.DecodeString(payload)
+		decodedSignature, signatureErr := base64.RawURLEncoding.DecodeString(signature)
+		if !found || payloadErr != nil || signatureErr != nil {
+			return nil, fmt.Errorf("%w: malformed value", ErrInvalidSessionCookie)
+		}
+		mac := hmac.New(sha256.New, claimsCookieMACKey)
+		mac.Write(decodedPayload)
+		if !hmac.Equal(mac.Sum(nil), decodedSignature) {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidSessionCookie)
+		}
+		claimsJSON = decodedPayload
+	default:
+		return nil, fmt.Errorf("%w: not signed", ErrInvalidSessionCookie)
+	}
+
+	var payload claimsCookiePayload
+	if err := json.Unmarshal(claimsJSON, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSessionCookie, err)
+	}
+	if payload.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidSessionCookie)
+	}
+	if expiresAt := time.Unix(payload.ExpiresAt, 0); !time.Now().Before(expiresAt) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrInvalidSessionCookie, expiresAt.UTC().Format(time.RFC3339))
+	}
+	return &payload.UserClaims, nil
+}
+
+// RefreshSession redeems refreshToken with oauth2Config.TokenSource and returns the claims of the new ID
+// token, con i gruppi riletti da Microsoft Graph, and the refresh token to store: quello nuovo se Entra ID
+// lo ha ruotato, altrimenti refreshToken. Le richieste concorrenti con lo stesso refresh token condividono
+// un solo rinnovo.
+func RefreshSession(ctx context.Context, refreshToken string) (*UserClaims, string, error) {
+	sum := sha256.Sum256([]byte(refreshToken))
+	key := hex.EncodeToString(sum[:])
+
+	refreshCallsMu.Lock()
+	call, inFlight := refreshCalls[key]
+	if !inFlight {
+		call = &refreshCall{done: make(chan struct{})}
+		refreshCalls[key] = call
+	}
+	refreshCallsMu.Unlock()
+
+	if !inFlight {
+		// Non legato a ctx: il risultato serve anche alle richieste in attesa.
+		refreshCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		call.claims, call.refreshToken, call.err = refreshSession(refreshCtx, refreshToken)
+		cancel()
+		close(call.done)
+		time.AfterFunc(refreshResultReuse, func() {
+			refreshCallsMu.Lock()
+			delete(refreshCalls, key)
+			refreshCallsMu.Unlock()
+		})
+	}
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	if call.err != nil {
+		return nil, "", call.err
+	}
+	claims := *call.claims
+	return &claims, call.refreshToken, nil
+}
+
+func refreshSession(ctx context.Context, refreshToken string) (*UserClaims, string, error) {
+	if provider == nil {
+		return nil, "", errors.New("Azure AD authentication not initialized")
+	}
+	// Un token già scaduto forza TokenSource a usare subito il refresh token.
+	token, err := oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken, Expiry: time.Unix(1, 0)}).Token()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to refresh the OAuth2 token: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("no id_token present in the refresh response")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: oauth2Config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to verify the refreshed id_token: %w", err)
+	}
+	claims, err := GetUserClaims(idToken)
+	if err != nil {
+		return nil, "", err
+	}
+	claims.Groups, claims.GroupNames, err = GetUserGroupsFromGraph(ctx, token.AccessToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to retrieve user groups after refresh: %w", err)
+	}
+
+	if token.RefreshToken != "" {
+		refreshToken = token.RefreshToken
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("[DEBUG] RefreshSession: Session of '%s' refreshed, ID token expires in %s", claims.Email, time.Until(idToken.Expiry).Round(time.Second))
+	}
+	return claims, refreshToken, nil
+}
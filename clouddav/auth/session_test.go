@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClaimsCookie(t *testing.T) {
+	if err := initSessionKey("test-secret"); err != nil {
+		t.Fatal(err)
+	}
+	claims := &UserClaims{Subject: "sub", Email: "user@example.com", Groups: []string{"g1"}, GroupNames: []string{"editors"}}
+
+	// signedPayload firma un payload arbitrario come EncodeClaimsCookie, per simulare il formato precedente.
+	signedPayload := func(payload any) string {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac := hmac.New(sha256.New, claimsCookieMACKey)
+		mac.Write(data)
+		return claimsCookieSigned + base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	encode := func(encrypt bool, expiresAt time.Time) string {
+		value, err := EncodeClaimsCookie(claims, encrypt, expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	tamper := func(value string) string {
+		payload, signature, _ := strings.Cut(strings.TrimPrefix(value, claimsCookieSigned), ".")
+		data, _ := base64.RawURLEncoding.DecodeString(payload)
+		data = []byte(strings.Replace(string(data), "editors", "admins", 1))
+		return claimsCookieSigned + base64.RawURLEncoding.EncodeToString(data) + "." + signature
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"signed", encode(false, time.Now().Add(time.Hour)), true},
+		{"encrypted", encode(true, time.Now().Add(time.Hour)), true},
+		{"signed and expired", encode(false, time.Now().Add(-time.Second)), false},
+		{"encrypted and expired", encode(true, time.Now().Add(-time.Second)), false},
+		{"signed without expiry", signedPayload(claims), false},
+		{"tampered groups", tamper(encode(false, time.Now().Add(time.Hour))), false},
+		{"unsigned", "%7B%22email%22%3A%22user%40example.com%22%7D", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeClaimsCookie(tt.value)
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidSessionCookie) {
+					t.Errorf("DecodeClaimsCookie = %+v, %v; want ErrInvalidSessionCookie", decoded, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeClaimsCookie: %v", err)
+			}
+			if decoded.Email != claims.Email || len(decoded.GroupNames) != 1 || decoded.GroupNames[0] != "editors" {
+				t.Errorf("DecodeClaimsCookie = %+v, want %+v", decoded, claims)
+			}
+		})
+	}
+}
@@ -3,10 +3,18 @@ package config
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os" // MODIFICA: Aggiunto import per os.ReadFile
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"clouddav/internal/logging"
+
 	"gopkg.in/yaml.v2"
 )
 
@@ -27,6 +35,21 @@ type Config struct {
 		ClientSecret  string   `yaml:"client_secret" json:"client_secret"`
 		RedirectURL   string   `yaml:"redirect_url" json:"redirect_url"`
 		AllowedGroups []string `yaml:"allowed_groups" json:"allowed_groups"`
+		// SessionSecret è la chiave con cui vengono firmati e cifrati i cookie di sessione (claims e refresh
+		// token); se vuota se ne genera una casuale all'avvio e ogni riavvio chiude le sessioni.
+		SessionSecret string `yaml:"session_secret" json:"-"`
+		// SessionRefreshLifetime è la durata del cookie con il refresh token, entro cui la sessione di 24h
+		// viene rinnovata senza un nuovo login interattivo ("0" = nessun rinnovo).
+		SessionRefreshLifetime string `yaml:"session_refresh_lifetime" json:"session_refresh_lifetime"`
+		// EncryptSessionCookie cifra con AES-GCM il cookie user_claims, altrimenti solo firmato (HMAC-SHA256):
+		// email e gruppi non sono leggibili da chi ottiene il cookie.
+		EncryptSessionCookie bool `yaml:"encrypt_session_cookie" json:"encrypt_session_cookie"`
+		// FederatedLogout fa terminare a /auth/logout anche la sessione di Microsoft Entra ID (end_session_endpoint),
+		// così su un computer condiviso il login successivo richiede di nuovo le credenziali.
+		FederatedLogout bool `yaml:"federated_logout" json:"federated_logout"`
+		// PostLogoutRedirectURL è la pagina a cui Entra ID rimanda dopo il logout federato (va registrata
+		// nell'app); se vuota si usa la radice dell'applicazione ricavata da redirect_url.
+		PostLogoutRedirectURL string `yaml:"post_logout_redirect_url" json:"post_logout_redirect_url"`
 	} `yaml:"azure_ad" json:"azure_ad"`
 	GlobalAdminGroups []string        `yaml:"global_admin_groups" json:"global_admin_groups"`
 	Storages          []StorageConfig `yaml:"storages" json:"storages"`
@@ -34,7 +57,51 @@ type Config struct {
 	Timeouts          TimeoutConfig    `yaml:"timeouts" json:"timeouts"`
 	ClientPingIntervalMs int `yaml:"client_ping_interval_ms" json:"client_ping_interval_ms"`
 	LogLevel             string `yaml:"log_level" json:"log_level"`
+	// LogFormat è il formato dei log applicativi: "text" (default) o "json" (una riga JSON per messaggio,
+	// per Loki & co.). Letto solo all'avvio.
+	LogFormat            string `yaml:"log_format" json:"log_format"`
 	UploadCleanupTimeout string `yaml:"upload_cleanup_timeout" json:"upload_cleanup_timeout"`
+	// ShutdownUploadGrace è il tempo concesso allo shutdown agli upload in corso per completarsi, rifiutando
+	// quelli nuovi, prima di fermare il server e annullarli ("0s" = nessuna attesa).
+	ShutdownUploadGrace string              `yaml:"shutdown_upload_grace" json:"shutdown_upload_grace"`
+	AccessLog           AccessLogConfig     `yaml:"access_log" json:"access_log"`
+	RecentErrors        RecentErrorsConfig  `yaml:"recent_errors" json:"recent_errors"`
+	Notifications       NotificationsConfig `yaml:"notifications" json:"notifications"`
+	UploadTemp          UploadTempConfig    `yaml:"upload_temp" json:"upload_temp"`
+	ConfigReload        ConfigReloadConfig  `yaml:"config_reload" json:"config_reload"`
+	// ChangeLog configura il registro delle modifiche usato da list_directory con since_seq. Letto solo all'avvio.
+	ChangeLog          ChangeLogConfig          `yaml:"change_log" json:"change_log"`
+	Thumbnails         ThumbnailConfig          `yaml:"thumbnails" json:"thumbnails"`
+	ServerStatus       ServerStatusConfig       `yaml:"server_status" json:"server_status"`
+	LongPolling        LongPollingConfig        `yaml:"long_polling" json:"long_polling"`
+	ContentDisposition ContentDispositionConfig `yaml:"content_disposition" json:"content_disposition"`
+	Metrics            MetricsConfig            `yaml:"metrics" json:"metrics"`
+	UploadAutoRename   UploadAutoRenameConfig   `yaml:"upload_auto_rename" json:"upload_auto_rename"`
+	DirectoryIndex     DirectoryIndexConfig     `yaml:"directory_index" json:"directory_index"`
+	WebDAV             WebDAVConfig             `yaml:"webdav" json:"webdav"`
+	ZipDownload        ZipDownloadConfig        `yaml:"zip_download" json:"zip_download"`
+	// DownloadStreamError sceglie come /download segnala un errore dello storage a risposta già iniziata:
+	// "abort" (default) chiude la connessione prima della fine del Content-Length, "trailer" omette il
+	// Content-Length e riporta l'errore nel trailer X-Download-Error.
+	DownloadStreamError string `yaml:"download_stream_error" json:"download_stream_error"`
+	// APITokens associa i token statici (Authorization: Bearer <token>) all'identità con cui vengono
+	// autorizzati, per script e job CI che non possono fare il login Entra ID. Valgono solo con enable_auth.
+	APITokens           map[string]APITokenUser `yaml:"api_tokens" json:"-"`
+	GlobalDeleteWorkers int                     `yaml:"global_delete_workers" json:"global_delete_workers"` // Goroutine di cancellazione concorrenti in tutto il server (default NumCPU*8, letto solo all'avvio)
+	// MaxConcurrentExports limita gli export di directory (/download-zip) in corso in tutto il server; oltre
+	// il limite la risposta è 503 con Retry-After. Default NumCPU, applicato anche al reload della configurazione.
+	MaxConcurrentExports int `yaml:"max_concurrent_exports" json:"max_concurrent_exports"`
+	// MaxUploadBytes è la dimensione massima di un file caricato, verificata all'initiate e sui byte ricevuti
+	// dai chunk (0 = nessun limite). Gli storage possono sovrascriverla con il proprio max_upload_bytes.
+	MaxUploadBytes int64 `yaml:"max_upload_bytes" json:"max_upload_bytes"`
+	// RateLimitPerMinute limita le richieste HTTP e i messaggi WebSocket/long polling di ciascun utente
+	// (token bucket per email, "anonymous" senza autenticazione); oltre il limite 429 o errore RATE_LIMITED.
+	// 0 = nessun limite. RateLimitBurst è la raffica ammessa a bucket pieno (default = RateLimitPerMinute).
+	RateLimitPerMinute int `yaml:"rate_limit_per_minute" json:"rate_limit_per_minute"`
+	RateLimitBurst     int `yaml:"rate_limit_burst" json:"rate_limit_burst"`
+	// AllowedOrigins sono le origini (es. "https://files.example.com") da cui un browser può aprire il
+	// WebSocket; "*" le accetta tutte. Vuota = stesso host se enable_auth è true, qualsiasi origine altrimenti.
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"`
 }
 
 // StorageConfig ... (come prima)
@@ -43,12 +110,65 @@ type StorageConfig struct {
 	Type                   string       `yaml:"type" json:"type"`
 	FilesystemConfig       `yaml:",inline" json:",inline"`
 	AzureBlobStorageConfig `yaml:",inline" json:",inline"`
+	CommandStorageConfig   `yaml:",inline" json:",inline"`
+	GCSStorageConfig       `yaml:",inline" json:",inline"`
 	Permissions            []Permission `yaml:"permissions" json:"permissions"`
+	StoreChecksums         bool         `yaml:"store_checksums" json:"store_checksums"` // Salva lo SHA256 verificato (sidecar locale o metadata Azure)
+	// RecordUploader salva chi ha caricato il file e quando (sidecar locale, metadata Azure o in memoria),
+	// restituiti da get_item_metadata; non altera il contenuto né lo SHA256 del file.
+	RecordUploader       bool                   `yaml:"record_uploader,omitempty" json:"record_uploader,omitempty"`
+	StrictUploadSize     bool                   `yaml:"strict_upload_size" json:"strict_upload_size"` // Rifiuta gli upload i cui byte ricevuti non corrispondono alla dimensione dichiarata
+	DownloadChecksums    DownloadChecksumConfig `yaml:"download_checksums" json:"download_checksums"`
+	UploadCleanupTimeout string                 `yaml:"upload_cleanup_timeout,omitempty" json:"upload_cleanup_timeout,omitempty"` // Sovrascrive upload_cleanup_timeout globale per questo storage
+	QuotaBytes           int64                  `yaml:"quota_bytes,omitempty" json:"quota_bytes,omitempty"`                       // Spazio massimo occupato dallo storage, verificato all'initiate degli upload (0 = nessun limite)
+	// MaxUploadBytes sovrascrive max_upload_bytes globale per questo storage (0 = globale, -1 = nessun limite).
+	// Dopo ReadConfig contiene il limite effettivo: 0 se non c'è limite.
+	MaxUploadBytes int64 `yaml:"max_upload_bytes,omitempty" json:"max_upload_bytes,omitempty"`
+	// MaxConcurrentOperations limita le operazioni sul backend in corso contemporaneamente (list, letture,
+	// scritture, cancellazioni, upload), per pilotare ogni backend alla sua concorrenza ottimale (0 = nessun limite).
+	// Le operazioni oltre il limite attendono uno slot fino a OperationQueueTimeout (default 10s), poi falliscono con 503.
+	MaxConcurrentOperations int    `yaml:"max_concurrent_operations,omitempty" json:"max_concurrent_operations,omitempty"`
+	OperationQueueTimeout   string `yaml:"operation_queue_timeout,omitempty" json:"operation_queue_timeout,omitempty"`
+	// NormalizeBackslashes converte i backslash in "/" nei path ricevuti dai client (client Windows, rclone).
+	// Opzionale perché su Linux un nome di file può contenere legittimamente un backslash.
+	NormalizeBackslashes bool `yaml:"normalize_backslashes,omitempty" json:"normalize_backslashes,omitempty"`
+	// KeepTrailingSlashes conserva la barra finale dei path ricevuti, che di default viene rimossa
+	// (le barre ripetute vengono comunque compresse); per i client che la usano per distinguere le directory.
+	KeepTrailingSlashes bool `yaml:"keep_trailing_slashes,omitempty" json:"keep_trailing_slashes,omitempty"`
+	// LogLevel sovrascrive log_level globale per i log di questo storage ("DEBUG" o "INFO"; vuoto = globale),
+	// per fare debug di un solo backend senza attivare DEBUG su tutti.
+	LogLevel string `yaml:"log_level,omitempty" json:"log_level,omitempty"`
+}
+
+// DownloadChecksumConfig controls the checksum headers (Content-MD5, X-Checksum-SHA256) set on downloads.
+// Lo SHA256 salvato (store_checksums) viene usato senza rileggere il file; altrimenti i file fino a
+// MaxComputeSizeMB vengono letti in memoria per calcolare gli hash prima di inviarli, il che ritarda il
+// primo byte e occupa memoria pari alla dimensione del file. I file più grandi vengono inviati senza header.
+type DownloadChecksumConfig struct {
+	Enabled          bool `yaml:"enabled" json:"enabled"`
+	MaxComputeSizeMB int  `yaml:"max_compute_size_mb" json:"max_compute_size_mb"` // 0 = default 16, negativo = solo checksum salvati
 }
 
+// MaxUploadWriters is the highest upload_writers accepted for a local storage.
+const MaxUploadWriters = 64
+
 // FilesystemConfig ... (come prima)
 type FilesystemConfig struct {
 	Path string `yaml:"path" json:"path"`
+	// UploadTempDir è la directory dei file temporanei di upload, riservata al server; vuota = la directory
+	// nascosta .clouddav-uploads sotto la radice dello storage.
+	UploadTempDir string `yaml:"upload_temp_dir,omitempty" json:"upload_temp_dir,omitempty"`
+	// UploadWriters è il numero di goroutine che scrivono in parallelo i chunk di ogni upload (default 1):
+	// valori più alti servono su dischi veloci (NVMe) con client che inviano chunk in parallelo.
+	UploadWriters int `yaml:"upload_writers,omitempty" json:"upload_writers,omitempty"`
+	// FollowSymlinks serve il target dei link simbolici. Se false i link sono elencati come tali
+	// (is_symlink, link_target) e non vengono seguiti in lettura, né come file né come directory.
+	FollowSymlinks bool `yaml:"follow_symlinks,omitempty" json:"follow_symlinks,omitempty"`
+	// TrashDir attiva il cestino: DeleteItem sposta gli elementi in una sottodirectory con timestamp invece di
+	// cancellarli. Deve stare fuori da Path e sullo stesso filesystem (lo spostamento è un rename).
+	TrashDir string `yaml:"trash_dir,omitempty" json:"trash_dir,omitempty"`
+	// TrashRetention è dopo quanto gli elementi nel cestino vengono eliminati definitivamente (default 168h, "0s" = mai).
+	TrashRetention string `yaml:"trash_retention,omitempty" json:"trash_retention,omitempty"`
 }
 
 // AzureBlobStorageConfig ... (come prima)
@@ -56,12 +176,127 @@ type AzureBlobStorageConfig struct {
 	ConnectionString string `yaml:"connection_string,omitempty" json:"connection_string,omitempty"`
 	AccountName      string `yaml:"account_name,omitempty" json:"account_name,omitempty"`
 	ContainerName    string `yaml:"container_name" json:"container_name"`
+	// SASURL è l'URL SAS di un container (https://<account>.blob.core.windows.net/<container>?sv=...), per
+	// i deployment che dispongono solo di quello; container_name, se vuoto, viene ricavato dall'URL.
+	SASURL string `yaml:"sas_url,omitempty" json:"-"`
+	// SASToken è un token SAS (la query string, con o senza "?") usato con account_name e container_name.
+	SASToken string `yaml:"sas_token,omitempty" json:"-"`
+	// DownloadBlockSizeMB è la dimensione dei range letti nei download a blocchi (default 4 MB).
+	DownloadBlockSizeMB int `yaml:"download_block_size_mb,omitempty" json:"download_block_size_mb,omitempty"`
+	// IncrementalUploadHash calcola lo SHA256 durante lo staging dei blocchi (chunk in ordine), evitando di
+	// riscaricare il blob al finalize; con chunk fuori ordine si torna alla verifica con download.
+	IncrementalUploadHash bool `yaml:"incremental_upload_hash,omitempty" json:"incremental_upload_hash,omitempty"`
+	// DirectoryMarkers decide come copy/move di una directory virtuale trattano i blob marker ("dir/") a destinazione:
+	// DirectoryMarkersPreserve (default), DirectoryMarkersRecreate o DirectoryMarkersImplicit.
+	DirectoryMarkers string `yaml:"directory_markers,omitempty" json:"directory_markers,omitempty"`
+	// DirectoryModTime ricava la data di modifica delle directory virtuali dal blob più recente sotto il prefisso
+	// (un listing in più per directory); senza, la data è sconosciuta e viene serializzata come null.
+	DirectoryModTime bool `yaml:"directory_mod_time,omitempty" json:"directory_mod_time,omitempty"`
+}
+
+// SASContainerURL returns the URL of the container with the SAS of sas_url or sas_token, or "" if the
+// storage doesn't authenticate with a SAS.
+func (c AzureBlobStorageConfig) SASContainerURL() (string, error) {
+	switch {
+	case c.SASURL != "":
+		if _, err := sasURLContainerName(c.SASURL); err != nil {
+			return "", err
+		}
+		return c.SASURL, nil
+	case c.SASToken != "":
+		if c.AccountName == "" {
+			return "", fmt.Errorf("sas_token requires account_name")
+		}
+		return fmt.Sprintf("https://%s.blob.core.windows.net/%s?%s", c.AccountName, url.PathEscape(c.ContainerName), strings.TrimPrefix(c.SASToken, "?")), nil
+	default:
+		return "", nil
+	}
+}
+
+// sasURLContainerName returns the container of a container SAS URL: l'ultimo segmento del path, così
+// funzionano anche gli URL path-style di Azurite (http://127.0.0.1:10000/devstoreaccount1/<container>).
+func sasURLContainerName(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err // Senza l'URL, che contiene il token
+		}
+		return "", fmt.Errorf("invalid sas_url: %w", err)
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" || parsed.Host == "" {
+		return "", fmt.Errorf("sas_url must be an http(s) URL of a container")
+	}
+	if parsed.RawQuery == "" {
+		return "", fmt.Errorf("sas_url has no SAS token in its query string")
+	}
+	containerName := path.Base(path.Clean("/" + parsed.Path))
+	if containerName == "/" {
+		return "", fmt.Errorf("sas_url must include the container in its path")
+	}
+	return containerName, nil
+}
+
+// Valori di directory_markers degli storage azure-blob. In ogni modalità un move cancella i marker della sorgente.
+const (
+	DirectoryMarkersPreserve = "preserve" // Copia i marker esistenti, le directory implicite restano implicite
+	DirectoryMarkersRecreate = "recreate" // Crea un marker per ogni directory copiata, anche se era implicita
+	DirectoryMarkersImplicit = "implicit" // Non copia i marker, tranne quelli delle directory vuote che altrimenti sparirebbero
+)
+
+// GCSStorageConfig configures a Google Cloud Storage bucket (type "gcs").
+type GCSStorageConfig struct {
+	Bucket string `yaml:"bucket,omitempty" json:"bucket,omitempty"`
+	// CredentialsFile è il file JSON di un service account (o authorized_user); se vuoto si usano
+	// GOOGLE_APPLICATION_CREDENTIALS o il metadata server, come per le Application Default Credentials.
+	CredentialsFile string `yaml:"credentials_file,omitempty" json:"-"`
+}
+
+// ConfigReloadConfig controls how connected clients are updated when the configuration is reloaded
+// (websocket.Hub.ReevaluateClientAccess).
+type ConfigReloadConfig struct {
+	NotifyClients          bool `yaml:"notify_clients" json:"notify_clients"`                       // Invia permissions_changed ai client il cui insieme di storage accessibili è cambiato
+	DisconnectOnAccessLoss bool `yaml:"disconnect_on_access_loss" json:"disconnect_on_access_loss"` // Disconnette i client che non hanno più accesso ad alcuno storage
+}
+
+// ChangeLogConfig configures the per-storage change log of the incremental listings (list_directory con
+// since_seq, storage.ChangeLog).
+type ChangeLogConfig struct {
+	// Dir è la directory in cui viene persistito il log di ogni storage (<nome>.changes.jsonl), così i
+	// cursori restano validi dopo un riavvio; vuota = solo in memoria.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	// MaxEntries è il numero di modifiche conservate per storage (default 10000): con un cursore più vecchio
+	// il client riceve l'elenco completo.
+	MaxEntries int `yaml:"max_entries,omitempty" json:"max_entries,omitempty"`
+}
+
+// CommandStorageConfig configures the "command" provider, which delegates every operation to external
+// commands. Ogni comando è un argv (programma e argomenti) eseguito senza shell; la richiesta viene
+// passata come JSON su stdin, mai come argomento. list e get sono obbligatori, gli altri opzionali.
+type CommandStorageConfig struct {
+	Commands        CommandSet `yaml:"commands,omitempty" json:"commands,omitempty"`
+	CommandTimeout  string     `yaml:"command_timeout,omitempty" json:"command_timeout,omitempty"`   // Timeout di list/stat/delete/mkdir (default 30s)
+	TransferTimeout string     `yaml:"transfer_timeout,omitempty" json:"transfer_timeout,omitempty"` // Timeout di get/put/move/copy (default 1h)
+}
+
+// CommandSet holds the command of each operation supported by the "command" provider.
+type CommandSet struct {
+	List   []string `yaml:"list,omitempty" json:"list,omitempty"`
+	Stat   []string `yaml:"stat,omitempty" json:"stat,omitempty"` // Se assente, GetItem usa list sulla directory padre
+	Get    []string `yaml:"get,omitempty" json:"get,omitempty"`
+	Put    []string `yaml:"put,omitempty" json:"put,omitempty"`
+	Delete []string `yaml:"delete,omitempty" json:"delete,omitempty"`
+	Mkdir  []string `yaml:"mkdir,omitempty" json:"mkdir,omitempty"`
+	Move   []string `yaml:"move,omitempty" json:"move,omitempty"`
+	Copy   []string `yaml:"copy,omitempty" json:"copy,omitempty"`
 }
 
 // Permission ... (come prima)
 type Permission struct {
 	GroupID string `yaml:"group_id" json:"group_id"` // Adesso si assume sia un nome di gruppo
 	Access  string `yaml:"access" json:"access"`
+	// PathPrefix limita il permesso a un sottoalbero dello storage (es. "projects/"); vuoto = tutto lo storage.
+	// Per un path vale il prefisso più lungo tra i permessi dei gruppi dell'utente.
+	PathPrefix string `yaml:"path_prefix,omitempty" json:"path_prefix,omitempty"`
 }
 
 // PaginationConfig ... (come prima)
@@ -74,59 +309,379 @@ type TimeoutConfig struct {
 	ReadTimeout  string `yaml:"read_timeout" json:"read_timeout"`
 	WriteTimeout string `yaml:"write_timeout" json:"write_timeout"`
 	IdleTimeout  string `yaml:"idle_timeout" json:"idle_timeout"`
+	// ProviderInitTimeout limita l'inizializzazione di ogni storage provider all'avvio
+	// (es. acquisizione delle credenziali Azure), così un backend irraggiungibile non blocca lo startup.
+	ProviderInitTimeout string `yaml:"provider_init_timeout" json:"provider_init_timeout"`
+	// Timeout delle azioni di /upload, applicati al contesto della richiesta dopo la lettura del form:
+	// il finalize può richiedere molto più dell'initiate (es. Azure riscarica il blob per calcolarne lo SHA256).
+	UploadInitiateTimeout string `yaml:"upload_initiate_timeout" json:"upload_initiate_timeout"` // Anche per cancel
+	UploadChunkTimeout    string `yaml:"upload_chunk_timeout" json:"upload_chunk_timeout"`
+	UploadFinalizeTimeout string `yaml:"upload_finalize_timeout" json:"upload_finalize_timeout"`
+	// ReadinessCheckTimeout limita i controlli degli storage di /readyz (in parallelo): va tenuto sotto il
+	// timeoutSeconds della readinessProbe di Kubernetes.
+	ReadinessCheckTimeout string `yaml:"readiness_check_timeout" json:"readiness_check_timeout"`
+}
+
+// UploadTempConfig controls the retention of the temporary files of local uploads.
+// I file upload-*.tmp della directory dei temporanei (upload_temp_dir o .clouddav-uploads) non associati
+// a una sessione attiva e più vecchi di MaxAge vengono rimossi
+// periodicamente; se lo spazio totale supera MaxTotalSizeMB viene loggato un warning.
+type UploadTempConfig struct {
+	MaxAge         string `yaml:"max_age" json:"max_age"`
+	CheckInterval  string `yaml:"check_interval" json:"check_interval"`
+	MaxTotalSizeMB int64  `yaml:"max_total_size_mb" json:"max_total_size_mb"` // 0 = nessun controllo
+	// SessionStateFile è il file JSON in cui vengono salvate le sessioni di upload locali, così un riavvio
+	// non le abbandona; all'avvio vengono rimossi i file temporanei delle sessioni salvate non ripristinabili.
+	// Vuoto = disabilitato.
+	// Letto solo all'avvio.
+	SessionStateFile string `yaml:"session_state_file" json:"-"`
+}
+
+// ThumbnailConfig controls the /thumbnail endpoint. Le immagini (JPEG, PNG, GIF) sono elaborate
+// internamente; PDF e video richiedono strumenti esterni
+// (pdftoppm, ffmpeg): se il comando non è configurato o non è nel PATH l'endpoint risponde 501.
+type ThumbnailConfig struct {
+	PDFRenderer     string `yaml:"pdf_renderer" json:"pdf_renderer"`     // es. "pdftoppm"
+	VideoRenderer   string `yaml:"video_renderer" json:"video_renderer"` // es. "ffmpeg"
+	MaxSourceSizeMB int64  `yaml:"max_source_size_mb" json:"max_source_size_mb"`
+	Timeout         string `yaml:"timeout" json:"timeout"`     // Tempo massimo di generazione di una thumbnail
+	CacheDir        string `yaml:"cache_dir" json:"cache_dir"` // Vuoto = <temp dir di sistema>/clouddav-thumbnails
+	DefaultSize     int    `yaml:"default_size" json:"default_size"`
+	MaxSize         int    `yaml:"max_size" json:"max_size"`
+	// MaxConcurrentTools limita i processi pdftoppm/ffmpeg in esecuzione insieme; un reload vale per i nuovi processi.
+	MaxConcurrentTools int `yaml:"max_concurrent_tools" json:"max_concurrent_tools"`
+	// CacheMaxSizeMB e CacheMaxAge limitano la cache: oltre la dimensione vengono rimosse le thumbnail usate
+	// meno di recente, oltre l'età quelle non più richieste ("0" = nessun limite di età).
+	CacheMaxSizeMB int64  `yaml:"cache_max_size_mb" json:"cache_max_size_mb"`
+	CacheMaxAge    string `yaml:"cache_max_age" json:"cache_max_age"`
+}
+
+// AccessLogConfig controls the per-request HTTP access log.
+type AccessLogConfig struct {
+	Enabled            bool   `yaml:"enabled" json:"enabled"`
+	Format             string `yaml:"format" json:"format"`                             // "text" (default) o "json"
+	LogStaticEndpoints bool   `yaml:"log_static_endpoints" json:"log_static_endpoints"` // Se true, logga anche file statici e endpoint di health
+}
+
+// ServerStatusConfig sets the limits against which server_status computes the load indicator.
+type ServerStatusConfig struct {
+	MaxInFlightRequests int `yaml:"max_in_flight_requests" json:"max_in_flight_requests"` // Messaggi WS/LP in elaborazione oltre i quali il server è "overloaded"
+	MaxOngoingUploads   int `yaml:"max_ongoing_uploads" json:"max_ongoing_uploads"`       // 0 = gli upload in corso non contano nel carico
+}
+
+// LongPollingConfig sets the polling cadence suggested to long polling clients (next_poll_after_ms).
+// Con il server "busy" o "overloaded" (server_status) l'intervallo viene raddoppiato o quadruplicato,
+// fino a MaxPollIntervalMs.
+type LongPollingConfig struct {
+	PollIntervalMs    int `yaml:"poll_interval_ms" json:"poll_interval_ms"`
+	MaxPollIntervalMs int `yaml:"max_poll_interval_ms" json:"max_poll_interval_ms"`
+}
+
+// MetricsConfig controls the Prometheus /metrics endpoint.
+type MetricsConfig struct {
+	Enabled      bool `yaml:"enabled" json:"enabled"`
+	RequireAdmin bool `yaml:"require_admin" json:"require_admin"` // Richiede l'autenticazione e l'appartenenza a global_admin_groups
+}
+
+// ContentDispositionConfig chooses whether downloads are shown in the browser ("inline") or saved
+// ("attachment"), by file extension. I parametri ?inline e ?download della richiesta sovrascrivono la scelta,
+// ma i tipi sconosciuti e quelli in ForceAttachmentTypes vengono sempre scaricati come allegato.
+type ContentDispositionConfig struct {
+	Extensions           map[string]string `yaml:"extensions" json:"extensions"`                         // es. ".pdf": "inline"; le estensioni non elencate sono "attachment"
+	ForceAttachmentTypes []string          `yaml:"force_attachment_types" json:"force_attachment_types"` // Tipi MIME o estensioni mai serviti inline (es. HTML e SVG, che possono eseguire script)
+}
+
+// UploadAutoRenameConfig controls how an upload initiated with auto_rename picks a free name when the
+// target already exists. Pattern usa i segnaposto {name} (nome senza estensione), {n} (numero progressivo
+// da 1) e {ext} (estensione con il punto, eventualmente vuota).
+type UploadAutoRenameConfig struct {
+	Pattern     string `yaml:"pattern" json:"pattern"`           // Default "{name} ({n}){ext}", es. "report (1).pdf"
+	MaxAttempts int    `yaml:"max_attempts" json:"max_attempts"` // Nomi provati prima di rinunciare con 409 (default 100)
+}
+
+// Candidate returns the n-th alternative for itemPath according to Pattern (n = 0 is itemPath itself).
+// Usato anche da move_item e copy_item con conflict_strategy "rename".
+func (c UploadAutoRenameConfig) Candidate(itemPath string, n int) string {
+	if n == 0 {
+		return itemPath
+	}
+	dir, base := path.Split(itemPath)
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	if name == "" { // File nascosti (".env"): l'intero nome è la base, non un'estensione
+		name, ext = base, ""
+	}
+	return dir + strings.NewReplacer("{name}", name, "{n}", strconv.Itoa(n), "{ext}", ext).Replace(c.Pattern)
+}
+
+// DirectoryIndexConfig controls the /index endpoint, a static HTML (or JSON) listing of a directory with
+// download links, browsable without the web app. Rispetta le stesse autorizzazioni di list_directory.
+type DirectoryIndexConfig struct {
+	Enabled         bool   `yaml:"enabled" json:"enabled"`
+	Template        string `yaml:"template" json:"-"`                            // File html/template alternativo; vuoto = pagina predefinita
+	ItemsPerPage    int    `yaml:"items_per_page" json:"items_per_page"`         // 0 = pagination.items_per_page
+	MaxItemsPerPage int    `yaml:"max_items_per_page" json:"max_items_per_page"` // Limite di ?per_page (default 1000)
+}
+
+// WebDAVConfig controls the /webdav/ endpoint, which exposes the storages to WebDAV clients (es. per montarli
+// come unità di rete). Il primo segmento del path è il nome dello storage; valgono le stesse autorizzazioni
+// dell'applicazione web.
+type WebDAVConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Users sono le credenziali HTTP Basic per i client che non possono fare il login Entra ID (con enable_auth).
+	Users []WebDAVUser `yaml:"users" json:"-"`
+}
+
+// WebDAVUser is an HTTP Basic account of the WebDAV endpoint. Groups sono nomi di gruppo, confrontati con
+// allowed_groups, global_admin_groups e i permessi degli storage come i gruppi degli utenti Entra ID.
+type WebDAVUser struct {
+	Username     string   `yaml:"username" json:"username"`
+	PasswordHash string   `yaml:"password_hash" json:"-"` // Hash bcrypt (es. htpasswd -nbB utente password)
+	Email        string   `yaml:"email" json:"email"`     // Vuoto = username
+	Groups       []string `yaml:"groups" json:"groups"`
+}
+
+// ZipDownloadConfig controls the compression of the archives of /download-zip. Il parametro
+// ?compression_level della richiesta sovrascrive CompressionLevel.
+type ZipDownloadConfig struct {
+	// CompressionLevel va da 0 (nessuna compressione, il più veloce: adatto a contenuti già compressi) a 9
+	// (massima compressione); assente = 6, il default di deflate.
+	CompressionLevel *int `yaml:"compression_level" json:"compression_level"`
+	// StoreExtensions sono le estensioni dei file già compressi, salvati senza compressione a qualsiasi
+	// livello; lo stesso vale per immagini, audio e video riconosciuti dal tipo MIME.
+	StoreExtensions []string `yaml:"store_extensions" json:"store_extensions"`
+}
+
+// APITokenUser is the identity of a static API token. Groups sono nomi di gruppo, confrontati con
+// allowed_groups, global_admin_groups e i permessi degli storage come per gli utenti webdav.users.
+type APITokenUser struct {
+	Email  string   `yaml:"email" json:"email"`
+	Groups []string `yaml:"groups" json:"groups"`
+}
+
+// MinAPITokenLength is the minimum length of the tokens of api_tokens.
+const MinAPITokenLength = 32
+
+// RecentErrorsConfig limits the per-user buffer of recent failed operations (my_recent_errors).
+type RecentErrorsConfig struct {
+	MaxPerUser int    `yaml:"max_per_user" json:"max_per_user"`
+	MaxAge     string `yaml:"max_age" json:"max_age"`
+}
+
+// NotificationsConfig bounds the per-user notification inbox (get_notifications): le notifiche non lette
+// oltre MaxPerUser o più vecchie di MaxAge vengono scartate.
+type NotificationsConfig struct {
+	MaxPerUser int    `yaml:"max_per_user" json:"max_per_user"`
+	MaxAge     string `yaml:"max_age" json:"max_age"`
 }
 
+const (
+	DispositionInline     = "inline"
+	DispositionAttachment = "attachment"
+)
+
+const (
+	DownloadStreamErrorAbort   = "abort"
+	DownloadStreamErrorTrailer = "trailer"
+)
+
+const (
+	AccessLogFormatText = "text"
+	AccessLogFormatJSON = "json"
+)
+
+// AppConfig è la configurazione caricata all'avvio; dopo un reload quella in uso è websocket.Hub.Config().
 var AppConfig Config
-var CurrentLogLevel LogLevel = LogLevelInfo
 
-// LoadConfig loads the configuration from the specified file.
-func LoadConfig(filename string) error {
+// currentLogLevel contiene il LogLevel in uso; atomico perché un reload lo cambia mentre le richieste lo leggono.
+var currentLogLevel atomic.Value
+
+func init() {
+	currentLogLevel.Store(LogLevelInfo)
+}
+
+// ReadConfig reads, completes with the defaults and validates the configuration in the specified file,
+// without applying it. Usata da LoadConfig all'avvio e dal reload, che deve poter scartare una
+// configurazione non valida continuando con quella in uso.
+func ReadConfig(filename string) (*Config, error) {
+	cfg := &Config{}
 	// MODIFICA: Sostituito ioutil.ReadFile con os.ReadFile
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		log.Printf("Error reading configuration file %s: %v", filename, err)
-		return fmt.Errorf("error reading configuration file %s: %w", filename, err)
+		return nil, fmt.Errorf("error reading configuration file %s: %w", filename, err)
 	}
 
-	err = yaml.Unmarshal(data, &AppConfig)
+	err = yaml.Unmarshal(data, cfg)
 	if err != nil {
 		log.Printf("Error parsing configuration file %s: %v", filename, err)
-		return fmt.Errorf("error parsing configuration file %s: %w", filename, err)
+		return nil, fmt.Errorf("error parsing configuration file %s: %w", filename, err)
 	}
 
-	if AppConfig.Pagination.ItemsPerPage <= 0 {
-		AppConfig.Pagination.ItemsPerPage = 50
+	if cfg.Pagination.ItemsPerPage <= 0 {
+		cfg.Pagination.ItemsPerPage = 50
+	}
+	if cfg.Timeouts.ReadTimeout == "" {
+		cfg.Timeouts.ReadTimeout = "5s"
 	}
-	if AppConfig.Timeouts.ReadTimeout == "" {
-		AppConfig.Timeouts.ReadTimeout = "5s"
+	if cfg.Timeouts.WriteTimeout == "" { // "" significa usa default di Go, "0s" per nessun timeout
+		cfg.Timeouts.WriteTimeout = "0s" // Default a nessun timeout esplicito
 	}
-	if AppConfig.Timeouts.WriteTimeout == "" { // "" significa usa default di Go, "0s" per nessun timeout
-		AppConfig.Timeouts.WriteTimeout = "0s" // Default a nessun timeout esplicito
+	if cfg.Timeouts.IdleTimeout == "" {
+		cfg.Timeouts.IdleTimeout = "120s"
 	}
-	if AppConfig.Timeouts.IdleTimeout == "" {
-		AppConfig.Timeouts.IdleTimeout = "120s"
+	if cfg.Timeouts.ProviderInitTimeout == "" {
+		cfg.Timeouts.ProviderInitTimeout = "30s"
 	}
-	if AppConfig.ClientPingIntervalMs <= 0 {
-		AppConfig.ClientPingIntervalMs = 10000
+	if cfg.Timeouts.UploadInitiateTimeout == "" {
+		cfg.Timeouts.UploadInitiateTimeout = "30s"
 	}
-	if AppConfig.UploadCleanupTimeout == "" {
-		AppConfig.UploadCleanupTimeout = "10m"
+	if cfg.Timeouts.UploadChunkTimeout == "" {
+		cfg.Timeouts.UploadChunkTimeout = "5m"
+	}
+	if cfg.Timeouts.UploadFinalizeTimeout == "" {
+		cfg.Timeouts.UploadFinalizeTimeout = "1h"
+	}
+	if cfg.Timeouts.ReadinessCheckTimeout == "" {
+		cfg.Timeouts.ReadinessCheckTimeout = "3s"
+	}
+	if cfg.ChangeLog.MaxEntries == 0 {
+		cfg.ChangeLog.MaxEntries = 10000
+	}
+	if cfg.AzureAD.SessionRefreshLifetime == "" {
+		cfg.AzureAD.SessionRefreshLifetime = "720h"
+	}
+	if cfg.ClientPingIntervalMs <= 0 {
+		cfg.ClientPingIntervalMs = 10000
+	}
+	if cfg.UploadCleanupTimeout == "" {
+		cfg.UploadCleanupTimeout = "10m"
+	}
+	if cfg.ShutdownUploadGrace == "" {
+		cfg.ShutdownUploadGrace = "0s"
+	}
+	if cfg.AccessLog.Format == "" {
+		cfg.AccessLog.Format = AccessLogFormatText
+	}
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = logging.FormatText
+	}
+	if cfg.RecentErrors.MaxPerUser <= 0 {
+		cfg.RecentErrors.MaxPerUser = 50
+	}
+	if cfg.RecentErrors.MaxAge == "" {
+		cfg.RecentErrors.MaxAge = "1h"
+	}
+	if cfg.Notifications.MaxPerUser <= 0 {
+		cfg.Notifications.MaxPerUser = 100
+	}
+	if cfg.Notifications.MaxAge == "" {
+		cfg.Notifications.MaxAge = "168h"
+	}
+	if cfg.UploadTemp.MaxAge == "" {
+		cfg.UploadTemp.MaxAge = "24h"
+	}
+	if cfg.UploadTemp.CheckInterval == "" {
+		cfg.UploadTemp.CheckInterval = "15m"
+	}
+	if cfg.Thumbnails.MaxSourceSizeMB <= 0 {
+		cfg.Thumbnails.MaxSourceSizeMB = 100
+	}
+	if cfg.Thumbnails.Timeout == "" {
+		cfg.Thumbnails.Timeout = "30s"
+	}
+	if cfg.Thumbnails.DefaultSize <= 0 {
+		cfg.Thumbnails.DefaultSize = 256
+	}
+	if cfg.Thumbnails.MaxSize <= 0 {
+		cfg.Thumbnails.MaxSize = 1024
+	}
+	if cfg.Thumbnails.MaxConcurrentTools <= 0 {
+		cfg.Thumbnails.MaxConcurrentTools = 2
+	}
+	if cfg.Thumbnails.CacheMaxSizeMB <= 0 {
+		cfg.Thumbnails.CacheMaxSizeMB = 1024
+	}
+	if cfg.Thumbnails.CacheMaxAge == "" {
+		cfg.Thumbnails.CacheMaxAge = "168h"
+	}
+	if cfg.ServerStatus.MaxInFlightRequests <= 0 {
+		cfg.ServerStatus.MaxInFlightRequests = 256
+	}
+	if cfg.LongPolling.PollIntervalMs <= 0 {
+		cfg.LongPolling.PollIntervalMs = 5000
+	}
+	if cfg.LongPolling.MaxPollIntervalMs <= 0 {
+		cfg.LongPolling.MaxPollIntervalMs = 60000
+	}
+	if cfg.UploadAutoRename.Pattern == "" {
+		cfg.UploadAutoRename.Pattern = "{name} ({n}){ext}"
+	}
+	if cfg.UploadAutoRename.MaxAttempts <= 0 {
+		cfg.UploadAutoRename.MaxAttempts = 100
+	}
+	if cfg.GlobalDeleteWorkers <= 0 {
+		cfg.GlobalDeleteWorkers = runtime.NumCPU() * 8
+	}
+	if cfg.MaxConcurrentExports <= 0 {
+		cfg.MaxConcurrentExports = runtime.NumCPU()
+	}
+	if cfg.RateLimitBurst <= 0 {
+		cfg.RateLimitBurst = cfg.RateLimitPerMinute
+	}
+	if cfg.DirectoryIndex.MaxItemsPerPage <= 0 {
+		cfg.DirectoryIndex.MaxItemsPerPage = 1000
+	}
+	if cfg.DownloadStreamError == "" {
+		cfg.DownloadStreamError = DownloadStreamErrorAbort
+	}
+	if cfg.ZipDownload.CompressionLevel == nil {
+		defaultLevel := 6
+		cfg.ZipDownload.CompressionLevel = &defaultLevel
+	}
+	if cfg.ZipDownload.StoreExtensions == nil {
+		cfg.ZipDownload.StoreExtensions = []string{
+			".zip", ".gz", ".tgz", ".bz2", ".xz", ".7z", ".rar", ".zst", ".jar",
+			".docx", ".xlsx", ".pptx", ".odt", ".ods", ".odp", ".epub",
+		}
+	}
+	if cfg.ContentDisposition.Extensions == nil {
+		cfg.ContentDisposition.Extensions = map[string]string{
+			".pdf": DispositionInline, ".png": DispositionInline, ".jpg": DispositionInline, ".jpeg": DispositionInline,
+			".gif": DispositionInline, ".webp": DispositionInline,
+		}
+	}
+	if cfg.ContentDisposition.ForceAttachmentTypes == nil {
+		cfg.ContentDisposition.ForceAttachmentTypes = []string{
+			"text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml",
+			"text/javascript", "application/javascript",
+		}
+	}
+	for i := range cfg.Storages {
+		if cfg.Storages[i].DownloadChecksums.MaxComputeSizeMB == 0 {
+			cfg.Storages[i].DownloadChecksums.MaxComputeSizeMB = 16
+		}
+		if cfg.Storages[i].Type == "azure-blob" && cfg.Storages[i].ContainerName == "" && cfg.Storages[i].SASURL != "" {
+			// Un errore nell'URL viene riportato da validateConfig.
+			cfg.Storages[i].ContainerName, _ = sasURLContainerName(cfg.Storages[i].SASURL)
+		}
+		switch cfg.Storages[i].MaxUploadBytes {
+		case 0:
+			cfg.Storages[i].MaxUploadBytes = max(cfg.MaxUploadBytes, 0)
+		case -1:
+			cfg.Storages[i].MaxUploadBytes = 0
+		}
 	}
 
-	switch strings.ToUpper(AppConfig.LogLevel) {
-	case string(LogLevelDebug):
-		CurrentLogLevel = LogLevelDebug
-	case string(LogLevelInfo):
-		CurrentLogLevel = LogLevelInfo
+	switch strings.ToUpper(cfg.LogLevel) {
+	case string(LogLevelDebug), string(LogLevelInfo):
 	default:
-		log.Printf("Warning: Invalid log_level '%s' in config. Using default 'INFO'.", AppConfig.LogLevel)
-		CurrentLogLevel = LogLevelInfo
+		log.Printf("Warning: Invalid log_level '%s' in config. Using default 'INFO'.", cfg.LogLevel)
 	}
-	log.Printf("Current log level set to: %s", CurrentLogLevel)
-	log.Printf("Configuration loaded successfully from %s", filename)
+	log.Printf("Configuration read successfully from %s", filename)
 
-	if IsLogLevel(LogLevelDebug) {
-		yamlData, marshalErr := yaml.Marshal(&AppConfig)
+	if strings.ToUpper(cfg.LogLevel) == string(LogLevelDebug) {
+		yamlData, marshalErr := yaml.Marshal(cfg)
 		if marshalErr != nil {
 			log.Printf("Warning: Failed to marshal config to YAML for logging: %v", marshalErr)
 		} else {
@@ -134,18 +689,78 @@ func LoadConfig(filename string) error {
 		}
 	}
 
-	validationErrors := validateConfig(&AppConfig)
+	validationErrors := validateConfig(cfg)
 	if len(validationErrors) > 0 {
 		log.Println("--- Errori di Validazione Configurazione ---")
 		for _, ve := range validationErrors {
 			log.Printf("Errore: %v", ve)
 		}
 		log.Println("------------------------------------------")
-		return fmt.Errorf("configuration validation failed with %d errors", len(validationErrors))
+		return nil, fmt.Errorf("configuration validation failed with %d errors", len(validationErrors))
+	}
+	return cfg, nil
+}
+
+// LoadConfig loads the configuration from the specified file into AppConfig and applies its log level.
+func LoadConfig(filename string) error {
+	cfg, err := ReadConfig(filename)
+	if err != nil {
+		return err
 	}
+	AppConfig = *cfg
+	logging.Setup(AppConfig.LogFormat, os.Stderr)
+	SetLogLevel(AppConfig.LogLevel)
+	SetStorageLogLevels(AppConfig.Storages)
+	log.Printf("Configuration loaded successfully from %s", filename)
 	return nil
 }
 
+// SetLogLevel applies the log_level of a configuration ("DEBUG" o "INFO", default INFO).
+// Sicura da chiamare mentre altre goroutine usano IsLogLevel (es. durante un reload).
+func SetLogLevel(logLevel string) {
+	level, err := ParseLogLevel(logLevel)
+	if err != nil {
+		level = LogLevelInfo
+	}
+	currentLogLevel.Store(level)
+	logging.SetLevel(level == LogLevelDebug)
+	log.Printf("Current log level set to: %s", level)
+}
+
+// SetStorageLogLevels applies the log_level overrides of the storages, replacing those set before
+// (anche quelli impostati a runtime da /admin/loglevel). Gli storage senza log_level seguono il livello globale.
+func SetStorageLogLevels(storages []StorageConfig) {
+	levels := make(map[string]bool)
+	for _, sc := range storages {
+		if sc.LogLevel == "" {
+			continue
+		}
+		level, err := ParseLogLevel(sc.LogLevel)
+		if err != nil {
+			continue
+		}
+		levels[sc.Name] = level == LogLevelDebug
+		log.Printf("Log level for storage '%s' set to: %s", sc.Name, level)
+	}
+	logging.SetStorageLevels(levels)
+}
+
+// ParseLogLevel converts a log level name, case insensitive, to a LogLevel.
+func ParseLogLevel(logLevel string) (LogLevel, error) {
+	switch LogLevel(strings.ToUpper(logLevel)) {
+	case LogLevelDebug:
+		return LogLevelDebug, nil
+	case LogLevelInfo:
+		return LogLevelInfo, nil
+	}
+	return "", fmt.Errorf("invalid log level '%s': must be '%s' or '%s'", logLevel, LogLevelDebug, LogLevelInfo)
+}
+
+// GetLogLevel returns the log level in use.
+func GetLogLevel() LogLevel {
+	return currentLogLevel.Load().(LogLevel)
+}
+
 // GetTimeouts ... (come prima, ma assicurati che WriteTimeout "0s" sia gestito correttamente)
 func (c *Config) GetTimeouts() (readTimeout, writeTimeout, idleTimeout time.Duration, err error) {
 	readTimeout, err = time.ParseDuration(c.Timeouts.ReadTimeout)
@@ -176,6 +791,262 @@ func (c *Config) GetUploadCleanupTimeout() (time.Duration, error) {
 	return duration, nil
 }
 
+// GetShutdownUploadGrace returns how long the shutdown waits for the uploads in progress.
+func (c *Config) GetShutdownUploadGrace() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.ShutdownUploadGrace)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shutdown_upload_grace format: %w", err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("shutdown_upload_grace cannot be negative")
+	}
+	return duration, nil
+}
+
+// GetStorageConfig returns the configuration of the named storage, or nil if it doesn't exist.
+func (c *Config) GetStorageConfig(name string) *StorageConfig {
+	for i := range c.Storages {
+		if c.Storages[i].Name == name {
+			return &c.Storages[i]
+		}
+	}
+	return nil
+}
+
+// NormalizeStoragePath normalizes the client path p of the named storage before it reaches the providers:
+// converte i backslash in "/" se lo storage ha normalize_backslashes, poi comprime le barre ripetute e
+// rimuove quella finale (tranne che per la radice "/") con CleanSlashes, così "/dir", "/dir/" e "//dir"
+// indicano lo stesso elemento su tutti i provider. Con keep_trailing_slashes la barra finale resta.
+// È l'unico punto di normalizzazione, usato sia dai messaggi WebSocket sia dagli endpoint HTTP.
+func (c *Config) NormalizeStoragePath(storageName string, p string) string {
+	keepTrailingSlash := false
+	if c != nil {
+		if storageCfg := c.GetStorageConfig(storageName); storageCfg != nil {
+			if storageCfg.NormalizeBackslashes {
+				p = strings.ReplaceAll(p, "\\", "/")
+			}
+			keepTrailingSlash = storageCfg.KeepTrailingSlashes
+		}
+	}
+	return CleanSlashes(p, keepTrailingSlash)
+}
+
+// CleanSlashes collapses the repeated "/" of p and, unless keepTrailingSlash, removes the trailing one;
+// la radice resta "/" e un path vuoto resta vuoto. A differenza di path.Clean non interpreta "." e "..",
+// che restano alla validazione dei provider.
+func CleanSlashes(p string, keepTrailingSlash bool) string {
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	if !keepTrailingSlash && len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return p
+}
+
+// pathsOverlap reports whether two local directories are the same or one contains the other.
+func pathsOverlap(a string, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	contains := func(parent string, child string) bool {
+		rel, err := filepath.Rel(parent, child)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	return contains(absA, absB) || contains(absB, absA)
+}
+
+// GetUploadCleanupTimeout returns the inactivity timeout after which an upload on this storage is
+// considered orphaned, or defaultTimeout (the global upload_cleanup_timeout) if not set.
+func (s *StorageConfig) GetUploadCleanupTimeout(defaultTimeout time.Duration) (time.Duration, error) {
+	if s.UploadCleanupTimeout == "" {
+		return defaultTimeout, nil
+	}
+	duration, err := time.ParseDuration(s.UploadCleanupTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upload_cleanup_timeout format for storage '%s': %w", s.Name, err)
+	}
+	return duration, nil
+}
+
+// GetOperationQueueTimeout returns how long an operation waits for a slot of max_concurrent_operations.
+func (s *StorageConfig) GetOperationQueueTimeout() (time.Duration, error) {
+	if s.OperationQueueTimeout == "" {
+		return 10 * time.Second, nil
+	}
+	duration, err := time.ParseDuration(s.OperationQueueTimeout)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid operation_queue_timeout for storage '%s': must be a non-negative duration", s.Name)
+	}
+	return duration, nil
+}
+
+// GetTrashRetention returns how long deleted items stay in the trash_dir of a local storage; 0 means
+// that they are never purged automatically.
+func (s *StorageConfig) GetTrashRetention() (time.Duration, error) {
+	if s.TrashRetention == "" {
+		return 7 * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(s.TrashRetention)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid trash_retention for storage '%s': must be a non-negative duration", s.Name)
+	}
+	return duration, nil
+}
+
+// GetCommandTimeouts returns the timeouts of a "command" storage: timeout for metadata operations
+// (list, stat, delete, mkdir) and transferTimeout for get, put, move and copy.
+func (s *StorageConfig) GetCommandTimeouts() (timeout, transferTimeout time.Duration, err error) {
+	timeout, transferTimeout = 30*time.Second, time.Hour
+	if s.CommandTimeout != "" {
+		if timeout, err = time.ParseDuration(s.CommandTimeout); err != nil || timeout <= 0 {
+			return 0, 0, fmt.Errorf("invalid command_timeout for storage '%s': must be a positive duration", s.Name)
+		}
+	}
+	if s.TransferTimeout != "" {
+		if transferTimeout, err = time.ParseDuration(s.TransferTimeout); err != nil || transferTimeout <= 0 {
+			return 0, 0, fmt.Errorf("invalid transfer_timeout for storage '%s': must be a positive duration", s.Name)
+		}
+	}
+	return timeout, transferTimeout, nil
+}
+
+// GetProviderInitTimeout returns the maximum time allowed to initialize a single storage provider.
+func (c *Config) GetProviderInitTimeout() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.Timeouts.ProviderInitTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeouts.provider_init_timeout format: %w", err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("timeouts.provider_init_timeout must be positive, got '%s'", c.Timeouts.ProviderInitTimeout)
+	}
+	return duration, nil
+}
+
+// GetReadinessCheckTimeout returns the maximum time of the storage checks of /readyz.
+func (c *Config) GetReadinessCheckTimeout() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.Timeouts.ReadinessCheckTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeouts.readiness_check_timeout format: %w", err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("timeouts.readiness_check_timeout must be positive, got '%s'", c.Timeouts.ReadinessCheckTimeout)
+	}
+	return duration, nil
+}
+
+// GetSessionRefreshLifetime returns how long a browser session can be renewed with its refresh token
+// (0 = sessions are not renewed).
+func (c *Config) GetSessionRefreshLifetime() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.AzureAD.SessionRefreshLifetime)
+	if err != nil {
+		return 0, fmt.Errorf("invalid azure_ad.session_refresh_lifetime format: %w", err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("azure_ad.session_refresh_lifetime cannot be negative, got '%s'", c.AzureAD.SessionRefreshLifetime)
+	}
+	return duration, nil
+}
+
+// GetPostLogoutRedirectURL returns the page Entra ID returns to after a federated logout:
+// azure_ad.post_logout_redirect_url, or the root of azure_ad.redirect_url.
+func (c *Config) GetPostLogoutRedirectURL() (string, error) {
+	key, value := "azure_ad.post_logout_redirect_url", c.AzureAD.PostLogoutRedirectURL
+	if value == "" {
+		key, value = "azure_ad.redirect_url", c.AzureAD.RedirectURL
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return "", fmt.Errorf("%s must be an absolute http(s) URL for federated_logout, got '%s'", key, value)
+	}
+	if c.AzureAD.PostLogoutRedirectURL == "" {
+		return parsed.Scheme + "://" + parsed.Host + "/", nil
+	}
+	return value, nil
+}
+
+// GetUploadPhaseTimeout returns the timeout of an /upload action: "chunk", "finalize" or, for "initiate"
+// and "cancel", upload_initiate_timeout.
+func (c *Config) GetUploadPhaseTimeout(action string) (time.Duration, error) {
+	key, value := "upload_initiate_timeout", c.Timeouts.UploadInitiateTimeout
+	switch action {
+	case "chunk":
+		key, value = "upload_chunk_timeout", c.Timeouts.UploadChunkTimeout
+	case "finalize":
+		key, value = "upload_finalize_timeout", c.Timeouts.UploadFinalizeTimeout
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeouts.%s format: %w", key, err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("timeouts.%s must be positive, got '%s'", key, value)
+	}
+	return duration, nil
+}
+
+// GetUploadTempMaxAge returns the age after which an orphaned upload temp file is removed.
+func (c *Config) GetUploadTempMaxAge() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.UploadTemp.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upload_temp.max_age format: %w", err)
+	}
+	return duration, nil
+}
+
+// GetUploadTempCheckInterval returns how often the upload temp files are checked.
+func (c *Config) GetUploadTempCheckInterval() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.UploadTemp.CheckInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upload_temp.check_interval format: %w", err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("upload_temp.check_interval must be positive, got '%s'", c.UploadTemp.CheckInterval)
+	}
+	return duration, nil
+}
+
+// GetThumbnailTimeout returns the maximum time allowed to generate a thumbnail.
+func (c *Config) GetThumbnailTimeout() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.Thumbnails.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid thumbnails.timeout format: %w", err)
+	}
+	return duration, nil
+}
+
+// GetThumbnailCacheMaxAge returns how long an unused thumbnail stays in the cache (0 = nessun limite).
+func (c *Config) GetThumbnailCacheMaxAge() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.Thumbnails.CacheMaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid thumbnails.cache_max_age format: %w", err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("thumbnails.cache_max_age cannot be negative, got '%s'", c.Thumbnails.CacheMaxAge)
+	}
+	return duration, nil
+}
+
+// GetRecentErrorsMaxAge returns how long a failed operation is kept in the per-user recent errors buffer.
+func (c *Config) GetRecentErrorsMaxAge() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.RecentErrors.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid recent_errors.max_age format: %w", err)
+	}
+	return duration, nil
+}
+
+// GetNotificationsMaxAge returns how long an unread notification is kept in the per-user inbox.
+func (c *Config) GetNotificationsMaxAge() (time.Duration, error) {
+	duration, err := time.ParseDuration(c.Notifications.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid notifications.max_age format: %w", err)
+	}
+	return duration, nil
+}
+
 // validateConfig ... (come prima)
 func validateConfig(cfg *Config) []error {
 	var errors []error
@@ -193,6 +1064,111 @@ func validateConfig(cfg *Config) []error {
 		if cfg.AzureAD.RedirectURL == "" {
 			errors = append(errors, fmt.Errorf("azure_ad.redirect_url is mandatory when enable_auth is true"))
 		}
+		if cfg.AzureAD.FederatedLogout {
+			if _, err := cfg.GetPostLogoutRedirectURL(); err != nil {
+				errors = append(errors, err)
+			}
+		}
+	}
+	if cfg.LogFormat != logging.FormatText && cfg.LogFormat != logging.FormatJSON {
+		errors = append(errors, fmt.Errorf("log_format must be '%s' or '%s', got '%s'", logging.FormatText, logging.FormatJSON, cfg.LogFormat))
+	}
+	if cfg.AccessLog.Format != AccessLogFormatText && cfg.AccessLog.Format != AccessLogFormatJSON {
+		errors = append(errors, fmt.Errorf("access_log.format must be '%s' or '%s', got '%s'", AccessLogFormatText, AccessLogFormatJSON, cfg.AccessLog.Format))
+	}
+	if _, err := cfg.GetRecentErrorsMaxAge(); err != nil {
+		errors = append(errors, err)
+	}
+	if _, err := cfg.GetNotificationsMaxAge(); err != nil {
+		errors = append(errors, err)
+	}
+	if _, err := cfg.GetProviderInitTimeout(); err != nil {
+		errors = append(errors, err)
+	}
+	if _, err := cfg.GetReadinessCheckTimeout(); err != nil {
+		errors = append(errors, err)
+	}
+	if _, err := cfg.GetSessionRefreshLifetime(); err != nil {
+		errors = append(errors, err)
+	}
+	if cfg.ChangeLog.MaxEntries < 0 {
+		errors = append(errors, fmt.Errorf("change_log.max_entries cannot be negative"))
+	}
+	if _, err := cfg.GetShutdownUploadGrace(); err != nil {
+		errors = append(errors, err)
+	}
+	for _, action := range []string{"initiate", "chunk", "finalize"} {
+		if _, err := cfg.GetUploadPhaseTimeout(action); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	if _, err := cfg.GetUploadTempMaxAge(); err != nil {
+		errors = append(errors, err)
+	}
+	if _, err := cfg.GetUploadTempCheckInterval(); err != nil {
+		errors = append(errors, err)
+	}
+	if _, err := cfg.GetThumbnailTimeout(); err != nil {
+		errors = append(errors, err)
+	}
+	if _, err := cfg.GetThumbnailCacheMaxAge(); err != nil {
+		errors = append(errors, err)
+	}
+	if cfg.Thumbnails.DefaultSize > cfg.Thumbnails.MaxSize {
+		errors = append(errors, fmt.Errorf("thumbnails.default_size (%d) cannot exceed thumbnails.max_size (%d)", cfg.Thumbnails.DefaultSize, cfg.Thumbnails.MaxSize))
+	}
+	if cfg.LongPolling.PollIntervalMs > cfg.LongPolling.MaxPollIntervalMs {
+		errors = append(errors, fmt.Errorf("long_polling.poll_interval_ms (%d) cannot exceed long_polling.max_poll_interval_ms (%d)", cfg.LongPolling.PollIntervalMs, cfg.LongPolling.MaxPollIntervalMs))
+	}
+	for ext, disposition := range cfg.ContentDisposition.Extensions {
+		if !strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+			errors = append(errors, fmt.Errorf("content_disposition.extensions: '%s' must be a lowercase extension starting with '.'", ext))
+		}
+		if disposition != DispositionInline && disposition != DispositionAttachment {
+			errors = append(errors, fmt.Errorf("content_disposition.extensions['%s'] must be '%s' or '%s', got '%s'", ext, DispositionInline, DispositionAttachment, disposition))
+		}
+	}
+	if cfg.MaxUploadBytes < 0 {
+		errors = append(errors, fmt.Errorf("max_upload_bytes cannot be negative, got %d", cfg.MaxUploadBytes))
+	}
+	if cfg.RateLimitPerMinute < 0 {
+		errors = append(errors, fmt.Errorf("rate_limit_per_minute cannot be negative, got %d", cfg.RateLimitPerMinute))
+	}
+	if cfg.DownloadStreamError != DownloadStreamErrorAbort && cfg.DownloadStreamError != DownloadStreamErrorTrailer {
+		errors = append(errors, fmt.Errorf("download_stream_error must be '%s' or '%s', got '%s'", DownloadStreamErrorAbort, DownloadStreamErrorTrailer, cfg.DownloadStreamError))
+	}
+	if level := *cfg.ZipDownload.CompressionLevel; level < 0 || level > 9 {
+		errors = append(errors, fmt.Errorf("zip_download.compression_level must be between 0 and 9, got %d", level))
+	}
+	for _, ext := range cfg.ZipDownload.StoreExtensions {
+		if !strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+			errors = append(errors, fmt.Errorf("zip_download.store_extensions: '%s' must be a lowercase extension starting with '.'", ext))
+		}
+	}
+	if !strings.Contains(cfg.UploadAutoRename.Pattern, "{n}") || strings.Contains(cfg.UploadAutoRename.Pattern, "/") {
+		errors = append(errors, fmt.Errorf("upload_auto_rename.pattern must contain '{n}' and no '/', got '%s'", cfg.UploadAutoRename.Pattern))
+	}
+	if cfg.DirectoryIndex.Enabled && cfg.DirectoryIndex.Template != "" {
+		if _, err := os.Stat(cfg.DirectoryIndex.Template); err != nil {
+			errors = append(errors, fmt.Errorf("directory_index.template is not readable: %w", err))
+		}
+	}
+	for i, user := range cfg.WebDAV.Users {
+		if user.Username == "" || strings.Contains(user.Username, ":") {
+			errors = append(errors, fmt.Errorf("webdav.users[%d].username is mandatory and cannot contain ':'", i))
+		}
+		if !strings.HasPrefix(user.PasswordHash, "$2") {
+			errors = append(errors, fmt.Errorf("webdav.users[%d].password_hash must be a bcrypt hash", i))
+		}
+	}
+	for token, user := range cfg.APITokens {
+		// Il token non compare nei messaggi: se ne riporta solo l'utente.
+		if len(token) < MinAPITokenLength || strings.ContainsAny(token, " \t\r\n") {
+			errors = append(errors, fmt.Errorf("api_tokens: the token of '%s' must be at least %d characters without spaces", user.Email, MinAPITokenLength))
+		}
+		if user.Email == "" {
+			errors = append(errors, fmt.Errorf("api_tokens: every token must have an email"))
+		}
 	}
 	if cfg.Storages == nil {
 		errors = append(errors, fmt.Errorf("storages list is mandatory"))
@@ -201,6 +1177,23 @@ func validateConfig(cfg *Config) []error {
 		if storageCfg.Name == "" {
 			errors = append(errors, fmt.Errorf("storages[%d].name is mandatory", i))
 		}
+		if storageCfg.QuotaBytes < 0 {
+			errors = append(errors, fmt.Errorf("storages[%d].quota_bytes cannot be negative", i))
+		}
+		if storageCfg.MaxUploadBytes < 0 {
+			errors = append(errors, fmt.Errorf("storages[%d].max_upload_bytes must be -1 (no limit), 0 (global max_upload_bytes) or positive", i))
+		}
+		if storageCfg.MaxConcurrentOperations < 0 {
+			errors = append(errors, fmt.Errorf("storages[%d].max_concurrent_operations cannot be negative", i))
+		}
+		if _, err := storageCfg.GetOperationQueueTimeout(); err != nil {
+			errors = append(errors, err)
+		}
+		if storageCfg.LogLevel != "" {
+			if _, err := ParseLogLevel(storageCfg.LogLevel); err != nil {
+				errors = append(errors, fmt.Errorf("storages[%d].log_level: %w", i, err))
+			}
+		}
 		if storageCfg.Type == "" {
 			errors = append(errors, fmt.Errorf("storages[%d].type is mandatory", i))
 		} else {
@@ -209,17 +1202,82 @@ func validateConfig(cfg *Config) []error {
 				if storageCfg.Path == "" {
 					errors = append(errors, fmt.Errorf("storages[%d].path is mandatory for type 'local'", i))
 				}
+				if storageCfg.TrashDir != "" {
+					if storageCfg.Path != "" && pathsOverlap(storageCfg.Path, storageCfg.TrashDir) {
+						errors = append(errors, fmt.Errorf("storages[%d].trash_dir must be outside the storage path", i))
+					}
+					if _, err := storageCfg.GetTrashRetention(); err != nil {
+						errors = append(errors, err)
+					}
+				}
+				if storageCfg.UploadWriters < 0 || storageCfg.UploadWriters > MaxUploadWriters {
+					errors = append(errors, fmt.Errorf("storages[%d].upload_writers must be between 0 and %d", i, MaxUploadWriters))
+				}
 			case "azure-blob":
-				if storageCfg.ConnectionString == "" && storageCfg.AccountName == "" {
-					errors = append(errors, fmt.Errorf("storages[%d] requires either connection_string or account_name for type 'azure-blob'", i))
+				if storageCfg.ConnectionString == "" && storageCfg.AccountName == "" && storageCfg.SASURL == "" {
+					errors = append(errors, fmt.Errorf("storages[%d] requires one of connection_string, account_name or sas_url for type 'azure-blob'", i))
+				}
+				if storageCfg.SASURL != "" && storageCfg.SASToken != "" {
+					errors = append(errors, fmt.Errorf("storages[%d] sets both sas_url and sas_token: use only one", i))
+				} else if _, err := storageCfg.SASContainerURL(); err != nil {
+					errors = append(errors, fmt.Errorf("storages[%d]: %w", i, err))
+				}
+				if storageCfg.SASURL != "" && storageCfg.ContainerName != "" {
+					if containerName, err := sasURLContainerName(storageCfg.SASURL); err == nil && containerName != storageCfg.ContainerName {
+						errors = append(errors, fmt.Errorf("storages[%d].container_name '%s' differs from the container '%s' of sas_url", i, storageCfg.ContainerName, containerName))
+					}
 				}
 				if storageCfg.ContainerName == "" {
 					errors = append(errors, fmt.Errorf("storages[%d].container_name is mandatory for type 'azure-blob'", i))
 				}
+				if storageCfg.DownloadBlockSizeMB < 0 || storageCfg.DownloadBlockSizeMB > 100 {
+					errors = append(errors, fmt.Errorf("storages[%d].download_block_size_mb must be between 1 and 100 (0 = default)", i))
+				}
+				switch storageCfg.DirectoryMarkers {
+				case "", DirectoryMarkersPreserve, DirectoryMarkersRecreate, DirectoryMarkersImplicit:
+				default:
+					errors = append(errors, fmt.Errorf("storages[%d].directory_markers must be '%s', '%s' or '%s', got '%s'", i, DirectoryMarkersPreserve, DirectoryMarkersRecreate, DirectoryMarkersImplicit, storageCfg.DirectoryMarkers))
+				}
+			case "gcs":
+				if storageCfg.Bucket == "" {
+					errors = append(errors, fmt.Errorf("storages[%d].bucket is mandatory for type 'gcs'", i))
+				}
+				if storageCfg.CredentialsFile != "" {
+					if _, err := os.Stat(storageCfg.CredentialsFile); err != nil {
+						errors = append(errors, fmt.Errorf("storages[%d].credentials_file is not readable: %w", i, err))
+					}
+				}
+				if storageCfg.DownloadBlockSizeMB < 0 || storageCfg.DownloadBlockSizeMB > 100 {
+					errors = append(errors, fmt.Errorf("storages[%d].download_block_size_mb must be between 1 and 100 (0 = default)", i))
+				}
+			case "command":
+				if len(storageCfg.Commands.List) == 0 || storageCfg.Commands.List[0] == "" {
+					errors = append(errors, fmt.Errorf("storages[%d].commands.list is mandatory for type 'command'", i))
+				}
+				if len(storageCfg.Commands.Get) == 0 || storageCfg.Commands.Get[0] == "" {
+					errors = append(errors, fmt.Errorf("storages[%d].commands.get is mandatory for type 'command'", i))
+				}
+				if _, _, err := storageCfg.GetCommandTimeouts(); err != nil {
+					errors = append(errors, err)
+				}
+				if storageCfg.RecordUploader {
+					errors = append(errors, fmt.Errorf("storages[%d].record_uploader is not supported for type 'command'", i))
+				}
+			case "memory":
+				// Nessun campo obbligatorio; path indica quasi sempre uno storage "local" configurato male.
+				if storageCfg.Path != "" {
+					errors = append(errors, fmt.Errorf("storages[%d].path is not supported for type 'memory'", i))
+				}
 			default:
 				errors = append(errors, fmt.Errorf("storages[%d] has unknown type '%s'", i, storageCfg.Type))
 			}
 		}
+		if storageCfg.TrashDir != "" && storageCfg.Type != "local" {
+			errors = append(errors, fmt.Errorf("storages[%d].trash_dir is only supported for type 'local'", i))
+		}
+		if _, err := storageCfg.GetUploadCleanupTimeout(0); err != nil {
+			errors = append(errors, err)
+		}
 		for j, perm := range storageCfg.Permissions {
 			if perm.GroupID == "" { // GroupID ora si assume sia un nome
 				errors = append(errors, fmt.Errorf("storages[%d].permissions[%d].group_id (group name) is mandatory", i, j))
@@ -229,6 +1287,17 @@ func validateConfig(cfg *Config) []error {
 			} else if perm.Access != "read" && perm.Access != "write" {
 				errors = append(errors, fmt.Errorf("storages[%d].permissions[%d].access must be 'read' or 'write', got '%s'", i, j, perm.Access))
 			}
+			if perm.PathPrefix != "" {
+				for _, segment := range strings.Split(strings.ReplaceAll(perm.PathPrefix, "\\", "/"), "/") {
+					if segment == ".." || segment == "." {
+						errors = append(errors, fmt.Errorf("storages[%d].permissions[%d].path_prefix cannot contain '.' or '..' segments, got '%s'", i, j, perm.PathPrefix))
+						break
+					}
+				}
+				if strings.Contains(perm.PathPrefix, "*") {
+					errors = append(errors, fmt.Errorf("storages[%d].permissions[%d].path_prefix is a literal path prefix, wildcards are not supported: '%s'", i, j, perm.PathPrefix))
+				}
+			}
 		}
 	}
 	return errors
@@ -236,7 +1305,7 @@ func validateConfig(cfg *Config) []error {
 
 // IsLogLevel ... (come prima)
 func IsLogLevel(level LogLevel) bool {
-	switch CurrentLogLevel {
+	switch GetLogLevel() {
 	case LogLevelDebug:
 		return true 
 	case LogLevelInfo:
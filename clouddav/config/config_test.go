@@ -0,0 +1,60 @@
+package config
+
+import "testing"
+
+func TestCleanSlashes(t *testing.T) {
+	tests := []struct {
+		path              string
+		keepTrailingSlash bool
+		want              string
+	}{
+		{"", false, ""},
+		{"/", false, "/"},
+		{"//", false, "/"},
+		{"/dir", false, "/dir"},
+		{"/dir/", false, "/dir"},
+		{"//dir//", false, "/dir"},
+		{"/a/b/c/", false, "/a/b/c"},
+		{"/a//b///c", false, "/a/b/c"},
+		{"/a/./b/../c/", false, "/a/./b/../c"}, // "." e ".." restano ai provider
+		{"/dir/", true, "/dir/"},
+		{"//dir//", true, "/dir/"},
+		{"/", true, "/"},
+	}
+	for _, tt := range tests {
+		if got := CleanSlashes(tt.path, tt.keepTrailingSlash); got != tt.want {
+			t.Errorf("CleanSlashes(%q, %t) = %q, want %q", tt.path, tt.keepTrailingSlash, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeStoragePath(t *testing.T) {
+	cfg := &Config{Storages: []StorageConfig{
+		{Name: "plain", Type: "local"},
+		{Name: "windows", Type: "local", NormalizeBackslashes: true},
+		{Name: "keep", Type: "local", KeepTrailingSlashes: true},
+	}}
+	tests := []struct {
+		storageName string
+		path        string
+		want        string
+	}{
+		{"plain", "/docs//2024/", "/docs/2024"},
+		{"plain", `\docs\2024`, `\docs\2024`},
+		{"windows", `\docs\\2024\`, "/docs/2024"},
+		{"windows", `/docs\2024/report.pdf`, "/docs/2024/report.pdf"},
+		{"windows", `\docs/2024\/report.pdf`, "/docs/2024/report.pdf"},
+		{"plain", `/docs\2024/report.pdf`, `/docs\2024/report.pdf`},
+		{"keep", "//docs//", "/docs/"},
+		{"unknown", "/docs/", "/docs"},
+	}
+	for _, tt := range tests {
+		if got := cfg.NormalizeStoragePath(tt.storageName, tt.path); got != tt.want {
+			t.Errorf("NormalizeStoragePath(%q, %q) = %q, want %q", tt.storageName, tt.path, got, tt.want)
+		}
+	}
+	var nilConfig *Config
+	if got := nilConfig.NormalizeStoragePath("plain", "//x/"); got != "/x" {
+		t.Errorf("NormalizeStoragePath on a nil config = %q, want \"/x\"", got)
+	}
+}
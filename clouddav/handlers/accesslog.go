@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"clouddav/config"
+)
+
+// accessLogger scrive le righe JSON dell'access log senza il prefisso data/ora del logger standard,
+// così ogni riga è un oggetto JSON valido.
+var accessLogger = log.New(os.Stdout, "", 0)
+
+// accessLogEntryKey is the context key for the mutable access log entry of the current request.
+type accessLogEntryKey struct{}
+
+// accessLogEntry contiene i campi di una riga dell'access log.
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	User       string  `json:"user"`
+	ClientIP   string  `json:"client_ip"`
+	RequestID  string  `json:"request_id"`
+}
+
+// accessLogResponseWriter wraps an http.ResponseWriter to capture the status code and bytes written.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (w *accessLogResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush permette lo streaming dei download anche attraverso il wrapper.
+func (w *accessLogResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack è necessario per l'upgrade WebSocket su /ws.
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("underlying ResponseWriter does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap consente a http.ResponseController di raggiungere il ResponseWriter originale.
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AccessLogMiddleware emits one access log line per HTTP request, if enabled in the configuration.
+// Lo user viene valorizzato da AuthMiddleware tramite setAccessLogUser, dato che i claims sono
+// disponibili solo più in profondità nella catena degli handler.
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := currentConfig()
+		if cfg == nil || !cfg.AccessLog.Enabled || (!cfg.AccessLog.LogStaticEndpoints && isStaticOrHealthPath(r.URL.Path)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		entry := &accessLogEntry{
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			User:      "-",
+			ClientIP:  clientIP(r),
+			RequestID: requestID,
+		}
+		lw := &accessLogResponseWriter{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), accessLogEntryKey{}, entry)
+
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		switch {
+		case lw.hijacked:
+			// Upgrade WebSocket: la connessione prosegue fuori dall'handler HTTP, registriamo solo l'upgrade.
+			entry.Status = http.StatusSwitchingProtocols
+		case lw.status == 0:
+			entry.Status = http.StatusOK
+		default:
+			entry.Status = lw.status
+		}
+		entry.Bytes = lw.bytes
+		entry.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		entry.Time = start.UTC().Format(time.RFC3339Nano)
+		writeAccessLog(entry)
+	})
+}
+
+// setAccessLogUser records the authenticated user on the access log entry of the request, if any.
+func setAccessLogUser(ctx context.Context, user string) {
+	if entry, ok := ctx.Value(accessLogEntryKey{}).(*accessLogEntry); ok && user != "" {
+		entry.User = user
+	}
+}
+
+func writeAccessLog(entry *accessLogEntry) {
+	if currentConfig().AccessLog.Format == config.AccessLogFormatJSON {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Error marshalling access log entry: %v", err)
+			return
+		}
+		accessLogger.Println(string(line))
+		return
+	}
+	log.Printf("access: %s %s status=%d bytes=%d duration_ms=%.3f user=%s client_ip=%s request_id=%s",
+		entry.Method, entry.Path, entry.Status, entry.Bytes, entry.DurationMs, entry.User, entry.ClientIP, entry.RequestID)
+}
+
+// isStaticOrHealthPath reports whether the path is a static asset or a health endpoint,
+// which are not logged unless access_log.log_static_endpoints is true.
+func isStaticOrHealthPath(path string) bool {
+	switch path {
+	case "/favicon.ico", "/treeview.html", "/filelist.html", "/metrics", "/healthz", "/readyz":
+		return true
+	}
+	return strings.HasPrefix(path, "/js/") || strings.HasPrefix(path, "/css/")
+}
+
+// clientIP returns the client address, honouring X-Forwarded-For when behind a proxy/ingress.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestIDFor returns the request ID of r for the application logs: quello assegnato da AccessLogMiddleware
+// (già nell'header della risposta) o, con l'access log disattivato, l'X-Request-ID inviato dal client.
+func requestIDFor(w http.ResponseWriter, r *http.Request) string {
+	if requestID := w.Header().Get("X-Request-ID"); requestID != "" {
+		return requestID
+	}
+	return r.Header.Get("X-Request-ID")
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/internal/logging"
+	"clouddav/internal/metrics"
+	"clouddav/storage"
+)
+
+// handleAdminLogLevel serves /admin/loglevel: GET returns the log level in use, POST {"level":"DEBUG"}
+// lo cambia senza riavviare il server. Con "storage" il POST cambia solo l'override di quello storage
+// ("level" vuoto lo rimuove). Riservato ai global_admin_groups. I valori impostati restano in uso fino
+// al successivo reload della configurazione, che riapplica log_level.
+func handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	claims, _ := getClaimsFromContext(r.Context())
+	if !authz.IsGlobalAdmin(claims, currentConfig()) {
+		http.Error(w, "Access denied: global admin required", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var request struct {
+			Level   string `json:"level"`
+			Storage string `json:"storage"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body: expected {\"level\": \"DEBUG\"|\"INFO\", \"storage\": optional}", http.StatusBadRequest)
+			return
+		}
+		userIdent := "unauthenticated"
+		if claims != nil {
+			userIdent = claims.Email
+		}
+		if request.Storage != "" {
+			if _, ok := storage.GetProvider(request.Storage); !ok {
+				http.Error(w, fmt.Sprintf("Storage '%s' not found", request.Storage), http.StatusNotFound)
+				return
+			}
+			if request.Level == "" {
+				logging.ClearStorageLevel(request.Storage)
+				log.Printf("Log level override of storage '%s' removed by user '%s'", request.Storage, userIdent)
+				break
+			}
+		}
+		level, err := config.ParseLogLevel(request.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Storage != "" {
+			logging.SetStorageLevel(request.Storage, level == config.LogLevelDebug)
+			log.Printf("Log level of storage '%s' set to %s by user '%s'", request.Storage, level, userIdent)
+			break
+		}
+		previous := config.GetLogLevel()
+		config.SetLogLevel(string(level))
+		log.Printf("Log level changed from %s to %s by user '%s'", previous, level, userIdent)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	storageLevels := make(map[string]config.LogLevel)
+	for name, debug := range logging.StorageLevels() {
+		storageLevels[name] = config.LogLevelInfo
+		if debug {
+			storageLevels[name] = config.LogLevelDebug
+		}
+	}
+	response := map[string]any{"level": config.GetLogLevel(), "storages": storageLevels}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error writing log level response: %v", err)
+	}
+}
+
+// handleMetrics serves /metrics in the Prometheus text format when metrics.enabled is set. Con
+// metrics.require_admin la richiesta passa da AuthMiddleware ed è riservata ai global_admin_groups;
+// altrimenti l'endpoint è pubblico, come si aspetta uno scraper Prometheus.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	if !cfg.Metrics.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if !cfg.Metrics.RequireAdmin {
+		metrics.Handler().ServeHTTP(w, r)
+		return
+	}
+	AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := getClaimsFromContext(r.Context())
+		if !authz.IsGlobalAdmin(claims, currentConfig()) {
+			http.Error(w, "Access denied: global admin required", http.StatusForbidden)
+			return
+		}
+		metrics.Handler().ServeHTTP(w, r)
+	})).ServeHTTP(w, r)
+}
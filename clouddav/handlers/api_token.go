@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"clouddav/auth"
+	"clouddav/config"
+)
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (token string, ok bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// apiTokenClaims returns the claims of the api_tokens entry matching token, or nil. Tutti i token vengono
+// confrontati in tempo costante (sugli hash, che hanno lunghezza fissa), così il tempo di risposta non
+// rivela né quanti caratteri sono corretti né la lunghezza dei token configurati.
+func apiTokenClaims(cfg *config.Config, token string) *auth.UserClaims {
+	tokenHash := sha256.Sum256([]byte(token))
+	var matched *config.APITokenUser
+	for configuredToken, user := range cfg.APITokens {
+		configuredHash := sha256.Sum256([]byte(configuredToken))
+		if subtle.ConstantTimeCompare(tokenHash[:], configuredHash[:]) == 1 {
+			user := user
+			matched = &user
+		}
+	}
+	if matched == nil {
+		return nil
+	}
+	return &auth.UserClaims{
+		Subject:    "token:" + matched.Email,
+		Name:       matched.Email,
+		Email:      matched.Email,
+		GroupNames: matched.Groups,
+	}
+}
+
+func apiTokenChallenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="CloudDAV", error="invalid_token"`)
+	http.Error(w, "Invalid API token", http.StatusUnauthorized)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/internal/metrics"
+	"clouddav/storage"
+	"clouddav/websocket"
+)
+
+// handleArchiveEntry handles /archive-entry?storage=&path=&entry=: the decompressed content of a single
+// entry of a stored ZIP, senza scaricare l'intero archivio. Vale il permesso di lettura dell'archivio;
+// la voce viene individuata dal central directory (vedi websocket.OpenArchiveEntry).
+func handleArchiveEntry(w http.ResponseWriter, r *http.Request) {
+	claims, _ := getClaimsFromContext(r.Context())
+
+	storageName := r.URL.Query().Get("storage")
+	archivePath := currentConfig().NormalizeStoragePath(storageName, r.URL.Query().Get("path"))
+	entryName := r.URL.Query().Get("entry")
+	if storageName == "" || archivePath == "" || entryName == "" {
+		http.Error(w, "Parameters 'storage', 'path' and 'entry' required", http.StatusBadRequest)
+		return
+	}
+	// Il nome riportato negli errori e da cui deriva il filename del Content-Disposition.
+	itemPath := strings.TrimSuffix(archivePath, "/") + "/" + strings.TrimPrefix(entryName, "/")
+
+	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, archivePath, "read", currentConfig()); err != nil {
+		wsHub.RecordError(claims, "download", storageName, itemPath, err)
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			http.Error(w, "Access denied: read permission required", http.StatusForbidden)
+		} else {
+			log.Printf("Error checking storage access for archive entry '%s/%s': %v", storageName, itemPath, err)
+			http.Error(w, "Internal server error during access check", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	provider, ok := storage.GetProvider(storageName)
+	if !ok {
+		http.Error(w, "Storage provider not found", http.StatusNotFound)
+		return
+	}
+	defer metrics.DownloadDuration.ObserveSince(time.Now(), storageName)
+
+	entry, header, err := websocket.OpenArchiveEntry(r.Context(), provider, claims, archivePath, entryName)
+	if err != nil {
+		switch {
+		case errors.Is(err, websocket.ErrArchiveEntryNotFound):
+			wsHub.RecordError(claims, "download", storageName, itemPath, err)
+			http.Error(w, fmt.Sprintf("NOT_FOUND: entry '%s' not found in the archive", entryName), http.StatusNotFound)
+		case errors.Is(err, websocket.ErrUnsupportedArchive):
+			wsHub.RecordError(claims, "download", storageName, itemPath, err)
+			http.Error(w, fmt.Sprintf("UNSUPPORTED_ARCHIVE: %v", err), http.StatusBadRequest)
+		case errors.Is(err, websocket.ErrInvalidArchive):
+			wsHub.RecordError(claims, "download", storageName, itemPath, err)
+			http.Error(w, fmt.Sprintf("INVALID_ARCHIVE: %v", err), http.StatusUnprocessableEntity)
+		case errors.Is(err, websocket.ErrArchiveTooLarge):
+			wsHub.RecordError(claims, "download", storageName, itemPath, err)
+			http.Error(w, fmt.Sprintf("ARCHIVE_TOO_LARGE: %v", err), http.StatusRequestEntityTooLarge)
+		default:
+			writeDownloadError(w, claims, storageName, itemPath, err)
+		}
+		return
+	}
+	defer entry.Close()
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("[DEBUG] handleArchiveEntry: Streaming '%s' (%d bytes, %d compressed) from '%s/%s'", header.Name, header.UncompressedSize64, header.CompressedSize64, storageName, archivePath)
+	}
+
+	streamDownload(w, r, claims, storageName, itemPath, entry, int64(header.UncompressedSize64))
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"clouddav/config"
+)
+
+// downloadSniffSize is the number of bytes examined by http.DetectContentType.
+const downloadSniffSize = 512
+
+// downloadDisposition returns the Content-Disposition type and the Content-Type of a download.
+// La scelta parte da content_disposition.extensions e può essere sovrascritta da ?inline o ?download;
+// un file viene servito inline solo se il suo tipo MIME è noto e non è in force_attachment_types.
+// I tipi sconosciuti e quelli in force_attachment_types sono serviti come application/octet-stream.
+func downloadDisposition(r *http.Request, itemPath string, head func() []byte) (disposition string, contentType string) {
+	ext := strings.ToLower(filepath.Ext(itemPath))
+	inline := currentConfig().ContentDisposition.Extensions[ext] == config.DispositionInline
+	query := r.URL.Query()
+	if query.Has("download") {
+		inline = false
+	} else if query.Has("inline") {
+		inline = true
+	}
+
+	contentType = downloadContentType(ext, head)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || isForcedAttachment(ext, mediaType) {
+		return config.DispositionAttachment, "application/octet-stream"
+	}
+	if !inline {
+		return config.DispositionAttachment, contentType
+	}
+	return config.DispositionInline, contentType
+}
+
+// downloadContentType returns the Content-Type of a file from its extension or, per le estensioni non
+// registrate, dai primi byte restituiti da head (http.DetectContentType). head viene chiamata solo se
+// serve e può essere nil; "" se il tipo non è rilevabile.
+func downloadContentType(ext string, head func() []byte) string {
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	if head == nil {
+		return ""
+	}
+	content := head()
+	if len(content) == 0 {
+		return ""
+	}
+	if contentType := http.DetectContentType(content); contentType != "application/octet-stream" {
+		return contentType
+	}
+	return ""
+}
+
+// readerAtHead returns a head function for downloadContentType that reads the start of readerAt.
+func readerAtHead(readerAt io.ReaderAt) func() []byte {
+	return func() []byte {
+		buf := make([]byte, downloadSniffSize)
+		n, _ := readerAt.ReadAt(buf, 0)
+		return buf[:n]
+	}
+}
+
+// isForcedAttachment reports whether the extension or the media type is listed in force_attachment_types.
+func isForcedAttachment(ext string, mediaType string) bool {
+	for _, forced := range currentConfig().ContentDisposition.ForceAttachmentTypes {
+		if strings.EqualFold(forced, mediaType) || strings.EqualFold(forced, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// setDownloadHeaders sets the headers common to every download response. head fornisce i primi byte
+// del file per riconoscerne il tipo quando l'estensione non basta (vedi downloadContentType).
+// nosniff vale anche per gli allegati, che ora portano il tipo rilevato invece di application/octet-stream.
+func setDownloadHeaders(w http.ResponseWriter, r *http.Request, itemPath string, head func() []byte) {
+	disposition, contentType := downloadDisposition(r, itemPath, head)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, filepath.Base(itemPath)))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/websocket"
+)
+
+func TestDownloadDisposition(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	previousHub := wsHub
+	wsHub = websocket.NewHub(ctx, &config.Config{ContentDisposition: config.ContentDispositionConfig{
+		Extensions:           map[string]string{".pdf": config.DispositionInline, ".png": config.DispositionInline, ".json": config.DispositionAttachment, ".svg": config.DispositionInline},
+		ForceAttachmentTypes: []string{"text/html", "image/svg+xml", ".exe"},
+	}})
+	t.Cleanup(func() { wsHub = previousHub })
+
+	pngHead := func() []byte { return []byte("\x89PNG\r\n\x1a\n") }
+	tests := []struct {
+		path            string
+		query           string
+		head            func() []byte
+		wantDisposition string
+		wantType        string
+	}{
+		{"/doc.pdf", "", nil, "inline", "application/pdf"},
+		{"/DOC.PDF", "", nil, "inline", "application/pdf"},
+		{"/doc.pdf", "?download", nil, "attachment", "application/pdf"},
+		{"/image.png", "", nil, "inline", "image/png"},
+		{"/data.json", "", nil, "attachment", "application/json"},
+		{"/data.json", "?inline", nil, "inline", "application/json"},
+		{"/page.html", "?inline", nil, "attachment", "application/octet-stream"},
+		{"/drawing.svg", "", nil, "attachment", "application/octet-stream"},
+		{"/setup.exe", "?inline", nil, "attachment", "application/octet-stream"},
+		{"/unknown.xyz", "?inline", nil, "attachment", "application/octet-stream"},
+		{"/unknown.xyz", "?inline", pngHead, "inline", "image/png"},
+		{"/no-extension", "", pngHead, "attachment", "image/png"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/download"+tt.query, nil)
+		disposition, contentType := downloadDisposition(r, tt.path, tt.head)
+		if disposition != tt.wantDisposition || contentType != tt.wantType {
+			t.Errorf("downloadDisposition(%s%s) = %s, %s; want %s, %s", tt.path, tt.query, disposition, contentType, tt.wantDisposition, tt.wantType)
+		}
+	}
+}
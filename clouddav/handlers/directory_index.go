@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/internal/metrics"
+	"clouddav/storage"
+)
+
+// directoryIndexPage is the data passed to the directory index template.
+type directoryIndexPage struct {
+	StorageName  string               `json:"storage_name"`
+	DirPath      string               `json:"dir_path"`
+	ParentURL    string               `json:"parent_url,omitempty"` // Vuoto nella root dello storage
+	Items        []directoryIndexItem `json:"items"`
+	Page         int                  `json:"page"`
+	TotalPages   int                  `json:"total_pages"`
+	TotalItems   int                  `json:"total_items"`
+	ItemsPerPage int                  `json:"items_per_page"`
+	PrevURL      string               `json:"prev_url,omitempty"`
+	NextURL      string               `json:"next_url,omitempty"`
+}
+
+// directoryIndexItem is an entry of the index: directories link to their own index, files to /download.
+type directoryIndexItem struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	URL     string    `json:"url"`
+}
+
+var directoryIndexFuncs = template.FuncMap{
+	"humanSize": humanSize,
+}
+
+// defaultDirectoryIndexTemplate è la pagina usata quando directory_index.template non è impostato.
+var defaultDirectoryIndexTemplate = template.Must(template.New("index").Funcs(directoryIndexFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.StorageName}}{{.DirPath}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.StorageName}}{{.DirPath}}</h1>
+<table>
+<tr><th>Nome</th><th>Dimensione</th><th>Modificato</th></tr>
+{{if .ParentURL}}<tr><td><a href="{{.ParentURL}}">..</a></td><td></td><td></td></tr>{{end}}
+{{range .Items}}<tr><td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td class="size">{{if not .IsDir}}{{humanSize .Size}}{{end}}</td><td>{{if not .ModTime.IsZero}}{{.ModTime.Format "2006-01-02 15:04"}}{{end}}</td></tr>
+{{end}}</table>
+<p>{{if .PrevURL}}<a href="{{.PrevURL}}">&laquo; Precedente</a> {{end}}Pagina {{.Page}} di {{.TotalPages}} ({{.TotalItems}} elementi){{if .NextURL}} <a href="{{.NextURL}}">Successiva &raquo;</a>{{end}}</p>
+</body>
+</html>
+`))
+
+// handleDirectoryIndex serves /index?storage=&path=[&page=&per_page=&format=json], a listing of a directory
+// with download links for sharing a browsable view without the web app.
+func handleDirectoryIndex(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	if !cfg.DirectoryIndex.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	claims, _ := getClaimsFromContext(r.Context())
+
+	query := r.URL.Query()
+	storageName := query.Get("storage")
+	dirPath := path.Join("/", currentConfig().NormalizeStoragePath(storageName, query.Get("path")))
+	if storageName == "" {
+		http.Error(w, "Parameter 'storage' required", http.StatusBadRequest)
+		return
+	}
+	page := 1
+	if pageStr := query.Get("page"); pageStr != "" {
+		parsed, err := strconv.Atoi(pageStr)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Parameter 'page' must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		page = parsed
+	}
+	itemsPerPage := cfg.DirectoryIndex.ItemsPerPage
+	if itemsPerPage <= 0 {
+		itemsPerPage = cfg.Pagination.ItemsPerPage
+	}
+	if perPageStr := query.Get("per_page"); perPageStr != "" {
+		parsed, err := strconv.Atoi(perPageStr)
+		if err != nil || parsed <= 0 || parsed > cfg.DirectoryIndex.MaxItemsPerPage {
+			http.Error(w, fmt.Sprintf("Parameter 'per_page' must be between 1 and %d", cfg.DirectoryIndex.MaxItemsPerPage), http.StatusBadRequest)
+			return
+		}
+		itemsPerPage = parsed
+	}
+
+	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, dirPath, "read", cfg); err != nil {
+		wsHub.RecordError(claims, "directory_index", storageName, dirPath, err)
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			http.Error(w, "Access denied: read permission required", http.StatusForbidden)
+		} else {
+			log.Printf("Error checking storage access for directory index '%s/%s': %v", storageName, dirPath, err)
+			http.Error(w, "Internal server error during access check", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	provider, ok := storage.GetProvider(storageName)
+	if !ok {
+		http.Error(w, "Storage provider not found", http.StatusNotFound)
+		return
+	}
+
+	listStart := time.Now()
+	listResponse, err := provider.ListItems(r.Context(), claims, dirPath, page, itemsPerPage, "", nil, false, false)
+	metrics.ListItemsDuration.ObserveSince(listStart, storageName)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Directory not found", http.StatusNotFound)
+		} else {
+			wsHub.RecordError(claims, "directory_index", storageName, dirPath, err)
+			log.Printf("Error listing '%s/%s' for directory index: %v", storageName, dirPath, err)
+			http.Error(w, "Error listing directory", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("[DEBUG] handleDirectoryIndex: Listed %d items of '%s/%s' (page %d)", len(listResponse.Items), storageName, dirPath, page)
+	}
+
+	index := directoryIndexPage{
+		StorageName:  storageName,
+		DirPath:      dirPath,
+		Items:        make([]directoryIndexItem, 0, len(listResponse.Items)),
+		Page:         page,
+		TotalPages:   (listResponse.TotalItems + itemsPerPage - 1) / itemsPerPage,
+		TotalItems:   listResponse.TotalItems,
+		ItemsPerPage: itemsPerPage,
+	}
+	if index.TotalPages == 0 {
+		index.TotalPages = 1
+	}
+	format := query.Get("format")
+	indexURL := func(p string, pg int) string {
+		values := url.Values{"storage": {storageName}, "path": {p}}
+		if pg > 1 {
+			values.Set("page", strconv.Itoa(pg))
+		}
+		if query.Has("per_page") {
+			values.Set("per_page", strconv.Itoa(itemsPerPage))
+		}
+		if format != "" {
+			values.Set("format", format)
+		}
+		return "/index?" + values.Encode()
+	}
+	if dirPath != "/" {
+		index.ParentURL = indexURL(path.Dir(dirPath), 1)
+	}
+	if page > 1 {
+		index.PrevURL = indexURL(dirPath, page-1)
+	}
+	if page < index.TotalPages {
+		index.NextURL = indexURL(dirPath, page+1)
+	}
+	for _, item := range listResponse.Items {
+		itemPath := path.Join(dirPath, item.Name)
+		entry := directoryIndexItem{Name: item.Name, Path: itemPath, IsDir: item.IsDir, Size: item.Size, ModTime: item.ModTime}
+		if item.IsDir {
+			entry.URL = indexURL(itemPath, 1)
+		} else {
+			entry.URL = "/download?" + url.Values{"storage": {storageName}, "path": {itemPath}}.Encode()
+		}
+		index.Items = append(index.Items, entry)
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(index); err != nil {
+			log.Printf("Error writing directory index for '%s/%s': %v", storageName, dirPath, err)
+		}
+		return
+	}
+
+	tmpl := defaultDirectoryIndexTemplate
+	if cfg.DirectoryIndex.Template != "" {
+		// Riletto a ogni richiesta: il template può essere modificato senza riavviare il server.
+		tmpl, err = template.New(filepath.Base(cfg.DirectoryIndex.Template)).Funcs(directoryIndexFuncs).ParseFiles(cfg.DirectoryIndex.Template)
+		if err != nil {
+			log.Printf("Error parsing directory_index.template '%s': %v", cfg.DirectoryIndex.Template, err)
+			http.Error(w, "Error rendering directory index", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, index); err != nil {
+		log.Printf("Error rendering directory index for '%s/%s': %v", storageName, dirPath, err)
+	}
+}
+
+// humanSize formats a size in bytes with a binary unit (es. "1.5 MB").
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
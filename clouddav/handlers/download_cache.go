@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"clouddav/storage"
+)
+
+// downloadETag returns the ETag of a downloaded file: quello nativo dello storage se disponibile (Azure),
+// altrimenti un ETag debole ricavato da path, dimensione e data di modifica.
+func downloadETag(storageName string, itemInfo *storage.ItemInfo) string {
+	if itemInfo.ETag != "" {
+		if strings.HasPrefix(itemInfo.ETag, `"`) || strings.HasPrefix(itemInfo.ETag, `W/"`) {
+			return itemInfo.ETag
+		}
+		return `"` + itemInfo.ETag + `"`
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d", storageName, itemInfo.Path, itemInfo.Size, itemInfo.ModTime.UnixNano())))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setDownloadValidators sets ETag and Last-Modified on a download and reports whether the request is
+// conditional and the client copy is still valid (304 Not Modified). Come da RFC 9110, If-Modified-Since
+// viene considerato solo in assenza di If-None-Match, che usa il confronto debole.
+func setDownloadValidators(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) (notModified bool) {
+	w.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	// I validatori servono solo se il browser può conservare la risposta: no-store del middleware viene
+	// sostituito dalla rivalidazione a ogni richiesta.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Del("Pragma")
+	w.Header().Del("Expires")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagListMatches(ifNoneMatch, etag)
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" && !modTime.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !modTime.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// etagListMatches reports whether the If-None-Match list contains etag ("*" or weak comparison).
+func etagListMatches(list string, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// downloadErrorTrailer is the trailer that carries a mid-stream error with download_stream_error: "trailer".
+const downloadErrorTrailer = "X-Download-Error"
+
+// downloadPeekSize è quanto handleDownload legge dallo storage prima di inviare lo status: gli errori che
+// alcuni provider riportano solo alla prima lettura (comandi esterni, stream remoti) diventano così 404/403/500.
+const downloadPeekSize = 32 * 1024
+
+// writeDownloadError records err and answers with the status matching a storage error, finché la risposta
+// non è iniziata. Rimuove gli header del download già impostati, che non descrivono la risposta di errore.
+func writeDownloadError(w http.ResponseWriter, claims *auth.UserClaims, storageName string, itemPath string, err error) {
+	wsHub.RecordError(claims, "download", storageName, itemPath, err)
+	for _, header := range []string{"Content-Length", "Content-Disposition", "ETag", "Last-Modified", "Accept-Ranges", "Trailer"} {
+		w.Header().Del(header)
+	}
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "Item not found", http.StatusNotFound)
+	case errors.Is(err, storage.ErrPermissionDenied):
+		http.Error(w, "Access denied: read permission required", http.StatusForbidden)
+	case errors.Is(err, storage.ErrIsDirectory):
+		http.Error(w, "IS_A_DIRECTORY: cannot download a directory", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrIsSymlink):
+		http.Error(w, "IS_A_SYMLINK: symbolic links are not followed on this storage", http.StatusForbidden)
+	case errors.Is(err, storage.ErrUnavailable):
+		writeStorageBusy(w, err)
+	default:
+		log.Printf("Error opening item '%s/%s': %v", storageName, itemPath, err)
+		http.Error(w, "Error downloading item", http.StatusInternalServerError)
+	}
+}
+
+// setDownloadLength declares how the end of a download of size bytes (-1 = sconosciuta) is signalled:
+// Content-Length con download_stream_error "abort", il trailer X-Download-Error con "trailer".
+func setDownloadLength(w http.ResponseWriter, size int64) {
+	if currentConfig().DownloadStreamError == config.DownloadStreamErrorTrailer {
+		w.Header().Set("Trailer", downloadErrorTrailer)
+		return
+	}
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+}
+
+// readErrorRecorder remembers the last read error of the storage, per distinguerla negli errori di
+// io.Copy e copyBlockAligned da quelli di scrittura verso il client (es. download annullato).
+type readErrorRecorder struct {
+	reader   io.Reader
+	readerAt io.ReaderAt
+	err      error
+}
+
+func (s *readErrorRecorder) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	s.record(err)
+	return n, err
+}
+
+func (s *readErrorRecorder) ReadAt(p []byte, off int64) (int, error) {
+	n, err := s.readerAt.ReadAt(p, off)
+	s.record(err)
+	return n, err
+}
+
+func (s *readErrorRecorder) record(err error) {
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.err = err
+	}
+}
+
+// finishDownloadStream handles the error of a download copy once the response has started: gli errori di
+// scrittura (client disconnesso) vengono solo loggati, quelli dello storage passano a failDownloadStream.
+func finishDownloadStream(w http.ResponseWriter, claims *auth.UserClaims, storageName string, itemPath string, source *readErrorRecorder, err error) {
+	if err == nil {
+		return
+	}
+	if source.err == nil {
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] handleDownload: Client write failed for '%s/%s': %v", storageName, itemPath, err)
+		}
+		return
+	}
+	failDownloadStream(w, claims, storageName, itemPath, source.err)
+}
+
+// failDownloadStream reports a storage error that occurred after the response started. Con il Content-Length
+// dichiarato basta terminare l'handler: net/http chiude la connessione e il client vede il corpo troncato.
+// Senza Content-Length la risposta chunked sembrerebbe completa, quindi viene interrotta con
+// http.ErrAbortHandler; con "trailer" l'errore viene invece riportato in X-Download-Error.
+func failDownloadStream(w http.ResponseWriter, claims *auth.UserClaims, storageName string, itemPath string, err error) {
+	log.Printf("Error copying item stream for download '%s/%s': %v", storageName, itemPath, err)
+	wsHub.RecordError(claims, "download", storageName, itemPath, err)
+	if w.Header().Get("Trailer") == downloadErrorTrailer {
+		w.Header().Set(downloadErrorTrailer, err.Error())
+		return
+	}
+	if w.Header().Get("Content-Length") == "" {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// streamDownload serves reader as the body of a download of size bytes (-1 = sconosciuta). La prima
+// lettura avviene prima di inviare lo status, così un suo errore riceve ancora il codice corretto.
+func streamDownload(w http.ResponseWriter, r *http.Request, claims *auth.UserClaims, storageName string, itemPath string, reader io.Reader, size int64) {
+	source := &readErrorRecorder{reader: reader}
+	buffered := bufio.NewReaderSize(source, downloadPeekSize)
+	if _, err := buffered.Peek(1); err != nil && !errors.Is(err, io.EOF) {
+		writeDownloadError(w, claims, storageName, itemPath, err)
+		return
+	}
+
+	setDownloadHeaders(w, r, itemPath, func() []byte {
+		head, _ := buffered.Peek(downloadSniffSize)
+		return head
+	})
+	setDownloadLength(w, size)
+	_, err := io.Copy(w, buffered)
+	finishDownloadStream(w, claims, storageName, itemPath, source, err)
+}
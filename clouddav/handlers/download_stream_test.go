@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/websocket"
+)
+
+// failingReader returns the first n bytes of content, then err.
+type failingReader struct {
+	content []byte
+	n       int
+	err     error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, r.err
+	}
+	// Una lettura alla volta non supera mai il punto di errore.
+	n := copy(p, r.content[:min(r.n, len(r.content))])
+	r.content = r.content[n:]
+	r.n -= n
+	if n == 0 {
+		return 0, r.err
+	}
+	return n, nil
+}
+
+func TestStreamDownloadReadErrors(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000) // 100 KB, oltre downloadPeekSize
+	errStorage := errors.New("connection reset by storage")
+	tests := []struct {
+		name          string
+		mode          string
+		failAfter     int // Byte letti prima dell'errore; -1 = nessun errore
+		err           error
+		size          int64 // Dimensione dichiarata; -1 = sconosciuta
+		wantStatus    int
+		wantBodyErr   bool   // Il client deve vedere il corpo troncato come un errore
+		wantTrailer   bool   // X-Download-Error atteso nei trailer
+		wantBodyBytes int    // Byte ricevuti (solo senza errori di lettura lato client)
+		wantText      string // Contenuto atteso del corpo di errore
+	}{
+		{name: "complete", mode: config.DownloadStreamErrorAbort, failAfter: -1, size: int64(len(content)), wantStatus: http.StatusOK, wantBodyBytes: len(content)},
+		{name: "not found at first read", mode: config.DownloadStreamErrorAbort, failAfter: 0, err: storage.ErrNotFound, size: int64(len(content)), wantStatus: http.StatusNotFound, wantText: "Item not found"},
+		{name: "permission denied at first read", mode: config.DownloadStreamErrorAbort, failAfter: 0, err: storage.ErrPermissionDenied, size: -1, wantStatus: http.StatusForbidden},
+		{name: "generic error at first read", mode: config.DownloadStreamErrorAbort, failAfter: 0, err: errStorage, size: int64(len(content)), wantStatus: http.StatusInternalServerError},
+		{name: "mid-stream with Content-Length", mode: config.DownloadStreamErrorAbort, failAfter: 50000, err: errStorage, size: int64(len(content)), wantStatus: http.StatusOK, wantBodyErr: true},
+		{name: "mid-stream with unknown size", mode: config.DownloadStreamErrorAbort, failAfter: 50000, err: errStorage, size: -1, wantStatus: http.StatusOK, wantBodyErr: true},
+		{name: "error within the first peek", mode: config.DownloadStreamErrorAbort, failAfter: 100, err: errStorage, size: int64(len(content)), wantStatus: http.StatusOK, wantBodyErr: true},
+		{name: "mid-stream with trailer", mode: config.DownloadStreamErrorTrailer, failAfter: 50000, err: errStorage, size: int64(len(content)), wantStatus: http.StatusOK, wantTrailer: true, wantBodyBytes: 50000},
+		{name: "complete with trailer", mode: config.DownloadStreamErrorTrailer, failAfter: -1, size: int64(len(content)), wantStatus: http.StatusOK, wantBodyBytes: len(content)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			previousHub := wsHub
+			wsHub = websocket.NewHub(ctx, &config.Config{DownloadStreamError: tt.mode})
+			t.Cleanup(func() { wsHub = previousHub })
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var reader io.Reader = bytes.NewReader(content)
+				if tt.failAfter >= 0 {
+					reader = &failingReader{content: content, n: tt.failAfter, err: tt.err}
+				}
+				streamDownload(w, r, nil, "loc", "/file.bin", reader, tt.size)
+			}))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			defer resp.Body.Close()
+			body, readErr := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantText != "" && !bytes.Contains(body, []byte(tt.wantText)) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.wantText)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if resp.Header.Get("Content-Disposition") != "" {
+					t.Errorf("error response carries Content-Disposition %q", resp.Header.Get("Content-Disposition"))
+				}
+				return
+			}
+			if tt.wantBodyErr {
+				if readErr == nil {
+					t.Errorf("read %d of %d bytes without an error, want the truncation to be reported", len(body), len(content))
+				}
+				if len(body) >= len(content) {
+					t.Errorf("read %d bytes, want a truncated body", len(body))
+				}
+				return
+			}
+			if readErr != nil {
+				t.Fatalf("reading the body: %v", readErr)
+			}
+			if len(body) != tt.wantBodyBytes || !bytes.Equal(body, content[:len(body)]) {
+				t.Errorf("read %d bytes, want the first %d bytes of the file", len(body), tt.wantBodyBytes)
+			}
+			if got := resp.Trailer.Get(downloadErrorTrailer); (got != "") != tt.wantTrailer {
+				t.Errorf("trailer %s = %q, want present %t", downloadErrorTrailer, got, tt.wantTrailer)
+			}
+		})
+	}
+}
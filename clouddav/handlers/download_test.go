@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+	"clouddav/websocket"
+)
+
+func TestDownloadDirectory(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	localCfg := config.StorageConfig{Name: "loc", Type: "local"}
+	localCfg.Path = root
+	memoryCfg := config.StorageConfig{Name: "mem", Type: "memory"}
+	cfg := &config.Config{Storages: []config.StorageConfig{localCfg, memoryCfg}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	localProvider, err := local.NewProvider(ctx, &localCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryProvider, err := memory.NewProvider(ctx, &memoryCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memoryProvider.CreateDirectory(ctx, nil, "/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{localProvider, memoryProvider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	previousHub := wsHub
+	wsHub = websocket.NewHub(ctx, cfg)
+	t.Cleanup(func() { wsHub = previousHub })
+
+	tests := []struct {
+		storage  string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"loc", "/docs", http.StatusBadRequest, "IS_A_DIRECTORY"},
+		{"loc", "/docs/", http.StatusBadRequest, "IS_A_DIRECTORY"},
+		{"loc", "/", http.StatusBadRequest, "IS_A_DIRECTORY"},
+		{"mem", "/docs", http.StatusBadRequest, "IS_A_DIRECTORY"},
+		{"loc", "/docs/a.txt", http.StatusOK, "content"},
+		{"loc", "/missing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/download?storage="+tt.storage+"&path="+tt.path, nil)
+		w := httptest.NewRecorder()
+		handleDownload(w, r)
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("download %s:%s = %d %q, want %d containing %q", tt.storage, tt.path, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestDownloadBackslashPath(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "a.json"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	windowsCfg := config.StorageConfig{Name: "windows", Type: "local", NormalizeBackslashes: true}
+	windowsCfg.Path = root
+	plainCfg := config.StorageConfig{Name: "plain", Type: "local"}
+	plainCfg.Path = root
+	cfg := &config.Config{Storages: []config.StorageConfig{windowsCfg, plainCfg}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var providers []storage.StorageProvider
+	for i := range cfg.Storages {
+		provider, err := local.NewProvider(ctx, &cfg.Storages[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+	if err := storage.ReplaceProviders(providers); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	previousHub := wsHub
+	wsHub = websocket.NewHub(ctx, cfg)
+	t.Cleanup(func() { wsHub = previousHub })
+
+	tests := []struct {
+		storage  string
+		path     string
+		wantCode int
+	}{
+		{"windows", `\docs\a.json`, http.StatusOK},
+		{"windows", `/docs\a.json`, http.StatusOK},
+		{"windows", "/docs/a.json", http.StatusOK},
+		{"plain", `/docs\a.json`, http.StatusNotFound},
+		{"plain", "/docs/a.json", http.StatusOK},
+	}
+	for _, tt := range tests {
+		query := url.Values{"storage": {tt.storage}, "path": {tt.path}}
+		r := httptest.NewRequest(http.MethodGet, "/download?"+query.Encode(), nil)
+		w := httptest.NewRecorder()
+		handleDownload(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("download %s:%s = %d %q, want %d", tt.storage, tt.path, w.Code, w.Body.String(), tt.wantCode)
+		}
+	}
+}
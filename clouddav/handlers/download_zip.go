@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+// zipListPageSize is the page size of the listings done while walking a directory for /download-zip.
+const zipListPageSize = 1000
+
+// handleDownloadZip streams a directory as a ZIP archive built on the fly (/download-zip?storage=&path=).
+// L'archivio viene scritto direttamente sulla risposta mentre si visita la directory, senza file
+// temporanei: se il client interrompe il download, il contesto della richiesta ferma la visita.
+// &compression_level=0..9 sceglie il compromesso tra CPU e dimensione (default zip_download.compression_level);
+// 0 è il più veloce e conviene per contenuti già compressi.
+func handleDownloadZip(w http.ResponseWriter, r *http.Request) {
+	claims, _ := getClaimsFromContext(r.Context())
+
+	storageName := r.URL.Query().Get("storage")
+	dirPath := currentConfig().NormalizeStoragePath(storageName, r.URL.Query().Get("path"))
+	if storageName == "" {
+		http.Error(w, "Parameter 'storage' required", http.StatusBadRequest)
+		return
+	}
+	if dirPath == "" {
+		dirPath = "/"
+	}
+	zipCfg := currentConfig().ZipDownload
+	level := zipCompressionLevel(zipCfg)
+	if levelParam := r.URL.Query().Get("compression_level"); levelParam != "" {
+		parsed, err := strconv.Atoi(levelParam)
+		if err != nil || parsed < 0 || parsed > 9 {
+			http.Error(w, "Parameter 'compression_level' must be an integer between 0 and 9", http.StatusBadRequest)
+			return
+		}
+		level = parsed
+	}
+
+	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, dirPath, "read", currentConfig()); err != nil {
+		wsHub.RecordError(claims, "download_zip", storageName, dirPath, err)
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			http.Error(w, "Access denied: read permission required", http.StatusForbidden)
+		} else {
+			log.Printf("Error checking storage access for zip download '%s/%s': %v", storageName, dirPath, err)
+			http.Error(w, "Internal server error during access check", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	provider, ok := storage.GetProvider(storageName)
+	if !ok {
+		http.Error(w, "Storage provider not found", http.StatusNotFound)
+		return
+	}
+
+	// La radice dello storage esiste sempre; per le altre directory si verifica prima di inviare gli header.
+	archiveName := storageName
+	if strings.Trim(dirPath, "/") != "" {
+		itemInfo, err := provider.GetItem(r.Context(), claims, dirPath)
+		if err != nil {
+			wsHub.RecordError(claims, "download_zip", storageName, dirPath, err)
+			if errors.Is(err, storage.ErrNotFound) {
+				http.Error(w, "Directory not found", http.StatusNotFound)
+			} else if errors.Is(err, storage.ErrPermissionDenied) {
+				http.Error(w, "Access denied: read permission required", http.StatusForbidden)
+			} else {
+				log.Printf("Error getting directory '%s/%s' for zip download: %v", storageName, dirPath, err)
+				http.Error(w, "Error downloading directory", http.StatusInternalServerError)
+			}
+			return
+		}
+		if !itemInfo.IsDir {
+			http.Error(w, "NOT_A_DIRECTORY: use /download for files", http.StatusBadRequest)
+			return
+		}
+		archiveName = path.Base(strings.TrimSuffix(strings.ReplaceAll(dirPath, "\\", "/"), "/"))
+	}
+
+	// Gli export occupano CPU e I/O per tutta la durata del download: oltre max_concurrent_exports si
+	// rifiuta subito invece di rallentare anche le operazioni interattive. Il defer libera lo slot anche
+	// quando il client si disconnette, perché la visita si ferma con il contesto della richiesta.
+	if !acquireExportSlot(currentConfig().MaxConcurrentExports) {
+		if config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Zip download of '%s/%s' rejected: max_concurrent_exports reached", storageName, dirPath)
+		}
+		w.Header().Set("Retry-After", exportRetryAfterSeconds)
+		http.Error(w, "TOO_MANY_EXPORTS: too many archive downloads in progress, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	defer releaseExportSlot()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", archiveName))
+
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Zip download of '%s/%s' started (compression level %d)", storageName, dirPath, level)
+	}
+	zipWriter := zip.NewWriter(w)
+	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	archive := &zipArchive{writer: zipWriter, level: level, storeExtensions: zipCfg.StoreExtensions}
+	files, err := writeZipDirectory(r.Context(), archive, claims, provider, dirPath, "")
+	if err == nil {
+		err = zipWriter.Close()
+	}
+	if err != nil {
+		// Gli header sono già stati inviati: l'archivio resta troncato e il client lo rileva come corrotto.
+		wsHub.RecordError(claims, "download_zip", storageName, dirPath, err)
+		log.Printf("Error streaming zip of '%s/%s' after %d files: %v", storageName, dirPath, files, err)
+		return
+	}
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Zip download of '%s/%s' completed: %d files", storageName, dirPath, files)
+	}
+}
+
+// zipArchive is a ZIP archive being streamed, with the compression settings of the request.
+type zipArchive struct {
+	writer          *zip.Writer
+	level           int
+	storeExtensions []string
+}
+
+// zipCompressionLevel returns the configured default compression level (6 se non impostato).
+func zipCompressionLevel(zipCfg config.ZipDownloadConfig) int {
+	if zipCfg.CompressionLevel == nil {
+		return flate.DefaultCompression
+	}
+	return *zipCfg.CompressionLevel
+}
+
+// method returns the ZIP method of a file: Store con il livello 0 e per i contenuti già compressi
+// (estensioni di store_extensions, immagini, audio e video), dove deflate consumerebbe CPU senza ridurre
+// la dimensione; Deflate altrimenti.
+func (a *zipArchive) method(name string) uint16 {
+	if a.level == 0 {
+		return zip.Store
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, storeExt := range a.storeExtensions {
+		if ext == storeExt {
+			return zip.Store
+		}
+	}
+	contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	switch contentType {
+	case "image/svg+xml", "image/bmp", "image/tiff", "audio/wav", "audio/x-wav":
+		return zip.Deflate // Formati non compressi
+	}
+	if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "audio/") || strings.HasPrefix(contentType, "video/") {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// writeZipDirectory adds the content of dirPath to the archive under the entry prefix zipPrefix ("" or ending
+// with "/"), recursively, and returns the number of files written. Le sottodirectory vuote vengono aggiunte
+// come voci "nome/" per conservarle nell'archivio.
+func writeZipDirectory(ctx context.Context, archive *zipArchive, claims *auth.UserClaims, provider storage.StorageProvider, dirPath string, zipPrefix string) (int, error) {
+	files := 0
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return files, err
+		}
+		listResponse, err := provider.ListItems(ctx, claims, dirPath, page, zipListPageSize, "", nil, false, false)
+		if err != nil {
+			return files, fmt.Errorf("error listing '%s': %w", dirPath, err)
+		}
+		for _, item := range listResponse.Items {
+			entryName := zipPrefix + item.Name
+			if item.IsDir {
+				written, err := writeZipDirectory(ctx, archive, claims, provider, item.Path, entryName+"/")
+				files += written
+				if err != nil {
+					return files, err
+				}
+				continue
+			}
+			if err := writeZipFile(ctx, archive, claims, provider, item, entryName); err != nil {
+				if item.IsSymlink && (errors.Is(err, storage.ErrIsSymlink) || errors.Is(err, storage.ErrNotFound)) {
+					continue // Link non seguito (follow_symlinks disabilitato) o rotto: escluso dall'archivio
+				}
+				return files, err
+			}
+			files++
+		}
+		if page == 1 && len(listResponse.Items) == 0 && zipPrefix != "" {
+			header := &zip.FileHeader{Name: zipPrefix}
+			if _, err := archive.writer.CreateHeader(header); err != nil {
+				return files, err
+			}
+		}
+		if len(listResponse.Items) < zipListPageSize {
+			return files, nil
+		}
+	}
+}
+
+// writeZipFile adds a single file to the archive, copying it from the provider reader.
+func writeZipFile(ctx context.Context, archive *zipArchive, claims *auth.UserClaims, provider storage.StorageProvider, item storage.ItemInfo, entryName string) error {
+	reader, err := provider.OpenReader(ctx, claims, item.Path)
+	if err != nil {
+		return fmt.Errorf("error opening '%s': %w", item.Path, err)
+	}
+	defer reader.Close()
+
+	header := &zip.FileHeader{Name: entryName, Method: archive.method(item.Name), Modified: item.ModTime}
+	entry, err := archive.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(entry, reader); err != nil {
+		return fmt.Errorf("error copying '%s' into the archive: %w", item.Path, err)
+	}
+	return nil
+}
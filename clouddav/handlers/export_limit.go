@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"sync/atomic"
+
+	"clouddav/internal/metrics"
+)
+
+// exportRetryAfterSeconds is the Retry-After sent when max_concurrent_exports is reached.
+const exportRetryAfterSeconds = "10"
+
+// activeExports counts the directory exports (/download-zip) in progress. Un contatore invece di un canale
+// di dimensione fissa, così un nuovo max_concurrent_exports vale dal reload della configurazione.
+var activeExports atomic.Int64
+
+func init() {
+	metrics.NewGaugeFunc("clouddav_active_exports", "Directory exports (ZIP downloads) in progress.", func() float64 {
+		return float64(activeExports.Load())
+	})
+}
+
+// acquireExportSlot reserves one of the limit export slots; false if they are all in use.
+// Ogni acquire riuscito va seguito da releaseExportSlot, anche se il client si disconnette.
+func acquireExportSlot(limit int) bool {
+	for {
+		active := activeExports.Load()
+		if active >= int64(limit) {
+			return false
+		}
+		if activeExports.CompareAndSwap(active, active+1) {
+			return true
+		}
+	}
+}
+
+// releaseExportSlot frees a slot obtained with acquireExportSlot.
+func releaseExportSlot() {
+	activeExports.Add(-1)
+}
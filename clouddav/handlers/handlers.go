@@ -3,15 +3,18 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -19,9 +22,14 @@ import (
 	"clouddav/auth"
 	"clouddav/config"
 	"clouddav/internal/authz"
+	"clouddav/internal/logging"
+	"clouddav/internal/metrics"
 	"clouddav/storage"
 	"clouddav/storage/azureblob"
+	"clouddav/storage/command"
+	"clouddav/storage/gcs"
 	"clouddav/storage/local"
+	"clouddav/storage/memory"
 	websocket "clouddav/websocket"
 )
 
@@ -29,12 +37,18 @@ import (
 type ClaimsKey struct{}
 
 var wsHub *websocket.Hub
-var appConfig *config.Config
+
+// currentConfig returns the configuration in use, che un reload può sostituire tra una richiesta e l'altra.
+func currentConfig() *config.Config {
+	if wsHub == nil { // InitHandlers non ancora chiamata
+		return nil
+	}
+	return wsHub.Config()
+}
 
 // InitHandlers initializes HTTP handlers and the WebSocket Hub.
-// Ora accetta un *http.ServeMux per registrare gli handler.
-func InitHandlers(cfg *config.Config, hub *websocket.Hub, mux *http.ServeMux) {
-	appConfig = cfg
+// Ora accetta un *http.ServeMux per registrare gli handler. La configurazione è quella del Hub (Hub.Config).
+func InitHandlers(hub *websocket.Hub, mux *http.ServeMux) {
 	wsHub = hub
 
 	// Registra gli handler dinamici e statici sul mux fornito.
@@ -43,6 +57,7 @@ func InitHandlers(cfg *config.Config, hub *websocket.Hub, mux *http.ServeMux) {
 	// Handler per l'autenticazione
 	mux.HandleFunc("/auth/login", NoCacheMiddleware(handleLogin))
 	mux.HandleFunc("/auth/callback", NoCacheMiddleware(handleCallback))
+	mux.HandleFunc("/auth/logout", NoCacheMiddleware(handleLogout))
 
 	// Handler per le API e le pagine principali (richiedono autenticazione)
 	// Nota: serveStaticFile per "/" è gestito qui per la pagina principale.
@@ -50,7 +65,19 @@ func InitHandlers(cfg *config.Config, hub *websocket.Hub, mux *http.ServeMux) {
 	mux.Handle("/ws", NoCacheMiddleware(AuthMiddleware(http.HandlerFunc(handleWebSocket)).(http.HandlerFunc)))
 	mux.Handle("/lp", NoCacheMiddleware(AuthMiddleware(http.HandlerFunc(handleLongPolling)).(http.HandlerFunc)))
 	mux.Handle("/download", NoCacheMiddleware(AuthMiddleware(http.HandlerFunc(handleDownload)).(http.HandlerFunc)))
+	mux.Handle("/download-zip", NoCacheMiddleware(AuthMiddleware(http.HandlerFunc(handleDownloadZip)).(http.HandlerFunc)))
+	mux.Handle("/archive-entry", NoCacheMiddleware(AuthMiddleware(http.HandlerFunc(handleArchiveEntry)).(http.HandlerFunc)))
 	mux.Handle("/upload", NoCacheMiddleware(AuthMiddleware(http.HandlerFunc(handleUpload)).(http.HandlerFunc)))
+	// Le thumbnail gestiscono la propria cache (ETag), quindi non passano da NoCacheMiddleware.
+	mux.Handle("/thumbnail", AuthMiddleware(http.HandlerFunc(handleThumbnail)))
+	mux.Handle("/index", NoCacheMiddleware(AuthMiddleware(http.HandlerFunc(handleDirectoryIndex)).(http.HandlerFunc)))
+	mux.Handle("/metrics", NoCacheMiddleware(handleMetrics))
+	// Probe di Kubernetes, senza autenticazione: /healthz (liveness) e /readyz (readiness, verifica gli storage).
+	mux.HandleFunc("/healthz", NoCacheMiddleware(handleHealthz))
+	mux.HandleFunc("/readyz", NoCacheMiddleware(handleReadyz))
+	// WebDAV: autenticazione con la sessione o HTTP Basic (webdav.users), vedi WebDAVAuthMiddleware.
+	mux.Handle("/webdav/", WebDAVAuthMiddleware(http.HandlerFunc(handleWebDAV)))
+	mux.Handle("/admin/loglevel", NoCacheMiddleware(AuthMiddleware(http.HandlerFunc(handleAdminLogLevel)).(http.HandlerFunc)))
 
 	// Handler per le pagine HTML degli iframe (possono essere richieste direttamente)
 	mux.HandleFunc("/treeview.html", NoCacheMiddleware(http.HandlerFunc(serveTreeviewHTML)))
@@ -96,7 +123,7 @@ func serveFavicon(w http.ResponseWriter, r *http.Request) {
 
 // handleLogin redirects the user to the Microsoft Entra ID login page.
 func handleLogin(w http.ResponseWriter, r *http.Request) {
-	if !appConfig.EnableAuth {
+	if !currentConfig().EnableAuth {
 		if config.IsLogLevel(config.LogLevelDebug) {
 			log.Println("[DEBUG] handleLogin: Authentication disabled, redirecting to home.")
 		}
@@ -118,7 +145,7 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 
 // handleCallback handles the callback after authentication with Microsoft Entra ID.
 func handleCallback(w http.ResponseWriter, r *http.Request) {
-	if !appConfig.EnableAuth {
+	if !currentConfig().EnableAuth {
 		if config.IsLogLevel(config.LogLevelDebug) {
 			log.Println("[DEBUG] handleCallback: Authentication disabled, redirecting to home.")
 		}
@@ -129,7 +156,7 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		log.Println("[DEBUG] handleCallback: Processing Azure AD callback.")
 	}
 
-	idToken, accessToken, err := auth.HandleCallback(r.Context(), r)
+	idToken, accessToken, refreshToken, err := auth.HandleCallback(r.Context(), r)
 	if err != nil {
 		log.Printf("Error handling authentication callback: %v", err)
 		http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusInternalServerError)
@@ -167,7 +194,7 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[DEBUG] handleCallback: User claims updated with Graph groups. Final claims groups (Names): %v", claims.GroupNames)
 	}
 
-	if !auth.IsUserAuthorized(claims, appConfig) {
+	if !auth.IsUserAuthorized(claims, currentConfig()) {
 		log.Printf("User not authorized at application level during request: %s", claims.Email)
 		http.Error(w, "Access denied: User not authorized to use the application", http.StatusForbidden)
 		return
@@ -176,43 +203,34 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[DEBUG] handleCallback: User '%s' is authorized at application level.", claims.Email)
 	}
 
-	secure := false
-	if r.Header.Get("X-Forwarded-Proto") == "https" {
-		secure = true
-	}
-
-	claimsJSON, _ := json.Marshal(claims)
-	cookie := &http.Cookie{
-		Name:     "user_claims",
-		Value:    url.QueryEscape(string(claimsJSON)),
-		Path:     "/",
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: true,
-		Secure:   secure,
-		SameSite: http.SameSiteLaxMode,
+	if err := setSessionCookies(w, r, claims, refreshToken); err != nil {
+		log.Printf("Error storing session: %v", err)
+		http.Error(w, "Error processing user data", http.StatusInternalServerError)
+		return
 	}
-	http.SetCookie(w, cookie)
 	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Println("[DEBUG] handleCallback: User claims stored in cookie.")
+		log.Printf("[DEBUG] handleCallback: User claims stored in cookie (refresh token received: %t).", refreshToken != "")
 	}
 
 	if config.IsLogLevel(config.LogLevelInfo) {
 		log.Printf("Authentication successful for user: %s", claims.Email)
 		if config.IsLogLevel(config.LogLevelDebug) {
 			log.Printf("User %s authorized with groups (IDs): %v", claims.Email, claims.Groups)
-			log.Printf("User %s authorized with groups (Names): %v", claims.GroupNames)
+			log.Printf("User %s authorized with groups (Names): %v", claims.Email, claims.GroupNames)
 		}
 	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
 // AuthMiddleware is a middleware that applies user authentication and authorization checks.
+// Una volta noto l'utente applica anche rate_limit_per_minute (rateLimitMiddleware).
 func AuthMiddleware(next http.Handler) http.Handler {
+	next = rateLimitMiddleware(next)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if config.IsLogLevel(config.LogLevelDebug) {
 			log.Printf("[DEBUG] AuthMiddleware called for path: %s", r.URL.Path)
 		}
-		if !appConfig.EnableAuth {
+		if !currentConfig().EnableAuth {
 			if config.IsLogLevel(config.LogLevelDebug) {
 				log.Println("[DEBUG] AuthMiddleware: Authentication disabled, bypassing checks.")
 			}
@@ -220,7 +238,43 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// I client senza browser (script, job CI) si autenticano con un token di api_tokens invece del cookie.
+		if token, hasBearer := bearerToken(r); hasBearer {
+			claims := apiTokenClaims(currentConfig(), token)
+			if claims == nil {
+				if config.IsLogLevel(config.LogLevelInfo) {
+					log.Printf("API token authentication failed from %s", clientIP(r))
+				}
+				apiTokenChallenge(w)
+				return
+			}
+			if !auth.IsUserAuthorized(claims, currentConfig()) {
+				log.Printf("API token user not authorized at application level: %s", claims.Email)
+				http.Error(w, "Access denied: User not authorized to use the application", http.StatusForbidden)
+				return
+			}
+			if config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("[DEBUG] AuthMiddleware: User '%s' authenticated with an API token.", claims.Email)
+			}
+			setAccessLogUser(r.Context(), claims.Email)
+			ctx := context.WithValue(r.Context(), auth.ClaimsKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		cookie, err := r.Cookie("user_claims")
+		// Sessione scaduta o in scadenza: rinnovo trasparente con il refresh token (session_refresh).
+		if claims := refreshSessionIfDue(w, r, err == nil); claims != nil {
+			if !auth.IsUserAuthorized(claims, currentConfig()) {
+				log.Printf("User not authorized at application level after session refresh: %s", claims.Email)
+				http.Error(w, "Access denied: User not authorized to use the application", http.StatusForbidden)
+				return
+			}
+			setAccessLogUser(r.Context(), claims.Email)
+			ctx := context.WithValue(r.Context(), auth.ClaimsKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 		if err != nil {
 			if err == http.ErrNoCookie {
 				if config.IsLogLevel(config.LogLevelInfo) {
@@ -237,17 +291,12 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			log.Println("[DEBUG] AuthMiddleware: Session cookie found.")
 		}
 
-		claimsJSON, err := url.QueryUnescape(cookie.Value)
+		claims, err := auth.DecodeClaimsCookie(cookie.Value)
 		if err != nil {
-			log.Printf("Error decoding session cookie value: %v", err)
-			http.Error(w, "Error processing user data", http.StatusInternalServerError)
-			return
-		}
-
-		var claims auth.UserClaims
-		if err := json.Unmarshal([]byte(claimsJSON), &claims); err != nil {
-			log.Printf("Error parsing claims from cookie: %v", err)
-			http.Error(w, "Error processing user data", http.StatusInternalServerError)
+			// Cookie alterato, scaduto, senza firma (formato precedente) o firmato con un altro session_secret.
+			log.Printf("Rejected session cookie from %s, redirecting to login: %v", clientIP(r), err)
+			clearClaimsCookie(w, r)
+			http.Redirect(w, r, "/auth/login", http.StatusFound)
 			return
 		}
 		if config.IsLogLevel(config.LogLevelDebug) {
@@ -257,7 +306,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			log.Printf("[DEBUG] AuthMiddleware: User's groups (Names): %v", claims.GroupNames)
 		}
 
-		if !auth.IsUserAuthorized(&claims, appConfig) {
+		if !auth.IsUserAuthorized(claims, currentConfig()) {
 			log.Printf("User not authorized at application level during request: %s", claims.Email)
 			http.Error(w, "Access denied: User not authorized to use the application", http.StatusForbidden)
 			return
@@ -266,7 +315,8 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			log.Printf("[DEBUG] AuthMiddleware: User '%s' is authorized for application access.", claims.Email)
 		}
 
-		ctx := context.WithValue(r.Context(), auth.ClaimsKey{}, &claims)
+		setAccessLogUser(r.Context(), claims.Email)
+		ctx := context.WithValue(r.Context(), auth.ClaimsKey{}, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -321,6 +371,17 @@ func handleLongPolling(w http.ResponseWriter, r *http.Request) {
 	wsHub.ServeLongPolling(w, r, claims)
 }
 
+// rejectUploadWhileDraining answers 503 to a new upload during the shutdown (shutdown_upload_grace):
+// gli upload già avviati continuano a ricevere chunk e finalize. Restituisce true se ha risposto.
+func rejectUploadWhileDraining(w http.ResponseWriter) bool {
+	if !wsHub.UploadsDraining() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	http.Error(w, "SERVER_SHUTTING_DOWN: the server is shutting down and does not accept new uploads", http.StatusServiceUnavailable)
+	return true
+}
+
 // handleDownload handles file downloads via standard HTTP after user authentication checks.
 func handleDownload(w http.ResponseWriter, r *http.Request) {
 	claims, _ := getClaimsFromContext(r.Context())
@@ -334,7 +395,7 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	storageName := r.URL.Query().Get("storage")
-	itemPath := r.URL.Query().Get("path")
+	itemPath := currentConfig().NormalizeStoragePath(storageName, r.URL.Query().Get("path"))
 	if config.IsLogLevel(config.LogLevelDebug) {
 		log.Printf("[DEBUG] handleDownload: Request for storage '%s', path '%s'", storageName, itemPath)
 	}
@@ -344,7 +405,8 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, itemPath, "read", appConfig); err != nil {
+	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, itemPath, "read", currentConfig()); err != nil {
+		wsHub.RecordError(claims, "download", storageName, itemPath, err)
 		if errors.Is(err, storage.ErrPermissionDenied) {
 			http.Error(w, "Access denied: read permission required", http.StatusForbidden)
 		} else {
@@ -362,29 +424,174 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Storage provider not found", http.StatusNotFound)
 		return
 	}
+	defer metrics.DownloadDuration.ObserveSince(time.Now(), storageName)
+
+	// ETag e Last-Modified per le richieste condizionali; gli errori di GetItem vengono riportati dal
+	// percorso di download normale. L'ETag serve anche a If-Range nelle richieste Range (http.ServeContent).
+	// La dimensione diventa il Content-Length dello stream (-1 = sconosciuta, GetItem fallito).
+	itemSize := int64(-1)
+	if itemInfo, err := provider.GetItem(r.Context(), claims, itemPath); err == nil && !itemInfo.IsDir {
+		itemSize = itemInfo.Size
+		if setDownloadValidators(w, r, downloadETag(storageName, itemInfo), itemInfo.ModTime) {
+			if config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("[DEBUG] handleDownload: '%s/%s' not modified", storageName, itemPath)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Richieste Range (download ripresi, seek nei media): servite con 206 dai provider ad accesso casuale.
+	// Gli header di checksum riguardano il file intero e non vengono impostati sulle risposte parziali.
+	caps := provider.Capabilities()
+	if r.Header.Get("Range") != "" && caps.RandomAccess {
+		if serveRangeDownload(w, r, claims, provider, storageName, itemPath, caps.BlockSize) {
+			return
+		}
+	}
+	if caps.RandomAccess {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	// Header di checksum per la verifica lato client, se abilitati per lo storage. Se il file è stato letto
+	// in memoria per calcolarli viene servito direttamente dal buffer.
+	if storageCfg := currentConfig().GetStorageConfig(storageName); storageCfg != nil && storageCfg.DownloadChecksums.Enabled {
+		if content, buffered := prepareDownloadChecksums(w, r, claims, provider, storageCfg, itemPath); buffered {
+			setDownloadHeaders(w, r, itemPath, func() []byte { return content })
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			if _, err := w.Write(content); err != nil {
+				log.Printf("Error writing buffered download '%s/%s': %v", storageName, itemPath, err)
+			}
+			return
+		}
+	}
+
+	// Per i provider con una dimensione di blocco preferita (Azure) i file grandi vengono scaricati
+	// a range allineati ai blocchi, con flush periodici; altrimenti si usa un unico stream.
+	if caps.RandomAccess && caps.BlockSize > 0 {
+		if serveBlockAlignedDownload(w, r, claims, provider, storageName, itemPath, caps.BlockSize) {
+			return
+		}
+	}
 
 	reader, err := provider.OpenReader(r.Context(), claims, itemPath)
 	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(w, "Item not found", http.StatusNotFound)
-		} else if errors.Is(err, storage.ErrPermissionDenied) {
-			http.Error(w, "Access denied: read permission required", http.StatusForbidden)
-		} else {
-			log.Printf("Error opening item '%s/%s': %v", storageName, itemPath, err)
-			http.Error(w, "Error downloading item", http.StatusInternalServerError)
-		}
+		writeDownloadError(w, claims, storageName, itemPath, err)
 		return
 	}
 	defer reader.Close()
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(itemPath)))
-	w.Header().Set("Content-Type", "application/octet-stream")
+	streamDownload(w, r, claims, storageName, itemPath, reader, itemSize)
+}
+
+// prepareDownloadChecksums sets X-Checksum-SHA256 from the stored checksum when available. Otherwise, for
+// files up to download_checksums.max_compute_size_mb, it reads the file in memory to compute Content-MD5 and
+// X-Checksum-SHA256 and returns its content with buffered=true. Per i file più grandi non imposta header:
+// calcolare l'hash in streaming non è possibile perché gli header vanno inviati prima del corpo.
+func prepareDownloadChecksums(w http.ResponseWriter, r *http.Request, claims *auth.UserClaims, provider storage.StorageProvider, storageCfg *config.StorageConfig, itemPath string) (content []byte, buffered bool) {
+	itemInfo, err := provider.GetItem(r.Context(), claims, itemPath)
+	if err != nil || itemInfo.IsDir {
+		return nil, false // Gli errori vengono riportati dal percorso di download normale
+	}
+	if itemInfo.SHA256 != "" {
+		w.Header().Set("X-Checksum-SHA256", itemInfo.SHA256)
+		return nil, false
+	}
 
-	_, err = io.Copy(w, reader)
+	maxComputeSize := int64(storageCfg.DownloadChecksums.MaxComputeSizeMB) << 20
+	if maxComputeSize < 0 || itemInfo.Size > maxComputeSize {
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] handleDownload: Skipping checksum headers for '%s/%s' (%d bytes): no stored checksum and above max_compute_size_mb", storageCfg.Name, itemPath, itemInfo.Size)
+		}
+		return nil, false
+	}
+
+	reader, err := provider.OpenReader(r.Context(), claims, itemPath)
 	if err != nil {
-		log.Printf("Error copying item stream for download '%s/%s': %v", storageName, itemPath, err)
-		// Non inviare http.Error qui se lo stream è già iniziato, potrebbe corrompere la risposta.
+		return nil, false
 	}
+	defer reader.Close()
+	// Il file potrebbe essere cresciuto dopo GetItem: se supera il limite lo si scarica senza header.
+	content, err = io.ReadAll(io.LimitReader(reader, maxComputeSize+1))
+	if err != nil || int64(len(content)) > maxComputeSize {
+		if err != nil {
+			log.Printf("Error reading '%s/%s' to compute download checksums: %v", storageCfg.Name, itemPath, err)
+		}
+		return nil, false
+	}
+
+	md5Sum := md5.Sum(content)
+	sha256Sum := sha256.Sum256(content)
+	w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+	w.Header().Set("X-Checksum-SHA256", hex.EncodeToString(sha256Sum[:]))
+	return content, true
+}
+
+// serveBlockAlignedDownload streams a file as a sequence of block-aligned ranges read via OpenReaderAt,
+// flushing the response after each block. Returns false, without writing anything, if the file should
+// be served as a single stream instead (small file, or OpenReaderAt failed: the regular path reports the error).
+func serveBlockAlignedDownload(w http.ResponseWriter, r *http.Request, claims *auth.UserClaims, provider storage.StorageProvider, storageName string, itemPath string, blockSize int64) bool {
+	readerAt, err := provider.OpenReaderAt(r.Context(), claims, itemPath)
+	if err != nil {
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] handleDownload: OpenReaderAt failed for '%s/%s', falling back to a single stream: %v", storageName, itemPath, err)
+		}
+		return false
+	}
+	defer readerAt.Close()
+
+	size := readerAt.Size()
+	if size < 2*blockSize {
+		return false
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("[DEBUG] handleDownload: Serving '%s/%s' (%d bytes) in blocks of %d bytes", storageName, itemPath, size, blockSize)
+	}
+
+	setDownloadHeaders(w, r, itemPath, readerAtHead(readerAt))
+	setDownloadLength(w, size)
+
+	source := &readErrorRecorder{readerAt: readerAt}
+	err = copyBlockAligned(w, source, 0, size-1, blockSize)
+	finishDownloadStream(w, claims, storageName, itemPath, source, err)
+	return true
+}
+
+// copyBlockAligned writes the bytes [start, end] of readerAt to w. Each read ends on a multiple of
+// blockSize, so that a range starting mid-block (e.g. a resumed download) realigns after the first read.
+func copyBlockAligned(w http.ResponseWriter, readerAt io.ReaderAt, start int64, end int64, blockSize int64) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, blockSize)
+	for offset := start; offset <= end; {
+		next := (offset/blockSize + 1) * blockSize
+		if next > end+1 {
+			next = end + 1
+		}
+		n, err := readerAt.ReadAt(buf[:next-offset], offset)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil && !(errors.Is(err, io.EOF) && offset+int64(n) == next) {
+			return err
+		}
+		offset += int64(n)
+	}
+	return nil
+}
+
+// uploadPhaseContext bounds an upload action ("initiate", "chunk", "finalize", "cancel") with the
+// corresponding timeouts.upload_*_timeout.
+func uploadPhaseContext(ctx context.Context, action string) (context.Context, context.CancelFunc) {
+	timeout, err := currentConfig().GetUploadPhaseTimeout(action)
+	if err != nil { // Non accade con una configurazione validata
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 // handleUpload manages file uploads via HTTP after user authentication checks.
@@ -406,6 +613,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	const MAX_MEMORY = 400 << 20 // 400 MB - Regola se necessario
 
 	if strings.HasPrefix(contentType, "multipart/form-data") {
+		countChunkBody(r, claims)
 		err = r.ParseMultipartForm(MAX_MEMORY)
 	} else if contentType == "application/x-www-form-urlencoded" {
 		err = r.ParseForm()
@@ -422,20 +630,41 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	storageName := r.FormValue("storage")
-	itemPath := r.FormValue("path")
+	itemPath := currentConfig().NormalizeStoragePath(storageName, r.FormValue("path"))
 	action := r.FormValue("action")
+	// upload_id è restituito dall'initiate e identifica la sessione nelle azioni successive; i client che
+	// non lo inviano vengono associati alla loro sessione tramite storage e path.
+	uploadID := r.FormValue("upload_id")
 
 	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("[DEBUG] handleUpload: Action '%s' for storage '%s', path '%s'", action, storageName, itemPath)
+		log.Printf("[DEBUG] handleUpload: Action '%s' for storage '%s', path '%s', upload '%s'", action, storageName, itemPath, uploadID)
 	}
 
-	if storageName == "" || itemPath == "" || action == "" {
+	if storageName == "" || action == "" || (itemPath == "" && uploadID == "") {
 		log.Printf("Missing required parameters for upload: storage='%s', path='%s', action='%s'", storageName, itemPath, action)
-		http.Error(w, "Parameters 'storage', 'path', and 'action' are required", http.StatusBadRequest)
+		http.Error(w, "Parameters 'storage', 'path' (or 'upload_id'), and 'action' are required", http.StatusBadRequest)
 		return
 	}
 
-	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, itemPath, "write", appConfig); err != nil {
+	// Le azioni successive all'initiate usano la destinazione registrata nella sessione.
+	var sessionState *websocket.UploadSessionState
+	if action != "initiate" || uploadID != "" {
+		var exists bool
+		if uploadID, sessionState, exists = findUploadSession(claims, storageName, itemPath, r.FormValue("upload_id")); exists {
+			itemPath = sessionState.ItemPath
+		} else if itemPath == "" || action == "initiate" {
+			http.Error(w, "UPLOAD_NOT_FOUND: upload session not found, initiate a new upload", http.StatusNotFound)
+			return
+		}
+	}
+
+	// Ogni azione ha il proprio timeout: un finalize lento non deve essere interrotto dal limite dell'initiate.
+	phaseCtx, cancelPhase := uploadPhaseContext(r.Context(), action)
+	defer cancelPhase()
+	r = r.WithContext(phaseCtx)
+
+	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, itemPath, "write", currentConfig()); err != nil {
+		wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, err)
 		if errors.Is(err, storage.ErrPermissionDenied) {
 			http.Error(w, "Access denied: write permission required", http.StatusForbidden)
 		} else {
@@ -453,13 +682,24 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Storage provider not found", http.StatusNotFound)
 		return
 	}
+	// Ogni richiesta di upload occupa uno slot di max_concurrent_operations dello storage; i metodi di upload
+	// vengono poi chiamati sul provider concreto, senza prendere altri slot.
+	releaseSlot, slotErr := storage.AcquireSlot(r.Context(), provider)
+	if slotErr != nil {
+		wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, slotErr)
+		if errors.Is(slotErr, storage.ErrUnavailable) {
+			writeStorageBusy(w, slotErr)
+		}
+		return
+	}
+	defer releaseSlot()
+	provider = storage.Unwrap(provider)
 	if config.IsLogLevel(config.LogLevelDebug) {
 		log.Printf("[DEBUG] handleUpload: Provider %T (val: %v)", provider, provider) // Logga tipo e valore del provider
 	}
 
-	uploadKey := fmt.Sprintf("%s:%s", storageName, itemPath)
 	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("[DEBUG] handleUpload: uploadKey %s", uploadKey)
+		log.Printf("[DEBUG] handleUpload: upload ID '%s'", uploadID)
 	}
 
 	var currentUserEmail string
@@ -468,29 +708,60 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	} else {
 		currentUserEmail = "unknown_user"
 	}
+	// Campi strutturati dei log dell'upload; itemPath può cambiare con auto_rename, quindi sono calcolati a ogni uso.
+	requestID := requestIDFor(w, r)
+	uploadLogAttrs := func(attrs ...any) []any {
+		base := []any{logging.KeyUser, currentUserEmail, logging.KeyStorage, storageName, logging.KeyPath, itemPath, "action", action}
+		if uploadID != "" {
+			base = append(base, "upload_id", uploadID)
+		}
+		if requestID != "" {
+			base = append(base, logging.KeyRequestID, requestID)
+		}
+		return append(base, attrs...)
+	}
+	// touchSession aggiorna l'ultima attività della sessione, che resta valida dopo un conflitto al finalize.
+	touchSession := func() {
+		wsHub.FileUploadsMutex.Lock()
+		if sessionState, exists := wsHub.OngoingFileUploads[uploadID]; exists {
+			sessionState.LastActivity = time.Now()
+		}
+		wsHub.FileUploadsMutex.Unlock()
+	}
+	removeSession := func() {
+		wsHub.FileUploadsMutex.Lock()
+		delete(wsHub.OngoingFileUploads, uploadID)
+		wsHub.UpdateUploadsGauge()
+		wsHub.FileUploadsMutex.Unlock()
+	}
+	cancelUpload := func() error {
+		switch p := provider.(type) {
+		case *local.LocalFilesystemProvider:
+			return p.CancelUpload(claims, uploadID)
+		case *azureblob.AzureBlobStorageProvider:
+			return p.CancelUpload(r.Context(), claims, uploadID)
+		case *command.CommandStorageProvider:
+			return p.CancelUpload(claims, uploadID)
+		case *memory.MemoryStorageProvider:
+			return p.CancelUpload(claims, uploadID)
+		case *gcs.GCSStorageProvider:
+			return p.CancelUpload(claims, uploadID)
+		default:
+			return nil
+		}
+	}
+	caps := provider.Capabilities()
 
 	switch action {
 	case "initiate":
 		if config.IsLogLevel(config.LogLevelDebug) {
 			log.Println("[DEBUG] handleUpload: initiate action")
 		}
-
-		// Controllo preliminare per upload concorrenti
-		wsHub.FileUploadsMutex.Lock()
-		if sessionState, exists := wsHub.OngoingFileUploads[uploadKey]; exists {
-			wsHub.FileUploadsMutex.Unlock() // Rilascia il lock se c'è un conflitto immediato
-			log.Printf("Upload conflict: File '%s' is already being uploaded by '%s'. Current user: '%s'", uploadKey, sessionState.Claims.Email, currentUserEmail)
-			http.Error(w, fmt.Sprintf("File '%s' è già in fase di caricamento da parte di %s.", itemPath, sessionState.Claims.Email), http.StatusConflict)
+		if rejectUploadWhileDraining(w) {
 			return
 		}
-		// Se non esiste, rilascia comunque il lock prima di operazioni potenzialmente lunghe o altre logiche.
-		wsHub.FileUploadsMutex.Unlock() // !!! RILASCIO CRUCIALE DEL LOCK INIZIALE !!!
-		log.Printf("Initial lock released for initiate action of %s", uploadKey)
 
-
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Handling upload initiate for storage '%s', path '%s' by user '%s'", storageName, itemPath, currentUserEmail)
-		}
+		slog.Info("Handling upload", uploadLogAttrs()...)
 
 		totalFileSizeStr := r.FormValue("total_file_size")
 		chunkSizeStr := r.FormValue("chunk_size")
@@ -508,61 +779,184 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		var uploadedSize int64
-		var errInitiate error // Rinominato per chiarezza
-
 		// La chiamata al provider.InitiateUpload può essere lunga, non deve tenere bloccato il mutex.
-		switch p := provider.(type) {
-		case *local.LocalFilesystemProvider:
-			uploadedSize, errInitiate = p.InitiateUpload(r.Context(), claims, itemPath, totalFileSize, chunkSize)
-		case *azureblob.AzureBlobStorageProvider:
-			uploadedSize, errInitiate = p.InitiateUpload(r.Context(), claims, itemPath, totalFileSize, chunkSize)
-		default:
-			errInitiate = storage.ErrNotImplemented
+		// overwrite serve a GCS, che fissa la precondizione di creazione alla sessione resumable.
+		initiateProviderUpload := func(overwrite bool) (int64, error) {
+			switch p := provider.(type) {
+			case *local.LocalFilesystemProvider:
+				return p.InitiateUpload(r.Context(), claims, uploadID, itemPath, totalFileSize, chunkSize)
+			case *azureblob.AzureBlobStorageProvider:
+				return p.InitiateUpload(r.Context(), claims, uploadID, itemPath, totalFileSize, chunkSize)
+			case *command.CommandStorageProvider:
+				return p.InitiateUpload(r.Context(), claims, uploadID, itemPath, totalFileSize, chunkSize)
+			case *memory.MemoryStorageProvider:
+				return p.InitiateUpload(r.Context(), claims, uploadID, itemPath, totalFileSize, chunkSize)
+			case *gcs.GCSStorageProvider:
+				return p.InitiateUpload(r.Context(), claims, uploadID, itemPath, totalFileSize, chunkSize, overwrite)
+			default:
+				return 0, storage.ErrNotImplemented
+			}
 		}
-
-		if errInitiate != nil {
-			// Non c'è bisogno di bloccare FileUploadsMutex qui per la delete, perché non abbiamo ancora aggiunto nulla.
-			log.Printf("Error initiating upload for '%s/%s': %v", storageName, itemPath, errInitiate)
+		writeInitiateError := func(errInitiate error) {
+			wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, errInitiate)
+			slog.Error("Error initiating upload", uploadLogAttrs(logging.KeyError, errInitiate)...)
 			if errors.Is(errInitiate, storage.ErrPermissionDenied) {
 				http.Error(w, "Access denied: write permission required", http.StatusForbidden)
 			} else if errors.Is(errInitiate, storage.ErrNotFound) {
 				http.Error(w, "Destination not found", http.StatusNotFound)
 			} else if errors.Is(errInitiate, storage.ErrNotImplemented) {
 				http.Error(w, "Upload not supported for this storage type", http.StatusNotImplemented)
+			} else if errors.Is(errInitiate, storage.ErrUploadTooLarge) {
+				http.Error(w, fmt.Sprintf("UPLOAD_TOO_LARGE: %v", errInitiate), http.StatusRequestEntityTooLarge)
+			} else if errors.Is(errInitiate, storage.ErrInvalidChunk) {
+				http.Error(w, fmt.Sprintf("INVALID_CHUNK: %v", errInitiate), http.StatusBadRequest)
+			} else if errors.Is(errInitiate, storage.ErrIsDirectory) {
+				http.Error(w, fmt.Sprintf("IS_A_DIRECTORY: '%s' is a directory", itemPath), http.StatusConflict)
+			} else if errors.Is(errInitiate, context.DeadlineExceeded) {
+				http.Error(w, "UPLOAD_TIMEOUT: initiate did not complete within timeouts.upload_initiate_timeout", http.StatusGatewayTimeout)
 			} else {
 				http.Error(w, fmt.Sprintf("Error initiating upload: %v", errInitiate), http.StatusInternalServerError)
 			}
+		}
+
+		// Ripresa di un upload con upload_id: il provider restituisce i byte già ricevuti.
+		if sessionState != nil {
+			uploadedSize, errInitiate := initiateProviderUpload(sessionState.Overwrite && !sessionState.AutoRename)
+			if errInitiate != nil {
+				writeInitiateError(errInitiate)
+				return
+			}
+			touchSession()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"upload_id": uploadID, "uploaded_size": uploadedSize, "path": itemPath})
+			return
+		}
+
+		uploadID, err = storage.NewUploadID()
+		if err != nil {
+			log.Printf("Error generating upload ID for '%s/%s': %v", storageName, itemPath, err)
+			http.Error(w, "Error initiating upload", http.StatusInternalServerError)
+			return
+		}
+		// overwrite e auto_rename vengono ricordati nella sessione e applicati al finalize, quando il file viene
+		// pubblicato: un file esistente non è un conflitto finché l'upload non è completo.
+		overwrite, _ := strconv.ParseBool(r.FormValue("overwrite"))
+		autoRename, _ := strconv.ParseBool(r.FormValue("auto_rename"))
+
+		// expected_mod_time ed expected_etag sono la versione del file che il client vuole sovrascrivere: vengono
+		// verificati qui e di nuovo al finalize, così un file cambiato nel frattempo non viene sostituito.
+		precondition, preconditionErr := storage.ParseItemPrecondition(r.FormValue("expected_mod_time"), r.FormValue("expected_etag"))
+		if preconditionErr != nil {
+			http.Error(w, preconditionErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if !precondition.IsZero() {
+			if !overwrite || autoRename {
+				http.Error(w, "expected_mod_time and expected_etag require overwrite=true without auto_rename", http.StatusBadRequest)
+				return
+			}
+			if _, checkErr := storage.CheckItemPrecondition(r.Context(), provider, claims, itemPath, precondition); checkErr != nil {
+				writePreconditionError(w, claims, action, storageName, itemPath, checkErr)
+				return
+			}
+		}
+
+		// I provider che scrivono i dati direttamente sul path finale (Azure, GCS) non possono avere due upload
+		// sullo stesso path: per loro auto_rename sceglie il nome già all'initiate.
+		if !caps.UploadPathAtFinalize {
+			if autoRename {
+				resolvedPath, release, renameErr := reserveUploadName(r.Context(), claims, provider, storageName, itemPath, "")
+				if renameErr != nil {
+					wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, renameErr)
+					if errors.Is(renameErr, errNoFreeName) {
+						http.Error(w, fmt.Sprintf("No free name available for '%s'", itemPath), http.StatusConflict)
+					} else if errors.Is(renameErr, storage.ErrPermissionDenied) {
+						http.Error(w, "Access denied: write permission required", http.StatusForbidden)
+					} else {
+						log.Printf("Error resolving auto_rename name for '%s/%s': %v", storageName, itemPath, renameErr)
+						http.Error(w, "Error resolving upload name", http.StatusInternalServerError)
+					}
+					return
+				}
+				defer release()
+				itemPath = resolvedPath
+			}
+
+			// Controllo preliminare per upload concorrenti
+			wsHub.FileUploadsMutex.Lock()
+			if _, sessionState, exists := wsHub.UploadForPath(storageName, itemPath, ""); exists {
+				wsHub.FileUploadsMutex.Unlock() // Rilascia il lock se c'è un conflitto immediato
+				log.Printf("Upload conflict: File '%s/%s' is already being uploaded by '%s'. Current user: '%s'", storageName, itemPath, sessionState.Claims.Email, currentUserEmail)
+				http.Error(w, fmt.Sprintf("File '%s' è già in fase di caricamento da parte di %s.", itemPath, sessionState.Claims.Email), http.StatusConflict)
+				return
+			}
+			wsHub.FileUploadsMutex.Unlock()
+		}
+
+		// Il limite è verificato anche dal provider, ma controllarlo qui evita il calcolo della quota.
+		if storageCfg := currentConfig().GetStorageConfig(storageName); storageCfg != nil {
+			if sizeErr := storage.CheckUploadSize(storageCfg.MaxUploadBytes, totalFileSize); sizeErr != nil {
+				wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, sizeErr)
+				http.Error(w, fmt.Sprintf("UPLOAD_TOO_LARGE: %v", sizeErr), http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+
+		if quotaErr := checkStorageQuota(r.Context(), claims, provider, totalFileSize); quotaErr != nil {
+			wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, quotaErr)
+			if errors.Is(quotaErr, storage.ErrQuotaExceeded) {
+				http.Error(w, fmt.Sprintf("QUOTA_EXCEEDED: %v", quotaErr), http.StatusRequestEntityTooLarge)
+			} else {
+				log.Printf("Error checking quota for upload '%s/%s': %v", storageName, itemPath, quotaErr)
+				http.Error(w, "Error checking storage quota", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		uploadedSize, errInitiate := initiateProviderUpload(overwrite && !autoRename)
+		if errInitiate != nil {
+			writeInitiateError(errInitiate)
 			return
 		}
 
 		// Ora, blocca il mutex SOLO per aggiungere la sessione alla mappa.
-		log.Printf("Setting Mutex for final add of %s", uploadKey)
 		wsHub.FileUploadsMutex.Lock()
-		log.Printf("Mutex locked for final add of %s", uploadKey)
-
 		// È buona pratica ricontrollare l'esistenza qui per gestire una possibile race condition
-		if _, currentExists := wsHub.OngoingFileUploads[uploadKey]; currentExists {
+		if _, _, currentExists := wsHub.UploadForPath(storageName, itemPath, ""); currentExists && !caps.UploadPathAtFinalize {
 			wsHub.FileUploadsMutex.Unlock()
-			log.Printf("Upload conflict (race condition before final add): File '%s' became active.", uploadKey)
+			log.Printf("Upload conflict (race condition before final add): File '%s/%s' became active.", storageName, itemPath)
+			cancelUpload()
 			http.Error(w, "File è diventato attivo durante l'inizializzazione, riprovare.", http.StatusConflict)
-			// Considerare la pulizia delle risorse temporanee del provider qui se necessario
 			return
 		}
 
-		wsHub.OngoingFileUploads[uploadKey] = &websocket.UploadSessionState{
-			Claims:       claims,
-			StorageName:  storageName,
-			ItemPath:     itemPath,
-			LastActivity: time.Now(),
-			ProviderType: provider.Type(),
+		wsHub.OngoingFileUploads[uploadID] = &websocket.UploadSessionState{
+			Claims:        claims,
+			ClientID:      r.FormValue("client_id"), // Identificatore del client WS/LP (config_update), per la pulizia alla disconnessione
+			UploadID:      uploadID,
+			StorageName:   storageName,
+			ItemPath:      itemPath,
+			Overwrite:     overwrite,
+			AutoRename:    autoRename,
+			Precondition:  precondition,
+			LastActivity:  time.Now(),
+			ProviderType:  provider.Type(),
+			ObservedSize:  uploadedSize,
+			LastProgress:  time.Now(),
+			TotalSize:     totalFileSize,
+			ReceivedBytes: uploadedSize,
+			StartedAt:     time.Now(),
 		}
+		wsHub.UpdateUploadsGauge()
 		wsHub.FileUploadsMutex.Unlock()
-		log.Printf("Store Setted. Mutex unlocked for %s", uploadKey)
-
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] handleUpload: Registered upload '%s' for '%s/%s'", uploadID, storageName, itemPath)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]int64{"uploaded_size": uploadedSize})
+		// upload_id identifica la sessione in chunk, finalize, cancel e status; path è il percorso richiesto (o
+		// quello scelto con auto_rename sugli storage che lo risolvono all'initiate).
+		json.NewEncoder(w).Encode(map[string]interface{}{"upload_id": uploadID, "uploaded_size": uploadedSize, "path": itemPath})
 
 	case "chunk":
 		if config.IsLogLevel(config.LogLevelDebug) {
@@ -573,11 +967,13 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Chunk action requires multipart/form-data Content-Type", http.StatusBadRequest)
 			return
 		}
-
-		if config.IsLogLevel(config.LogLevelDebug) { // Modificato da Info a Debug
-			log.Printf("Handling upload chunk for storage '%s', path '%s'", storageName, itemPath)
+		if sessionState == nil {
+			http.Error(w, "UPLOAD_NOT_FOUND: upload session not found, initiate a new upload", http.StatusNotFound)
+			return
 		}
-		file, _, err := r.FormFile("chunk")
+
+		slog.Debug("Handling upload", uploadLogAttrs()...)
+		file, chunkHeader, err := r.FormFile("chunk")
 		if err != nil {
 			log.Printf("Error getting file chunk for '%s/%s': %v", storageName, itemPath, err)
 			http.Error(w, fmt.Sprintf("Error getting file chunk: %v", err), http.StatusBadRequest)
@@ -596,44 +992,79 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Missing or invalid chunk_index or chunk_size for chunk action", http.StatusBadRequest)
 			return
 		}
+		// Checksum opzionali del singolo chunk: un chunk corrotto viene rifiutato e si reinvia solo quello.
+		chunkChecksum, checksumErr := storage.ParseChunkChecksum(r.FormValue("chunk_md5"), r.FormValue("chunk_crc32c"))
+		if checksumErr != nil {
+			http.Error(w, fmt.Sprintf("INVALID_CHUNK: %v", checksumErr), http.StatusBadRequest)
+			return
+		}
 
 		var writeErr error
 		switch p := provider.(type) {
 		case *local.LocalFilesystemProvider:
+			// Il chunk viene copiato nel file temporaneo direttamente dal multipart, senza leggerlo tutto in memoria.
+			writeErr = p.WriteChunk(r.Context(), claims, uploadID, file, chunkIndex, chunkSizeVal, chunkChecksum) // Passa chunkSizeVal
+		case *azureblob.AzureBlobStorageProvider:
+			if blockID == "" {
+				http.Error(w, "Parameter 'block_id' is required for azure-blob chunk upload", http.StatusBadRequest)
+				return
+			}
+			writeErr = p.WriteChunk(r.Context(), claims, uploadID, blockID, file, chunkIndex, chunkChecksum)
+		case *command.CommandStorageProvider:
 			chunkData, readErr := ioutil.ReadAll(file)
 			if readErr != nil {
-				log.Printf("Error reading file chunk for local upload '%s/%s': %v", storageName, itemPath, readErr)
+				log.Printf("Error reading file chunk for command upload '%s/%s': %v", storageName, itemPath, readErr)
 				http.Error(w, fmt.Sprintf("Error reading file chunk: %v", readErr), http.StatusInternalServerError)
 				return
 			}
-			writeErr = p.WriteChunk(r.Context(), claims, itemPath, chunkData, chunkIndex, chunkSizeVal) // Passa chunkSizeVal
-		case *azureblob.AzureBlobStorageProvider:
-			if blockID == "" {
-				http.Error(w, "Parameter 'block_id' is required for azure-blob chunk upload", http.StatusBadRequest)
+			writeErr = p.WriteChunk(r.Context(), claims, uploadID, chunkData, chunkIndex, chunkSizeVal, chunkChecksum)
+		case *memory.MemoryStorageProvider:
+			chunkData, readErr := ioutil.ReadAll(file)
+			if readErr != nil {
+				log.Printf("Error reading file chunk for memory upload '%s/%s': %v", storageName, itemPath, readErr)
+				http.Error(w, fmt.Sprintf("Error reading file chunk: %v", readErr), http.StatusInternalServerError)
 				return
 			}
-			writeErr = p.WriteChunk(r.Context(), claims, itemPath, blockID, file, chunkIndex)
+			writeErr = p.WriteChunk(r.Context(), claims, uploadID, chunkData, chunkIndex, chunkSizeVal, chunkChecksum)
+		case *gcs.GCSStorageProvider:
+			writeErr = p.WriteChunk(r.Context(), claims, uploadID, file, chunkIndex, chunkChecksum)
 		default:
 			writeErr = storage.ErrNotImplemented
 		}
 
 		if writeErr != nil {
+			wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, writeErr)
 			log.Printf("Error writing chunk for '%s/%s': %v", storageName, itemPath, writeErr)
 			if errors.Is(writeErr, storage.ErrPermissionDenied) {
 				http.Error(w, "Access denied: write permission required", http.StatusForbidden)
+			} else if errors.Is(writeErr, storage.ErrUploadNotFound) {
+				http.Error(w, fmt.Sprintf("UPLOAD_NOT_FOUND: %v", writeErr), http.StatusNotFound)
 			} else if errors.Is(writeErr, storage.ErrNotImplemented) {
 				http.Error(w, "Chunk upload not supported for this storage type", http.StatusNotImplemented)
+			} else if errors.Is(writeErr, storage.ErrUploadTooLarge) {
+				http.Error(w, fmt.Sprintf("UPLOAD_TOO_LARGE: %v", writeErr), http.StatusRequestEntityTooLarge)
+			} else if errors.Is(writeErr, storage.ErrSizeExceeded) {
+				http.Error(w, fmt.Sprintf("SIZE_EXCEEDED: %v", writeErr), http.StatusRequestEntityTooLarge)
+			} else if errors.Is(writeErr, storage.ErrInvalidChunk) {
+				http.Error(w, fmt.Sprintf("INVALID_CHUNK: %v", writeErr), http.StatusBadRequest)
+			} else if errors.Is(writeErr, storage.ErrChunkChecksumMismatch) {
+				http.Error(w, fmt.Sprintf("CHUNK_CHECKSUM_MISMATCH: %v", writeErr), http.StatusBadRequest)
+			} else if errors.Is(writeErr, context.DeadlineExceeded) {
+				http.Error(w, "UPLOAD_TIMEOUT: chunk did not complete within timeouts.upload_chunk_timeout", http.StatusGatewayTimeout)
 			} else {
 				http.Error(w, fmt.Sprintf("Error writing chunk: %v", writeErr), http.StatusInternalServerError)
 			}
 			return
 		}
 
+		metrics.UploadBytesWritten.Add(float64(chunkHeader.Size), storageName)
+
 		wsHub.FileUploadsMutex.Lock()
-		if sessionState, exists := wsHub.OngoingFileUploads[uploadKey]; exists {
+		if sessionState, exists := wsHub.OngoingFileUploads[uploadID]; exists {
 			sessionState.LastActivity = time.Now()
+			sessionState.RecordChunk(sessionState.LastActivity, chunkHeader.Size)
 			if config.IsLogLevel(config.LogLevelDebug) {
-				log.Printf("Updated last activity for upload '%s' to %s", uploadKey, sessionState.LastActivity.Format(time.RFC3339))
+				log.Printf("Updated last activity for upload '%s' to %s", uploadID, sessionState.LastActivity.Format(time.RFC3339))
 			}
 		}
 		wsHub.FileUploadsMutex.Unlock()
@@ -644,12 +1075,16 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 
 	case "finalize":
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Handling upload finalize for storage '%s', path '%s'", storageName, itemPath)
+		slog.Info("Handling upload", uploadLogAttrs()...)
+		if sessionState == nil {
+			http.Error(w, "UPLOAD_NOT_FOUND: upload session not found, initiate a new upload", http.StatusNotFound)
+			return
 		}
 		var errFinalize error // Rinominato per chiarezza
 		var blockIDs []string
 		clientSHA256 := r.FormValue("client_sha256")
+		// strong_verification chiede di verificare lo SHA256 rileggendo il file pubblicato (Azure: riscarica il blob).
+		strongVerification, _ := strconv.ParseBool(r.FormValue("strong_verification"))
 		totalFileSizeStr := r.FormValue("total_file_size")
 
 		totalFileSize, parseErr := strconv.ParseInt(totalFileSizeStr, 10, 64)
@@ -657,11 +1092,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Missing or invalid total_file_size for finalize action", http.StatusBadRequest)
 			return
 		}
-
-		switch p := provider.(type) {
-		case *local.LocalFilesystemProvider:
-			errFinalize = p.FinalizeUpload(claims, itemPath, clientSHA256) // totalFileSize non è più necessario qui per il provider locale
-		case *azureblob.AzureBlobStorageProvider:
+		if _, isAzure := provider.(*azureblob.AzureBlobStorageProvider); isAzure {
 			blockIDsJSON := r.FormValue("block_ids")
 			if blockIDsJSON == "" {
 				http.Error(w, "Parameter 'block_ids' is required for azure-blob finalize", http.StatusBadRequest)
@@ -671,84 +1102,168 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Invalid 'block_ids' format", http.StatusBadRequest)
 				return
 			}
-			errFinalize = p.FinalizeUpload(r.Context(), claims, itemPath, blockIDs, clientSHA256)
-		default:
-			errFinalize = storage.ErrNotImplemented
 		}
 
-		wsHub.FileUploadsMutex.Lock()
-		delete(wsHub.OngoingFileUploads, uploadKey)
-		wsHub.FileUploadsMutex.Unlock()
+		// Il conflitto con un file esistente viene verificato qui, alla pubblicazione: overwrite=true inviato al
+		// finalize conferma la sovrascrittura dopo un ALREADY_EXISTS.
+		formOverwrite, _ := strconv.ParseBool(r.FormValue("overwrite"))
+		formAutoRename, _ := strconv.ParseBool(r.FormValue("auto_rename"))
+		overwrite := sessionState.Overwrite || formOverwrite
+		autoRename := sessionState.AutoRename || formAutoRename
+		ifMatch := "" // ETag del file da sostituire, verificato con expected_mod_time/expected_etag
+		publish := func(destPath string, overwrite bool) error {
+			switch p := provider.(type) {
+			case *local.LocalFilesystemProvider:
+				return p.FinalizeUpload(claims, uploadID, destPath, clientSHA256, overwrite) // totalFileSize non è più necessario qui per il provider locale
+			case *azureblob.AzureBlobStorageProvider:
+				return p.FinalizeUpload(r.Context(), claims, uploadID, destPath, blockIDs, clientSHA256, totalFileSize, overwrite, strongVerification, ifMatch)
+			case *command.CommandStorageProvider:
+				return p.FinalizeUpload(r.Context(), claims, uploadID, destPath, clientSHA256, overwrite)
+			case *memory.MemoryStorageProvider:
+				return p.FinalizeUpload(r.Context(), claims, uploadID, destPath, clientSHA256, overwrite)
+			case *gcs.GCSStorageProvider:
+				return p.FinalizeUpload(r.Context(), claims, uploadID, destPath, clientSHA256, totalFileSize, overwrite)
+			default:
+				return storage.ErrNotImplemented
+			}
+		}
+
+		finalPath := itemPath
+		changeOp := storage.ChangeCreated // Senza overwrite il file pubblicato è nuovo
+		if autoRename && caps.UploadPathAtFinalize {
+			// Con auto_rename il nome libero viene scelto alla pubblicazione; se un altro client lo occupa tra la
+			// verifica e il finalize del provider si passa al candidato successivo.
+			for attempt := 0; ; attempt++ {
+				resolvedPath, release, renameErr := reserveUploadName(r.Context(), claims, provider, storageName, itemPath, uploadID)
+				if renameErr != nil {
+					errFinalize = renameErr
+					break
+				}
+				errFinalize = publish(resolvedPath, false)
+				release()
+				if errFinalize == nil {
+					finalPath = resolvedPath
+				}
+				if !errors.Is(errFinalize, storage.ErrAlreadyExists) || attempt >= currentConfig().UploadAutoRename.MaxAttempts {
+					break
+				}
+			}
+		} else if overwrite && !autoRename && !sessionState.Precondition.IsZero() {
+			// Su Azure il commit è condizionato all'ETag appena verificato; sugli altri storage resta una breve
+			// finestra tra la verifica e la pubblicazione.
+			var current *storage.ItemInfo
+			if current, errFinalize = storage.CheckItemPrecondition(r.Context(), provider, claims, itemPath, sessionState.Precondition); errFinalize == nil {
+				ifMatch = current.ETag
+				errFinalize = publish(itemPath, true)
+			}
+			changeOp = storage.ChangeModified
+		} else {
+			errFinalize = publish(itemPath, overwrite && !autoRename)
+			if overwrite && !autoRename {
+				changeOp = storage.ChangeModified
+			}
+		}
+
+		// Dopo un conflitto la sessione resta valida: il client può confermare la sovrascrittura o annullare.
+		if errors.Is(errFinalize, storage.ErrAlreadyExists) || errors.Is(errFinalize, storage.ErrIsDirectory) || errors.Is(errFinalize, errNoFreeName) {
+			touchSession()
+		} else {
+			removeSession()
+		}
 
 		if errFinalize != nil {
-			log.Printf("Error finalizing upload for '%s/%s': %v", storageName, itemPath, errFinalize)
+			wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, errFinalize)
+			slog.Error("Error finalizing upload", uploadLogAttrs(logging.KeyError, errFinalize)...)
 			if errors.Is(errFinalize, storage.ErrPermissionDenied) {
 				http.Error(w, "Access denied: write permission required", http.StatusForbidden)
+			} else if errors.Is(errFinalize, storage.ErrAlreadyExists) {
+				http.Error(w, fmt.Sprintf("ALREADY_EXISTS: '%s' already exists, send overwrite=true to replace it", itemPath), http.StatusConflict)
+			} else if errors.Is(errFinalize, storage.ErrIsDirectory) {
+				http.Error(w, fmt.Sprintf("IS_A_DIRECTORY: '%s' is a directory", itemPath), http.StatusConflict)
+			} else if errors.Is(errFinalize, storage.ErrPreconditionFailed) || (!sessionState.Precondition.IsZero() && errors.Is(errFinalize, storage.ErrNotFound)) {
+				http.Error(w, fmt.Sprintf("PRECONDITION_FAILED: %v", errFinalize), http.StatusConflict)
+			} else if errors.Is(errFinalize, errNoFreeName) {
+				http.Error(w, fmt.Sprintf("No free name available for '%s'", itemPath), http.StatusConflict)
+			} else if errors.Is(errFinalize, storage.ErrUploadNotFound) {
+				http.Error(w, fmt.Sprintf("UPLOAD_NOT_FOUND: %v", errFinalize), http.StatusNotFound)
 			} else if errors.Is(errFinalize, storage.ErrNotImplemented) {
 				http.Error(w, "Upload finalization not supported for this storage type", http.StatusNotImplemented)
 			} else if errors.Is(errFinalize, storage.ErrIntegrityCheckFailed) {
 				http.Error(w, "File integrity check failed after upload. Hashes do not match.", http.StatusInternalServerError)
+			} else if errors.Is(errFinalize, storage.ErrSizeMismatch) {
+				http.Error(w, fmt.Sprintf("SIZE_MISMATCH: %v", errFinalize), http.StatusBadRequest)
+			} else if errors.Is(errFinalize, context.DeadlineExceeded) {
+				http.Error(w, "UPLOAD_TIMEOUT: finalize did not complete within timeouts.upload_finalize_timeout", http.StatusGatewayTimeout)
 			} else {
 				http.Error(w, fmt.Sprintf("Error finalizing upload: %v", errFinalize), http.StatusInternalServerError)
 			}
 			return
 		}
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Successfully finalized upload for storage '%s', path '%s'", storageName, itemPath)
-		}
-		w.WriteHeader(http.StatusOK)
+		itemPath = finalPath
+		storage.RecordChange(storageName, changeOp, finalPath)
+		slog.Info("Upload finalized", uploadLogAttrs()...)
+		w.Header().Set("Content-Type", "application/json")
+		// path è il percorso in cui il file è stato pubblicato (diverso da quello richiesto con auto_rename).
+		json.NewEncoder(w).Encode(map[string]interface{}{"upload_id": uploadID, "path": finalPath})
 
 	case "cancel":
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Handling upload cancel for storage '%s', path '%s'", storageName, itemPath)
-		}
-		var errCancel error // Rinominato per chiarezza
-
-		switch p := provider.(type) {
-		case *local.LocalFilesystemProvider:
-			errCancel = p.CancelUpload(claims, itemPath)
-		case *azureblob.AzureBlobStorageProvider:
-			errCancel = p.CancelUpload(r.Context(), claims, itemPath)
-		default:
-			errCancel = nil
+		slog.Info("Handling upload", uploadLogAttrs()...)
+		if sessionState == nil {
+			// Nessuna sessione da annullare (già completata, annullata o scaduta).
+			w.WriteHeader(http.StatusOK)
+			return
 		}
+		errCancel := cancelUpload()
+		removeSession()
 
-		wsHub.FileUploadsMutex.Lock()
-		delete(wsHub.OngoingFileUploads, uploadKey)
-		wsHub.FileUploadsMutex.Unlock()
-
-		if errCancel != nil {
+		if errCancel != nil && !errors.Is(errCancel, storage.ErrUploadNotFound) && !errors.Is(errCancel, storage.ErrNotFound) {
 			log.Printf("Error cancelling upload for '%s/%s': %v", storageName, itemPath, errCancel)
+			wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, errCancel)
 			if errors.Is(errCancel, storage.ErrPermissionDenied) {
 				http.Error(w, "Access denied: write permission required", http.StatusForbidden)
-			} else if !strings.Contains(errCancel.Error(), "no ongoing upload session found") && !errors.Is(errCancel, storage.ErrNotFound) {
+			} else {
 				http.Error(w, fmt.Sprintf("Error cancelling upload: %v", errCancel), http.StatusInternalServerError)
-				return
 			}
+			return
 		}
 		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Successfully handled upload cancel for storage '%s', path '%s'", storageName, itemPath)
+			log.Printf("Successfully handled upload cancel for storage '%s', path '%s', upload '%s'", storageName, itemPath, uploadID)
 		}
 		w.WriteHeader(http.StatusOK)
 
 	case "status":
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("Handling upload status for storage '%s', path '%s'", storageName, itemPath)
-		}
+		slog.Debug("Handling upload", uploadLogAttrs()...)
 		var uploadedSize int64
 		var errStatus error // Rinominato per chiarezza
 
-		switch p := provider.(type) {
-		case *local.LocalFilesystemProvider:
-			uploadedSize, errStatus = p.GetUploadedSize(claims, itemPath)
-		case *azureblob.AzureBlobStorageProvider:
-			uploadedSize, errStatus = p.GetUploadedSize(r.Context(), claims, itemPath)
-		default:
-			uploadedSize = 0
-			errStatus = nil
+		if sessionState == nil {
+			// Senza una sessione in corso lo stato è la dimensione del file già pubblicato, se esiste.
+			info, statErr := provider.GetItem(r.Context(), claims, itemPath)
+			if statErr == nil && !info.IsDir {
+				uploadedSize = info.Size
+			} else if statErr != nil && !errors.Is(statErr, storage.ErrNotFound) {
+				errStatus = statErr
+			}
+		} else {
+			switch p := provider.(type) {
+			case *local.LocalFilesystemProvider:
+				uploadedSize, errStatus = p.GetUploadedSize(claims, uploadID)
+			case *azureblob.AzureBlobStorageProvider:
+				uploadedSize, errStatus = p.GetUploadedSize(r.Context(), claims, uploadID)
+			case *command.CommandStorageProvider:
+				uploadedSize, errStatus = p.GetUploadedSize(claims, uploadID)
+			case *memory.MemoryStorageProvider:
+				uploadedSize, errStatus = p.GetUploadedSize(claims, uploadID)
+			case *gcs.GCSStorageProvider:
+				uploadedSize, errStatus = p.GetUploadedSize(r.Context(), claims, uploadID)
+			default:
+				uploadedSize = 0
+				errStatus = nil
+			}
 		}
 
 		if errStatus != nil {
+			wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, errStatus)
 			log.Printf("Error getting upload status for '%s/%s': %v", storageName, itemPath, errStatus)
 			if errors.Is(errStatus, storage.ErrPermissionDenied) {
 				http.Error(w, "Access denied: read permission required", http.StatusForbidden)
@@ -758,7 +1273,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]int64{"uploaded_size": uploadedSize})
+		json.NewEncoder(w).Encode(map[string]interface{}{"upload_id": uploadID, "uploaded_size": uploadedSize})
 
 	default:
 		log.Printf("Received invalid upload action: %s for storage '%s', path '%s'", action, storageName, itemPath)
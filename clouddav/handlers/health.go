@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// storageHealth is the outcome of the HealthCheck of a storage, as reported by /readyz.
+type storageHealth struct {
+	Status     string  `json:"status"` // "ok" o "error"
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// handleHealthz serves the liveness probe: risponde 200 finché il processo gestisce richieste HTTP,
+// senza toccare gli storage (un backend irraggiungibile non deve far riavviare il pod).
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz serves the readiness probe: esegue in parallelo HealthCheck su ogni storage registrato,
+// entro timeouts.readiness_check_timeout, e risponde 503 se uno fallisce o se il server è in shutdown
+// (shutdown_upload_grace), con il dettaglio per storage. Non richiede autenticazione: la risposta
+// contiene solo i nomi degli storage e gli errori dei backend.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	timeout, err := currentConfig().GetReadinessCheckTimeout()
+	if err != nil {
+		timeout = 3 * time.Second // Già validato da ReadConfig
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	providers := storage.GetAllProviders()
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name() < providers[j].Name() })
+	results := make(map[string]storageHealth, len(providers))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, provider := range providers {
+		wg.Add(1)
+		go func(provider storage.StorageProvider) {
+			defer wg.Done()
+			start := time.Now()
+			health := storageHealth{Status: "ok"}
+			if err := provider.HealthCheck(ctx); err != nil {
+				health = storageHealth{Status: "error", Error: err.Error()}
+			}
+			health.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+			resultsMu.Lock()
+			results[provider.Name()] = health
+			resultsMu.Unlock()
+		}(provider)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, provider := range providers {
+		if health := results[provider.Name()]; health.Status != "ok" {
+			ready = false
+			log.Printf("Readiness check of storage '%s' failed after %.0fms: %s", provider.Name(), health.DurationMs, health.Error)
+		}
+	}
+	draining := wsHub.UploadsDraining()
+	status := http.StatusOK
+	if !ready || draining {
+		status = http.StatusServiceUnavailable
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("[DEBUG] handleReadyz: %d storages checked, ready %t, draining %t", len(providers), ready, draining)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":    ready && !draining,
+		"draining": draining,
+		"storages": results,
+	})
+}
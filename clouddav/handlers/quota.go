@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// quotaUsageCacheTTL is how long the used space of a storage is reused before visiting it again.
+const quotaUsageCacheTTL = 30 * time.Second
+
+// quotaUsage is the cached used space of a storage. Il mutex resta acquisito durante il calcolo e la
+// verifica, così due initiate concorrenti non possono superare insieme la quota.
+type quotaUsage struct {
+	mu         sync.Mutex
+	used       int64
+	computedAt time.Time
+}
+
+var (
+	quotaUsagesMu sync.Mutex
+	quotaUsages   = make(map[string]*quotaUsage)
+)
+
+// checkStorageQuota verifies that an upload of uploadSize bytes fits in the quota_bytes of the storage and,
+// if so, adds it to the cached usage until the next refresh (gli upload avviati nel frattempo contano già).
+// Returns an error wrapping storage.ErrQuotaExceeded if it does not fit.
+func checkStorageQuota(ctx context.Context, claims *auth.UserClaims, provider storage.StorageProvider, uploadSize int64) error {
+	storageCfg := currentConfig().GetStorageConfig(provider.Name())
+	if storageCfg == nil || storageCfg.QuotaBytes <= 0 {
+		return nil
+	}
+
+	quotaUsagesMu.Lock()
+	usage, ok := quotaUsages[provider.Name()]
+	if !ok {
+		usage = &quotaUsage{}
+		quotaUsages[provider.Name()] = usage
+	}
+	quotaUsagesMu.Unlock()
+
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	if time.Since(usage.computedAt) > quotaUsageCacheTTL {
+		used, err := provider.GetUsedBytes(ctx, claims)
+		if err != nil {
+			return fmt.Errorf("error computing used space of storage '%s': %w", provider.Name(), err)
+		}
+		usage.used, usage.computedAt = used, time.Now()
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] checkStorageQuota: Storage '%s' uses %d of %d bytes", provider.Name(), used, storageCfg.QuotaBytes)
+		}
+	}
+
+	if usage.used+uploadSize > storageCfg.QuotaBytes {
+		return fmt.Errorf("%w: %d bytes used, %d requested, quota %d", storage.ErrQuotaExceeded, usage.used, uploadSize, storageCfg.QuotaBytes)
+	}
+	usage.used += uploadSize
+	return nil
+}
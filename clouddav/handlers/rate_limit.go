@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"clouddav/config"
+	"clouddav/internal/metrics"
+	"clouddav/internal/ratelimit"
+)
+
+// rateLimitMiddleware applies rate_limit_per_minute to the requests that passed AuthMiddleware, per utente
+// (per indirizzo del client per le richieste anonime, vedi ratelimit.Key).
+// /ws e /lp sono esclusi perché il limite vale per ogni messaggio (websocket.Hub), e la pagina principale
+// perché non tocca gli storage.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := currentConfig()
+		if cfg.RateLimitPerMinute <= 0 || r.URL.Path == "/" || r.URL.Path == "/ws" || r.URL.Path == "/lp" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var email string
+		if claims, _ := getClaimsFromContext(r.Context()); claims != nil {
+			email = claims.Email
+		}
+		user := ratelimit.Key(email, clientIP(r))
+		if allowed, retryAfter := ratelimit.Allow(user, cfg.RateLimitPerMinute, cfg.RateLimitBurst); !allowed {
+			metrics.RateLimited.Inc("http")
+			if config.IsLogLevel(config.LogLevelInfo) {
+				log.Printf("Request %s %s of user '%s' rejected: rate_limit_per_minute reached", r.Method, r.URL.Path, user)
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfterSeconds(retryAfter)))
+			http.Error(w, "RATE_LIMITED: too many requests, retry later", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/websocket"
+)
+
+func TestRateLimitMiddlewareKeysAnonymousClientsByAddress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	previousHub := wsHub
+	wsHub = websocket.NewHub(ctx, &config.Config{RateLimitPerMinute: 1, RateLimitBurst: 1})
+	t.Cleanup(func() { wsHub = previousHub })
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string, claims *auth.UserClaims) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/storages", nil)
+		r.RemoteAddr = remoteAddr
+		if claims != nil {
+			r = r.WithContext(context.WithValue(r.Context(), auth.ClaimsKey{}, claims))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		claims     *auth.UserClaims
+		want       int
+	}{
+		{"first anonymous client", "192.0.2.10:1000", nil, http.StatusOK},
+		{"same client again", "192.0.2.10:1001", nil, http.StatusTooManyRequests},
+		{"another anonymous client", "192.0.2.11:1000", nil, http.StatusOK},
+		{"user at the exhausted address", "192.0.2.10:1002", &auth.UserClaims{Email: "ratelimit@example.com"}, http.StatusOK},
+		{"same user from another address", "192.0.2.12:1000", &auth.UserClaims{Email: "ratelimit@example.com"}, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if got := request(tt.remoteAddr, tt.claims); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
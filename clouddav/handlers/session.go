@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+)
+
+const (
+	// sessionRefreshCookie holds the refresh token of the session, cifrato con auth.SealSessionValue.
+	sessionRefreshCookie = "session_refresh"
+	// sessionLifetime is the lifetime of the user_claims cookie.
+	sessionLifetime = 24 * time.Hour
+	// sessionRefreshWindow is how long before the expiry of user_claims the session is renewed.
+	sessionRefreshWindow = time.Hour
+)
+
+// sessionRefreshState is the content of the session_refresh cookie. ClaimsExpiry è la scadenza del cookie
+// user_claims, che il browser non invia al server.
+type sessionRefreshState struct {
+	RefreshToken string `json:"rt"`
+	ClaimsExpiry int64  `json:"exp"`
+}
+
+// sessionCookieSecure reports whether the session cookies must be Secure (richiesta arrivata in HTTPS al proxy).
+func sessionCookieSecure(r *http.Request) bool {
+	return r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// setSessionCookies stores claims in the user_claims cookie, firmato o cifrato (azure_ad.encrypt_session_cookie)
+// con auth.EncodeClaimsCookie, and, if Entra ID issued one and azure_ad.session_refresh_lifetime is not 0,
+// the refresh token in the session_refresh cookie.
+func setSessionCookies(w http.ResponseWriter, r *http.Request, claims *auth.UserClaims, refreshToken string) error {
+	secure := sessionCookieSecure(r)
+	claimsExpiry := time.Now().Add(sessionLifetime)
+	claimsValue, err := auth.EncodeClaimsCookie(claims, currentConfig().AzureAD.EncryptSessionCookie, claimsExpiry)
+	if err != nil {
+		return fmt.Errorf("failed to encode the session cookie of '%s': %w", claims.Email, err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "user_claims",
+		Value:    claimsValue,
+		Path:     "/",
+		Expires:  claimsExpiry,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	refreshLifetime, err := currentConfig().GetSessionRefreshLifetime()
+	if err != nil || refreshLifetime == 0 || refreshToken == "" {
+		return nil
+	}
+	stateJSON, _ := json.Marshal(sessionRefreshState{RefreshToken: refreshToken, ClaimsExpiry: claimsExpiry.Unix()})
+	sealed, err := auth.SealSessionValue(stateJSON)
+	if err != nil {
+		log.Printf("Error encrypting the session refresh token of '%s': %v", claims.Email, err)
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionRefreshCookie,
+		Value:    sealed,
+		Path:     "/",
+		Expires:  time.Now().Add(refreshLifetime),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// handleLogout handles /auth/logout: elimina i cookie di sessione (user_claims e session_refresh) e
+// rimanda a "/" oppure, con azure_ad.federated_logout, all'end_session_endpoint di Entra ID, che termina
+// anche la sessione del provider e torna a post_logout_redirect_url.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	var email string
+	if cookie, err := r.Cookie("user_claims"); err == nil {
+		if claims, err := auth.DecodeClaimsCookie(cookie.Value); err == nil {
+			email = claims.Email
+		}
+	}
+	clearClaimsCookie(w, r)
+	clearSessionRefreshCookie(w, r)
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("User '%s' logged out from %s (federated: %t)", email, clientIP(r), cfg.EnableAuth && cfg.AzureAD.FederatedLogout)
+	}
+
+	if !cfg.EnableAuth || !cfg.AzureAD.FederatedLogout {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	postLogoutRedirectURL, err := cfg.GetPostLogoutRedirectURL()
+	if err == nil {
+		var logoutURL string
+		if logoutURL, err = auth.GetLogoutURL(postLogoutRedirectURL); err == nil {
+			http.Redirect(w, r, logoutURL, http.StatusFound)
+			return
+		}
+	}
+	// La sessione locale è comunque chiusa: senza end_session_endpoint si torna alla home.
+	log.Printf("Federated logout unavailable, redirecting to home: %v", err)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// clearClaimsCookie expires the user_claims cookie.
+func clearClaimsCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "user_claims",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   sessionCookieSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionRefreshCookie expires the session_refresh cookie, so that a refresh token that failed is not
+// retried at every request.
+func clearSessionRefreshCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionRefreshCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   sessionCookieSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// refreshSessionIfDue renews the session with the refresh token of the session_refresh cookie when the
+// user_claims cookie is missing (scaduto) or expires within sessionRefreshWindow, and returns the new
+// claims. Restituisce nil se il rinnovo non serve o fallisce: in quel caso il cookie session_refresh viene
+// eliminato e la richiesta prosegue con il cookie user_claims, se ancora presente, o con il redirect a
+// /auth/login.
+func refreshSessionIfDue(w http.ResponseWriter, r *http.Request, hasClaimsCookie bool) *auth.UserClaims {
+	cookie, err := r.Cookie(sessionRefreshCookie)
+	if err != nil {
+		return nil
+	}
+	if refreshLifetime, err := currentConfig().GetSessionRefreshLifetime(); err != nil || refreshLifetime == 0 {
+		clearSessionRefreshCookie(w, r) // Rinnovo disattivato dopo l'emissione del cookie
+		return nil
+	}
+	var state sessionRefreshState
+	plaintext, err := auth.OpenSessionValue(cookie.Value)
+	if err == nil {
+		err = json.Unmarshal(plaintext, &state)
+	}
+	if err != nil || state.RefreshToken == "" {
+		if config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Discarding unreadable session refresh cookie from %s: %v", clientIP(r), err)
+		}
+		clearSessionRefreshCookie(w, r)
+		return nil
+	}
+	if hasClaimsCookie && time.Until(time.Unix(state.ClaimsExpiry, 0)) > sessionRefreshWindow {
+		return nil
+	}
+
+	claims, refreshToken, err := auth.RefreshSession(r.Context(), state.RefreshToken)
+	if err != nil {
+		log.Printf("Session refresh failed from %s, falling back to interactive login: %v", clientIP(r), err)
+		clearSessionRefreshCookie(w, r)
+		return nil
+	}
+	if auth.IsUserAuthorized(claims, currentConfig()) {
+		if err := setSessionCookies(w, r, claims, refreshToken); err != nil {
+			log.Printf("Error storing the refreshed session: %v", err)
+		}
+	} else {
+		clearSessionRefreshCookie(w, r) // AuthMiddleware risponde 403
+	}
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Session refreshed for user: %s", claims.Email)
+	}
+	return claims
+}
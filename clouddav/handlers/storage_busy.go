@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// storageBusyRetryAfterSeconds is the Retry-After sent when a storage has no free slot of max_concurrent_operations.
+const storageBusyRetryAfterSeconds = "1"
+
+// writeStorageBusy answers 503 to a request that found its storage saturated (storage.ErrUnavailable):
+// le operazioni hanno già atteso operation_queue_timeout, quindi il client può riprovare subito.
+func writeStorageBusy(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", storageBusyRetryAfterSeconds)
+	http.Error(w, fmt.Sprintf("STORAGE_BUSY: %v", err), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+// errThumbnailToolUnavailable indicates that the external tool needed for a content type is not configured or not installed.
+var errThumbnailToolUnavailable = errors.New("thumbnail tool not configured or not available")
+
+// thumbnailGenerator renders a JPEG thumbnail of at most size pixels per side of srcPath into dstPath.
+type thumbnailGenerator func(ctx context.Context, srcPath string, dstPath string, size int) error
+
+// thumbnailGeneratorFor returns the generator for a content type. supported è false se il tipo non
+// ha un generatore (415); un generatore che richiede uno strumento assente restituisce errThumbnailToolUnavailable (501).
+// Le immagini JPEG, PNG e GIF vengono elaborate internamente.
+func thumbnailGeneratorFor(contentType string) (generator thumbnailGenerator, supported bool) {
+	switch {
+	case contentType == "image/jpeg" || contentType == "image/png" || contentType == "image/gif":
+		return generateImageThumbnail, true
+	case contentType == "application/pdf":
+		return generatePDFThumbnail, true
+	case strings.HasPrefix(contentType, "video/"):
+		return generateVideoThumbnail, true
+	}
+	return nil, false
+}
+
+// lookupThumbnailTool resolves the configured command of an external tool.
+func lookupThumbnailTool(command string) (string, error) {
+	if command == "" {
+		return "", errThumbnailToolUnavailable
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errThumbnailToolUnavailable, err)
+	}
+	return path, nil
+}
+
+// thumbnailToolSlots limits the external tool processes running at the same time
+// (thumbnails.max_concurrent_tools).
+var (
+	thumbnailToolSlotsMu sync.Mutex
+	thumbnailToolSlots   chan struct{}
+)
+
+// currentThumbnailToolSlots returns the semaphore for limit, replacing it if a reload changed the limit.
+// I processi già avviati (e quelli in attesa) restano sul semaforo precedente e lo rilasciano lì.
+func currentThumbnailToolSlots(limit int) chan struct{} {
+	thumbnailToolSlotsMu.Lock()
+	defer thumbnailToolSlotsMu.Unlock()
+	if thumbnailToolSlots == nil || cap(thumbnailToolSlots) != limit {
+		thumbnailToolSlots = make(chan struct{}, limit)
+	}
+	return thumbnailToolSlots
+}
+
+// runThumbnailTool runs cmd once a slot of thumbnailToolSlots is free, waiting at most until ctx ends.
+func runThumbnailTool(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	slots := currentThumbnailToolSlots(currentConfig().Thumbnails.MaxConcurrentTools)
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a free thumbnail tool slot: %w", ctx.Err())
+	}
+	defer func() { <-slots }()
+	return cmd.CombinedOutput()
+}
+
+// generatePDFThumbnail renders the first page of a PDF with pdftoppm.
+func generatePDFThumbnail(ctx context.Context, srcPath string, dstPath string, size int) error {
+	tool, err := lookupThumbnailTool(currentConfig().Thumbnails.PDFRenderer)
+	if err != nil {
+		return err
+	}
+	// pdftoppm aggiunge l'estensione .jpg al prefisso di output.
+	outPrefix := strings.TrimSuffix(dstPath, ".jpg")
+	cmd := exec.CommandContext(ctx, tool, "-jpeg", "-f", "1", "-l", "1", "-singlefile", "-scale-to", strconv.Itoa(size), srcPath, outPrefix)
+	if output, err := runThumbnailTool(ctx, cmd); err != nil {
+		return fmt.Errorf("pdftoppm failed: %w (%s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// generateVideoThumbnail extracts a representative frame of a video with ffmpeg. Il file caricato
+// dall'utente non è fidato: il demuxer è imposto in base al contenitore riconosciuto (una playlist HLS o
+// concat rinominata .mp4 viene rifiutata) e ffmpeg può aprire solo file locali, mai URL.
+func generateVideoThumbnail(ctx context.Context, srcPath string, dstPath string, size int) error {
+	tool, err := lookupThumbnailTool(currentConfig().Thumbnails.VideoRenderer)
+	if err != nil {
+		return err
+	}
+	demuxer, err := detectVideoDemuxer(srcPath)
+	if err != nil {
+		return err
+	}
+	scale := fmt.Sprintf("thumbnail,scale=w=%d:h=%d:force_original_aspect_ratio=decrease", size, size)
+	cmd := exec.CommandContext(ctx, tool, "-y", "-loglevel", "error", "-protocol_whitelist", "file", "-f", demuxer, "-i", "file:"+srcPath,
+		"-vf", scale, "-frames:v", "1", "-f", "image2", "-c:v", "mjpeg", dstPath)
+	if output, err := runThumbnailTool(ctx, cmd); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w (%s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// thumbnailCacheDir returns the directory where generated thumbnails are cached.
+func thumbnailCacheDir() string {
+	cfg := currentConfig()
+	if cfg.Thumbnails.CacheDir != "" {
+		return cfg.Thumbnails.CacheDir
+	}
+	return filepath.Join(os.TempDir(), "clouddav-thumbnails")
+}
+
+// handleThumbnail returns a JPEG thumbnail of a stored file, generated by content type and cached
+// by storage, path, modification time and size.
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	claims, _ := getClaimsFromContext(r.Context())
+
+	storageName := r.URL.Query().Get("storage")
+	itemPath := cfg.NormalizeStoragePath(storageName, r.URL.Query().Get("path"))
+	if storageName == "" || itemPath == "" {
+		http.Error(w, "Parameters 'storage' and 'path' required", http.StatusBadRequest)
+		return
+	}
+	size := cfg.Thumbnails.DefaultSize
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+		parsed, err := strconv.Atoi(sizeStr)
+		if err != nil || parsed <= 0 || parsed > cfg.Thumbnails.MaxSize {
+			http.Error(w, fmt.Sprintf("Parameter 'size' must be between 1 and %d", cfg.Thumbnails.MaxSize), http.StatusBadRequest)
+			return
+		}
+		size = parsed
+	}
+
+	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, itemPath, "read", cfg); err != nil {
+		wsHub.RecordError(claims, "thumbnail", storageName, itemPath, err)
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			http.Error(w, "Access denied: read permission required", http.StatusForbidden)
+		} else {
+			log.Printf("Error checking storage access for thumbnail '%s/%s': %v", storageName, itemPath, err)
+			http.Error(w, "Internal server error during access check", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	provider, ok := storage.GetProvider(storageName)
+	if !ok {
+		http.Error(w, "Storage provider not found", http.StatusNotFound)
+		return
+	}
+
+	itemInfo, err := provider.GetItem(r.Context(), claims, itemPath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+		} else {
+			wsHub.RecordError(claims, "thumbnail", storageName, itemPath, err)
+			log.Printf("Error getting item '%s/%s' for thumbnail: %v", storageName, itemPath, err)
+			http.Error(w, "Error reading item", http.StatusInternalServerError)
+		}
+		return
+	}
+	if itemInfo.IsDir {
+		http.Error(w, "IS_A_DIRECTORY: cannot create a thumbnail of a directory", http.StatusBadRequest)
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(filepath.Ext(itemPath))))
+	generator, supported := thumbnailGeneratorFor(contentType)
+	if !supported {
+		http.Error(w, "Thumbnails are not supported for this file type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if itemInfo.Size > cfg.Thumbnails.MaxSourceSizeMB<<20 {
+		http.Error(w, fmt.Sprintf("File too large for a thumbnail (max %d MB)", cfg.Thumbnails.MaxSourceSizeMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// La chiave include la data di modifica: un file modificato genera una nuova thumbnail.
+	keySum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d", storageName, itemPath, itemInfo.ModTime.UnixNano(), size)))
+	cacheKey := hex.EncodeToString(keySum[:])
+	etag := `"` + cacheKey[:32] + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	cacheDir := thumbnailCacheDir()
+	cachedPath := filepath.Join(cacheDir, cacheKey+".jpg")
+	if _, err := os.Stat(cachedPath); err != nil {
+		if err := generateThumbnail(r.Context(), provider, claims, itemPath, generator, cacheDir, cachedPath, size); err != nil {
+			if errors.Is(err, errThumbnailToolUnavailable) {
+				http.Error(w, "Thumbnails for this file type are not available on this server", http.StatusNotImplemented)
+				return
+			}
+			if errors.Is(err, errThumbnailSourceTooLarge) {
+				http.Error(w, "File too large for a thumbnail", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if errors.Is(err, errThumbnailUnsupportedFormat) {
+				http.Error(w, "Thumbnails are not supported for the content of this file", http.StatusUnsupportedMediaType)
+				return
+			}
+			wsHub.RecordError(claims, "thumbnail", storageName, itemPath, err)
+			log.Printf("Error generating thumbnail for '%s/%s': %v", storageName, itemPath, err)
+			http.Error(w, "Error generating thumbnail", http.StatusInternalServerError)
+			return
+		}
+		scheduleThumbnailCacheEviction(cacheDir)
+	} else {
+		// La data di modifica segna l'ultimo utilizzo: l'eviction rimuove prima le thumbnail non richieste da più tempo.
+		now := time.Now()
+		os.Chtimes(cachedPath, now, now)
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] handleThumbnail: Serving cached thumbnail for '%s/%s' (size %d)", storageName, itemPath, size)
+		}
+	}
+
+	thumbnail, err := os.Open(cachedPath)
+	if err != nil {
+		log.Printf("Error opening cached thumbnail '%s': %v", cachedPath, err)
+		http.Error(w, "Error reading thumbnail", http.StatusInternalServerError)
+		return
+	}
+	defer thumbnail.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("ETag", etag)
+	if _, err := io.Copy(w, thumbnail); err != nil {
+		log.Printf("Error writing thumbnail for '%s/%s': %v", storageName, itemPath, err)
+	}
+}
+
+// generateThumbnail copies the source to a local temp file (the external tools need a path),
+// runs the generator with the configured timeout and atomically moves the result into the cache.
+func generateThumbnail(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, itemPath string, generator thumbnailGenerator, cacheDir string, cachedPath string, size int) error {
+	cfg := currentConfig()
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("error creating thumbnail cache directory '%s': %w", cacheDir, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, thumbnailTimeout())
+	defer cancel()
+
+	reader, err := provider.OpenReader(ctx, claims, itemPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	source, err := os.CreateTemp(cacheDir, "source-*"+filepath.Ext(itemPath))
+	if err != nil {
+		return fmt.Errorf("error creating thumbnail source file: %w", err)
+	}
+	defer os.Remove(source.Name())
+	maxSourceSize := cfg.Thumbnails.MaxSourceSizeMB << 20
+	copied, err := io.Copy(source, io.LimitReader(reader, maxSourceSize+1))
+	source.Close()
+	if err != nil {
+		return fmt.Errorf("error copying thumbnail source: %w", err)
+	}
+	if copied > maxSourceSize {
+		return fmt.Errorf("%w: exceeds %d MB", errThumbnailSourceTooLarge, cfg.Thumbnails.MaxSourceSizeMB)
+	}
+
+	// Il file generato viene rinominato solo a generazione completata, così richieste concorrenti
+	// non servono mai una thumbnail parziale.
+	tempOutput := strings.TrimSuffix(source.Name(), filepath.Ext(source.Name())) + "-out.jpg"
+	defer os.Remove(tempOutput)
+	if err := generator(ctx, source.Name(), tempOutput, size); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("thumbnail generation timed out: %w", ctx.Err())
+		}
+		return err
+	}
+	if _, err := os.Stat(tempOutput); err != nil {
+		return fmt.Errorf("thumbnail tool produced no output: %w", err)
+	}
+	return os.Rename(tempOutput, cachedPath)
+}
+
+// thumbnailTimeout returns the configured generation timeout.
+func thumbnailTimeout() time.Duration {
+	timeout, err := currentConfig().GetThumbnailTimeout()
+	if err != nil {
+		return 30 * time.Second
+	}
+	return timeout
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"clouddav/config"
+)
+
+// thumbnailCacheEvictInterval is the minimum time between two eviction passes of the thumbnail cache.
+const thumbnailCacheEvictInterval = time.Minute
+
+// thumbnailCacheLastEviction is the time (UnixNano) of the last eviction pass started.
+var thumbnailCacheLastEviction atomic.Int64
+
+// scheduleThumbnailCacheEviction starts an eviction pass of cacheDir in background, at most once every
+// thumbnailCacheEvictInterval: viene chiamata dopo ogni thumbnail generata, cioè quando la cache cresce.
+func scheduleThumbnailCacheEviction(cacheDir string) {
+	now := time.Now().UnixNano()
+	last := thumbnailCacheLastEviction.Load()
+	if now-last < int64(thumbnailCacheEvictInterval) || !thumbnailCacheLastEviction.CompareAndSwap(last, now) {
+		return
+	}
+	thumbnails := currentConfig().Thumbnails
+	maxAge, err := currentConfig().GetThumbnailCacheMaxAge()
+	if err != nil {
+		maxAge = 0
+	}
+	go evictThumbnailCache(cacheDir, maxAge, thumbnails.CacheMaxSizeMB<<20, thumbnailTimeout())
+}
+
+// evictThumbnailCache removes the thumbnails of cacheDir not used for more than maxAge (0 = nessun limite)
+// and then the least recently used ones until the cache is within maxBytes (0 = nessun limite). I file
+// sorgente temporanei più vecchi di staleSource (rimasti da un'interruzione) vengono rimossi comunque.
+// Restituisce il numero di file rimossi.
+func evictThumbnailCache(cacheDir string, maxAge time.Duration, maxBytes int64, staleSource time.Duration) int {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading thumbnail cache directory '%s': %v", cacheDir, err)
+		}
+		return 0
+	}
+	type cachedThumbnail struct {
+		path    string
+		size    int64
+		lastUse time.Time
+	}
+	now := time.Now()
+	var thumbnails []cachedThumbnail
+	var totalBytes int64
+	removed := 0
+	remove := func(path string) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove '%s' from the thumbnail cache: %v", path, err)
+			return
+		}
+		removed++
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(cacheDir, entry.Name())
+		if strings.HasPrefix(entry.Name(), "source-") {
+			if now.Sub(info.ModTime()) > 2*staleSource {
+				remove(path)
+			}
+			continue
+		}
+		if filepath.Ext(entry.Name()) != ".jpg" {
+			continue
+		}
+		if maxAge > 0 && now.Sub(info.ModTime()) > maxAge {
+			remove(path)
+			continue
+		}
+		thumbnails = append(thumbnails, cachedThumbnail{path: path, size: info.Size(), lastUse: info.ModTime()})
+		totalBytes += info.Size()
+	}
+
+	if maxBytes > 0 && totalBytes > maxBytes {
+		sort.Slice(thumbnails, func(i, j int) bool { return thumbnails[i].lastUse.Before(thumbnails[j].lastUse) })
+		for _, thumbnail := range thumbnails {
+			if totalBytes <= maxBytes {
+				break
+			}
+			remove(thumbnail.path)
+			totalBytes -= thumbnail.size
+		}
+	}
+	if removed > 0 && config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Thumbnail cache '%s': removed %d files, %d MB left", cacheDir, removed, totalBytes>>20)
+	}
+	return removed
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvictThumbnailCache(t *testing.T) {
+	type cacheFile struct {
+		name string
+		size int
+		age  time.Duration
+	}
+	files := []cacheFile{
+		{"old.jpg", 100, 10 * 24 * time.Hour},
+		{"lru.jpg", 100, 3 * time.Hour},
+		{"middle.jpg", 100, 2 * time.Hour},
+		{"recent.jpg", 100, time.Minute},
+		{"source-stale", 100, time.Hour},
+		{"source-active", 100, time.Second},
+		{"notes.txt", 100, 10 * 24 * time.Hour},
+	}
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		maxBytes int64
+		kept     []string
+	}{
+		{"no limits", 0, 0, []string{"old.jpg", "lru.jpg", "middle.jpg", "recent.jpg", "source-active", "notes.txt"}},
+		{"max age", 7 * 24 * time.Hour, 0, []string{"lru.jpg", "middle.jpg", "recent.jpg", "source-active", "notes.txt"}},
+		{"max size removes least recently used", 0, 250, []string{"middle.jpg", "recent.jpg", "source-active", "notes.txt"}},
+		{"max age and size", 7 * 24 * time.Hour, 100, []string{"recent.jpg", "source-active", "notes.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			for _, f := range files {
+				path := filepath.Join(cacheDir, f.name)
+				if err := os.WriteFile(path, make([]byte, f.size), 0644); err != nil {
+					t.Fatal(err)
+				}
+				modTime := time.Now().Add(-f.age)
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			removed := evictThumbnailCache(cacheDir, tt.maxAge, tt.maxBytes, 10*time.Minute)
+			if want := len(files) - len(tt.kept); removed != want {
+				t.Errorf("removed %d files, want %d", removed, want)
+			}
+			kept := make(map[string]bool)
+			for _, name := range tt.kept {
+				kept[name] = true
+			}
+			for _, f := range files {
+				_, err := os.Stat(filepath.Join(cacheDir, f.name))
+				if exists := err == nil; exists != kept[f.name] {
+					t.Errorf("%s: exists = %t, want %t", f.name, exists, kept[f.name])
+				}
+			}
+		})
+	}
+}
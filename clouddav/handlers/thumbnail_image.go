@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Registra il decoder GIF per image.Decode
+	"image/jpeg"
+	_ "image/png" // Registra il decoder PNG per image.Decode
+	"os"
+)
+
+// maxThumbnailImagePixels limits the decoded size of a source image: un PNG di pochi MB può dichiarare
+// dimensioni enormi, e l'immagine decodificata occupa 4 byte per pixel (64 Mpx = 256 MB).
+const maxThumbnailImagePixels = 64 << 20
+
+// thumbnailJPEGQuality is the JPEG quality of the thumbnails generated in-process.
+const thumbnailJPEGQuality = 85
+
+// errThumbnailSourceTooLarge indicates that the source cannot be processed within the configured limits (413).
+var errThumbnailSourceTooLarge = errors.New("thumbnail source too large")
+
+// generateImageThumbnail decodes a JPEG, PNG or GIF image and scales it down to at most size pixels per
+// side, without external tools. Le immagini più piccole di size non vengono ingrandite.
+func generateImageThumbnail(ctx context.Context, srcPath string, dstPath string, size int) error {
+	source, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// Le dimensioni si leggono dall'header prima di decodificare, così il limite vale prima di allocare.
+	imgConfig, _, err := image.DecodeConfig(source)
+	if err != nil {
+		return fmt.Errorf("error reading image header: %w", err)
+	}
+	if int64(imgConfig.Width)*int64(imgConfig.Height) > maxThumbnailImagePixels {
+		return fmt.Errorf("%w: image is %dx%d pixels", errThumbnailSourceTooLarge, imgConfig.Width, imgConfig.Height)
+	}
+	if _, err := source.Seek(0, 0); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(source)
+	if err != nil {
+		return fmt.Errorf("error decoding image: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	output, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(output, scaleDownImage(img, size), &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+		output.Close()
+		return fmt.Errorf("error encoding thumbnail: %w", err)
+	}
+	return output.Close()
+}
+
+// scaleDownImage resizes img so that its longest side is at most size, keeping the aspect ratio.
+// Ogni pixel di destinazione è la media dei pixel sorgente che copre (box filter), che per le riduzioni
+// dà un risultato senza aliasing senza dipendenze esterne.
+func scaleDownImage(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := srcWidth, srcHeight
+	if srcWidth > size || srcHeight > size {
+		if srcWidth >= srcHeight {
+			dstWidth, dstHeight = size, max(1, srcHeight*size/srcWidth)
+		} else {
+			dstWidth, dstHeight = max(1, srcWidth*size/srcHeight), size
+		}
+	}
+
+	// Conversione in RGBA non premoltiplicato su sfondo bianco: il JPEG non ha trasparenza.
+	src := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Over)
+	if dstWidth == srcWidth && dstHeight == srcHeight {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for dy := 0; dy < dstHeight; dy++ {
+		y0, y1 := dy*srcHeight/dstHeight, max((dy+1)*srcHeight/dstHeight, dy*srcHeight/dstHeight+1)
+		for dx := 0; dx < dstWidth; dx++ {
+			x0, x1 := dx*srcWidth/dstWidth, max((dx+1)*srcWidth/dstWidth, dx*srcWidth/dstWidth+1)
+			var r, g, b, count uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+				}
+				count += uint64(x1 - x0)
+			}
+			offset := dy*dst.Stride + dx*4
+			dst.Pix[offset] = uint8(r / count)
+			dst.Pix[offset+1] = uint8(g / count)
+			dst.Pix[offset+2] = uint8(b / count)
+			dst.Pix[offset+3] = 0xff
+		}
+	}
+	return dst
+}
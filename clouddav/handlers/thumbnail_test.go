@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"clouddav/config"
+	"clouddav/websocket"
+)
+
+func TestThumbnailToolSlotsFollowReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cfg := &config.Config{}
+	cfg.Thumbnails.MaxConcurrentTools = 1
+	previousHub := wsHub
+	wsHub = websocket.NewHub(ctx, cfg)
+	t.Cleanup(func() {
+		wsHub = previousHub
+		thumbnailToolSlotsMu.Lock()
+		thumbnailToolSlots = nil
+		thumbnailToolSlotsMu.Unlock()
+	})
+	// Il binario di test senza test da eseguire fa da strumento che termina subito con successo.
+	tool := func() *exec.Cmd { return exec.Command(os.Args[0], "-test.run=^$") }
+	run := func() error {
+		waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err := runThumbnailTool(waitCtx, tool())
+		return err
+	}
+
+	if err := run(); err != nil {
+		t.Fatalf("runThumbnailTool with a free slot: %v", err)
+	}
+	held := currentThumbnailToolSlots(1)
+	held <- struct{}{} // Occupa l'unico slot, come un processo in esecuzione
+	if err := run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("runThumbnailTool with every slot busy = %v, want a timeout", err)
+	}
+
+	reloaded := *cfg
+	reloaded.Thumbnails.MaxConcurrentTools = 2
+	wsHub.SetConfig(&reloaded)
+	if err := run(); err != nil {
+		t.Errorf("runThumbnailTool after raising max_concurrent_tools: %v", err)
+	}
+	if slots := currentThumbnailToolSlots(2); cap(slots) != 2 {
+		t.Errorf("semaphore size after the reload = %d, want 2", cap(slots))
+	}
+	<-held
+}
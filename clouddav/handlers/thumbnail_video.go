@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errThumbnailUnsupportedFormat indicates that the content of the source is not a recognized format (415).
+var errThumbnailUnsupportedFormat = errors.New("unsupported thumbnail source format")
+
+// mpegTSPacketSize is the size of an MPEG transport stream packet, which starts with the 0x47 sync byte.
+const mpegTSPacketSize = 188
+
+// detectVideoDemuxer returns the ffmpeg demuxer of the video container of srcPath, riconosciuto dai
+// magic byte e non dall'estensione. Qualsiasi altro contenuto (playlist HLS, file concat, testo) restituisce
+// errThumbnailUnsupportedFormat: con il probing automatico ffmpeg seguirebbe i riferimenti che contiene.
+func detectVideoDemuxer(srcPath string) (string, error) {
+	source, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+	header := make([]byte, 2*mpegTSPacketSize+1)
+	n, err := io.ReadFull(source, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", fmt.Errorf("%w: %v", errThumbnailUnsupportedFormat, err)
+	}
+	header = header[:n]
+
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return "mov", nil // MP4, MOV, M4V, 3GP
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "matroska", nil // Matroska e WebM
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("AVI ")):
+		return "avi", nil
+	case bytes.HasPrefix(header, []byte("FLV")):
+		return "flv", nil
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return "ogg", nil
+	case bytes.HasPrefix(header, []byte{0x00, 0x00, 0x01, 0xBA}):
+		return "mpeg", nil // MPEG program stream
+	case len(header) > 2*mpegTSPacketSize && header[0] == 0x47 && header[mpegTSPacketSize] == 0x47 && header[2*mpegTSPacketSize] == 0x47:
+		return "mpegts", nil
+	}
+	return "", fmt.Errorf("%w: not a recognized video container", errThumbnailUnsupportedFormat)
+}
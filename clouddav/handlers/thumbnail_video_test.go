@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectVideoDemuxer(t *testing.T) {
+	mpegTS := make([]byte, 3*mpegTSPacketSize)
+	for i := 0; i < len(mpegTS); i += mpegTSPacketSize {
+		mpegTS[i] = 0x47
+	}
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"mp4", append([]byte{0, 0, 0, 0x18}, []byte("ftypisom\x00\x00\x02\x00")...), "mov"},
+		{"webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x86, 0x81}, "matroska"},
+		{"avi", []byte("RIFF\x10\x00\x00\x00AVI LIST"), "avi"},
+		{"flv", []byte("FLV\x01\x05\x00\x00\x00\x09"), "flv"},
+		{"ogg", []byte("OggS\x00\x02"), "ogg"},
+		{"mpeg program stream", []byte{0x00, 0x00, 0x01, 0xBA, 0x44}, "mpeg"},
+		{"mpeg transport stream", mpegTS, "mpegts"},
+		{"hls playlist renamed .mp4", []byte("#EXTM3U\n#EXTINF:10,\nhttp://169.254.169.254/latest/meta-data\n"), ""},
+		{"concat list", []byte("ffconcat version 1.0\nfile /etc/passwd\n"), ""},
+		{"single sync byte", bytes.Repeat([]byte{0x47}, 10), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcPath := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(srcPath, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := detectVideoDemuxer(srcPath)
+			if tt.want == "" {
+				if !errors.Is(err, errThumbnailUnsupportedFormat) {
+					t.Errorf("detectVideoDemuxer = %q, %v; want errThumbnailUnsupportedFormat", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("detectVideoDemuxer = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"clouddav/auth"
+	"clouddav/storage"
+)
+
+// writePreconditionError answers an upload initiate whose expected_mod_time/expected_etag could not be verified.
+// Un file eliminato dopo la lettura del client è anch'esso una versione diversa da quella attesa: 409 PRECONDITION_FAILED.
+func writePreconditionError(w http.ResponseWriter, claims *auth.UserClaims, action, storageName, itemPath string, err error) {
+	wsHub.RecordError(claims, "upload_"+action, storageName, itemPath, err)
+	switch {
+	case errors.Is(err, storage.ErrPreconditionFailed), errors.Is(err, storage.ErrNotFound):
+		http.Error(w, fmt.Sprintf("PRECONDITION_FAILED: %v", err), http.StatusConflict)
+	case errors.Is(err, storage.ErrPermissionDenied):
+		http.Error(w, "Access denied: read permission required", http.StatusForbidden)
+	case errors.Is(err, storage.ErrNotImplemented):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error checking the expected version of '%s/%s': %v", storageName, itemPath, err)
+		http.Error(w, "Error checking the expected version of the file", http.StatusInternalServerError)
+	}
+}
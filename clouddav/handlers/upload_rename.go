@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// errNoFreeName is returned by reserveUploadName when every candidate name is taken.
+var errNoFreeName = errors.New("no free name available")
+
+// Nomi scelti con auto_rename e non ancora occupati (registrati in OngoingFileUploads o pubblicati). Senza la
+// prenotazione due upload concorrenti dello stesso file potrebbero vedere libero lo stesso "nome (1)".
+var (
+	uploadNameReservationsMu sync.Mutex
+	uploadNameReservations   = make(map[string]struct{})
+)
+
+// autoRenameCandidate returns the n-th alternative for itemPath according to upload_auto_rename.pattern
+// (n = 0 is itemPath itself).
+func autoRenameCandidate(itemPath string, n int) string {
+	return currentConfig().UploadAutoRename.Candidate(itemPath, n)
+}
+
+// reserveUploadName returns the first name derived from itemPath that neither exists on the storage nor is
+// being uploaded (escluso l'upload excludeID) or reserved, and reserves it until release is called. Il
+// chiamante deve chiamare release dopo aver registrato la sessione in OngoingFileUploads o pubblicato il
+// file al finalize (o se l'operazione fallisce).
+func reserveUploadName(ctx context.Context, claims *auth.UserClaims, provider storage.StorageProvider, storageName string, itemPath string, excludeID string) (resolvedPath string, release func(), err error) {
+	cfg := currentConfig()
+	for n := 0; n <= cfg.UploadAutoRename.MaxAttempts; n++ {
+		candidate := autoRenameCandidate(itemPath, n)
+		key := fmt.Sprintf("%s:%s", storageName, candidate)
+
+		uploadNameReservationsMu.Lock()
+		wsHub.FileUploadsMutex.Lock()
+		_, _, uploading := wsHub.UploadForPath(storageName, candidate, excludeID)
+		wsHub.FileUploadsMutex.Unlock()
+		_, reserved := uploadNameReservations[key]
+		if uploading || reserved {
+			uploadNameReservationsMu.Unlock()
+			continue
+		}
+		uploadNameReservations[key] = struct{}{}
+		uploadNameReservationsMu.Unlock()
+
+		release = func() {
+			uploadNameReservationsMu.Lock()
+			delete(uploadNameReservations, key)
+			uploadNameReservationsMu.Unlock()
+		}
+		_, statErr := provider.GetItem(ctx, claims, candidate)
+		if errors.Is(statErr, storage.ErrNotFound) {
+			if n > 0 && config.IsLogLevel(config.LogLevelInfo) {
+				log.Printf("Upload auto_rename: '%s/%s' already exists, using '%s'", storageName, itemPath, candidate)
+			}
+			return candidate, release, nil
+		}
+		release()
+		if statErr != nil {
+			return "", nil, fmt.Errorf("checking if '%s' exists: %w", candidate, statErr)
+		}
+	}
+	return "", nil, fmt.Errorf("%w for '%s' after %d attempts", errNoFreeName, itemPath, cfg.UploadAutoRename.MaxAttempts)
+}
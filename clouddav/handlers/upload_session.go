@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+
+	"clouddav/auth"
+	"clouddav/websocket"
+)
+
+// uploadSessionOwner returns the user of an upload session, confrontato con quello della richiesta per
+// chunk, finalize, cancel e status ("" per gli upload anonimi).
+func uploadSessionOwner(claims *auth.UserClaims) string {
+	if claims == nil {
+		return ""
+	}
+	return claims.Email
+}
+
+// findUploadSession returns the ongoing upload of the user identified by uploadID or, for the clients
+// che non inviano upload_id, by the destination itemPath of storageName. Restituisce l'upload ID e la
+// sessione, oppure false se non esiste o appartiene a un altro utente.
+func findUploadSession(claims *auth.UserClaims, storageName string, itemPath string, uploadID string) (string, *websocket.UploadSessionState, bool) {
+	wsHub.FileUploadsMutex.Lock()
+	defer wsHub.FileUploadsMutex.Unlock()
+
+	owner := uploadSessionOwner(claims)
+	if uploadID != "" {
+		sessionState, exists := wsHub.OngoingFileUploads[uploadID]
+		if !exists || sessionState.StorageName != storageName || uploadSessionOwner(sessionState.Claims) != owner {
+			return "", nil, false
+		}
+		return uploadID, sessionState, true
+	}
+	for id, sessionState := range wsHub.OngoingFileUploads {
+		if sessionState.StorageName == storageName && sessionState.ItemPath == itemPath && uploadSessionOwner(sessionState.Claims) == owner {
+			return id, sessionState, true
+		}
+	}
+	return "", nil, false
+}
+
+// countChunkBody counts the body of an upload request as progress of its session while it arrives, if the
+// client sent storage and upload_id in the query string: i campi del form sono disponibili solo dopo aver
+// letto tutto il body, chunk compreso.
+func countChunkBody(r *http.Request, claims *auth.UserClaims) {
+	query := r.URL.Query()
+	if query.Get("upload_id") == "" {
+		return
+	}
+	if _, sessionState, exists := findUploadSession(claims, query.Get("storage"), "", query.Get("upload_id")); exists {
+		r.Body = sessionState.CountStreamedBytes(r.Body)
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/webdav"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+// webdavPrefix is the URL prefix of the WebDAV endpoint; il segmento successivo è il nome dello storage.
+const webdavPrefix = "/webdav"
+
+// webdavDummyHash is compared against the password of unknown users, so that the response time does not
+// reveal which usernames exist (hash bcrypt di una password casuale, con il costo di default).
+const webdavDummyHash = "$2a$10$BixJhfunNzwX2RPT6ueGX.BjUXafr.BBwSm0kh5SKPiiXsghWMj3S"
+
+// webdavHandler serves the WebDAV methods on the storages. I lock (LOCK/UNLOCK, usati da Windows e macOS
+// prima di scrivere) sono tenuti in memoria: un riavvio li rilascia.
+var webdavHandler = &webdav.Handler{
+	Prefix:     webdavPrefix,
+	FileSystem: storageFileSystem{},
+	LockSystem: webdav.NewMemLS(),
+	Logger: func(r *http.Request, err error) {
+		if err != nil && config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	},
+}
+
+// WebDAVAuthMiddleware authenticates the WebDAV requests. I client che hanno la sessione dell'applicazione
+// (cookie) o un token di api_tokens passano da AuthMiddleware; gli altri si autenticano in HTTP Basic con gli utenti di webdav.users.
+// Senza credenziali risponde 401 con la challenge Basic invece del redirect al login, che un client WebDAV
+// non saprebbe seguire. rate_limit_per_minute vale anche per gli utenti Basic, come in AuthMiddleware.
+func WebDAVAuthMiddleware(next http.Handler) http.Handler {
+	limited := rateLimitMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := currentConfig()
+		if !cfg.EnableAuth {
+			limited.ServeHTTP(w, r)
+			return
+		}
+
+		username, password, hasBasic := r.BasicAuth()
+		if !hasBasic {
+			_, hasBearer := bearerToken(r)
+			_, claimsErr := r.Cookie("user_claims")
+			_, refreshErr := r.Cookie(sessionRefreshCookie)
+			if claimsErr == nil || refreshErr == nil || hasBearer {
+				AuthMiddleware(next).ServeHTTP(w, r)
+				return
+			}
+			webdavChallenge(w)
+			return
+		}
+
+		claims := webdavBasicClaims(cfg, username, password)
+		if claims == nil {
+			if config.IsLogLevel(config.LogLevelInfo) {
+				log.Printf("WebDAV: Basic authentication failed for user '%s' from %s", username, clientIP(r))
+			}
+			webdavChallenge(w)
+			return
+		}
+		if !auth.IsUserAuthorized(claims, cfg) {
+			log.Printf("WebDAV user not authorized at application level: %s", claims.Email)
+			http.Error(w, "Access denied: User not authorized to use the application", http.StatusForbidden)
+			return
+		}
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] WebDAVAuthMiddleware: User '%s' authenticated with HTTP Basic.", claims.Email)
+		}
+
+		setAccessLogUser(r.Context(), claims.Email)
+		ctx := context.WithValue(r.Context(), auth.ClaimsKey{}, claims)
+		limited.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// webdavBasicClaims returns the claims of the webdav.users account matching the credentials, or nil.
+func webdavBasicClaims(cfg *config.Config, username string, password string) *auth.UserClaims {
+	for _, user := range cfg.WebDAV.Users {
+		if subtle.ConstantTimeCompare([]byte(user.Username), []byte(username)) != 1 {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			return nil
+		}
+		email := user.Email
+		if email == "" {
+			email = user.Username
+		}
+		return &auth.UserClaims{
+			Subject:    "webdav:" + user.Username,
+			Name:       user.Username,
+			Email:      email,
+			GroupNames: user.Groups,
+		}
+	}
+	bcrypt.CompareHashAndPassword([]byte(webdavDummyHash), []byte(password))
+	return nil
+}
+
+func webdavChallenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="CloudDAV", charset="UTF-8"`)
+	http.Error(w, "Authentication required", http.StatusUnauthorized)
+}
+
+// handleWebDAV serves /webdav/<storage>/<path>. L'accesso allo storage (e a quello della Destination di
+// MOVE e COPY) viene verificato qui per rispondere 403/404; il FileSystem lo verifica di nuovo per ogni
+// operazione, perché COPY e MOVE di directory visitano anche altri path.
+func handleWebDAV(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	if !cfg.WebDAV.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	claims, _ := getClaimsFromContext(r.Context())
+
+	// Un PROPFIND a profondità infinita visiterebbe l'intero storage (RFC 4918, 9.1: propfind-finite-depth);
+	// senza header Depth il PROPFIND vale come infinity e viene rifiutato allo stesso modo.
+	if depth := strings.TrimSpace(r.Header.Get("Depth")); r.Method == "PROPFIND" && (depth == "" || strings.EqualFold(depth, "infinity")) {
+		http.Error(w, "PROPFIND with Depth: infinity is not supported", http.StatusForbidden)
+		return
+	}
+
+	requiredAccess := "write"
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "COPY":
+		requiredAccess = "read" // COPY scrive solo sulla destinazione
+	}
+	if !checkWebDAVAccess(w, r, claims, cfg, strings.TrimPrefix(r.URL.Path, webdavPrefix), requiredAccess) {
+		return
+	}
+	if r.Method == "MOVE" || r.Method == "COPY" {
+		if destination, err := url.Parse(r.Header.Get("Destination")); err == nil && strings.HasPrefix(destination.Path, webdavPrefix+"/") {
+			if !checkWebDAVAccess(w, r, claims, cfg, strings.TrimPrefix(destination.Path, webdavPrefix), "write") {
+				return
+			}
+		}
+	}
+
+	// Un PUT è un nuovo upload: durante lo shutdown viene rifiutato come l'initiate di /upload.
+	if r.Method == http.MethodPut && rejectUploadWhileDraining(w) {
+		return
+	}
+
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("[DEBUG] handleWebDAV: %s %s", r.Method, r.URL.Path)
+	}
+	webdavHandler.ServeHTTP(w, r)
+}
+
+// checkWebDAVAccess checks requiredAccess on the storage of the WebDAV path name and writes the error
+// response if it is not granted. La radice (elenco degli storage) è filtrata dal FileSystem.
+func checkWebDAVAccess(w http.ResponseWriter, r *http.Request, claims *auth.UserClaims, cfg *config.Config, name string, requiredAccess string) bool {
+	storageName, itemPath := splitWebDAVPath(name)
+	if storageName == "" {
+		return true
+	}
+	if cfg.GetStorageConfig(storageName) == nil {
+		http.Error(w, "Storage not found", http.StatusNotFound)
+		return false
+	}
+	if err := authz.CheckStorageAccess(r.Context(), claims, storageName, itemPath, requiredAccess, cfg); err != nil {
+		wsHub.RecordError(claims, "webdav_"+strings.ToLower(r.Method), storageName, itemPath, err)
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			http.Error(w, "Access denied: "+requiredAccess+" permission required", http.StatusForbidden)
+		} else {
+			log.Printf("Error checking storage access for WebDAV %s '%s/%s': %v", r.Method, storageName, itemPath, err)
+			http.Error(w, "Internal server error during access check", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// splitWebDAVPath splits a WebDAV path ("/storage/dir/file") into the storage name and the path inside
+// the storage ("/dir/file"). Per la radice restituisce due stringhe vuote.
+func splitWebDAVPath(name string) (storageName string, itemPath string) {
+	trimmed := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if trimmed == "" {
+		return "", ""
+	}
+	storageName, rest, _ := strings.Cut(trimmed, "/")
+	return storageName, "/" + rest
+}
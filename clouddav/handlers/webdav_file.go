@@ -0,0 +1,712 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/internal/metrics"
+	"clouddav/storage"
+	"clouddav/storage/azureblob"
+	"clouddav/storage/command"
+	"clouddav/storage/gcs"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+	websocket "clouddav/websocket"
+)
+
+const (
+	// webdavListPageSize is the page size of the listings of a directory read by a WebDAV client.
+	webdavListPageSize = 1000
+	// webdavChunkSize is the chunk size used to store a file written by a WebDAV client through the upload
+	// methods of the providers (multiplo di 256 KiB, come richiesto dagli upload resumable di GCS).
+	webdavChunkSize = 8 << 20
+)
+
+// errUploadInProgress is returned when a WebDAV client writes a file that is being uploaded by someone else.
+var errUploadInProgress = errors.New("file is already being uploaded")
+
+// storageFileSystem implements webdav.FileSystem on the registered storage providers. I path hanno la
+// forma "/<storage>/<path>"; la radice elenca gli storage leggibili dall'utente. Ogni operazione verifica
+// l'accesso con authz.CheckStorageAccess usando i claims della richiesta.
+type storageFileSystem struct{}
+
+// resolve returns the provider and the path inside the storage of the WebDAV path name, after checking
+// requiredAccess. Per la radice provider è nil.
+func (storageFileSystem) resolve(ctx context.Context, op string, name string, requiredAccess string) (claims *auth.UserClaims, provider storage.StorageProvider, itemPath string, err error) {
+	claims, _ = getClaimsFromContext(ctx)
+	storageName, itemPath := splitWebDAVPath(name)
+	if storageName == "" {
+		return claims, nil, "", nil
+	}
+	provider, ok := storage.GetProvider(storageName)
+	if !ok || currentConfig().GetStorageConfig(storageName) == nil {
+		return claims, nil, "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if err := authz.CheckStorageAccess(ctx, claims, storageName, itemPath, requiredAccess, currentConfig()); err != nil {
+		return claims, nil, "", webdavError(claims, op, storageName, itemPath, name, err)
+	}
+	return claims, provider, itemPath, nil
+}
+
+func (fs storageFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	claims, provider, itemPath, err := fs.resolve(ctx, "mkdir", name, "write")
+	if err != nil {
+		return err
+	}
+	if provider == nil || itemPath == "/" {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if _, err := provider.GetItem(ctx, claims, itemPath); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	// MKCOL non crea le directory intermedie: senza la directory padre la risposta deve essere 409.
+	if err := checkWebDAVParent(ctx, claims, provider, itemPath); err != nil {
+		return webdavError(claims, "mkdir", provider.Name(), itemPath, name, err)
+	}
+	if err := provider.CreateDirectory(ctx, claims, itemPath); err != nil {
+		return webdavError(claims, "mkdir", provider.Name(), itemPath, name, err)
+	}
+	return nil
+}
+
+func (fs storageFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return fs.create(ctx, name)
+	}
+
+	claims, provider, itemPath, err := fs.resolve(ctx, "open", name, "read")
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		return &webdavDir{info: webdavDirInfo("/", time.Time{}), list: func() ([]os.FileInfo, error) {
+			return webdavStorageInfos(ctx, claims), nil
+		}}, nil
+	}
+	info, err := fs.stat(ctx, claims, provider, itemPath)
+	if err != nil {
+		return nil, webdavError(claims, "open", provider.Name(), itemPath, name, err)
+	}
+	if info.IsDir() {
+		return &webdavDir{info: info, list: func() ([]os.FileInfo, error) {
+			infos, err := webdavListDirectory(ctx, claims, provider, itemPath)
+			if err != nil {
+				return nil, webdavError(claims, "readdir", provider.Name(), itemPath, name, err)
+			}
+			return infos, nil
+		}}, nil
+	}
+	return &webdavReadFile{ctx: ctx, claims: claims, provider: provider, itemPath: itemPath, info: info}, nil
+}
+
+// create opens name for writing (PUT). Il contenuto viene raccolto in un file temporaneo e salvato sullo
+// storage alla Close, con gli stessi metodi di upload usati da /upload.
+func (fs storageFileSystem) create(ctx context.Context, name string) (webdav.File, error) {
+	claims, provider, itemPath, err := fs.resolve(ctx, "create", name, "write")
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil || itemPath == "/" {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrPermission}
+	}
+	if itemInfo, err := provider.GetItem(ctx, claims, itemPath); err == nil && itemInfo.IsDir {
+		return nil, webdavError(claims, "create", provider.Name(), itemPath, name, storage.ErrIsDirectory)
+	}
+	if err := checkWebDAVParent(ctx, claims, provider, itemPath); err != nil {
+		return nil, webdavError(claims, "create", provider.Name(), itemPath, name, err)
+	}
+	temp, err := os.CreateTemp("", "webdav-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file for WebDAV upload: %w", err)
+	}
+	return &webdavWriteFile{ctx: ctx, claims: claims, provider: provider, itemPath: itemPath, temp: temp, hasher: sha256.New()}, nil
+}
+
+func (fs storageFileSystem) RemoveAll(ctx context.Context, name string) error {
+	claims, provider, itemPath, err := fs.resolve(ctx, "remove", name, "write")
+	if err != nil {
+		return err
+	}
+	if provider == nil || itemPath == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	if err := authz.CheckRecursiveWriteAccess(ctx, claims, provider.Name(), itemPath, currentConfig()); err != nil {
+		return webdavError(claims, "remove", provider.Name(), itemPath, name, err)
+	}
+	if err := provider.DeleteItem(ctx, claims, itemPath); err != nil {
+		return webdavError(claims, "remove", provider.Name(), itemPath, name, err)
+	}
+	return nil
+}
+
+func (fs storageFileSystem) Rename(ctx context.Context, oldName string, newName string) error {
+	claims, provider, oldPath, err := fs.resolve(ctx, "rename", oldName, "write")
+	if err != nil {
+		return err
+	}
+	_, newProvider, newPath, err := fs.resolve(ctx, "rename", newName, "write")
+	if err != nil {
+		return err
+	}
+	if provider == nil || newProvider == nil || oldPath == "/" || newPath == "/" {
+		return &os.PathError{Op: "rename", Path: oldName, Err: os.ErrPermission}
+	}
+	if provider.Name() != newProvider.Name() {
+		return fmt.Errorf("cannot move '%s' to '%s': moves between storages are not supported", oldName, newName)
+	}
+	for _, itemPath := range []string{oldPath, newPath} {
+		if err := authz.CheckRecursiveWriteAccess(ctx, claims, provider.Name(), itemPath, currentConfig()); err != nil {
+			return webdavError(claims, "rename", provider.Name(), itemPath, oldName, err)
+		}
+	}
+	if err := provider.MoveItem(ctx, claims, oldPath, newPath); err != nil {
+		return webdavError(claims, "rename", provider.Name(), oldPath, oldName, err)
+	}
+	return nil
+}
+
+func (fs storageFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	claims, provider, itemPath, err := fs.resolve(ctx, "stat", name, "read")
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		return webdavDirInfo("/", time.Time{}), nil
+	}
+	info, err := fs.stat(ctx, claims, provider, itemPath)
+	if err != nil {
+		return nil, webdavError(claims, "stat", provider.Name(), itemPath, name, err)
+	}
+	return info, nil
+}
+
+// stat returns the FileInfo of itemPath; la radice di uno storage è sempre una directory.
+func (storageFileSystem) stat(ctx context.Context, claims *auth.UserClaims, provider storage.StorageProvider, itemPath string) (os.FileInfo, error) {
+	if itemPath == "/" {
+		return webdavDirInfo(provider.Name(), time.Time{}), nil
+	}
+	itemInfo, err := provider.GetItem(ctx, claims, itemPath)
+	if err != nil {
+		return nil, err
+	}
+	return webdavItemInfo(itemInfo), nil
+}
+
+// checkWebDAVParent returns storage.ErrNotFound if the parent directory of itemPath does not exist.
+func checkWebDAVParent(ctx context.Context, claims *auth.UserClaims, provider storage.StorageProvider, itemPath string) error {
+	parent := path.Dir(itemPath)
+	if parent == "/" {
+		return nil
+	}
+	parentInfo, err := provider.GetItem(ctx, claims, parent)
+	if err != nil {
+		return err
+	}
+	if !parentInfo.IsDir {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// webdavError converts a storage error into the os errors recognized by the webdav package (404, 409,
+// 405...) and records it among the recent errors of the user. I path non trovati non vengono registrati:
+// i client WebDAV ne cercano molti (desktop.ini, ._*, .DS_Store).
+func webdavError(claims *auth.UserClaims, op string, storageName string, itemPath string, name string, err error) error {
+	var osErr error
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case errors.Is(err, storage.ErrPermissionDenied), errors.Is(err, storage.ErrIsSymlink):
+		osErr = os.ErrPermission
+	case errors.Is(err, storage.ErrAlreadyExists):
+		osErr = os.ErrExist
+	}
+	wsHub.RecordError(claims, "webdav_"+op, storageName, itemPath, err)
+	if osErr == nil {
+		log.Printf("WebDAV %s of '%s/%s' failed: %v", op, storageName, itemPath, err)
+		return err
+	}
+	return &os.PathError{Op: op, Path: name, Err: osErr}
+}
+
+// webdavStorageInfos lists the storages the user can read, in the order of the configuration.
+func webdavStorageInfos(ctx context.Context, claims *auth.UserClaims) []os.FileInfo {
+	cfg := currentConfig()
+	infos := []os.FileInfo{}
+	for _, storageCfg := range cfg.Storages {
+		if _, ok := storage.GetProvider(storageCfg.Name); !ok {
+			continue
+		}
+		if authz.CheckStorageAccess(ctx, claims, storageCfg.Name, "/", "read", cfg) != nil {
+			continue
+		}
+		infos = append(infos, webdavDirInfo(storageCfg.Name, time.Time{}))
+	}
+	return infos
+}
+
+// webdavListDirectory returns all the items of dirPath, reading the listing one page at a time.
+func webdavListDirectory(ctx context.Context, claims *auth.UserClaims, provider storage.StorageProvider, dirPath string) ([]os.FileInfo, error) {
+	infos := []os.FileInfo{}
+	for page := 1; ; page++ {
+		listResponse, err := provider.ListItems(ctx, claims, dirPath, page, webdavListPageSize, "", nil, false, false)
+		if err != nil {
+			return nil, err
+		}
+		for i := range listResponse.Items {
+			infos = append(infos, webdavItemInfo(&listResponse.Items[i]))
+		}
+		if len(listResponse.Items) < webdavListPageSize {
+			return infos, nil
+		}
+	}
+}
+
+// webdavFileInfo is the os.FileInfo of a storage item. Implementa webdav.ContentTyper, così PROPFIND
+// ricava il tipo dall'estensione senza aprire i file.
+type webdavFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func webdavItemInfo(itemInfo *storage.ItemInfo) *webdavFileInfo {
+	return &webdavFileInfo{name: itemInfo.Name, size: itemInfo.Size, modTime: itemInfo.ModTime, isDir: itemInfo.IsDir}
+}
+
+func webdavDirInfo(name string, modTime time.Time) *webdavFileInfo {
+	return &webdavFileInfo{name: name, modTime: modTime, isDir: true}
+}
+
+func (fi *webdavFileInfo) Name() string       { return fi.name }
+func (fi *webdavFileInfo) Size() int64        { return fi.size }
+func (fi *webdavFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *webdavFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *webdavFileInfo) Sys() interface{}   { return nil }
+
+func (fi *webdavFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi *webdavFileInfo) ContentType(ctx context.Context) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(fi.name)); contentType != "" {
+		return contentType, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// webdavDir is an open directory; il contenuto viene letto alla prima Readdir.
+type webdavDir struct {
+	info    os.FileInfo
+	list    func() ([]os.FileInfo, error)
+	entries []os.FileInfo
+	loaded  bool
+	pos     int
+}
+
+func (d *webdavDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		entries, err := d.list()
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	remaining := d.entries[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.pos += count
+	return remaining[:count], nil
+}
+
+func (d *webdavDir) Stat() (os.FileInfo, error) { return d.info, nil }
+func (d *webdavDir) Close() error               { return nil }
+
+func (d *webdavDir) Read(p []byte) (int, error) {
+	return 0, storage.ErrIsDirectory
+}
+
+func (d *webdavDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, storage.ErrIsDirectory
+}
+
+func (d *webdavDir) Write(p []byte) (int, error) {
+	return 0, storage.ErrIsDirectory
+}
+
+// webdavReadFile is a file opened for reading. Lo storage viene aperto alla prima Read (PROPFIND e HEAD non
+// leggono il contenuto); con OpenReaderAt le letture sono a blocchi come nei download con Range, altrimenti
+// il file viene letto in sequenza (streamReadSeeker).
+type webdavReadFile struct {
+	ctx      context.Context
+	claims   *auth.UserClaims
+	provider storage.StorageProvider
+	itemPath string
+	info     os.FileInfo
+	offset   int64
+	content  io.ReadSeeker
+	closer   io.Closer
+}
+
+func (f *webdavReadFile) open() error {
+	readerAt, err := f.provider.OpenReaderAt(f.ctx, f.claims, f.itemPath)
+	if err == nil {
+		f.closer = readerAt
+		f.content = io.NewSectionReader(readerAt, 0, readerAt.Size())
+		if blockSize := f.provider.Capabilities().BlockSize; blockSize > 0 {
+			f.content = &blockReadSeeker{readerAt: readerAt, size: readerAt.Size(), blockSize: blockSize}
+		}
+		return nil
+	}
+	if !errors.Is(err, storage.ErrNotImplemented) {
+		return err
+	}
+	stream := &streamReadSeeker{size: f.info.Size(), open: func() (io.ReadCloser, error) {
+		return f.provider.OpenReader(f.ctx, f.claims, f.itemPath)
+	}}
+	f.content, f.closer = stream, stream
+	return nil
+}
+
+func (f *webdavReadFile) Read(p []byte) (int, error) {
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.content == nil {
+		if err := f.open(); err != nil {
+			return 0, webdavError(f.claims, "read", f.provider.Name(), f.itemPath, f.itemPath, err)
+		}
+	}
+	if _, err := f.content.Seek(f.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := f.content.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *webdavReadFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, errors.New("webdavReadFile.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("webdavReadFile.Seek: negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *webdavReadFile) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+func (f *webdavReadFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (f *webdavReadFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *webdavReadFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// streamReadSeeker adapts a provider without random access to an io.ReadSeeker: una posizione più avanti
+// viene raggiunta scartando i byte, una posizione precedente riaprendo il file.
+type streamReadSeeker struct {
+	open      func() (io.ReadCloser, error)
+	size      int64
+	reader    io.ReadCloser
+	readerPos int64
+	offset    int64
+}
+
+func (s *streamReadSeeker) Read(p []byte) (int, error) {
+	if s.reader == nil || s.offset < s.readerPos {
+		if s.reader != nil {
+			s.reader.Close()
+		}
+		reader, err := s.open()
+		if err != nil {
+			s.reader = nil
+			return 0, err
+		}
+		s.reader, s.readerPos = reader, 0
+	}
+	if s.offset > s.readerPos {
+		skipped, err := io.CopyN(io.Discard, s.reader, s.offset-s.readerPos)
+		s.readerPos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.reader.Read(p)
+	s.readerPos += int64(n)
+	s.offset += int64(n)
+	return n, err
+}
+
+func (s *streamReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("streamReadSeeker.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("streamReadSeeker.Seek: negative position")
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+func (s *streamReadSeeker) Close() error {
+	if s.reader == nil {
+		return nil
+	}
+	return s.reader.Close()
+}
+
+// webdavWriteFile is a file opened for writing (PUT): il contenuto va in un file temporaneo, con lo SHA256
+// calcolato durante la scrittura, e viene salvato sullo storage alla Close.
+type webdavWriteFile struct {
+	ctx      context.Context
+	claims   *auth.UserClaims
+	provider storage.StorageProvider
+	itemPath string
+	temp     *os.File
+	hasher   hash.Hash
+	size     int64
+	closed   bool
+}
+
+func (f *webdavWriteFile) Write(p []byte) (int, error) {
+	n, err := f.temp.Write(p)
+	f.hasher.Write(p[:n])
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *webdavWriteFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	defer os.Remove(f.temp.Name())
+	defer f.temp.Close()
+
+	sha := hex.EncodeToString(f.hasher.Sum(nil))
+	if err := storeWebDAVUpload(f.ctx, f.claims, f.provider, f.itemPath, f.temp, f.size, sha); err != nil {
+		return webdavError(f.claims, "write", f.provider.Name(), f.itemPath, f.itemPath, err)
+	}
+	return nil
+}
+
+func (f *webdavWriteFile) Stat() (os.FileInfo, error) {
+	return &webdavFileInfo{name: path.Base(f.itemPath), size: f.size, modTime: time.Now()}, nil
+}
+
+func (f *webdavWriteFile) Read(p []byte) (int, error) {
+	return 0, errors.New("file opened for writing")
+}
+
+func (f *webdavWriteFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("file opened for writing")
+}
+
+func (f *webdavWriteFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+// sectionReadSeekCloser adds a no-op Close to a chunk of the temporary file, for the Azure WriteChunk.
+type sectionReadSeekCloser struct {
+	*io.SectionReader
+}
+
+func (sectionReadSeekCloser) Close() error { return nil }
+
+// storeWebDAVUpload stores the content of a WebDAV PUT on the storage with the upload methods of the
+// provider (initiate, chunk, finalize), come un upload da /upload: la sessione viene registrata nel Hub per
+// rilevare i conflitti con gli upload in corso, e valgono quota e verifica dello SHA256. Un PUT sostituisce
+// il file esistente, quindi il finalize pubblica sempre con overwrite.
+func storeWebDAVUpload(ctx context.Context, claims *auth.UserClaims, provider storage.StorageProvider, itemPath string, content io.ReaderAt, size int64, sha string) error {
+	storageName := provider.Name()
+	uploadID, err := storage.NewUploadID()
+	if err != nil {
+		return err
+	}
+	// Come una richiesta di /upload, il PUT occupa uno slot di max_concurrent_operations per tutte le fasi.
+	releaseSlot, err := storage.AcquireSlot(ctx, provider)
+	if err != nil {
+		return err
+	}
+	defer releaseSlot()
+	provider = storage.Unwrap(provider)
+
+	wsHub.FileUploadsMutex.Lock()
+	if _, sessionState, exists := wsHub.UploadForPath(storageName, itemPath, ""); exists {
+		wsHub.FileUploadsMutex.Unlock()
+		return fmt.Errorf("%w: '%s' by %s", errUploadInProgress, itemPath, sessionState.Claims.Email)
+	}
+	sessionState := &websocket.UploadSessionState{
+		Claims:       claims,
+		UploadID:     uploadID,
+		StorageName:  storageName,
+		ItemPath:     itemPath,
+		Overwrite:    true,
+		LastActivity: time.Now(),
+		ProviderType: provider.Type(),
+		TotalSize:    size,
+		StartedAt:    time.Now(),
+	}
+	wsHub.OngoingFileUploads[uploadID] = sessionState
+	wsHub.UpdateUploadsGauge()
+	wsHub.FileUploadsMutex.Unlock()
+	defer func() {
+		wsHub.FileUploadsMutex.Lock()
+		delete(wsHub.OngoingFileUploads, uploadID)
+		wsHub.UpdateUploadsGauge()
+		wsHub.FileUploadsMutex.Unlock()
+	}()
+
+	if err := checkStorageQuota(ctx, claims, provider, size); err != nil {
+		return err
+	}
+
+	// Ogni fase ha il timeout della corrispondente azione di /upload (timeouts.upload_*_timeout).
+	phaseCtx, cancelPhase := uploadPhaseContext(ctx, "initiate")
+	switch p := provider.(type) {
+	case *local.LocalFilesystemProvider:
+		_, err = p.InitiateUpload(phaseCtx, claims, uploadID, itemPath, size, webdavChunkSize)
+	case *azureblob.AzureBlobStorageProvider:
+		_, err = p.InitiateUpload(phaseCtx, claims, uploadID, itemPath, size, webdavChunkSize)
+	case *command.CommandStorageProvider:
+		_, err = p.InitiateUpload(phaseCtx, claims, uploadID, itemPath, size, webdavChunkSize)
+	case *memory.MemoryStorageProvider:
+		_, err = p.InitiateUpload(phaseCtx, claims, uploadID, itemPath, size, webdavChunkSize)
+	case *gcs.GCSStorageProvider:
+		_, err = p.InitiateUpload(phaseCtx, claims, uploadID, itemPath, size, webdavChunkSize, true)
+	default:
+		err = storage.ErrNotImplemented
+	}
+	cancelPhase()
+	if err != nil {
+		return err
+	}
+
+	var blockIDs []string
+	for chunkIndex := int64(0); chunkIndex*webdavChunkSize < size && err == nil; chunkIndex++ {
+		offset := chunkIndex * webdavChunkSize
+		chunk := io.NewSectionReader(content, offset, min(webdavChunkSize, size-offset))
+		phaseCtx, cancelPhase = uploadPhaseContext(ctx, "chunk")
+		switch p := provider.(type) {
+		case *local.LocalFilesystemProvider:
+			err = p.WriteChunk(phaseCtx, claims, uploadID, chunk, chunkIndex, webdavChunkSize, storage.ChunkChecksum{})
+		case *azureblob.AzureBlobStorageProvider:
+			// Stesso formato dei blockID generati dal client web, così l'ordinamento in FinalizeUpload è corretto.
+			blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%020d", chunkIndex)))
+			blockIDs = append(blockIDs, blockID)
+			err = p.WriteChunk(phaseCtx, claims, uploadID, blockID, sectionReadSeekCloser{chunk}, chunkIndex, storage.ChunkChecksum{})
+		case *command.CommandStorageProvider:
+			var chunkData []byte
+			if chunkData, err = io.ReadAll(chunk); err == nil {
+				err = p.WriteChunk(phaseCtx, claims, uploadID, chunkData, chunkIndex, webdavChunkSize, storage.ChunkChecksum{})
+			}
+		case *memory.MemoryStorageProvider:
+			var chunkData []byte
+			if chunkData, err = io.ReadAll(chunk); err == nil {
+				err = p.WriteChunk(phaseCtx, claims, uploadID, chunkData, chunkIndex, webdavChunkSize, storage.ChunkChecksum{})
+			}
+		case *gcs.GCSStorageProvider:
+			err = p.WriteChunk(phaseCtx, claims, uploadID, chunk, chunkIndex, storage.ChunkChecksum{})
+		}
+		cancelPhase()
+		if err == nil {
+			metrics.UploadBytesWritten.Add(float64(chunk.Size()), storageName)
+			wsHub.FileUploadsMutex.Lock()
+			sessionState.LastActivity = time.Now()
+			sessionState.RecordChunk(sessionState.LastActivity, chunk.Size())
+			wsHub.FileUploadsMutex.Unlock()
+		}
+	}
+
+	if err == nil {
+		phaseCtx, cancelPhase = uploadPhaseContext(ctx, "finalize")
+		switch p := provider.(type) {
+		case *local.LocalFilesystemProvider:
+			err = p.FinalizeUpload(claims, uploadID, itemPath, sha, true)
+		case *azureblob.AzureBlobStorageProvider:
+			err = p.FinalizeUpload(phaseCtx, claims, uploadID, itemPath, blockIDs, sha, size, true, false, "")
+		case *command.CommandStorageProvider:
+			err = p.FinalizeUpload(phaseCtx, claims, uploadID, itemPath, sha, true)
+		case *memory.MemoryStorageProvider:
+			err = p.FinalizeUpload(phaseCtx, claims, uploadID, itemPath, sha, true)
+		case *gcs.GCSStorageProvider:
+			err = p.FinalizeUpload(phaseCtx, claims, uploadID, itemPath, sha, size, true)
+		}
+		cancelPhase()
+	}
+	if err != nil {
+		var cancelErr error
+		switch p := provider.(type) {
+		case *local.LocalFilesystemProvider:
+			cancelErr = p.CancelUpload(claims, uploadID)
+		case *azureblob.AzureBlobStorageProvider:
+			cancelErr = p.CancelUpload(context.Background(), claims, uploadID)
+		case *command.CommandStorageProvider:
+			cancelErr = p.CancelUpload(claims, uploadID)
+		case *memory.MemoryStorageProvider:
+			cancelErr = p.CancelUpload(claims, uploadID)
+		case *gcs.GCSStorageProvider:
+			cancelErr = p.CancelUpload(claims, uploadID)
+		}
+		if cancelErr != nil && config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] storeWebDAVUpload: Cancel of failed upload '%s/%s' returned: %v", storageName, itemPath, cancelErr)
+		}
+		return err
+	}
+	storage.RecordChange(storageName, storage.ChangeModified, itemPath) // PUT sovrascrive sempre
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("WebDAV upload of '%s/%s' completed (%d bytes)", storageName, itemPath, size)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/memory"
+	"clouddav/websocket"
+)
+
+// newWebDAVTestHub serves the memory storage "mem" on the WebDAV endpoint, with cfg as the starting
+// configuration (WebDAV e lo storage vengono aggiunti qui).
+func newWebDAVTestHub(t *testing.T, cfg *config.Config) {
+	t.Helper()
+	memoryCfg := config.StorageConfig{Name: "mem", Type: "memory"}
+	cfg.Storages = []config.StorageConfig{memoryCfg}
+	cfg.WebDAV.Enabled = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	provider, err := memory.NewProvider(ctx, &memoryCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	previousHub := wsHub
+	wsHub = websocket.NewHub(ctx, cfg)
+	t.Cleanup(func() { wsHub = previousHub })
+}
+
+func TestWebDAVPropfindDepth(t *testing.T) {
+	newWebDAVTestHub(t, &config.Config{})
+
+	tests := []struct {
+		depth    string // "-" lascia la richiesta senza header Depth
+		wantCode int
+	}{
+		{"-", http.StatusForbidden},
+		{"", http.StatusForbidden},
+		{"infinity", http.StatusForbidden},
+		{" Infinity ", http.StatusForbidden},
+		{"0", http.StatusMultiStatus},
+		{"1", http.StatusMultiStatus},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PROPFIND", webdavPrefix+"/mem/", nil)
+		if tt.depth != "-" {
+			r.Header.Set("Depth", tt.depth)
+		}
+		w := httptest.NewRecorder()
+		handleWebDAV(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("PROPFIND with Depth %q = %d %q, want %d", tt.depth, w.Code, w.Body.String(), tt.wantCode)
+		}
+	}
+}
+
+func TestWebDAVBasicAuthRateLimit(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{EnableAuth: true, RateLimitPerMinute: 1, RateLimitBurst: 1}
+	cfg.WebDAV.Enabled = true
+	cfg.WebDAV.Users = []config.WebDAVUser{
+		{Username: "alice", PasswordHash: string(hash), Email: "webdav-alice@example.com"},
+		{Username: "bob", PasswordHash: string(hash), Email: "webdav-bob@example.com"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	previousHub := wsHub
+	wsHub = websocket.NewHub(ctx, cfg)
+	t.Cleanup(func() { wsHub = previousHub })
+	handler := WebDAVAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		username   string
+		remoteAddr string
+		want       int
+	}{
+		{"first request", "alice", "192.0.2.20:1000", http.StatusOK},
+		{"same user from another address", "alice", "192.0.2.21:1000", http.StatusTooManyRequests},
+		{"another user", "bob", "192.0.2.20:1001", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PROPFIND", webdavPrefix+"/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		r.SetBasicAuth(tt.username, "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After header", tt.name)
+		}
+	}
+}
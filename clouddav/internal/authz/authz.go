@@ -4,15 +4,17 @@ import (
 	"context"
 	"errors"
 	"log"
+	"path"
+	"strings"
 
-	// Import strings for ToLower or other string operations if needed
 	"clouddav/auth"    // Importa il package auth per UserClaims
 	"clouddav/config"  // Importa il package config per Config e StorageConfig
 	"clouddav/storage" // Importa il package storage per StorageProvider e errori comuni
 )
 
 // CheckStorageAccess verifies if the user has the required permissions on a specific storage and path.
-// This check is now performed by matching against group names.
+// This check is now performed by matching against group names; per itemPath vale la regola con il
+// path_prefix più specifico tra quelle dei gruppi dell'utente (vedi grantedStorageAccess).
 // This check is only performed if enable_auth is true.
 func CheckStorageAccess(ctx context.Context, claims *auth.UserClaims, storageName string, itemPath string, requiredAccess string, cfg *config.Config) error {
 	if !cfg.EnableAuth {
@@ -36,29 +38,18 @@ func CheckStorageAccess(ctx context.Context, claims *auth.UserClaims, storageNam
 
 	// Step 1: Check if the user is a global administrator
 	// Crea una mappa per una ricerca efficiente dei nomi dei gruppi dell'utente
-	userGroupNamesMap := make(map[string]bool)
-	for _, groupName := range claims.GroupNames {
-		userGroupNamesMap[groupName] = true
-	}
+	userGroupNamesMap := userGroupSet(claims)
 
-	for _, adminGroup := range cfg.GlobalAdminGroups {
-		if userGroupNamesMap[adminGroup] {
-			if config.IsLogLevel(config.LogLevelDebug) {
-				log.Printf("[DEBUG] authz.CheckStorageAccess: User '%s' is a member of global admin group '%s'. Granting full access.", claims.Email, adminGroup)
-			}
-			return nil // Global admin has full access
+	if adminGroup := matchGlobalAdminGroup(userGroupNamesMap, cfg); adminGroup != "" {
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("[DEBUG] authz.CheckStorageAccess: User '%s' is a member of global admin group '%s'. Granting full access.", claims.Email, adminGroup)
 		}
+		return nil // Global admin has full access
 	}
 
 	// Step 2: If not a global admin, proceed with granular storage permissions
 	// Se non è un amministratore globale, procedi con i permessi granulari dello storage
-	var storageCfg *config.StorageConfig
-	for i := range cfg.Storages {
-		if cfg.Storages[i].Name == storageName {
-			storageCfg = &cfg.Storages[i]
-			break
-		}
-	}
+	storageCfg := cfg.GetStorageConfig(storageName)
 
 	if storageCfg == nil {
 		if config.IsLogLevel(config.LogLevelInfo) {
@@ -71,21 +62,11 @@ func CheckStorageAccess(ctx context.Context, claims *auth.UserClaims, storageNam
 	}
 
 
-	hasRead := false
-	hasWrite := false
-
 	// Check permissions defined in the storage configuration by matching group names
-	for _, perm := range storageCfg.Permissions {
-		if userGroupNamesMap[perm.GroupID] { // Confronta con il nome del gruppo
-			if config.IsLogLevel(config.LogLevelDebug) {
-				log.Printf("[DEBUG] authz.CheckStorageAccess: User '%s' is a member of configured group '%s' with access '%s' for storage '%s'.", claims.Email, perm.GroupID, perm.Access, storageName)
-			}
-			if perm.Access == "read" {
-				hasRead = true
-			} else if perm.Access == "write" {
-				hasRead = true // Write implies read
-				hasWrite = true
-			}
+	hasRead, hasWrite, matched, pathPrefix := grantedStorageAccess(userGroupNamesMap, storageCfg, itemPath)
+	if config.IsLogLevel(config.LogLevelDebug) {
+		for _, perm := range matched {
+			log.Printf("[DEBUG] authz.CheckStorageAccess: User '%s' is a member of configured group '%s' with access '%s' for storage '%s' (path prefix '%s').", claims.Email, perm.GroupID, perm.Access, storageName, pathPrefix)
 		}
 	}
 
@@ -104,11 +85,97 @@ func CheckStorageAccess(ctx context.Context, claims *auth.UserClaims, storageNam
 }
 
 
+// userGroupSet returns the group names of the user as a set.
+func userGroupSet(claims *auth.UserClaims) map[string]bool {
+	groups := make(map[string]bool)
+	if claims == nil {
+		return groups
+	}
+	for _, groupName := range claims.GroupNames {
+		groups[groupName] = true
+	}
+	return groups
+}
+
+// matchGlobalAdminGroup returns the first configured global admin group the user belongs to, or "".
+func matchGlobalAdminGroup(userGroups map[string]bool, cfg *config.Config) string {
+	for _, adminGroup := range cfg.GlobalAdminGroups {
+		if userGroups[adminGroup] {
+			return adminGroup
+		}
+	}
+	return ""
+}
+
+// grantedStorageAccess evaluates the storage permissions matching the user's groups for itemPath.
+// Tra i permessi dei gruppi dell'utente il cui path_prefix contiene itemPath valgono solo quelli con il
+// prefisso più lungo; i permessi senza prefisso valgono per tutto lo storage. Write implies read.
+// Restituisce anche i permessi applicati e il loro prefisso ("" se a livello di storage), usati da ExplainAccess.
+func grantedStorageAccess(userGroups map[string]bool, storageCfg *config.StorageConfig, itemPath string) (hasRead bool, hasWrite bool, matched []config.Permission, pathPrefix string) {
+	cleanItemPath := path.Clean("/" + itemPath)
+	bestLength := -1
+	for _, perm := range storageCfg.Permissions {
+		if !userGroups[perm.GroupID] { // Confronta con il nome del gruppo
+			continue
+		}
+		prefix := permissionPrefix(perm)
+		if !pathHasPrefix(cleanItemPath, prefix) {
+			continue
+		}
+		if len(prefix) > bestLength {
+			bestLength = len(prefix)
+			hasRead, hasWrite, matched = false, false, nil
+			pathPrefix = strings.TrimSuffix(prefix, "/")
+		} else if len(prefix) < bestLength {
+			continue
+		}
+		matched = append(matched, perm)
+		if perm.Access == "read" {
+			hasRead = true
+		} else if perm.Access == "write" {
+			hasRead = true
+			hasWrite = true
+		}
+	}
+	return hasRead, hasWrite, matched, pathPrefix
+}
+
+// permissionPrefix returns the normalized path_prefix of a permission ("/" for storage-level rules).
+func permissionPrefix(perm config.Permission) string {
+	return path.Clean("/" + perm.PathPrefix)
+}
+
+// pathHasPrefix reports whether itemPath is prefix or is under it, on path segment boundaries
+// ("/projects" contiene "/projects/a" ma non "/projects-old").
+func pathHasPrefix(itemPath string, prefix string) bool {
+	return prefix == "/" || itemPath == prefix || strings.HasPrefix(itemPath, prefix+"/")
+}
+
+// IsGlobalAdmin reports whether the user belongs to one of the configured global admin groups.
+// If enable_auth is false every caller is treated as an administrator, consistently with CheckStorageAccess.
+func IsGlobalAdmin(claims *auth.UserClaims, cfg *config.Config) bool {
+	if !cfg.EnableAuth {
+		return true
+	}
+	if claims == nil {
+		return false
+	}
+	for _, groupName := range claims.GroupNames {
+		for _, adminGroup := range cfg.GlobalAdminGroups {
+			if groupName == adminGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // GetAccessibleStorages returns the list of storage configurations the user has at least read access to.
 // This is used by the frontend to build the initial treeview.
 // If enable_auth is false, all configured storages are returned.
 // If enable_auth is true, all configured storages are returned if the user is a global admin.
-// Otherwise, only storages where the user has read access (on the root path "") are returned.
+// Otherwise, only storages where the user has read access are returned, also if only under a path_prefix
+// (la radice potrebbe comunque non essere leggibile: il client deve aprire direttamente il path).
 func GetAccessibleStorages(ctx context.Context, claims *auth.UserClaims, cfg *config.Config) []config.StorageConfig {
 	if config.IsLogLevel(config.LogLevelInfo) {
 		log.Println("authz.GetAccessibleStorages chiamato.")
@@ -164,8 +231,8 @@ func GetAccessibleStorages(ctx context.Context, claims *auth.UserClaims, cfg *co
 
 	// If not a global admin, filter based on granular storage permissions
 	for _, storageCfg := range allStorages {
-		// Per la lista degli storage accessibili, controlliamo se l'utente ha almeno un permesso di "read"
-		// per la root dello storage (path "").
+		// Per la lista degli storage accessibili, controlliamo se l'utente ha almeno un permesso di "read",
+		// anche limitato a un path_prefix.
 		hasReadAccessToStorage := false
 		for _, perm := range storageCfg.Permissions {
 			if userGroupNamesMap[perm.GroupID] && (perm.Access == "read" || perm.Access == "write") {
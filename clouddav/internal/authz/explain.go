@@ -0,0 +1,108 @@
+package authz
+
+import (
+	"clouddav/auth"
+	"clouddav/config"
+)
+
+// Access decisions reported by ExplainAccess.
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// AccessExplanation describes the outcome of the authorization checks for a user on a storage path,
+// together with the rule that determined it. Usata dal messaggio admin explain_access.
+type AccessExplanation struct {
+	StorageName   string   `json:"storage_name"`
+	ItemPath      string   `json:"item_path"`
+	UserEmail     string   `json:"user_email"`
+	UserGroups    []string `json:"user_groups"`
+	AuthEnabled   bool     `json:"auth_enabled"`
+	StorageExists bool     `json:"storage_exists"`
+	Read          string   `json:"read"`  // DecisionAllow o DecisionDeny
+	Write         string   `json:"write"` // DecisionAllow o DecisionDeny
+	// GlobalAdminGroup is the global admin group that granted full access, if any.
+	GlobalAdminGroup string `json:"global_admin_group,omitempty"`
+	// MatchedPermissions are the storage permissions whose group the user belongs to.
+	MatchedPermissions []config.Permission `json:"matched_permissions"`
+	// MatchedPathRules are the path_prefix of the permissions that applied: vuota se hanno deciso i
+	// permessi a livello di storage, altrimenti il prefisso più specifico che contiene item_path.
+	MatchedPathRules []string `json:"matched_path_rules"`
+	Reason           string   `json:"reason"`
+}
+
+// ExplainAccess evaluates the same rules as CheckStorageAccess for the given user and reports
+// which one decided read and write access. Non effettua logging di accesso negato: è pensata
+// per la diagnostica da parte di un amministratore.
+func ExplainAccess(claims *auth.UserClaims, storageName string, itemPath string, cfg *config.Config) *AccessExplanation {
+	exp := &AccessExplanation{
+		StorageName:        storageName,
+		ItemPath:           itemPath,
+		UserGroups:         []string{},
+		AuthEnabled:        cfg.EnableAuth,
+		Read:               DecisionDeny,
+		Write:              DecisionDeny,
+		MatchedPermissions: []config.Permission{},
+		MatchedPathRules:   []string{},
+	}
+	if claims != nil {
+		exp.UserEmail = claims.Email
+		if claims.GroupNames != nil {
+			exp.UserGroups = claims.GroupNames
+		}
+	}
+
+	storageCfg := cfg.GetStorageConfig(storageName)
+	exp.StorageExists = storageCfg != nil
+
+	if !cfg.EnableAuth {
+		exp.Read, exp.Write = DecisionAllow, DecisionAllow
+		exp.Reason = "authentication is disabled (enable_auth: false): access is implicitly granted"
+		return exp
+	}
+	if claims == nil {
+		exp.Reason = "no user claims: unauthenticated users are denied"
+		return exp
+	}
+
+	userGroups := userGroupSet(claims)
+	if adminGroup := matchGlobalAdminGroup(userGroups, cfg); adminGroup != "" {
+		exp.Read, exp.Write = DecisionAllow, DecisionAllow
+		exp.GlobalAdminGroup = adminGroup
+		exp.Reason = "user is a member of global admin group '" + adminGroup + "': full access"
+		return exp
+	}
+
+	if storageCfg == nil {
+		exp.Reason = "storage '" + storageName + "' is not configured"
+		return exp
+	}
+
+	hasRead, hasWrite, matched, pathPrefix := grantedStorageAccess(userGroups, storageCfg, itemPath)
+	if matched != nil {
+		exp.MatchedPermissions = matched
+	}
+	scope := "storage permissions"
+	if pathPrefix != "" {
+		exp.MatchedPathRules = []string{pathPrefix}
+		scope = "permissions for path prefix '" + pathPrefix + "' (the most specific matching rule)"
+	}
+	if hasRead {
+		exp.Read = DecisionAllow
+	}
+	if hasWrite {
+		exp.Write = DecisionAllow
+	}
+	switch {
+	case hasWrite:
+		exp.Reason = "write access granted by " + scope + " (write implies read)"
+	case hasRead:
+		exp.Reason = "read access granted by " + scope + "; no matching permission grants write"
+	case len(storageCfg.Permissions) == 0:
+		exp.Reason = "storage has no permissions configured and the user is not a global admin"
+	default:
+		exp.Reason = "none of the user's groups matches a global admin group or a storage permission for this path"
+	}
+	return exp
+}
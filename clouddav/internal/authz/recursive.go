@@ -0,0 +1,50 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// CheckRecursiveWriteAccess verifies write access to itemPath and to everything under it, for the operations
+// that modify a whole subtree (eliminazione o spostamento di una directory, sovrascrittura o merge di una
+// destinazione). Oltre a CheckStorageAccess su itemPath, rifiuta l'operazione se una regola con un
+// path_prefix più lungo, sotto itemPath, toglie all'utente il permesso di scrittura: altrimenti basterebbe
+// agire sulla directory padre per aggirarla.
+func CheckRecursiveWriteAccess(ctx context.Context, claims *auth.UserClaims, storageName string, itemPath string, cfg *config.Config) error {
+	if err := CheckStorageAccess(ctx, claims, storageName, itemPath, "write", cfg); err != nil {
+		return err
+	}
+	if !cfg.EnableAuth {
+		return nil
+	}
+	userGroups := userGroupSet(claims)
+	if matchGlobalAdminGroup(userGroups, cfg) != "" {
+		return nil
+	}
+	storageCfg := cfg.GetStorageConfig(storageName)
+	if storageCfg == nil {
+		return nil // CheckStorageAccess ha già rifiutato uno storage inesistente
+	}
+
+	cleanItemPath := path.Clean("/" + itemPath)
+	for _, perm := range storageCfg.Permissions {
+		if !userGroups[perm.GroupID] {
+			continue
+		}
+		prefix := permissionPrefix(perm)
+		if prefix == cleanItemPath || !pathHasPrefix(prefix, cleanItemPath) {
+			continue // Regola non sotto itemPath: già valutata da CheckStorageAccess
+		}
+		if _, hasWrite, _, _ := grantedStorageAccess(userGroups, storageCfg, prefix); !hasWrite {
+			log.Printf("Access denied for user '%s': recursive write on storage '%s', path '%s' would modify '%s', which is read-only for the user.", claims.Email, storageName, cleanItemPath, prefix)
+			return fmt.Errorf("%w: '%s' contains read-only path '%s'", storage.ErrPermissionDenied, cleanItemPath, prefix)
+		}
+	}
+	return nil
+}
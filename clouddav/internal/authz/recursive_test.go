@@ -0,0 +1,65 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+func TestCheckRecursiveWriteAccess(t *testing.T) {
+	cfg := &config.Config{
+		EnableAuth:        true,
+		GlobalAdminGroups: []string{"admins"},
+		Storages: []config.StorageConfig{{
+			Name: "data",
+			Type: "local",
+			Permissions: []config.Permission{
+				{GroupID: "editors", Access: "write"},
+				{GroupID: "editors", Access: "read", PathPrefix: "projects/archive"},
+				{GroupID: "editors", Access: "write", PathPrefix: "projects/archive/inbox"},
+				{GroupID: "others", Access: "read", PathPrefix: "shared"}, // Gruppo di cui l'utente non fa parte
+			},
+		}},
+	}
+	editor := &auth.UserClaims{Email: "editor@example.com", GroupNames: []string{"editors"}}
+	admin := &auth.UserClaims{Email: "admin@example.com", GroupNames: []string{"admins"}}
+
+	tests := []struct {
+		name     string
+		claims   *auth.UserClaims
+		itemPath string
+		allowed  bool
+	}{
+		{"file outside the read-only subtree", editor, "/projects/report.txt", true},
+		{"parent of the read-only subtree", editor, "/projects", false},
+		{"storage root", editor, "/", false},
+		{"read-only subtree itself", editor, "/projects/archive", false},
+		{"inside the read-only subtree", editor, "/projects/archive/2023", false},
+		{"writable rule under the read-only subtree", editor, "/projects/archive/inbox", true},
+		{"sibling with a similar name", editor, "/projects/archive-old", true},
+		{"read-only rule of another group", editor, "/shared", true},
+		{"global admin", admin, "/projects", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckRecursiveWriteAccess(context.Background(), tt.claims, "data", tt.itemPath, cfg)
+			if tt.allowed && err != nil {
+				t.Errorf("CheckRecursiveWriteAccess(%q) = %v, want allowed", tt.itemPath, err)
+			}
+			if !tt.allowed && !errors.Is(err, storage.ErrPermissionDenied) {
+				t.Errorf("CheckRecursiveWriteAccess(%q) = %v, want ErrPermissionDenied", tt.itemPath, err)
+			}
+		})
+	}
+}
+
+func TestCheckRecursiveWriteAccessAuthDisabled(t *testing.T) {
+	cfg := &config.Config{Storages: []config.StorageConfig{{Name: "data", Type: "local"}}}
+	if err := CheckRecursiveWriteAccess(context.Background(), nil, "data", "/", cfg); err != nil {
+		t.Errorf("CheckRecursiveWriteAccess with auth disabled = %v, want nil", err)
+	}
+}
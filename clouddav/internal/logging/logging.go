@@ -0,0 +1,122 @@
+// Package logging configures the application logs: il formato testuale del package log oppure JSON
+// tramite log/slog, con i campi strutturati usati dai call site che li forniscono.
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Formati supportati da log_format.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Chiavi dei campi strutturati, comuni a tutti i call site così i log sono interrogabili per campo.
+const (
+	KeyUser        = "user"
+	KeyRequestID   = "request_id"
+	KeyStorage     = "storage"
+	KeyPath        = "path"
+	KeyMessageType = "message_type"
+	KeyError       = "error"
+)
+
+var (
+	level       = new(slog.LevelVar)
+	jsonEnabled atomic.Bool
+	// jsonHandler scrive ogni record senza filtro di livello: lo usano i logger degli storage con un
+	// livello proprio (vedi StorageLogger). Nil in formato testuale.
+	jsonHandler atomic.Pointer[slog.JSONHandler]
+)
+
+// Setup selects the log format; va chiamata all'avvio, prima che altre goroutine scrivano log.
+// Con FormatText i log restano quelli del package log e slog scrive tramite lo stesso logger
+// ("LIVELLO messaggio chiave=valore"). Con FormatJSON ogni riga è un oggetto JSON scritto su w, anche
+// per i log.Printf esistenti, il cui livello viene dedotto dal prefisso (vedi levelForMessage).
+func Setup(format string, w io.Writer) {
+	if format != FormatJSON {
+		jsonEnabled.Store(false)
+		slog.SetLogLoggerLevel(level.Level())
+		return
+	}
+	unfiltered := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	jsonHandler.Store(unfiltered)
+	handler := &levelHandler{Handler: unfiltered}
+	slog.SetDefault(slog.New(handler))
+	// SetDefault instrada il package log su slog sempre a livello INFO: il writer lo sostituisce per
+	// assegnare ai messaggi esistenti il livello corretto.
+	log.SetFlags(0)
+	log.SetOutput(&legacyLogWriter{handler: handler})
+	jsonEnabled.Store(true)
+}
+
+// SetLevel sets the minimum level of the slog logs (DEBUG se debug, altrimenti INFO).
+// Chiamata da config.SetLogLevel, così IsLogLevel e slog restano allineati anche dopo un reload.
+func SetLevel(debug bool) {
+	if debug {
+		level.Set(slog.LevelDebug)
+	} else {
+		level.Set(slog.LevelInfo)
+	}
+	if !jsonEnabled.Load() {
+		slog.SetLogLoggerLevel(level.Level())
+	}
+}
+
+// levelHandler applies the global level to a handler that does not filter, so a single handler (e un
+// solo lock sul writer) serve sia il logger di default sia gli StorageLogger.
+type levelHandler struct {
+	slog.Handler
+}
+
+func (h *levelHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= level.Level()
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// legacyLogWriter receives the output of the package log in JSON mode and turns each line into a record.
+type legacyLogWriter struct {
+	handler slog.Handler
+}
+
+func (w *legacyLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	lvl, msg := levelForMessage(msg)
+	ctx := context.Background()
+	if !w.handler.Enabled(ctx, lvl) {
+		return len(p), nil
+	}
+	if err := w.handler.Handle(ctx, slog.NewRecord(time.Now(), lvl, msg, 0)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// levelForMessage infers the level of a message written with the package log from the conventions of
+// the code base: "[DEBUG] ..." (prefisso rimosso), "Warning: ...", "Error ..." / "Error: ...".
+// Gli altri messaggi sono INFO, compresi quelli già filtrati da config.IsLogLevel(LogLevelDebug).
+func levelForMessage(msg string) (slog.Level, string) {
+	switch {
+	case strings.HasPrefix(msg, "[DEBUG] "):
+		return slog.LevelDebug, strings.TrimPrefix(msg, "[DEBUG] ")
+	case strings.HasPrefix(msg, "Warning"):
+		return slog.LevelWarn, msg
+	case strings.HasPrefix(msg, "Error"):
+		return slog.LevelError, msg
+	}
+	return slog.LevelInfo, msg
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+)
+
+var (
+	storageLevelsMu sync.RWMutex
+	// storageLevels contiene gli override di log_level per storage (nome -> DEBUG se true); gli storage
+	// assenti seguono il livello globale.
+	storageLevels = map[string]bool{}
+)
+
+// SetStorageLevels replaces all the per-storage overrides (chiamata al caricamento e a ogni reload
+// della configurazione, quindi annulla anche gli override impostati a runtime).
+func SetStorageLevels(levels map[string]bool) {
+	storageLevelsMu.Lock()
+	defer storageLevelsMu.Unlock()
+	storageLevels = maps.Clone(levels)
+	if storageLevels == nil {
+		storageLevels = map[string]bool{}
+	}
+}
+
+// SetStorageLevel sets the override of a single storage (DEBUG se debug, altrimenti INFO).
+func SetStorageLevel(storage string, debug bool) {
+	storageLevelsMu.Lock()
+	defer storageLevelsMu.Unlock()
+	storageLevels[storage] = debug
+}
+
+// ClearStorageLevel removes the override of a storage, che torna a seguire il livello globale.
+func ClearStorageLevel(storage string) {
+	storageLevelsMu.Lock()
+	defer storageLevelsMu.Unlock()
+	delete(storageLevels, storage)
+}
+
+// StorageLevels returns a copy of the current overrides (nome storage -> DEBUG se true).
+func StorageLevels() map[string]bool {
+	storageLevelsMu.RLock()
+	defer storageLevelsMu.RUnlock()
+	return maps.Clone(storageLevels)
+}
+
+// StorageLogger writes the logs of a storage provider at the level of that storage: l'override
+// log_level dello storage se presente, altrimenti il livello globale. I messaggi hanno il campo
+// storage, e quelli DEBUG vengono scritti anche quando il livello globale è INFO.
+type StorageLogger struct {
+	storage string
+}
+
+// NewStorageLogger returns the logger of the storage with the given name. Il livello viene letto a ogni
+// messaggio, quindi gli override applicati da reload o da /admin/loglevel valgono subito.
+func NewStorageLogger(storage string) *StorageLogger {
+	return &StorageLogger{storage: storage}
+}
+
+// IsDebug reports whether DEBUG messages of this storage are written.
+func (l *StorageLogger) IsDebug() bool {
+	storageLevelsMu.RLock()
+	debug, ok := storageLevels[l.storage]
+	storageLevelsMu.RUnlock()
+	if ok {
+		return debug
+	}
+	return level.Level() <= slog.LevelDebug
+}
+
+// Debugf writes a DEBUG message if IsDebug.
+func (l *StorageLogger) Debugf(format string, args ...any) {
+	if l.IsDebug() {
+		l.output(slog.LevelDebug, fmt.Sprintf(format, args...))
+	}
+}
+
+// Infof writes an INFO message; INFO è il livello minimo, quindi viene sempre scritto.
+func (l *StorageLogger) Infof(format string, args ...any) {
+	l.output(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// output writes the message bypassing the global level, già verificato dal chiamante.
+func (l *StorageLogger) output(lvl slog.Level, msg string) {
+	if h := jsonHandler.Load(); h != nil && jsonEnabled.Load() {
+		record := slog.NewRecord(time.Now(), lvl, msg, 0)
+		record.AddAttrs(slog.String(KeyStorage, l.storage))
+		_ = h.Handle(context.Background(), record)
+		return
+	}
+	// Stesso formato che slog usa in modalità testo: "LIVELLO messaggio chiave=valore".
+	_ = log.Output(3, fmt.Sprintf("%s %s %s=%s", lvl, msg, KeyStorage, l.storage))
+}
@@ -0,0 +1,214 @@
+// Package metrics exposes the server metrics in the Prometheus text exposition format (version 0.0.4).
+// Implementa solo ciò che serve al server (counter, gauge calcolati allo scrape e istogrammi con label)
+// senza dipendere dalla libreria client di Prometheus.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is a metric family that can write its samples.
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]metric)
+)
+
+func register(name string, m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = m
+}
+
+// Handler returns the HTTP handler that serves every registered metric.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryMu.Lock()
+		names := make([]string, 0, len(registry))
+		for name := range registry {
+			names = append(names, name)
+		}
+		metrics := make([]metric, 0, len(names))
+		sort.Strings(names)
+		for _, name := range names {
+			metrics = append(metrics, registry[name])
+		}
+		registryMu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range metrics {
+			m.write(w)
+		}
+	})
+}
+
+// labelKey joins label values into a map key (i valori non contengono mai \xff).
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// labelValueEscaper escapes a label value as the text format requires: solo backslash, doppi apici e
+// newline (strconv.Quote produrrebbe anche sequenze come \t o \u che Prometheus rifiuta).
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels returns {name="value",...} for the given names and values, or "" without labels.
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, labelValueEscaper.Replace(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]float64
+	labels     map[string][]string
+}
+
+// NewCounterVec creates and registers a counter with the given label names.
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labelNames: labelNames, values: make(map[string]float64), labels: make(map[string][]string)}
+	register(name, c)
+	return c
+}
+
+// Add adds v (>= 0) to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.labels[key]; !ok {
+		c.labels[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] += v
+}
+
+// Inc increments the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labelNames, c.labels[key], "", ""), formatValue(c.values[key]))
+	}
+}
+
+// gaugeFunc is a gauge whose value is read when the metrics are scraped.
+type gaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge read from value at every scrape; registrarne uno con lo stesso nome lo sostituisce.
+func NewGaugeFunc(name string, help string, value func() float64) {
+	register(name, &gaugeFunc{name: name, help: help, value: value})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatValue(g.value()))
+}
+
+// DefaultBuckets are the histogram buckets, in seconds, used for request latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // Conteggi non cumulativi per bucket; l'ultimo elemento è +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates and registers a histogram with the given upper bounds and label names.
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labelNames: labelNames, buckets: buckets, series: make(map[string]*histogramSeries)}
+	register(name, h)
+	return h
+}
+
+// Observe records a value in the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			bound := math.Inf(1)
+			if i < len(h.buckets) {
+				bound = h.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labelNames, s.labels, "le", formatValue(bound)), cumulative)
+		}
+		labels := formatLabels(h.labelNames, s.labels, "", "")
+		fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, labels, formatValue(s.sum), h.name, labels, s.count)
+	}
+}
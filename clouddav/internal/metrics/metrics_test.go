@@ -0,0 +1,260 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sample is a parsed sample line of the text exposition format.
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)
+)
+
+// parseExposition parses the text exposition format (version 0.0.4) strictly enough to catch the
+// errors a Prometheus server would reject: nomi non validi, escape nelle label, campioni senza TYPE
+// e famiglie ripetute. Restituisce i tipi delle famiglie e i campioni nell'ordine di uscita.
+func parseExposition(text string) (map[string]string, []sample, error) {
+	types := map[string]string{}
+	var samples []sample
+	current := ""
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# ") {
+			fields := strings.SplitN(line[2:], " ", 3)
+			if len(fields) < 3 || !metricNamePattern.MatchString(fields[1]) {
+				return nil, nil, fmt.Errorf("line %d: malformed comment %q", lineNumber, line)
+			}
+			if fields[0] == "TYPE" {
+				if _, ok := types[fields[1]]; ok {
+					return nil, nil, fmt.Errorf("line %d: family %s declared twice", lineNumber, fields[1])
+				}
+				switch fields[2] {
+				case "counter", "gauge", "histogram":
+				default:
+					return nil, nil, fmt.Errorf("line %d: unknown type %q", lineNumber, fields[2])
+				}
+				types[fields[1]] = fields[2]
+				current = fields[1]
+			}
+			continue
+		}
+
+		name := metricNamePattern.FindString(line)
+		if name == "" {
+			return nil, nil, fmt.Errorf("line %d: invalid metric name in %q", lineNumber, line)
+		}
+		family := name
+		if types[current] == "histogram" {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if strings.TrimSuffix(name, suffix) == current {
+					family = current
+				}
+			}
+		}
+		if family != current {
+			return nil, nil, fmt.Errorf("line %d: sample %s outside its family (current %s)", lineNumber, name, current)
+		}
+		rest := line[len(name):]
+		labels := map[string]string{}
+		if strings.HasPrefix(rest, "{") {
+			var err error
+			labels, rest, err = parseLabels(rest[1:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+		}
+		if !strings.HasPrefix(rest, " ") {
+			return nil, nil, fmt.Errorf("line %d: missing value in %q", lineNumber, line)
+		}
+		value, err := strconv.ParseFloat(rest[1:], 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: invalid value: %w", lineNumber, err)
+		}
+		samples = append(samples, sample{name: name, labels: labels, value: value})
+	}
+	return types, samples, scanner.Err()
+}
+
+// parseLabels parses the labels after the opening brace and returns the rest of the line.
+func parseLabels(s string) (map[string]string, string, error) {
+	labels := map[string]string{}
+	for {
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], nil
+		}
+		name := labelNamePattern.FindString(s)
+		if name == "" || !strings.HasPrefix(s[len(name):], `="`) {
+			return nil, "", fmt.Errorf("malformed label at %q", s)
+		}
+		s = s[len(name)+2:]
+		var value strings.Builder
+		for {
+			if s == "" {
+				return nil, "", fmt.Errorf("unterminated value of label %s", name)
+			}
+			c := s[0]
+			s = s[1:]
+			if c == '"' {
+				break
+			}
+			if c == '\n' {
+				return nil, "", fmt.Errorf("raw newline in label %s", name)
+			}
+			if c == '\\' {
+				if s == "" {
+					return nil, "", fmt.Errorf("unterminated escape in label %s", name)
+				}
+				switch s[0] {
+				case '\\', '"':
+					value.WriteByte(s[0])
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					return nil, "", fmt.Errorf("invalid escape \\%c in label %s", s[0], name)
+				}
+				s = s[1:]
+				continue
+			}
+			value.WriteByte(c)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, "", fmt.Errorf("label %s repeated", name)
+		}
+		labels[name] = value.String()
+		s = strings.TrimPrefix(s, ",")
+	}
+}
+
+// scrape serves the registry through Handler and parses the response.
+func scrape(t *testing.T) (map[string]string, []sample) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	types, samples, err := parseExposition(w.Body.String())
+	if err != nil {
+		t.Fatalf("invalid exposition: %v\n%s", err, w.Body.String())
+	}
+	return types, samples
+}
+
+// find returns the value of the sample with the given name and labels.
+func find(samples []sample, name string, labels map[string]string) (float64, bool) {
+	for _, s := range samples {
+		if s.name != name || len(s.labels) != len(labels) {
+			continue
+		}
+		match := true
+		for key, value := range labels {
+			if s.labels[key] != value {
+				match = false
+			}
+		}
+		if match {
+			return s.value, true
+		}
+	}
+	return 0, false
+}
+
+func TestExpositionCounterAndGauge(t *testing.T) {
+	counter := NewCounterVec("test_counter_total", "A test counter.", "storage")
+	values := []string{"plain", `quote " and \ backslash`, "new\nline", "tab\tand ü"}
+	for i, value := range values {
+		counter.Add(float64(i+1), value)
+	}
+	counter.Inc("plain")
+	NewGaugeFunc("test_gauge", "A test gauge.", func() float64 { return 2.5 })
+
+	types, samples := scrape(t)
+	if types["test_counter_total"] != "counter" || types["test_gauge"] != "gauge" {
+		t.Errorf("types = %v", types)
+	}
+	for i, value := range values {
+		want := float64(i + 1)
+		if value == "plain" {
+			want++
+		}
+		if got, ok := find(samples, "test_counter_total", map[string]string{"storage": value}); !ok || got != want {
+			t.Errorf("counter{storage=%q} = %v, %t; want %v", value, got, ok, want)
+		}
+	}
+	if got, ok := find(samples, "test_gauge", map[string]string{}); !ok || got != 2.5 {
+		t.Errorf("gauge = %v, %t; want 2.5", got, ok)
+	}
+}
+
+func TestExpositionHistogram(t *testing.T) {
+	histogram := NewHistogramVec("test_duration_seconds", "A test histogram.", []float64{0.1, 1, 10}, "storage")
+	for _, v := range []float64{0.05, 0.1, 0.5, 5, 50} {
+		histogram.Observe(v, "data")
+	}
+	histogram.Observe(1, "other")
+
+	types, samples := scrape(t)
+	if types["test_duration_seconds"] != "histogram" {
+		t.Fatalf("types = %v", types)
+	}
+	wantBuckets := map[string]float64{"0.1": 2, "1": 3, "10": 4, "+Inf": 5}
+	for le, want := range wantBuckets {
+		if got, ok := find(samples, "test_duration_seconds_bucket", map[string]string{"storage": "data", "le": le}); !ok || got != want {
+			t.Errorf("bucket le=%s = %v, %t; want %v", le, got, ok, want)
+		}
+	}
+	count, _ := find(samples, "test_duration_seconds_count", map[string]string{"storage": "data"})
+	sum, _ := find(samples, "test_duration_seconds_sum", map[string]string{"storage": "data"})
+	if count != 5 || math.Abs(sum-55.65) > 1e-9 {
+		t.Errorf("count = %v, sum = %v; want 5 and 55.65", count, sum)
+	}
+
+	// I bucket sono cumulativi e quello +Inf coincide con _count, per ogni serie.
+	previous := map[string]float64{}
+	for _, s := range samples {
+		if s.name != "test_duration_seconds_bucket" {
+			continue
+		}
+		storage := s.labels["storage"]
+		if s.value < previous[storage] {
+			t.Errorf("bucket le=%s of %s decreases: %v after %v", s.labels["le"], storage, s.value, previous[storage])
+		}
+		previous[storage] = s.value
+		if s.labels["le"] == "+Inf" {
+			if count, _ := find(samples, "test_duration_seconds_count", map[string]string{"storage": storage}); count != s.value {
+				t.Errorf("+Inf bucket of %s = %v, _count = %v", storage, s.value, count)
+			}
+		}
+	}
+}
+
+func TestServerMetricsExposition(t *testing.T) {
+	WebSocketMessages.Inc("list_directory")
+	ListItemsDuration.Observe(0.2, "data")
+	types, _ := scrape(t)
+	for name, want := range map[string]string{
+		"clouddav_websocket_messages_total":    "counter",
+		"clouddav_upload_bytes_written_total":  "counter",
+		"clouddav_list_items_duration_seconds": "histogram",
+		"clouddav_download_duration_seconds":   "histogram",
+		"clouddav_rate_limited_total":          "counter",
+	} {
+		if types[name] != want {
+			t.Errorf("type of %s = %q, want %q", name, types[name], want)
+		}
+	}
+}
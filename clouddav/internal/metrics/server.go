@@ -0,0 +1,23 @@
+package metrics
+
+import "time"
+
+// Metriche del server, esposte su /metrics. Le label "storage" usano solo nomi di storage configurati,
+// "type" solo i tipi di messaggio supportati, così la cardinalità resta limitata.
+var (
+	WebSocketMessages = NewCounterVec("clouddav_websocket_messages_total",
+		"Client messages handled over WebSocket and long polling, by message type.", "type")
+	UploadBytesWritten = NewCounterVec("clouddav_upload_bytes_written_total",
+		"Bytes of upload chunks written to the storage, by storage.", "storage")
+	ListItemsDuration = NewHistogramVec("clouddav_list_items_duration_seconds",
+		"Duration of directory listings (ListItems), by storage.", DefaultBuckets, "storage")
+	DownloadDuration = NewHistogramVec("clouddav_download_duration_seconds",
+		"Duration of /download requests until the response is fully written, by storage.", DefaultBuckets, "storage")
+	RateLimited = NewCounterVec("clouddav_rate_limited_total",
+		"Requests rejected by rate_limit_per_minute, by source (http or message).", "source")
+)
+
+// ObserveSince records the time elapsed since start, in seconds.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
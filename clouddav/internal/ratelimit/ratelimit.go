@@ -0,0 +1,81 @@
+// Package ratelimit limits the requests of each user with a token bucket, condiviso tra le richieste HTTP
+// (AuthMiddleware) e i messaggi WebSocket/long polling, così un client non può sovraccaricare gli storage.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	mu       sync.Mutex
+	limiters = make(map[string]*rate.Limiter)
+)
+
+// Key returns the bucket of a request: l'email dell'utente autenticato, altrimenti l'indirizzo del client,
+// così i client anonimi non condividono un unico bucket e uno solo non può esaurirlo per tutti.
+func Key(email string, remoteAddr string) string {
+	if email != "" {
+		return email
+	}
+	return "anonymous@" + remoteAddr
+}
+
+// Allow consumes a token from the bucket of user, refilled at perMinute tokens per minute up to burst.
+// Se il bucket è vuoto restituisce false e il tempo dopo cui la richiesta sarebbe accettata.
+// perMinute <= 0 disattiva il limite. Limite e burst vengono aggiornati se cambiano con un reload.
+func Allow(user string, perMinute int, burst int) (bool, time.Duration) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+	limit := rate.Limit(float64(perMinute) / 60)
+	now := time.Now()
+
+	mu.Lock()
+	limiter, ok := limiters[user]
+	if !ok {
+		limiter = rate.NewLimiter(limit, burst)
+		limiters[user] = limiter
+	}
+	mu.Unlock()
+
+	if limiter.Limit() != limit {
+		limiter.SetLimitAt(now, limit)
+	}
+	if limiter.Burst() != burst {
+		limiter.SetBurstAt(now, burst)
+	}
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() { // burst 0: nessuna richiesta può passare
+		return false, time.Minute
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// RetryAfterSeconds rounds a delay returned by Allow up to whole seconds, per l'header Retry-After.
+func RetryAfterSeconds(delay time.Duration) int {
+	return int(math.Ceil(delay.Seconds()))
+}
+
+// Prune removes the buckets that have refilled completely: sono equivalenti a un bucket nuovo, quindi
+// eliminarli non cambia i limiti ma evita di conservare un limiter per ogni utente visto.
+func Prune() int {
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	removed := 0
+	for user, limiter := range limiters {
+		if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+			delete(limiters, user)
+			removed++
+		}
+	}
+	return removed
+}
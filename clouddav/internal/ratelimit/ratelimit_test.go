@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// resetLimiters empties the buckets, che sono globali, prima e dopo il test.
+func resetLimiters(t *testing.T) {
+	reset := func() {
+		mu.Lock()
+		limiters = make(map[string]*rate.Limiter)
+		mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestAllowRefillsTokens(t *testing.T) {
+	resetLimiters(t)
+	const perMinute = 6000 // Un token ogni 10ms
+	if ok, _ := Allow("refill@example.com", perMinute, 1); !ok {
+		t.Fatal("first request rejected with a full bucket")
+	}
+	ok, delay := Allow("refill@example.com", perMinute, 1)
+	if ok || delay <= 0 || delay > 10*time.Millisecond {
+		t.Fatalf("second request = %t, %v; want rejected with a delay up to 10ms", ok, delay)
+	}
+	time.Sleep(delay)
+	if ok, delay := Allow("refill@example.com", perMinute, 1); !ok {
+		t.Errorf("request after the refill delay rejected, retry after %v", delay)
+	}
+}
+
+func TestAllowIsolatesUsers(t *testing.T) {
+	resetLimiters(t)
+	for i := 0; i < 3; i++ {
+		if ok, _ := Allow("isolated-a@example.com", 1, 3); !ok {
+			t.Fatalf("request %d of the burst rejected", i)
+		}
+	}
+	if ok, _ := Allow("isolated-a@example.com", 1, 3); ok {
+		t.Error("request beyond the burst accepted")
+	}
+	if ok, _ := Allow("isolated-b@example.com", 1, 3); !ok {
+		t.Error("another user rejected after the first exhausted its bucket")
+	}
+	if Key("", "192.0.2.1") == Key("", "192.0.2.2") || Key("a@example.com", "192.0.2.1") != Key("a@example.com", "192.0.2.2") {
+		t.Error("Key does not separate anonymous clients by address or join a user's addresses")
+	}
+}
+
+func TestAllowLimits(t *testing.T) {
+	resetLimiters(t)
+	if ok, _ := Allow("disabled@example.com", 0, 0); !ok {
+		t.Error("perMinute 0 does not disable the limit")
+	}
+	if ok, delay := Allow("zero-burst@example.com", 60, 0); ok || delay != time.Minute {
+		t.Errorf("burst 0 = %t, %v; want rejected for a minute", ok, delay)
+	}
+
+	// Un reload che alza il burst vale per il bucket esistente.
+	if ok, _ := Allow("reload@example.com", 1, 1); !ok {
+		t.Fatal("first request rejected")
+	}
+	if ok, _ := Allow("reload@example.com", 1, 1); ok {
+		t.Fatal("second request accepted with burst 1")
+	}
+	if ok, delay := Allow("reload@example.com", 6000, 1); !ok && delay > 10*time.Millisecond {
+		t.Errorf("after raising the rate the retry delay is %v, want at most 10ms", delay)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	resetLimiters(t)
+	Allow("prune-full@example.com", 6000, 1)
+	Allow("prune-empty@example.com", 1, 1)
+	time.Sleep(20 * time.Millisecond)
+	Prune()
+	mu.Lock()
+	_, full := limiters["prune-full@example.com"]
+	_, empty := limiters["prune-empty@example.com"]
+	mu.Unlock()
+	if full || !empty {
+		t.Errorf("after Prune: refilled bucket kept %t, empty bucket kept %t; want false, true", full, empty)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	for delay, want := range map[time.Duration]int{time.Millisecond: 1, time.Second: 1, 1500 * time.Millisecond: 2} {
+		if got := RetryAfterSeconds(delay); got != want {
+			t.Errorf("RetryAfterSeconds(%v) = %d, want %d", delay, got, want)
+		}
+	}
+}
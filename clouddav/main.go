@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 	"time"
 
@@ -14,7 +16,10 @@ import (
 	"clouddav/handlers"
 	"clouddav/storage"
 	"clouddav/storage/azureblob"
+	"clouddav/storage/command"
+	"clouddav/storage/gcs"
 	"clouddav/storage/local"
+	"clouddav/storage/memory"
 	"clouddav/websocket" // Importa il package websocket
 )
 
@@ -35,27 +40,20 @@ func main() {
 		log.Println("Azure AD authentication is disabled.")
 	}
 
-	// Inizializza i provider di storage
-	storage.ClearRegistry() // Pulisce il registro degli storage prima di inizializzare
-	for _, sc := range config.AppConfig.Storages {
-		var provider storage.StorageProvider
-		var err error
-		switch sc.Type {
-		case "local":
-			log.Printf("Inizializzazione provider locale: %+v", sc)
-			provider, err = local.NewProvider(&sc)
-		case "azure-blob":
-			log.Printf("Inizializzazione provider Azure Blob: %+v", sc)
-			provider, err = azureblob.NewProvider(&sc)
-		default:
-			log.Fatalf("Unknown storage type configured: %s", sc.Type)
-		}
+	local.SetUploadStateFile(config.AppConfig.UploadTemp.SessionStateFile)
+	storage.SetDeleteWorkers(config.AppConfig.GlobalDeleteWorkers)
+	if err := storage.InitChangeLogs(config.AppConfig.ChangeLog); err != nil {
+		log.Fatalf("Failed to initialize the change logs: %v", err)
+	}
+	defer storage.CloseChangeLogs()
 
-		if err != nil {
-			log.Fatalf("Failed to initialize storage provider %s (%s): %v", sc.Name, sc.Type, err)
-		}
-		storage.RegisterProvider(provider)
-		log.Printf("Storage provider registrato con successo: Type='%s', Name='%s'", provider.Type(), provider.Name())
+	// Inizializza i provider di storage
+	providers, err := newStorageProviders(&config.AppConfig, nil)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := storage.ReplaceProviders(providers); err != nil {
+		log.Fatalf("Failed to register storage providers: %v", err)
 	}
 
 	// Crea il contesto principale per l'applicazione
@@ -64,13 +62,15 @@ func main() {
 
 	// Inizializza il WebSocket Hub
 	wsHub := websocket.NewHub(appCtx, &config.AppConfig)
-	go wsHub.Run() // Avvia il Hub in una goroutine
+	restoreUploadSessions(appCtx, wsHub)
+	go wsHub.Run()                                          // Avvia il Hub in una goroutine
+	storage.SetChangeListener(wsHub.NotifyDirectoryChanged) // directory_changed ai client che guardano la directory
 
 	// Crea un nuovo multiplexer HTTP
 	mainMux := http.NewServeMux()
 
 	// Inizializza gli handler HTTP, passando il Hub e il multiplexer
-	handlers.InitHandlers(&config.AppConfig, wsHub, mainMux) // Passa mainMux
+	handlers.InitHandlers(wsHub, mainMux) // Passa mainMux
 
 	// Configura il server HTTP
 	readTimeout, writeTimeout, idleTimeout, err := config.AppConfig.GetTimeouts()
@@ -83,7 +83,7 @@ func main() {
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
 		IdleTimeout:  idleTimeout,
-		Handler:      mainMux, // Usa il multiplexer configurato
+		Handler:      handlers.AccessLogMiddleware(mainMux), // Usa il multiplexer configurato, con access log
 	}
 
 	// Avvia il server in una goroutine
@@ -94,13 +94,19 @@ func main() {
 		}
 	}()
 
-	// Gestione dello shutdown controllato
+	// Gestione dello shutdown controllato; SIGHUP ricarica la configurazione senza riavviare
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM) // Cattura segnali di interruzione
-	<-sigChan                                               // Blocca finché non riceve un segnale
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP) // Cattura segnali di interruzione e reload
+	for sig := <-sigChan; sig == syscall.SIGHUP; sig = <-sigChan {          // Blocca finché non riceve un segnale di shutdown
+		reloadConfig(config_path, wsHub)
+	}
 
 	log.Println("Segnale di shutdown ricevuto. Spegnimento del server...")
 
+	// Prima di fermare il server HTTP si lascia agli upload in corso shutdown_upload_grace per completarsi:
+	// i chunk e il finalize arrivano come richieste HTTP, quindi il server deve restare attivo nel frattempo.
+	drainUploads(wsHub, sigChan)
+
 	// Crea un contesto con timeout per lo shutdown
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer shutdownCancel()
@@ -116,3 +122,201 @@ func main() {
 	log.Println("Server spento.")
 }
 
+
+// drainUploads refuses new uploads and waits up to shutdown_upload_grace for the ongoing ones to complete.
+// Allo scadere, o a un secondo segnale, si prosegue con lo shutdown, che annulla gli upload rimasti come in precedenza.
+func drainUploads(wsHub *websocket.Hub, sigChan <-chan os.Signal) {
+	grace, err := wsHub.Config().GetShutdownUploadGrace()
+	if err != nil || grace <= 0 {
+		return
+	}
+	wsHub.BeginUploadDrain()
+	log.Printf("Attesa fino a %s per il completamento degli upload in corso (shutdown_upload_grace)", grace)
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), grace)
+	defer drainCancel()
+	go func() {
+		select {
+		case <-sigChan:
+			log.Println("Secondo segnale ricevuto: attesa degli upload interrotta.")
+			drainCancel()
+		case <-drainCtx.Done():
+		}
+	}()
+	if remaining := wsHub.WaitForUploads(drainCtx); remaining > 0 {
+		log.Printf("shutdown_upload_grace scaduto: %d upload ancora in corso verranno annullati", remaining)
+	} else {
+		log.Println("Nessun upload in corso, shutdown del server.")
+	}
+}
+
+// restoreUploadSessions reloads the local upload sessions saved before the restart (upload_temp.session_state_file)
+// and registers them in the Hub, so that clients can resume them after asking for their status.
+func restoreUploadSessions(ctx context.Context, wsHub *websocket.Hub) {
+	var localProviders []*local.LocalFilesystemProvider
+	for _, provider := range storage.GetAllProviders() {
+		if localProvider, ok := storage.Unwrap(provider).(*local.LocalFilesystemProvider); ok {
+			localProviders = append(localProviders, localProvider)
+		}
+	}
+	restored, err := local.RestoreUploadSessions(ctx, localProviders)
+	if err != nil {
+		log.Printf("Error restoring upload sessions, ongoing uploads must be restarted: %v", err)
+		return
+	}
+	for _, session := range restored {
+		wsHub.AddRestoredUpload(session.UploadID, session.StorageName, session.ItemPath, session.UserEmail, "local", session.UploadedSize, session.ExpectedFileSize)
+	}
+	if len(restored) > 0 {
+		log.Printf("Restored %d upload sessions", len(restored))
+	}
+}
+
+// newStorageProviders creates the providers of the storages in cfg. Con previous non nil (reload), gli storage
+// la cui configurazione non è cambiata riusano il provider registrato, senza interrompere le operazioni in corso.
+// Ogni provider ha il proprio timeout, così un backend irraggiungibile fa fallire l'avvio invece di bloccarlo.
+func newStorageProviders(cfg *config.Config, previous *config.Config) ([]storage.StorageProvider, error) {
+	providerInitTimeout, err := cfg.GetProviderInitTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("error getting provider init timeout from config: %w", err)
+	}
+	providers := make([]storage.StorageProvider, 0, len(cfg.Storages))
+	for _, sc := range cfg.Storages {
+		if previous != nil {
+			// Le impostazioni lette dalla configurazione a ogni richiesta (permessi, log_level, ...) non richiedono
+			// di ricreare il provider.
+			if previousSc := previous.GetStorageConfig(sc.Name); previousSc != nil && sameProviderSettings(*previousSc, sc) {
+				if provider, ok := storage.GetProvider(sc.Name); ok {
+					providers = append(providers, provider)
+					continue
+				}
+			}
+		}
+
+		var provider storage.StorageProvider
+		initCtx, initCancel := context.WithTimeout(context.Background(), providerInitTimeout)
+		switch sc.Type {
+		case "local":
+			log.Printf("Inizializzazione provider locale: %s", storageLogSummary(sc))
+			provider, err = local.NewProvider(initCtx, &sc)
+		case "azure-blob":
+			log.Printf("Inizializzazione provider Azure Blob: %s", storageLogSummary(sc))
+			provider, err = azureblob.NewProvider(initCtx, &sc)
+		case "command":
+			log.Printf("Inizializzazione provider command: %s", storageLogSummary(sc))
+			provider, err = command.NewProvider(initCtx, &sc)
+		case "gcs":
+			log.Printf("Inizializzazione provider GCS: %s", storageLogSummary(sc))
+			provider, err = gcs.NewProvider(initCtx, &sc)
+		case "memory":
+			log.Printf("Inizializzazione provider in memoria: %s", storageLogSummary(sc))
+			provider, err = memory.NewProvider(initCtx, &sc)
+		default:
+			err = fmt.Errorf("unknown storage type configured: %s", sc.Type)
+		}
+		initCancel()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize storage provider %s (%s): %w", sc.Name, sc.Type, err)
+		}
+		changeLog, err := storage.ChangeLogFor(sc.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the change log of storage %s: %w", sc.Name, err)
+		}
+		provider = storage.NewChangeLoggingProvider(provider, changeLog)
+		// Il timeout è già stato validato da ReadConfig.
+		queueTimeout, _ := sc.GetOperationQueueTimeout()
+		provider = storage.NewLimitedProvider(provider, sc.MaxConcurrentOperations, queueTimeout)
+		providers = append(providers, provider)
+		log.Printf("Storage provider inizializzato con successo: Type='%s', Name='%s'", provider.Type(), provider.Name())
+	}
+	return providers, nil
+}
+
+// reloadConfig re-reads the configuration file (SIGHUP) and applies it: storages, permissions and the
+// other settings read per request take effect for the following requests. Una configurazione non valida,
+// o uno storage che non si inizializza, viene scartata e il server continua con quella in uso.
+func reloadConfig(configPath string, wsHub *websocket.Hub) {
+	log.Printf("SIGHUP received, reloading configuration from %s", configPath)
+	newCfg, err := config.ReadConfig(configPath)
+	if err != nil {
+		log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+		return
+	}
+	currentCfg := wsHub.Config()
+	providers, err := newStorageProviders(newCfg, currentCfg)
+	if err != nil {
+		log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+		return
+	}
+	if err := storage.ReplaceProviders(providers); err != nil {
+		log.Printf("Configuration reload failed, keeping the current configuration: %v", err)
+		return
+	}
+	wsHub.SetConfig(newCfg)
+	config.SetLogLevel(newCfg.LogLevel)
+	config.SetStorageLogLevels(newCfg.Storages)
+	warnRestartRequiredSettings(currentCfg, newCfg)
+	wsHub.ReevaluateClientAccess()
+	log.Printf("Configuration reloaded from %s: %d storages", configPath, len(providers))
+}
+
+// storageLogSummary describes a storage for the logs without its secrets: la configurazione completa
+// contiene connection string, SAS, credenziali e comandi che possono includere password.
+func storageLogSummary(sc config.StorageConfig) string {
+	summary := fmt.Sprintf("name=%s type=%s", sc.Name, sc.Type)
+	switch sc.Type {
+	case "local":
+		summary += fmt.Sprintf(" path=%s", sc.Path)
+	case "azure-blob":
+		auth := "azure-ad"
+		switch {
+		case sc.ConnectionString != "":
+			auth = "connection-string"
+		case sc.SASURL != "" || sc.SASToken != "":
+			auth = "sas"
+		}
+		summary += fmt.Sprintf(" account=%s container=%s auth=%s", sc.AccountName, sc.ContainerName, auth)
+	case "gcs":
+		summary += fmt.Sprintf(" bucket=%s", sc.Bucket)
+	}
+	return summary
+}
+
+// sameProviderSettings reports whether two storage configurations differ at most in settings that are read
+// from the current configuration at every request, and so do not require a new provider: permissions
+// (autorizzazione), log_level (letto dal logger a ogni messaggio), quota, download_checksums,
+// upload_cleanup_timeout e normalizzazione dei path.
+func sameProviderSettings(a, b config.StorageConfig) bool {
+	for _, sc := range []*config.StorageConfig{&a, &b} {
+		sc.Permissions = nil
+		sc.LogLevel = ""
+		sc.QuotaBytes = 0
+		sc.DownloadChecksums = config.DownloadChecksumConfig{}
+		sc.UploadCleanupTimeout = ""
+		sc.NormalizeBackslashes = false
+		sc.KeepTrailingSlashes = false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// warnRestartRequiredSettings logs the changed settings that are applied only at startup.
+func warnRestartRequiredSettings(currentCfg *config.Config, newCfg *config.Config) {
+	startupOnly := map[string]bool{
+		"enable_auth":                    currentCfg.EnableAuth != newCfg.EnableAuth,
+		"azure_ad":                       !reflect.DeepEqual(currentCfg.AzureAD, newCfg.AzureAD),
+		"timeouts":                       currentCfg.Timeouts != newCfg.Timeouts,
+		"upload_cleanup_timeout":         currentCfg.UploadCleanupTimeout != newCfg.UploadCleanupTimeout,
+		"upload_temp.max_age":            currentCfg.UploadTemp.MaxAge != newCfg.UploadTemp.MaxAge,
+		"upload_temp.check_interval":     currentCfg.UploadTemp.CheckInterval != newCfg.UploadTemp.CheckInterval,
+		"upload_temp.session_state_file": currentCfg.UploadTemp.SessionStateFile != newCfg.UploadTemp.SessionStateFile,
+		"recent_errors":                  currentCfg.RecentErrors != newCfg.RecentErrors,
+		"notifications":                  currentCfg.Notifications != newCfg.Notifications,
+		"global_delete_workers":          currentCfg.GlobalDeleteWorkers != newCfg.GlobalDeleteWorkers,
+		"log_format":                     currentCfg.LogFormat != newCfg.LogFormat,
+	}
+	for setting, changed := range startupOnly {
+		if changed {
+			log.Printf("Warning: '%s' changed, the new value takes effect only after a restart", setting)
+		}
+	}
+}
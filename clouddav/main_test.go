@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"clouddav/config"
+)
+
+func TestSameProviderSettings(t *testing.T) {
+	base := config.StorageConfig{
+		Name:             "data",
+		Type:             "local",
+		FilesystemConfig: config.FilesystemConfig{Path: "/srv/data"},
+		Permissions:      []config.Permission{{GroupID: "editors", Access: "write"}},
+	}
+	tests := []struct {
+		name   string
+		change func(sc *config.StorageConfig)
+		same   bool
+	}{
+		{"unchanged", func(sc *config.StorageConfig) {}, true},
+		{"permissions", func(sc *config.StorageConfig) {
+			sc.Permissions = []config.Permission{{GroupID: "editors", Access: "read"}}
+		}, true},
+		{"log level", func(sc *config.StorageConfig) { sc.LogLevel = "DEBUG" }, true},
+		{"quota", func(sc *config.StorageConfig) { sc.QuotaBytes = 1 << 30 }, true},
+		{"download checksums", func(sc *config.StorageConfig) { sc.DownloadChecksums.Enabled = true }, true},
+		{"path normalization", func(sc *config.StorageConfig) { sc.NormalizeBackslashes = true }, true},
+		{"path", func(sc *config.StorageConfig) { sc.Path = "/srv/other" }, false},
+		{"store checksums", func(sc *config.StorageConfig) { sc.StoreChecksums = true }, false},
+		{"max concurrent operations", func(sc *config.StorageConfig) { sc.MaxConcurrentOperations = 4 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := base
+			changed.Permissions = append([]config.Permission(nil), base.Permissions...)
+			tt.change(&changed)
+			if got := sameProviderSettings(base, changed); got != tt.same {
+				t.Errorf("sameProviderSettings = %t, want %t", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestStorageLogSummaryOmitsSecrets(t *testing.T) {
+	secrets := []string{"AccountKey=secret-key", "sig=secret-sas", "sv=secret-token", "/etc/gcs-key.json", "password=hunter2"}
+	storages := []config.StorageConfig{
+		{Name: "conn", Type: "azure-blob", AzureBlobStorageConfig: config.AzureBlobStorageConfig{ConnectionString: "DefaultEndpointsProtocol=https;AccountKey=secret-key", ContainerName: "files"}},
+		{Name: "sasurl", Type: "azure-blob", AzureBlobStorageConfig: config.AzureBlobStorageConfig{SASURL: "https://acct.blob.core.windows.net/files?sig=secret-sas", ContainerName: "files"}},
+		{Name: "sastoken", Type: "azure-blob", AzureBlobStorageConfig: config.AzureBlobStorageConfig{AccountName: "acct", ContainerName: "files", SASToken: "sv=secret-token"}},
+		{Name: "gcs", Type: "gcs", GCSStorageConfig: config.GCSStorageConfig{Bucket: "bucket", CredentialsFile: "/etc/gcs-key.json"}},
+		{Name: "cmd", Type: "command", CommandStorageConfig: config.CommandStorageConfig{Commands: config.CommandSet{List: []string{"tool", "--password=hunter2", "ls"}}}},
+	}
+	for _, sc := range storages {
+		summary := storageLogSummary(sc)
+		if !strings.Contains(summary, "name="+sc.Name) {
+			t.Errorf("storageLogSummary(%s) = %q, want the storage name", sc.Name, summary)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(summary, secret) {
+				t.Errorf("storageLogSummary(%s) = %q, contains %q", sc.Name, summary, secret)
+			}
+		}
+	}
+	if summary := storageLogSummary(storages[2]); !strings.Contains(summary, "account=acct container=files auth=sas") {
+		t.Errorf("storageLogSummary(sastoken) = %q, want account, container and auth method", summary)
+	}
+}
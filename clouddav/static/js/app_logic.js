@@ -481,6 +481,8 @@ window.handleBackendMessage = (message) => {
         }
     } else if (message.type === 'create_directory_response' ||
                message.type === 'delete_item_response' ||
+               message.type === 'my_permissions_response' ||
+               message.type === 'get_directory_size_response' ||
                message.type === 'check_directory_contents_request_response') { 
         // Ensure filelist controller handles these if it's defined
         if (typeof window.handleFilelistBackendResponse === 'function') {
@@ -500,6 +502,22 @@ window.handleBackendMessage = (message) => {
         if (window.handleConfigUpdate) { 
              window.handleConfigUpdate(message);
         }
+        // config_update arriva a ogni (ri)connessione: recupera le notifiche accumulate mentre eravamo offline.
+        if (window.sendMessage) {
+            window.sendMessage({ type: 'get_notifications', payload: {} });
+        }
+    } else if (message.type === 'get_notifications_response') {
+        const notifications = (message.payload && message.payload.notifications) || [];
+        // Il server le restituisce dalla più recente: le mostriamo in ordine cronologico.
+        notifications.slice().reverse().forEach(showNotification);
+        markNotificationsRead(notifications.map(n => n.id));
+    } else if (message.type === 'notification') {
+        if (message.payload) {
+            showNotification(message.payload);
+            markNotificationsRead([message.payload.id]);
+        }
+    } else if (message.type === 'mark_notifications_read_response') {
+        // Nessuna azione: le notifiche sono già state mostrate.
     } else if (message.type === 'error') {
         console.error('AppLogic - Backend error:', message.payload ? message.payload.error : 'Errore sconosciuto');
         if (window.showToast) { 
@@ -536,6 +554,31 @@ window.handleBackendMessage = (message) => {
     }
 };
 
+// --- Notifiche ---
+// ID già mostrati: con il Long Polling il recupero tramite cursor può sovrapporsi a get_notifications.
+const shownNotificationIds = new Set();
+
+function showNotification(notification) {
+    if (shownNotificationIds.has(notification.id)) {
+        return;
+    }
+    shownNotificationIds.add(notification.id);
+    let text = notification.message || notification.type;
+    if (notification.path) {
+        text += ` (${notification.storage_name}: ${notification.path})`;
+    }
+    addMessageToHistory(`Notifica: ${text}`, 'warning');
+    if (window.showToast) {
+        window.showToast(text, 'warning');
+    }
+}
+
+function markNotificationsRead(ids) {
+    if (ids.length > 0 && window.sendMessage) {
+        window.sendMessage({ type: 'mark_notifications_read', payload: { ids: ids } });
+    }
+}
+
 // --- Load Event ---
 window.addEventListener('load', initializeAppUI);
 
@@ -21,6 +21,7 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
     const currentPathArea = document.getElementById('current-path-area');
 
     let currentFilelistStorageName = '';
+    let currentFilelistCanWrite = true; // Da my_permissions_response dello storage corrente
     let currentFilelistDirPath = '';
     let currentFilelistPage = 1;
     let itemsPerPageFilelist = 50; 
@@ -88,6 +89,21 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
                 }
                 if(window.hideFilelistLoadingSpinner) window.hideFilelistLoadingSpinner();
                 break;
+            case 'my_permissions_response':
+                if (message.payload && message.payload.storage_name === currentFilelistStorageName) {
+                    currentFilelistCanWrite = message.payload.can_write;
+                    if (triggerUploadBtn) triggerUploadBtn.disabled = !currentFilelistCanWrite;
+                    filelistTableBody.querySelectorAll('.delete-btn').forEach(btn => { btn.disabled = !currentFilelistCanWrite; });
+                }
+                break;
+            case 'get_directory_size_response':
+                if (message.payload && message.payload.storage_name === currentFilelistStorageName) {
+                    const sizeCell = filelistTableBody.querySelector(`td[data-size-path="${CSS.escape(message.payload.dir_path)}"]`);
+                    if (sizeCell) {
+                        sizeCell.textContent = `${message.payload.file_count.toLocaleString()} file, ${formatBytesForDisplay(message.payload.total_bytes)}`;
+                    }
+                }
+                break;
             case 'create_directory_response':
                 notifyAppLogic(`Cartella \"${message.payload.name || message.payload.dir_path}\" creata.`, 'success', {filename: message.payload.name});
                 resetPaginationAndLoadFiles();
@@ -123,6 +139,11 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
     
     window.loadFilelistForPath = (storageName, dirPath) => {
         console.log(`FilelistCtrl - loadFilelistForPath called with storage: ${storageName}, path: ${dirPath}`);
+        if (storageName !== currentFilelistStorageName && window.sendMessage) {
+            // Permessi dell'utente sul nuovo storage: i pulsanti di scrittura vengono disabilitati se manca il permesso.
+            currentFilelistCanWrite = true;
+            window.sendMessage({ type: 'my_permissions', payload: { storage_name: storageName } });
+        }
         currentFilelistStorageName = storageName;
         currentFilelistDirPath = dirPath;
         updateCurrentPathDisplay();
@@ -209,12 +230,28 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
             tr.appendChild(typeTd);
 
             const sizeTd = document.createElement('td');
-            sizeTd.textContent = item.is_dir ? '' : formatBytesForDisplay(item.size);
+            if (item.is_dir) {
+                // La dimensione di una directory richiede una visita ricorsiva: la si calcola solo su richiesta.
+                sizeTd.dataset.sizePath = item.path;
+                const sizeBtn = document.createElement('button');
+                sizeBtn.textContent = 'Calcola';
+                sizeBtn.addEventListener('click', () => {
+                    sizeTd.textContent = '...';
+                    window.sendMessage({
+                        type: 'get_directory_size',
+                        payload: { storage_name: currentFilelistStorageName, dir_path: item.path }
+                    });
+                });
+                sizeTd.appendChild(sizeBtn);
+            } else {
+                sizeTd.textContent = formatBytesForDisplay(item.size);
+            }
             tr.appendChild(sizeTd);
 
             const modTimeTd = document.createElement('td');
-            const modDate = new Date(item.mod_time);
-            modTimeTd.textContent = isNaN(modDate.getTime()) ? '' : modDate.toLocaleString();
+            // mod_time è null quando lo storage non la conosce (es. directory virtuali Azure).
+            const modDate = item.mod_time ? new Date(item.mod_time) : null;
+            modTimeTd.textContent = !modDate || isNaN(modDate.getTime()) ? '' : modDate.toLocaleString();
             tr.appendChild(modTimeTd);
 
             const actionsTd = document.createElement('td');
@@ -224,10 +261,16 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
                 downloadBtn.textContent = 'Download';
                 downloadBtn.addEventListener('click', () => downloadFile(currentFilelistStorageName, item.path));
                 actionsTd.appendChild(downloadBtn);
+            } else {
+                const downloadZipBtn = document.createElement('button');
+                downloadZipBtn.textContent = 'Download ZIP';
+                downloadZipBtn.addEventListener('click', () => downloadDirectoryZip(currentFilelistStorageName, item.path));
+                actionsTd.appendChild(downloadZipBtn);
             }
             const deleteBtn = document.createElement('button');
             deleteBtn.textContent = 'Elimina';
             deleteBtn.classList.add('delete-btn'); 
+            deleteBtn.disabled = !currentFilelistCanWrite;
             deleteBtn.addEventListener('click', () => {
                 const deleteDetails = {
                     storageName: currentFilelistStorageName,
@@ -325,6 +368,12 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
         window.open(downloadUrl, '_blank');
     }
 
+    function downloadDirectoryZip(storageName, dirPath) {
+        notifyAppLogic(`Download ZIP di ${dirPath.split('/').pop()} avviato...`, 'info', {filename: dirPath.split('/').pop() + '.zip'});
+        const downloadUrl = `/download-zip?storage=${encodeURIComponent(storageName)}&path=${encodeURIComponent(dirPath)}`;
+        window.open(downloadUrl, '_blank');
+    }
+
     if(triggerUploadBtn) triggerUploadBtn.addEventListener('click', () => {
         const files = uploadFileInput.files;
         if (files.length === 0) {
@@ -374,7 +423,7 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
                 storageName: currentFilelistStorageName, filePath,
                 uploadedSize: 0, blockIDs: [], expectedFileSize: file.size,
                 isUploading: true, activeChunkUploads: 0, chunkQueue: [],
-                activeXHRs: new Set(),
+                activeXHRs: new Set(), overwrite: false, serverUploadId: null,
                 resolve: null, reject: null 
             });
             
@@ -391,16 +440,20 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
             uploadState.reject = reject;
 
             try {
+                const initiateParams = new URLSearchParams({
+                    storage: uploadState.storageName,
+                    path: uploadState.filePath,
+                    action: 'initiate',
+                    total_file_size: uploadState.expectedFileSize.toString(),
+                    chunk_size: uploadState.chunkSize.toString()
+                });
+                if (window.clientId) {
+                    initiateParams.append('client_id', window.clientId);
+                }
                 const initiateResponse = await fetch('/upload', {
                     method: 'POST',
                     headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
-                    body: new URLSearchParams({
-                        storage: uploadState.storageName,
-                        path: uploadState.filePath,
-                        action: 'initiate',
-                        total_file_size: uploadState.expectedFileSize.toString(),
-                        chunk_size: uploadState.chunkSize.toString()
-                    })
+                    body: initiateParams
                 });
 
                 if (!initiateResponse.ok) {
@@ -408,6 +461,9 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
                     throw new Error(`Errore inizializzazione upload: ${initiateResponse.status} - ${errorText}`);
                 }
                 const data = await initiateResponse.json();
+                // upload_id identifica la sessione sul server: chunk, finalize e cancel lo inviano insieme al path.
+                uploadState.serverUploadId = data.upload_id || null;
+                if (data.path) uploadState.filePath = data.path;
                 uploadState.uploadedSize = data.uploaded_size || 0;
                 
                 const initialPercentage = uploadState.expectedFileSize > 0 ? (uploadState.uploadedSize / uploadState.expectedFileSize) * 100 : 0;
@@ -451,6 +507,30 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
         });
     }
 
+    // Tabella del CRC32C (Castagnoli), inviato come chunk_crc32c: il server rifiuta un chunk corrotto e si
+    // reinvia solo quello invece di far fallire l'intero file al finalize.
+    const CRC32C_TABLE = (() => {
+        const table = new Uint32Array(256);
+        for (let n = 0; n < 256; n++) {
+            let c = n;
+            for (let k = 0; k < 8; k++) {
+                c = c & 1 ? 0x82F63B78 ^ (c >>> 1) : c >>> 1;
+            }
+            table[n] = c >>> 0;
+        }
+        return table;
+    })();
+    const MAX_CHUNK_CHECKSUM_RETRIES = 3;
+
+    async function crc32cHex(blob) {
+        const bytes = new Uint8Array(await blob.arrayBuffer());
+        let crc = 0xFFFFFFFF;
+        for (let i = 0; i < bytes.length; i++) {
+            crc = CRC32C_TABLE[(crc ^ bytes[i]) & 0xFF] ^ (crc >>> 8);
+        }
+        return ((crc ^ 0xFFFFFFFF) >>> 0).toString(16).padStart(8, '0');
+    }
+
     async function processNextChunkInternal(uploadId) {
         const uploadState = ongoingUploadsMap.get(uploadId);
         if (!uploadState || !uploadState.isUploading) return;
@@ -469,22 +549,31 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
             return;
         }
 
-        const { chunk, blockID, index: chunkIndex } = uploadState.chunkQueue.shift();
+        const queuedChunk = uploadState.chunkQueue.shift();
+        const { chunk, blockID, index: chunkIndex } = queuedChunk;
         uploadState.activeChunkUploads++;
+        const chunkCRC32C = await crc32cHex(chunk);
 
         const formData = new FormData();
         formData.append('storage', uploadState.storageName);
         formData.append('path', uploadState.filePath);
         formData.append('action', 'chunk');
+        if (uploadState.serverUploadId) formData.append('upload_id', uploadState.serverUploadId);
         formData.append('block_id', blockID); 
         formData.append('chunk_index', chunkIndex.toString()); 
         formData.append('chunk_size', uploadState.chunkSize.toString()); 
+        formData.append('chunk_crc32c', chunkCRC32C);
         formData.append('chunk', chunk);
 
         const xhr = new XMLHttpRequest();
         uploadState.activeXHRs.add(xhr);
         
-        xhr.open('POST', '/upload', true);
+        // storage e upload_id anche nella query string: il server conta i byte del chunk mentre arrivano,
+        // così un chunk lento non fa considerare l'upload inattivo.
+        const chunkURL = uploadState.serverUploadId
+            ? '/upload?' + new URLSearchParams({ storage: uploadState.storageName, upload_id: uploadState.serverUploadId })
+            : '/upload';
+        xhr.open('POST', chunkURL, true);
         xhr.timeout = 300000; 
 
         if (xhr.upload) {
@@ -505,6 +594,11 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
                     const percentage = uploadState.expectedFileSize > 0 ? (uploadState.uploadedSize / uploadState.expectedFileSize) * 100 : 100;
                     if(window.updateGlobalUploadProgress) window.updateGlobalUploadProgress(uploadId, uploadState.file.name, percentage, `Chunk ${chunkIndex + 1} caricato.`, uploadState.filePath);
                 }
+            } else if (xhr.status === 400 && (xhr.responseText || '').startsWith('CHUNK_CHECKSUM_MISMATCH') && (queuedChunk.retries || 0) < MAX_CHUNK_CHECKSUM_RETRIES) {
+                // Il chunk è arrivato corrotto: torna in coda e viene reinviato da solo.
+                console.warn(`FilelistCtrl - Chunk ${chunkIndex} di ${uploadState.file.name} corrotto in transito, nuovo invio.`);
+                queuedChunk.retries = (queuedChunk.retries || 0) + 1;
+                uploadState.chunkQueue.unshift(queuedChunk);
             } else {
                 const errorText = xhr.responseText || `Errore HTTP: ${xhr.status}`;
                 console.error(`FilelistCtrl - Errore caricamento chunk ${chunkIndex} per ${uploadState.file.name}: ${errorText}`);
@@ -563,18 +657,36 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
             console.debug(`FilelistCtrl - Block IDs ordinati per ${uploadState.file.name}:`, JSON.stringify(uploadState.blockIDs)); 
         }
         try {
-            const finalizeResponse = await fetch('/upload', {
-                method: 'POST',
-                headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
-                body: new URLSearchParams({
+            const sendFinalize = () => {
+                const finalizeParams = new URLSearchParams({
                     storage: uploadState.storageName,
                     path: uploadState.filePath,
                     action: 'finalize',
                     block_ids: JSON.stringify(uploadState.blockIDs), 
                     client_sha256: uploadState.clientSHA256,
                     total_file_size: uploadState.expectedFileSize.toString()
-                })
-            });
+                });
+                if (uploadState.serverUploadId) finalizeParams.append('upload_id', uploadState.serverUploadId);
+                if (uploadState.overwrite) finalizeParams.append('overwrite', 'true');
+                return fetch('/upload', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+                    body: finalizeParams
+                });
+            };
+            let finalizeResponse = await sendFinalize();
+
+            // Il server non sovrascrive un file esistente senza overwrite=true: il conflitto viene rilevato alla
+            // pubblicazione e la sessione resta valida, quindi dopo la conferma basta ripetere il finalize.
+            if (finalizeResponse.status === 409 && !uploadState.overwrite) {
+                const conflictText = await finalizeResponse.text();
+                if (!conflictText.startsWith('ALREADY_EXISTS') || !window.confirm(`Il file "${uploadState.filePath}" esiste già. Sovrascriverlo?`)) {
+                    cancelServerUpload(uploadState);
+                    throw new Error(`Errore finalizzazione: 409 - ${conflictText}`);
+                }
+                uploadState.overwrite = true;
+                finalizeResponse = await sendFinalize();
+            }
             if (!finalizeResponse.ok) {
                 const errorText = await finalizeResponse.text();
                 throw new Error(`Errore finalizzazione: ${finalizeResponse.status} - ${errorText}`);
@@ -593,6 +705,21 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
         }
     }
 
+    // cancelServerUpload releases the server session of an upload (file temporaneo, blocchi in staging).
+    function cancelServerUpload(uploadState) {
+        const cancelParams = new URLSearchParams({
+            storage: uploadState.storageName,
+            path: uploadState.filePath,
+            action: 'cancel'
+        });
+        if (uploadState.serverUploadId) cancelParams.append('upload_id', uploadState.serverUploadId);
+        return fetch('/upload', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+            body: cancelParams
+        });
+    }
+
     window.cancelUploadFile = async (uploadId) => {
         const uploadState = ongoingUploadsMap.get(uploadId);
         if (!uploadState) { 
@@ -622,15 +749,7 @@ console.log('--- EXECUTING filelist_controller.js ---'); // NUOVO LOG INIZIALE
         if(window.updateGlobalUploadProgress) window.updateGlobalUploadProgress(uploadId, uploadState.file.name, percentage, 'Annullamento...', uploadState.filePath);
 
         try {
-            await fetch('/upload', {
-                method: 'POST',
-                headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
-                body: new URLSearchParams({
-                    storage: uploadState.storageName,
-                    path: uploadState.filePath,
-                    action: 'cancel'
-                })
-            });
+            await cancelServerUpload(uploadState);
             notifyAppLogic(`Upload di \"${uploadState.file.name}\" annullato.`, 'warning', {filename: uploadState.file.name});
             if(window.updateGlobalUploadProgress) window.updateGlobalUploadProgress(uploadId, uploadState.file.name, percentage, 'Annullato.', uploadState.filePath, true, 'cancelled');
         } catch (error) {
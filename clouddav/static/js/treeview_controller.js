@@ -234,7 +234,8 @@
                                           // questo potrebbe essere rivisto.
                         name_filter: '',       // Solitamente non si filtra per nome nel treeview
                         timestamp_filter: '',  // Solitamente non si filtra per data nel treeview
-                        only_directories: true // << MODIFICA: Richiedi solo directory
+                        only_directories: true, // << MODIFICA: Richiedi solo directory
+                        fields: ['name', 'is_dir', 'path'] // Solo i campi usati dal treeview
                     }
                 });
                 lastRequestIds.set(pathKey, requestID);
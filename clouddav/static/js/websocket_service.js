@@ -7,6 +7,8 @@
     let messageQueue = [];
     let isProcessingQueue = false;
     let longPollingIntervalId = null;
+    let longPollingIntervalMs = 5000; // Default, updated by next_poll_after_ms in the LP responses
+    let notificationCursor = 0; // Last notification cursor seen in the LP responses
     const longPollingUrl = '/lp'; // Endpoint for Long Polling
 
     const websocketProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
@@ -83,7 +85,7 @@
                     const message = messageQueue.shift();
                     sendLongPollingMessageInternal(message);
                 }
-            }, 5000); // Poll interval
+            }, longPollingIntervalMs); // Poll interval suggested by the server
             startClientPingInterval(); // Also ping during LP
         }
     }
@@ -126,6 +128,7 @@
             return response.json();
         })
         .then(data => {
+            applyLongPollingHints(data);
             if (window.handleBackendMessage) {
                 window.handleBackendMessage(data);
             }
@@ -139,6 +142,27 @@
         });
     }
 
+    // Applies the polling cadence suggested by the server and fetches the notifications arrived
+    // since the last cursor seen (LP clients don't receive pushed notifications).
+    function applyLongPollingHints(data) {
+        if (typeof data.next_poll_after_ms === 'number' && data.next_poll_after_ms > 0 && data.next_poll_after_ms !== longPollingIntervalMs) {
+            console.log(`Server suggested long polling interval: ${data.next_poll_after_ms}ms`);
+            longPollingIntervalMs = data.next_poll_after_ms;
+            if (longPollingIntervalId !== null) {
+                clearInterval(longPollingIntervalId);
+                longPollingIntervalId = null;
+                startLongPollingInternal();
+            }
+        }
+        if (typeof data.cursor === 'number' && data.cursor > notificationCursor) {
+            const since = notificationCursor;
+            notificationCursor = data.cursor;
+            if (data.type !== 'get_notifications_response') {
+                sendMessageInternal({ type: 'get_notifications', payload: { since: since } });
+            }
+        }
+    }
+
     function processMessageQueueInternal() {
         if (isWebSocketActive && ws && ws.readyState === WebSocket.OPEN && messageQueue.length > 0 && !isProcessingQueue) {
             isProcessingQueue = true;
@@ -213,6 +237,13 @@
                 startClientPingInterval(); // Restart with new interval
             }
         }
+        if (message.payload && typeof message.payload.client_id === 'string') {
+            // Inviato all'initiate degli upload: il server li annulla quando questo client si disconnette.
+            window.clientId = message.payload.client_id;
+        }
+        if (message.payload && typeof message.payload.next_poll_after_ms === 'number' && message.payload.next_poll_after_ms > 0) {
+            longPollingIntervalMs = message.payload.next_poll_after_ms;
+        }
     };
     console.log('websocket_service.js loaded');
 })();
@@ -2,52 +2,78 @@ package azureblob
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"sort" // Assicurati che questo import sia presente
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"clouddav/auth"
 	"clouddav/config"
+	"clouddav/internal/logging"
 	"clouddav/storage"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 )
 
 // AzureBlobStorageProvider implements the StorageProvider interface for Azure Blob Storage.
 type AzureBlobStorageProvider struct {
-	name            string
-	containerName   string
-	containerClient *container.Client
+	name             string
+	logger           *logging.StorageLogger
+	maxUploadBytes   int64 // Dimensione massima di un upload (0 = nessun limite)
+	containerName    string
+	containerClient  *container.Client
+	storeChecksums   bool                           // Salva lo SHA256 verificato nei metadata del blob
+	recordUploader   bool                           // Salva chi ha caricato il blob e quando nei metadata
+	blockSize        int64                          // Dimensione dei range per i download a blocchi
+	strictUploadSize bool                           // Verifica la dimensione del blob committato rispetto a quella dichiarata
+	uploadHashes     *uploadHashes                  // SHA256 incrementali degli upload in corso (nil se incremental_upload_hash è disattivo)
+	uploads          map[string]*azureUploadSession // Upload in corso, per upload ID
+	uploadsMu        sync.Mutex
+	directoryMarkers string // config.DirectoryMarkers*: marker creati a destinazione da copy/move di una directory
+	directoryModTime bool   // Ricava la data di modifica delle directory virtuali dai blob sotto il prefisso
 }
 
+// defaultDownloadBlockSize is the range size used for block-aligned downloads when not configured.
+const defaultDownloadBlockSize = 4 << 20
+
 // NewProvider creates a new AzureBlobStorageProvider.
-func NewProvider(cfg *config.StorageConfig) (*AzureBlobStorageProvider, error) {
+// Le credenziali Azure vengono acquisite in modo lazy alla prima richiesta: per questo, dopo aver creato
+// il client, si esegue una richiesta di prova legata a ctx, così una credenziale o un endpoint bloccati
+// fanno fallire l'avvio entro il timeout invece di bloccarlo indefinitamente.
+func NewProvider(ctx context.Context, cfg *config.StorageConfig) (*AzureBlobStorageProvider, error) {
 	if cfg.Type != "azure-blob" {
 		return nil, errors.New("invalid storage config type for azure-blob provider")
 	}
 	if cfg.ContainerName == "" {
 		return nil, errors.New("azure-blob storage container_name is required")
 	}
-	if cfg.AccountName == "" && cfg.ConnectionString == "" {
-		return nil, errors.New("azure-blob storage requires either account_name (for AAD) or connection_string")
+	if cfg.AccountName == "" && cfg.ConnectionString == "" && cfg.SASURL == "" {
+		return nil, errors.New("azure-blob storage requires either account_name (for AAD or sas_token), connection_string or sas_url")
+	}
+	sasURL, err := cfg.SASContainerURL()
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAS configuration for azure-blob storage '%s': %w", cfg.Name, err)
 	}
 
 	var cred azcore.TokenCredential
 	var containerClient *container.Client
-	var err error
 
 	if cfg.ConnectionString != "" {
 		if config.IsLogLevel(config.LogLevelInfo) {
@@ -57,6 +83,15 @@ func NewProvider(cfg *config.StorageConfig) (*AzureBlobStorageProvider, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Azure Blob container client from connection string: %w", err)
 		}
+	} else if sasURL != "" {
+		// Il SAS porta già l'autorizzazione nella query string: niente credential.
+		if config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Azure Blob: Connecting to '%s' container '%s' using SAS (configured)...", cfg.Name, cfg.ContainerName)
+		}
+		containerClient, err = container.NewClientWithNoCredential(sasURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure Blob container client from SAS for storage '%s': %w", cfg.Name, err)
+		}
 	} else {
 		if config.IsLogLevel(config.LogLevelInfo) {
 			log.Printf("Using AAD Authentication")
@@ -81,17 +116,60 @@ func NewProvider(cfg *config.StorageConfig) (*AzureBlobStorageProvider, error) {
 		}
 	}
 
+	if err := probeContainer(ctx, containerClient); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("timed out initializing Azure Blob storage '%s' (credential acquisition or endpoint unreachable): %w", cfg.Name, err)
+		}
+		return nil, fmt.Errorf("failed to access container '%s' of Azure Blob storage '%s': %w", cfg.ContainerName, cfg.Name, err)
+	}
+
+	blockSize := int64(defaultDownloadBlockSize)
+	if cfg.DownloadBlockSizeMB > 0 {
+		blockSize = int64(cfg.DownloadBlockSizeMB) << 20
+	}
+
+	var uploadHashes *uploadHashes
+	if cfg.IncrementalUploadHash {
+		uploadHashes = newUploadHashes()
+	}
+
+	directoryMarkers := cfg.DirectoryMarkers
+	if directoryMarkers == "" {
+		directoryMarkers = config.DirectoryMarkersPreserve
+	}
+
 	if config.IsLogLevel(config.LogLevelInfo) {
 		log.Printf("Azure Blob: Provider '%s' initialized for container '%s'.", cfg.Name, cfg.ContainerName)
 	}
 
 	return &AzureBlobStorageProvider{
-		name:            cfg.Name,
-		containerName:   cfg.ContainerName,
-		containerClient: containerClient,
+		name:             cfg.Name,
+		logger:           logging.NewStorageLogger(cfg.Name),
+		maxUploadBytes:   cfg.MaxUploadBytes,
+		containerName:    cfg.ContainerName,
+		containerClient:  containerClient,
+		storeChecksums:   cfg.StoreChecksums,
+		recordUploader:   cfg.RecordUploader,
+		blockSize:        blockSize,
+		strictUploadSize: cfg.StrictUploadSize,
+		uploadHashes:     uploadHashes,
+		uploads:          make(map[string]*azureUploadSession),
+		directoryMarkers: directoryMarkers,
+		directoryModTime: cfg.DirectoryModTime,
 	}, nil
 }
 
+// probeContainer lists at most one blob of the container, forcing credential acquisition and a round trip
+// to the endpoint. Si usa il listing (e non le proprietà del container) perché richiede gli stessi permessi
+// delle normali operazioni di lettura.
+func probeContainer(ctx context.Context, containerClient *container.Client) error {
+	pager := containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		MaxResults: to.Ptr(int32(1)),
+	})
+	_, err := pager.NextPage(ctx)
+	return err
+}
+
 // Type returns the storage type.
 func (p *AzureBlobStorageProvider) Type() string {
 	return "azure-blob"
@@ -103,14 +181,14 @@ func (p *AzureBlobStorageProvider) Name() string {
 }
 
 // ListItems lists blobs and virtual directories in a given path (prefix).
-func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.UserClaims, path string, page int, itemsPerPage int, nameFilter string, timestampFilter *time.Time, onlyDirectories bool) (*storage.ListItemsResponse, error) {
+func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.UserClaims, path string, page int, itemsPerPage int, nameFilter string, modTimeRange *storage.ModTimeRange, onlyDirectories bool, onlyFiles bool) (*storage.ListItemsResponse, error) {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
 	if config.IsLogLevel(config.LogLevelInfo) {
 		// CORREZIONE: Rimosso \ prima di " finale
-		log.Printf("AzureBlobStorageProvider.ListItems chiamato da utente '%s' per storage '%s', path '%s', page %d, itemsPerPage %d, nameFilter '%s', onlyDirectories: %t", userIdent, p.name, path, page, itemsPerPage, nameFilter, onlyDirectories)
+		log.Printf("AzureBlobStorageProvider.ListItems chiamato da utente '%s' per storage '%s', path '%s', page %d, itemsPerPage %d, nameFilter '%s', onlyDirectories: %t, onlyFiles: %t", userIdent, p.name, path, page, itemsPerPage, nameFilter, onlyDirectories, onlyFiles)
 	}
 
 	prefix := strings.TrimPrefix(path, "/")
@@ -118,7 +196,7 @@ func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.U
 		prefix += "/"
 	}
 
-	if config.IsLogLevel(config.LogLevelDebug) {
+	if p.logger.IsDebug() {
 		// CORREZIONE: Rimosso \ prima di " finale
 		log.Printf("Azure Blob: Listing items in container '%s' with prefix '%s' for storage '%s'", p.containerName, prefix, p.name)
 	}
@@ -143,9 +221,7 @@ func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.U
 		if err != nil {
 			select {
 			case <-ctx.Done():
-				if config.IsLogLevel(config.LogLevelDebug) {
-					log.Printf("Context cancelled during Azure Blob listing: %v", ctx.Err())
-				}
+				p.logger.Debugf("Context cancelled during Azure Blob listing: %v", ctx.Err())
 				return nil, ctx.Err()
 			default:
 			}
@@ -153,7 +229,7 @@ func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.U
 		}
 
 		if pageResponse.Segment != nil {
-			if pageResponse.Segment.BlobPrefixes != nil {
+			if pageResponse.Segment.BlobPrefixes != nil && !onlyFiles {
 				for _, bp := range pageResponse.Segment.BlobPrefixes {
 					name := strings.TrimPrefix(*bp.Name, prefix)
 					name = strings.TrimSuffix(name, "/")
@@ -161,11 +237,10 @@ func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.U
 						continue
 					}
 					itemInfo := storage.ItemInfo{
-						Name:    name,
-						IsDir:   true,
-						Size:    0,
-						ModTime: time.Time{},
-						Path:    strings.TrimSuffix(*bp.Name, "/"),
+						Name:  name,
+						IsDir: true,
+						Size:  0,
+						Path:  strings.TrimSuffix(*bp.Name, "/"),
 					}
 					if nameFilter != "" {
 						matched, _ := regexp.MatchString(nameFilter, itemInfo.Name)
@@ -198,10 +273,8 @@ func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.U
 								continue
 							}
 						}
-						if timestampFilter != nil {
-							if !itemInfo.ModTime.After(*timestampFilter) {
-								continue
-							}
+						if !modTimeRange.Contains(itemInfo.ModTime) {
+							continue
 						}
 						allFilteredItems = append(allFilteredItems, itemInfo)
 					}
@@ -236,7 +309,8 @@ func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.U
 	}
 
 	paginatedItems := allFilteredItems[startIndex:endIndex]
-	if config.IsLogLevel(config.LogLevelDebug) {
+	p.setDirectoryModTimes(ctx, paginatedItems)
+	if p.logger.IsDebug() {
 		// CORREZIONE: Rimosso \ prima di " finale
 		log.Printf("Azure Blob: Returning %d items for page %d (total filtered: %d, onlyDirs: %t)", len(paginatedItems), page, totalItems, onlyDirectories)
 	}
@@ -249,6 +323,71 @@ func (p *AzureBlobStorageProvider) ListItems(ctx context.Context, claims *auth.U
 	}, nil
 }
 
+// CountItems counts the blobs and virtual directories directly under path by walking the hierarchy
+// listing to the end (pagine da 5000, il massimo del servizio), senza conservare gli elementi: a differenza
+// di ListItems, che si ferma alla pagina richiesta, il conteggio non dipende dalla paginazione.
+func (p *AzureBlobStorageProvider) CountItems(ctx context.Context, claims *auth.UserClaims, path string, nameFilter string, maxCount int) (int, bool, error) {
+	if config.IsLogLevel(config.LogLevelInfo) {
+		userIdent := "unauthenticated"
+		if claims != nil {
+			userIdent = claims.Email
+		}
+		log.Printf("AzureBlobStorageProvider.CountItems chiamato da utente '%s' per storage '%s', path '%s', nameFilter '%s'", userIdent, p.name, path, nameFilter)
+	}
+
+	var nameRegexp *regexp.Regexp
+	if nameFilter != "" {
+		var err error
+		if nameRegexp, err = regexp.Compile(nameFilter); err != nil {
+			return 0, false, fmt.Errorf("invalid name filter: %w", err)
+		}
+	}
+	prefix := strings.TrimPrefix(path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	pager := p.containerClient.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+		Prefix:     to.Ptr(prefix),
+		MaxResults: to.Ptr(int32(5000)),
+	})
+	count := 0
+	// add counts a name of the listing; false quando il limite è superato.
+	add := func(name string) bool {
+		if name == "" || strings.Contains(name, "/") || (nameRegexp != nil && !nameRegexp.MatchString(name)) {
+			return true
+		}
+		if count == maxCount {
+			return false
+		}
+		count++
+		return true
+	}
+	for pager.More() {
+		pageResponse, err := pager.NextPage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, false, ctx.Err()
+			}
+			return 0, false, fmt.Errorf("failed to list blobs for prefix '%s': %w", prefix, err)
+		}
+		if pageResponse.Segment == nil {
+			continue
+		}
+		for _, blobPrefix := range pageResponse.Segment.BlobPrefixes {
+			if !add(strings.TrimSuffix(strings.TrimPrefix(*blobPrefix.Name, prefix), "/")) {
+				return count, false, nil
+			}
+		}
+		for _, blobItem := range pageResponse.Segment.BlobItems {
+			if !add(strings.TrimPrefix(*blobItem.Name, prefix)) {
+				return count, false, nil
+			}
+		}
+	}
+	return count, true, nil
+}
+
 // GetItem retrieves information about a single blob.
 func (p *AzureBlobStorageProvider) GetItem(ctx context.Context, claims *auth.UserClaims, path string) (*storage.ItemInfo, error) {
 	userIdent := "unauthenticated"
@@ -280,13 +419,14 @@ func (p *AzureBlobStorageProvider) GetItem(ctx context.Context, claims *auth.Use
 			pageResponse, listErr := pager.NextPage(ctx)
 			if listErr == nil && pageResponse.Segment != nil &&
 				(len(pageResponse.Segment.BlobPrefixes) > 0 || len(pageResponse.Segment.BlobItems) > 0) {
-				return &storage.ItemInfo{
-					Name:    filepath.Base(path),
-					IsDir:   true,
-					Size:    0,
-					ModTime: time.Time{},
-					Path:    path,
-				}, nil
+				dirInfo := []storage.ItemInfo{{
+					Name:  filepath.Base(path),
+					IsDir: true,
+					Size:  0,
+					Path:  path,
+				}}
+				p.setDirectoryModTimes(ctx, dirInfo)
+				return &dirInfo[0], nil
 			}
 			return nil, storage.ErrNotFound
 		}
@@ -300,6 +440,15 @@ func (p *AzureBlobStorageProvider) GetItem(ctx context.Context, claims *auth.Use
 		ModTime: *props.LastModified,
 		Path:    path,
 	}
+	if props.ETag != nil {
+		itemInfo.ETag = string(*props.ETag)
+	}
+	if p.storeChecksums {
+		itemInfo.SHA256 = storedChecksum(props.Metadata, itemInfo.Size)
+	}
+	if p.recordUploader {
+		itemInfo.UploadedBy, itemInfo.UploadedAt = storedUploader(props.Metadata)
+	}
 
 	return itemInfo, nil
 }
@@ -322,7 +471,7 @@ func (p *AzureBlobStorageProvider) OpenReader(ctx context.Context, claims *auth.
 		return nil, err
 	}
 	if itemInfo.IsDir {
-		return nil, errors.New("cannot open a directory for reading")
+		return nil, storage.ErrIsDirectory
 	}
 
 	blobClient := p.containerClient.NewBlobClient(blobPath)
@@ -338,15 +487,97 @@ func (p *AzureBlobStorageProvider) OpenReader(ctx context.Context, claims *auth.
 	return downloadResponse.Body, nil
 }
 
+// blobReaderAt reads ranges of a blob with one DownloadStream request per ReadAt call. Ogni range è
+// condizionato all'ETag letto all'apertura (If-Match): se il blob viene sovrascritto durante la lettura,
+// ReadAt restituisce storage.ErrPreconditionFailed invece di mescolare byte di due versioni.
+type blobReaderAt struct {
+	ctx        context.Context
+	blobClient *blob.Client
+	blobPath   string
+	size       int64
+	etag       string
+}
+
+func (r *blobReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	count := int64(len(buf))
+	if off+count > r.size {
+		count = r.size - off
+	}
+	options := &blob.DownloadStreamOptions{Range: blob.HTTPRange{Offset: off, Count: count}}
+	if r.etag != "" {
+		options.AccessConditions = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: to.Ptr(azcore.ETag(r.etag))}}
+	}
+	resp, err := r.blobClient.DownloadStream(r.ctx, options)
+	if err != nil {
+		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) && storageErr.StatusCode == 412 {
+			return 0, fmt.Errorf("%w: blob '%s' was modified while reading it (ETag %s)", storage.ErrPreconditionFailed, r.blobPath, r.etag)
+		}
+		return 0, fmt.Errorf("failed to download range %d-%d of blob '%s': %w", off, off+count-1, r.blobPath, err)
+	}
+	defer resp.Body.Close()
+
+	n, err := io.ReadFull(resp.Body, buf[:count])
+	if err != nil {
+		return n, fmt.Errorf("failed to read range %d-%d of blob '%s': %w", off, off+count-1, r.blobPath, err)
+	}
+	if count < int64(len(buf)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *blobReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *blobReaderAt) Close() error {
+	return nil
+}
+
+// OpenReaderAt opens a blob for random access reads. Ogni ReadAt è una richiesta di range separata,
+// quindi i chiamanti dovrebbero leggere blocchi ampi (vedi Capabilities().BlockSize).
+func (p *AzureBlobStorageProvider) OpenReaderAt(ctx context.Context, claims *auth.UserClaims, path string) (storage.ReaderAtCloser, error) {
+	itemInfo, err := p.GetItem(ctx, claims, path)
+	if err != nil {
+		return nil, err
+	}
+	if itemInfo.IsDir {
+		return nil, storage.ErrIsDirectory
+	}
+	blobPath := strings.TrimPrefix(path, "/")
+	return &blobReaderAt{
+		ctx:        ctx,
+		blobClient: p.containerClient.NewBlobClient(blobPath),
+		blobPath:   blobPath,
+		size:       itemInfo.Size,
+		etag:       itemInfo.ETag,
+	}, nil
+}
+
+// Capabilities reports random access support and the block size used for ranged downloads.
+func (p *AzureBlobStorageProvider) Capabilities() storage.Capabilities {
+	return storage.Capabilities{RandomAccess: true, BlockSize: p.blockSize}
+}
+
+// HealthCheck lists at most one blob of the container, like the probe of NewProvider.
+func (p *AzureBlobStorageProvider) HealthCheck(ctx context.Context) error {
+	if err := probeContainer(ctx, p.containerClient); err != nil {
+		return fmt.Errorf("failed to access container '%s': %w", p.containerName, err)
+	}
+	return nil
+}
+
 // CreateDirectory simulates creating a virtual directory (a zero-byte blob ending with '/').
 func (p *AzureBlobStorageProvider) CreateDirectory(ctx context.Context, claims *auth.UserClaims, path string) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("AzureBlobStorageProvider.CreateDirectory chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
-	}
+	p.logger.Infof("AzureBlobStorageProvider.CreateDirectory chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
 
 	dirBlobPath := strings.TrimPrefix(path, "/")
 	if !strings.HasSuffix(dirBlobPath, "/") {
@@ -361,21 +592,7 @@ func (p *AzureBlobStorageProvider) CreateDirectory(ctx context.Context, claims *
 		return fmt.Errorf("failed to check for existing virtual directory '%s': %w", dirBlobPath, err)
 	}
 
-	dirMarkerBlobClient := p.containerClient.NewBlockBlobClient(dirBlobPath)
-	uploadResp, err := dirMarkerBlobClient.UploadBuffer(ctx, []byte{}, nil)
-	if err != nil {
-		var storageErr *azcore.ResponseError
-		if errors.As(err, &storageErr) && storageErr.StatusCode == 403 {
-			return storage.ErrPermissionDenied
-		}
-		return fmt.Errorf("failed to create virtual directory blob '%s': %w", dirBlobPath, err)
-	}
-
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("Azure Blob: Created virtual directory marker blob: %s", *uploadResp.ETag)
-	}
-
-	return nil
+	return p.uploadDirectoryMarker(ctx, dirBlobPath)
 }
 
 // DeleteItem deletes a blob or all blobs under a prefix (for virtual directories).
@@ -384,9 +601,7 @@ func (p *AzureBlobStorageProvider) DeleteItem(ctx context.Context, claims *auth.
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("AzureBlobStorageProvider.DeleteItem chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
-	}
+	p.logger.Infof("AzureBlobStorageProvider.DeleteItem chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
 
 	blobPath := strings.TrimPrefix(path, "/")
 
@@ -399,9 +614,7 @@ func (p *AzureBlobStorageProvider) DeleteItem(ctx context.Context, claims *auth.
 	}
 
 	if !itemInfo.IsDir {
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Azure Blob: Deleting blob '%s' in container '%s'", blobPath, p.containerName)
-		}
+		p.logger.Infof("Azure Blob: Deleting blob '%s' in container '%s'", blobPath, p.containerName)
 		blobClient := p.containerClient.NewBlobClient(blobPath)
 		_, deleteErr := blobClient.Delete(ctx, nil)
 		if deleteErr != nil {
@@ -411,9 +624,7 @@ func (p *AzureBlobStorageProvider) DeleteItem(ctx context.Context, claims *auth.
 			}
 			return fmt.Errorf("failed to delete blob '%s': %w", blobPath, deleteErr)
 		}
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Azure Blob: Deleted blob '%s'", blobPath)
-		}
+		p.logger.Infof("Azure Blob: Deleted blob '%s'", blobPath)
 		return nil
 	}
 
@@ -421,9 +632,7 @@ func (p *AzureBlobStorageProvider) DeleteItem(ctx context.Context, claims *auth.
 	if prefix != "" && !strings.HasSuffix(prefix, "/") {
 		prefix += "/"
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("Azure Blob: Deleting virtual directory (blobs with prefix) '%s' in container '%s'", prefix, p.containerName)
-	}
+	p.logger.Infof("Azure Blob: Deleting virtual directory (blobs with prefix) '%s' in container '%s'", prefix, p.containerName)
 
 	pager := p.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
 		Prefix: to.Ptr(prefix),
@@ -435,9 +644,7 @@ func (p *AzureBlobStorageProvider) DeleteItem(ctx context.Context, claims *auth.
 		if listErr != nil {
 			select {
 			case <-ctx.Done():
-				if config.IsLogLevel(config.LogLevelDebug) {
-					log.Printf("Context cancelled during Azure Blob delete listing: %v", ctx.Err())
-				}
+				p.logger.Debugf("Context cancelled during Azure Blob delete listing: %v", ctx.Err())
 				return ctx.Err()
 			default:
 			}
@@ -480,15 +687,19 @@ func (p *AzureBlobStorageProvider) DeleteItem(ctx context.Context, claims *auth.
 	for _, blobNameToDelete := range blobsToDelete {
 		select {
 		case <-ctx.Done():
-			if config.IsLogLevel(config.LogLevelDebug) {
-				log.Printf("Context cancelled during Azure Blob deletion of '%s': %v", blobNameToDelete, ctx.Err())
-			}
+			p.logger.Debugf("Context cancelled during Azure Blob deletion of '%s': %v", blobNameToDelete, ctx.Err())
 			return ctx.Err()
 		case sem <- struct{}{}:
+			// Oltre al limite della singola richiesta, ogni worker occupa uno slot del budget globale.
+			if err := storage.DeleteWorkers.Acquire(ctx); err != nil {
+				<-sem
+				return err
+			}
 			wg.Add(1)
 			go func(name string) {
 				defer wg.Done()
 				defer func() { <-sem }()
+				defer storage.DeleteWorkers.Release()
 
 				blobClientToDelete := p.containerClient.NewBlobClient(name)
 				_, deleteErr := blobClientToDelete.Delete(ctx, nil)
@@ -497,16 +708,12 @@ func (p *AzureBlobStorageProvider) DeleteItem(ctx context.Context, claims *auth.
 					if errors.As(deleteErr, &deleteStorageErr) && deleteStorageErr.StatusCode == 403 {
 						errChan <- storage.ErrPermissionDenied
 					} else if errors.As(deleteErr, &deleteStorageErr) && deleteStorageErr.StatusCode == 404 {
-						if config.IsLogLevel(config.LogLevelDebug) {
-							log.Printf("Azure Blob: Blob '%s' not found during deletion, already deleted?", name)
-						}
+						p.logger.Debugf("Azure Blob: Blob '%s' not found during deletion, already deleted?", name)
 					} else {
 						errChan <- fmt.Errorf("failed to delete blob '%s': %w", name, deleteErr)
 					}
 				} else {
-					if config.IsLogLevel(config.LogLevelDebug) {
-						log.Printf("Azure Blob: Deleted blob '%s'", name)
-					}
+					p.logger.Debugf("Azure Blob: Deleted blob '%s'", name)
 				}
 			}(blobNameToDelete)
 		}
@@ -521,57 +728,553 @@ func (p *AzureBlobStorageProvider) DeleteItem(ctx context.Context, claims *auth.
 		}
 	}
 
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("Azure Blob: Virtual directory deletion complete for prefix '%s'", prefix)
+	p.logger.Infof("Azure Blob: Virtual directory deletion complete for prefix '%s'", prefix)
+	return nil
+}
+
+// GetUsedBytes returns the total size of the blobs in the container.
+func (p *AzureBlobStorageProvider) GetUsedBytes(ctx context.Context, claims *auth.UserClaims) (int64, error) {
+	var used int64
+	pager := p.containerClient.NewListBlobsFlatPager(nil)
+	for pager.More() {
+		pageResponse, err := pager.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list blobs of container '%s' to compute used space: %w", p.containerName, err)
+		}
+		if pageResponse.Segment == nil {
+			continue
+		}
+		for _, blobItem := range pageResponse.Segment.BlobItems {
+			if blobItem.Properties != nil && blobItem.Properties.ContentLength != nil {
+				used += *blobItem.Properties.ContentLength
+			}
+		}
+	}
+	return used, nil
+}
+
+// CanWrite probes the write permission of the credentials with Set Blob Metadata on a blob that does not
+// exist: Azure verifica l'autorizzazione prima dell'esistenza del blob, quindi 404 indica che la scrittura
+// sarebbe consentita e 403 che non lo è, senza creare né modificare nulla.
+func (p *AzureBlobStorageProvider) CanWrite(ctx context.Context, claims *auth.UserClaims, path string) (bool, error) {
+	probeID := make([]byte, 16)
+	if _, err := rand.Read(probeID); err != nil {
+		return false, err
+	}
+	probeName := strings.TrimPrefix(filepath.ToSlash(filepath.Join(path, ".clouddav-write-probe-"+hex.EncodeToString(probeID))), "/")
+	_, err := p.containerClient.NewBlobClient(probeName).SetMetadata(ctx, map[string]*string{"probe": to.Ptr("1")}, nil)
+	if err == nil {
+		return true, nil
+	}
+	var storageErr *azcore.ResponseError
+	if errors.As(err, &storageErr) {
+		switch storageErr.StatusCode {
+		case 404:
+			return true, nil
+		case 403:
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("failed to probe write access to container '%s': %w", p.containerName, err)
+}
+
+// GetDirectorySize sums the ContentLength of the blobs under the prefix of path with a flat listing. I marker
+// delle directory ("dir/") non contano come file; un prefisso senza blob è una directory inesistente.
+func (p *AzureBlobStorageProvider) GetDirectorySize(ctx context.Context, claims *auth.UserClaims, path string) (int64, int64, error) {
+	prefix := strings.TrimPrefix(path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var totalBytes, fileCount int64
+	found := prefix == ""
+	pager := p.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: to.Ptr(prefix),
+	})
+	for pager.More() {
+		pageResponse, err := pager.NextPage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, 0, ctx.Err()
+			}
+			return 0, 0, fmt.Errorf("failed to list blobs under '%s' to compute directory size: %w", prefix, err)
+		}
+		if pageResponse.Segment == nil {
+			continue
+		}
+		for _, blobItem := range pageResponse.Segment.BlobItems {
+			if blobItem.Name == nil {
+				continue
+			}
+			found = true
+			if strings.HasSuffix(*blobItem.Name, "/") {
+				continue
+			}
+			if blobItem.Properties != nil && blobItem.Properties.ContentLength != nil {
+				totalBytes += *blobItem.Properties.ContentLength
+			}
+			fileCount++
+		}
+	}
+	if !found {
+		return 0, 0, storage.ErrNotFound
+	}
+	return totalBytes, fileCount, nil
+}
+
+// Search lists every blob under basePath with a flat listing and matches the names client-side:
+// Azure non supporta filtri sul nome oltre al prefisso. Le directory virtuali trovate sono quelle
+// che compaiono nei nomi dei blob o hanno un marker.
+func (p *AzureBlobStorageProvider) Search(ctx context.Context, claims *auth.UserClaims, basePath string, pattern string, modTimeRange *storage.ModTimeRange, maxResults int) ([]storage.ItemInfo, error) {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("AzureBlobStorageProvider.Search chiamato da utente '%s' per storage '%s', basePath '%s', pattern '%s', maxResults %d", userIdent, p.name, basePath, pattern, maxResults)
+
+	prefix := strings.TrimPrefix(basePath, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	search, err := storage.NewPrefixSearch(prefix, pattern, modTimeRange, maxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	pager := p.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: to.Ptr(prefix),
+	})
+	for pager.More() && !search.Full() {
+		pageResponse, err := pager.NextPage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to list blobs to search prefix '%s': %w", prefix, err)
+		}
+		if pageResponse.Segment == nil {
+			continue
+		}
+		for _, blobItem := range pageResponse.Segment.BlobItems {
+			var size int64
+			var modTime time.Time
+			if blobItem.Properties != nil {
+				if blobItem.Properties.ContentLength != nil {
+					size = *blobItem.Properties.ContentLength
+				}
+				if blobItem.Properties.LastModified != nil {
+					modTime = *blobItem.Properties.LastModified
+				}
+			}
+			search.Add(*blobItem.Name, size, modTime)
+		}
+	}
+
+	p.logger.Debugf("Azure Blob: Search found %d items under prefix '%s'", len(search.Items()), prefix)
+	return search.Items(), nil
+}
+
+// Walk visits the blobs under basePath with a flat listing of the prefix (vedi storage.PrefixWalk), senza
+// una richiesta per ogni directory virtuale.
+func (p *AzureBlobStorageProvider) Walk(ctx context.Context, claims *auth.UserClaims, basePath string, maxDepth int, fn storage.WalkFunc) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("AzureBlobStorageProvider.Walk chiamato da utente '%s' per storage '%s', basePath '%s', maxDepth %d", userIdent, p.name, basePath, maxDepth)
+
+	prefix := strings.TrimPrefix(basePath, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	walk := storage.NewPrefixWalk(prefix, maxDepth, fn)
+	pager := p.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: to.Ptr(prefix),
+	})
+	for pager.More() && !walk.Done() {
+		pageResponse, err := pager.NextPage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to list blobs to walk prefix '%s': %w", prefix, err)
+		}
+		if pageResponse.Segment == nil {
+			continue
+		}
+		for _, blobItem := range pageResponse.Segment.BlobItems {
+			if blobItem.Name == nil {
+				continue
+			}
+			var size int64
+			var modTime time.Time
+			if blobItem.Properties != nil {
+				if blobItem.Properties.ContentLength != nil {
+					size = *blobItem.Properties.ContentLength
+				}
+				if blobItem.Properties.LastModified != nil {
+					modTime = *blobItem.Properties.LastModified
+				}
+			}
+			if err := walk.Add(*blobItem.Name, size, modTime); err != nil {
+				return err
+			}
+		}
+	}
+	if prefix != "" && !walk.Found() {
+		return storage.ErrNotFound
 	}
 	return nil
 }
 
-// InitiateUpload starts a new upload session for a block blob.
-func (p *AzureBlobStorageProvider) InitiateUpload(ctx context.Context, claims *auth.UserClaims, blobPath string, totalFileSize int64, chunkSize int64) (int64, error) {
+// copyPollInterval is the interval between checks of a pending server-side copy.
+const copyPollInterval = 500 * time.Millisecond
+
+// MoveItem moves a blob, or every blob under a virtual directory prefix, with a server-side copy
+// followed by the deletion of the source. Azure non ha un rename: se la cancellazione fallisce
+// la sorgente resta al suo posto accanto alla copia, non si perdono dati.
+func (p *AzureBlobStorageProvider) MoveItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("AzureBlobStorageProvider.InitiateUpload chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, blobPath)
+	p.logger.Infof("AzureBlobStorageProvider.MoveItem chiamato da utente '%s' per storage '%s', da '%s' a '%s'", userIdent, p.name, srcPath, dstPath)
+	_, err := p.transferItem(ctx, claims, srcPath, dstPath, true)
+	return err
+}
+
+// MoveItemWithResult is MoveItem, reporting whether the move was instant. Sorgente e destinazione sono
+// sempre nello stesso container, quindi StartCopyFromURL è una copia server-side che il servizio di solito
+// completa nella risposta stessa; solo le copie rese asincrone dal servizio vengono attese con il polling.
+func (p *AzureBlobStorageProvider) MoveItemWithResult(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) (*storage.MoveResult, error) {
+	p.logger.Infof("AzureBlobStorageProvider.MoveItemWithResult chiamato per storage '%s', da '%s' a '%s'", p.name, srcPath, dstPath)
+	pendingCopies, err := p.transferItem(ctx, claims, srcPath, dstPath, true)
+	if err != nil {
+		return nil, err
 	}
+	return &storage.MoveResult{
+		Method:        storage.MoveMethodServerCopy,
+		Instant:       pendingCopies == 0,
+		PendingCopies: pendingCopies,
+	}, nil
+}
 
-	blobPath = strings.TrimPrefix(blobPath, "/")
+// CopyItem copies a blob, or every blob under a virtual directory prefix, with server-side copies:
+// i dati non transitano dal server.
+func (p *AzureBlobStorageProvider) CopyItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("AzureBlobStorageProvider.CopyItem chiamato da utente '%s' per storage '%s', da '%s' a '%s'", userIdent, p.name, srcPath, dstPath)
+	_, err := p.transferItem(ctx, claims, srcPath, dstPath, false)
+	return err
+}
 
-	itemInfo, err := p.GetItem(ctx, claims, blobPath)
+// transferItem copies (and with deleteSource moves) a blob or a virtual directory, returning the number
+// of copies that the service completed asynchronously.
+func (p *AzureBlobStorageProvider) transferItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string, deleteSource bool) (int, error) {
+	operation := "copy"
+	if deleteSource {
+		operation = "move"
+	}
+	srcBlob := strings.Trim(srcPath, "/")
+	dstBlob := strings.Trim(dstPath, "/")
+	if dstBlob == "" || (deleteSource && srcBlob == "") {
+		return 0, fmt.Errorf("%w: cannot %s the container root", storage.ErrPermissionDenied, operation)
+	}
+
+	srcInfo, err := p.GetItem(ctx, claims, srcBlob)
 	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) {
-			return 0, nil
+		return 0, err
+	}
+	if _, err := p.GetItem(ctx, claims, dstBlob); err == nil {
+		return 0, storage.ErrAlreadyExists
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return 0, fmt.Errorf("failed to check destination '%s': %w", dstBlob, err)
+	}
+
+	if !srcInfo.IsDir {
+		pending, err := p.transferBlob(ctx, srcBlob, dstBlob, deleteSource)
+		if pending {
+			return 1, err
 		}
-		return 0, fmt.Errorf("failed to check existing blob for upload '%s': %w", blobPath, err)
+		return 0, err
 	}
 
-	if itemInfo.IsDir {
-		return 0, errors.New("cannot upload to a virtual directory path")
+	srcPrefix := ""
+	if srcBlob != "" {
+		srcPrefix = srcBlob + "/"
+	}
+	dstPrefix := dstBlob + "/"
+	if strings.HasPrefix(dstPrefix, srcPrefix) {
+		return 0, fmt.Errorf("cannot %s directory '%s' into itself", operation, srcPath)
 	}
 
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("AzureBlob.InitiateUpload: Blob '%s' exists with size %d. Client should handle resume logic.", blobPath, itemInfo.Size)
+	// Il listing flat restituisce sia i file sia i marker delle directory (blob vuoti "dir/"). Prima si
+	// trasferiscono i file, poi si creano i marker a destinazione secondo directory_markers; un move cancella
+	// i marker della sorgente solo alla fine, così un errore a metà lascia intatta la struttura di partenza.
+	var files []string
+	sourceMarkers := make(map[string]bool) // Directory con un marker, relative a srcPrefix ("" = la directory trasferita)
+	pager := p.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: to.Ptr(srcPrefix),
+	})
+	for pager.More() {
+		pageResponse, listErr := pager.NextPage(ctx)
+		if listErr != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, fmt.Errorf("failed to list blobs to %s with prefix '%s': %w", operation, srcPrefix, listErr)
+		}
+		if pageResponse.Segment != nil {
+			for _, blobItem := range pageResponse.Segment.BlobItems {
+				name := *blobItem.Name
+				if strings.HasSuffix(name, "/") {
+					sourceMarkers[strings.TrimPrefix(name, srcPrefix)] = true
+				} else {
+					files = append(files, name)
+				}
+			}
+		}
+	}
+	if len(files) == 0 && len(sourceMarkers) == 0 {
+		return 0, storage.ErrNotFound
+	}
+	dstMarkers := directoryMarkersToCreate(p.directoryMarkers, srcPrefix, files, sourceMarkers)
+	p.logger.Infof("Azure Blob: Starting %s of %d blobs from prefix '%s' to '%s' (%d source markers, %d markers to create, directory_markers '%s')", operation, len(files), srcPrefix, dstPrefix, len(sourceMarkers), len(dstMarkers), p.directoryMarkers)
+
+	var pendingCopies atomic.Int64
+	transfer := func(srcName string, dstName string, deleteSource bool) error {
+		pending, err := p.transferBlob(ctx, srcName, dstName, deleteSource)
+		if pending {
+			pendingCopies.Add(1)
+		}
+		return err
+	}
+	err = forEachConcurrently(ctx, files, func(name string) error {
+		return transfer(name, dstPrefix+strings.TrimPrefix(name, srcPrefix), deleteSource)
+	})
+	if err != nil {
+		return int(pendingCopies.Load()), err
 	}
+	err = forEachConcurrently(ctx, dstMarkers, func(dir string) error {
+		if sourceMarkers[dir] {
+			return transfer(srcPrefix+dir, dstPrefix+dir, false) // La copia mantiene anche i metadata del marker
+		}
+		return p.uploadDirectoryMarker(ctx, dstPrefix+dir)
+	})
+	if err != nil {
+		return int(pendingCopies.Load()), err
+	}
+	if deleteSource {
+		markerNames := make([]string, 0, len(sourceMarkers))
+		for dir := range sourceMarkers {
+			markerNames = append(markerNames, srcPrefix+dir)
+		}
+		err = forEachConcurrently(ctx, markerNames, func(name string) error {
+			return p.deleteDirectoryMarker(ctx, name)
+		})
+		if err != nil {
+			return int(pendingCopies.Load()), err
+		}
+	}
+	p.logger.Infof("Azure Blob: Virtual directory %s complete from '%s' to '%s' (%d asynchronous copies)", operation, srcPrefix, dstPrefix, pendingCopies.Load())
+	return int(pendingCopies.Load()), nil
+}
+
+// transferBlob copies a single blob server-side, waits for the copy to complete and, with
+// deleteSource, deletes the source. pending è true se il servizio ha reso la copia asincrona.
+func (p *AzureBlobStorageProvider) transferBlob(ctx context.Context, srcBlob string, dstBlob string, deleteSource bool) (pending bool, err error) {
+	srcClient := p.containerClient.NewBlobClient(srcBlob)
+	dstClient := p.containerClient.NewBlobClient(dstBlob)
+
+	copyResp, err := dstClient.StartCopyFromURL(ctx, srcClient.URL(), nil)
+	if err != nil {
+		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) && storageErr.StatusCode == 403 {
+			return false, storage.ErrPermissionDenied
+		}
+		return false, fmt.Errorf("failed to start copy of blob '%s' to '%s': %w", srcBlob, dstBlob, err)
+	}
+
+	// Le copie nello stesso account sono in genere completate subito, ma il servizio può renderle asincrone.
+	status := copyResp.CopyStatus
+	pending = status != nil && *status == blob.CopyStatusTypePending
+	if pending && p.logger.IsDebug() {
+		log.Printf("Azure Blob: Copy of blob '%s' to '%s' is asynchronous, polling its status", srcBlob, dstBlob)
+	}
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			if _, abortErr := dstClient.AbortCopyFromURL(context.Background(), *copyResp.CopyID, nil); abortErr != nil {
+				log.Printf("Warning: Failed to abort copy of blob '%s' to '%s': %v", srcBlob, dstBlob, abortErr)
+			}
+			return pending, ctx.Err()
+		case <-time.After(copyPollInterval):
+		}
+		props, propsErr := dstClient.GetProperties(ctx, nil)
+		if propsErr != nil {
+			return pending, fmt.Errorf("failed to check copy status of blob '%s': %w", dstBlob, propsErr)
+		}
+		status = props.CopyStatus
+	}
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		return pending, fmt.Errorf("copy of blob '%s' to '%s' ended with status '%s'", srcBlob, dstBlob, *status)
+	}
+	if !deleteSource {
+		p.logger.Debugf("Azure Blob: Copied blob '%s' to '%s'", srcBlob, dstBlob)
+		return pending, nil
+	}
+
+	if _, err := srcClient.Delete(ctx, nil); err != nil {
+		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) && storageErr.StatusCode == 403 {
+			return pending, storage.ErrPermissionDenied
+		}
+		return pending, fmt.Errorf("blob '%s' copied to '%s' but failed to delete the source: %w", srcBlob, dstBlob, err)
+	}
+	p.logger.Debugf("Azure Blob: Moved blob '%s' to '%s'", srcBlob, dstBlob)
+	return pending, nil
+}
+
+// azureUploadSession is a block blob upload in progress. I blocchi Azure sono legati al nome del blob,
+// quindi la destinazione è fissata all'initiate; il blob diventa visibile solo con il commit del finalize.
+type azureUploadSession struct {
+	blobPath     string
+	declaredSize int64           // total_file_size dichiarato all'initiate
+	stagedBytes  map[int64]int64 // Byte per chunk già in staging (un chunk reinviato non viene contato due volte)
+}
+
+// uploadSession returns the upload session of uploadID, or ErrUploadNotFound.
+func (p *AzureBlobStorageProvider) uploadSession(uploadID string) (*azureUploadSession, error) {
+	p.uploadsMu.Lock()
+	defer p.uploadsMu.Unlock()
+	session, ok := p.uploads[uploadID]
+	if !ok {
+		return nil, fmt.Errorf("%w: azure upload '%s'", storage.ErrUploadNotFound, uploadID)
+	}
+	return session, nil
+}
+
+// discardUploadSession drops the session and the running hash of uploadID. I blocchi non committati
+// non sono visibili e Azure li elimina da solo dopo una settimana.
+func (p *AzureBlobStorageProvider) discardUploadSession(uploadID string) {
+	p.uploadsMu.Lock()
+	delete(p.uploads, uploadID)
+	p.uploadsMu.Unlock()
+	if p.uploadHashes != nil {
+		p.uploadHashes.discard(uploadID)
+	}
+}
+
+// InitiateUpload starts a new upload session for a block blob, or resumes the session of uploadID.
+// Un blob già esistente non è un conflitto qui: viene verificato al commit del finalize.
+func (p *AzureBlobStorageProvider) InitiateUpload(ctx context.Context, claims *auth.UserClaims, uploadID string, blobPath string, totalFileSize int64, chunkSize int64) (int64, error) {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	slog.Info("AzureBlobStorageProvider.InitiateUpload", logging.KeyUser, userIdent, logging.KeyStorage, p.name, logging.KeyPath, blobPath, "upload_id", uploadID)
+
+	blobPath = strings.TrimPrefix(blobPath, "/")
+	if err := storage.CheckUploadSize(p.maxUploadBytes, totalFileSize); err != nil {
+		return 0, err
+	}
+
+	p.uploadsMu.Lock()
+	session, exists := p.uploads[uploadID]
+	var stagedSize int64
+	if exists {
+		for _, n := range session.stagedBytes {
+			stagedSize += n
+		}
+	}
+	p.uploadsMu.Unlock()
+	if exists {
+		if session.blobPath != blobPath {
+			return 0, fmt.Errorf("upload '%s' was initiated for blob '%s', not '%s'", uploadID, session.blobPath, blobPath)
+		}
+		return stagedSize, nil
+	}
+
+	itemInfo, err := p.GetItem(ctx, claims, blobPath)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, fmt.Errorf("failed to check existing blob for upload '%s': %w", blobPath, err)
+	}
+	if err == nil && itemInfo.IsDir {
+		return 0, errors.New("cannot upload to a virtual directory path")
+	}
+
+	p.uploadsMu.Lock()
+	p.uploads[uploadID] = &azureUploadSession{blobPath: blobPath, declaredSize: totalFileSize, stagedBytes: make(map[int64]int64)}
+	p.uploadsMu.Unlock()
 	return 0, nil
 }
 
-// WriteChunk uploads a block to a block blob.
-func (p *AzureBlobStorageProvider) WriteChunk(ctx context.Context, claims *auth.UserClaims, blobPath string, blockID string, chunk io.ReadSeekCloser, chunkIndex int64) error {
+// WriteChunk uploads a block of the block blob of uploadID. Con checksum il blocco viene verificato
+// prima dello staging, così un chunk corrotto non sostituisce quello già caricato con lo stesso blockID.
+func (p *AzureBlobStorageProvider) WriteChunk(ctx context.Context, claims *auth.UserClaims, uploadID string, blockID string, chunk io.ReadSeekCloser, chunkIndex int64, checksum storage.ChunkChecksum) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("AzureBlobStorageProvider.WriteChunk chiamato da utente '%s' per storage '%s', path '%s', blockID '%s', chunkIndex %d", userIdent, p.name, blobPath, blockID, chunkIndex)
+	p.logger.Debugf("AzureBlobStorageProvider.WriteChunk chiamato da utente '%s' per storage '%s', upload '%s', blockID '%s', chunkIndex %d", userIdent, p.name, uploadID, blockID, chunkIndex)
+
+	session, err := p.uploadSession(uploadID)
+	if err != nil {
+		return err
 	}
+	blobPath := session.blobPath
 
-	blobPath = strings.TrimPrefix(blobPath, "/")
+	chunkLength, err := chunk.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = chunk.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to measure block '%s' for blob '%s': %w", blockID, blobPath, err)
+	}
+	if !checksum.IsZero() {
+		verifier := checksum.NewVerifier()
+		_, err := io.Copy(verifier, chunk)
+		if err == nil {
+			_, err = chunk.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read block '%s' for blob '%s': %w", blockID, blobPath, err)
+		}
+		if err := verifier.Verify(chunkIndex); err != nil {
+			return err
+		}
+	}
+
+	// I blocchi non hanno una posizione fissa: i limiti valgono per la somma dei blocchi in staging, dove un
+	// chunk ritrasmesso sostituisce il precedente con lo stesso indice.
+	if p.maxUploadBytes > 0 || p.strictUploadSize {
+		p.uploadsMu.Lock()
+		stagedSize := chunkLength
+		for index, n := range session.stagedBytes {
+			if index != chunkIndex {
+				stagedSize += n
+			}
+		}
+		p.uploadsMu.Unlock()
+		if err := storage.CheckUploadSize(p.maxUploadBytes, stagedSize); err != nil {
+			return err
+		}
+		if p.strictUploadSize && stagedSize > session.declaredSize {
+			return fmt.Errorf("%w: chunk %d brings blob '%s' to %d bytes, declared size is %d", storage.ErrSizeExceeded, chunkIndex, blobPath, stagedSize, session.declaredSize)
+		}
+	}
 
 	blockBlobClient := p.containerClient.NewBlockBlobClient(blobPath)
 
-	_, err := blockBlobClient.StageBlock(ctx, blockID, chunk, nil)
+	// Con il Content-MD5 Azure rifiuta un blocco arrivato corrotto: il finalize può fidarsi dei blocchi in staging.
+	md5Sum, err := blockMD5(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to read block '%s' for blob '%s': %w", blockID, blobPath, err)
+	}
+	_, err = blockBlobClient.StageBlock(ctx, blockID, chunk, &blockblob.StageBlockOptions{TransactionalValidation: blob.TransferValidationTypeMD5(md5Sum)})
 	if err != nil {
 		var storageErr *azcore.ResponseError
 		if errors.As(err, &storageErr) && storageErr.StatusCode == 403 {
@@ -580,23 +1283,52 @@ func (p *AzureBlobStorageProvider) WriteChunk(ctx context.Context, claims *auth.
 		return fmt.Errorf("failed to stage block '%s' for blob '%s': %w", blockID, blobPath, err)
 	}
 
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("Azure Blob: Staged block '%s' for blob '%s'", blockID, blobPath)
+	p.logger.Debugf("Azure Blob: Staged block '%s' for blob '%s'", blockID, blobPath)
+	p.uploadsMu.Lock()
+	session.stagedBytes[chunkIndex] = chunkLength
+	p.uploadsMu.Unlock()
+
+	if p.uploadHashes != nil {
+		if err := p.uploadHashes.add(uploadID, chunkIndex, chunk); err != nil && config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Azure Blob: Incremental SHA256 disabled for upload of blob '%s', finalize will re-download it: %v", blobPath, err)
+		}
 	}
 	return nil
 }
 
 // FinalizeUpload commits the blocks to form the final block blob and performs SHA256 integrity check.
-func (p *AzureBlobStorageProvider) FinalizeUpload(ctx context.Context, claims *auth.UserClaims, blobPath string, blockIDs []string, expectedSHA256 string) error {
+// Se strict_upload_size è attivo, i blocchi in staging devono essere esattamente quelli del commit e sommare
+// declaredSize: il controllo avviene prima del commit, perché dopo il blob precedente è già stato sostituito.
+// Senza overwrite il commit è condizionato (If-None-Match: *): un blob creato nel frattempo da un altro
+// client dà ErrAlreadyExists e la sessione resta valida per ripetere il finalize con overwrite.
+// La verifica evita di riscaricare il blob: lo SHA256 incrementale viene confrontato prima del commit,
+// altrimenti bastano i blocchi verificati con Content-MD5 allo staging; il download completo avviene solo
+// con strongVerification o se i blocchi committati non sono quelli della sessione. Con store_checksums lo
+// SHA256 viene scritto nei metadata insieme al commit. Con ifMatch il commit sostituisce il blob solo se ha
+// ancora quell'ETag (If-Match), altrimenti restituisce storage.ErrPreconditionFailed.
+func (p *AzureBlobStorageProvider) FinalizeUpload(ctx context.Context, claims *auth.UserClaims, uploadID string, blobPath string, blockIDs []string, expectedSHA256 string, declaredSize int64, overwrite bool, strongVerification bool, ifMatch string) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("AzureBlobStorageProvider.FinalizeUpload chiamato da utente '%s' per storage '%s', path '%s' con %d blocchi. SHA256 atteso: %s", userIdent, p.name, blobPath, len(blockIDs), expectedSHA256)
-	}
+	slog.Info("AzureBlobStorageProvider.FinalizeUpload", logging.KeyUser, userIdent, logging.KeyStorage, p.name, logging.KeyPath, blobPath, "upload_id", uploadID, "blocks", len(blockIDs), "expected_sha256", expectedSHA256, "overwrite", overwrite, "strong_verification", strongVerification)
 
 	blobPath = strings.TrimPrefix(blobPath, "/")
+	session, err := p.uploadSession(uploadID)
+	if err != nil {
+		return err
+	}
+	if session.blobPath != blobPath {
+		return fmt.Errorf("upload '%s' was staged for blob '%s' and cannot be published as '%s'", uploadID, session.blobPath, blobPath)
+	}
+
+	if p.strictUploadSize {
+		// La sessione resta valida: il client può reinviare i chunk mancanti e ripetere il finalize.
+		if ok, reason := p.stagedBlocksMatch(session, len(blockIDs), declaredSize); !ok {
+			log.Printf("Error: Size mismatch for upload '%s' of blob '%s' (%s), not committing.", uploadID, blobPath, reason)
+			return fmt.Errorf("%w: '%s' %s", storage.ErrSizeMismatch, blobPath, reason)
+		}
+	}
 
 	blockBlobClient := p.containerClient.NewBlockBlobClient(blobPath)
 
@@ -604,30 +1336,88 @@ func (p *AzureBlobStorageProvider) FinalizeUpload(ctx context.Context, claims *a
 	// Ordina i blockID lessicograficamente.
 	// Dato che i blockID sono generati dal client come btoa(String(chunkIndex).padStart(20, '0')),
 	// l'ordinamento lessicografico corrisponderà all'ordine sequenziale corretto dei chunk.
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("Azure Blob: Block IDs prima dell'ordinamento per '%s': %v", blobPath, blockIDs)
-	}
+	p.logger.Debugf("Azure Blob: Block IDs prima dell'ordinamento per '%s': %v", blobPath, blockIDs)
 	sort.Strings(blockIDs) // Questa è la riga chiave da aggiungere
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("Azure Blob: Block IDs dopo l'ordinamento per '%s': %v", blobPath, blockIDs)
-	}
+	p.logger.Debugf("Azure Blob: Block IDs dopo l'ordinamento per '%s': %v", blobPath, blockIDs)
 	// --- FINE MODIFICA ---
 
-	_, err := blockBlobClient.CommitBlockList(ctx, blockIDs, nil)
+	// Scelta della verifica prima del commit: un hash incrementale diverso da quello atteso non pubblica il blob.
+	verification := ""
+	if expectedSHA256 != "" {
+		verification = verificationDownload
+		if p.uploadHashes != nil && !strongVerification {
+			if calculatedSHA256, ok, reason := p.uploadHashes.sum(uploadID, len(blockIDs), declaredSize); ok {
+				p.logger.Debugf("Azure Blob: Incremental SHA256 for '%s': %s, expected: %s", blobPath, calculatedSHA256, expectedSHA256)
+				if calculatedSHA256 != expectedSHA256 {
+					slog.Error("SHA256 mismatch for blob (incremental hash)", logging.KeyUser, userIdent, logging.KeyStorage, p.name, logging.KeyPath, blobPath, "calculated_sha256", calculatedSHA256, "expected_sha256", expectedSHA256)
+					p.discardUploadSession(uploadID)
+					return storage.ErrIntegrityCheckFailed
+				}
+				verification = verificationIncremental
+			} else {
+				p.logger.Debugf("Azure Blob: Incremental SHA256 not usable for blob '%s': %s", blobPath, reason)
+			}
+		}
+		if verification == verificationDownload && !strongVerification {
+			if ok, reason := p.stagedBlocksMatch(session, len(blockIDs), declaredSize); ok {
+				verification = verificationBlockMD5
+			} else {
+				p.logger.Infof("Azure Blob: Staged blocks of blob '%s' do not match the commit (%s), re-downloading for verification.", blobPath, reason)
+			}
+		}
+	}
+
+	// I metadata dell'uploader vengono scritti insieme al commit, senza una richiesta in più;
+	// setStoredChecksum li conserva quando aggiunge il checksum.
+	commitOptions := &blockblob.CommitBlockListOptions{}
+	if p.recordUploader {
+		commitOptions.Metadata = map[string]*string{
+			uploadedByMetadataKey: to.Ptr(storage.UploaderName(claims)),
+			uploadedAtMetadataKey: to.Ptr(time.Now().UTC().Format(time.RFC3339)),
+		}
+	}
+	if p.storeChecksums && (verification == verificationIncremental || verification == verificationBlockMD5) {
+		if commitOptions.Metadata == nil {
+			commitOptions.Metadata = map[string]*string{}
+		}
+		commitOptions.Metadata[checksumMetadataKey] = to.Ptr(expectedSHA256)
+		commitOptions.Metadata[checksumSizeMetadataKey] = to.Ptr(fmt.Sprintf("%d", declaredSize))
+	}
+	if !overwrite {
+		commitOptions.AccessConditions = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)}}
+	}
+	if ifMatch != "" {
+		// Sovrascrittura con expected_mod_time/expected_etag: il commit fallisce se il blob è cambiato dopo la verifica.
+		commitOptions.AccessConditions = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: to.Ptr(azcore.ETag(ifMatch))}}
+	}
+	commitResponse, err := blockBlobClient.CommitBlockList(ctx, blockIDs, commitOptions)
 	if err != nil {
 		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) && (storageErr.StatusCode == 409 || storageErr.StatusCode == 412) && !overwrite {
+			return storage.ErrAlreadyExists
+		}
+		p.discardUploadSession(uploadID)
+		if errors.As(err, &storageErr) && storageErr.StatusCode == 412 && ifMatch != "" {
+			return fmt.Errorf("%w: blob '%s' no longer has ETag %s", storage.ErrPreconditionFailed, blobPath, ifMatch)
+		}
 		if errors.As(err, &storageErr) && storageErr.StatusCode == 403 {
 			return storage.ErrPermissionDenied
 		}
 		return fmt.Errorf("failed to commit block list for blob '%s': %w", blobPath, err)
 	}
 
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("Azure Blob: Committed block list for blob '%s'. Starting integrity check.", blobPath)
-	}
+	p.logger.Infof("Azure Blob: Committed block list for blob '%s'. Starting integrity check.", blobPath)
+	// Dopo il commit i blocchi staged non esistono più: sessione e hash incrementale non servono oltre questo finalize.
+	defer p.discardUploadSession(uploadID)
 
-	if expectedSHA256 != "" {
-		downloadResponse, err := blockBlobClient.DownloadStream(ctx, nil)
+	switch verification {
+	case verificationIncremental:
+		p.logger.Infof("Azure Blob: SHA256 integrity check passed for blob '%s' (incremental hash, no re-download).", blobPath)
+	case verificationBlockMD5:
+		p.logger.Infof("Azure Blob: Integrity of blob '%s' verified by the Content-MD5 of its %d blocks (no re-download).", blobPath, len(blockIDs))
+	case verificationDownload:
+		// Si verifica la versione appena committata: se il blob è stato sostituito, il download fallisce (412).
+		downloadResponse, err := blockBlobClient.DownloadStream(ctx, &blob.DownloadStreamOptions{AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: commitResponse.ETag}}})
 		if err != nil {
 			return fmt.Errorf("failed to download blob for SHA256 verification: %w", err)
 		}
@@ -639,86 +1429,172 @@ func (p *AzureBlobStorageProvider) FinalizeUpload(ctx context.Context, claims *a
 		}
 		calculatedSHA256 := hex.EncodeToString(hasher.Sum(nil))
 
-		if config.IsLogLevel(config.LogLevelDebug) {
+		if p.logger.IsDebug() {
 			log.Printf("Azure Blob: Calculated SHA256 for '%s': %s", blobPath, calculatedSHA256)
 			log.Printf("Azure Blob: Expected SHA256 for '%s': %s", blobPath, expectedSHA256)
 		}
 
 		if calculatedSHA256 != expectedSHA256 {
-			log.Printf("Error: SHA256 mismatch for blob '%s'. Calculated: %s, Expected: %s", blobPath, calculatedSHA256, expectedSHA256)
+			slog.Error("SHA256 mismatch for blob", logging.KeyUser, userIdent, logging.KeyStorage, p.name, logging.KeyPath, blobPath, "calculated_sha256", calculatedSHA256, "expected_sha256", expectedSHA256)
 			return storage.ErrIntegrityCheckFailed
 		}
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Azure Blob: SHA256 integrity check passed for blob '%s'.", blobPath)
-		}
-	} else {
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("Azure Blob: SHA256 integrity check skipped for blob '%s' (no expected hash provided).", blobPath)
+		p.logger.Infof("Azure Blob: SHA256 integrity check passed for blob '%s' (re-downloaded).", blobPath)
+		if p.storeChecksums {
+			var committedETag azcore.ETag
+			if commitResponse.ETag != nil {
+				committedETag = *commitResponse.ETag
+			}
+			if err := p.setStoredChecksum(ctx, blobPath, calculatedSHA256, committedETag); err != nil {
+				log.Printf("Warning: Failed to store SHA256 checksum in metadata of blob '%s': %v", blobPath, err)
+			}
 		}
+	default:
+		p.logger.Debugf("Azure Blob: SHA256 integrity check skipped for blob '%s' (no expected hash provided).", blobPath)
 	}
 
 	return nil
 }
 
-// CancelUpload aborts an ongoing block blob upload.
-func (p *AzureBlobStorageProvider) CancelUpload(ctx context.Context, claims *auth.UserClaims, blobPath string) error {
+// CancelUpload aborts an ongoing block blob upload. Il blob eventualmente già esistente con lo stesso nome
+// non viene toccato: i blocchi in staging non sono visibili e scadono da soli.
+func (p *AzureBlobStorageProvider) CancelUpload(ctx context.Context, claims *auth.UserClaims, uploadID string) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("AzureBlobStorageProvider.CancelUpload chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, blobPath)
-	}
+	p.logger.Infof("AzureBlobStorageProvider.CancelUpload chiamato da utente '%s' per storage '%s', upload '%s'", userIdent, p.name, uploadID)
 
-	blobPath = strings.TrimPrefix(blobPath, "/")
-	blobClient := p.containerClient.NewBlobClient(blobPath)
-
-	_, err := blobClient.Delete(ctx, nil)
+	session, err := p.uploadSession(uploadID)
 	if err != nil {
-		var storageErr *azcore.ResponseError
-		if errors.As(err, &storageErr) && storageErr.StatusCode == 404 {
-			if config.IsLogLevel(config.LogLevelInfo) {
-				log.Printf("Azure Blob: No existing blob found to delete during cancel for '%s'", blobPath)
-			}
-			return nil
-		}
-		if errors.As(err, &storageErr) && storageErr.StatusCode == 403 {
-			return storage.ErrPermissionDenied
-		}
-		return fmt.Errorf("failed to delete blob during cancel for '%s': %w", blobPath, err)
-	}
-
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("Azure Blob: Deleted existing blob '%s' during cancel (staged blocks will expire).", blobPath)
+		return err
 	}
+	p.discardUploadSession(uploadID)
+	p.logger.Infof("Azure Blob: Discarded upload session '%s' of blob '%s' (staged blocks will expire).", uploadID, session.blobPath)
 	return nil
 }
 
-// GetUploadedSize returns the current size of a blob.
-func (p *AzureBlobStorageProvider) GetUploadedSize(ctx context.Context, claims *auth.UserClaims, blobPath string) (int64, error) {
+// GetUploadedSize returns the bytes staged so far by the upload session of uploadID (0 se non esiste).
+func (p *AzureBlobStorageProvider) GetUploadedSize(ctx context.Context, claims *auth.UserClaims, uploadID string) (int64, error) {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("AzureBlobStorageProvider.GetUploadedSize chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, blobPath)
+	p.logger.Debugf("AzureBlobStorageProvider.GetUploadedSize chiamato da utente '%s' per storage '%s', upload '%s'", userIdent, p.name, uploadID)
+
+	p.uploadsMu.Lock()
+	defer p.uploadsMu.Unlock()
+	session, ok := p.uploads[uploadID]
+	if !ok {
+		return 0, nil
+	}
+	var stagedSize int64
+	for _, n := range session.stagedBytes {
+		stagedSize += n
 	}
+	return stagedSize, nil
+}
 
-	blobPath = strings.TrimPrefix(blobPath, "/")
+// Metadata keys used to store the verified checksum. Un overwrite del blob azzera i metadata,
+// quindi il checksum non può sopravvivere a una modifica del contenuto; la size è un controllo in più.
+const (
+	checksumMetadataKey     = "clouddav_sha256"
+	checksumSizeMetadataKey = "clouddav_sha256_size"
+)
 
-	itemInfo, err := p.GetItem(ctx, claims, blobPath)
-	if err != nil {
-		if errors.Is(err, storage.ErrNotFound) {
-			return 0, nil
+// storedChecksum returns the SHA256 stored in the blob metadata, or "" if absent or not matching the size.
+// Le chiavi dei metadata tornano con il case canonicalizzato degli header HTTP, per cui il confronto è case-insensitive.
+func storedChecksum(metadata map[string]*string, size int64) string {
+	var sha, storedSize string
+	for k, v := range metadata {
+		if v == nil {
+			continue
+		}
+		if strings.EqualFold(k, checksumMetadataKey) {
+			sha = *v
+		} else if strings.EqualFold(k, checksumSizeMetadataKey) {
+			storedSize = *v
 		}
-		return 0, fmt.Errorf("failed to get blob size for upload status '%s': %w", blobPath, err)
 	}
+	if sha == "" || storedSize != fmt.Sprintf("%d", size) {
+		return ""
+	}
+	return sha
+}
 
-	if itemInfo.IsDir {
-		return 0, errors.New("cannot get size for a virtual directory path")
+// Metadata keys used to record who uploaded the blob and when (record_uploader).
+const (
+	uploadedByMetadataKey = "clouddav_uploaded_by"
+	uploadedAtMetadataKey = "clouddav_uploaded_at"
+)
+
+// storedUploader returns the uploader and the upload time recorded in the blob metadata, if any.
+func storedUploader(metadata map[string]*string) (string, *time.Time) {
+	var uploadedBy string
+	var uploadedAt *time.Time
+	for k, v := range metadata {
+		if v == nil {
+			continue
+		}
+		if strings.EqualFold(k, uploadedByMetadataKey) {
+			uploadedBy = *v
+		} else if strings.EqualFold(k, uploadedAtMetadataKey) {
+			if t, err := time.Parse(time.RFC3339, *v); err == nil {
+				uploadedAt = &t
+			}
+		}
+	}
+	if uploadedBy == "" {
+		return "", nil
+	}
+	return uploadedBy, uploadedAt
+}
+
+// setStoredChecksum writes the checksum into the blob metadata, preserving the other metadata entries.
+// etag is the version of the blob the checksum was computed on: lettura e scrittura dei metadata sono
+// condizionate con If-Match, e se il blob è cambiato il checksum non viene salvato (ErrPreconditionFailed).
+func (p *AzureBlobStorageProvider) setStoredChecksum(ctx context.Context, blobPath string, sha256Hex string, etag azcore.ETag) error {
+	if etag == "" {
+		return fmt.Errorf("%w: no ETag for blob '%s'", storage.ErrPreconditionFailed, blobPath)
+	}
+	blobClient := p.containerClient.NewBlobClient(blobPath)
+	accessConditions := &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: to.Ptr(etag)}}
+	props, err := blobClient.GetProperties(ctx, &blob.GetPropertiesOptions{AccessConditions: accessConditions})
+	if err != nil {
+		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) && storageErr.StatusCode == 412 {
+			return fmt.Errorf("%w: blob '%s' no longer has ETag %s", storage.ErrPreconditionFailed, blobPath, etag)
+		}
+		return fmt.Errorf("failed to get blob properties for '%s': %w", blobPath, err)
+	}
+	metadata := map[string]*string{}
+	for k, v := range props.Metadata {
+		if !strings.EqualFold(k, checksumMetadataKey) && !strings.EqualFold(k, checksumSizeMetadataKey) {
+			metadata[strings.ToLower(k)] = v
+		}
 	}
+	metadata[checksumMetadataKey] = to.Ptr(sha256Hex)
+	metadata[checksumSizeMetadataKey] = to.Ptr(fmt.Sprintf("%d", *props.ContentLength))
+	if _, err := blobClient.SetMetadata(ctx, metadata, &blob.SetMetadataOptions{AccessConditions: accessConditions}); err != nil {
+		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) && storageErr.StatusCode == 403 {
+			return storage.ErrPermissionDenied
+		}
+		if errors.As(err, &storageErr) && storageErr.StatusCode == 412 {
+			return fmt.Errorf("%w: blob '%s' no longer has ETag %s", storage.ErrPreconditionFailed, blobPath, etag)
+		}
+		return fmt.Errorf("failed to set metadata for blob '%s': %w", blobPath, err)
+	}
+	return nil
+}
 
-	return itemInfo.Size, nil
+// StoreChecksum saves a SHA256 computed outside of an upload (e.g. by compute_hash).
+// hashed is the item as read before hashing: il checksum viene salvato solo se il blob ha ancora il suo ETag.
+// Non fa nulla se store_checksums non è abilitato per lo storage.
+func (p *AzureBlobStorageProvider) StoreChecksum(ctx context.Context, claims *auth.UserClaims, path string, sha256Hex string, hashed *storage.ItemInfo) error {
+	if !p.storeChecksums {
+		return nil
+	}
+	return p.setStoredChecksum(ctx, strings.TrimPrefix(path, "/"), sha256Hex, azcore.ETag(hashed.ETag))
 }
 
 var _ storage.StorageProvider = (*AzureBlobStorageProvider)(nil)
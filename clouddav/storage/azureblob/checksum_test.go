@@ -0,0 +1,83 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"clouddav/internal/logging"
+	"clouddav/storage"
+)
+
+// fakeMetadataServer answers GetProperties and SetMetadata of a blob, honoring If-Match like Azure.
+type fakeMetadataServer struct {
+	mu          sync.Mutex
+	etag        string
+	ifMatch     []string // If-Match di ogni richiesta
+	metadataSet bool
+}
+
+func (f *fakeMetadataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ifMatch = append(f.ifMatch, r.Header.Get("If-Match"))
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != f.etag {
+		w.Header().Set("x-ms-error-code", "ConditionNotMet")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("ETag", f.etag)
+	if r.Method == http.MethodPut && r.URL.Query().Get("comp") == "metadata" {
+		f.metadataSet = true
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Length", "10")
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestStoreChecksumRequiresHashedETag(t *testing.T) {
+	tests := []struct {
+		name       string
+		hashedETag string
+		stored     bool
+	}{
+		{"unchanged blob", `"0x1"`, true},
+		{"blob overwritten after hashing", `"0x0"`, false},
+		{"no ETag", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMetadataServer{etag: `"0x1"`}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			containerClient, err := container.NewClientWithNoCredential(server.URL+"/container", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &AzureBlobStorageProvider{name: "test", logger: logging.NewStorageLogger("test"), containerClient: containerClient, storeChecksums: true}
+
+			err = p.StoreChecksum(context.Background(), nil, "/file.bin", "abc123", &storage.ItemInfo{Size: 10, ETag: tt.hashedETag})
+			if tt.stored && err != nil {
+				t.Fatalf("StoreChecksum: %v", err)
+			}
+			if !tt.stored && !errors.Is(err, storage.ErrPreconditionFailed) {
+				t.Fatalf("StoreChecksum = %v, want ErrPreconditionFailed", err)
+			}
+			if fake.metadataSet != tt.stored {
+				t.Errorf("metadata set = %t, want %t", fake.metadataSet, tt.stored)
+			}
+			for i, ifMatch := range fake.ifMatch {
+				if ifMatch != tt.hashedETag {
+					t.Errorf("request %d sent If-Match %q, want the hashed ETag %q", i, ifMatch, tt.hashedETag)
+				}
+			}
+		})
+	}
+}
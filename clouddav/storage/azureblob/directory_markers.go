@@ -0,0 +1,143 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"clouddav/config"
+	"clouddav/storage"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// directoryMarkersToCreate returns the directories, relative to srcPrefix and ending with "/" ("" is the
+// transferred directory itself), that get a marker at the destination of a copy/move according to mode.
+// files are the full names of the non-marker blobs under srcPrefix, sourceMarkers the directories with a marker.
+func directoryMarkersToCreate(mode string, srcPrefix string, files []string, sourceMarkers map[string]bool) []string {
+	dirs := make(map[string]bool)
+	switch mode {
+	case config.DirectoryMarkersRecreate:
+		// Ogni directory dell'albero, comprese quelle implicite che esistono solo come prefisso dei file.
+		dirs[""] = true
+		addParents := func(relative string) {
+			for i := 0; i < len(relative); i++ {
+				if relative[i] == '/' {
+					dirs[relative[:i+1]] = true
+				}
+			}
+		}
+		for _, name := range files {
+			addParents(strings.TrimPrefix(name, srcPrefix))
+		}
+		for dir := range sourceMarkers {
+			addParents(dir)
+		}
+	case config.DirectoryMarkersImplicit:
+		// Solo le directory senza contenuto: senza marker non esisterebbero a destinazione.
+		for dir := range sourceMarkers {
+			if !hasBlobUnder(srcPrefix+dir, files, sourceMarkers, srcPrefix) {
+				dirs[dir] = true
+			}
+		}
+	default:
+		for dir := range sourceMarkers {
+			dirs[dir] = true
+		}
+	}
+
+	result := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// hasBlobUnder reports whether a file or the marker of a subdirectory is under prefix.
+func hasBlobUnder(prefix string, files []string, sourceMarkers map[string]bool, srcPrefix string) bool {
+	for _, name := range files {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for dir := range sourceMarkers {
+		if marker := srcPrefix + dir; marker != prefix && strings.HasPrefix(marker, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadDirectoryMarker creates the zero-byte marker blob of a virtual directory (markerBlob ends with "/").
+func (p *AzureBlobStorageProvider) uploadDirectoryMarker(ctx context.Context, markerBlob string) error {
+	uploadResp, err := p.containerClient.NewBlockBlobClient(markerBlob).UploadBuffer(ctx, []byte{}, nil)
+	if err != nil {
+		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) && storageErr.StatusCode == 403 {
+			return storage.ErrPermissionDenied
+		}
+		return fmt.Errorf("failed to create virtual directory blob '%s': %w", markerBlob, err)
+	}
+	p.logger.Debugf("Azure Blob: Created virtual directory marker blob '%s': %s", markerBlob, *uploadResp.ETag)
+	return nil
+}
+
+// deleteDirectoryMarker deletes the marker blob of a virtual directory; un marker già assente non è un errore.
+func (p *AzureBlobStorageProvider) deleteDirectoryMarker(ctx context.Context, markerBlob string) error {
+	_, err := p.containerClient.NewBlobClient(markerBlob).Delete(ctx, nil)
+	if err != nil {
+		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) {
+			switch storageErr.StatusCode {
+			case 404:
+				return nil
+			case 403:
+				return storage.ErrPermissionDenied
+			}
+		}
+		return fmt.Errorf("failed to delete directory marker blob '%s': %w", markerBlob, err)
+	}
+	p.logger.Debugf("Azure Blob: Deleted directory marker blob '%s'", markerBlob)
+	return nil
+}
+
+// forEachConcurrently runs fn for every name with bounded concurrency and returns the first error.
+func forEachConcurrently(ctx context.Context, names []string, fn func(name string) error) error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(names))
+	maxConcurrency := runtime.NumCPU() * 4
+	if maxConcurrency == 0 {
+		maxConcurrency = 4
+	}
+	sem := make(chan struct{}, maxConcurrency)
+
+	for _, name := range names {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case sem <- struct{}{}:
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if err := fn(name); err != nil {
+					errChan <- err
+				}
+			}(name)
+		}
+	}
+	wg.Wait()
+	close(errChan)
+	for err := range errChan {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
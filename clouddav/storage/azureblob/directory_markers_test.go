@@ -0,0 +1,249 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"clouddav/config"
+	"clouddav/internal/logging"
+)
+
+// fakeContainerServer keeps the blobs of one container in memory and answers the requests used by copy and
+// move: Get Blob Properties, List Blobs (flat e gerarchico), Put Blob, Copy Blob e Delete Blob.
+// Con asyncCopies le copie risultano pending nella risposta e completate al primo Get Blob Properties.
+type fakeContainerServer struct {
+	mu          sync.Mutex
+	blobs       map[string][]byte
+	asyncCopies bool
+}
+
+const fakeLastModified = "Mon, 01 Jan 2024 00:00:00 GMT"
+
+func (f *fakeContainerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Query().Get("comp") == "list" {
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/container/")
+	data, exists := f.blobs[name]
+	switch {
+	case r.Method == http.MethodHead:
+		if !exists {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", fakeLastModified)
+		w.Header().Set("ETag", `"0x1"`)
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		if f.asyncCopies {
+			w.Header().Set("x-ms-copy-status", "success")
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		source, err := url.Parse(r.Header.Get("x-ms-copy-source"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sourceData, ok := f.blobs[strings.TrimPrefix(source.Path, "/container/")]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "CannotVerifyCopySource")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.blobs[name] = append([]byte(nil), sourceData...)
+		w.Header().Set("ETag", `"0x2"`)
+		w.Header().Set("Last-Modified", fakeLastModified)
+		w.Header().Set("x-ms-copy-id", "copy")
+		if f.asyncCopies {
+			w.Header().Set("x-ms-copy-status", "pending")
+		} else {
+			w.Header().Set("x-ms-copy-status", "success")
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut:
+		f.blobs[name] = body
+		w.Header().Set("ETag", `"0x3"`)
+		w.Header().Set("Last-Modified", fakeLastModified)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		if !exists {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// list writes a single page of List Blobs; con un delimiter i blob più profondi diventano BlobPrefix.
+func (f *fakeContainerServer) list(w http.ResponseWriter, prefix string, delimiter string) {
+	type blobProperties struct {
+		LastModified  string `xml:"Last-Modified"`
+		ContentLength int    `xml:"Content-Length"`
+	}
+	type blobItem struct {
+		Name       string         `xml:"Name"`
+		Properties blobProperties `xml:"Properties"`
+	}
+	type blobPrefix struct {
+		Name string `xml:"Name"`
+	}
+	type enumerationResults struct {
+		XMLName  xml.Name     `xml:"EnumerationResults"`
+		Prefix   string       `xml:"Prefix"`
+		Blobs    []blobItem   `xml:"Blobs>Blob"`
+		Prefixes []blobPrefix `xml:"Blobs>BlobPrefix"`
+	}
+
+	result := enumerationResults{Prefix: prefix}
+	seenPrefixes := make(map[string]bool)
+	for _, name := range f.sortedNames() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 && len(prefix)+i+1 < len(name) {
+				sub := name[:len(prefix)+i+1]
+				if !seenPrefixes[sub] {
+					seenPrefixes[sub] = true
+					result.Prefixes = append(result.Prefixes, blobPrefix{Name: sub})
+				}
+				continue
+			}
+		}
+		result.Blobs = append(result.Blobs, blobItem{Name: name, Properties: blobProperties{LastModified: fakeLastModified, ContentLength: len(f.blobs[name])}})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeContainerServer) sortedNames() []string {
+	names := make([]string, 0, len(f.blobs))
+	for name := range f.blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newMarkerTestProvider(t *testing.T, blobs []string, directoryMarkers string) (*AzureBlobStorageProvider, *fakeContainerServer) {
+	t.Helper()
+	fake := &fakeContainerServer{blobs: make(map[string][]byte)}
+	for _, name := range blobs {
+		if strings.HasSuffix(name, "/") {
+			fake.blobs[name] = nil
+		} else {
+			fake.blobs[name] = []byte("content of " + name)
+		}
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	containerClient, err := container.NewClientWithNoCredential(server.URL+"/container", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AzureBlobStorageProvider{
+		name:             "test",
+		logger:           logging.NewStorageLogger("test"),
+		containerClient:  containerClient,
+		directoryMarkers: directoryMarkers,
+	}, fake
+}
+
+func TestTransferDirectoryMarkers(t *testing.T) {
+	// "markers" ha un marker per ogni directory, compresa una vuota; "implicit" esiste solo come prefisso dei file.
+	layouts := map[string][]string{
+		"markers":  {"src/", "src/a.txt", "src/empty/", "src/full/", "src/full/b.txt"},
+		"implicit": {"src/a.txt", "src/sub/b.txt"},
+	}
+	tests := []struct {
+		layout string
+		mode   string
+		want   []string // Blob sotto dst/ dopo il trasferimento
+	}{
+		{"markers", config.DirectoryMarkersPreserve, []string{"dst/", "dst/a.txt", "dst/empty/", "dst/full/", "dst/full/b.txt"}},
+		{"markers", config.DirectoryMarkersRecreate, []string{"dst/", "dst/a.txt", "dst/empty/", "dst/full/", "dst/full/b.txt"}},
+		{"markers", config.DirectoryMarkersImplicit, []string{"dst/a.txt", "dst/empty/", "dst/full/b.txt"}},
+		{"implicit", config.DirectoryMarkersPreserve, []string{"dst/a.txt", "dst/sub/b.txt"}},
+		{"implicit", config.DirectoryMarkersRecreate, []string{"dst/", "dst/a.txt", "dst/sub/", "dst/sub/b.txt"}},
+		{"implicit", config.DirectoryMarkersImplicit, []string{"dst/a.txt", "dst/sub/b.txt"}},
+	}
+	for _, tt := range tests {
+		for _, move := range []bool{false, true} {
+			operation := "copy"
+			if move {
+				operation = "move"
+			}
+			t.Run(fmt.Sprintf("%s %s %s", operation, tt.layout, tt.mode), func(t *testing.T) {
+				source := append([]string{"other/keep.txt"}, layouts[tt.layout]...)
+				p, fake := newMarkerTestProvider(t, source, tt.mode)
+				ctx := context.Background()
+
+				var err error
+				if move {
+					err = p.MoveItem(ctx, nil, "/src", "/dst")
+				} else {
+					err = p.CopyItem(ctx, nil, "/src", "/dst")
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", operation, err)
+				}
+
+				var gotDst, gotSrc []string
+				for _, name := range fake.sortedNames() {
+					switch {
+					case strings.HasPrefix(name, "dst/"):
+						gotDst = append(gotDst, name)
+					case strings.HasPrefix(name, "src/"):
+						gotSrc = append(gotSrc, name)
+					}
+				}
+				if !reflect.DeepEqual(gotDst, tt.want) {
+					t.Errorf("destination blobs = %v, want %v", gotDst, tt.want)
+				}
+				if _, ok := fake.blobs["dst/full/b.txt"]; ok && string(fake.blobs["dst/full/b.txt"]) != "content of src/full/b.txt" {
+					t.Errorf("dst/full/b.txt = %q, want the content of the source", fake.blobs["dst/full/b.txt"])
+				}
+				if move {
+					if len(gotSrc) != 0 {
+						t.Errorf("source blobs left after move: %v", gotSrc)
+					}
+				} else {
+					wantSrc := append([]string(nil), layouts[tt.layout]...)
+					sort.Strings(wantSrc)
+					if !reflect.DeepEqual(gotSrc, wantSrc) {
+						t.Errorf("source blobs after copy = %v, want %v", gotSrc, wantSrc)
+					}
+				}
+				if _, ok := fake.blobs["other/keep.txt"]; !ok {
+					t.Error("a blob outside the source prefix was removed")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"clouddav/storage"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// directoryModTimeScanLimit is the number of blobs read to derive the ModTime of a virtual directory.
+const directoryModTimeScanLimit = 1000
+
+// latestBlobModTime returns the most recent LastModified of the blobs under prefix ("dir/"). Il listing di
+// Azure non si può ordinare per data, quindi si legge una sola pagina flat: se sotto il prefisso ci sono più
+// di directoryModTimeScanLimit blob la data non sarebbe affidabile e viene restituito il tempo zero (sconosciuta).
+func (p *AzureBlobStorageProvider) latestBlobModTime(ctx context.Context, prefix string) (time.Time, error) {
+	pager := p.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:     to.Ptr(prefix),
+		MaxResults: to.Ptr(int32(directoryModTimeScanLimit)),
+	})
+	pageResponse, err := pager.NextPage(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to list blobs under '%s': %w", prefix, err)
+	}
+	if pager.More() {
+		return time.Time{}, nil
+	}
+	var latest time.Time
+	if pageResponse.Segment != nil {
+		for _, blobItem := range pageResponse.Segment.BlobItems {
+			if blobItem.Properties != nil && blobItem.Properties.LastModified != nil && blobItem.Properties.LastModified.After(latest) {
+				latest = *blobItem.Properties.LastModified
+			}
+		}
+	}
+	return latest, nil
+}
+
+// setDirectoryModTimes fills the ModTime of the virtual directories in items when directory_mod_time is
+// enabled. Gli errori vengono solo loggati: la data di quella directory resta sconosciuta.
+func (p *AzureBlobStorageProvider) setDirectoryModTimes(ctx context.Context, items []storage.ItemInfo) {
+	if !p.directoryModTime {
+		return
+	}
+	indexByPath := make(map[string]int)
+	var dirPaths []string
+	for i, item := range items {
+		if item.IsDir {
+			dirPath := strings.TrimSuffix(strings.TrimPrefix(item.Path, "/"), "/")
+			indexByPath[dirPath] = i
+			dirPaths = append(dirPaths, dirPath)
+		}
+	}
+	// Ogni goroutine scrive un elemento diverso di items, quindi non serve un mutex.
+	forEachConcurrently(ctx, dirPaths, func(dirPath string) error {
+		prefix := dirPath
+		if prefix != "" {
+			prefix += "/"
+		}
+		modTime, err := p.latestBlobModTime(ctx, prefix)
+		if err != nil {
+			p.logger.Debugf("Azure Blob: Cannot derive the modification time of directory '%s': %v", dirPath, err)
+			return nil
+		}
+		items[indexByPath[dirPath]].ModTime = modTime
+		return nil
+	})
+}
@@ -0,0 +1,44 @@
+package azureblob
+
+import (
+	"context"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+func TestMoveItemWithResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		blobs       []string
+		srcPath     string
+		asyncCopies bool
+		want        storage.MoveResult
+	}{
+		{name: "blob copied in the response", blobs: []string{"a.txt"}, srcPath: "/a.txt", want: storage.MoveResult{Method: storage.MoveMethodServerCopy, Instant: true}},
+		{name: "blob copied asynchronously", blobs: []string{"a.txt"}, srcPath: "/a.txt", asyncCopies: true, want: storage.MoveResult{Method: storage.MoveMethodServerCopy, PendingCopies: 1}},
+		{name: "directory copied in the response", blobs: []string{"src/a.txt", "src/sub/b.txt"}, srcPath: "/src", want: storage.MoveResult{Method: storage.MoveMethodServerCopy, Instant: true}},
+		{name: "directory copied asynchronously", blobs: []string{"src/a.txt", "src/sub/b.txt"}, srcPath: "/src", asyncCopies: true, want: storage.MoveResult{Method: storage.MoveMethodServerCopy, PendingCopies: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, fake := newMarkerTestProvider(t, tt.blobs, config.DirectoryMarkersPreserve)
+			fake.mu.Lock()
+			fake.asyncCopies = tt.asyncCopies
+			fake.mu.Unlock()
+			result, err := p.MoveItemWithResult(context.Background(), nil, tt.srcPath, "/dst")
+			if err != nil {
+				t.Fatalf("MoveItemWithResult: %v", err)
+			}
+			if *result != tt.want {
+				t.Errorf("result = %+v, want %+v", *result, tt.want)
+			}
+			for _, name := range tt.blobs {
+				if _, ok := fake.blobs[name]; ok {
+					t.Errorf("source blob %s left after the move", name)
+				}
+			}
+		})
+	}
+}
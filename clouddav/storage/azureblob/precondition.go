@@ -0,0 +1,47 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"clouddav/auth"
+	"clouddav/storage"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// DeleteBlobIfMatch deletes the blob at path only if its ETag is still etag (If-Match), so that a blob
+// replaced after the client read it is not deleted. Restituisce storage.ErrPreconditionFailed se l'ETag è
+// cambiato; le directory virtuali non hanno ETag e vanno eliminate con DeleteItem.
+func (p *AzureBlobStorageProvider) DeleteBlobIfMatch(ctx context.Context, claims *auth.UserClaims, path string, etag string) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("AzureBlobStorageProvider.DeleteBlobIfMatch chiamato da utente '%s' per storage '%s', path '%s', ETag %s", userIdent, p.name, path, etag)
+
+	blobPath := strings.TrimPrefix(path, "/")
+	ifMatch := azcore.ETag(etag)
+	_, err := p.containerClient.NewBlobClient(blobPath).Delete(ctx, &blob.DeleteOptions{
+		AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: &ifMatch}},
+	})
+	if err != nil {
+		var storageErr *azcore.ResponseError
+		if errors.As(err, &storageErr) {
+			switch storageErr.StatusCode {
+			case 412:
+				return fmt.Errorf("%w: blob '%s' no longer has ETag %s", storage.ErrPreconditionFailed, blobPath, etag)
+			case 404:
+				return storage.ErrNotFound
+			case 403:
+				return storage.ErrPermissionDenied
+			}
+		}
+		return fmt.Errorf("failed to delete blob '%s': %w", blobPath, err)
+	}
+	p.logger.Infof("Azure Blob: Deleted blob '%s' (If-Match %s)", blobPath, etag)
+	return nil
+}
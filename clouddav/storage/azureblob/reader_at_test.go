@@ -0,0 +1,72 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"clouddav/storage"
+)
+
+// fakeRangeServer serves ranges of a blob, honoring If-Match like Azure.
+type fakeRangeServer struct {
+	mu      sync.Mutex
+	content string
+	etag    string
+	ifMatch []string // If-Match di ogni richiesta
+}
+
+func (f *fakeRangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ifMatch = append(f.ifMatch, r.Header.Get("If-Match"))
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != f.etag {
+		w.Header().Set("x-ms-error-code", "ConditionNotMet")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	var start, end int
+	fmt.Sscanf(strings.TrimPrefix(r.Header.Get("x-ms-range"), "bytes="), "%d-%d", &start, &end)
+	w.Header().Set("ETag", f.etag)
+	w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(f.content)))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write([]byte(f.content[start : end+1]))
+}
+
+func TestBlobReaderAtDetectsOverwrite(t *testing.T) {
+	fake := &fakeRangeServer{content: "0123456789", etag: `"0x1"`}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	containerClient, err := container.NewClientWithNoCredential(server.URL+"/container", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &blobReaderAt{ctx: context.Background(), blobClient: containerClient.NewBlobClient("file.bin"), blobPath: "file.bin", size: 10, etag: `"0x1"`}
+
+	buf := make([]byte, 5)
+	if n, err := reader.ReadAt(buf, 0); err != nil || string(buf[:n]) != "01234" {
+		t.Fatalf("ReadAt(0) = %q, %v; want \"01234\"", buf[:n], err)
+	}
+
+	fake.mu.Lock()
+	fake.content, fake.etag = "abcdefghij", `"0x2"` // Sovrascrittura durante il download
+	fake.mu.Unlock()
+	if n, err := reader.ReadAt(buf, 5); !errors.Is(err, storage.ErrPreconditionFailed) {
+		t.Fatalf("ReadAt(5) after an overwrite = %q, %v; want ErrPreconditionFailed", buf[:n], err)
+	}
+
+	for i, ifMatch := range fake.ifMatch {
+		if ifMatch != `"0x1"` {
+			t.Errorf("request %d sent If-Match %q, want the ETag read at open", i, ifMatch)
+		}
+	}
+}
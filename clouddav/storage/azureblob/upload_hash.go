@@ -0,0 +1,132 @@
+package azureblob
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// uploadHashes keeps the running SHA256 of the block blob uploads in progress (incremental_upload_hash),
+// keyed by upload ID. Ogni blocco viene aggiunto all'hash dopo lo staging solo se arriva in ordine;
+// altrimenti l'hash dell'upload viene invalidato e il finalize torna a riscaricare il blob.
+type uploadHashes struct {
+	mu      sync.Mutex
+	entries map[string]*uploadHashState
+}
+
+// uploadHashState is the running hash of one upload. Lo stato di sha256 è conservato serializzato
+// (encoding.BinaryMarshaler) insieme alla sessione: un upload ripreso dopo una riconnessione continua
+// dal blocco successivo senza rileggere quelli già inviati.
+type uploadHashState struct {
+	mu            sync.Mutex
+	state         []byte // Stato dopo i primi nextIndex blocchi
+	previousState []byte // Stato prima dell'ultimo blocco, per accettare il retry dello stesso blocco
+	nextIndex     int64
+	size          int64
+	previousSize  int64
+	invalid       bool
+}
+
+func newUploadHashes() *uploadHashes {
+	return &uploadHashes{entries: make(map[string]*uploadHashState)}
+}
+
+// add feeds a staged block to the running hash of uploadID. Il blocco 0 avvia un nuovo hash (nuovo
+// upload o ripartenza da zero); il blocco successivo lo estende; il retry dell'ultimo blocco lo sostituisce.
+// Qualsiasi altro indice invalida l'hash fino al prossimo blocco 0.
+func (u *uploadHashes) add(uploadID string, chunkIndex int64, chunk io.ReadSeeker) error {
+	u.mu.Lock()
+	entry, ok := u.entries[uploadID]
+	if !ok || chunkIndex == 0 {
+		entry = &uploadHashState{}
+		u.entries[uploadID] = entry
+	}
+	u.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	var baseState []byte
+	var baseSize int64
+	switch {
+	case chunkIndex == 0:
+		// Hash vuoto.
+	case entry.invalid:
+		return nil
+	case chunkIndex == entry.nextIndex:
+		baseState, baseSize = entry.state, entry.size
+	case chunkIndex == entry.nextIndex-1 && entry.previousState != nil:
+		baseState, baseSize = entry.previousState, entry.previousSize
+	default:
+		entry.invalid = true
+		return fmt.Errorf("block %d received out of order (expected %d)", chunkIndex, entry.nextIndex)
+	}
+
+	hasher := sha256.New()
+	if baseState != nil {
+		if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(baseState); err != nil {
+			entry.invalid = true
+			return fmt.Errorf("failed to restore hash state: %w", err)
+		}
+	}
+	if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+		entry.invalid = true
+		return fmt.Errorf("failed to rewind block %d: %w", chunkIndex, err)
+	}
+	n, err := io.Copy(hasher, chunk)
+	if err != nil {
+		entry.invalid = true
+		return fmt.Errorf("failed to hash block %d: %w", chunkIndex, err)
+	}
+	state, err := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		entry.invalid = true
+		return fmt.Errorf("failed to save hash state: %w", err)
+	}
+
+	entry.previousState, entry.previousSize = baseState, baseSize
+	if entry.previousState == nil {
+		entry.previousState, _ = sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	}
+	entry.state, entry.size = state, baseSize+n
+	entry.nextIndex = chunkIndex + 1
+	return nil
+}
+
+// sum returns the running SHA256 of uploadID if every block from 0 to blockCount-1 was hashed in order
+// and the hashed bytes match size; ok è false quando serve la verifica con download.
+func (u *uploadHashes) sum(uploadID string, blockCount int, size int64) (sha256Hex string, ok bool, reason string) {
+	u.mu.Lock()
+	entry, found := u.entries[uploadID]
+	u.mu.Unlock()
+	if !found {
+		return "", false, "no running hash for this upload"
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	switch {
+	case entry.invalid:
+		return "", false, "blocks received out of order"
+	case entry.nextIndex != int64(blockCount):
+		return "", false, fmt.Sprintf("%d blocks hashed, %d committed", entry.nextIndex, blockCount)
+	case entry.size != size:
+		return "", false, fmt.Sprintf("%d bytes hashed, %d declared", entry.size, size)
+	}
+
+	hasher := sha256.New()
+	if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(entry.state); err != nil {
+		return "", false, fmt.Sprintf("failed to restore hash state: %v", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), true, ""
+}
+
+// discard drops the running hash of uploadID (upload finalizzato o annullato).
+func (u *uploadHashes) discard(uploadID string) {
+	u.mu.Lock()
+	delete(u.entries, uploadID)
+	u.mu.Unlock()
+}
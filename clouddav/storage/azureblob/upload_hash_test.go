@@ -0,0 +1,146 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"clouddav/internal/logging"
+	"clouddav/storage"
+)
+
+func sha256Of(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadHashes(t *testing.T) {
+	blocks := []string{"aaaa", "bbbb", "cc"}
+	tests := []struct {
+		name    string
+		order   []int64 // Indici dei blocchi nell'ordine di arrivo
+		wantOK  bool
+		wantErr bool // Errore atteso dall'ultimo add
+	}{
+		{name: "in order", order: []int64{0, 1, 2}, wantOK: true},
+		{name: "retry of the last block", order: []int64{0, 1, 1, 2}, wantOK: true},
+		{name: "restart from block 0", order: []int64{0, 1, 0, 1, 2}, wantOK: true},
+		{name: "gap", order: []int64{0, 2}, wantErr: true},
+		{name: "out of order", order: []int64{0, 2, 1}},
+		{name: "reversed, then restarted without the last block", order: []int64{2, 1, 0, 1}},
+		{name: "missing last block", order: []int64{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashes := newUploadHashes()
+			var err error
+			for _, index := range tt.order {
+				err = hashes.add("upload", index, strings.NewReader(blocks[index]))
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("last add error = %v, want error %t", err, tt.wantErr)
+			}
+			sum, ok, reason := hashes.sum("upload", len(blocks), int64(len(strings.Join(blocks, ""))))
+			if ok != tt.wantOK {
+				t.Fatalf("sum ok = %t (%s), want %t", ok, reason, tt.wantOK)
+			}
+			if ok && sum != sha256Of(strings.Join(blocks, "")) {
+				t.Errorf("sum = %s, want the SHA256 of the whole file", sum)
+			}
+		})
+	}
+}
+
+func TestUploadHashesSizeMismatchAndDiscard(t *testing.T) {
+	hashes := newUploadHashes()
+	hashes.add("upload", 0, strings.NewReader("abc"))
+	if _, ok, _ := hashes.sum("upload", 1, 4); ok {
+		t.Error("sum accepted a declared size different from the hashed bytes")
+	}
+	hashes.discard("upload")
+	if _, ok, _ := hashes.sum("upload", 1, 3); ok {
+		t.Error("sum found the running hash of a discarded upload")
+	}
+}
+
+// fakeDownloadServer is fakeBlockBlobServer that also serves the committed content and counts the downloads.
+type fakeDownloadServer struct {
+	fakeBlockBlobServer
+	content   string
+	downloads int
+}
+
+func (f *fakeDownloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		f.mu.Lock()
+		f.downloads++
+		f.mu.Unlock()
+		w.Header().Set("ETag", `"0x1"`)
+		w.Header().Set("Content-Length", fmt.Sprint(len(f.content)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(f.content))
+		return
+	}
+	f.fakeBlockBlobServer.ServeHTTP(w, r)
+}
+
+func TestFinalizeUploadWithIncrementalHash(t *testing.T) {
+	const content = "0123456789"
+	tests := []struct {
+		name          string
+		expected      string
+		wantErr       error
+		wantCommits   int
+		wantDownloads int
+	}{
+		{name: "matching hash", expected: sha256Of(content), wantCommits: 1},
+		{name: "mismatching hash", expected: sha256Of("something else"), wantErr: storage.ErrIntegrityCheckFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDownloadServer{content: content}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			containerClient, err := container.NewClientWithNoCredential(server.URL+"/container", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &AzureBlobStorageProvider{
+				name:            "test",
+				logger:          logging.NewStorageLogger("test"),
+				containerClient: containerClient,
+				uploads:         make(map[string]*azureUploadSession),
+				uploadHashes:    newUploadHashes(),
+			}
+			ctx := context.Background()
+			p.uploads["upload"] = &azureUploadSession{blobPath: "file.bin", declaredSize: int64(len(content)), stagedBytes: make(map[int64]int64)}
+
+			var blockIDs []string
+			for i, part := range []string{content[:5], content[5:]} {
+				blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%020d", i)))
+				if err := p.WriteChunk(ctx, nil, "upload", blockID, nopSeekCloser{bytes.NewReader([]byte(part))}, int64(i), storage.ChunkChecksum{}); err != nil {
+					t.Fatalf("WriteChunk(%d): %v", i, err)
+				}
+				blockIDs = append(blockIDs, blockID)
+			}
+
+			err = p.FinalizeUpload(ctx, nil, "upload", "file.bin", blockIDs, tt.expected, int64(len(content)), true, false, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FinalizeUpload = %v, want %v", err, tt.wantErr)
+			}
+			if fake.commits != tt.wantCommits || fake.downloads != tt.wantDownloads {
+				t.Errorf("%d commits and %d downloads, want %d and %d", fake.commits, fake.downloads, tt.wantCommits, tt.wantDownloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"clouddav/internal/logging"
+	"clouddav/storage"
+)
+
+// fakeBlockBlobServer answers Put Block and Put Block List like Azure and counts the requests.
+type fakeBlockBlobServer struct {
+	mu      sync.Mutex
+	staged  int
+	commits int
+}
+
+func (f *fakeBlockBlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "block":
+		f.staged++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "blocklist":
+		f.commits++
+		w.Header().Set("ETag", `"0x1"`)
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+type nopSeekCloser struct{ *bytes.Reader }
+
+func (nopSeekCloser) Close() error { return nil }
+
+func TestStrictUploadSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		declaredSize int64
+		chunks       []int
+		writeErr     error // Errore atteso dall'ultimo chunk
+		finalizeErr  error
+	}{
+		{name: "exact size", declaredSize: 10, chunks: []int{5, 5}},
+		{name: "undersized", declaredSize: 10, chunks: []int{5, 4}, finalizeErr: storage.ErrSizeMismatch},
+		{name: "missing chunk", declaredSize: 10, chunks: []int{5}, finalizeErr: storage.ErrSizeMismatch},
+		{name: "oversized", declaredSize: 10, chunks: []int{5, 5, 1}, writeErr: storage.ErrSizeExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeBlockBlobServer{}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			containerClient, err := container.NewClientWithNoCredential(server.URL+"/container", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &AzureBlobStorageProvider{
+				name:             "test",
+				logger:           logging.NewStorageLogger("test"),
+				containerClient:  containerClient,
+				strictUploadSize: true,
+				uploads:          make(map[string]*azureUploadSession),
+			}
+			ctx := context.Background()
+			p.uploads["upload"] = &azureUploadSession{blobPath: "file.bin", declaredSize: tt.declaredSize, stagedBytes: make(map[int64]int64)}
+
+			var blockIDs []string
+			for i, size := range tt.chunks {
+				blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%020d", i)))
+				chunk := nopSeekCloser{bytes.NewReader(make([]byte, size))}
+				err := p.WriteChunk(ctx, nil, "upload", blockID, chunk, int64(i), storage.ChunkChecksum{})
+				if i == len(tt.chunks)-1 && tt.writeErr != nil {
+					if !errors.Is(err, tt.writeErr) {
+						t.Fatalf("WriteChunk(%d) = %v, want %v", i, err, tt.writeErr)
+					}
+					if fake.staged != i {
+						t.Errorf("%d blocks staged, want %d: the oversized chunk must not be staged", fake.staged, i)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("WriteChunk(%d): %v", i, err)
+				}
+				blockIDs = append(blockIDs, blockID)
+			}
+			if tt.name == "missing chunk" {
+				blockIDs = append(blockIDs, base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%020d", 1))))
+			}
+
+			err = p.FinalizeUpload(ctx, nil, "upload", "file.bin", blockIDs, "", tt.declaredSize, true, false, "")
+			if tt.finalizeErr != nil {
+				if !errors.Is(err, tt.finalizeErr) {
+					t.Fatalf("FinalizeUpload = %v, want %v", err, tt.finalizeErr)
+				}
+				if fake.commits != 0 {
+					t.Errorf("block list committed %d times after a size mismatch, want 0", fake.commits)
+				}
+				if _, err := p.uploadSession("upload"); err != nil {
+					t.Errorf("upload session discarded after a size mismatch: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FinalizeUpload: %v", err)
+			}
+			if fake.commits != 1 {
+				t.Errorf("block list committed %d times, want 1", fake.commits)
+			}
+		})
+	}
+}
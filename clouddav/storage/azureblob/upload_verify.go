@@ -0,0 +1,50 @@
+package azureblob
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+)
+
+// Livelli di verifica del contenuto al finalize, dal più economico al più costoso.
+const (
+	// verificationIncremental: SHA256 calcolato durante lo staging (incremental_upload_hash), confrontato
+	// con quello del client prima del commit.
+	verificationIncremental = "incremental"
+	// verificationBlockMD5: ogni blocco è stato verificato da Azure con il Content-MD5 inviato allo staging
+	// e il commit contiene esattamente i blocchi e i byte della sessione; lo SHA256 è quello dichiarato dal client.
+	verificationBlockMD5 = "block_md5"
+	// verificationDownload: il blob committato viene riscaricato per calcolarne lo SHA256 (strong_verification).
+	verificationDownload = "download"
+)
+
+// blockMD5 returns the MD5 of chunk, sent as Content-MD5 of the block so that Azure rejects a block
+// corrupted in transit. Il chunk viene riportato all'inizio per lo staging.
+func blockMD5(chunk io.ReadSeeker) ([]byte, error) {
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, chunk); err != nil {
+		return nil, err
+	}
+	if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// stagedBlocksMatch reports whether the blocks staged by the session (ognuno verificato con Content-MD5)
+// are exactly blockCount and declaredSize bytes, cioè se il blob committato è quello ricevuto dal client.
+func (p *AzureBlobStorageProvider) stagedBlocksMatch(session *azureUploadSession, blockCount int, declaredSize int64) (bool, string) {
+	p.uploadsMu.Lock()
+	defer p.uploadsMu.Unlock()
+	var stagedSize int64
+	for _, n := range session.stagedBytes {
+		stagedSize += n
+	}
+	switch {
+	case len(session.stagedBytes) != blockCount:
+		return false, fmt.Sprintf("%d blocks staged, %d committed", len(session.stagedBytes), blockCount)
+	case stagedSize != declaredSize:
+		return false, fmt.Sprintf("%d bytes staged, %d declared", stagedSize, declaredSize)
+	}
+	return true, ""
+}
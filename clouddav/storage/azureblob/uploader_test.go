@@ -0,0 +1,143 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"clouddav/auth"
+	"clouddav/internal/logging"
+	"clouddav/storage"
+)
+
+func TestStoredUploader(t *testing.T) {
+	uploadedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		metadata map[string]*string
+		wantBy   string
+		wantAt   *time.Time
+	}{
+		{"none", nil, "", nil},
+		{"both", map[string]*string{uploadedByMetadataKey: to.Ptr("alice@example.com"), uploadedAtMetadataKey: to.Ptr("2024-05-01T12:00:00Z")}, "alice@example.com", &uploadedAt},
+		{"keys in another case", map[string]*string{"Clouddav_Uploaded_By": to.Ptr("alice@example.com"), "CLOUDDAV_UPLOADED_AT": to.Ptr("2024-05-01T12:00:00Z")}, "alice@example.com", &uploadedAt},
+		{"invalid time", map[string]*string{uploadedByMetadataKey: to.Ptr("alice@example.com"), uploadedAtMetadataKey: to.Ptr("yesterday")}, "alice@example.com", nil},
+		{"time without uploader", map[string]*string{uploadedAtMetadataKey: to.Ptr("2024-05-01T12:00:00Z")}, "", nil},
+		{"nil value", map[string]*string{uploadedByMetadataKey: nil}, "", nil},
+	}
+	for _, tt := range tests {
+		gotBy, gotAt := storedUploader(tt.metadata)
+		if gotBy != tt.wantBy || (gotAt == nil) != (tt.wantAt == nil) || (gotAt != nil && !gotAt.Equal(*tt.wantAt)) {
+			t.Errorf("%s: storedUploader = %q, %v, want %q, %v", tt.name, gotBy, gotAt, tt.wantBy, tt.wantAt)
+		}
+	}
+}
+
+// fakeMetadataBlobServer stages blocks, keeps the metadata sent with Put Block List and returns it from
+// Get Blob Properties, come fa Azure con gli header x-ms-meta-*.
+type fakeMetadataBlobServer struct {
+	mu       sync.Mutex
+	size     int
+	metadata http.Header
+}
+
+func (f *fakeMetadataBlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "block":
+		f.size += len(body)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "blocklist":
+		f.metadata = http.Header{}
+		for key, values := range r.Header {
+			if strings.HasPrefix(strings.ToLower(key), "x-ms-meta-") {
+				f.metadata[key] = values
+			}
+		}
+		w.Header().Set("ETag", `"0x1"`)
+		w.Header().Set("Last-Modified", fakeLastModified)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodHead && f.metadata != nil:
+		for key, values := range f.metadata {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(f.size))
+		w.Header().Set("Last-Modified", fakeLastModified)
+		w.Header().Set("ETag", `"0x1"`)
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestUploaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name           string
+		recordUploader bool
+		claims         *auth.UserClaims
+		wantUploadedBy string
+	}{
+		{"authenticated user", true, &auth.UserClaims{Email: "alice@example.com"}, "alice@example.com"},
+		{"anonymous upload", true, nil, storage.AnonymousUploader},
+		{"disabled", false, &auth.UserClaims{Email: "alice@example.com"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMetadataBlobServer{}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			containerClient, err := container.NewClientWithNoCredential(server.URL+"/container", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &AzureBlobStorageProvider{
+				name:            "test",
+				logger:          logging.NewStorageLogger("test"),
+				containerClient: containerClient,
+				recordUploader:  tt.recordUploader,
+				uploads:         make(map[string]*azureUploadSession),
+			}
+			ctx := context.Background()
+			content := []byte("uploaded content")
+			p.uploads["upload"] = &azureUploadSession{blobPath: "file.bin", declaredSize: int64(len(content)), stagedBytes: make(map[int64]int64)}
+			blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%020d", 0)))
+			if err := p.WriteChunk(ctx, tt.claims, "upload", blockID, nopSeekCloser{bytes.NewReader(content)}, 0, storage.ChunkChecksum{}); err != nil {
+				t.Fatalf("WriteChunk: %v", err)
+			}
+			before := time.Now().UTC().Add(-time.Second)
+			if err := p.FinalizeUpload(ctx, tt.claims, "upload", "file.bin", []string{blockID}, "", int64(len(content)), true, false, ""); err != nil {
+				t.Fatalf("FinalizeUpload: %v", err)
+			}
+
+			item, err := p.GetItem(ctx, nil, "/file.bin")
+			if err != nil {
+				t.Fatalf("GetItem: %v", err)
+			}
+			if item.UploadedBy != tt.wantUploadedBy || item.Size != int64(len(content)) {
+				t.Errorf("GetItem = uploaded_by %q, size %d, want %q, %d", item.UploadedBy, item.Size, tt.wantUploadedBy, len(content))
+			}
+			if tt.wantUploadedBy == "" {
+				if item.UploadedAt != nil || len(fake.metadata) != 0 {
+					t.Errorf("uploader recorded with record_uploader disabled: %v, metadata %v", item.UploadedAt, fake.metadata)
+				}
+			} else if item.UploadedAt == nil || item.UploadedAt.Before(before) || item.UploadedAt.After(time.Now().Add(time.Second)) {
+				t.Errorf("UploadedAt = %v, want the time of the commit", item.UploadedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+)
+
+// Operazioni registrate nel change log (Change.Op).
+const (
+	ChangeCreated  = "created"  // Elemento creato (directory, upload senza overwrite, destinazione di move/copy, ripristino dal cestino)
+	ChangeModified = "modified" // File scritto con overwrite: sostituito, o creato se non esisteva
+	ChangeDeleted  = "deleted"  // Elemento eliminato o sorgente di un move (tombstone)
+)
+
+// Change is an entry of the change log of a storage.
+type Change struct {
+	Seq  uint64    `json:"seq"`
+	Op   string    `json:"op"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+// ChangeLog records the changes made through the server to a storage, with monotonic sequence numbers, so
+// that list_directory with since_seq can return only what changed in a directory. Conserva le ultime
+// maxEntries modifiche: un cursore più vecchio (floor) non può essere servito e il client deve rileggere
+// la directory. Con un file le modifiche vengono accodate in JSON lines e ricaricate all'avvio; senza, la
+// numerazione parte dal tempo di avvio in microsecondi, così i cursori di un'esecuzione precedente
+// risultano troppo vecchi invece di collidere con i nuovi.
+type ChangeLog struct {
+	mu         sync.Mutex
+	changes    []Change // Ordinate per Seq
+	floor      uint64   // Since è completo solo per seq >= floor
+	lastSeq    uint64
+	maxEntries int
+	filePath   string
+	file       *os.File
+	fileLines  int
+	// storageName è passato al ChangeListener; vuoto per i change log aperti fuori da ChangeLogFor.
+	storageName string
+}
+
+// ChangeListener is called after every change recorded in a change log of the registry (es. per la notifica
+// directory_changed ai client che stanno guardando la directory). Non deve bloccare: gira nella richiesta
+// che ha fatto la modifica.
+type ChangeListener func(storageName string, change Change)
+
+var changeListener atomic.Pointer[ChangeListener]
+
+// SetChangeListener sets the listener of the recorded changes (nil lo rimuove).
+func SetChangeListener(listener ChangeListener) {
+	if listener == nil {
+		changeListener.Store(nil)
+		return
+	}
+	changeListener.Store(&listener)
+}
+
+// OpenChangeLog opens the change log persisted in filePath ("" = solo in memoria), keeping at most
+// maxEntries changes.
+func OpenChangeLog(filePath string, maxEntries int) (*ChangeLog, error) {
+	l := &ChangeLog{maxEntries: maxEntries, filePath: filePath}
+	if filePath != "" {
+		if err := l.load(); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("opening change log '%s': %w", filePath, err)
+		}
+		l.file = file
+	}
+	if len(l.changes) == 0 && l.lastSeq == 0 {
+		l.lastSeq = uint64(time.Now().UnixMicro())
+		l.floor = l.lastSeq
+	}
+	return l, nil
+}
+
+// load reads the persisted changes, tolerating a truncated last line (scrittura interrotta).
+func (l *ChangeLog) load() error {
+	file, err := os.Open(l.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening change log '%s': %w", l.filePath, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var change Change
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil || change.Seq <= l.lastSeq {
+			continue
+		}
+		l.changes = append(l.changes, change)
+		l.lastSeq = change.Seq
+		l.fileLines++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading change log '%s': %w", l.filePath, err)
+	}
+	if len(l.changes) > 0 {
+		l.floor = l.changes[0].Seq - 1
+	}
+	l.trim()
+	return nil
+}
+
+// Record appends a change of itemPath. Un errore di scrittura del file viene solo registrato nel log: la
+// modifica è già avvenuta sullo storage.
+func (l *ChangeLog) Record(op string, itemPath string) Change {
+	change := l.record(op, itemPath)
+	if listener := changeListener.Load(); listener != nil && l.storageName != "" {
+		(*listener)(l.storageName, change)
+	}
+	return change
+}
+
+func (l *ChangeLog) record(op string, itemPath string) Change {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastSeq++
+	change := Change{Seq: l.lastSeq, Op: op, Path: cleanChangePath(itemPath), Time: time.Now().UTC()}
+	l.changes = append(l.changes, change)
+	l.trim()
+
+	if l.file != nil {
+		line, _ := json.Marshal(change)
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			log.Printf("Error writing change log '%s': %v", l.filePath, err)
+		}
+		l.fileLines++
+		if l.fileLines > 2*l.maxEntries {
+			l.compact()
+		}
+	}
+	return change
+}
+
+// trim drops the oldest changes beyond maxEntries, a blocchi per non copiare la slice a ogni Record.
+func (l *ChangeLog) trim() {
+	if len(l.changes) <= l.maxEntries+l.maxEntries/4 {
+		return
+	}
+	drop := len(l.changes) - l.maxEntries
+	l.floor = l.changes[drop-1].Seq
+	l.changes = append([]Change(nil), l.changes[drop:]...)
+}
+
+// compact rewrites the file with the changes still retained.
+func (l *ChangeLog) compact() {
+	tmpPath := l.filePath + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Error compacting change log '%s': %v", l.filePath, err)
+		return
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, change := range l.changes {
+		encoder.Encode(change)
+	}
+	err = writer.Flush()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, l.filePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		log.Printf("Error compacting change log '%s': %v", l.filePath, err)
+		return
+	}
+	file, err := os.OpenFile(l.filePath, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Error reopening change log '%s': %v", l.filePath, err)
+		l.file.Close()
+		l.file = nil
+		return
+	}
+	l.file.Close()
+	l.file = file
+	l.fileLines = len(l.changes)
+}
+
+// CurrentSeq returns the sequence number of the last change: il cursore da restituire con un elenco
+// completo, letto prima dell'elenco perché le modifiche concorrenti vengano riproposte, non perse.
+func (l *ChangeLog) CurrentSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastSeq
+}
+
+// Since returns the changes of the direct children of dirPath after seq, only the last one per path, and
+// the current sequence number. ok è false quando le modifiche non sono più tutte disponibili (seq più
+// vecchio del log o di un'esecuzione senza file) oppure dirPath, o una directory che lo contiene, è stata
+// creata, eliminata o spostata dopo seq: il client deve rileggere la directory.
+func (l *ChangeLog) Since(dirPath string, seq uint64) ([]Change, uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq < l.floor || seq > l.lastSeq {
+		return nil, l.lastSeq, false
+	}
+	dirPath = cleanChangePath(dirPath)
+	start := sort.Search(len(l.changes), func(i int) bool { return l.changes[i].Seq > seq })
+	latest := make(map[string]int)
+	var changes []Change
+	for _, change := range l.changes[start:] {
+		if change.Op != ChangeModified && isSameOrAncestorPath(change.Path, dirPath) {
+			return nil, l.lastSeq, false
+		}
+		if change.Path == "/" || path.Dir(change.Path) != dirPath {
+			continue
+		}
+		if i, seen := latest[change.Path]; seen {
+			changes[i] = change
+			continue
+		}
+		latest[change.Path] = len(changes)
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Seq < changes[j].Seq })
+	return changes, l.lastSeq, true
+}
+
+// Close closes the file of the change log.
+func (l *ChangeLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func cleanChangePath(itemPath string) string {
+	return path.Clean("/" + strings.ReplaceAll(itemPath, "\\", "/"))
+}
+
+// isSameOrAncestorPath reports whether candidate is dirPath or one of its parent directories.
+func isSameOrAncestorPath(candidate string, dirPath string) bool {
+	return candidate == dirPath || candidate == "/" || strings.HasPrefix(dirPath, candidate+"/")
+}
+
+// --- Registro dei change log ---
+
+var (
+	changeLogs       = make(map[string]*ChangeLog)
+	changeLogsMu     sync.Mutex
+	changeLogsConfig config.ChangeLogConfig
+)
+
+// InitChangeLogs sets change_log, read only at startup, and creates its directory.
+func InitChangeLogs(cfg config.ChangeLogConfig) error {
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+			return fmt.Errorf("creating change_log.dir '%s': %w", cfg.Dir, err)
+		}
+	}
+	changeLogsMu.Lock()
+	changeLogsConfig = cfg
+	changeLogsMu.Unlock()
+	return nil
+}
+
+// ChangeLogFor returns the change log of a storage, creating it the first time: sopravvive alla
+// ricreazione del provider al reload della configurazione.
+func ChangeLogFor(storageName string) (*ChangeLog, error) {
+	changeLogsMu.Lock()
+	defer changeLogsMu.Unlock()
+	if changeLog, ok := changeLogs[storageName]; ok {
+		return changeLog, nil
+	}
+	filePath := ""
+	if changeLogsConfig.Dir != "" {
+		filePath = filepath.Join(changeLogsConfig.Dir, url.PathEscape(storageName)+".changes.jsonl")
+	}
+	maxEntries := changeLogsConfig.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	changeLog, err := OpenChangeLog(filePath, maxEntries)
+	if err != nil {
+		return nil, err
+	}
+	changeLog.storageName = storageName
+	changeLogs[storageName] = changeLog
+	return changeLog, nil
+}
+
+// GetChangeLog returns the change log of a storage, if it exists.
+func GetChangeLog(storageName string) (*ChangeLog, bool) {
+	changeLogsMu.Lock()
+	defer changeLogsMu.Unlock()
+	changeLog, ok := changeLogs[storageName]
+	return changeLog, ok
+}
+
+// RecordChange records a change made outside of the StorageProvider interface (upload, move di Azure con
+// risultato, ripristino dal cestino), se lo storage ha un change log.
+func RecordChange(storageName string, op string, itemPath string) {
+	if changeLog, ok := GetChangeLog(storageName); ok {
+		changeLog.Record(op, itemPath)
+	}
+}
+
+// CloseChangeLogs closes the files of all the change logs (shutdown).
+func CloseChangeLogs() {
+	changeLogsMu.Lock()
+	defer changeLogsMu.Unlock()
+	for name, changeLog := range changeLogs {
+		if err := changeLog.Close(); err != nil {
+			log.Printf("Error closing the change log of storage '%s': %v", name, err)
+		}
+	}
+}
+
+// ChangeLoggingProvider records in a ChangeLog the changes made through the StorageProvider interface.
+type ChangeLoggingProvider struct {
+	StorageProvider
+	changeLog *ChangeLog
+}
+
+// NewChangeLoggingProvider returns provider recording its changes in changeLog.
+func NewChangeLoggingProvider(provider StorageProvider, changeLog *ChangeLog) StorageProvider {
+	return &ChangeLoggingProvider{StorageProvider: provider, changeLog: changeLog}
+}
+
+// Unwrap returns the wrapped provider.
+func (p *ChangeLoggingProvider) Unwrap() StorageProvider {
+	return p.StorageProvider
+}
+
+func (p *ChangeLoggingProvider) CreateDirectory(ctx context.Context, claims *auth.UserClaims, path string) error {
+	if err := p.StorageProvider.CreateDirectory(ctx, claims, path); err != nil {
+		return err
+	}
+	p.changeLog.Record(ChangeCreated, path)
+	return nil
+}
+
+func (p *ChangeLoggingProvider) DeleteItem(ctx context.Context, claims *auth.UserClaims, path string) error {
+	if err := p.StorageProvider.DeleteItem(ctx, claims, path); err != nil {
+		return err
+	}
+	p.changeLog.Record(ChangeDeleted, path)
+	return nil
+}
+
+func (p *ChangeLoggingProvider) MoveItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	if err := p.StorageProvider.MoveItem(ctx, claims, srcPath, dstPath); err != nil {
+		return err
+	}
+	p.changeLog.Record(ChangeDeleted, srcPath)
+	p.changeLog.Record(ChangeCreated, dstPath)
+	return nil
+}
+
+func (p *ChangeLoggingProvider) CopyItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	if err := p.StorageProvider.CopyItem(ctx, claims, srcPath, dstPath); err != nil {
+		return err
+	}
+	p.changeLog.Record(ChangeCreated, dstPath)
+	return nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// ChunkChecksum is the optional checksum of a single upload chunk sent by the client (chunk_md5,
+// chunk_crc32c, in esadecimale). Un chunk che non corrisponde viene rifiutato con ErrChunkChecksumMismatch
+// e non risulta ricevuto, così il client può reinviare solo quello.
+type ChunkChecksum struct {
+	MD5    string
+	CRC32C string
+}
+
+// ParseChunkChecksum validates the hex checksums of a chunk; empty values are not checked.
+func ParseChunkChecksum(md5Hex string, crc32cHex string) (ChunkChecksum, error) {
+	checksum := ChunkChecksum{MD5: strings.ToLower(strings.TrimSpace(md5Hex)), CRC32C: strings.ToLower(strings.TrimSpace(crc32cHex))}
+	if checksum.MD5 != "" {
+		if decoded, err := hex.DecodeString(checksum.MD5); err != nil || len(decoded) != md5.Size {
+			return ChunkChecksum{}, fmt.Errorf("%w: chunk_md5 must be %d hex digits", ErrInvalidChunk, md5.Size*2)
+		}
+	}
+	if checksum.CRC32C != "" {
+		if decoded, err := hex.DecodeString(checksum.CRC32C); err != nil || len(decoded) != crc32.Size {
+			return ChunkChecksum{}, fmt.Errorf("%w: chunk_crc32c must be %d hex digits", ErrInvalidChunk, crc32.Size*2)
+		}
+	}
+	return checksum, nil
+}
+
+// IsZero reports whether no checksum was sent for the chunk.
+func (c ChunkChecksum) IsZero() bool {
+	return c.MD5 == "" && c.CRC32C == ""
+}
+
+// ChunkVerifier computes the checksums of a chunk while it is written.
+type ChunkVerifier struct {
+	expected ChunkChecksum
+	md5      hash.Hash
+	crc32c   hash.Hash32
+	writer   io.Writer
+}
+
+// NewVerifier returns a verifier for c: i byte del chunk vanno scritti nel verifier (es. con io.TeeReader)
+// e poi confrontati con Verify.
+func (c ChunkChecksum) NewVerifier() *ChunkVerifier {
+	v := &ChunkVerifier{expected: c}
+	var writers []io.Writer
+	if c.MD5 != "" {
+		v.md5 = md5.New()
+		writers = append(writers, v.md5)
+	}
+	if c.CRC32C != "" {
+		v.crc32c = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		writers = append(writers, v.crc32c)
+	}
+	v.writer = io.MultiWriter(writers...)
+	return v
+}
+
+func (v *ChunkVerifier) Write(p []byte) (int, error) {
+	return v.writer.Write(p)
+}
+
+// Verify compares the checksums of the bytes written with the expected ones.
+func (v *ChunkVerifier) Verify(chunkIndex int64) error {
+	if v.md5 != nil {
+		if actual := hex.EncodeToString(v.md5.Sum(nil)); actual != v.expected.MD5 {
+			return fmt.Errorf("%w: chunk %d has MD5 %s, expected %s", ErrChunkChecksumMismatch, chunkIndex, actual, v.expected.MD5)
+		}
+	}
+	if v.crc32c != nil {
+		if actual := fmt.Sprintf("%08x", v.crc32c.Sum32()); actual != v.expected.CRC32C {
+			return fmt.Errorf("%w: chunk %d has CRC32C %s, expected %s", ErrChunkChecksumMismatch, chunkIndex, actual, v.expected.CRC32C)
+		}
+	}
+	return nil
+}
+
+// VerifyChunk checks the checksums of a chunk already read in memory.
+func (c ChunkChecksum) VerifyChunk(chunkIndex int64, data []byte) error {
+	if c.IsZero() {
+		return nil
+	}
+	v := c.NewVerifier()
+	v.Write(data)
+	return v.Verify(chunkIndex)
+}
@@ -0,0 +1,277 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// stubRecordEnv names the file where the stub command records its invocations; se è impostata, il binario
+// di test si comporta da comando dello storage invece di eseguire i test (vedi runStub).
+const stubRecordEnv = "CLOUDDAV_COMMAND_STUB_RECORD"
+
+// invocation is what the stub command received: the arguments, the request line and the rest of stdin.
+type invocation struct {
+	Args    []string `json:"args"`
+	Request request  `json:"request"`
+	Body    string   `json:"body"`
+}
+
+func TestMain(m *testing.M) {
+	if recordPath := os.Getenv(stubRecordEnv); recordPath != "" {
+		os.Exit(runStub(recordPath))
+	}
+	os.Exit(m.Run())
+}
+
+// runStub implements the command protocol for the tests. Il comportamento dipende dal path della
+// richiesta: /missing, /denied, /exists e /dir escono con i codici del protocollo, /fail con un codice
+// generico, /slow non risponde; per gli altri path list, stat e get restituiscono dati fissi.
+func runStub(recordPath string) int {
+	stdin := bufio.NewReader(os.Stdin)
+	line, err := stdin.ReadBytes('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "no request line:", err)
+		return 1
+	}
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid request:", err)
+		return 1
+	}
+	body, _ := io.ReadAll(stdin)
+	record, _ := json.Marshal(invocation{Args: os.Args[1:], Request: req, Body: string(body)})
+	f, err := os.OpenFile(recordPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	f.Write(append(record, '\n'))
+	f.Close()
+
+	switch req.Path {
+	case "/missing":
+		return exitNotFound
+	case "/denied":
+		return exitPermissionDenied
+	case "/exists":
+		return exitAlreadyExists
+	case "/dir":
+		return exitIsDirectory
+	case "/fail":
+		fmt.Fprint(os.Stderr, "backend unavailable")
+		return 7
+	case "/slow":
+		time.Sleep(10 * time.Second)
+		return 0
+	}
+	switch req.Operation {
+	case "list":
+		fmt.Print(`{"items":[{"name":"a.txt","size":3,"mod_time":"2024-01-02T15:04:05Z"},{"name":"sub","is_dir":true},` +
+			`{"name":"../escape"},{"name":"x/y"},{"name":".."},{"name":""}]}`)
+	case "stat":
+		fmt.Printf(`{"item":{"name":%q,"size":3}}`, filepath.Base(req.Path))
+	case "get":
+		fmt.Print("content of " + req.Path)
+	}
+	return 0
+}
+
+// newStubProvider creates a provider whose commands run the stub with the given extra arguments.
+func newStubProvider(t *testing.T, args ...string) (*CommandStorageProvider, func() []invocation) {
+	t.Helper()
+	recordPath := filepath.Join(t.TempDir(), "invocations.jsonl")
+	t.Setenv(stubRecordEnv, recordPath)
+	stub, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	argv := append([]string{stub}, args...)
+	cfg := &config.StorageConfig{Name: "tape", Type: "command"}
+	cfg.UploadTempDir = t.TempDir()
+	cfg.CommandTimeout = "2s"
+	cfg.TransferTimeout = "2s"
+	cfg.Commands = config.CommandSet{List: argv, Stat: argv, Get: argv, Put: argv, Delete: argv, Mkdir: argv, Move: argv, Copy: argv}
+	p, err := NewProvider(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	invocations := func() []invocation {
+		data, err := os.ReadFile(recordPath)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		var result []invocation
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var inv invocation
+			if err := json.Unmarshal([]byte(line), &inv); err != nil {
+				t.Fatalf("invalid invocation record %q: %v", line, err)
+			}
+			result = append(result, inv)
+		}
+		return result
+	}
+	return p, invocations
+}
+
+func TestCommandArgumentsAndRequest(t *testing.T) {
+	args := []string{"--bucket", "$HOME", "a b; rm -rf /", "`id`"}
+	p, invocations := newStubProvider(t, args...)
+	claims := &auth.UserClaims{Subject: "sub-1", Email: "user@example.com", GroupNames: []string{"staff"}}
+
+	if err := p.MoveItem(context.Background(), claims, "/docs/a.txt", "/archive/a.txt"); err != nil {
+		t.Fatalf("MoveItem: %v", err)
+	}
+	got := invocations()
+	if len(got) != 1 {
+		t.Fatalf("%d invocations, want 1", len(got))
+	}
+	// Gli argomenti arrivano letterali: nessuna shell espande variabili, separatori o sostituzioni.
+	if !reflect.DeepEqual(got[0].Args, args) {
+		t.Errorf("args = %q, want %q", got[0].Args, args)
+	}
+	req := got[0].Request
+	if req.Operation != "move" || req.Storage != "tape" || req.Path != "/docs/a.txt" || req.Destination != "/archive/a.txt" {
+		t.Errorf("request = %+v", req)
+	}
+	if req.User == nil || req.User.Email != "user@example.com" || req.User.Subject != "sub-1" || !reflect.DeepEqual(req.User.GroupNames, []string{"staff"}) {
+		t.Errorf("request user = %+v", req.User)
+	}
+}
+
+func TestCommandPathSanitization(t *testing.T) {
+	p, invocations := newStubProvider(t)
+	tests := []struct {
+		path     string
+		wantPath string // "" se il path va rifiutato senza eseguire il comando
+	}{
+		{"docs//a/./b.txt", "/docs/a/b.txt"},
+		{`\docs\a.txt`, "/docs/a.txt"},
+		{"/docs/a.txt/", "/docs/a.txt"},
+		{"/$(touch pwned); echo `id` > x", "/$(touch pwned); echo `id` > x"},
+		{"/docs/../../etc/passwd", ""},
+		{"..", ""},
+		{`\..\secret`, ""},
+		{"/docs/a\x00b", ""},
+		{"/docs/a\nb", ""},
+	}
+	for _, tt := range tests {
+		before := len(invocations())
+		err := p.DeleteItem(context.Background(), nil, tt.path)
+		after := invocations()
+		if tt.wantPath == "" {
+			if !errors.Is(err, storage.ErrPermissionDenied) || len(after) != before {
+				t.Errorf("DeleteItem(%q) = %v with %d invocations, want ErrPermissionDenied without running the command", tt.path, err, len(after)-before)
+			}
+			continue
+		}
+		if err != nil || len(after) != before+1 {
+			t.Errorf("DeleteItem(%q) = %v with %d invocations, want one invocation", tt.path, err, len(after)-before)
+			continue
+		}
+		if got := after[len(after)-1].Request.Path; got != tt.wantPath {
+			t.Errorf("DeleteItem(%q) sent path %q, want %q", tt.path, got, tt.wantPath)
+		}
+	}
+	if err := p.CopyItem(context.Background(), nil, "/docs/a.txt", "/docs/../../b.txt"); !errors.Is(err, storage.ErrPermissionDenied) {
+		t.Errorf("CopyItem to a traversing destination = %v, want ErrPermissionDenied", err)
+	}
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	p, _ := newStubProvider(t)
+	for _, tt := range []struct {
+		path string
+		want error
+	}{
+		{"/missing", storage.ErrNotFound},
+		{"/denied", storage.ErrPermissionDenied},
+		{"/exists", storage.ErrAlreadyExists},
+		{"/dir", storage.ErrIsDirectory},
+	} {
+		if _, err := p.GetItem(context.Background(), nil, tt.path); !errors.Is(err, tt.want) {
+			t.Errorf("GetItem(%s) = %v, want %v", tt.path, err, tt.want)
+		}
+	}
+
+	err := p.CreateDirectory(context.Background(), nil, "/fail")
+	if err == nil || !strings.Contains(err.Error(), "exit code 7") || !strings.Contains(err.Error(), "backend unavailable") {
+		t.Errorf("CreateDirectory(/fail) = %v, want the exit code and stderr", err)
+	}
+
+	p.timeout = 200 * time.Millisecond
+	start := time.Now()
+	err = p.DeleteItem(context.Background(), nil, "/slow")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("DeleteItem(/slow) = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out command returned after %v", elapsed)
+	}
+}
+
+func TestCommandListAndGet(t *testing.T) {
+	p, _ := newStubProvider(t)
+	listing, err := p.ListItems(context.Background(), nil, "/docs", 1, 10, "", nil, false, false)
+	if err != nil {
+		t.Fatalf("ListItems: %v", err)
+	}
+	var names []string
+	for _, item := range listing.Items {
+		names = append(names, item.Path)
+	}
+	// Le directory vengono prima; i nomi che non sono un singolo segmento vengono scartati.
+	if want := []string{"/docs/sub", "/docs/a.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %q, want %q", names, want)
+	}
+
+	reader, err := p.OpenReader(context.Background(), nil, "/docs/a.txt")
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(data) != "content of /docs/a.txt" {
+		t.Errorf("read %q, %v", data, err)
+	}
+	if _, err := p.OpenReader(context.Background(), nil, "/missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("OpenReader(/missing) = %v, want ErrNotFound before any content", err)
+	}
+}
+
+func TestCommandUploadSendsContent(t *testing.T) {
+	p, invocations := newStubProvider(t)
+	content := []byte("uploaded content")
+	if _, err := p.InitiateUpload(context.Background(), nil, "up-1", "/new.txt", int64(len(content)), 8); err != nil {
+		t.Fatalf("InitiateUpload: %v", err)
+	}
+	for i := 0; i*8 < len(content); i++ {
+		chunk := content[i*8 : min((i+1)*8, len(content))]
+		if err := p.WriteChunk(context.Background(), nil, "up-1", chunk, int64(i), 8, storage.ChunkChecksum{}); err != nil {
+			t.Fatalf("WriteChunk %d: %v", i, err)
+		}
+	}
+	if err := p.FinalizeUpload(context.Background(), nil, "up-1", "/new.txt", "", true); err != nil {
+		t.Fatalf("FinalizeUpload: %v", err)
+	}
+	got := invocations()
+	last := got[len(got)-1]
+	if last.Request.Operation != "put" || last.Request.Path != "/new.txt" || last.Request.Size != int64(len(content)) || last.Body != string(content) {
+		t.Errorf("put invocation = %+v, body %q; want %d bytes of %q", last.Request, last.Body, len(content), content)
+	}
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"clouddav/auth"
+	"clouddav/config"
+)
+
+// Strategie di conflict_strategy di move_item e copy_item, applicate quando la destinazione esiste già.
+const (
+	ConflictFail      = "fail"      // Errore ErrAlreadyExists (comportamento predefinito)
+	ConflictOverwrite = "overwrite" // La destinazione viene eliminata e sostituita
+	ConflictMerge     = "merge"     // Le directory vengono unite, i file in conflitto seguono file_conflict
+	ConflictRename    = "rename"    // L'elemento viene trasferito con un nome libero (upload_auto_rename.pattern)
+	ConflictSkip      = "skip"      // Solo come file_conflict di un merge: l'elemento resta nella sorgente
+)
+
+// Esiti per elemento di TransferWithConflicts (TransferOutcome.Outcome).
+const (
+	OutcomeTransferred = "transferred"
+	OutcomeOverwritten = "overwritten"
+	OutcomeRenamed     = "renamed"
+	OutcomeSkipped     = "skipped"
+	OutcomeFailed      = "failed"
+)
+
+// maxTransferTreeItems is the maximum number of items of the source, and of the destination, of a merge.
+const maxTransferTreeItems = 100000
+
+// ErrDestinationInsideSource is returned when an item would be moved or copied into itself.
+var ErrDestinationInsideSource = errors.New("destination is inside the source")
+
+// ErrTreeTooLarge is returned when a merge involves more than maxTransferTreeItems items.
+var ErrTreeTooLarge = errors.New("directory tree too large")
+
+// TransferOutcome is the result of the transfer of a single item of TransferWithConflicts.
+type TransferOutcome struct {
+	SourcePath      string `json:"source_path"`
+	DestinationPath string `json:"destination_path"`
+	Outcome         string `json:"outcome"`
+	Error           string `json:"error,omitempty"`
+}
+
+// treeTransfer carries the state of a TransferWithConflicts.
+type treeTransfer struct {
+	ctx          context.Context
+	provider     StorageProvider
+	claims       *auth.UserClaims
+	move         bool
+	fileConflict string
+	rename       config.UploadAutoRenameConfig
+	outcomes     []TransferOutcome
+}
+
+// TransferWithConflicts moves (move true) or copies srcPath to dstPath with MoveItem/CopyItem, resolving a
+// destination that already exists with strategy. Con ConflictMerge due directory vengono unite: il
+// contenuto della sorgente viene trasferito nella destinazione elemento per elemento, le sottodirectory
+// presenti in entrambe vengono unite a loro volta e i conflitti con un file si risolvono con fileConflict
+// (overwrite, rename, skip o fail). Le sottodirectory assenti nella destinazione vengono trasferite intere,
+// con una sola operazione. Nello spostamento le directory della sorgente rimaste vuote vengono eliminate.
+//
+// Gli alberi di sorgente e destinazione vengono letti con Walk prima di trasferire qualcosa. Gli errori dei
+// singoli elementi di un merge sono riportati negli esiti; l'errore restituito riguarda l'operazione intera
+// (es. ErrAlreadyExists con ConflictFail, ErrNotFound se srcPath non esiste).
+func TransferWithConflicts(ctx context.Context, provider StorageProvider, claims *auth.UserClaims, srcPath string, dstPath string, move bool, strategy string, fileConflict string, rename config.UploadAutoRenameConfig) ([]TransferOutcome, error) {
+	cleanSrc, cleanDst := path.Clean("/"+srcPath), path.Clean("/"+dstPath)
+	if cleanDst == cleanSrc || strings.HasPrefix(cleanDst, strings.TrimSuffix(cleanSrc, "/")+"/") {
+		return nil, fmt.Errorf("%w: '%s' into '%s'", ErrDestinationInsideSource, srcPath, dstPath)
+	}
+	t := &treeTransfer{ctx: ctx, provider: provider, claims: claims, move: move, fileConflict: fileConflict, rename: rename}
+
+	srcInfo, err := provider.GetItem(ctx, claims, srcPath)
+	if err != nil {
+		return nil, err
+	}
+	dstInfo, err := provider.GetItem(ctx, claims, dstPath)
+	if errors.Is(err, ErrNotFound) {
+		if err := t.transfer(srcPath, dstPath); err != nil {
+			return nil, err
+		}
+		t.record(srcPath, dstPath, OutcomeTransferred, nil)
+		return t.outcomes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if strategy == ConflictMerge {
+		if srcInfo.IsDir && dstInfo.IsDir {
+			return t.outcomes, t.merge(srcPath, dstPath)
+		}
+		strategy = fileConflict
+	}
+	exists := func(candidate string) bool {
+		_, err := provider.GetItem(ctx, claims, candidate)
+		return !errors.Is(err, ErrNotFound)
+	}
+	outcome, resolvedPath, err := t.resolve(strategy, srcPath, dstPath, exists)
+	if err != nil {
+		return nil, err
+	}
+	t.record(srcPath, resolvedPath, outcome, nil)
+	return t.outcomes, nil
+}
+
+// transfer moves or copies a single item to a destination that does not exist.
+func (t *treeTransfer) transfer(srcPath string, dstPath string) error {
+	if t.move {
+		return t.provider.MoveItem(t.ctx, t.claims, srcPath, dstPath)
+	}
+	return t.provider.CopyItem(t.ctx, t.claims, srcPath, dstPath)
+}
+
+func (t *treeTransfer) record(srcPath string, dstPath string, outcome string, err error) {
+	entry := TransferOutcome{SourcePath: srcPath, DestinationPath: dstPath, Outcome: outcome}
+	if err != nil {
+		entry.Outcome, entry.Error = OutcomeFailed, err.Error()
+	}
+	t.outcomes = append(t.outcomes, entry)
+}
+
+// resolve transfers srcPath onto the existing dstPath according to strategy and returns the outcome and
+// the final destination. exists reports whether a candidate name of ConflictRename is taken.
+func (t *treeTransfer) resolve(strategy string, srcPath string, dstPath string, exists func(string) bool) (string, string, error) {
+	switch strategy {
+	case ConflictOverwrite:
+		if err := t.provider.DeleteItem(t.ctx, t.claims, dstPath); err != nil && !errors.Is(err, ErrNotFound) {
+			return "", dstPath, fmt.Errorf("removing '%s' to overwrite it: %w", dstPath, err)
+		}
+		return OutcomeOverwritten, dstPath, t.transfer(srcPath, dstPath)
+	case ConflictRename:
+		for n := 1; n <= t.rename.MaxAttempts; n++ {
+			if candidate := t.rename.Candidate(dstPath, n); !exists(candidate) {
+				return OutcomeRenamed, candidate, t.transfer(srcPath, candidate)
+			}
+		}
+		return "", dstPath, fmt.Errorf("%w: no free name for '%s' after %d attempts", ErrAlreadyExists, dstPath, t.rename.MaxAttempts)
+	case ConflictSkip:
+		return OutcomeSkipped, dstPath, nil
+	default:
+		return "", dstPath, fmt.Errorf("%w: '%s'", ErrAlreadyExists, dstPath)
+	}
+}
+
+// walkTree returns the items under basePath with their relative paths, directories before their content.
+func (t *treeTransfer) walkTree(basePath string) ([]ItemInfo, []string, error) {
+	var items []ItemInfo
+	var relatives []string
+	err := t.provider.Walk(t.ctx, t.claims, basePath, 0, func(item ItemInfo, relative string) error {
+		if len(items) >= maxTransferTreeItems {
+			return fmt.Errorf("%w: more than %d items under '%s'", ErrTreeTooLarge, maxTransferTreeItems, basePath)
+		}
+		items = append(items, item)
+		relatives = append(relatives, relative)
+		return nil
+	})
+	return items, relatives, err
+}
+
+// merge transfers the content of the directory srcPath into the existing directory dstPath.
+func (t *treeTransfer) merge(srcPath string, dstPath string) error {
+	srcItems, srcRelatives, err := t.walkTree(srcPath)
+	if err != nil {
+		return err
+	}
+	dstItems, dstRelatives, err := t.walkTree(dstPath)
+	if err != nil {
+		return err
+	}
+	dstIsDir := make(map[string]bool, len(dstRelatives))
+	for i, relative := range dstRelatives {
+		dstIsDir[relative] = dstItems[i].IsDir
+	}
+
+	handled := make(map[string]bool) // Directory della sorgente trasferite o saltate per intero
+	mergedDirs := []string{""}       // Directory presenti in entrambi gli alberi, da eliminare se svuotate
+	for i, item := range srcItems {
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
+		relative := srcRelatives[i]
+		if ancestorHandled(handled, relative) {
+			continue
+		}
+		itemSrc, itemDst := path.Join(srcPath, relative), path.Join(dstPath, relative)
+		isDir, exists := dstIsDir[relative]
+		switch {
+		case !exists:
+			t.record(itemSrc, itemDst, OutcomeTransferred, t.transfer(itemSrc, itemDst))
+		case item.IsDir && isDir:
+			mergedDirs = append(mergedDirs, relative)
+			continue
+		default:
+			relativeOf := func(candidate string) string {
+				return strings.TrimPrefix(strings.TrimPrefix(candidate, strings.TrimSuffix(dstPath, "/")), "/")
+			}
+			outcome, resolvedPath, err := t.resolve(t.fileConflict, itemSrc, itemDst, func(candidate string) bool {
+				_, taken := dstIsDir[relativeOf(candidate)]
+				return taken
+			})
+			if outcome == OutcomeRenamed && err == nil {
+				// Il nome scelto non è più libero per gli elementi successivi.
+				dstIsDir[relativeOf(resolvedPath)] = item.IsDir
+			}
+			t.record(itemSrc, resolvedPath, outcome, err)
+		}
+		if item.IsDir {
+			handled[relative] = true
+		}
+	}
+
+	if t.move {
+		// Dalla più profonda: Walk elenca le directory prima del loro contenuto.
+		for i := len(mergedDirs) - 1; i >= 0; i-- {
+			t.removeIfEmpty(path.Join(srcPath, mergedDirs[i]))
+		}
+	}
+	return nil
+}
+
+// removeIfEmpty deletes a source directory of a merge left without items (nessun elemento saltato o fallito).
+func (t *treeTransfer) removeIfEmpty(dirPath string) {
+	listing, err := t.provider.ListItems(t.ctx, t.claims, dirPath, 1, 1, "", nil, false, false)
+	if err != nil || listing.TotalItems > 0 {
+		return
+	}
+	if err := t.provider.DeleteItem(t.ctx, t.claims, dirPath); err != nil && !errors.Is(err, ErrNotFound) {
+		t.record(dirPath, "", OutcomeFailed, fmt.Errorf("removing the emptied source directory: %w", err))
+	}
+}
+
+// ancestorHandled reports whether a parent directory of relative is in handled.
+func ancestorHandled(handled map[string]bool, relative string) bool {
+	for dir := path.Dir(relative); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if handled[dir] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"clouddav/storage"
+)
+
+const (
+	defaultEndpoint  = "https://storage.googleapis.com"
+	storageScope     = "https://www.googleapis.com/auth/devstorage.read_write"
+	maxErrorBodySize = 4096
+)
+
+// newTokenSource resolves the credentials with golang.org/x/oauth2/google: the configured credentials
+// file, otherwise the Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS, il file di gcloud,
+// poi il metadata server di GCE, GKE e Cloud Run). I token vengono riusati fino a poco prima della scadenza.
+func newTokenSource(httpClient *http.Client, credentialsPath string) (oauth2.TokenSource, error) {
+	// Il contesto resta legato al token source per tutta la vita del provider: non usa quello di avvio.
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+	if credentialsPath == "" {
+		creds, err := google.FindDefaultCredentials(ctx, storageScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find GCS default credentials: %w", err)
+		}
+		return creds.TokenSource, nil
+	}
+
+	data, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GCS credentials file '%s': %w", credentialsPath, err)
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, storageScope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GCS credentials file '%s': %w", credentialsPath, err)
+	}
+	return creds.TokenSource, nil
+}
+
+// apiClient sends authenticated requests to the Cloud Storage JSON API of one bucket.
+type apiClient struct {
+	httpClient *http.Client
+	endpoint   string
+	bucket     string
+	tokens     oauth2.TokenSource // nil con l'emulatore (STORAGE_EMULATOR_HOST): nessuna autenticazione
+}
+
+// objectURL returns the URL of an object resource (metadata, or the content with alt=media).
+func (c *apiClient) objectURL(objectName string, query url.Values) string {
+	u := c.endpoint + "/storage/v1/b/" + url.PathEscape(c.bucket) + "/o/" + url.PathEscape(objectName)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (c *apiClient) listURL(query url.Values) string {
+	return c.endpoint + "/storage/v1/b/" + url.PathEscape(c.bucket) + "/o?" + query.Encode()
+}
+
+func (c *apiClient) uploadURL(query url.Values) string {
+	return c.endpoint + "/upload/storage/v1/b/" + url.PathEscape(c.bucket) + "/o?" + query.Encode()
+}
+
+func (c *apiClient) rewriteURL(srcObject string, dstObject string, rewriteToken string) string {
+	u := c.endpoint + "/storage/v1/b/" + url.PathEscape(c.bucket) + "/o/" + url.PathEscape(srcObject) +
+		"/rewriteTo/b/" + url.PathEscape(c.bucket) + "/o/" + url.PathEscape(dstObject)
+	if rewriteToken != "" {
+		u += "?" + url.Values{"rewriteToken": {rewriteToken}}.Encode()
+	}
+	return u
+}
+
+// do sends a request with the access token. Le risposte con status fuori da expected vengono
+// chiuse e convertite in errore (vedi statusError).
+func (c *apiClient) do(ctx context.Context, method string, rawURL string, body io.Reader, header http.Header, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.tokens != nil {
+		token, err := c.tokens.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain GCS access token: %w", err)
+		}
+		token.SetAuthHeader(req)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	return nil, statusError(resp)
+}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON response into out (if not nil).
+func (c *apiClient) doJSON(ctx context.Context, method string, rawURL string, in interface{}, out interface{}) error {
+	var body io.Reader
+	header := http.Header{}
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(ctx, method, rawURL, body, header, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid GCS response for %s %s: %w", method, rawURL, err)
+	}
+	return nil
+}
+
+// apiError is an error response of the JSON API.
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("GCS request failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap maps the HTTP status to the common storage errors, so errors.Is works on them.
+func (e *apiError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return storage.ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return storage.ErrPermissionDenied
+	case http.StatusPreconditionFailed:
+		return storage.ErrAlreadyExists
+	}
+	return nil
+}
+
+func statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	var parsed struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &parsed) == nil && parsed.Error.Message != "" {
+		message = parsed.Error.Message
+	}
+	return &apiError{StatusCode: resp.StatusCode, Message: message}
+}
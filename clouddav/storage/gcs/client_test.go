@@ -0,0 +1,149 @@
+package gcs
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTokenServer is an OAuth2 token endpoint that records the grants it receives.
+type fakeTokenServer struct {
+	mu     sync.Mutex
+	grants []map[string]string
+}
+
+func (f *fakeTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	grant := map[string]string{}
+	for key := range r.PostForm {
+		grant[key] = r.PostForm.Get(key)
+	}
+	f.mu.Lock()
+	f.grants = append(f.grants, grant)
+	count := len(f.grants)
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, count)
+}
+
+// writeCredentials writes a credentials file with the given fields and returns its path.
+func writeCredentials(t *testing.T, fields map[string]string) string {
+	t.Helper()
+	data, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestServiceAccountTokenSource(t *testing.T) {
+	fake := &fakeTokenServer{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	path := writeCredentials(t, map[string]string{
+		"type":           "service_account",
+		"client_email":   "clouddav@project.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key":    string(pemKey),
+		"token_uri":      server.URL,
+	})
+
+	tokens, err := newTokenSource(server.Client(), path)
+	if err != nil {
+		t.Fatalf("newTokenSource: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if token, err := tokens.Token(); err != nil || token.AccessToken != "token-1" {
+			t.Fatalf("Token %d = %v, %v; want the cached token-1", i, token, err)
+		}
+	}
+
+	if len(fake.grants) != 1 {
+		t.Fatalf("token endpoint called %d times, want 1", len(fake.grants))
+	}
+	grant := fake.grants[0]
+	if grant["grant_type"] != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		t.Errorf("grant_type = %q", grant["grant_type"])
+	}
+	parts := strings.Split(grant["assertion"], ".")
+	if len(parts) != 3 {
+		t.Fatalf("assertion %q is not a JWT", grant["assertion"])
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != "clouddav@project.iam.gserviceaccount.com" || claims["scope"] != storageScope || claims["aud"] != server.URL {
+		t.Errorf("JWT claims = %v, want the service account, the storage scope and the token URI", claims)
+	}
+}
+
+func TestAuthorizedUserTokenSource(t *testing.T) {
+	fake := &fakeTokenServer{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	path := writeCredentials(t, map[string]string{
+		"type":          "authorized_user",
+		"client_id":     "client",
+		"client_secret": "secret",
+		"refresh_token": "refresh",
+		"token_uri":     server.URL,
+	})
+
+	tokens, err := newTokenSource(server.Client(), path)
+	if err != nil {
+		t.Fatalf("newTokenSource: %v", err)
+	}
+	if token, err := tokens.Token(); err != nil || token.AccessToken != "token-1" {
+		t.Fatalf("Token = %v, %v; want token-1", token, err)
+	}
+	if len(fake.grants) != 1 || fake.grants[0]["grant_type"] != "refresh_token" || fake.grants[0]["refresh_token"] != "refresh" {
+		t.Errorf("grants = %v, want one refresh_token grant", fake.grants)
+	}
+}
+
+func TestInvalidCredentialsFile(t *testing.T) {
+	for _, fields := range []map[string]string{
+		{"type": "unknown"},
+		{"type": "service_account", "client_email": "a@b", "private_key": "not a key"},
+	} {
+		path := writeCredentials(t, fields)
+		tokens, err := newTokenSource(http.DefaultClient, path)
+		if err == nil {
+			_, err = tokens.Token()
+		}
+		if err == nil {
+			t.Errorf("credentials %v accepted", fields)
+		}
+	}
+	if _, err := newTokenSource(http.DefaultClient, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing credentials file accepted")
+	}
+}
@@ -0,0 +1,887 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/logging"
+	"clouddav/storage"
+)
+
+// GCSStorageProvider implements the StorageProvider interface for a Google Cloud Storage bucket.
+// Come per Azure Blob le directory sono virtuali: prefissi che terminano con "/" e, per le directory
+// vuote create dall'applicazione, un oggetto marker vuoto "dir/".
+//
+// Il provider usa direttamente la JSON API di Cloud Storage. Con la variabile STORAGE_EMULATOR_HOST
+// (la stessa delle librerie client Google) le richieste vanno all'emulatore indicato, senza autenticazione.
+type GCSStorageProvider struct {
+	name             string
+	logger           *logging.StorageLogger
+	maxUploadBytes   int64 // Dimensione massima di un upload (0 = nessun limite)
+	bucket           string
+	client           *apiClient
+	storeChecksums   bool  // Salva lo SHA256 verificato nei metadata dell'oggetto
+	recordUploader   bool  // Salva chi ha caricato l'oggetto e quando nei metadata
+	blockSize        int64 // Dimensione dei range per i download a blocchi
+	strictUploadSize bool
+	uploads          map[string]*uploadSession
+	uploadsMu        sync.Mutex
+}
+
+// defaultDownloadBlockSize is the range size used for block-aligned downloads when not configured.
+const defaultDownloadBlockSize = 4 << 20
+
+// listPageSize is the maxResults of each listing request.
+const listPageSize = 1000
+
+// object is the subset of the object resource of the JSON API used by the provider.
+type object struct {
+	Name       string            `json:"name"`
+	Size       string            `json:"size"`
+	Generation string            `json:"generation"`
+	Updated    time.Time         `json:"updated"`
+	CRC32C     string            `json:"crc32c"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+func (o *object) size() int64 {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return size
+}
+
+type listResponse struct {
+	Items         []object `json:"items"`
+	Prefixes      []string `json:"prefixes"`
+	NextPageToken string   `json:"nextPageToken"`
+}
+
+// NewProvider creates a new GCSStorageProvider. Come per Azure, si esegue un listing di prova legato
+// a ctx, così credenziali errate o un bucket inesistente fanno fallire l'avvio entro il timeout.
+func NewProvider(ctx context.Context, cfg *config.StorageConfig) (*GCSStorageProvider, error) {
+	if cfg.Type != "gcs" {
+		return nil, errors.New("invalid storage config type for gcs provider")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("gcs storage bucket is required")
+	}
+
+	httpClient := &http.Client{}
+	client := &apiClient{httpClient: httpClient, endpoint: defaultEndpoint, bucket: cfg.Bucket}
+	if emulatorHost := os.Getenv("STORAGE_EMULATOR_HOST"); emulatorHost != "" {
+		if !strings.Contains(emulatorHost, "://") {
+			emulatorHost = "http://" + emulatorHost
+		}
+		client.endpoint = strings.TrimSuffix(emulatorHost, "/")
+		log.Printf("GCS: Storage '%s' uses the emulator at %s (STORAGE_EMULATOR_HOST), without authentication.", cfg.Name, client.endpoint)
+	} else {
+		tokens, err := newTokenSource(httpClient, cfg.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		client.tokens = tokens
+	}
+
+	if err := probeBucket(ctx, client); err != nil {
+		return nil, fmt.Errorf("failed to access GCS bucket '%s': %w", cfg.Bucket, err)
+	}
+
+	blockSize := int64(defaultDownloadBlockSize)
+	if cfg.DownloadBlockSizeMB > 0 {
+		blockSize = int64(cfg.DownloadBlockSizeMB) << 20
+	}
+
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("GCS: Provider '%s' initialized for bucket '%s'.", cfg.Name, cfg.Bucket)
+	}
+
+	return &GCSStorageProvider{
+		name:             cfg.Name,
+		logger:           logging.NewStorageLogger(cfg.Name),
+		maxUploadBytes:   cfg.MaxUploadBytes,
+		bucket:           cfg.Bucket,
+		client:           client,
+		storeChecksums:   cfg.StoreChecksums,
+		recordUploader:   cfg.RecordUploader,
+		blockSize:        blockSize,
+		strictUploadSize: cfg.StrictUploadSize,
+		uploads:          make(map[string]*uploadSession),
+	}, nil
+}
+
+// Type returns the storage type.
+func (p *GCSStorageProvider) Type() string {
+	return "gcs"
+}
+
+// Name returns the configured name.
+func (p *GCSStorageProvider) Name() string {
+	return p.name
+}
+
+func userIdentOf(claims *auth.UserClaims) string {
+	if claims != nil {
+		return claims.Email
+	}
+	return "unauthenticated"
+}
+
+// dirPrefix returns the listing prefix of a directory path ("" for the bucket root).
+func dirPrefix(path string) string {
+	prefix := strings.TrimPrefix(path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// listAll lists every object and prefix under prefix; with delimiter "/" only the direct children.
+func (p *GCSStorageProvider) listAll(ctx context.Context, prefix string, delimiter string, visit func(list *listResponse)) error {
+	return p.listPages(ctx, prefix, delimiter, func(list *listResponse) bool {
+		visit(list)
+		return true
+	})
+}
+
+// listPages is listAll with early termination: il listing si ferma quando visit restituisce false.
+func (p *GCSStorageProvider) listPages(ctx context.Context, prefix string, delimiter string, visit func(list *listResponse) bool) error {
+	query := url.Values{
+		"prefix":     {prefix},
+		"maxResults": {strconv.Itoa(listPageSize)},
+		"fields":     {"items(name,size,updated),prefixes,nextPageToken"},
+	}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	for {
+		var list listResponse
+		if err := p.client.doJSON(ctx, http.MethodGet, p.client.listURL(query), nil, &list); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to list objects with prefix '%s': %w", prefix, err)
+		}
+		if !visit(&list) || list.NextPageToken == "" {
+			return nil
+		}
+		query.Set("pageToken", list.NextPageToken)
+	}
+}
+
+// ListItems lists objects and virtual directories in a given path (prefix).
+func (p *GCSStorageProvider) ListItems(ctx context.Context, claims *auth.UserClaims, path string, page int, itemsPerPage int, nameFilter string, modTimeRange *storage.ModTimeRange, onlyDirectories bool, onlyFiles bool) (*storage.ListItemsResponse, error) {
+	p.logger.Infof("GCSStorageProvider.ListItems chiamato da utente '%s' per storage '%s', path '%s', page %d, itemsPerPage %d, nameFilter '%s', onlyDirectories: %t, onlyFiles: %t", userIdentOf(claims), p.name, path, page, itemsPerPage, nameFilter, onlyDirectories, onlyFiles)
+
+	prefix := dirPrefix(path)
+	var nameRegexp *regexp.Regexp
+	if nameFilter != "" {
+		var err error
+		if nameRegexp, err = regexp.Compile(nameFilter); err != nil {
+			return nil, fmt.Errorf("invalid name filter: %w", err)
+		}
+	}
+
+	allFilteredItems := []storage.ItemInfo{}
+	err := p.listAll(ctx, prefix, "/", func(list *listResponse) {
+		if !onlyFiles {
+			for _, dirPrefix := range list.Prefixes {
+				name := strings.TrimSuffix(strings.TrimPrefix(dirPrefix, prefix), "/")
+				if name == "" || (nameRegexp != nil && !nameRegexp.MatchString(name)) {
+					continue
+				}
+				allFilteredItems = append(allFilteredItems, storage.ItemInfo{
+					Name:  name,
+					IsDir: true,
+					Path:  strings.TrimSuffix(dirPrefix, "/"),
+				})
+			}
+		}
+		if onlyDirectories {
+			return
+		}
+		for _, obj := range list.Items {
+			name := strings.TrimPrefix(obj.Name, prefix)
+			// Il marker della directory stessa ("dir/") non è un elemento della directory.
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			if nameRegexp != nil && !nameRegexp.MatchString(name) {
+				continue
+			}
+			if !modTimeRange.Contains(obj.Updated) {
+				continue
+			}
+			allFilteredItems = append(allFilteredItems, storage.ItemInfo{
+				Name:    name,
+				Size:    obj.size(),
+				ModTime: obj.Updated,
+				Path:    obj.Name,
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(allFilteredItems, func(i, j int) bool {
+		if allFilteredItems[i].IsDir != allFilteredItems[j].IsDir {
+			return allFilteredItems[i].IsDir
+		}
+		return allFilteredItems[i].Name < allFilteredItems[j].Name
+	})
+
+	totalItems := len(allFilteredItems)
+	startIndex := (page - 1) * itemsPerPage
+	endIndex := startIndex + itemsPerPage
+	if startIndex >= totalItems {
+		return &storage.ListItemsResponse{
+			Items:        []storage.ItemInfo{},
+			TotalItems:   totalItems,
+			Page:         page,
+			ItemsPerPage: itemsPerPage,
+		}, nil
+	}
+	if endIndex > totalItems {
+		endIndex = totalItems
+	}
+
+	p.logger.Debugf("GCS: Returning %d items for page %d (total filtered: %d, onlyDirs: %t)", endIndex-startIndex, page, totalItems, onlyDirectories)
+	return &storage.ListItemsResponse{
+		Items:        allFilteredItems[startIndex:endIndex],
+		TotalItems:   totalItems,
+		Page:         page,
+		ItemsPerPage: itemsPerPage,
+	}, nil
+}
+
+// CountItems counts the objects and prefixes directly under path, fermando il listing oltre maxCount.
+func (p *GCSStorageProvider) CountItems(ctx context.Context, claims *auth.UserClaims, path string, nameFilter string, maxCount int) (int, bool, error) {
+	p.logger.Infof("GCSStorageProvider.CountItems chiamato da utente '%s' per storage '%s', path '%s', nameFilter '%s'", userIdentOf(claims), p.name, path, nameFilter)
+
+	prefix := dirPrefix(path)
+	var nameRegexp *regexp.Regexp
+	if nameFilter != "" {
+		var err error
+		if nameRegexp, err = regexp.Compile(nameFilter); err != nil {
+			return 0, false, fmt.Errorf("invalid name filter: %w", err)
+		}
+	}
+
+	count := 0
+	exact := true
+	// add counts a name of the listing; false quando il limite è superato.
+	add := func(name string) bool {
+		if name == "" || strings.Contains(name, "/") || (nameRegexp != nil && !nameRegexp.MatchString(name)) {
+			return true
+		}
+		if count == maxCount {
+			exact = false
+			return false
+		}
+		count++
+		return true
+	}
+	err := p.listPages(ctx, prefix, "/", func(list *listResponse) bool {
+		for _, dirPrefix := range list.Prefixes {
+			if !add(strings.TrimSuffix(strings.TrimPrefix(dirPrefix, prefix), "/")) {
+				return false
+			}
+		}
+		for _, obj := range list.Items {
+			if !add(strings.TrimPrefix(obj.Name, prefix)) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return count, exact, nil
+}
+
+// getObject returns the metadata of an object.
+func (p *GCSStorageProvider) getObject(ctx context.Context, objectName string) (*object, error) {
+	var obj object
+	if err := p.client.doJSON(ctx, http.MethodGet, p.client.objectURL(objectName, nil), nil, &obj); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// GetItem retrieves information about a single object or virtual directory.
+func (p *GCSStorageProvider) GetItem(ctx context.Context, claims *auth.UserClaims, path string) (*storage.ItemInfo, error) {
+	p.logger.Infof("GCSStorageProvider.GetItem chiamato da utente '%s' per storage '%s', path '%s'", userIdentOf(claims), p.name, path)
+
+	objectName := strings.TrimPrefix(path, "/")
+	if objectName != "" {
+		obj, err := p.getObject(ctx, objectName)
+		if err == nil {
+			itemInfo := &storage.ItemInfo{
+				Name:    filepath.Base(path),
+				Size:    obj.size(),
+				ModTime: obj.Updated,
+				Path:    path,
+			}
+			if p.storeChecksums {
+				itemInfo.SHA256 = storedChecksum(obj.Metadata, itemInfo.Size)
+			}
+			if p.recordUploader {
+				itemInfo.UploadedBy, itemInfo.UploadedAt = storedUploader(obj.Metadata)
+			}
+			return itemInfo, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("failed to get object metadata for '%s': %w", objectName, err)
+		}
+	}
+
+	// Nessun oggetto con questo nome: è una directory se esiste almeno un oggetto con il prefisso.
+	var list listResponse
+	query := url.Values{"prefix": {dirPrefix(objectName)}, "maxResults": {"1"}, "fields": {"items/name"}}
+	if err := p.client.doJSON(ctx, http.MethodGet, p.client.listURL(query), nil, &list); err != nil {
+		return nil, fmt.Errorf("failed to check virtual directory '%s': %w", objectName, err)
+	}
+	if len(list.Items) > 0 || objectName == "" {
+		return &storage.ItemInfo{
+			Name:  filepath.Base(path),
+			IsDir: true,
+			Path:  path,
+		}, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+// OpenReader opens an object for reading, returning an io.ReadCloser.
+func (p *GCSStorageProvider) OpenReader(ctx context.Context, claims *auth.UserClaims, path string) (io.ReadCloser, error) {
+	p.logger.Infof("GCSStorageProvider.OpenReader chiamato da utente '%s' per storage '%s', path '%s'", userIdentOf(claims), p.name, path)
+
+	itemInfo, err := p.GetItem(ctx, claims, path)
+	if err != nil {
+		return nil, err
+	}
+	if itemInfo.IsDir {
+		return nil, storage.ErrIsDirectory
+	}
+
+	objectName := strings.TrimPrefix(path, "/")
+	resp, err := p.client.do(ctx, http.MethodGet, p.client.objectURL(objectName, url.Values{"alt": {"media"}}), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object '%s': %w", objectName, err)
+	}
+	return resp.Body, nil
+}
+
+// objectReaderAt reads ranges of an object with one request per ReadAt call.
+type objectReaderAt struct {
+	ctx        context.Context
+	client     *apiClient
+	objectName string
+	size       int64
+}
+
+func (r *objectReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	count := int64(len(buf))
+	if off+count > r.size {
+		count = r.size - off
+	}
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+count-1))
+	resp, err := r.client.do(r.ctx, http.MethodGet, r.client.objectURL(r.objectName, url.Values{"alt": {"media"}}), nil, header, http.StatusPartialContent, http.StatusOK)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download range %d-%d of object '%s': %w", off, off+count-1, r.objectName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && off > 0 {
+		return 0, fmt.Errorf("range %d-%d of object '%s' not honoured by the server", off, off+count-1, r.objectName)
+	}
+
+	n, err := io.ReadFull(resp.Body, buf[:count])
+	if err != nil {
+		return n, fmt.Errorf("failed to read range %d-%d of object '%s': %w", off, off+count-1, r.objectName, err)
+	}
+	if count < int64(len(buf)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *objectReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *objectReaderAt) Close() error {
+	return nil
+}
+
+// OpenReaderAt opens an object for random access reads. Ogni ReadAt è una richiesta di range separata,
+// quindi i chiamanti dovrebbero leggere blocchi ampi (vedi Capabilities().BlockSize).
+func (p *GCSStorageProvider) OpenReaderAt(ctx context.Context, claims *auth.UserClaims, path string) (storage.ReaderAtCloser, error) {
+	itemInfo, err := p.GetItem(ctx, claims, path)
+	if err != nil {
+		return nil, err
+	}
+	if itemInfo.IsDir {
+		return nil, storage.ErrIsDirectory
+	}
+	return &objectReaderAt{
+		ctx:        ctx,
+		client:     p.client,
+		objectName: strings.TrimPrefix(path, "/"),
+		size:       itemInfo.Size,
+	}, nil
+}
+
+// Capabilities reports random access support and the block size used for ranged downloads.
+func (p *GCSStorageProvider) Capabilities() storage.Capabilities {
+	return storage.Capabilities{RandomAccess: true, BlockSize: p.blockSize}
+}
+
+// probeBucket lists at most one object of the bucket, to verify credentials and reachability.
+func probeBucket(ctx context.Context, client *apiClient) error {
+	var list listResponse
+	probeURL := client.listURL(url.Values{"maxResults": {"1"}, "fields": {"items/name"}})
+	return client.doJSON(ctx, http.MethodGet, probeURL, nil, &list)
+}
+
+// HealthCheck runs the same probe as NewProvider.
+func (p *GCSStorageProvider) HealthCheck(ctx context.Context) error {
+	if err := probeBucket(ctx, p.client); err != nil {
+		return fmt.Errorf("failed to access GCS bucket '%s': %w", p.bucket, err)
+	}
+	return nil
+}
+
+// CreateDirectory creates a virtual directory by uploading an empty marker object "dir/".
+func (p *GCSStorageProvider) CreateDirectory(ctx context.Context, claims *auth.UserClaims, path string) error {
+	p.logger.Infof("GCSStorageProvider.CreateDirectory chiamato da utente '%s' per storage '%s', path '%s'", userIdentOf(claims), p.name, path)
+
+	markerName := dirPrefix(path)
+	if markerName == "" {
+		return storage.ErrAlreadyExists
+	}
+	if _, err := p.GetItem(ctx, claims, strings.TrimSuffix(markerName, "/")); err == nil {
+		return storage.ErrAlreadyExists
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to check for existing virtual directory '%s': %w", markerName, err)
+	}
+
+	// ifGenerationMatch=0: la richiesta fallisce (412) se il marker è stato creato nel frattempo.
+	uploadURL := p.client.uploadURL(url.Values{"uploadType": {"media"}, "name": {markerName}, "ifGenerationMatch": {"0"}})
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-directory")
+	resp, err := p.client.do(ctx, http.MethodPost, uploadURL, http.NoBody, header, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("failed to create virtual directory marker '%s': %w", markerName, err)
+	}
+	resp.Body.Close()
+
+	p.logger.Debugf("GCS: Created virtual directory marker object '%s'", markerName)
+	return nil
+}
+
+// deleteObject deletes an object; an object already gone is not an error.
+func (p *GCSStorageProvider) deleteObject(ctx context.Context, objectName string) error {
+	err := p.client.doJSON(ctx, http.MethodDelete, p.client.objectURL(objectName, nil), nil, nil)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to delete object '%s': %w", objectName, err)
+	}
+	return nil
+}
+
+// forEachConcurrently runs fn on every name with bounded concurrency and returns the first error.
+// Se workers non è nil ogni goroutine occupa anche uno slot di quel pool globale (storage.DeleteWorkers).
+func forEachConcurrently(ctx context.Context, names []string, workers *storage.WorkerPool, fn func(name string) error) error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(names))
+	maxConcurrency := runtime.NumCPU() * 4
+	if maxConcurrency == 0 {
+		maxConcurrency = 4
+	}
+	sem := make(chan struct{}, maxConcurrency)
+
+	for _, name := range names {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case sem <- struct{}{}:
+			if workers != nil {
+				if err := workers.Acquire(ctx); err != nil {
+					<-sem
+					wg.Wait()
+					return err
+				}
+			}
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if workers != nil {
+					defer workers.Release()
+				}
+				if err := fn(name); err != nil {
+					errChan <- err
+				}
+			}(name)
+		}
+	}
+	wg.Wait()
+	close(errChan)
+	for err := range errChan {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listObjectNames returns the names of every object under prefix, including the directory marker.
+func (p *GCSStorageProvider) listObjectNames(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := p.listAll(ctx, prefix, "", func(list *listResponse) {
+		for _, obj := range list.Items {
+			names = append(names, obj.Name)
+		}
+	})
+	return names, err
+}
+
+// GetUsedBytes returns the total size of the objects in the bucket.
+func (p *GCSStorageProvider) GetUsedBytes(ctx context.Context, claims *auth.UserClaims) (int64, error) {
+	var used int64
+	err := p.listAll(ctx, "", "", func(list *listResponse) {
+		for i := range list.Items {
+			used += list.Items[i].size()
+		}
+	})
+	return used, err
+}
+
+// CanWrite asks the bucket which of the object permissions needed by an upload the credentials hold
+// (testIamPermissions), una chiamata di sola lettura che non crea oggetti. Non tiene conto delle condizioni
+// IAM sul nome dell'oggetto.
+func (p *GCSStorageProvider) CanWrite(ctx context.Context, claims *auth.UserClaims, path string) (bool, error) {
+	required := []string{"storage.objects.create", "storage.objects.delete"} // delete per sovrascrivere
+	query := url.Values{"permissions": required}
+	rawURL := p.client.endpoint + "/storage/v1/b/" + url.PathEscape(p.client.bucket) + "/iam/testPermissions?" + query.Encode()
+	var result struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := p.client.doJSON(ctx, http.MethodGet, rawURL, nil, &result); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to test permissions on bucket '%s': %w", p.client.bucket, err)
+	}
+	return len(result.Permissions) == len(required), nil
+}
+
+// GetDirectorySize sums the size of the objects under the prefix of path, come il provider Azure: i marker
+// "dir/" non contano come file e un prefisso senza oggetti è una directory inesistente.
+func (p *GCSStorageProvider) GetDirectorySize(ctx context.Context, claims *auth.UserClaims, path string) (int64, int64, error) {
+	prefix := dirPrefix(path)
+	var totalBytes, fileCount int64
+	found := prefix == ""
+	err := p.listAll(ctx, prefix, "", func(list *listResponse) {
+		for i := range list.Items {
+			found = true
+			if strings.HasSuffix(list.Items[i].Name, "/") {
+				continue
+			}
+			totalBytes += list.Items[i].size()
+			fileCount++
+		}
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return 0, 0, storage.ErrNotFound
+	}
+	return totalBytes, fileCount, nil
+}
+
+// Search lists every object under basePath and matches the names client-side, come il provider Azure.
+func (p *GCSStorageProvider) Search(ctx context.Context, claims *auth.UserClaims, basePath string, pattern string, modTimeRange *storage.ModTimeRange, maxResults int) ([]storage.ItemInfo, error) {
+	p.logger.Infof("GCSStorageProvider.Search chiamato da utente '%s' per storage '%s', basePath '%s', pattern '%s', maxResults %d", userIdentOf(claims), p.name, basePath, pattern, maxResults)
+
+	prefix := dirPrefix(basePath)
+	search, err := storage.NewPrefixSearch(prefix, pattern, modTimeRange, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	err = p.listPages(ctx, prefix, "", func(list *listResponse) bool {
+		for i := range list.Items {
+			search.Add(list.Items[i].Name, list.Items[i].size(), list.Items[i].Updated)
+		}
+		return !search.Full()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return search.Items(), nil
+}
+
+// Walk visits the objects under basePath with a flat listing of the prefix, come il provider Azure.
+func (p *GCSStorageProvider) Walk(ctx context.Context, claims *auth.UserClaims, basePath string, maxDepth int, fn storage.WalkFunc) error {
+	p.logger.Infof("GCSStorageProvider.Walk chiamato da utente '%s' per storage '%s', basePath '%s', maxDepth %d", userIdentOf(claims), p.name, basePath, maxDepth)
+
+	prefix := dirPrefix(basePath)
+	walk := storage.NewPrefixWalk(prefix, maxDepth, fn)
+	var walkErr error
+	err := p.listPages(ctx, prefix, "", func(list *listResponse) bool {
+		for i := range list.Items {
+			if walkErr = walk.Add(list.Items[i].Name, list.Items[i].size(), list.Items[i].Updated); walkErr != nil {
+				return false
+			}
+		}
+		return !walk.Done()
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	if err != nil {
+		return err
+	}
+	if prefix != "" && !walk.Found() {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteItem deletes an object or all objects under a prefix (for virtual directories).
+func (p *GCSStorageProvider) DeleteItem(ctx context.Context, claims *auth.UserClaims, path string) error {
+	p.logger.Infof("GCSStorageProvider.DeleteItem chiamato da utente '%s' per storage '%s', path '%s'", userIdentOf(claims), p.name, path)
+
+	objectName := strings.TrimPrefix(path, "/")
+	itemInfo, err := p.GetItem(ctx, claims, path)
+	if err != nil {
+		return err
+	}
+	if !itemInfo.IsDir {
+		if err := p.client.doJSON(ctx, http.MethodDelete, p.client.objectURL(objectName, nil), nil, nil); err != nil {
+			return fmt.Errorf("failed to delete object '%s': %w", objectName, err)
+		}
+		p.logger.Infof("GCS: Deleted object '%s'", objectName)
+		return nil
+	}
+
+	prefix := dirPrefix(objectName)
+	if prefix == "" {
+		return fmt.Errorf("%w: cannot delete the bucket root", storage.ErrPermissionDenied)
+	}
+	objectsToDelete, err := p.listObjectNames(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if len(objectsToDelete) == 0 {
+		return storage.ErrNotFound
+	}
+	p.logger.Infof("GCS: Deleting virtual directory '%s' (%d objects) in bucket '%s'", prefix, len(objectsToDelete), p.bucket)
+	if err := forEachConcurrently(ctx, objectsToDelete, storage.DeleteWorkers, func(name string) error {
+		return p.deleteObject(ctx, name)
+	}); err != nil {
+		return err
+	}
+	p.logger.Infof("GCS: Virtual directory deletion complete for prefix '%s'", prefix)
+	return nil
+}
+
+// MoveItem moves an object, or every object under a virtual directory prefix, with a server-side
+// rewrite followed by the deletion of the source. Se la cancellazione fallisce la sorgente resta
+// al suo posto accanto alla copia, non si perdono dati.
+func (p *GCSStorageProvider) MoveItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	p.logger.Infof("GCSStorageProvider.MoveItem chiamato da utente '%s' per storage '%s', da '%s' a '%s'", userIdentOf(claims), p.name, srcPath, dstPath)
+	return p.transferItem(ctx, claims, srcPath, dstPath, true)
+}
+
+// CopyItem copies an object, or every object under a virtual directory prefix, with server-side
+// rewrites: i dati non transitano dal server.
+func (p *GCSStorageProvider) CopyItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	p.logger.Infof("GCSStorageProvider.CopyItem chiamato da utente '%s' per storage '%s', da '%s' a '%s'", userIdentOf(claims), p.name, srcPath, dstPath)
+	return p.transferItem(ctx, claims, srcPath, dstPath, false)
+}
+
+// transferItem copies (and with deleteSource moves) an object or a virtual directory.
+func (p *GCSStorageProvider) transferItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string, deleteSource bool) error {
+	operation := "copy"
+	if deleteSource {
+		operation = "move"
+	}
+	srcObject := strings.Trim(srcPath, "/")
+	dstObject := strings.Trim(dstPath, "/")
+	if dstObject == "" || (deleteSource && srcObject == "") {
+		return fmt.Errorf("%w: cannot %s the bucket root", storage.ErrPermissionDenied, operation)
+	}
+
+	srcInfo, err := p.GetItem(ctx, claims, srcObject)
+	if err != nil {
+		return err
+	}
+	if _, err := p.GetItem(ctx, claims, dstObject); err == nil {
+		return storage.ErrAlreadyExists
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to check destination '%s': %w", dstObject, err)
+	}
+
+	if !srcInfo.IsDir {
+		return p.transferObject(ctx, srcObject, dstObject, deleteSource)
+	}
+
+	srcPrefix := dirPrefix(srcObject)
+	dstPrefix := dstObject + "/"
+	if strings.HasPrefix(dstPrefix, srcPrefix) {
+		return fmt.Errorf("cannot %s directory '%s' into itself", operation, srcPath)
+	}
+	objectsToTransfer, err := p.listObjectNames(ctx, srcPrefix)
+	if err != nil {
+		return err
+	}
+	if len(objectsToTransfer) == 0 {
+		return storage.ErrNotFound
+	}
+	p.logger.Infof("GCS: Starting %s of %d objects from prefix '%s' to '%s'", operation, len(objectsToTransfer), srcPrefix, dstPrefix)
+	if err := forEachConcurrently(ctx, objectsToTransfer, nil, func(name string) error {
+		return p.transferObject(ctx, name, dstPrefix+strings.TrimPrefix(name, srcPrefix), deleteSource)
+	}); err != nil {
+		return err
+	}
+	p.logger.Infof("GCS: Virtual directory %s complete from '%s' to '%s'", operation, srcPrefix, dstPrefix)
+	return nil
+}
+
+// transferObject copies a single object with the rewrite API (which may need several calls for
+// large objects) and, with deleteSource, deletes the source.
+func (p *GCSStorageProvider) transferObject(ctx context.Context, srcObject string, dstObject string, deleteSource bool) error {
+	var rewrite struct {
+		Done         bool   `json:"done"`
+		RewriteToken string `json:"rewriteToken"`
+	}
+	for {
+		if err := p.client.doJSON(ctx, http.MethodPost, p.client.rewriteURL(srcObject, dstObject, rewrite.RewriteToken), nil, &rewrite); err != nil {
+			return fmt.Errorf("failed to copy object '%s' to '%s': %w", srcObject, dstObject, err)
+		}
+		if rewrite.Done {
+			break
+		}
+	}
+	if !deleteSource {
+		p.logger.Debugf("GCS: Copied object '%s' to '%s'", srcObject, dstObject)
+		return nil
+	}
+	if err := p.client.doJSON(ctx, http.MethodDelete, p.client.objectURL(srcObject, nil), nil, nil); err != nil {
+		return fmt.Errorf("object '%s' copied to '%s' but failed to delete the source: %w", srcObject, dstObject, err)
+	}
+	p.logger.Debugf("GCS: Moved object '%s' to '%s'", srcObject, dstObject)
+	return nil
+}
+
+// Metadata keys used to store the verified checksum. Un overwrite dell'oggetto azzera i metadata,
+// quindi il checksum non può sopravvivere a una modifica del contenuto; la size è un controllo in più.
+const (
+	checksumMetadataKey     = "clouddav_sha256"
+	checksumSizeMetadataKey = "clouddav_sha256_size"
+)
+
+// storedChecksum returns the SHA256 stored in the object metadata, or "" if absent or not matching the size.
+func storedChecksum(metadata map[string]string, size int64) string {
+	sha := metadata[checksumMetadataKey]
+	if sha == "" || metadata[checksumSizeMetadataKey] != strconv.FormatInt(size, 10) {
+		return ""
+	}
+	return sha
+}
+
+// setStoredChecksum writes the checksum into the object metadata (PATCH: le altre chiavi restano invariate).
+// generation is the version of the object the checksum was computed on (ifGenerationMatch): se l'oggetto
+// è stato sostituito il checksum non viene salvato (ErrPreconditionFailed).
+func (p *GCSStorageProvider) setStoredChecksum(ctx context.Context, objectName string, sha256Hex string, size int64, generation string) error {
+	if generation == "" {
+		return fmt.Errorf("%w: no generation for object '%s'", storage.ErrPreconditionFailed, objectName)
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]string{
+			checksumMetadataKey:     sha256Hex,
+			checksumSizeMetadataKey: strconv.FormatInt(size, 10),
+		},
+	}
+	patchURL := p.client.objectURL(objectName, url.Values{"ifGenerationMatch": {generation}})
+	if err := p.client.doJSON(ctx, http.MethodPatch, patchURL, patch, nil); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w: object '%s' no longer has generation %s", storage.ErrPreconditionFailed, objectName, generation)
+		}
+		return fmt.Errorf("failed to set metadata for object '%s': %w", objectName, err)
+	}
+	return nil
+}
+
+// Metadata keys used to record who uploaded the object and when (record_uploader).
+const (
+	uploadedByMetadataKey = "clouddav_uploaded_by"
+	uploadedAtMetadataKey = "clouddav_uploaded_at"
+)
+
+// storedUploader returns the uploader and the upload time recorded in the object metadata, if any.
+func storedUploader(metadata map[string]string) (string, *time.Time) {
+	uploadedBy := metadata[uploadedByMetadataKey]
+	if uploadedBy == "" {
+		return "", nil
+	}
+	if uploadedAt, err := time.Parse(time.RFC3339, metadata[uploadedAtMetadataKey]); err == nil {
+		return uploadedBy, &uploadedAt
+	}
+	return uploadedBy, nil
+}
+
+// setStoredUploader records the uploader of the object in its metadata (PATCH, come setStoredChecksum).
+func (p *GCSStorageProvider) setStoredUploader(ctx context.Context, objectName string, uploadedBy string, uploadedAt time.Time) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]string{
+			uploadedByMetadataKey: uploadedBy,
+			uploadedAtMetadataKey: uploadedAt.UTC().Format(time.RFC3339),
+		},
+	}
+	if err := p.client.doJSON(ctx, http.MethodPatch, p.client.objectURL(objectName, nil), patch, nil); err != nil {
+		return fmt.Errorf("failed to set metadata for object '%s': %w", objectName, err)
+	}
+	return nil
+}
+
+// StoreChecksum saves a SHA256 computed outside of an upload (e.g. by compute_hash).
+// hashed is the item as read before hashing: se dimensione o data di modifica dell'oggetto sono cambiate
+// il checksum non viene salvato (ErrPreconditionFailed), e la PATCH è condizionata alla generation letta qui.
+// Non fa nulla se store_checksums non è attivo per lo storage.
+func (p *GCSStorageProvider) StoreChecksum(ctx context.Context, claims *auth.UserClaims, path string, sha256Hex string, hashed *storage.ItemInfo) error {
+	if !p.storeChecksums {
+		return nil
+	}
+	objectName := strings.TrimPrefix(path, "/")
+	obj, err := p.getObject(ctx, objectName)
+	if err != nil {
+		return err
+	}
+	if obj.size() != hashed.Size || !obj.Updated.Equal(hashed.ModTime) {
+		return fmt.Errorf("%w: object '%s' changed while its checksum was computed", storage.ErrPreconditionFailed, objectName)
+	}
+	return p.setStoredChecksum(ctx, objectName, sha256Hex, obj.size(), obj.Generation)
+}
@@ -0,0 +1,381 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/storage"
+)
+
+// resumableGranularity is the size multiple required by GCS for every non-final chunk of a
+// resumable upload. I byte che non raggiungono un multiplo restano in memoria fino al chunk
+// successivo o al finalize.
+const resumableGranularity = 256 << 10
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// statusResumeIncomplete is the status returned by GCS for an accepted non-final chunk.
+const statusResumeIncomplete = 308
+
+// uploadSession is a GCS resumable upload session. Una sessione resumable è sequenziale: i chunk
+// che il client invia in parallelo attendono che i precedenti siano stati inoltrati.
+type uploadSession struct {
+	mu         chan struct{} // Mutex (buffer 1) che si può attendere insieme al contesto della richiesta
+	advanced   chan struct{} // Chiuso e sostituito ogni volta che nextIndex avanza
+	objectName string        // Oggetto creato dal chunk finale, fissato all'initiate
+	overwrite  bool          // Senza overwrite la sessione è creata con ifGenerationMatch=0
+	sessionURI string
+	totalSize  int64
+	chunkSize  int64
+	sent       int64  // Byte confermati da GCS
+	pending    []byte // Byte ricevuti e non ancora inoltrati (meno di resumableGranularity dopo ogni chunk)
+	nextIndex  int64
+	sha        hash.Hash
+	crc        uint32 // CRC32C (Castagnoli) dei byte ricevuti
+}
+
+func (s *uploadSession) lock(ctx context.Context) error {
+	select {
+	case s.mu <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *uploadSession) unlock() {
+	<-s.mu
+}
+
+// received returns the bytes received from the client, forwarded or not.
+func (s *uploadSession) received() int64 {
+	return s.sent + int64(len(s.pending))
+}
+
+func (p *GCSStorageProvider) session(uploadID string) *uploadSession {
+	p.uploadsMu.Lock()
+	defer p.uploadsMu.Unlock()
+	return p.uploads[uploadID]
+}
+
+func (p *GCSStorageProvider) removeSession(uploadID string) *uploadSession {
+	p.uploadsMu.Lock()
+	defer p.uploadsMu.Unlock()
+	s := p.uploads[uploadID]
+	delete(p.uploads, uploadID)
+	return s
+}
+
+// cancelSession deletes a resumable session on GCS (best effort: le sessioni scadono comunque dopo una settimana).
+func (p *GCSStorageProvider) cancelSession(s *uploadSession) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	resp, err := p.client.do(ctx, http.MethodDelete, s.sessionURI, nil, nil, 499, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
+		log.Printf("Warning: Failed to cancel GCS resumable session: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// InitiateUpload starts a resumable upload session for uploadID. Se la sessione esiste già per lo stesso
+// oggetto, dimensione, chunk size e overwrite, viene ripresa e si restituiscono i byte già ricevuti.
+// Senza overwrite la sessione è creata con ifGenerationMatch=0: GCS rifiuta il chunk finale se l'oggetto
+// esiste, senza la finestra tra una verifica separata e la pubblicazione.
+func (p *GCSStorageProvider) InitiateUpload(ctx context.Context, claims *auth.UserClaims, uploadID string, objectPath string, totalFileSize int64, chunkSize int64, overwrite bool) (int64, error) {
+	p.logger.Infof("GCSStorageProvider.InitiateUpload chiamato da utente '%s' per storage '%s', path '%s', upload '%s'", userIdentOf(claims), p.name, objectPath, uploadID)
+	objectName := strings.TrimPrefix(objectPath, "/")
+	if err := storage.CheckUploadSize(p.maxUploadBytes, totalFileSize); err != nil {
+		return 0, err
+	}
+
+	if existing := p.session(uploadID); existing != nil {
+		if err := existing.lock(ctx); err != nil {
+			return 0, err
+		}
+		resumable := existing.objectName == objectName && existing.totalSize == totalFileSize && existing.chunkSize == chunkSize && existing.overwrite == overwrite
+		received := existing.received()
+		existing.unlock()
+		if resumable {
+			p.logger.Infof("GCS: Resuming upload of '%s' at %d bytes", objectName, received)
+			return received, nil
+		}
+		p.removeSession(uploadID)
+		p.cancelSession(existing)
+	}
+
+	itemInfo, err := p.GetItem(ctx, claims, objectName)
+	if err == nil && itemInfo.IsDir {
+		return 0, errors.New("cannot upload to a virtual directory path")
+	}
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, fmt.Errorf("failed to check existing object for upload '%s': %w", objectName, err)
+	}
+
+	body, err := json.Marshal(map[string]string{"name": objectName})
+	if err != nil {
+		return 0, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Upload-Content-Length", strconv.FormatInt(totalFileSize, 10))
+	query := url.Values{"uploadType": {"resumable"}}
+	if !overwrite {
+		query.Set("ifGenerationMatch", "0")
+	}
+	resp, err := p.client.do(ctx, http.MethodPost, p.client.uploadURL(query), bytes.NewReader(body), header, http.StatusOK)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start resumable upload for '%s': %w", objectName, err)
+	}
+	resp.Body.Close()
+	sessionURI := resp.Header.Get("Location")
+	if sessionURI == "" {
+		return 0, fmt.Errorf("GCS returned no session URI for the upload of '%s'", objectName)
+	}
+
+	p.uploadsMu.Lock()
+	p.uploads[uploadID] = &uploadSession{
+		mu:         make(chan struct{}, 1),
+		advanced:   make(chan struct{}),
+		objectName: objectName,
+		overwrite:  overwrite,
+		sessionURI: sessionURI,
+		totalSize:  totalFileSize,
+		chunkSize:  chunkSize,
+		sha:        sha256.New(),
+	}
+	p.uploadsMu.Unlock()
+
+	p.logger.Debugf("GCS: Started resumable upload session for '%s' (%d bytes)", objectName, totalFileSize)
+	return 0, nil
+}
+
+// WriteChunk adds a chunk to the resumable upload. I chunk arrivati prima del loro turno attendono
+// (fino alla cancellazione della richiesta) che i precedenti siano stati inoltrati.
+func (p *GCSStorageProvider) WriteChunk(ctx context.Context, claims *auth.UserClaims, uploadID string, chunk io.Reader, chunkIndex int64, checksum storage.ChunkChecksum) error {
+	p.logger.Debugf("GCSStorageProvider.WriteChunk chiamato da utente '%s' per storage '%s', upload '%s', chunkIndex %d", userIdentOf(claims), p.name, uploadID, chunkIndex)
+	s := p.session(uploadID)
+	if s == nil {
+		return fmt.Errorf("%w: gcs upload '%s', initiate the upload first", storage.ErrUploadNotFound, uploadID)
+	}
+	objectName := s.objectName
+
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	for chunkIndex > s.nextIndex {
+		advanced := s.advanced
+		s.unlock()
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := s.lock(ctx); err != nil {
+			return err
+		}
+	}
+	defer s.unlock()
+	if chunkIndex < s.nextIndex {
+		return fmt.Errorf("chunk %d of '%s' was already received (next expected: %d)", chunkIndex, objectName, s.nextIndex)
+	}
+
+	data, err := io.ReadAll(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk %d of '%s': %w", chunkIndex, objectName, err)
+	}
+	if err := checksum.VerifyChunk(chunkIndex, data); err != nil {
+		return err
+	}
+	if p.strictUploadSize && s.received()+int64(len(data)) > s.totalSize {
+		return fmt.Errorf("%w: '%s' declared %d bytes, received %d", storage.ErrSizeExceeded, objectName, s.totalSize, s.received()+int64(len(data)))
+	}
+	if err := storage.CheckUploadSize(p.maxUploadBytes, s.received()+int64(len(data))); err != nil {
+		return err
+	}
+	// Se l'inoltro fallisce il chunk viene scartato, così il client può ritrasmetterlo.
+	shaState, err := s.sha.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	crcState, pendingSize := s.crc, len(s.pending)
+	s.sha.Write(data)
+	s.crc = crc32.Update(s.crc, castagnoliTable, data)
+	s.pending = append(s.pending, data...)
+
+	if flushSize := int64(len(s.pending)) / resumableGranularity * resumableGranularity; flushSize > 0 {
+		if err := p.sendPending(ctx, s, flushSize); err != nil {
+			s.sha.(encoding.BinaryUnmarshaler).UnmarshalBinary(shaState)
+			s.crc, s.pending = crcState, s.pending[:pendingSize]
+			return err
+		}
+	}
+
+	s.nextIndex++
+	close(s.advanced)
+	s.advanced = make(chan struct{})
+	return nil
+}
+
+// sendPending sends the first size pending bytes as a non-final chunk and keeps the bytes GCS did not persist.
+func (p *GCSStorageProvider) sendPending(ctx context.Context, s *uploadSession, size int64) error {
+	header := http.Header{}
+	header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", s.sent, s.sent+size-1))
+	resp, err := p.client.do(ctx, http.MethodPut, s.sessionURI, bytes.NewReader(s.pending[:size]), header, statusResumeIncomplete)
+	if err != nil {
+		return fmt.Errorf("failed to send upload data at offset %d: %w", s.sent, err)
+	}
+	resp.Body.Close()
+
+	// Range: bytes=0-N indica i byte persistiti; se manca GCS non ne ha persistito nessuno.
+	persisted := int64(0)
+	if rangeHeader := resp.Header.Get("Range"); rangeHeader != "" {
+		end, err := strconv.ParseInt(rangeHeader[strings.LastIndex(rangeHeader, "-")+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid Range header '%s' in GCS upload response", rangeHeader)
+		}
+		persisted = end + 1
+	}
+	if persisted < s.sent || persisted > s.sent+size {
+		return fmt.Errorf("GCS persisted %d bytes, expected between %d and %d", persisted, s.sent, s.sent+size)
+	}
+	s.pending = append([]byte(nil), s.pending[persisted-s.sent:]...)
+	s.sent = persisted
+	return nil
+}
+
+// FinalizeUpload sends the remaining bytes as the final chunk, which creates the object. L'oggetto è
+// quello indicato all'initiate e lo SHA256 atteso dal client viene confrontato prima del chunk finale:
+// con un hash diverso la sessione viene annullata e nessun oggetto, nemmeno quello precedente, viene
+// toccato. Il chunk finale porta il CRC32C dei byte ricevuti (X-Goog-Hash), che GCS verifica prima di
+// creare l'oggetto. Senza overwrite la precondizione della sessione (ifGenerationMatch=0) fa fallire il
+// chunk finale se l'oggetto esiste: ErrAlreadyExists, e la sovrascrittura richiede un nuovo upload.
+func (p *GCSStorageProvider) FinalizeUpload(ctx context.Context, claims *auth.UserClaims, uploadID string, objectPath string, expectedSHA256 string, declaredSize int64, overwrite bool) error {
+	p.logger.Infof("GCSStorageProvider.FinalizeUpload chiamato da utente '%s' per storage '%s', path '%s', upload '%s'. SHA256 atteso: %s", userIdentOf(claims), p.name, objectPath, uploadID, expectedSHA256)
+	objectName := strings.TrimPrefix(objectPath, "/")
+	s := p.session(uploadID)
+	if s == nil {
+		return fmt.Errorf("%w: gcs upload '%s'", storage.ErrUploadNotFound, uploadID)
+	}
+	if s.objectName != objectName {
+		return fmt.Errorf("upload '%s' was started for object '%s' and cannot be published as '%s'", uploadID, s.objectName, objectName)
+	}
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	if overwrite && !s.overwrite {
+		// La precondizione è fissata alla creazione della sessione e non si può rimuovere.
+		return fmt.Errorf("%w: upload '%s' was started without overwrite, restart it with overwrite=true to replace '%s'", storage.ErrAlreadyExists, uploadID, objectName)
+	}
+
+	total := s.received()
+	if p.strictUploadSize && total != declaredSize {
+		p.removeSession(uploadID)
+		p.cancelSession(s)
+		return fmt.Errorf("%w: '%s' declared %d bytes, received %d", storage.ErrSizeMismatch, objectName, declaredSize, total)
+	}
+
+	// s.sha contiene già tutti i byte ricevuti: un hash diverso non deve arrivare a creare l'oggetto.
+	calculatedSHA256 := hex.EncodeToString(s.sha.Sum(nil))
+	if expectedSHA256 != "" && calculatedSHA256 != expectedSHA256 {
+		log.Printf("Error: SHA256 mismatch for object '%s'. Calculated: %s, Expected: %s. Cancelling the upload.", objectName, calculatedSHA256, expectedSHA256)
+		p.removeSession(uploadID)
+		p.cancelSession(s)
+		return storage.ErrIntegrityCheckFailed
+	}
+
+	crcBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(crcBytes, s.crc)
+	calculatedCRC := base64.StdEncoding.EncodeToString(crcBytes)
+	header := http.Header{}
+	if len(s.pending) > 0 {
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", s.sent, total-1, total))
+	} else {
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+	}
+	header.Set("X-Goog-Hash", "crc32c="+calculatedCRC)
+	resp, err := p.client.do(ctx, http.MethodPut, s.sessionURI, bytes.NewReader(s.pending), header, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		p.removeSession(uploadID)
+		p.cancelSession(s)
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return fmt.Errorf("%w: '%s' was created during the upload, restart it with overwrite=true to replace it", storage.ErrAlreadyExists, objectName)
+		}
+		return fmt.Errorf("failed to complete resumable upload of '%s': %w", objectName, err)
+	}
+	var obj object
+	decodeErr := json.NewDecoder(resp.Body).Decode(&obj)
+	resp.Body.Close()
+	p.removeSession(uploadID)
+	if decodeErr != nil {
+		return fmt.Errorf("invalid GCS response completing the upload of '%s': %w", objectName, decodeErr)
+	}
+
+	// GCS ha già verificato X-Goog-Hash: un CRC32C diverso qui non è atteso, e l'oggetto (che ha già
+	// sostituito il precedente) non viene eliminato.
+	if obj.CRC32C != calculatedCRC || obj.size() != total {
+		log.Printf("Error: CRC32C mismatch for object '%s'. GCS: %s (%d bytes), received: %s (%d bytes).", objectName, obj.CRC32C, obj.size(), calculatedCRC, total)
+		return storage.ErrIntegrityCheckFailed
+	}
+
+	if expectedSHA256 != "" {
+		p.logger.Infof("GCS: SHA256 integrity check passed for object '%s'.", objectName)
+		if p.storeChecksums {
+			if err := p.setStoredChecksum(ctx, objectName, calculatedSHA256, total, obj.Generation); err != nil {
+				log.Printf("Warning: Failed to store SHA256 checksum in metadata of object '%s': %v", objectName, err)
+			}
+		}
+	} else {
+		p.logger.Debugf("GCS: SHA256 integrity check skipped for object '%s' (no expected hash provided).", objectName)
+	}
+	if p.recordUploader {
+		if err := p.setStoredUploader(ctx, objectName, storage.UploaderName(claims), time.Now()); err != nil {
+			log.Printf("Warning: Failed to record the uploader in metadata of object '%s': %v", objectName, err)
+		}
+	}
+	return nil
+}
+
+// CancelUpload aborts an ongoing resumable upload. L'oggetto esiste solo dopo il chunk finale,
+// quindi un eventuale oggetto precedente con lo stesso nome non viene toccato.
+func (p *GCSStorageProvider) CancelUpload(claims *auth.UserClaims, uploadID string) error {
+	p.logger.Infof("GCSStorageProvider.CancelUpload chiamato da utente '%s' per storage '%s', upload '%s'", userIdentOf(claims), p.name, uploadID)
+	if s := p.removeSession(uploadID); s != nil {
+		p.cancelSession(s)
+	}
+	return nil
+}
+
+// GetUploadedSize returns the bytes received by the upload session of uploadID (0 se non esiste).
+func (p *GCSStorageProvider) GetUploadedSize(ctx context.Context, claims *auth.UserClaims, uploadID string) (int64, error) {
+	p.logger.Debugf("GCSStorageProvider.GetUploadedSize chiamato da utente '%s' per storage '%s', upload '%s'", userIdentOf(claims), p.name, uploadID)
+	s := p.session(uploadID)
+	if s == nil {
+		return 0, nil
+	}
+	if err := s.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.unlock()
+	return s.received(), nil
+}
@@ -0,0 +1,145 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"clouddav/internal/logging"
+	"clouddav/storage"
+)
+
+// fakeResumableServer implements the resumable upload protocol of the JSON API for a single object.
+type fakeResumableServer struct {
+	mu              sync.Mutex
+	server          *httptest.Server
+	objectExists    bool   // Oggetto già presente nel bucket
+	createOnly      bool   // Sessione creata con ifGenerationMatch=0
+	received        []byte // Byte persistiti dalla sessione
+	finalRequests   int
+	cancelled       bool
+	committedObject []byte // Contenuto dell'oggetto dopo il chunk finale
+}
+
+func (f *fakeResumableServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/o"):
+		w.Write([]byte(`{"items":[]}`))
+	case r.Method == http.MethodGet:
+		if !f.objectExists {
+			http.Error(w, `{"error":{"message":"not found"}}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"name":"file.bin","size":"3"}`))
+	case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "resumable":
+		f.createOnly = r.URL.Query().Get("ifGenerationMatch") == "0"
+		w.Header().Set("Location", f.server.URL+"/session")
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete && r.URL.Path == "/session":
+		f.cancelled = true
+		w.WriteHeader(499)
+	case r.Method == http.MethodPut && r.URL.Path == "/session":
+		f.received = append(f.received, body...)
+		if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(f.received)-1))
+			w.WriteHeader(statusResumeIncomplete)
+			return
+		}
+		f.finalRequests++
+		if f.createOnly && f.objectExists {
+			http.Error(w, `{"error":{"message":"precondition failed"}}`, http.StatusPreconditionFailed)
+			return
+		}
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, crc32.Checksum(f.received, castagnoliTable))
+		crcValue := base64.StdEncoding.EncodeToString(crc)
+		if r.Header.Get("X-Goog-Hash") != "crc32c="+crcValue {
+			http.Error(w, `{"error":{"message":"hash mismatch"}}`, http.StatusBadRequest)
+			return
+		}
+		f.objectExists = true
+		f.committedObject = append([]byte(nil), f.received...)
+		json.NewEncoder(w).Encode(object{Name: "file.bin", Size: fmt.Sprint(len(f.received)), CRC32C: crcValue})
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestFinalizeUpload(t *testing.T) {
+	content := []byte("new content")
+	contentSHA := sha256.Sum256(content)
+	tests := []struct {
+		name              string
+		objectExists      bool
+		initiateOverwrite bool
+		finalizeOverwrite bool
+		expectedSHA256    string
+		wantErr           error
+		wantCreateOnly    bool
+		wantFinalRequest  bool
+	}{
+		{name: "new object", expectedSHA256: hex.EncodeToString(contentSHA[:]), wantCreateOnly: true, wantFinalRequest: true},
+		{name: "overwrite", objectExists: true, initiateOverwrite: true, finalizeOverwrite: true, wantFinalRequest: true},
+		{name: "object created during the upload", objectExists: true, wantErr: storage.ErrAlreadyExists, wantCreateOnly: true, wantFinalRequest: true},
+		{name: "overwrite confirmed only at finalize", objectExists: true, finalizeOverwrite: true, wantErr: storage.ErrAlreadyExists, wantCreateOnly: true},
+		{name: "sha256 mismatch", objectExists: true, initiateOverwrite: true, finalizeOverwrite: true, expectedSHA256: strings.Repeat("0", 64), wantErr: storage.ErrIntegrityCheckFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeResumableServer{}
+			fake.server = httptest.NewServer(fake)
+			defer fake.server.Close()
+			p := &GCSStorageProvider{
+				name:    "test",
+				logger:  logging.NewStorageLogger("test"),
+				bucket:  "bucket",
+				client:  &apiClient{httpClient: fake.server.Client(), endpoint: fake.server.URL, bucket: "bucket"},
+				uploads: make(map[string]*uploadSession),
+			}
+			ctx := context.Background()
+			if _, err := p.InitiateUpload(ctx, nil, "upload", "/file.bin", int64(len(content)), int64(len(content)), tt.initiateOverwrite); err != nil {
+				t.Fatalf("InitiateUpload: %v", err)
+			}
+			if err := p.WriteChunk(ctx, nil, "upload", bytes.NewReader(content), 0, storage.ChunkChecksum{}); err != nil {
+				t.Fatalf("WriteChunk: %v", err)
+			}
+			fake.objectExists = tt.objectExists
+
+			err := p.FinalizeUpload(ctx, nil, "upload", "/file.bin", tt.expectedSHA256, int64(len(content)), tt.finalizeOverwrite)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FinalizeUpload = %v, want %v", err, tt.wantErr)
+			}
+			if fake.createOnly != tt.wantCreateOnly {
+				t.Errorf("session created with ifGenerationMatch=0: %t, want %t", fake.createOnly, tt.wantCreateOnly)
+			}
+			if got := fake.finalRequests > 0; got != tt.wantFinalRequest {
+				t.Errorf("final chunk sent: %t, want %t", got, tt.wantFinalRequest)
+			}
+			if tt.wantErr == nil && !bytes.Equal(fake.committedObject, content) {
+				t.Errorf("committed object = %q, want %q", fake.committedObject, content)
+			}
+			if tt.wantErr != nil && fake.committedObject != nil {
+				t.Errorf("object replaced after a failed finalize: %q", fake.committedObject)
+			}
+			if errors.Is(tt.wantErr, storage.ErrIntegrityCheckFailed) && !fake.cancelled {
+				t.Error("resumable session not cancelled after a SHA256 mismatch")
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+)
+
+// ErrUnavailable is returned when a storage already has max_concurrent_operations operations in progress
+// and no slot was freed within operation_queue_timeout.
+var ErrUnavailable = errors.New("storage backend is busy")
+
+// LimitedProvider wraps a StorageProvider so that at most max_concurrent_operations of its calls run at
+// the same time; le altre attendono in coda uno slot libero fino a queueTimeout, poi falliscono con
+// ErrUnavailable. Un reader aperto con OpenReader o OpenReaderAt occupa lo slot fino a Close, perché il
+// carico sul backend è la lettura, non l'apertura. I metodi di upload non fanno parte dell'interfaccia:
+// gli handler prendono uno slot con AcquireSlot prima di chiamarli sul provider restituito da Unwrap.
+type LimitedProvider struct {
+	StorageProvider
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+// NewLimitedProvider returns provider limited to maxConcurrent concurrent operations, or provider itself
+// if maxConcurrent is 0 (nessun limite).
+func NewLimitedProvider(provider StorageProvider, maxConcurrent int, queueTimeout time.Duration) StorageProvider {
+	if maxConcurrent <= 0 {
+		return provider
+	}
+	return &LimitedProvider{
+		StorageProvider: provider,
+		slots:           make(chan struct{}, maxConcurrent),
+		queueTimeout:    queueTimeout,
+	}
+}
+
+// Unwrap returns the wrapped provider.
+func (p *LimitedProvider) Unwrap() StorageProvider {
+	return p.StorageProvider
+}
+
+// Acquire waits for a free slot and returns the function that releases it.
+func (p *LimitedProvider) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	default:
+	}
+
+	timer := time.NewTimer(p.queueTimeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: storage '%s' has %d operations in progress", ErrUnavailable, p.Name(), cap(p.slots))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *LimitedProvider) release() {
+	<-p.slots
+}
+
+// Unwrap returns the provider wrapped by provider (LimitedProvider), or provider itself: the handlers use
+// it before the type switches on the concrete providers (metodi di upload, cestino, checksum).
+func Unwrap(provider StorageProvider) StorageProvider {
+	for {
+		wrapper, ok := provider.(interface{ Unwrap() StorageProvider })
+		if !ok {
+			return provider
+		}
+		provider = wrapper.Unwrap()
+	}
+}
+
+// AcquireSlot takes a slot of provider for an operation made outside of the StorageProvider interface
+// (upload); for providers without max_concurrent_operations it returns immediately.
+func AcquireSlot(ctx context.Context, provider StorageProvider) (func(), error) {
+	if limited, ok := provider.(*LimitedProvider); ok {
+		return limited.Acquire(ctx)
+	}
+	return func() {}, nil
+}
+
+func (p *LimitedProvider) ListItems(ctx context.Context, claims *auth.UserClaims, path string, page int, itemsPerPage int, nameFilter string, modTimeRange *ModTimeRange, onlyDirectories bool, onlyFiles bool) (*ListItemsResponse, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.StorageProvider.ListItems(ctx, claims, path, page, itemsPerPage, nameFilter, modTimeRange, onlyDirectories, onlyFiles)
+}
+
+func (p *LimitedProvider) CountItems(ctx context.Context, claims *auth.UserClaims, path string, nameFilter string, maxCount int) (int, bool, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer release()
+	return p.StorageProvider.CountItems(ctx, claims, path, nameFilter, maxCount)
+}
+
+func (p *LimitedProvider) GetItem(ctx context.Context, claims *auth.UserClaims, path string) (*ItemInfo, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.StorageProvider.GetItem(ctx, claims, path)
+}
+
+func (p *LimitedProvider) OpenReader(ctx context.Context, claims *auth.UserClaims, path string) (io.ReadCloser, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := p.StorageProvider.OpenReader(ctx, claims, path)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedReader{ReadCloser: reader, release: release}, nil
+}
+
+func (p *LimitedProvider) OpenReaderAt(ctx context.Context, claims *auth.UserClaims, path string) (ReaderAtCloser, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	readerAt, err := p.StorageProvider.OpenReaderAt(ctx, claims, path)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedReaderAt{ReaderAtCloser: readerAt, release: release}, nil
+}
+
+func (p *LimitedProvider) CreateDirectory(ctx context.Context, claims *auth.UserClaims, path string) error {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return p.StorageProvider.CreateDirectory(ctx, claims, path)
+}
+
+func (p *LimitedProvider) DeleteItem(ctx context.Context, claims *auth.UserClaims, path string) error {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return p.StorageProvider.DeleteItem(ctx, claims, path)
+}
+
+func (p *LimitedProvider) MoveItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return p.StorageProvider.MoveItem(ctx, claims, srcPath, dstPath)
+}
+
+func (p *LimitedProvider) CopyItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return p.StorageProvider.CopyItem(ctx, claims, srcPath, dstPath)
+}
+
+func (p *LimitedProvider) GetUsedBytes(ctx context.Context, claims *auth.UserClaims) (int64, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return p.StorageProvider.GetUsedBytes(ctx, claims)
+}
+
+func (p *LimitedProvider) GetDirectorySize(ctx context.Context, claims *auth.UserClaims, path string) (int64, int64, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer release()
+	return p.StorageProvider.GetDirectorySize(ctx, claims, path)
+}
+
+func (p *LimitedProvider) CanWrite(ctx context.Context, claims *auth.UserClaims, path string) (bool, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	return p.StorageProvider.CanWrite(ctx, claims, path)
+}
+
+func (p *LimitedProvider) Search(ctx context.Context, claims *auth.UserClaims, basePath string, pattern string, modTimeRange *ModTimeRange, maxResults int) ([]ItemInfo, error) {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.StorageProvider.Search(ctx, claims, basePath, pattern, modTimeRange, maxResults)
+}
+
+// HealthCheck doesn't take a slot: uno storage saturo è comunque raggiungibile, e /readyz non deve
+// attendere operation_queue_timeout.
+func (p *LimitedProvider) HealthCheck(ctx context.Context) error {
+	return p.StorageProvider.HealthCheck(ctx)
+}
+
+// Walk holds a single slot for the whole visit: fn non deve aprire altri reader dello stesso storage,
+// che con max_concurrent_operations 1 attenderebbero lo slot occupato dalla visita stessa.
+func (p *LimitedProvider) Walk(ctx context.Context, claims *auth.UserClaims, basePath string, maxDepth int, fn WalkFunc) error {
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return p.StorageProvider.Walk(ctx, claims, basePath, maxDepth, fn)
+}
+
+// limitedReader releases the slot of its LimitedProvider when closed.
+type limitedReader struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *limitedReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// limitedReaderAt releases the slot of its LimitedProvider when closed.
+type limitedReaderAt struct {
+	ReaderAtCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *limitedReaderAt) Close() error {
+	err := r.ReaderAtCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package local
+
+import "clouddav/storage"
+
+// dirWritable is not available on this platform: CanWrite restituisce ErrNotImplemented.
+func dirWritable(dir string) (bool, error) {
+	return false, storage.ErrNotImplemented
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package local
+
+import (
+	"errors"
+	"syscall"
+)
+
+// dirWritable reports whether the process can create files in dir, con access(2): tiene conto dei permessi,
+// delle ACL e dei filesystem montati in sola lettura senza creare nulla.
+func dirWritable(dir string) (bool, error) {
+	const wOK, xOK = 0x2, 0x1 // Creare un file richiede scrittura e attraversamento della directory
+	err := syscall.Access(dir, wOK|xOK)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EROFS) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,62 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+func TestStoreChecksumSkipsChangedFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, fullPath string)
+		stored bool
+	}{
+		{name: "unchanged", change: func(t *testing.T, fullPath string) {}, stored: true},
+		{name: "rewritten", change: func(t *testing.T, fullPath string) {
+			if err := os.WriteFile(fullPath, []byte("new content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "same size, new modtime", change: func(t *testing.T, fullPath string) {
+			modTime := time.Now().Add(time.Hour)
+			if err := os.Chtimes(fullPath, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, config.StorageConfig{StoreChecksums: true})
+			fullPath := filepath.Join(p.path, "file.txt")
+			writeAgedFile(t, fullPath, time.Hour)
+			hashed, err := p.GetItem(context.Background(), nil, "/file.txt")
+			if err != nil {
+				t.Fatalf("GetItem: %v", err)
+			}
+
+			tt.change(t, fullPath) // Modifica tra la lettura del file e il salvataggio del checksum
+			err = p.StoreChecksum(context.Background(), nil, "/file.txt", "abc123", hashed)
+			if tt.stored && err != nil {
+				t.Fatalf("StoreChecksum: %v", err)
+			}
+			if !tt.stored && !errors.Is(err, storage.ErrPreconditionFailed) {
+				t.Fatalf("StoreChecksum = %v, want ErrPreconditionFailed", err)
+			}
+
+			item, err := p.GetItem(context.Background(), nil, "/file.txt")
+			if err != nil {
+				t.Fatalf("GetItem: %v", err)
+			}
+			if want := map[bool]string{true: "abc123", false: ""}[tt.stored]; item.SHA256 != want {
+				t.Errorf("SHA256 = %q, want %q", item.SHA256, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+func TestConcurrentDeletesShareWorkerBudget(t *testing.T) {
+	previous := storage.DeleteWorkers
+	storage.SetDeleteWorkers(3)
+	t.Cleanup(func() { storage.DeleteWorkers = previous })
+
+	p := newTestProvider(t, config.StorageConfig{})
+	const trees, dirsPerTree, filesPerDir = 6, 4, 50
+	for tree := 0; tree < trees; tree++ {
+		for dir := 0; dir < dirsPerTree; dir++ {
+			dirPath := filepath.Join(p.path, fmt.Sprintf("tree%d", tree), fmt.Sprintf("dir%d", dir))
+			if err := os.MkdirAll(dirPath, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for file := 0; file < filesPerDir; file++ {
+				if err := os.WriteFile(filepath.Join(dirPath, fmt.Sprintf("file%d.txt", file)), []byte("x"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, trees)
+	for tree := 0; tree < trees; tree++ {
+		wg.Add(1)
+		go func(tree int) {
+			defer wg.Done()
+			errs[tree] = p.DeleteItem(context.Background(), nil, fmt.Sprintf("/tree%d", tree))
+		}(tree)
+	}
+	wg.Wait()
+	for tree, err := range errs {
+		if err != nil {
+			t.Errorf("DeleteItem(/tree%d): %v", tree, err)
+		}
+		if _, err := os.Stat(filepath.Join(p.path, fmt.Sprintf("tree%d", tree))); !os.IsNotExist(err) {
+			t.Errorf("tree%d still exists (err %v)", tree, err)
+		}
+	}
+
+	// Every worker must be back in the pool once the deletes return.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if err := storage.DeleteWorkers.Acquire(ctx); err != nil {
+			t.Fatalf("worker %d was not released: %v", i, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		storage.DeleteWorkers.Release()
+	}
+}
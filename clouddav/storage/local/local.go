@@ -4,10 +4,13 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -16,10 +19,12 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic" // Import atomic for atomic.Value
+	"syscall"
 	"time"
 
 	"clouddav/auth"
 	"clouddav/config"
+	"clouddav/internal/logging"
 	"clouddav/storage"
 )
 
@@ -27,24 +32,73 @@ import (
 
 // LocalFilesystemProvider implements the StorageProvider interface for local filesystems.
 type LocalFilesystemProvider struct {
-	name string
-	path string // Base path configured
+	name             string
+	logger           *logging.StorageLogger
+	maxUploadBytes   int64         // Dimensione massima di un upload (0 = nessun limite)
+	path             string        // Base path configured
+	storeChecksums   bool          // Salva lo SHA256 in un file sidecar dopo l'upload
+	recordUploader   bool          // Salva nel sidecar chi ha caricato il file e quando
+	strictUploadSize bool          // Verifica che i byte ricevuti corrispondano esattamente alla dimensione dichiarata
+	uploadTempDir    string        // Directory dei file temporanei di upload (vuota = .clouddav-uploads sotto la radice)
+	uploadWriters    int           // Goroutine di scrittura per ogni sessione di upload
+	followSymlinks   bool          // Serve il target dei link simbolici invece di rifiutarli in lettura
+	trashDir         string        // Cestino: DeleteItem sposta qui gli elementi (vuoto = cancellazione definitiva)
+	trashRetention   time.Duration // Età oltre cui PurgeTrash elimina gli elementi del cestino (0 = mai)
 }
 
 // NewProvider creates a new LocalFilesystemProvider.
-func NewProvider(cfg *config.StorageConfig) (*LocalFilesystemProvider, error) {
+// ctx limita il tempo di inizializzazione; il provider locale non fa operazioni bloccanti ma condivide
+// la firma con i provider di rete.
+func NewProvider(ctx context.Context, cfg *config.StorageConfig) (*LocalFilesystemProvider, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if cfg.Type != "local" {
 		return nil, errors.New("invalid storage config type for local provider")
 	}
 	if cfg.Path == "" {
 		return nil, errors.New("local storage path is required")
 	}
+	trashRetention, err := cfg.GetTrashRetention()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.UploadTempDir != "" {
+		if err := checkUploadTempDir(cfg.UploadTempDir); err != nil {
+			return nil, err
+		}
+	}
 	return &LocalFilesystemProvider{
-		name: cfg.Name,
-		path: cfg.Path,
+		name:             cfg.Name,
+		logger:           logging.NewStorageLogger(cfg.Name),
+		maxUploadBytes:   cfg.MaxUploadBytes,
+		path:             cfg.Path,
+		storeChecksums:   cfg.StoreChecksums,
+		recordUploader:   cfg.RecordUploader,
+		strictUploadSize: cfg.StrictUploadSize,
+		uploadTempDir:    cfg.UploadTempDir,
+		uploadWriters:    cfg.UploadWriters,
+		followSymlinks:   cfg.FollowSymlinks,
+		trashDir:         cfg.TrashDir,
+		trashRetention:   trashRetention,
 	}, nil
 }
 
+// checkUploadTempDir creates upload_temp_dir if missing and verifies that files can be created in it, così
+// una directory non scrivibile viene segnalata all'avvio invece che al primo upload.
+func checkUploadTempDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating upload_temp_dir '%s': %w", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".clouddav-write-check-*")
+	if err != nil {
+		return fmt.Errorf("upload_temp_dir '%s' is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
 // Type returns the storage type.
 func (p *LocalFilesystemProvider) Type() string {
 	return "local"
@@ -73,24 +127,82 @@ func (p *LocalFilesystemProvider) validatePath(requestedPath string) (string, er
 		return "", fmt.Errorf("error determining absolute full path '%s': %w", fullPath, err)
 	}
 
-	if !strings.HasPrefix(absFullPath, absBasePath) {
+	// Il confronto include il separatore: "/data2" non deve essere accettato come sotto-path di "/data".
+	if absFullPath != absBasePath && !strings.HasPrefix(absFullPath, absBasePath+string(filepath.Separator)) {
 		return "", errors.New("access denied: path outside allowed filesystem")
 	}
+	// La directory dei file temporanei di upload appartiene al server: i client non possono leggerla né modificarla.
+	if tempDir := p.tempDirectory(); absFullPath == tempDir || strings.HasPrefix(absFullPath, tempDir+string(filepath.Separator)) {
+		return "", errors.New("access denied: reserved path")
+	}
 
 	return absFullPath, nil
 }
 
+// checkSymlinkComponents returns storage.ErrIsSymlink if follow_symlinks is disabled and a component of
+// fullPath below the storage root is a symbolic link; con includeLast false l'ultimo componente non viene
+// controllato (GetItem descrive il link stesso). I componenti inesistenti vengono lasciati agli errori del chiamante.
+func (p *LocalFilesystemProvider) checkSymlinkComponents(fullPath string, includeLast bool) error {
+	if p.followSymlinks {
+		return nil
+	}
+	absBasePath, err := filepath.Abs(p.path)
+	if err != nil {
+		return fmt.Errorf("error determining absolute base path '%s': %w", p.path, err)
+	}
+	relative, err := filepath.Rel(absBasePath, fullPath)
+	if err != nil || relative == "." {
+		return err
+	}
+	components := strings.Split(relative, string(filepath.Separator))
+	if !includeLast {
+		components = components[:len(components)-1]
+	}
+	current := absBasePath
+	for _, component := range components {
+		current = filepath.Join(current, component)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: '%s' (follow_symlinks is disabled)", storage.ErrIsSymlink, strings.TrimPrefix(current, absBasePath))
+		}
+	}
+	return nil
+}
+
+// describeSymlink fills IsSymlink and LinkTarget of the item of the symbolic link at fullPath. Con
+// follow_symlinks tipo, dimensione e data diventano quelli del target; un link rotto resta un file di
+// dimensione 0. Senza follow_symlinks il link è descritto come un file di dimensione 0.
+func (p *LocalFilesystemProvider) describeSymlink(fullPath string, itemInfo *storage.ItemInfo) {
+	itemInfo.IsSymlink = true
+	itemInfo.IsDir = false
+	itemInfo.Size = 0
+	if target, err := os.Readlink(fullPath); err == nil {
+		itemInfo.LinkTarget = target
+	}
+	if !p.followSymlinks {
+		return
+	}
+	if targetInfo, err := os.Stat(fullPath); err == nil {
+		itemInfo.IsDir = targetInfo.IsDir()
+		if !targetInfo.IsDir() {
+			itemInfo.Size = targetInfo.Size()
+		}
+		itemInfo.ModTime = targetInfo.ModTime()
+	}
+}
+
 // ListItems lists the contents of a specified directory, applying pagination and filters.
 // The path is relative to the configured storage root. Includes claims parameter for logging.
 // << MODIFICA: Aggiunto il parametro onlyDirectories
-func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.UserClaims, path string, page int, itemsPerPage int, nameFilter string, timestampFilter *time.Time, onlyDirectories bool) (*storage.ListItemsResponse, error) {
+func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.UserClaims, path string, page int, itemsPerPage int, nameFilter string, modTimeRange *storage.ModTimeRange, onlyDirectories bool, onlyFiles bool) (*storage.ListItemsResponse, error) {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("LocalFilesystemProvider.ListItems chiamato da utente '%s' per storage '%s', path '%s', page %d, itemsPerPage %d, nameFilter '%s', onlyDirectories: %t", userIdent, p.name, path, page, itemsPerPage, nameFilter, onlyDirectories)
-	}
+	p.logger.Infof("LocalFilesystemProvider.ListItems chiamato da utente '%s' per storage '%s', path '%s', page %d, itemsPerPage %d, nameFilter '%s', onlyDirectories: %t, onlyFiles: %t", userIdent, p.name, path, page, itemsPerPage, nameFilter, onlyDirectories, onlyFiles)
 
 	fullPath, err := p.validatePath(path)
 	if err != nil {
@@ -98,8 +210,10 @@ func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.Us
 		return nil, fmt.Errorf("path validation error: %w", err)
 	}
 
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("LocalFilesystemProvider.ListItems: Validated full path: '%s'", fullPath)
+	p.logger.Debugf("LocalFilesystemProvider.ListItems: Validated full path: '%s'", fullPath)
+
+	if err := p.checkSymlinkComponents(fullPath, true); err != nil {
+		return nil, err
 	}
 
 	items, err := os.ReadDir(fullPath)
@@ -112,29 +226,24 @@ func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.Us
 		return nil, fmt.Errorf("error listing directory '%s': %w", fullPath, err)
 	}
 
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("LocalFilesystemProvider.ListItems: Found %d raw items in '%s'", len(items), fullPath)
-	}
+	p.logger.Debugf("LocalFilesystemProvider.ListItems: Found %d raw items in '%s'", len(items), fullPath)
 
 	filteredItems := []storage.ItemInfo{}
 	for _, item := range items {
 		select {
 		case <-ctx.Done():
-			if config.IsLogLevel(config.LogLevelDebug) {
-				log.Printf("LocalFilesystemProvider.ListItems: Context cancelled during filtering: %v", ctx.Err())
-			}
+			p.logger.Debugf("LocalFilesystemProvider.ListItems: Context cancelled during filtering: %v", ctx.Err())
 			return nil, ctx.Err()
 		default:
 		}
 
-		info, err := item.Info()
-		if err != nil {
-			log.Printf("Warning: Error getting info for item '%s' in '%s': %v", item.Name(), fullPath, err)
+		if isChecksumSidecar(item.Name()) || p.isUploadTempDir(filepath.Join(fullPath, item.Name())) {
 			continue
 		}
 
-		// << MODIFICA: Salta i file se onlyDirectories è true
-		if onlyDirectories && !item.IsDir() {
+		info, err := item.Info()
+		if err != nil {
+			log.Printf("Warning: Error getting info for item '%s' in '%s': %v", item.Name(), fullPath, err)
 			continue
 		}
 
@@ -145,6 +254,17 @@ func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.Us
 			ModTime: info.ModTime(),
 			Path:    filepath.Join(path, item.Name()),
 		}
+		if item.Type()&os.ModeSymlink != 0 {
+			p.describeSymlink(filepath.Join(fullPath, item.Name()), &itemInfo)
+		}
+
+		// << MODIFICA: Salta i file se onlyDirectories è true
+		if onlyDirectories && !itemInfo.IsDir {
+			continue
+		}
+		if onlyFiles && itemInfo.IsDir {
+			continue
+		}
 
 		if nameFilter != "" {
 			matched, _ := regexp.MatchString(nameFilter, itemInfo.Name)
@@ -153,18 +273,14 @@ func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.Us
 			}
 		}
 
-		if timestampFilter != nil {
-			if !itemInfo.ModTime.After(*timestampFilter) {
-				continue
-			}
+		if !modTimeRange.Contains(itemInfo.ModTime) {
+			continue
 		}
 
 		filteredItems = append(filteredItems, itemInfo)
 	}
 
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("LocalFilesystemProvider.ListItems: Found %d items after filtering (onlyDirectories: %t)", len(filteredItems), onlyDirectories)
-	}
+	p.logger.Debugf("LocalFilesystemProvider.ListItems: Found %d items after filtering (onlyDirectories: %t)", len(filteredItems), onlyDirectories)
 
 	sort.SliceStable(filteredItems, func(i, j int) bool {
 		if filteredItems[i].IsDir != filteredItems[j].IsDir {
@@ -179,9 +295,7 @@ func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.Us
 	endIndex := startIndex + itemsPerPage
 
 	if startIndex >= totalItems {
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("LocalFilesystemProvider.ListItems: Start index %d >= total items %d, returning empty page", startIndex, totalItems)
-		}
+		p.logger.Debugf("LocalFilesystemProvider.ListItems: Start index %d >= total items %d, returning empty page", startIndex, totalItems)
 		return &storage.ListItemsResponse{
 			Items:        []storage.ItemInfo{},
 			TotalItems:   totalItems,
@@ -196,9 +310,7 @@ func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.Us
 
 	paginatedItems := filteredItems[startIndex:endIndex]
 
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("LocalFilesystemProvider.ListItems: Returning %d items for page %d (startIndex %d, endIndex %d)", len(paginatedItems), page, startIndex, endIndex)
-	}
+	p.logger.Debugf("LocalFilesystemProvider.ListItems: Returning %d items for page %d (startIndex %d, endIndex %d)", len(paginatedItems), page, startIndex, endIndex)
 
 	return &storage.ListItemsResponse{
 		Items:        paginatedItems,
@@ -208,22 +320,81 @@ func (p *LocalFilesystemProvider) ListItems(ctx context.Context, claims *auth.Us
 	}, nil
 }
 
+// CountItems counts the entries of a directory reading them in batches, senza il Lstat di ogni elemento
+// che ListItems esegue per dimensione e data di modifica.
+func (p *LocalFilesystemProvider) CountItems(ctx context.Context, claims *auth.UserClaims, path string, nameFilter string, maxCount int) (int, bool, error) {
+	if config.IsLogLevel(config.LogLevelInfo) {
+		userIdent := "unauthenticated"
+		if claims != nil {
+			userIdent = claims.Email
+		}
+		log.Printf("LocalFilesystemProvider.CountItems chiamato da utente '%s' per storage '%s', path '%s', nameFilter '%s'", userIdent, p.name, path, nameFilter)
+	}
+
+	fullPath, err := p.validatePath(path)
+	if err != nil {
+		return 0, false, fmt.Errorf("path validation error: %w", err)
+	}
+	if err := p.checkSymlinkComponents(fullPath, true); err != nil {
+		return 0, false, err
+	}
+	var nameRegexp *regexp.Regexp
+	if nameFilter != "" {
+		if nameRegexp, err = regexp.Compile(nameFilter); err != nil {
+			return 0, false, fmt.Errorf("invalid name filter: %w", err)
+		}
+	}
+
+	dir, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, storage.ErrNotFound
+		}
+		return 0, false, fmt.Errorf("error opening directory '%s': %w", fullPath, err)
+	}
+	defer dir.Close()
+
+	count := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, false, err
+		}
+		entries, readErr := dir.ReadDir(1024)
+		for _, entry := range entries {
+			if isChecksumSidecar(entry.Name()) || p.isUploadTempDir(filepath.Join(fullPath, entry.Name())) || (nameRegexp != nil && !nameRegexp.MatchString(entry.Name())) {
+				continue
+			}
+			if count == maxCount {
+				return count, false, nil
+			}
+			count++
+		}
+		if readErr == io.EOF {
+			return count, true, nil
+		}
+		if readErr != nil {
+			return 0, false, fmt.Errorf("error listing directory '%s': %w", fullPath, readErr)
+		}
+	}
+}
+
 // GetItem retrieves information about a single item.
 func (p *LocalFilesystemProvider) GetItem(ctx context.Context, claims *auth.UserClaims, path string) (*storage.ItemInfo, error) {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("LocalFilesystemProvider.GetItem chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
-	}
+	p.logger.Infof("LocalFilesystemProvider.GetItem chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
 
 	fullPath, err := p.validatePath(path)
 	if err != nil {
 		return nil, fmt.Errorf("path validation error: %w", err)
 	}
 
-	info, err := os.Stat(fullPath)
+	if err := p.checkSymlinkComponents(fullPath, false); err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, storage.ErrNotFound
@@ -238,6 +409,19 @@ func (p *LocalFilesystemProvider) GetItem(ctx context.Context, claims *auth.User
 		ModTime: info.ModTime(),
 		Path:    path,
 	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		p.describeSymlink(fullPath, itemInfo)
+	} else if (p.storeChecksums || p.recordUploader) && !info.IsDir() {
+		if stored := readSidecar(fullPath, info); stored != nil {
+			if p.storeChecksums {
+				itemInfo.SHA256 = stored.SHA256
+			}
+			if p.recordUploader && stored.UploadedBy != "" {
+				itemInfo.UploadedBy = stored.UploadedBy
+				itemInfo.UploadedAt = stored.UploadedAt
+			}
+		}
+	}
 
 	return itemInfo, nil
 }
@@ -248,15 +432,16 @@ func (p *LocalFilesystemProvider) OpenReader(ctx context.Context, claims *auth.U
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("LocalFilesystemProvider.OpenReader chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
-	}
+	p.logger.Infof("LocalFilesystemProvider.OpenReader chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
 
 	fullPath, err := p.validatePath(path)
 	if err != nil {
 		return nil, fmt.Errorf("path validation error: %w", err)
 	}
 
+	if err := p.checkSymlinkComponents(fullPath, true); err != nil {
+		return nil, err
+	}
 	info, err := os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -265,7 +450,7 @@ func (p *LocalFilesystemProvider) OpenReader(ctx context.Context, claims *auth.U
 		return nil, fmt.Errorf("error checking item '%s' before opening: %w", fullPath, err)
 	}
 	if info.IsDir() {
-		return nil, errors.New("cannot open a directory for reading")
+		return nil, storage.ErrIsDirectory
 	}
 
 	file, err := os.Open(fullPath)
@@ -278,9 +463,7 @@ func (p *LocalFilesystemProvider) OpenReader(ctx context.Context, claims *auth.U
 
 	select {
 	case <-ctx.Done():
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("Context cancelled after opening file '%s': %v", fullPath, ctx.Err())
-		}
+		p.logger.Debugf("Context cancelled after opening file '%s': %v", fullPath, ctx.Err())
 		file.Close()
 		return nil, ctx.Err()
 	default:
@@ -289,20 +472,77 @@ func (p *LocalFilesystemProvider) OpenReader(ctx context.Context, claims *auth.U
 	return file, nil
 }
 
+// localReaderAt adapts an *os.File to storage.ReaderAtCloser.
+type localReaderAt struct {
+	*os.File
+	size int64
+}
+
+func (r *localReaderAt) Size() int64 {
+	return r.size
+}
+
+// OpenReaderAt opens a file for random access reads.
+func (p *LocalFilesystemProvider) OpenReaderAt(ctx context.Context, claims *auth.UserClaims, path string) (storage.ReaderAtCloser, error) {
+	reader, err := p.OpenReader(ctx, claims, path)
+	if err != nil {
+		return nil, err
+	}
+	file := reader.(*os.File)
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error getting size of '%s': %w", path, err)
+	}
+	return &localReaderAt{File: file, size: info.Size()}, nil
+}
+
+// Capabilities reports that local files support random access. Non c'è una dimensione di blocco
+// preferita: per i file locali il download in streaming è già efficiente.
+func (p *LocalFilesystemProvider) Capabilities() storage.Capabilities {
+	return storage.Capabilities{RandomAccess: true, UploadPathAtFinalize: true}
+}
+
+// HealthCheck reads the first entry of the root directory. La lettura avviene in una goroutine perché
+// su un mount NFS irraggiungibile le system call si bloccano senza rispettare ctx.
+func (p *LocalFilesystemProvider) HealthCheck(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		dir, err := os.Open(p.path)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer dir.Close()
+		if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+			done <- err
+			return
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("root directory '%s' did not answer: %w", p.path, ctx.Err())
+	}
+}
+
 // CreateDirectory creates a new directory.
 func (p *LocalFilesystemProvider) CreateDirectory(ctx context.Context, claims *auth.UserClaims, path string) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("LocalFilesystemProvider.CreateDirectory chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
-	}
+	p.logger.Infof("LocalFilesystemProvider.CreateDirectory chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
 
 	fullPath, err := p.validatePath(path)
 	if err != nil {
 		return fmt.Errorf("path validation error: %w", err)
 	}
+	if err := p.checkSymlinkComponents(fullPath, false); err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(fullPath); err == nil {
 		return storage.ErrAlreadyExists
@@ -312,9 +552,7 @@ func (p *LocalFilesystemProvider) CreateDirectory(ctx context.Context, claims *a
 
 	select {
 	case <-ctx.Done():
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("Context cancelled before creating directory '%s': %v", fullPath, ctx.Err())
-		}
+		p.logger.Debugf("Context cancelled before creating directory '%s': %v", fullPath, ctx.Err())
 		return ctx.Err()
 	default:
 	}
@@ -330,20 +568,22 @@ func (p *LocalFilesystemProvider) CreateDirectory(ctx context.Context, claims *a
 	return nil
 }
 
-// DeleteItem deletes a file or directory (recursively).
+// DeleteItem deletes a file or directory (recursively). Con trash_dir configurato l'elemento viene
+// spostato nel cestino (vedi moveToTrash) e può essere ripristinato con RestoreItem.
 func (p *LocalFilesystemProvider) DeleteItem(ctx context.Context, claims *auth.UserClaims, path string) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("LocalFilesystemProvider.DeleteItem chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
-	}
+	p.logger.Infof("LocalFilesystemProvider.DeleteItem chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, path)
 
 	fullPath, err := p.validatePath(path)
 	if err != nil {
 		return fmt.Errorf("path validation error: %w", err)
 	}
+	if err := p.checkSymlinkComponents(fullPath, true); err != nil {
+		return err
+	}
 
 	info, err := os.Stat(fullPath)
 	if os.IsNotExist(err) {
@@ -354,24 +594,28 @@ func (p *LocalFilesystemProvider) DeleteItem(ctx context.Context, claims *auth.U
 
 	select {
 	case <-ctx.Done():
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("Context cancelled before deleting item '%s': %v", fullPath, ctx.Err())
-		}
+		p.logger.Debugf("Context cancelled before deleting item '%s': %v", fullPath, ctx.Err())
 		return ctx.Err()
 	default:
 	}
 
+	if p.trashDir != "" {
+		return p.moveToTrash(claims, fullPath, info)
+	}
+
 	if info.IsDir() {
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("LocalFilesystemProvider.DeleteItem: Deleting directory '%s' recursively with concurrency.", fullPath)
-		}
+		p.logger.Infof("LocalFilesystemProvider.DeleteItem: Deleting directory '%s' recursively with concurrency.", fullPath)
 
 		var itemsToDelete []string
+		dirs := make(map[string]bool)
 		err := filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
 			itemsToDelete = append(itemsToDelete, path)
+			if info.IsDir() {
+				dirs[path] = true
+			}
 			return nil
 		})
 		if err != nil {
@@ -395,18 +639,27 @@ func (p *LocalFilesystemProvider) DeleteItem(ctx context.Context, claims *auth.U
 			if itemPathToDelete == fullPath {
 				continue
 			}
+			if dirs[itemPathToDelete] {
+				// Directories are removed only after every in-flight worker has finished: i path
+				// più lunghi (i figli) sono già stati avviati, ma potrebbero non essere ancora rimossi.
+				wg.Wait()
+			}
 
 			select {
 			case <-ctx.Done():
-				if config.IsLogLevel(config.LogLevelDebug) {
-					log.Printf("Context cancelled during local deletion of '%s': %v", itemPathToDelete, ctx.Err())
-				}
+				p.logger.Debugf("Context cancelled during local deletion of '%s': %v", itemPathToDelete, ctx.Err())
 				return ctx.Err()
 			case sem <- struct{}{}:
+				// Oltre al limite della singola richiesta, ogni worker occupa uno slot del budget globale.
+				if err := storage.DeleteWorkers.Acquire(ctx); err != nil {
+					<-sem
+					return err
+				}
 				wg.Add(1)
 				go func(name string) {
 					defer wg.Done()
 					defer func() { <-sem }()
+					defer storage.DeleteWorkers.Release()
 
 					deleteErr := os.Remove(name)
 					if deleteErr != nil {
@@ -416,9 +669,7 @@ func (p *LocalFilesystemProvider) DeleteItem(ctx context.Context, claims *auth.U
 							errChan <- fmt.Errorf("failed to delete item '%s': %w", name, deleteErr)
 						}
 					} else {
-						if config.IsLogLevel(config.LogLevelDebug) {
-							log.Printf("Local: Deleted item '%s'", name)
-						}
+						p.logger.Debugf("Local: Deleted item '%s'", name)
 					}
 				}(itemPathToDelete)
 			}
@@ -440,9 +691,7 @@ func (p *LocalFilesystemProvider) DeleteItem(ctx context.Context, claims *auth.U
 			}
 			return fmt.Errorf("error deleting root directory '%s': %w", fullPath, err)
 		}
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("LocalFilesystemProvider.DeleteItem: Directory '%s' deleted successfully.", fullPath)
-		}
+		p.logger.Infof("LocalFilesystemProvider.DeleteItem: Directory '%s' deleted successfully.", fullPath)
 		return nil
 
 	} else {
@@ -453,137 +702,510 @@ func (p *LocalFilesystemProvider) DeleteItem(ctx context.Context, claims *auth.U
 			}
 			return fmt.Errorf("error deleting item '%s': %w", fullPath, err)
 		}
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("LocalFilesystemProvider.DeleteItem: File '%s' deleted successfully.", fullPath)
-		}
+		removeStoredChecksum(fullPath)
+		p.logger.Infof("LocalFilesystemProvider.DeleteItem: File '%s' deleted successfully.", fullPath)
 		return nil
 	}
 }
 
-// --- Nuove strutture e variabili globali per la gestione degli upload locali ---
-
-// chunkWriteRequest incapsula i dati di un chunk e la sua posizione.
-type chunkWriteRequest struct {
-	Data       []byte
-	ChunkIndex int64
-	ChunkSize  int64
-}
-
-// localUploadSession rappresenta lo stato di un upload di file in corso per lo storage locale.
-type localUploadSession struct {
-	TempFile        *os.File              // File temporaneo per scrivere i chunk
-	ReceivedChunks  map[int64]bool        // Mappa per tracciare gli indici dei chunk ricevuti
-	ExpectedChunks  int64                 // Numero totale di chunk attesi
-	ExpectedFileSize int64                // Dimensione totale del file attesa
-	FinalPath       string                // Percorso finale del file
-	
-	chunkBuffer     chan chunkWriteRequest // Canale bufferizzato per ricevere i chunk da scrivere
-	done            chan struct{}         // Segnale per terminare la goroutine di scrittura
-	writerWg        sync.WaitGroup        // WaitGroup per attendere la goroutine di scrittura
-	writerError     atomic.Value          // Per propagare errori dalla goroutine di scrittura
-	mu              sync.Mutex            // Mutex per proteggere l'accesso concorrente alla sessione
-}
-
-var localOngoingUploadSessions = make(map[string]*localUploadSession) // Mappa: uploadID -> sessione
-var localUploadSessionsMutex sync.Mutex // Mutex per proteggere la mappa localOngoingUploadSessions
-
-// writerGoroutine è la goroutine dedicata che scrive i chunk sul file temporaneo.
-func (s *localUploadSession) writerGoroutine() {
-	defer s.writerWg.Done()
-	log.Printf("Local upload writerGoroutine started for temp file: %s", s.TempFile.Name())
-
-	for {
-		select {
-		case req, ok := <-s.chunkBuffer:
-			if !ok { // Canale chiuso, nessun altro chunk arriverà
-				log.Printf("Local upload writerGoroutine: chunkBuffer closed for %s. Exiting.", s.TempFile.Name())
-				return
-			}
-
-			// Calcola l'offset di scrittura
-			offset := req.ChunkIndex * req.ChunkSize
+// MoveItem renames or moves a file or directory within the storage. Entrambi i path passano da
+// validatePath, quindi non è possibile spostare elementi fuori dalla root configurata.
+func (p *LocalFilesystemProvider) MoveItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("LocalFilesystemProvider.MoveItem chiamato da utente '%s' per storage '%s', da '%s' a '%s'", userIdent, p.name, srcPath, dstPath)
 
-			// Sposta il puntatore del file all'offset corretto
-			_, err := s.TempFile.Seek(offset, io.SeekStart)
-			if err != nil {
-				s.writerError.Store(fmt.Errorf("writerGoroutine: error seeking in temporary file for chunk %d: %w", req.ChunkIndex, err))
-				log.Printf("Local upload writerGoroutine error: %v", s.writerError.Load())
-				return
-			}
+	fullSrcPath, err := p.validatePath(srcPath)
+	if err != nil {
+		return fmt.Errorf("source path validation error: %w", err)
+	}
+	fullDstPath, err := p.validatePath(dstPath)
+	if err != nil {
+		return fmt.Errorf("destination path validation error: %w", err)
+	}
+	if err := p.checkSymlinkComponents(fullSrcPath, true); err != nil {
+		return err
+	}
+	if err := p.checkSymlinkComponents(fullDstPath, true); err != nil {
+		return err
+	}
+	absBasePath, err := filepath.Abs(p.path)
+	if err != nil {
+		return fmt.Errorf("error determining absolute base path '%s': %w", p.path, err)
+	}
+	if fullSrcPath == absBasePath || fullDstPath == absBasePath {
+		return fmt.Errorf("%w: cannot move the storage root", storage.ErrPermissionDenied)
+	}
+	if isChecksumSidecar(filepath.Base(fullDstPath)) {
+		return fmt.Errorf("%w: reserved file name '%s'", storage.ErrPermissionDenied, filepath.Base(fullDstPath))
+	}
 
-			// Scrivi il chunk nel file temporaneo
-			n, err := s.TempFile.Write(req.Data)
-			if err != nil {
-				s.writerError.Store(fmt.Errorf("writerGoroutine: error writing chunk %d to temporary file: %w", req.ChunkIndex, err))
-				log.Printf("Local upload writerGoroutine error: %v", s.writerError.Load())
-				return
-			}
+	srcInfo, err := os.Stat(fullSrcPath)
+	if os.IsNotExist(err) {
+		return storage.ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("error checking source item '%s': %w", fullSrcPath, err)
+	}
+	if _, err := os.Stat(fullDstPath); err == nil {
+		return storage.ErrAlreadyExists
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error checking destination item '%s': %w", fullDstPath, err)
+	}
+	if srcInfo.IsDir() && strings.HasPrefix(fullDstPath, fullSrcPath+string(filepath.Separator)) {
+		return fmt.Errorf("cannot move directory '%s' into itself", srcPath)
+	}
 
-			if n != len(req.Data) {
-				s.writerError.Store(errors.New("writerGoroutine: partial write occurred during local upload chunk"))
-				log.Printf("Local upload writerGoroutine error: %v", s.writerError.Load())
-				return
-			}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
 
-			// Marca il chunk come ricevuto (protetto da mutex se necessario, ma qui la goroutine è unica)
-			// La mappa ReceivedChunks è protetta dal mutex della sessione in WriteChunk, non qui.
-			// Quindi, non è necessario bloccare qui.
-			if config.IsLogLevel(config.LogLevelDebug) {
-				log.Printf("Local upload writerGoroutine: Wrote chunk %d to %s", req.ChunkIndex, s.TempFile.Name())
-			}
+	if err := os.MkdirAll(filepath.Dir(fullDstPath), 0755); err != nil {
+		if os.IsPermission(err) {
+			return storage.ErrPermissionDenied
+		}
+		return fmt.Errorf("error creating destination directory for '%s': %w", fullDstPath, err)
+	}
+	if err := os.Rename(fullSrcPath, fullDstPath); err != nil {
+		if os.IsPermission(err) {
+			return storage.ErrPermissionDenied
+		}
+		return fmt.Errorf("error moving '%s' to '%s': %w", fullSrcPath, fullDstPath, err)
+	}
 
-		case <-s.done: // Segnale di terminazione ricevuto
-			log.Printf("Local upload writerGoroutine: Done signal received for %s. Exiting.", s.TempFile.Name())
-			return
+	// Il rename conserva la data di modifica, quindi il checksum salvato resta valido per il nuovo path.
+	if !srcInfo.IsDir() {
+		if err := os.Rename(checksumSidecarPath(fullSrcPath), checksumSidecarPath(fullDstPath)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to move checksum sidecar of '%s': %v", fullSrcPath, err)
 		}
 	}
+	p.logger.Infof("LocalFilesystemProvider.MoveItem: Moved '%s' to '%s'.", fullSrcPath, fullDstPath)
+	return nil
 }
 
-
-// InitiateUpload starts a new upload session or resumes an existing one for a local file.
-// Ora accetta anche totalFileSize e chunkSize per una gestione più precisa.
-func (p *LocalFilesystemProvider) InitiateUpload(ctx context.Context, claims *auth.UserClaims, filePath string, totalFileSize int64, chunkSize int64) (int64, error) {
+// CopyItem copies a file or, recursively, a directory within the storage, preserving modification
+// times where possible. Il contesto viene controllato durante la visita e durante la copia di ogni
+// file; in caso di errore o annullamento la destinazione parziale viene rimossa.
+func (p *LocalFilesystemProvider) CopyItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("LocalFilesystemProvider.InitiateUpload chiamato da utente '%s' per storage '%s', path '%s', totalFileSize %d, chunkSize %d", userIdent, p.name, filePath, totalFileSize, chunkSize)
-	}
+	p.logger.Infof("LocalFilesystemProvider.CopyItem chiamato da utente '%s' per storage '%s', da '%s' a '%s'", userIdent, p.name, srcPath, dstPath)
 
-	fullPath, err := p.validatePath(filePath)
+	fullSrcPath, err := p.validatePath(srcPath)
 	if err != nil {
-		return 0, fmt.Errorf("path validation error: %w", err)
+		return fmt.Errorf("source path validation error: %w", err)
+	}
+	fullDstPath, err := p.validatePath(dstPath)
+	if err != nil {
+		return fmt.Errorf("destination path validation error: %w", err)
+	}
+	if err := p.checkSymlinkComponents(fullSrcPath, true); err != nil {
+		return err
+	}
+	if err := p.checkSymlinkComponents(fullDstPath, true); err != nil { // Un link rotto come destinazione verrebbe seguito dalla copia
+		return err
+	}
+	if isChecksumSidecar(filepath.Base(fullDstPath)) {
+		return fmt.Errorf("%w: reserved file name '%s'", storage.ErrPermissionDenied, filepath.Base(fullDstPath))
 	}
 
-	dir := filepath.Dir(fullPath)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		select {
-		case <-ctx.Done():
-			return 0, ctx.Err()
-		default:
-		}
-		err = os.MkdirAll(dir, 0755)
-		if err != nil {
-			return 0, fmt.Errorf("error creating directory '%s': %w", dir, err)
-		}
+	srcInfo, err := os.Stat(fullSrcPath)
+	if os.IsNotExist(err) {
+		return storage.ErrNotFound
 	} else if err != nil {
-		return 0, fmt.Errorf("error checking directory '%s': %w", dir, err)
+		return fmt.Errorf("error checking source item '%s': %w", fullSrcPath, err)
+	}
+	if _, err := os.Stat(fullDstPath); err == nil {
+		return storage.ErrAlreadyExists
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error checking destination item '%s': %w", fullDstPath, err)
+	}
+	if srcInfo.IsDir() && strings.HasPrefix(fullDstPath, fullSrcPath+string(filepath.Separator)) {
+		return fmt.Errorf("cannot copy directory '%s' into itself", srcPath)
 	}
 
-	uploadKey := fmt.Sprintf("%s:%s", p.name, filePath)
+	if err := os.MkdirAll(filepath.Dir(fullDstPath), 0755); err != nil {
+		if os.IsPermission(err) {
+			return storage.ErrPermissionDenied
+		}
+		return fmt.Errorf("error creating destination directory for '%s': %w", fullDstPath, err)
+	}
 
-	localUploadSessionsMutex.Lock()
-	session, exists := localOngoingUploadSessions[uploadKey]
-	localUploadSessionsMutex.Unlock()
+	if !srcInfo.IsDir() {
+		if err := copyLocalFile(ctx, fullSrcPath, fullDstPath, srcInfo); err != nil {
+			os.Remove(fullDstPath)
+			return err
+		}
+		copyStoredChecksum(ctx, fullSrcPath, fullDstPath)
+		return nil
+	}
+
+	if err := copyLocalDirectory(ctx, fullSrcPath, fullDstPath); err != nil {
+		if removeErr := os.RemoveAll(fullDstPath); removeErr != nil {
+			log.Printf("Warning: Failed to remove partial copy '%s': %v", fullDstPath, removeErr)
+		}
+		return err
+	}
+	p.logger.Infof("LocalFilesystemProvider.CopyItem: Copied '%s' to '%s'.", fullSrcPath, fullDstPath)
+	return nil
+}
+
+// copyLocalDirectory recursively copies srcDir to dstDir, which must not exist.
+func copyLocalDirectory(ctx context.Context, srcDir string, dstDir string) error {
+	type dirTimes struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []dirTimes
+
+	err := filepath.WalkDir(srcDir, func(path string, entry os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dstDir, relPath)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			if err := os.Mkdir(target, info.Mode().Perm()|0700); err != nil {
+				return fmt.Errorf("error creating directory '%s': %w", target, err)
+			}
+			dirs = append(dirs, dirTimes{path: target, modTime: info.ModTime()})
+		case info.Mode().IsRegular():
+			if err := copyLocalFile(ctx, path, target, info); err != nil {
+				return err
+			}
+		default:
+			// Link simbolici e file speciali non vengono copiati.
+			if config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("LocalFilesystemProvider.CopyItem: Skipping non-regular file '%s'", path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if os.IsPermission(err) {
+			return storage.ErrPermissionDenied
+		}
+		return err
+	}
+
+	// Le date delle directory vanno impostate dopo averle riempite, partendo dalle più profonde.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime); err != nil && config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("LocalFilesystemProvider.CopyItem: Cannot preserve modification time of '%s': %v", dirs[i].path, err)
+		}
+	}
+	return nil
+}
+
+// copyLocalFile stream-copies a regular file, preserving permissions and modification time.
+func copyLocalFile(ctx context.Context, srcPath string, dstPath string, srcInfo os.FileInfo) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		if os.IsPermission(err) {
+			return storage.ErrPermissionDenied
+		}
+		return fmt.Errorf("error opening '%s' for copy: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, srcInfo.Mode().Perm())
+	if err != nil {
+		if os.IsExist(err) {
+			return storage.ErrAlreadyExists
+		}
+		if os.IsPermission(err) {
+			return storage.ErrPermissionDenied
+		}
+		return fmt.Errorf("error creating '%s': %w", dstPath, err)
+	}
+	if _, err := io.Copy(dst, &contextReader{ctx: ctx, r: src}); err != nil {
+		dst.Close()
+		return fmt.Errorf("error copying '%s' to '%s': %w", srcPath, dstPath, err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("error closing '%s': %w", dstPath, err)
+	}
+	if err := os.Chtimes(dstPath, srcInfo.ModTime(), srcInfo.ModTime()); err != nil && config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("LocalFilesystemProvider.CopyItem: Cannot preserve modification time of '%s': %v", dstPath, err)
+	}
+	return nil
+}
+
+// copyStoredChecksum copies the checksum sidecar of a single copied file: con la data di modifica
+// preservata resta valido anche per la copia.
+func copyStoredChecksum(ctx context.Context, srcPath string, dstPath string) {
+	sidecarPath := checksumSidecarPath(srcPath)
+	sidecarInfo, err := os.Stat(sidecarPath)
+	if err != nil {
+		return
+	}
+	if err := copyLocalFile(ctx, sidecarPath, checksumSidecarPath(dstPath), sidecarInfo); err != nil {
+		log.Printf("Warning: Failed to copy checksum sidecar of '%s': %v", srcPath, err)
+	}
+}
+
+// contextReader stops a copy as soon as the context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// --- Nuove strutture e variabili globali per la gestione degli upload locali ---
+
+// chunkWriteRequest incapsula il reader di un chunk e la sua posizione. La goroutine di scrittura copia
+// al massimo MaxBytes byte da Reader e invia l'esito su Result (bufferizzato), su cui WriteChunk attende:
+// il reader è valido solo per la durata della richiesta HTTP. Checksum, se presente, viene verificato
+// sui byte copiati prima di considerare il chunk scritto.
+type chunkWriteRequest struct {
+	Reader     io.Reader
+	ChunkIndex int64
+	ChunkSize  int64
+	MaxBytes   int64
+	Checksum   storage.ChunkChecksum
+	Result     chan chunkWriteResult
+}
+
+// chunkWriteResult is the outcome of a chunkWriteRequest.
+type chunkWriteResult struct {
+	Written int64
+	Err     error
+}
+
+// localUploadSession rappresenta lo stato di un upload di file in corso per lo storage locale.
+type localUploadSession struct {
+	TempFile         *os.File        // File temporaneo per scrivere i chunk
+	ReceivedChunks   map[int64]bool  // Mappa per tracciare gli indici dei chunk ricevuti
+	ReceivedBytes    map[int64]int64 // Byte ricevuti per chunk (un chunk reinviato non viene contato due volte)
+	ExpectedChunks   int64           // Numero totale di chunk attesi
+	ExpectedFileSize int64           // Dimensione totale del file attesa
+	FinalPath        string          // Percorso finale richiesto all'initiate (il finalize può pubblicare altrove)
+	UploadID         string          // Chiave opaca della sessione, indipendente dal path finale
+	StorageName      string          // Dati salvati nel file di stato (upload_temp.session_state_file)
+	ItemPath         string
+	ChunkSize        int64
+	UserEmail        string
+	writtenBytes     map[int64]int64 // Byte per chunk già scritti nel file temporaneo (solo questi vengono salvati)
+
+	chunkBuffer chan chunkWriteRequest // Canale bufferizzato per ricevere i chunk da scrivere
+	done        chan struct{}          // Segnale per terminare la goroutine di scrittura
+	writerWg    sync.WaitGroup         // WaitGroup per attendere le goroutine di scrittura
+	writerError atomic.Value           // Per propagare errori dalla goroutine di scrittura
+	mu          sync.Mutex             // Mutex per proteggere l'accesso concorrente alla sessione
+
+	// sealMu protegge sealed: WriteChunk lo tiene in lettura mentre consegna il chunk, il finalize in scrittura
+	// per chiudere chunkBuffer. Una sessione sigillata ha il file temporaneo completo e verificato (sha256) e
+	// resta registrata finché la pubblicazione non riesce: un finalize rifiutato per conflitto può essere ripetuto.
+	sealMu   sync.RWMutex
+	sealed   bool
+	sha256   string
+	doneOnce sync.Once // Chiusura di done, condivisa da finalize e cancel
+}
+
+var localOngoingUploadSessions = make(map[string]*localUploadSession) // Mappa: "storage:uploadID" -> sessione
+var localUploadSessionsMutex sync.Mutex                               // Mutex per proteggere la mappa localOngoingUploadSessions
+
+// startWriters starts the writer goroutines of the session (upload_writers, almeno una). Il file temporaneo è
+// pre-allocato e ogni chunk viene scritto al proprio offset, quindi più goroutine possono scrivere in parallelo.
+func (s *localUploadSession) startWriters(writers int) {
+	if writers < 1 {
+		writers = 1
+	}
+	s.writerWg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go s.writerGoroutine()
+	}
+}
+
+// writerGoroutine è una goroutine di scrittura che scrive i chunk sul file temporaneo, copiandoli dal reader
+// della richiesta all'offset del chunk senza bufferizzarli interamente in memoria.
+func (s *localUploadSession) writerGoroutine() {
+	defer s.writerWg.Done()
+	log.Printf("Local upload writerGoroutine started for temp file: %s", s.TempFile.Name())
+
+	for {
+		select {
+		case req, ok := <-s.chunkBuffer:
+			if !ok { // Canale chiuso, nessun altro chunk arriverà
+				log.Printf("Local upload writerGoroutine: chunkBuffer closed for %s. Exiting.", s.TempFile.Name())
+				return
+			}
+
+			// Dopo un errore del file temporaneo la goroutine resta attiva solo per rispondere ai chunk in coda.
+			if errVal := s.writerError.Load(); errVal != nil {
+				req.Result <- chunkWriteResult{Err: errVal.(error)}
+				continue
+			}
+
+			// Calcola l'offset di scrittura: WriteAt non sposta il puntatore del file.
+			offset := req.ChunkIndex * req.ChunkSize
+			writer := &chunkFileWriter{w: io.NewOffsetWriter(s.TempFile, offset)}
+			reader := req.Reader
+			var verifier *storage.ChunkVerifier
+			if !req.Checksum.IsZero() {
+				verifier = req.Checksum.NewVerifier()
+				reader = io.TeeReader(req.Reader, verifier)
+			}
+			n, err := io.CopyN(writer, reader, req.MaxBytes)
+			if writer.err != nil {
+				// Errore del file temporaneo (es. disco pieno): la sessione non è più utilizzabile.
+				s.writerError.Store(fmt.Errorf("writerGoroutine: error writing chunk %d to temporary file: %w", req.ChunkIndex, err))
+				log.Printf("Local upload writerGoroutine error: %v", s.writerError.Load())
+				req.Result <- chunkWriteResult{Written: n, Err: s.writerError.Load().(error)}
+				continue
+			}
+			if err != nil && err != io.EOF {
+				// Errore di lettura dal client (es. disconnessione): solo questo chunk è da reinviare.
+				req.Result <- chunkWriteResult{Written: n, Err: fmt.Errorf("error reading chunk %d: %w", req.ChunkIndex, err)}
+				continue
+			}
+			if err == nil {
+				// Copiati MaxBytes byte: se il reader ne ha altri il chunk eccede la suddivisione dichiarata.
+				var extra [1]byte
+				if extraRead, _ := io.ReadFull(req.Reader, extra[:]); extraRead > 0 {
+					req.Result <- chunkWriteResult{Written: n, Err: oversizedChunkError(req, s.ExpectedFileSize)}
+					continue
+				}
+			}
+
+			if verifier != nil {
+				if err := verifier.Verify(req.ChunkIndex); err != nil {
+					// I byte scritti hanno sostituito quelli di un eventuale invio precedente: il chunk torna
+					// da ricevere e il client deve reinviarlo.
+					s.mu.Lock()
+					_, wasWritten := s.writtenBytes[req.ChunkIndex]
+					delete(s.writtenBytes, req.ChunkIndex)
+					s.mu.Unlock()
+					if wasWritten {
+						saveUploadSessions()
+					}
+					req.Result <- chunkWriteResult{Written: n, Err: err}
+					continue
+				}
+			}
+
+			if config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("Local upload writerGoroutine: Wrote chunk %d (%d bytes) to %s", req.ChunkIndex, n, s.TempFile.Name())
+			}
+			// Solo i chunk già scritti vengono salvati nel file di stato: dopo un riavvio il client
+			// reinvia quelli che erano ancora nel buffer.
+			s.mu.Lock()
+			s.writtenBytes[req.ChunkIndex] = n
+			s.mu.Unlock()
+			saveUploadSessions()
+			req.Result <- chunkWriteResult{Written: n}
+
+		case <-s.done: // Segnale di terminazione ricevuto
+			log.Printf("Local upload writerGoroutine: Done signal received for %s. Exiting.", s.TempFile.Name())
+			return
+		}
+	}
+}
+
+// chunkFileWriter records the write errors of the temporary file, to tell them apart from the read
+// errors of the client in io.CopyN.
+type chunkFileWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (cw *chunkFileWriter) Write(data []byte) (int, error) {
+	n, err := cw.w.Write(data)
+	if err != nil {
+		cw.err = err
+	}
+	return n, err
+}
+
+// oversizedChunkError describes a chunk longer than the space reserved for it: la dimensione del chunk,
+// o per l'ultimo chunk il resto della dimensione dichiarata all'initiate.
+func oversizedChunkError(req chunkWriteRequest, expectedFileSize int64) error {
+	if req.MaxBytes < req.ChunkSize {
+		return fmt.Errorf("%w: chunk %d ends after byte %d, declared size is %d", storage.ErrSizeExceeded, req.ChunkIndex, req.ChunkIndex*req.ChunkSize+req.MaxBytes, expectedFileSize)
+	}
+	return fmt.Errorf("%w: chunk %d is larger than the chunk size %d", storage.ErrInvalidChunk, req.ChunkIndex, req.ChunkSize)
+}
+
+
+// InitiateUpload starts a new upload session or resumes an existing one for a local file.
+// Ora accetta anche totalFileSize e chunkSize per una gestione più precisa. La sessione è identificata da
+// uploadID: filePath indica solo dove creare il file temporaneo, il path finale viene deciso al finalize.
+func (p *LocalFilesystemProvider) InitiateUpload(ctx context.Context, claims *auth.UserClaims, uploadID string, filePath string, totalFileSize int64, chunkSize int64) (int64, error) {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	slog.Info("LocalFilesystemProvider.InitiateUpload", logging.KeyUser, userIdent, logging.KeyStorage, p.name, logging.KeyPath, filePath, "upload_id", uploadID, "total_file_size", totalFileSize, "chunk_size", chunkSize)
+
+	fullPath, err := p.validatePath(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("path validation error: %w", err)
+	}
+	if err := p.checkSymlinkComponents(fullPath, false); err != nil {
+		return 0, err
+	}
+	// La suddivisione in chunk dichiarata qui è quella con cui WriteChunk valida indici e dimensioni.
+	if totalFileSize < 0 || chunkSize <= 0 {
+		return 0, fmt.Errorf("%w: invalid total file size %d or chunk size %d", storage.ErrInvalidChunk, totalFileSize, chunkSize)
+	}
+	if err := storage.CheckUploadSize(p.maxUploadBytes, totalFileSize); err != nil {
+		return 0, err
+	}
+
+	dir := filepath.Dir(fullPath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		default:
+		}
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return 0, fmt.Errorf("error creating directory '%s': %w", dir, err)
+		}
+	} else if err != nil {
+		return 0, fmt.Errorf("error checking directory '%s': %w", dir, err)
+	}
+
+	uploadKey := fmt.Sprintf("%s:%s", p.name, uploadID)
+
+	localUploadSessionsMutex.Lock()
+	session, exists := localOngoingUploadSessions[uploadKey]
+	localUploadSessionsMutex.Unlock()
 
 	var currentSize int64 = 0
 
 	if !exists {
-		// Crea un file temporaneo per l'upload
-		// Utilizziamo il percorso della directory di destinazione per il file temporaneo
-		tempDir := dir
-		tempFile, err := os.CreateTemp(tempDir, "upload-*.tmp")
+		// Crea un file temporaneo per l'upload nella directory riservata (upload_temp_dir o .clouddav-uploads
+		// sotto la radice, sullo stesso filesystem delle destinazioni)
+		tempDir := p.tempDirectory()
+		if err := os.MkdirAll(tempDir, 0755); err != nil {
+			return 0, fmt.Errorf("error creating upload temp directory '%s': %w", tempDir, err)
+		}
+		tempFile, err := os.CreateTemp(tempDir, uploadTempPattern)
 		if err != nil {
 			return 0, fmt.Errorf("error creating temporary file for upload: %w", err)
 		}
@@ -598,26 +1220,31 @@ func (p *LocalFilesystemProvider) InitiateUpload(ctx context.Context, claims *au
 		expectedChunks := (totalFileSize + chunkSize - 1) / chunkSize // Calcola il numero totale di chunk attesi
 
 		session = &localUploadSession{
-			TempFile:        tempFile,
-			ReceivedChunks:  make(map[int64]bool),
-			ExpectedChunks:  expectedChunks,
+			TempFile:         tempFile,
+			ReceivedChunks:   make(map[int64]bool),
+			ReceivedBytes:    make(map[int64]int64),
+			ExpectedChunks:   expectedChunks,
 			ExpectedFileSize: totalFileSize,
-			FinalPath:       fullPath,
-			chunkBuffer:     make(chan chunkWriteRequest, 100), // Buffer di 100 chunk (tunabile)
-			done:            make(chan struct{}),
+			FinalPath:        fullPath,
+			UploadID:         uploadID,
+			StorageName:      p.name,
+			ItemPath:         filePath,
+			ChunkSize:        chunkSize,
+			UserEmail:        userIdent,
+			writtenBytes:     make(map[int64]int64),
+			chunkBuffer:      make(chan chunkWriteRequest, 100), // Buffer di 100 chunk (tunabile)
+			done:             make(chan struct{}),
 		}
 		
-		// Avvia la goroutine di scrittura per questa sessione
-		session.writerWg.Add(1)
-		go session.writerGoroutine()
+		// Avvia le goroutine di scrittura per questa sessione
+		session.startWriters(p.uploadWriters)
 
 		localUploadSessionsMutex.Lock()
 		localOngoingUploadSessions[uploadKey] = session
 		localUploadSessionsMutex.Unlock()
+		saveUploadSessions()
 
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Initiated new local upload session for storage '%s', path '%s'. Temp file: '%s', Expected chunks: %d, Total size: %d", p.name, filePath, tempFile.Name(), expectedChunks, totalFileSize)
-		}
+		p.logger.Infof("Initiated new local upload session '%s' for storage '%s', path '%s'. Temp file: '%s', Expected chunks: %d, Total size: %d", uploadID, p.name, filePath, tempFile.Name(), expectedChunks, totalFileSize)
 	} else {
 		// Sessione esistente, riprendi l'upload
 		// Qui non avviamo una nuova goroutine di scrittura, assumiamo che sia già attiva.
@@ -627,39 +1254,52 @@ func (p *LocalFilesystemProvider) InitiateUpload(ctx context.Context, claims *au
 		session.mu.Lock() // Protegge l'accesso alla sessione per la lettura dello stato
 		defer session.mu.Unlock()
 
+		// Una ripresa deve usare la stessa suddivisione: con un'altra dimensione dei chunk gli offset dei
+		// chunk già scritti non corrisponderebbero più agli indici.
+		if totalFileSize != session.ExpectedFileSize || chunkSize != session.ChunkSize {
+			return 0, fmt.Errorf("%w: upload of '%s' was initiated with total file size %d and chunk size %d, resumed with %d and %d", storage.ErrInvalidChunk, filePath, session.ExpectedFileSize, session.ChunkSize, totalFileSize, chunkSize)
+		}
+
 		fileInfo, err := session.TempFile.Stat()
 		if err != nil {
 			session.TempFile.Close()
+			localUploadSessionsMutex.Lock()
 			delete(localOngoingUploadSessions, uploadKey) // Pulisci la sessione rotta
+			localUploadSessionsMutex.Unlock()
 			return 0, fmt.Errorf("error getting temp file info for resuming upload '%s': %w", session.TempFile.Name(), err)
 		}
 		currentSize = fileInfo.Size()
 
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Resuming local upload session for storage '%s', path '%s'. Temp file: '%s', Current size: %d", p.name, filePath, session.TempFile.Name(), currentSize)
-		}
+		p.logger.Infof("Resuming local upload session '%s' for storage '%s', path '%s'. Temp file: '%s', Current size: %d", uploadID, p.name, filePath, session.TempFile.Name(), currentSize)
 	}
 
 	return currentSize, nil
 }
 
-// WriteChunk invia un chunk di dati alla goroutine di scrittura della sessione.
-func (p *LocalFilesystemProvider) WriteChunk(ctx context.Context, claims *auth.UserClaims, filePath string, chunkData []byte, chunkIndex int64, chunkSize int64) error {
+// WriteChunk invia un chunk di dati alle goroutine di scrittura della sessione. Con checksum il chunk viene
+// accettato solo se i byte ricevuti corrispondono (ErrChunkChecksumMismatch altrimenti).
+func (p *LocalFilesystemProvider) WriteChunk(ctx context.Context, claims *auth.UserClaims, uploadID string, chunkData io.Reader, chunkIndex int64, chunkSize int64, checksum storage.ChunkChecksum) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("LocalFilesystemProvider.WriteChunk chiamato da utente '%s' per storage '%s', path '%s', chunkIndex %d", userIdent, p.name, filePath, chunkIndex)
-	}
+	p.logger.Debugf("LocalFilesystemProvider.WriteChunk chiamato da utente '%s' per storage '%s', upload '%s', chunkIndex %d", userIdent, p.name, uploadID, chunkIndex)
 
-	uploadKey := fmt.Sprintf("%s:%s", p.name, filePath)
+	uploadKey := fmt.Sprintf("%s:%s", p.name, uploadID)
 	localUploadSessionsMutex.Lock()
 	session, ok := localOngoingUploadSessions[uploadKey]
 	localUploadSessionsMutex.Unlock()
 
 	if !ok || session == nil || session.TempFile == nil {
-		return errors.New("local upload session not found or invalid")
+		return fmt.Errorf("%w: local upload '%s'", storage.ErrUploadNotFound, uploadID)
+	}
+
+	// Il finalize chiude chunkBuffer tenendo sealMu in scrittura: il lock in lettura garantisce che il canale
+	// resti aperto finché questo chunk non è stato consegnato e scritto.
+	session.sealMu.RLock()
+	defer session.sealMu.RUnlock()
+	if session.sealed {
+		return fmt.Errorf("%w: upload '%s' is already being finalized", storage.ErrInvalidChunk, uploadID)
 	}
 
 	// Controlla se la goroutine di scrittura ha segnalato un errore
@@ -667,21 +1307,31 @@ func (p *LocalFilesystemProvider) WriteChunk(ctx context.Context, claims *auth.U
 		return errVal.(error) // Propaga l'errore dalla goroutine di scrittura
 	}
 
-	// Marca il chunk come ricevuto (protetto da mutex)
-	session.mu.Lock()
-	session.ReceivedChunks[chunkIndex] = true
-	session.mu.Unlock()
+	// Il chunk deve rientrare nella suddivisione dichiarata all'initiate: un indice fuori intervallo o una
+	// dimensione diversa sposterebbero la scrittura fuori dal file temporaneo pre-allocato o sopra altri chunk.
+	if chunkSize != session.ChunkSize {
+		return fmt.Errorf("%w: chunk size %d differs from the chunk size %d declared at initiate", storage.ErrInvalidChunk, chunkSize, session.ChunkSize)
+	}
+	if chunkIndex < 0 || chunkIndex >= session.ExpectedChunks {
+		return fmt.Errorf("%w: chunk index %d out of range [0, %d)", storage.ErrInvalidChunk, chunkIndex, session.ExpectedChunks)
+	}
+	// I chunk non superano la dimensione dichiarata, quindi il limite vale per i byte ricevuti se vale per quella;
+	// una sessione ripristinata all'avvio può essere stata iniziata prima di un max_upload_bytes più basso.
+	if err := storage.CheckUploadSize(p.maxUploadBytes, session.ExpectedFileSize); err != nil {
+		return err
+	}
+	// La lunghezza del chunk si conosce solo leggendolo: la goroutine di scrittura copia al massimo lo spazio
+	// riservato al chunk (il resto della dimensione dichiarata per l'ultimo) e rifiuta i byte in eccesso.
+	maxBytes := min(chunkSize, session.ExpectedFileSize-chunkIndex*chunkSize)
+	req := chunkWriteRequest{Reader: chunkData, ChunkIndex: chunkIndex, ChunkSize: chunkSize, MaxBytes: maxBytes, Checksum: checksum, Result: make(chan chunkWriteResult, 1)}
 
 	// Invia il chunk alla goroutine di scrittura tramite il canale bufferizzato
 	select {
-	case session.chunkBuffer <- chunkWriteRequest{Data: chunkData, ChunkIndex: chunkIndex, ChunkSize: chunkSize}:
-		// Chunk inviato con successo al buffer
-		return nil
+	case session.chunkBuffer <- req:
+		// Chunk preso in carico dalla goroutine di scrittura
 	case <-ctx.Done():
 		// Il contesto della richiesta è stato annullato
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("Context cancelled during local WriteChunk (sending to buffer) for '%s': %v", filePath, ctx.Err())
-		}
+		p.logger.Debugf("Context cancelled during local WriteChunk (sending to buffer) for upload '%s': %v", uploadID, ctx.Err())
 		return ctx.Err()
 	case <-session.done:
 		// La sessione è stata terminata (es. annullata) mentre si tentava di inviare un chunk
@@ -689,137 +1339,286 @@ func (p *LocalFilesystemProvider) WriteChunk(ctx context.Context, claims *auth.U
 	case <-time.After(5 * time.Second): // Timeout per l'invio al buffer
 		// Questo timeout si verifica se il buffer è pieno e la goroutine di scrittura è lenta.
 		// Indica un problema di backpressure o una writerGoroutine bloccata.
-		log.Printf("Warning: Timeout sending chunk %d to buffer for file '%s'. Buffer might be full or writer goroutine is stuck.", chunkIndex, filePath)
+		log.Printf("Warning: Timeout sending chunk %d to buffer for upload '%s'. Buffer might be full or writer goroutine is stuck.", chunkIndex, uploadID)
 		return errors.New("timeout sending chunk to internal buffer")
 	}
-}
 
-// FinalizeUpload closes the file handle for a local upload session, reassembles the file,
-// performs SHA256 integrity check, and moves it to its final destination.
-func (p *LocalFilesystemProvider) FinalizeUpload(claims *auth.UserClaims, filePath string, expectedSHA256 string) error {
-	userIdent := "unauthenticated"
-	if claims != nil {
-		userIdent = claims.Email
+	// Il reader appartiene alla richiesta: si attende che la goroutine di scrittura abbia finito di copiarlo.
+	// Un contesto annullato interrompe la lettura dal client, quindi il risultato arriva comunque; se la
+	// sessione termina, si attende l'uscita della goroutine prima di restituire il reader al chiamante.
+	var result chunkWriteResult
+	select {
+	case result = <-req.Result:
+	case <-session.done:
+		session.writerWg.Wait()
+		return errors.New("upload session terminated while writing chunk")
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("LocalFilesystemProvider.FinalizeUpload chiamato da utente '%s' per storage '%s', path '%s'. SHA256 atteso: %s", userIdent, p.name, filePath, expectedSHA256)
+	if result.Err != nil {
+		if errors.Is(result.Err, storage.ErrChunkChecksumMismatch) {
+			session.mu.Lock()
+			delete(session.ReceivedChunks, chunkIndex)
+			delete(session.ReceivedBytes, chunkIndex)
+			session.mu.Unlock()
+		}
+		return result.Err
 	}
 
-	uploadKey := fmt.Sprintf("%s:%s", p.name, filePath)
-	localUploadSessionsMutex.Lock()
-	session, ok := localOngoingUploadSessions[uploadKey]
-	if ok {
-		delete(localOngoingUploadSessions, uploadKey) // Rimuovi dalla mappa prima di finalizzare
-	}
-	localUploadSessionsMutex.Unlock()
+	// Marca il chunk come ricevuto (protetto da mutex)
+	session.mu.Lock()
+	session.ReceivedChunks[chunkIndex] = true
+	session.ReceivedBytes[chunkIndex] = result.Written
+	session.mu.Unlock()
+	return nil
+}
 
-	if !ok || session == nil || session.TempFile == nil {
-		return errors.New("local upload session not found or invalid")
+// stopWriter closes done once, terminando la goroutine di scrittura anche se ha ancora chunk in coda.
+func (s *localUploadSession) stopWriter() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// seal stops accepting chunks, waits for the queued ones and verifies the complete temporary file (chunk,
+// dimensione, SHA256). Va chiamata tenendo sealMu in scrittura; dopo il primo successo un nuovo finalize
+// (es. dopo un conflitto) confronta solo expectedSHA256 con l'hash già calcolato.
+func (p *LocalFilesystemProvider) seal(session *localUploadSession, expectedSHA256 string) error {
+	if session.sealed {
+		if session.sha256 == "" {
+			return fmt.Errorf("%w: upload '%s' failed verification", storage.ErrUploadNotFound, session.UploadID)
+		}
+		if expectedSHA256 != "" && expectedSHA256 != session.sha256 {
+			return storage.ErrIntegrityCheckFailed
+		}
+		return nil
 	}
 
-	// Segnala alla goroutine di scrittura di terminare e chiudi il canale per assicurare che non vengano inviati più chunk
+	// Chiude il canale per assicurare che non vengano inviati più chunk: la goroutine di scrittura termina dopo
+	// aver scritto quelli ancora nel buffer. done va chiuso solo dopo, altrimenti la select della goroutine
+	// potrebbe sceglierlo e scartare i chunk in coda.
+	session.sealed = true
 	close(session.chunkBuffer)
-	close(session.done) // Segnala anche la terminazione esplicita
 	session.writerWg.Wait() // Attendi che la goroutine di scrittura abbia terminato
+	session.stopWriter()    // Segnala anche la terminazione esplicita
 
 	// Controlla se la goroutine di scrittura ha segnalato un errore
 	if errVal := session.writerError.Load(); errVal != nil {
-		// Se c'è stato un errore durante la scrittura asincrona, pulisci e restituisci l'errore
-		session.TempFile.Close()
-		os.Remove(session.TempFile.Name())
 		return fmt.Errorf("error during asynchronous chunk writing: %w", errVal.(error))
 	}
 
-	session.mu.Lock() // Blocca la sessione durante la finalizzazione
-	defer session.mu.Unlock()
+	session.mu.Lock()
+	receivedChunks := int64(len(session.ReceivedChunks))
+	var receivedBytes int64
+	for _, n := range session.ReceivedBytes {
+		receivedBytes += n
+	}
+	session.mu.Unlock()
 
 	// Controlla se tutti i chunk sono stati ricevuti
-	if int64(len(session.ReceivedChunks)) != session.ExpectedChunks {
-		session.TempFile.Close() // Chiudi il file temporaneo
-		os.Remove(session.TempFile.Name()) // Elimina il file temporaneo incompleto
-		return fmt.Errorf("missing chunks for file '%s'. Expected %d, received %d", filePath, session.ExpectedChunks, len(session.ReceivedChunks))
+	if receivedChunks != session.ExpectedChunks {
+		return fmt.Errorf("missing chunks for upload '%s'. Expected %d, received %d", session.UploadID, session.ExpectedChunks, receivedChunks)
+	}
+	if p.strictUploadSize && receivedBytes != session.ExpectedFileSize {
+		return fmt.Errorf("%w: upload '%s' declared %d bytes, received %d", storage.ErrSizeMismatch, session.UploadID, session.ExpectedFileSize, receivedBytes)
 	}
 
 	// Assicurati che il file temporaneo sia sincronizzato su disco prima di leggerlo
-	err := session.TempFile.Sync()
-	if err != nil {
-		session.TempFile.Close()
-		os.Remove(session.TempFile.Name())
+	if err := session.TempFile.Sync(); err != nil {
 		return fmt.Errorf("error syncing temporary file '%s': %w", session.TempFile.Name(), err)
 	}
-
 	// Riporta il puntatore del file temporaneo all'inizio per la lettura e l'hashing
-	_, err = session.TempFile.Seek(0, io.SeekStart)
-	if err != nil {
-		session.TempFile.Close()
-		os.Remove(session.TempFile.Name())
+	if _, err := session.TempFile.Seek(0, io.SeekStart); err != nil {
 		return fmt.Errorf("error seeking to start of temporary file '%s': %w", session.TempFile.Name(), err)
 	}
+	hasher := sha256.New()
+	hashedBytes, err := io.Copy(hasher, session.TempFile)
+	if err != nil {
+		return fmt.Errorf("error hashing temporary file '%s': %w", session.TempFile.Name(), err)
+	}
+	if hashedBytes != session.ExpectedFileSize {
+		return fmt.Errorf("temporary file size mismatch for upload '%s'. Expected %d, found %d", session.UploadID, session.ExpectedFileSize, hashedBytes)
+	}
+	calculatedSHA256 := hex.EncodeToString(hasher.Sum(nil))
+
+	// Verifica di integrità SHA256
+	if expectedSHA256 != "" {
+		if p.logger.IsDebug() {
+			log.Printf("Local: Calculated SHA256 for upload '%s': %s", session.UploadID, calculatedSHA256)
+			log.Printf("Local: Expected SHA256 for upload '%s': %s", session.UploadID, expectedSHA256)
+		}
+		if calculatedSHA256 != expectedSHA256 {
+			slog.Error("SHA256 mismatch for local upload", logging.KeyUser, session.UserEmail, logging.KeyStorage, p.name, "upload_id", session.UploadID, "calculated_sha256", calculatedSHA256, "expected_sha256", expectedSHA256)
+			return storage.ErrIntegrityCheckFailed
+		}
+		p.logger.Infof("Local: SHA256 integrity check passed for upload '%s'.", session.UploadID)
+	} else {
+		p.logger.Debugf("Local: SHA256 integrity check skipped for upload '%s' (no expected hash provided).", session.UploadID)
+	}
+	session.sha256 = calculatedSHA256
+	return nil
+}
+
+// publishUploadFile moves the verified temporary file to fullPath in one step, così nessun client vede mai un
+// file parziale. Senza overwrite usa un hard link, che fallisce atomicamente se la destinazione esiste già
+// (ErrAlreadyExists); con overwrite una rename che sostituisce il file esistente. Con upload_temp_dir su un
+// altro filesystem il file viene prima copiato in un temporaneo accanto alla destinazione.
+func publishUploadFile(ctx context.Context, tempPath string, fullPath string, overwrite bool) error {
+	var err error
+	if overwrite {
+		err = os.Rename(tempPath, fullPath)
+	} else {
+		err = os.Link(tempPath, fullPath)
+		if err == nil {
+			os.Remove(tempPath)
+		}
+	}
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrExist):
+		return storage.ErrAlreadyExists
+	case errors.Is(err, syscall.EXDEV):
+		return publishUploadCopy(ctx, tempPath, fullPath, overwrite)
+	case !overwrite:
+		// Filesystem senza hard link (es. FAT, alcuni mount di rete): resta il controllo di esistenza
+		// seguito dalla rename, non atomico.
+		if _, statErr := os.Lstat(fullPath); statErr == nil {
+			return storage.ErrAlreadyExists
+		}
+		err = os.Rename(tempPath, fullPath)
+		if err == nil {
+			return nil
+		}
+	}
+	if os.IsPermission(err) {
+		return storage.ErrPermissionDenied
+	}
+	return fmt.Errorf("error publishing upload to '%s': %w", fullPath, err)
+}
 
-	// Crea il file di destinazione finale
-	finalFile, err := os.Create(session.FinalPath)
+// publishUploadCopy copies tempPath next to fullPath and publishes the copy, per upload_temp_dir su un
+// filesystem diverso da quello dello storage.
+func publishUploadCopy(ctx context.Context, tempPath string, fullPath string, overwrite bool) error {
+	source, err := os.Open(tempPath)
+	if err != nil {
+		return fmt.Errorf("error opening temporary file '%s': %w", tempPath, err)
+	}
+	defer source.Close()
+	copyFile, err := os.CreateTemp(filepath.Dir(fullPath), uploadTempPattern)
 	if err != nil {
-		session.TempFile.Close()
-		os.Remove(session.TempFile.Name())
 		if os.IsPermission(err) {
 			return storage.ErrPermissionDenied
 		}
-		return fmt.Errorf("error creating final file '%s': %w", session.FinalPath, err)
+		return fmt.Errorf("error creating temporary copy for '%s': %w", fullPath, err)
+	}
+	_, err = io.Copy(copyFile, &contextReader{ctx: ctx, r: source})
+	if err == nil {
+		err = copyFile.Chmod(0644)
+	}
+	if closeErr := copyFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = publishUploadFile(ctx, copyFile.Name(), fullPath, overwrite)
 	}
-	defer finalFile.Close()
+	if err != nil {
+		os.Remove(copyFile.Name())
+		return err
+	}
+	os.Remove(tempPath)
+	return nil
+}
 
-	// Inizializza l'hasher SHA256
-	hasher := sha256.New()
+// discardUploadSession removes a session and its temporary file after a failed finalize or a cancel.
+func discardUploadSession(uploadKey string, session *localUploadSession) {
+	localUploadSessionsMutex.Lock()
+	if localOngoingUploadSessions[uploadKey] == session {
+		delete(localOngoingUploadSessions, uploadKey)
+	}
+	localUploadSessionsMutex.Unlock()
+	saveUploadSessions()
+	session.TempFile.Close()
+	if err := os.Remove(session.TempFile.Name()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing local temporary file '%s' of upload '%s': %v", session.TempFile.Name(), session.UploadID, err)
+	}
+}
 
-	// Crea un MultiWriter per scrivere contemporaneamente all'hasher e al file finale
-	mw := io.MultiWriter(finalFile, hasher)
+// FinalizeUpload verifies the temporary file of a local upload session (chunk, dimensione, SHA256) and
+// publishes it atomically at filePath. Il conflitto con un file esistente viene controllato solo qui: con
+// ErrAlreadyExists (o ErrIsDirectory) la sessione resta valida e il finalize può essere ripetuto con
+// overwrite o con un altro path; qualsiasi altro errore scarta la sessione.
+func (p *LocalFilesystemProvider) FinalizeUpload(claims *auth.UserClaims, uploadID string, filePath string, expectedSHA256 string, overwrite bool) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	slog.Info("LocalFilesystemProvider.FinalizeUpload", logging.KeyUser, userIdent, logging.KeyStorage, p.name, logging.KeyPath, filePath, "upload_id", uploadID, "expected_sha256", expectedSHA256, "overwrite", overwrite)
 
-	// Copia il contenuto dal file temporaneo al MultiWriter
-	bytesCopied, err := io.Copy(mw, session.TempFile)
+	fullPath, err := p.validatePath(filePath)
 	if err != nil {
-		session.TempFile.Close()
-		os.Remove(session.TempFile.Name())
-		os.Remove(session.FinalPath) // Rimuovi il file finale parziale
-		return fmt.Errorf("error copying data from temporary file to final destination and hasher: %w", err)
+		return fmt.Errorf("path validation error: %w", err)
+	}
+	if err := p.checkSymlinkComponents(fullPath, false); err != nil {
+		return err
 	}
 
-	// Verifica che la dimensione copiata corrisponda alla dimensione attesa
-	if bytesCopied != session.ExpectedFileSize {
-		session.TempFile.Close()
-		os.Remove(session.TempFile.Name())
-		os.Remove(session.FinalPath)
-		return fmt.Errorf("copied bytes mismatch for '%s'. Expected %d, copied %d", filePath, session.ExpectedFileSize, bytesCopied)
+	uploadKey := fmt.Sprintf("%s:%s", p.name, uploadID)
+	localUploadSessionsMutex.Lock()
+	session, ok := localOngoingUploadSessions[uploadKey]
+	localUploadSessionsMutex.Unlock()
+	if !ok || session == nil || session.TempFile == nil {
+		return fmt.Errorf("%w: local upload '%s'", storage.ErrUploadNotFound, uploadID)
 	}
 
-	// Chiudi il file temporaneo
-	session.TempFile.Close()
-	// Elimina il file temporaneo
-	os.Remove(session.TempFile.Name())
+	session.sealMu.Lock() // Serializza i finalize della sessione ed esclude i WriteChunk in corso
+	defer session.sealMu.Unlock()
+	if err := p.seal(session, expectedSHA256); err != nil {
+		discardUploadSession(uploadKey, session)
+		return err
+	}
 
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("Local upload finalized for storage '%s', path '%s'. Starting integrity check.", p.name, filePath)
+	if info, err := os.Lstat(fullPath); err == nil && info.IsDir() {
+		return storage.ErrIsDirectory
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		discardUploadSession(uploadKey, session)
+		if os.IsPermission(err) {
+			return storage.ErrPermissionDenied
+		}
+		return fmt.Errorf("error creating directory '%s': %w", filepath.Dir(fullPath), err)
+	}
+	// os.CreateTemp crea il file con permessi 0600: il file pubblicato ha quelli di un file creato normalmente.
+	if err := session.TempFile.Chmod(0644); err != nil {
+		discardUploadSession(uploadKey, session)
+		return fmt.Errorf("error setting permissions of temporary file '%s': %w", session.TempFile.Name(), err)
+	}
+	if err := publishUploadFile(context.Background(), session.TempFile.Name(), fullPath, overwrite); err != nil {
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return err
+		}
+		discardUploadSession(uploadKey, session)
+		return err
 	}
 
-	// Verifica di integrità SHA256
-	if expectedSHA256 != "" {
-		calculatedSHA256 := hex.EncodeToString(hasher.Sum(nil))
+	localUploadSessionsMutex.Lock()
+	delete(localOngoingUploadSessions, uploadKey)
+	localUploadSessionsMutex.Unlock()
+	saveUploadSessions()
+	session.TempFile.Close()
 
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("Local: Calculated SHA256 for '%s': %s", filePath, calculatedSHA256)
-			log.Printf("Local: Expected SHA256 for '%s': %s", filePath, expectedSHA256)
-		}
+	p.logger.Infof("Local upload '%s' finalized for storage '%s', published at '%s'.", uploadID, p.name, filePath)
 
-		if calculatedSHA256 != expectedSHA256 {
-			log.Printf("Error: SHA256 mismatch for local file '%s'. Calculated: %s, Expected: %s", filePath, calculatedSHA256, expectedSHA256)
-			os.Remove(session.FinalPath) // Elimina il file finale se l'hash non corrisponde
-			return storage.ErrIntegrityCheckFailed
+	// Lo SHA256 è già stato calcolato durante la verifica: salvarlo evita di rileggere il file in compute_hash.
+	// Un errore qui non invalida l'upload, al massimo l'hash verrà ricalcolato in seguito.
+	if p.storeChecksums || p.recordUploader {
+		var stored storedChecksum
+		if p.storeChecksums {
+			stored.SHA256 = session.sha256
 		}
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("Local: SHA256 integrity check passed for file '%s'.", filePath)
+		if p.recordUploader {
+			uploadedAt := time.Now().UTC()
+			stored.UploadedBy = storage.UploaderName(claims)
+			stored.UploadedAt = &uploadedAt
 		}
-	} else {
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("Local: SHA256 integrity check skipped for file '%s' (no expected hash provided).", filePath)
+		if err := writeSidecar(fullPath, stored); err != nil {
+			log.Printf("Warning: Failed to write sidecar for local file '%s': %v", filePath, err)
 		}
 	}
 
@@ -827,94 +1626,557 @@ func (p *LocalFilesystemProvider) FinalizeUpload(claims *auth.UserClaims, filePa
 }
 
 // CancelUpload cancels an ongoing local upload session and removes the incomplete file.
-func (p *LocalFilesystemProvider) CancelUpload(claims *auth.UserClaims, filePath string) error {
+func (p *LocalFilesystemProvider) CancelUpload(claims *auth.UserClaims, uploadID string) error {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("LocalFilesystemProvider.CancelUpload chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, filePath)
-	}
+	p.logger.Infof("LocalFilesystemProvider.CancelUpload chiamato da utente '%s' per storage '%s', upload '%s'", userIdent, p.name, uploadID)
 
-	uploadKey := fmt.Sprintf("%s:%s", p.name, filePath)
+	uploadKey := fmt.Sprintf("%s:%s", p.name, uploadID)
 	localUploadSessionsMutex.Lock()
 	session, ok := localOngoingUploadSessions[uploadKey]
-	if ok {
-		delete(localOngoingUploadSessions, uploadKey) // Rimuovi dalla mappa
-	}
 	localUploadSessionsMutex.Unlock()
 
 	if !ok || session == nil { // session.TempFile potrebbe essere nil se è già stato chiuso/rimosso
-		return errors.New("no ongoing local upload session found for cancellation or session invalid")
+		return fmt.Errorf("%w: local upload '%s'", storage.ErrUploadNotFound, uploadID)
 	}
 
-	// Segnala alla goroutine di scrittura di terminare e chiudi il canale
-	close(session.chunkBuffer)
-	close(session.done)
+	// Segnala alla goroutine di scrittura di terminare, poi chiude il canale: sealMu attende i WriteChunk
+	// e l'eventuale finalize in corso.
+	session.stopWriter()
+	session.sealMu.Lock()
+	if !session.sealed {
+		session.sealed = true
+		close(session.chunkBuffer)
+	}
+	session.sealMu.Unlock()
 	session.writerWg.Wait() // Attendi che la goroutine di scrittura abbia terminato
 
-	session.mu.Lock() // Blocca la sessione durante l'annullamento
+	localUploadSessionsMutex.Lock()
+	current := localOngoingUploadSessions[uploadKey]
+	localUploadSessionsMutex.Unlock()
+	if current != session { // Pubblicata o scartata da un finalize concorrente
+		return nil
+	}
+	p.logger.Infof("Local upload '%s' cancelled for storage '%s'. Removing incomplete temporary file '%s'.", uploadID, p.name, session.TempFile.Name())
+	discardUploadSession(uploadKey, session)
+	return nil
+}
+
+// GetUploadedSize returns the bytes received so far by a local upload session (0 se la sessione non esiste).
+func (p *LocalFilesystemProvider) GetUploadedSize(claims *auth.UserClaims, uploadID string) (int64, error) {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Debugf("LocalFilesystemProvider.GetUploadedSize chiamato da utente '%s' per storage '%s', upload '%s'", userIdent, p.name, uploadID)
+
+	uploadKey := fmt.Sprintf("%s:%s", p.name, uploadID)
+	localUploadSessionsMutex.Lock()
+	session, ok := localOngoingUploadSessions[uploadKey]
+	localUploadSessionsMutex.Unlock()
+
+	if !ok || session == nil || session.TempFile == nil {
+		return 0, nil
+	}
+
+	// Se c'è una sessione in corso, restituisci i byte ricevuti: il file temporaneo è pre-allocato
+	// alla dimensione dichiarata, quindi la sua size non indica l'avanzamento dell'upload.
+	session.mu.Lock()
 	defer session.mu.Unlock()
 
-	if session.TempFile != nil {
-		session.TempFile.Close() // Chiudi il file handle del temporaneo
+	var receivedBytes int64
+	for _, n := range session.ReceivedBytes {
+		receivedBytes += n
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("Local upload cancelled for storage '%s', path '%s'. Attempting to remove incomplete temporary file '%s'.", p.name, filePath, session.TempFile.Name())
+	return receivedBytes, nil
+}
+
+// --- Checksum salvati (sidecar) ---
+
+// checksumSidecarSuffix identifies the hidden sidecar files holding stored checksums.
+// Il sidecar di "dir/file.txt" è "dir/.file.txt.clouddav-sha256" e non compare nei listing.
+const checksumSidecarSuffix = ".clouddav-sha256"
+
+// storedChecksum is the content of a checksum sidecar. Size and ModTime are used to detect
+// whether the file changed after the checksum was computed. Con record_uploader il sidecar contiene
+// anche chi ha caricato il file e quando, e in quel caso SHA256 può essere vuoto.
+type storedChecksum struct {
+	SHA256     string     `json:"sha256"`
+	Size       int64      `json:"size"`
+	ModTime    time.Time  `json:"mod_time"`
+	UploadedBy string     `json:"uploaded_by,omitempty"`
+	UploadedAt *time.Time `json:"uploaded_at,omitempty"`
+}
+
+func isChecksumSidecar(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, checksumSidecarSuffix)
+}
+
+func checksumSidecarPath(fullPath string) string {
+	return filepath.Join(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+checksumSidecarSuffix)
+}
+
+// readSidecar returns the sidecar of the file, or nil if there is none
+// or the file was modified after it was written.
+func readSidecar(fullPath string, info os.FileInfo) *storedChecksum {
+	data, err := os.ReadFile(checksumSidecarPath(fullPath))
+	if err != nil {
+		return nil
+	}
+	var stored storedChecksum
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("Warning: Invalid checksum sidecar for '%s': %v", fullPath, err)
+		return nil
+	}
+	if stored.Size != info.Size() || !stored.ModTime.Equal(info.ModTime()) {
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("Local: Stored checksum for '%s' is stale (file changed), ignoring it.", fullPath)
+		}
+		return nil
+	}
+	return &stored
+}
+
+// writeSidecar saves stored together with the current size and modification time of the file.
+func writeSidecar(fullPath string, stored storedChecksum) error {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
 	}
+	return writeSidecarFor(fullPath, info, stored)
+}
 
-	removeErr := os.Remove(session.TempFile.Name()) // Rimuovi il file temporaneo
-	if removeErr != nil {
-		log.Printf("Error removing incomplete local temporary file '%s' after cancellation: %v", session.TempFile.Name(), removeErr)
-		return fmt.Errorf("error removing incomplete local temporary file: %w", removeErr)
+// writeSidecarFor saves stored together with the size and modification time in info.
+func writeSidecarFor(fullPath string, info os.FileInfo, stored storedChecksum) error {
+	stored.Size = info.Size()
+	stored.ModTime = info.ModTime()
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
 	}
-	if config.IsLogLevel(config.LogLevelInfo) {
-		log.Printf("Incomplete local temporary file '%s' removed after cancellation.", session.TempFile.Name())
+	return os.WriteFile(checksumSidecarPath(fullPath), data, 0644)
+}
+
+// writeStoredChecksum saves the SHA256 of the file, keeping the uploader recorded in a still valid sidecar.
+func writeStoredChecksum(fullPath string, sha256Hex string) error {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	return writeStoredChecksumFor(fullPath, info, sha256Hex)
+}
+
+// writeStoredChecksumFor is writeStoredChecksum for the version of the file described by info.
+func writeStoredChecksumFor(fullPath string, info os.FileInfo, sha256Hex string) error {
+	stored := storedChecksum{SHA256: sha256Hex}
+	if previous := readSidecar(fullPath, info); previous != nil {
+		stored.UploadedBy = previous.UploadedBy
+		stored.UploadedAt = previous.UploadedAt
+	}
+	return writeSidecarFor(fullPath, info, stored)
+}
+
+func removeStoredChecksum(fullPath string) {
+	if err := os.Remove(checksumSidecarPath(fullPath)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to remove checksum sidecar for '%s': %v", fullPath, err)
 	}
-	return nil
 }
 
-// GetUploadedSize returns the current size of a local file being uploaded.
-func (p *LocalFilesystemProvider) GetUploadedSize(claims *auth.UserClaims, filePath string) (int64, error) {
+// StoreChecksum saves a SHA256 computed outside of an upload (e.g. by compute_hash).
+// hashed is the item as read before hashing: se nel frattempo dimensione o data di modifica sono
+// cambiate il checksum non viene salvato (ErrPreconditionFailed). Il sidecar registra i valori di hashed,
+// così una modifica successiva al controllo lo rende comunque non valido.
+// Non fa nulla se store_checksums non è abilitato per lo storage.
+func (p *LocalFilesystemProvider) StoreChecksum(ctx context.Context, claims *auth.UserClaims, path string, sha256Hex string, hashed *storage.ItemInfo) error {
+	if !p.storeChecksums {
+		return nil
+	}
+	fullPath, err := p.validatePath(path)
+	if err != nil {
+		return fmt.Errorf("path validation error: %w", err)
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != hashed.Size || !info.ModTime().Equal(hashed.ModTime) {
+		return fmt.Errorf("%w: '%s' changed while its checksum was computed", storage.ErrPreconditionFailed, path)
+	}
+	return writeStoredChecksumFor(fullPath, info, sha256Hex)
+}
+
+// GetUsedBytes returns the total size of the files under the storage path, including the temp files of
+// ongoing uploads stored there (pre-allocati alla dimensione dichiarata, occupano già lo spazio).
+func (p *LocalFilesystemProvider) GetUsedBytes(ctx context.Context, claims *auth.UserClaims) (int64, error) {
+	var used int64
+	err := filepath.WalkDir(p.path, func(path string, d os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if path == p.path {
+				return walkErr
+			}
+			return nil // File rimosso durante la visita o non leggibile: non conta
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d.Type().IsRegular() {
+			if info, infoErr := d.Info(); infoErr == nil {
+				used += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error computing used space of '%s': %w", p.path, err)
+	}
+	return used, nil
+}
+
+// CanWrite checks whether an upload to path could create its files, without creating any: un upload scrive
+// un file temporaneo in upload_temp_dir (o .clouddav-uploads) e lo pubblica nella destinazione, quindi conta la
+// scrivibilità della directory o, se non esiste ancora, del primo antenato esistente (InitiateUpload crea
+// le directory mancanti).
+func (p *LocalFilesystemProvider) CanWrite(ctx context.Context, claims *auth.UserClaims, path string) (bool, error) {
+	fullPath, err := p.validatePath(path)
+	if err != nil {
+		return false, fmt.Errorf("path validation error: %w", err)
+	}
+	dir := fullPath
+	for {
+		info, statErr := os.Stat(dir)
+		if statErr == nil && info.IsDir() {
+			break
+		}
+		if statErr != nil && !os.IsNotExist(statErr) {
+			return false, fmt.Errorf("error accessing '%s': %w", dir, statErr)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false, storage.ErrNotFound
+		}
+		dir = parent
+	}
+	writable, err := dirWritable(dir)
+	if err != nil || !writable {
+		return writable, err
+	}
+	tempDir := p.tempDirectory()
+	if _, statErr := os.Stat(tempDir); statErr != nil {
+		if os.IsNotExist(statErr) && p.uploadTempDir == "" {
+			return dirWritable(filepath.Dir(tempDir)) // .clouddav-uploads viene creata al primo upload
+		}
+		return !os.IsNotExist(statErr), nil // Viene creata al primo upload
+	}
+	return dirWritable(tempDir)
+}
+
+// GetDirectorySize walks the directory tree under path and sums the size of the regular files. Come in
+// Search i link simbolici non vengono seguiti durante la visita.
+func (p *LocalFilesystemProvider) GetDirectorySize(ctx context.Context, claims *auth.UserClaims, path string) (int64, int64, error) {
+	fullPath, err := p.validatePath(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("path validation error: %w", err)
+	}
+	if err := p.checkSymlinkComponents(fullPath, true); err != nil {
+		return 0, 0, err
+	}
+	if info, statErr := os.Stat(fullPath); statErr != nil {
+		if os.IsNotExist(statErr) {
+			return 0, 0, storage.ErrNotFound
+		}
+		return 0, 0, fmt.Errorf("error accessing '%s': %w", fullPath, statErr)
+	} else if !info.IsDir() {
+		return 0, 0, fmt.Errorf("path '%s' is not a directory", path)
+	}
+
+	var totalBytes, fileCount int64
+	err = filepath.WalkDir(fullPath, func(walkPath string, d os.DirEntry, walkErr error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if walkErr != nil {
+			if walkPath == fullPath {
+				return walkErr
+			}
+			return nil // Directory non leggibile o rimossa durante la visita: non conta
+		}
+		if d.IsDir() && p.isUploadTempDir(walkPath) {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() && !isChecksumSidecar(d.Name()) {
+			if info, infoErr := d.Info(); infoErr == nil {
+				totalBytes += info.Size()
+				fileCount++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+		return 0, 0, fmt.Errorf("error computing size of '%s': %w", fullPath, err)
+	}
+	return totalBytes, fileCount, nil
+}
+
+// Search walks the directory tree under basePath and returns the files and directories whose name
+// matches pattern, fermandosi dopo maxResults elementi. I path restituiti sono relativi alla radice
+// dello storage come in ListItems.
+func (p *LocalFilesystemProvider) Search(ctx context.Context, claims *auth.UserClaims, basePath string, pattern string, modTimeRange *storage.ModTimeRange, maxResults int) ([]storage.ItemInfo, error) {
 	userIdent := "unauthenticated"
 	if claims != nil {
 		userIdent = claims.Email
 	}
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("LocalFilesystemProvider.GetUploadedSize chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, filePath)
-	}
+	p.logger.Infof("LocalFilesystemProvider.Search chiamato da utente '%s' per storage '%s', basePath '%s', pattern '%s', maxResults %d", userIdent, p.name, basePath, pattern, maxResults)
 
-	uploadKey := fmt.Sprintf("%s:%s", p.name, filePath)
-	localUploadSessionsMutex.Lock()
-	session, ok := localOngoingUploadSessions[uploadKey]
-	localUploadSessionsMutex.Unlock()
+	nameRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+	fullPath, err := p.validatePath(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("path validation error: %w", err)
+	}
+	if err := p.checkSymlinkComponents(fullPath, true); err != nil {
+		return nil, err
+	}
+	if info, statErr := os.Stat(fullPath); statErr != nil {
+		if os.IsNotExist(statErr) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("error accessing '%s': %w", fullPath, statErr)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("search base path '%s' is not a directory", basePath)
+	}
 
-	if !ok || session == nil || session.TempFile == nil {
-		// Se non c'è una sessione in corso, il file non è stato ancora caricato o è stato completato/annullato.
-		// In questo caso, controlliamo la dimensione del file finale se esiste.
-		fullPath, err := p.validatePath(filePath)
-		if err != nil {
-			return 0, fmt.Errorf("path validation error: %w", err)
+	items := []storage.ItemInfo{}
+	err = filepath.WalkDir(fullPath, func(walkPath string, d os.DirEntry, walkErr error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
 		}
-		fileInfo, err := os.Stat(fullPath)
-		if os.IsNotExist(err) {
-			return 0, nil // File non esiste, dimensione 0
-		} else if err != nil {
-			return 0, fmt.Errorf("error getting local file info '%s': %w", fullPath, err)
+		if walkErr != nil {
+			if walkPath == fullPath {
+				return walkErr
+			}
+			// Directory non leggibile o rimossa durante la visita: si prosegue con il resto dell'albero.
+			p.logger.Debugf("LocalFilesystemProvider.Search: skipping '%s': %v", walkPath, walkErr)
+			return nil
+		}
+		if d.IsDir() && p.isUploadTempDir(walkPath) {
+			return filepath.SkipDir
+		}
+		if walkPath == fullPath || isChecksumSidecar(d.Name()) || !nameRegexp.MatchString(d.Name()) {
+			return nil
+		}
+		info, infoErr := d.Info()
+		if infoErr != nil || !modTimeRange.Contains(info.ModTime()) {
+			return nil
+		}
+		relative, relErr := filepath.Rel(fullPath, walkPath)
+		if relErr != nil {
+			return relErr
 		}
-		return fileInfo.Size(), nil
+		itemInfo := storage.ItemInfo{
+			Name:    d.Name(),
+			IsDir:   d.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Path:    filepath.Join(basePath, relative),
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			// WalkDir non segue i link alle directory: il link viene restituito ma non visitato.
+			p.describeSymlink(walkPath, &itemInfo)
+		}
+		items = append(items, itemInfo)
+		if len(items) >= maxResults {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("error searching '%s': %w", fullPath, err)
 	}
+	p.logger.Debugf("LocalFilesystemProvider.Search: Found %d items under '%s'", len(items), fullPath)
+	return items, nil
+}
 
-	// Se c'è una sessione in corso, restituisci la dimensione del file temporaneo
-	session.mu.Lock()
-	defer session.mu.Unlock()
+// Walk visits the tree under basePath with filepath.WalkDir, saltando i sidecar dei checksum come ListItems.
+// I link simbolici alle directory vengono restituiti ma non visitati.
+func (p *LocalFilesystemProvider) Walk(ctx context.Context, claims *auth.UserClaims, basePath string, maxDepth int, fn storage.WalkFunc) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("LocalFilesystemProvider.Walk chiamato da utente '%s' per storage '%s', basePath '%s', maxDepth %d", userIdent, p.name, basePath, maxDepth)
 
-	fileInfo, err := session.TempFile.Stat()
+	fullPath, err := p.validatePath(basePath)
 	if err != nil {
-		return 0, fmt.Errorf("error getting temp file info for upload status '%s': %w", session.TempFile.Name(), err)
+		return fmt.Errorf("path validation error: %w", err)
+	}
+	if err := p.checkSymlinkComponents(fullPath, true); err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(fullPath); statErr != nil {
+		if os.IsNotExist(statErr) {
+			return storage.ErrNotFound
+		}
+		return fmt.Errorf("error accessing '%s': %w", fullPath, statErr)
+	} else if !info.IsDir() {
+		return fmt.Errorf("walk base path '%s' is not a directory", basePath)
 	}
 
-	return fileInfo.Size(), nil
+	var fnErr error
+	err = filepath.WalkDir(fullPath, func(walkPath string, d os.DirEntry, walkErr error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if walkErr != nil {
+			if walkPath == fullPath {
+				return walkErr
+			}
+			p.logger.Debugf("LocalFilesystemProvider.Walk: skipping '%s': %v", walkPath, walkErr)
+			return nil
+		}
+		if d.IsDir() && p.isUploadTempDir(walkPath) {
+			return filepath.SkipDir
+		}
+		if walkPath == fullPath || isChecksumSidecar(d.Name()) {
+			return nil
+		}
+		info, infoErr := d.Info()
+		if infoErr != nil {
+			// Rimosso durante la visita.
+			return nil
+		}
+		relative, relErr := filepath.Rel(fullPath, walkPath)
+		if relErr != nil {
+			return relErr
+		}
+		itemInfo := storage.ItemInfo{
+			Name:    d.Name(),
+			IsDir:   d.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Path:    filepath.Join(basePath, relative),
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			p.describeSymlink(walkPath, &itemInfo)
+		}
+		relative = filepath.ToSlash(relative)
+		if err := fn(itemInfo, relative); err != nil {
+			if errors.Is(err, fs.SkipDir) && !d.IsDir() {
+				// Per un file fs.SkipDir salterebbe il resto della directory che lo contiene.
+				return nil
+			}
+			if !errors.Is(err, fs.SkipDir) && !errors.Is(err, fs.SkipAll) {
+				fnErr = err
+			}
+			return err
+		}
+		if d.IsDir() && maxDepth > 0 && strings.Count(relative, "/")+1 >= maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("error walking '%s': %w", fullPath, err)
+	}
+	return nil
 }
 
 var _ storage.StorageProvider = (*LocalFilesystemProvider)(nil)
+
+// uploadTempPattern is the name pattern of the temporary files created by InitiateUpload.
+const uploadTempPattern = "upload-*.tmp"
+
+// uploadTempDirName is the hidden directory under the storage root that holds the upload temp files when
+// upload_temp_dir is not set. È riservata al server: validatePath la rifiuta e le visite la saltano.
+const uploadTempDirName = ".clouddav-uploads"
+
+// tempDirectory returns the absolute path of the directory of the upload temp files of this storage.
+func (p *LocalFilesystemProvider) tempDirectory() string {
+	dir := p.uploadTempDir
+	if dir == "" {
+		dir = filepath.Join(p.path, uploadTempDirName)
+	}
+	if absDir, err := filepath.Abs(dir); err == nil {
+		return absDir
+	}
+	return filepath.Clean(dir)
+}
+
+// isUploadTempDir reports whether fullPath (absolute) is the upload temp directory of this storage.
+func (p *LocalFilesystemProvider) isUploadTempDir(fullPath string) bool {
+	return fullPath == p.tempDirectory()
+}
+
+// activeUploadTempFiles returns the temporary files currently owned by an upload session.
+func activeUploadTempFiles() map[string]bool {
+	localUploadSessionsMutex.Lock()
+	defer localUploadSessionsMutex.Unlock()
+	active := make(map[string]bool, len(localOngoingUploadSessions))
+	for _, session := range localOngoingUploadSessions {
+		if session.TempFile != nil {
+			active[filepath.Clean(session.TempFile.Name())] = true
+		}
+	}
+	return active
+}
+
+// CleanupStaleTempFiles removes the upload temp files of this storage that are older than maxAge and not
+// associated with an active upload session, returning how many were removed and the total size of those left.
+// Viene letta solo la directory riservata (tempDirectory), senza ricorsione: i file degli utenti con un nome
+// simile a upload-*.tmp non vengono mai toccati.
+func (p *LocalFilesystemProvider) CleanupStaleTempFiles(ctx context.Context, maxAge time.Duration) (removed int, remainingBytes int64, err error) {
+	tempDir := p.tempDirectory()
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil // Nessun upload ancora avviato
+		}
+		return 0, 0, fmt.Errorf("error reading upload temp directory '%s': %w", tempDir, err)
+	}
+	active := activeUploadTempFiles()
+	now := time.Now()
+
+	for _, entry := range entries {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return removed, remainingBytes, ctxErr
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if matched, _ := filepath.Match(uploadTempPattern, entry.Name()); !matched {
+			continue
+		}
+		path := filepath.Join(tempDir, entry.Name())
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			continue // Il file può essere stato rimosso nel frattempo (finalize/cancel)
+		}
+		if active[path] || now.Sub(info.ModTime()) <= maxAge {
+			remainingBytes += info.Size()
+			continue
+		}
+		if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.Printf("Warning: Failed to remove stale upload temp file '%s': %v", path, removeErr)
+			remainingBytes += info.Size()
+			continue
+		}
+		removed++
+		p.logger.Infof("Removed stale upload temp file '%s' of storage '%s' (size %d, last modified %s)", path, p.name, info.Size(), info.ModTime().Format(time.RFC3339))
+	}
+	return removed, remainingBytes, nil
+}
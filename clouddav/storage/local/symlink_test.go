@@ -0,0 +1,190 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// newSymlinkProvider creates a provider whose root holds target.txt, dir/inner.txt and the links
+// file-link -> target.txt, dir-link -> dir and broken-link -> missing.txt.
+func newSymlinkProvider(t *testing.T, followSymlinks bool) *LocalFilesystemProvider {
+	t.Helper()
+	var cfg config.StorageConfig
+	cfg.FollowSymlinks = followSymlinks
+	p := newTestProvider(t, cfg)
+	if err := os.MkdirAll(filepath.Join(p.path, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"target.txt": "target", "dir/inner.txt": "inner"} {
+		if err := os.WriteFile(filepath.Join(p.path, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{"file-link": "target.txt", "dir-link": "dir", "broken-link": "missing.txt"} {
+		if err := os.Symlink(target, filepath.Join(p.path, link)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return p
+}
+
+func TestSymlinkItemInfo(t *testing.T) {
+	tests := []struct {
+		follow     bool
+		path       string
+		wantIsDir  bool
+		wantSize   int64
+		wantTarget string
+	}{
+		{false, "/file-link", false, 0, "target.txt"},
+		{false, "/dir-link", false, 0, "dir"},
+		{false, "/broken-link", false, 0, "missing.txt"},
+		{true, "/file-link", false, int64(len("target")), "target.txt"},
+		{true, "/dir-link", true, 0, "dir"},
+		{true, "/broken-link", false, 0, "missing.txt"},
+	}
+	for _, tt := range tests {
+		p := newSymlinkProvider(t, tt.follow)
+		item, err := p.GetItem(context.Background(), nil, tt.path)
+		if err != nil {
+			t.Errorf("follow %t, GetItem(%s): %v", tt.follow, tt.path, err)
+			continue
+		}
+		if !item.IsSymlink || item.LinkTarget != tt.wantTarget || item.IsDir != tt.wantIsDir || item.Size != tt.wantSize {
+			t.Errorf("follow %t, GetItem(%s) = symlink %t, target %q, dir %t, size %d; want symlink, target %q, dir %t, size %d",
+				tt.follow, tt.path, item.IsSymlink, item.LinkTarget, item.IsDir, item.Size, tt.wantTarget, tt.wantIsDir, tt.wantSize)
+		}
+	}
+}
+
+func TestSymlinkListing(t *testing.T) {
+	for _, follow := range []bool{false, true} {
+		p := newSymlinkProvider(t, follow)
+		listing, err := p.ListItems(context.Background(), nil, "/", 1, 100, "", nil, false, false)
+		if err != nil {
+			t.Fatalf("follow %t, ListItems: %v", follow, err)
+		}
+		symlinks := map[string]bool{}
+		for _, item := range listing.Items {
+			if item.IsSymlink {
+				symlinks[item.Name] = true
+			}
+		}
+		for _, name := range []string{"file-link", "dir-link", "broken-link"} {
+			if !symlinks[name] {
+				t.Errorf("follow %t: %s not listed as a symlink (items %+v)", follow, name, listing.Items)
+			}
+		}
+	}
+}
+
+func TestSymlinkReads(t *testing.T) {
+	tests := []struct {
+		follow  bool
+		path    string
+		want    string // Contenuto atteso se wantErr è nil
+		wantErr error
+	}{
+		{false, "/file-link", "", storage.ErrIsSymlink},
+		{false, "/dir-link/inner.txt", "", storage.ErrIsSymlink},
+		{false, "/broken-link", "", storage.ErrIsSymlink},
+		{false, "/target.txt", "target", nil},
+		{true, "/file-link", "target", nil},
+		{true, "/dir-link/inner.txt", "inner", nil},
+		{true, "/broken-link", "", storage.ErrNotFound},
+	}
+	for _, tt := range tests {
+		p := newSymlinkProvider(t, tt.follow)
+		reader, err := p.OpenReader(context.Background(), nil, tt.path)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("follow %t, OpenReader(%s) = %v, want %v", tt.follow, tt.path, err, tt.wantErr)
+			}
+			if err == nil {
+				reader.Close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("follow %t, OpenReader(%s): %v", tt.follow, tt.path, err)
+			continue
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(data) != tt.want {
+			t.Errorf("follow %t, read %s = %q, %v; want %q", tt.follow, tt.path, data, err, tt.want)
+		}
+	}
+}
+
+func TestSymlinkWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(p *LocalFilesystemProvider) error
+	}{
+		{"copy link", func(p *LocalFilesystemProvider) error {
+			return p.CopyItem(context.Background(), nil, "/file-link", "/copy.txt")
+		}},
+		{"copy through link", func(p *LocalFilesystemProvider) error {
+			return p.CopyItem(context.Background(), nil, "/dir-link/inner.txt", "/copy.txt")
+		}},
+		{"copy into link", func(p *LocalFilesystemProvider) error {
+			return p.CopyItem(context.Background(), nil, "/target.txt", "/dir-link/copy.txt")
+		}},
+		{"copy onto broken link", func(p *LocalFilesystemProvider) error {
+			return p.CopyItem(context.Background(), nil, "/target.txt", "/broken-link")
+		}},
+		{"move link", func(p *LocalFilesystemProvider) error {
+			return p.MoveItem(context.Background(), nil, "/file-link", "/moved")
+		}},
+		{"move into link", func(p *LocalFilesystemProvider) error {
+			return p.MoveItem(context.Background(), nil, "/target.txt", "/dir-link/moved.txt")
+		}},
+		{"delete through link", func(p *LocalFilesystemProvider) error {
+			return p.DeleteItem(context.Background(), nil, "/dir-link/inner.txt")
+		}},
+		{"mkdir through link", func(p *LocalFilesystemProvider) error {
+			return p.CreateDirectory(context.Background(), nil, "/dir-link/sub")
+		}},
+		{"upload through link", func(p *LocalFilesystemProvider) error {
+			_, err := p.InitiateUpload(context.Background(), nil, "symlink-upload", "/dir-link/up.txt", 1, 1)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		p := newSymlinkProvider(t, false)
+		if err := tt.op(p); !errors.Is(err, storage.ErrIsSymlink) {
+			t.Errorf("%s = %v, want %v", tt.name, err, storage.ErrIsSymlink)
+		}
+		for name, want := range map[string]string{"target.txt": "target", "dir/inner.txt": "inner"} {
+			if data, err := os.ReadFile(filepath.Join(p.path, name)); err != nil || string(data) != want {
+				t.Errorf("%s: %s = %q, %v; want %q", tt.name, name, data, err, want)
+			}
+		}
+		for _, name := range []string{"copy.txt", "moved", "missing.txt", "dir/copy.txt", "dir/moved.txt", "dir/sub", "dir/up.txt"} {
+			if _, err := os.Lstat(filepath.Join(p.path, name)); !os.IsNotExist(err) {
+				t.Errorf("%s: %s exists after the refused operation", tt.name, name)
+			}
+		}
+	}
+}
+
+func TestSymlinkWritesFollowed(t *testing.T) {
+	p := newSymlinkProvider(t, true)
+	if err := p.CopyItem(context.Background(), nil, "/dir-link/inner.txt", "/copy.txt"); err != nil {
+		t.Fatalf("CopyItem through a followed link: %v", err)
+	}
+	if err := p.CreateDirectory(context.Background(), nil, "/dir-link/sub"); err != nil {
+		t.Fatalf("CreateDirectory through a followed link: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(p.path, "dir", "sub")); err != nil || !info.IsDir() {
+		t.Errorf("dir/sub = %v, %v; want a directory", info, err)
+	}
+}
@@ -0,0 +1,308 @@
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// Ogni elemento cancellato con trash_dir configurato finisce in trash_dir/<id>/, dove id inizia con il
+// timestamp della cancellazione: la directory contiene l'elemento rinominato in trashItemName (con il suo
+// eventuale sidecar del checksum) e trashInfoFile con il path originale, usato da RestoreItem.
+const (
+	trashItemName = "item"
+	trashInfoFile = "info.json"
+)
+
+// trashMu serializza le operazioni che rimuovono o ripristinano voci del cestino (restore, empty, purge).
+var trashMu sync.Mutex
+
+// TrashEntry describes an item in the trash of a local storage.
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"original_path"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	DeletedBy    string    `json:"deleted_by,omitempty"`
+	IsDir        bool      `json:"is_dir"`
+	Size         int64     `json:"size"` // Solo per i file
+}
+
+// TrashEnabled reports whether deleted items are moved to trash_dir instead of being removed.
+func (p *LocalFilesystemProvider) TrashEnabled() bool {
+	return p.trashDir != ""
+}
+
+// newTrashID returns the name of a new trash entry: il timestamp UTC rende l'ordine dei nomi quello delle
+// cancellazioni, il suffisso casuale distingue le cancellazioni nello stesso istante.
+func newTrashID(deletedAt time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return deletedAt.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// trashEntryDir validates id and returns the directory of the trash entry.
+func (p *LocalFilesystemProvider) trashEntryDir(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("%w: invalid trash id '%s'", storage.ErrNotFound, id)
+	}
+	return filepath.Join(p.trashDir, id), nil
+}
+
+// moveToTrash moves the item at fullPath (info from os.Stat) into a new trash entry. Il rename richiede che
+// trash_dir sia sullo stesso filesystem dello storage: in caso contrario l'elemento non viene toccato.
+func (p *LocalFilesystemProvider) moveToTrash(claims *auth.UserClaims, fullPath string, info os.FileInfo) error {
+	absBasePath, err := filepath.Abs(p.path)
+	if err != nil {
+		return fmt.Errorf("error determining absolute base path '%s': %w", p.path, err)
+	}
+	if fullPath == absBasePath {
+		return fmt.Errorf("%w: cannot move the storage root to the trash", storage.ErrPermissionDenied)
+	}
+	relPath, err := filepath.Rel(absBasePath, fullPath)
+	if err != nil {
+		return fmt.Errorf("error determining storage path of '%s': %w", fullPath, err)
+	}
+
+	entry := TrashEntry{
+		OriginalPath: "/" + filepath.ToSlash(relPath),
+		DeletedAt:    time.Now(),
+		IsDir:        info.IsDir(),
+	}
+	if claims != nil {
+		entry.DeletedBy = claims.Email
+	}
+	if !info.IsDir() {
+		entry.Size = info.Size()
+	}
+	if entry.ID, err = newTrashID(entry.DeletedAt); err != nil {
+		return fmt.Errorf("error generating trash id: %w", err)
+	}
+	entryDir := filepath.Join(p.trashDir, entry.ID)
+	if err := os.MkdirAll(entryDir, 0700); err != nil {
+		return fmt.Errorf("error creating trash entry '%s': %w", entryDir, err)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		os.RemoveAll(entryDir)
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, trashInfoFile), data, 0600); err != nil {
+		os.RemoveAll(entryDir)
+		return fmt.Errorf("error writing trash entry '%s': %w", entryDir, err)
+	}
+
+	trashedPath := filepath.Join(entryDir, trashItemName)
+	if err := os.Rename(fullPath, trashedPath); err != nil {
+		os.RemoveAll(entryDir)
+		if errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("error moving '%s' to the trash: trash_dir '%s' is on a different filesystem", fullPath, p.trashDir)
+		}
+		if os.IsPermission(err) {
+			return storage.ErrPermissionDenied
+		}
+		return fmt.Errorf("error moving '%s' to the trash: %w", fullPath, err)
+	}
+	if !info.IsDir() {
+		if err := os.Rename(checksumSidecarPath(fullPath), checksumSidecarPath(trashedPath)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to move checksum sidecar of '%s' to the trash: %v", fullPath, err)
+		}
+	}
+	p.logger.Infof("LocalFilesystemProvider.DeleteItem: Moved '%s' to trash entry '%s' of storage '%s'.", fullPath, entry.ID, p.name)
+	return nil
+}
+
+// readTrashEntry reads the info of the trash entry in entryDir.
+func readTrashEntry(entryDir string) (*TrashEntry, error) {
+	data, err := os.ReadFile(filepath.Join(entryDir, trashInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("invalid trash entry '%s': %w", entryDir, err)
+	}
+	entry.ID = filepath.Base(entryDir)
+	return &entry, nil
+}
+
+// ListTrash returns the items in the trash, most recently deleted first. Le voci senza info valide
+// (es. una cancellazione interrotta) vengono saltate.
+func (p *LocalFilesystemProvider) ListTrash(ctx context.Context, claims *auth.UserClaims) ([]TrashEntry, error) {
+	if p.trashDir == "" {
+		return nil, storage.ErrNotImplemented
+	}
+	dirEntries, err := os.ReadDir(p.trashDir)
+	if os.IsNotExist(err) {
+		return []TrashEntry{}, nil // trash_dir non ancora creata: nessuna cancellazione
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading trash_dir '%s': %w", p.trashDir, err)
+	}
+	entries := make([]TrashEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !dirEntry.IsDir() {
+			continue
+		}
+		entry, err := readTrashEntry(filepath.Join(p.trashDir, dirEntry.Name()))
+		if err != nil {
+			p.logger.Debugf("ListTrash: skipping '%s': %v", dirEntry.Name(), err)
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// GetTrashEntry returns the trash entry with the given id, or storage.ErrNotFound.
+func (p *LocalFilesystemProvider) GetTrashEntry(ctx context.Context, claims *auth.UserClaims, id string) (*TrashEntry, error) {
+	if p.trashDir == "" {
+		return nil, storage.ErrNotImplemented
+	}
+	entryDir, err := p.trashEntryDir(id)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := readTrashEntry(entryDir)
+	if os.IsNotExist(err) {
+		return nil, storage.ErrNotFound
+	}
+	return entry, err
+}
+
+// RestoreItem moves the trash entry id back to its original path, ricreando le directory padre se nel
+// frattempo sono state rimosse. Restituisce storage.ErrAlreadyExists se il path originale è di nuovo occupato.
+func (p *LocalFilesystemProvider) RestoreItem(ctx context.Context, claims *auth.UserClaims, id string) (*TrashEntry, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	entry, err := p.GetTrashEntry(ctx, claims, id)
+	if err != nil {
+		return nil, err
+	}
+	entryDir := filepath.Join(p.trashDir, entry.ID)
+	fullPath, err := p.validatePath(entry.OriginalPath)
+	if err != nil {
+		return nil, fmt.Errorf("path validation error: %w", err)
+	}
+	if _, err := os.Lstat(fullPath); err == nil {
+		return nil, storage.ErrAlreadyExists
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error checking restore path '%s': %w", fullPath, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		if os.IsPermission(err) {
+			return nil, storage.ErrPermissionDenied
+		}
+		return nil, fmt.Errorf("error creating parent directory of '%s': %w", fullPath, err)
+	}
+	trashedPath := filepath.Join(entryDir, trashItemName)
+	if err := os.Rename(trashedPath, fullPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, storage.ErrNotFound
+		}
+		if os.IsPermission(err) {
+			return nil, storage.ErrPermissionDenied
+		}
+		return nil, fmt.Errorf("error restoring '%s' from the trash: %w", fullPath, err)
+	}
+	if !entry.IsDir {
+		if err := os.Rename(checksumSidecarPath(trashedPath), checksumSidecarPath(fullPath)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to restore checksum sidecar of '%s': %v", fullPath, err)
+		}
+	}
+	if err := os.RemoveAll(entryDir); err != nil {
+		log.Printf("Warning: Failed to remove trash entry '%s' after restore: %v", entryDir, err)
+	}
+	p.logger.Infof("LocalFilesystemProvider.RestoreItem: Restored trash entry '%s' of storage '%s' to '%s'.", entry.ID, p.name, fullPath)
+	return entry, nil
+}
+
+// removeTrashEntries permanently deletes the trash entries for which remove returns true and returns how many were deleted.
+func (p *LocalFilesystemProvider) removeTrashEntries(ctx context.Context, remove func(entry TrashEntry) bool) (int, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	entries, err := p.ListTrash(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !remove(entry) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		// Il budget globale delle cancellazioni vale anche per lo svuotamento del cestino.
+		if err := storage.DeleteWorkers.Acquire(ctx); err != nil {
+			return removed, err
+		}
+		err := os.RemoveAll(filepath.Join(p.trashDir, entry.ID))
+		storage.DeleteWorkers.Release()
+		if err != nil {
+			if os.IsPermission(err) {
+				return removed, storage.ErrPermissionDenied
+			}
+			return removed, fmt.Errorf("error removing trash entry '%s': %w", entry.ID, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// EmptyTrash permanently deletes the trash entries selected by include (nil = tutte) and returns how many were deleted.
+func (p *LocalFilesystemProvider) EmptyTrash(ctx context.Context, claims *auth.UserClaims, include func(entry TrashEntry) bool) (int, error) {
+	if p.trashDir == "" {
+		return 0, storage.ErrNotImplemented
+	}
+	removed, err := p.removeTrashEntries(ctx, func(entry TrashEntry) bool {
+		return include == nil || include(entry)
+	})
+	if removed > 0 && config.IsLogLevel(config.LogLevelInfo) {
+		userIdent := "unauthenticated"
+		if claims != nil {
+			userIdent = claims.Email
+		}
+		log.Printf("LocalFilesystemProvider.EmptyTrash: User '%s' permanently deleted %d trash entries of storage '%s'.", userIdent, removed, p.name)
+	}
+	return removed, err
+}
+
+// PurgeTrash permanently deletes the trash entries older than trash_retention. Non fa nulla se il cestino
+// non è configurato o la retention è 0.
+func (p *LocalFilesystemProvider) PurgeTrash(ctx context.Context) (int, error) {
+	if p.trashDir == "" || p.trashRetention <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-p.trashRetention)
+	return p.removeTrashEntries(ctx, func(entry TrashEntry) bool {
+		return entry.DeletedAt.Before(cutoff)
+	})
+}
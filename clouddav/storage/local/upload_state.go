@@ -0,0 +1,240 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// File di stato delle sessioni di upload locali (upload_temp.session_state_file). Vuoto = nessuna
+// persistenza: un riavvio abbandona gli upload in corso, come prima.
+var (
+	uploadStateFile   string
+	uploadStateSaveMu sync.Mutex // Serializza le scritture del file di stato
+)
+
+// persistedUploadSession is the state of a local upload session saved in the state file.
+type persistedUploadSession struct {
+	UploadID         string          `json:"upload_id"` // Vuoto nei file di stato precedenti, chiavi "storage:path"
+	StorageName      string          `json:"storage_name"`
+	ItemPath         string          `json:"item_path"`
+	TempPath         string          `json:"temp_path"`
+	ExpectedFileSize int64           `json:"expected_file_size"`
+	ChunkSize        int64           `json:"chunk_size"`
+	ReceivedChunks   map[int64]int64 `json:"received_chunks"` // Indice del chunk -> byte scritti nel file temporaneo
+	UserEmail        string          `json:"user_email"`
+}
+
+// uploadStateFileContent is the content of the state file, keyed by upload key ("storage:uploadID").
+type uploadStateFileContent struct {
+	Sessions map[string]persistedUploadSession `json:"sessions"`
+}
+
+// RestoredUploadSession describes an upload session reloaded by RestoreUploadSessions.
+type RestoredUploadSession struct {
+	UploadID         string
+	StorageName      string
+	ItemPath         string
+	UserEmail        string
+	UploadedSize     int64
+	ExpectedFileSize int64
+}
+
+// SetUploadStateFile enables the persistence of local upload sessions to path (empty disables it).
+// Va chiamata all'avvio, prima di RestoreUploadSessions e di qualsiasi upload.
+func SetUploadStateFile(path string) {
+	uploadStateFile = path
+}
+
+// saveUploadSessions writes the current local upload sessions to the state file, replacing it atomically.
+// Non deve essere chiamata tenendo il mutex di una sessione: lo snapshot li acquisisce tutti.
+func saveUploadSessions() {
+	if uploadStateFile == "" {
+		return
+	}
+	uploadStateSaveMu.Lock()
+	defer uploadStateSaveMu.Unlock()
+
+	localUploadSessionsMutex.Lock()
+	sessions := make(map[string]*localUploadSession, len(localOngoingUploadSessions))
+	for uploadKey, session := range localOngoingUploadSessions {
+		sessions[uploadKey] = session
+	}
+	localUploadSessionsMutex.Unlock()
+
+	content := uploadStateFileContent{Sessions: make(map[string]persistedUploadSession, len(sessions))}
+	for uploadKey, session := range sessions {
+		session.mu.Lock()
+		received := make(map[int64]int64, len(session.writtenBytes))
+		for chunkIndex, n := range session.writtenBytes {
+			received[chunkIndex] = n
+		}
+		content.Sessions[uploadKey] = persistedUploadSession{
+			UploadID:         session.UploadID,
+			StorageName:      session.StorageName,
+			ItemPath:         session.ItemPath,
+			TempPath:         session.TempFile.Name(),
+			ExpectedFileSize: session.ExpectedFileSize,
+			ChunkSize:        session.ChunkSize,
+			ReceivedChunks:   received,
+			UserEmail:        session.UserEmail,
+		}
+		session.mu.Unlock()
+	}
+
+	if err := writeUploadStateFile(content); err != nil {
+		log.Printf("Warning: Failed to save upload session state to '%s': %v", uploadStateFile, err)
+	}
+}
+
+func writeUploadStateFile(content uploadStateFileContent) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	tempPath := uploadStateFile + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, uploadStateFile)
+}
+
+// RestoreUploadSessions reloads the upload sessions saved before a restart for the given providers,
+// reopening their temp files, and removes the temp files of the saved sessions that could not be restored.
+// Le sessioni il cui file temporaneo manca o non ha la dimensione attesa vengono scartate. Gli altri file
+// temporanei non vengono toccati (possono appartenere a un'altra istanza che condivide il volume): quelli
+// orfani li rimuove la pulizia periodica per età. Da chiamare una sola volta all'avvio, prima di accettare richieste.
+func RestoreUploadSessions(ctx context.Context, providers []*LocalFilesystemProvider) ([]RestoredUploadSession, error) {
+	if uploadStateFile == "" {
+		return nil, nil
+	}
+	var content uploadStateFileContent
+	data, err := os.ReadFile(uploadStateFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading upload session state '%s': %w", uploadStateFile, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &content); err != nil {
+			// Un file di stato corrotto non deve impedire l'avvio: gli upload ripartono da zero.
+			log.Printf("Warning: Invalid upload session state '%s', discarding it: %v", uploadStateFile, err)
+		}
+	}
+
+	providersByName := make(map[string]*LocalFilesystemProvider, len(providers))
+	for _, p := range providers {
+		providersByName[p.name] = p
+	}
+
+	var restored []RestoredUploadSession
+	for uploadKey, saved := range content.Sessions {
+		p, ok := providersByName[saved.StorageName]
+		if !ok {
+			log.Printf("Discarding saved upload '%s': storage '%s' is no longer configured as local", uploadKey, saved.StorageName)
+			continue
+		}
+		if saved.UploadID == "" {
+			// Sessione salvata prima degli upload ID: riceve un nuovo ID, il client la riprende per path.
+			if saved.UploadID, err = storage.NewUploadID(); err != nil {
+				log.Printf("Discarding saved upload '%s': %v", uploadKey, err)
+				continue
+			}
+			uploadKey = fmt.Sprintf("%s:%s", saved.StorageName, saved.UploadID)
+		}
+		session, err := p.restoreUploadSession(saved)
+		if err != nil {
+			log.Printf("Discarding saved upload '%s': %v", uploadKey, err)
+			p.removeDiscardedTempFile(saved.TempPath)
+			continue
+		}
+
+		localUploadSessionsMutex.Lock()
+		localOngoingUploadSessions[uploadKey] = session
+		localUploadSessionsMutex.Unlock()
+
+		var uploadedSize int64
+		for _, n := range saved.ReceivedChunks {
+			uploadedSize += n
+		}
+		restored = append(restored, RestoredUploadSession{UploadID: saved.UploadID, StorageName: saved.StorageName, ItemPath: saved.ItemPath, UserEmail: saved.UserEmail, UploadedSize: uploadedSize, ExpectedFileSize: saved.ExpectedFileSize})
+		if config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Restored local upload session '%s' of user '%s': %d of %d bytes received", uploadKey, saved.UserEmail, uploadedSize, saved.ExpectedFileSize)
+		}
+	}
+	saveUploadSessions() // Rimuove dal file le sessioni scartate
+	return restored, nil
+}
+
+// removeDiscardedTempFile removes the temp file of a saved session that could not be restored, solo se è
+// un file upload-*.tmp nella directory riservata del provider: il file di stato potrebbe indicare un
+// percorso qualsiasi.
+func (p *LocalFilesystemProvider) removeDiscardedTempFile(tempPath string) {
+	if tempPath == "" {
+		return
+	}
+	absTempPath, err := filepath.Abs(tempPath)
+	if err != nil || filepath.Dir(absTempPath) != p.tempDirectory() {
+		return
+	}
+	if matched, _ := filepath.Match(uploadTempPattern, filepath.Base(absTempPath)); !matched {
+		return
+	}
+	if err := os.Remove(absTempPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to remove temp file '%s' of a discarded upload: %v", absTempPath, err)
+	} else if err == nil && config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Removed temp file '%s' of a discarded upload of storage '%s'", absTempPath, p.name)
+	}
+}
+
+// restoreUploadSession rebuilds a session from its saved state and starts its writer goroutines.
+func (p *LocalFilesystemProvider) restoreUploadSession(saved persistedUploadSession) (*localUploadSession, error) {
+	fullPath, err := p.validatePath(saved.ItemPath)
+	if err != nil {
+		return nil, fmt.Errorf("path validation error: %w", err)
+	}
+	if saved.ChunkSize <= 0 || saved.ExpectedFileSize <= 0 {
+		return nil, fmt.Errorf("invalid saved sizes (chunk %d, file %d)", saved.ChunkSize, saved.ExpectedFileSize)
+	}
+	tempFile, err := os.OpenFile(saved.TempPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening temp file: %w", err)
+	}
+	// Il file temporaneo è pre-allocato alla dimensione dichiarata: una dimensione diversa indica un file
+	// non più coerente con lo stato salvato.
+	info, err := tempFile.Stat()
+	if err != nil || info.Size() != saved.ExpectedFileSize {
+		tempFile.Close()
+		return nil, fmt.Errorf("temp file '%s' does not match the saved upload (expected %d bytes)", saved.TempPath, saved.ExpectedFileSize)
+	}
+
+	session := &localUploadSession{
+		TempFile:         tempFile,
+		ReceivedChunks:   make(map[int64]bool, len(saved.ReceivedChunks)),
+		ReceivedBytes:    make(map[int64]int64, len(saved.ReceivedChunks)),
+		ExpectedChunks:   (saved.ExpectedFileSize + saved.ChunkSize - 1) / saved.ChunkSize,
+		ExpectedFileSize: saved.ExpectedFileSize,
+		FinalPath:        fullPath,
+		UploadID:         saved.UploadID,
+		StorageName:      p.name,
+		ItemPath:         saved.ItemPath,
+		ChunkSize:        saved.ChunkSize,
+		UserEmail:        saved.UserEmail,
+		writtenBytes:     make(map[int64]int64, len(saved.ReceivedChunks)),
+		chunkBuffer:      make(chan chunkWriteRequest, 100),
+		done:             make(chan struct{}),
+	}
+	for chunkIndex, n := range saved.ReceivedChunks {
+		session.ReceivedChunks[chunkIndex] = true
+		session.ReceivedBytes[chunkIndex] = n
+		session.writtenBytes[chunkIndex] = n
+	}
+	session.startWriters(p.uploadWriters)
+	return session, nil
+}
@@ -0,0 +1,78 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"clouddav/config"
+)
+
+func TestRestoreUploadSessionsRemovesOnlyDiscardedTempFiles(t *testing.T) {
+	p := newTestProvider(t, config.StorageConfig{})
+	tempDir := p.tempDirectory()
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSized := func(path string, size int64) {
+		t.Helper()
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restorable := filepath.Join(tempDir, "upload-restorable.tmp")
+	mismatched := filepath.Join(tempDir, "upload-mismatched.tmp")
+	unlisted := filepath.Join(tempDir, "upload-unlisted.tmp")
+	userFile := filepath.Join(p.path, "upload-user.tmp")
+	writeSized(restorable, 10)
+	writeSized(mismatched, 3)
+	writeSized(unlisted, 10)
+	writeSized(userFile, 3)
+
+	session := func(uploadID, tempPath string) persistedUploadSession {
+		return persistedUploadSession{UploadID: uploadID, StorageName: p.name, ItemPath: "/" + uploadID + ".bin", TempPath: tempPath, ExpectedFileSize: 10, ChunkSize: 5, ReceivedChunks: map[int64]int64{0: 5}}
+	}
+	content := uploadStateFileContent{Sessions: map[string]persistedUploadSession{
+		p.name + ":restorable": session("restorable", restorable),
+		p.name + ":mismatched": session("mismatched", mismatched), // Dimensione diversa: scartata
+		p.name + ":user":       session("user", userFile),         // Scartata, ma fuori dalla directory riservata
+	}}
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateFile := filepath.Join(t.TempDir(), "uploads.json")
+	if err := os.WriteFile(stateFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	SetUploadStateFile(stateFile)
+	t.Cleanup(func() { SetUploadStateFile("") })
+
+	restored, err := RestoreUploadSessions(context.Background(), []*LocalFilesystemProvider{p})
+	if err != nil {
+		t.Fatalf("RestoreUploadSessions: %v", err)
+	}
+	t.Cleanup(func() { p.CancelUpload(nil, "restorable") })
+	if len(restored) != 1 || restored[0].UploadID != "restorable" || restored[0].UploadedSize != 5 {
+		t.Fatalf("restored = %+v, want only 'restorable' with 5 bytes", restored)
+	}
+
+	tests := []struct {
+		path   string
+		exists bool
+	}{
+		{restorable, true},
+		{mismatched, false},
+		{unlisted, true},
+		{userFile, true},
+	}
+	for _, tt := range tests {
+		_, err := os.Stat(tt.path)
+		if exists := err == nil; exists != tt.exists {
+			t.Errorf("%s: exists = %t, want %t", filepath.Base(tt.path), exists, tt.exists)
+		}
+	}
+}
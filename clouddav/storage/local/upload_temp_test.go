@@ -0,0 +1,93 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"clouddav/config"
+)
+
+func writeAgedFile(t *testing.T, path string, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanupStaleTempFilesOnlyTouchesTempDirectory(t *testing.T) {
+	tests := []struct {
+		name          string
+		uploadTempDir bool
+	}{
+		{name: "default .clouddav-uploads"},
+		{name: "upload_temp_dir", uploadTempDir: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config.StorageConfig
+			if tt.uploadTempDir {
+				cfg.UploadTempDir = t.TempDir()
+			}
+			p := newTestProvider(t, cfg)
+			tempDir := p.tempDirectory()
+
+			userFiles := []string{
+				filepath.Join(p.path, "upload-2024.tmp"),
+				filepath.Join(p.path, "docs", "upload-old.tmp"),
+			}
+			for _, path := range userFiles {
+				writeAgedFile(t, path, 48*time.Hour)
+			}
+			stale := filepath.Join(tempDir, "upload-111.tmp")
+			recent := filepath.Join(tempDir, "upload-222.tmp")
+			writeAgedFile(t, stale, 48*time.Hour)
+			writeAgedFile(t, recent, time.Minute)
+
+			removed, remaining, err := p.CleanupStaleTempFiles(context.Background(), time.Hour)
+			if err != nil {
+				t.Fatalf("CleanupStaleTempFiles: %v", err)
+			}
+			if removed != 1 || remaining != int64(len("data")) {
+				t.Errorf("removed %d, remaining %d bytes; want 1 and %d", removed, remaining, len("data"))
+			}
+			if _, err := os.Stat(stale); !os.IsNotExist(err) {
+				t.Errorf("stale temp file still exists (err %v)", err)
+			}
+			for _, path := range append(userFiles, recent) {
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("%s was removed: %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadTempDirectoryIsReserved(t *testing.T) {
+	p := newTestProvider(t, config.StorageConfig{})
+	writeAgedFile(t, filepath.Join(p.tempDirectory(), "upload-1.tmp"), 0)
+	writeAgedFile(t, filepath.Join(p.path, "visible.txt"), 0)
+
+	for _, path := range []string{"/" + uploadTempDirName, "/" + uploadTempDirName + "/upload-1.tmp", "/x/../" + uploadTempDirName} {
+		if _, err := p.validatePath(path); err == nil {
+			t.Errorf("validatePath(%q) succeeded, want access denied", path)
+		}
+	}
+
+	listing, err := p.ListItems(context.Background(), nil, "/", 1, 100, "", nil, false, false)
+	if err != nil {
+		t.Fatalf("ListItems: %v", err)
+	}
+	if len(listing.Items) != 1 || listing.Items[0].Name != "visible.txt" {
+		t.Errorf("ListItems(/) = %+v, want only visible.txt", listing.Items)
+	}
+}
@@ -0,0 +1,110 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// uploadFile uploads content to filePath in a single chunk.
+func uploadFile(t *testing.T, p *LocalFilesystemProvider, claims *auth.UserClaims, filePath string, content []byte) {
+	t.Helper()
+	ctx := context.Background()
+	uploadID := "upload-" + filepath.Base(filePath)
+	size := int64(len(content))
+	if _, err := p.InitiateUpload(ctx, claims, uploadID, filePath, size, size); err != nil {
+		t.Fatalf("InitiateUpload: %v", err)
+	}
+	if err := p.WriteChunk(ctx, claims, uploadID, bytes.NewReader(content), 0, size, storage.ChunkChecksum{}); err != nil {
+		t.Fatalf("WriteChunk: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	if err := p.FinalizeUpload(claims, uploadID, filePath, hex.EncodeToString(sum[:]), false); err != nil {
+		t.Fatalf("FinalizeUpload: %v", err)
+	}
+}
+
+func TestUploaderRoundTrip(t *testing.T) {
+	content := []byte("uploaded content")
+	sum := sha256.Sum256(content)
+	tests := []struct {
+		name           string
+		cfg            config.StorageConfig
+		claims         *auth.UserClaims
+		wantUploadedBy string
+		wantSHA256     string
+	}{
+		{name: "authenticated user", cfg: config.StorageConfig{RecordUploader: true}, claims: &auth.UserClaims{Email: "alice@example.com"}, wantUploadedBy: "alice@example.com"},
+		{name: "anonymous upload", cfg: config.StorageConfig{RecordUploader: true}, wantUploadedBy: storage.AnonymousUploader},
+		{name: "with stored checksums", cfg: config.StorageConfig{RecordUploader: true, StoreChecksums: true}, claims: &auth.UserClaims{Email: "bob@example.com"}, wantUploadedBy: "bob@example.com", wantSHA256: hex.EncodeToString(sum[:])},
+		{name: "disabled", claims: &auth.UserClaims{Email: "alice@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, tt.cfg)
+			before := time.Now().UTC().Add(-time.Second)
+			uploadFile(t, p, tt.claims, "/file.txt", content)
+
+			item, err := p.GetItem(context.Background(), nil, "/file.txt")
+			if err != nil {
+				t.Fatalf("GetItem: %v", err)
+			}
+			if item.UploadedBy != tt.wantUploadedBy || item.SHA256 != tt.wantSHA256 {
+				t.Errorf("GetItem = uploaded_by %q, sha256 %q, want %q, %q", item.UploadedBy, item.SHA256, tt.wantUploadedBy, tt.wantSHA256)
+			}
+			if tt.wantUploadedBy == "" {
+				if item.UploadedAt != nil {
+					t.Errorf("UploadedAt = %v, want none", item.UploadedAt)
+				}
+			} else if item.UploadedAt == nil || item.UploadedAt.Before(before) || item.UploadedAt.After(time.Now().Add(time.Second)) {
+				t.Errorf("UploadedAt = %v, want the time of the upload", item.UploadedAt)
+			}
+			// I metadata stanno nel sidecar: il contenuto del file resta quello caricato.
+			if data, err := os.ReadFile(filepath.Join(p.path, "file.txt")); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("file content = %q, %v, want %q", data, err, content)
+			}
+		})
+	}
+}
+
+func TestUploaderKeptByStoreChecksum(t *testing.T) {
+	p := newTestProvider(t, config.StorageConfig{RecordUploader: true, StoreChecksums: true})
+	ctx := context.Background()
+	uploadFile(t, p, &auth.UserClaims{Email: "alice@example.com"}, "/file.txt", []byte("content"))
+
+	hashed, err := p.GetItem(ctx, nil, "/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.StoreChecksum(ctx, nil, "/file.txt", "abc123", hashed); err != nil {
+		t.Fatalf("StoreChecksum: %v", err)
+	}
+	item, err := p.GetItem(ctx, nil, "/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.UploadedBy != "alice@example.com" || item.SHA256 != "abc123" {
+		t.Errorf("after StoreChecksum = uploaded_by %q, sha256 %q, want alice@example.com, abc123", item.UploadedBy, item.SHA256)
+	}
+
+	// Un file riscritto fuori da clouddav non è più quello caricato: il sidecar viene ignorato.
+	if err := os.WriteFile(filepath.Join(p.path, "file.txt"), []byte("rewritten elsewhere"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	item, err = p.GetItem(ctx, nil, "/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.UploadedBy != "" || item.SHA256 != "" {
+		t.Errorf("after a rewrite = uploaded_by %q, sha256 %q, want none", item.UploadedBy, item.SHA256)
+	}
+}
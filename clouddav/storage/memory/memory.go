@@ -0,0 +1,813 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/logging"
+	"clouddav/storage"
+)
+
+// MemoryStorageProvider implements the StorageProvider interface with an in-memory tree, for tests and
+// ephemeral storages. Il contenuto si perde al riavvio del processo (non al reload della configurazione,
+// se lo storage non cambia) e occupa memoria: quota_bytes è l'unico limite alla sua dimensione.
+type MemoryStorageProvider struct {
+	name           string
+	logger         *logging.StorageLogger
+	maxUploadBytes int64 // Dimensione massima di un upload (0 = nessun limite)
+	storeChecksums bool
+	recordUploader bool
+
+	mu   sync.RWMutex
+	root *memoryNode
+
+	uploadsMu sync.Mutex
+	uploads   map[string]*uploadSession // Upload in corso, per upload ID
+}
+
+// memoryNode is a file or a directory of the tree. Il contenuto di un file non viene mai modificato
+// dopo la creazione (un nuovo upload sostituisce il nodo), quindi i reader possono condividerlo senza copie.
+type memoryNode struct {
+	isDir    bool
+	children map[string]*memoryNode // Solo directory
+	data     []byte                 // Solo file
+	sha256   string                 // Checksum salvato (store_checksums), vuoto se non disponibile
+	modTime  time.Time
+	// uploadedBy e uploadedAt registrano l'upload che ha creato il file (record_uploader).
+	uploadedBy string
+	uploadedAt time.Time
+}
+
+// uploadSession holds the chunks received for an upload until FinalizeUpload.
+type uploadSession struct {
+	expectedSize   int64
+	chunkSize      int64
+	expectedChunks int64
+	chunks         map[int64][]byte
+}
+
+// NewProvider creates a new, empty MemoryStorageProvider.
+func NewProvider(ctx context.Context, cfg *config.StorageConfig) (*MemoryStorageProvider, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cfg.Type != "memory" {
+		return nil, errors.New("invalid storage config type for memory provider")
+	}
+	return &MemoryStorageProvider{
+		name:           cfg.Name,
+		logger:         logging.NewStorageLogger(cfg.Name),
+		maxUploadBytes: cfg.MaxUploadBytes,
+		storeChecksums: cfg.StoreChecksums,
+		recordUploader: cfg.RecordUploader,
+		root:           newDirectoryNode(time.Now()),
+		uploads:        make(map[string]*uploadSession),
+	}, nil
+}
+
+func newDirectoryNode(modTime time.Time) *memoryNode {
+	return &memoryNode{isDir: true, children: make(map[string]*memoryNode), modTime: modTime}
+}
+
+// Type returns the storage type.
+func (p *MemoryStorageProvider) Type() string {
+	return "memory"
+}
+
+// Name returns the storage name.
+func (p *MemoryStorageProvider) Name() string {
+	return p.name
+}
+
+// cleanPath normalizes a storage path to an absolute slash path. Come validatePath del provider locale,
+// i segmenti ".." vengono rifiutati invece di essere risolti.
+func cleanPath(itemPath string) (string, error) {
+	for _, segment := range strings.Split(itemPath, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("%w: path traversal attempt", storage.ErrPermissionDenied)
+		}
+	}
+	return path.Clean("/" + itemPath), nil
+}
+
+// lookup returns the node at cleanPath, or nil. Il chiamante deve tenere p.mu.
+func (p *MemoryStorageProvider) lookup(cleanPath string) *memoryNode {
+	node := p.root
+	if cleanPath == "/" {
+		return node
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(cleanPath, "/"), "/") {
+		if !node.isDir {
+			return nil
+		}
+		child, ok := node.children[name]
+		if !ok {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// mkdirAll returns the directory at cleanPath, creating the missing ones like os.MkdirAll.
+// Il chiamante deve tenere p.mu in scrittura.
+func (p *MemoryStorageProvider) mkdirAll(cleanPath string, modTime time.Time) (*memoryNode, error) {
+	node := p.root
+	if cleanPath == "/" {
+		return node, nil
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(cleanPath, "/"), "/") {
+		child, ok := node.children[name]
+		if !ok {
+			child = newDirectoryNode(modTime)
+			node.children[name] = child
+			node.modTime = modTime
+		} else if !child.isDir {
+			return nil, fmt.Errorf("'%s' is not a directory", name)
+		}
+		node = child
+	}
+	return node, nil
+}
+
+func (n *memoryNode) itemInfo(name string, itemPath string) storage.ItemInfo {
+	info := storage.ItemInfo{
+		Name:    name,
+		IsDir:   n.isDir,
+		ModTime: n.modTime,
+		Path:    itemPath,
+		SHA256:  n.sha256,
+	}
+	if !n.isDir {
+		info.Size = int64(len(n.data))
+	}
+	if n.uploadedBy != "" {
+		uploadedAt := n.uploadedAt
+		info.UploadedBy = n.uploadedBy
+		info.UploadedAt = &uploadedAt
+	}
+	return info
+}
+
+// clone returns a deep copy of the node. I dati dei file sono condivisi perché immutabili.
+func (n *memoryNode) clone(modTime time.Time) *memoryNode {
+	if !n.isDir {
+		return &memoryNode{data: n.data, sha256: n.sha256, modTime: modTime, uploadedBy: n.uploadedBy, uploadedAt: n.uploadedAt}
+	}
+	copied := newDirectoryNode(modTime)
+	for name, child := range n.children {
+		copied.children[name] = child.clone(modTime)
+	}
+	return copied
+}
+
+// ListItems lists the items of a directory, with the same filters, ordering (directory prima, poi per
+// nome) and pagination of the local provider.
+func (p *MemoryStorageProvider) ListItems(ctx context.Context, claims *auth.UserClaims, itemPath string, page int, itemsPerPage int, nameFilter string, modTimeRange *storage.ModTimeRange, onlyDirectories bool, onlyFiles bool) (*storage.ListItemsResponse, error) {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("MemoryStorageProvider.ListItems chiamato da utente '%s' per storage '%s', path '%s', page %d, itemsPerPage %d, nameFilter '%s', onlyDirectories: %t, onlyFiles: %t", userIdent, p.name, itemPath, page, itemsPerPage, nameFilter, onlyDirectories, onlyFiles)
+
+	dirPath, err := cleanPath(itemPath)
+	if err != nil {
+		return nil, fmt.Errorf("path validation error: %w", err)
+	}
+	var nameRegexp *regexp.Regexp
+	if nameFilter != "" {
+		if nameRegexp, err = regexp.Compile(nameFilter); err != nil {
+			return nil, fmt.Errorf("invalid name filter: %w", err)
+		}
+	}
+
+	p.mu.RLock()
+	dir := p.lookup(dirPath)
+	if dir == nil {
+		p.mu.RUnlock()
+		return nil, storage.ErrNotFound
+	}
+	if !dir.isDir {
+		p.mu.RUnlock()
+		return nil, fmt.Errorf("path '%s' is not a directory", itemPath)
+	}
+	filteredItems := []storage.ItemInfo{}
+	for name, child := range dir.children {
+		if onlyDirectories && !child.isDir {
+			continue
+		}
+		if onlyFiles && child.isDir {
+			continue
+		}
+		if nameRegexp != nil && !nameRegexp.MatchString(name) {
+			continue
+		}
+		if !modTimeRange.Contains(child.modTime) {
+			continue
+		}
+		filteredItems = append(filteredItems, child.itemInfo(name, path.Join(dirPath, name)))
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(filteredItems, func(i, j int) bool {
+		if filteredItems[i].IsDir != filteredItems[j].IsDir {
+			return filteredItems[i].IsDir
+		}
+		return filteredItems[i].Name < filteredItems[j].Name
+	})
+
+	totalItems := len(filteredItems)
+	startIndex := (page - 1) * itemsPerPage
+	endIndex := startIndex + itemsPerPage
+	if startIndex < 0 || startIndex >= totalItems {
+		return &storage.ListItemsResponse{Items: []storage.ItemInfo{}, TotalItems: totalItems, Page: page, ItemsPerPage: itemsPerPage}, nil
+	}
+	if endIndex > totalItems {
+		endIndex = totalItems
+	}
+	return &storage.ListItemsResponse{
+		Items:        filteredItems[startIndex:endIndex],
+		TotalItems:   totalItems,
+		Page:         page,
+		ItemsPerPage: itemsPerPage,
+	}, nil
+}
+
+// CountItems counts the children of a directory matching nameFilter.
+func (p *MemoryStorageProvider) CountItems(ctx context.Context, claims *auth.UserClaims, itemPath string, nameFilter string, maxCount int) (int, bool, error) {
+	dirPath, err := cleanPath(itemPath)
+	if err != nil {
+		return 0, false, fmt.Errorf("path validation error: %w", err)
+	}
+	var nameRegexp *regexp.Regexp
+	if nameFilter != "" {
+		if nameRegexp, err = regexp.Compile(nameFilter); err != nil {
+			return 0, false, fmt.Errorf("invalid name filter: %w", err)
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	dir := p.lookup(dirPath)
+	if dir == nil {
+		return 0, false, storage.ErrNotFound
+	}
+	if !dir.isDir {
+		return 0, false, fmt.Errorf("path '%s' is not a directory", itemPath)
+	}
+	count := 0
+	for name := range dir.children {
+		if nameRegexp != nil && !nameRegexp.MatchString(name) {
+			continue
+		}
+		if count == maxCount {
+			return count, false, nil
+		}
+		count++
+	}
+	return count, true, nil
+}
+
+// GetItem retrieves information about a single item.
+func (p *MemoryStorageProvider) GetItem(ctx context.Context, claims *auth.UserClaims, itemPath string) (*storage.ItemInfo, error) {
+	cleanItemPath, err := cleanPath(itemPath)
+	if err != nil {
+		return nil, fmt.Errorf("path validation error: %w", err)
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	node := p.lookup(cleanItemPath)
+	if node == nil {
+		return nil, storage.ErrNotFound
+	}
+	info := node.itemInfo(path.Base(cleanItemPath), cleanItemPath)
+	return &info, nil
+}
+
+// OpenReader opens a file for reading.
+func (p *MemoryStorageProvider) OpenReader(ctx context.Context, claims *auth.UserClaims, itemPath string) (io.ReadCloser, error) {
+	reader, err := p.OpenReaderAt(ctx, claims, itemPath)
+	if err != nil {
+		return nil, err
+	}
+	return reader.(*memoryReader), nil
+}
+
+// memoryReader adapts the content of a file to io.ReadCloser and storage.ReaderAtCloser.
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (r *memoryReader) Close() error {
+	return nil
+}
+
+// OpenReaderAt opens a file for random access reads.
+func (p *MemoryStorageProvider) OpenReaderAt(ctx context.Context, claims *auth.UserClaims, itemPath string) (storage.ReaderAtCloser, error) {
+	cleanItemPath, err := cleanPath(itemPath)
+	if err != nil {
+		return nil, fmt.Errorf("path validation error: %w", err)
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	node := p.lookup(cleanItemPath)
+	if node == nil {
+		return nil, storage.ErrNotFound
+	}
+	if node.isDir {
+		return nil, storage.ErrIsDirectory
+	}
+	return &memoryReader{Reader: bytes.NewReader(node.data)}, nil
+}
+
+// Capabilities reports that memory files support random access.
+func (p *MemoryStorageProvider) Capabilities() storage.Capabilities {
+	return storage.Capabilities{RandomAccess: true, UploadPathAtFinalize: true}
+}
+
+// HealthCheck always succeeds: the content is in the process memory.
+func (p *MemoryStorageProvider) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+// CreateDirectory creates a directory and its missing parents.
+func (p *MemoryStorageProvider) CreateDirectory(ctx context.Context, claims *auth.UserClaims, itemPath string) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("MemoryStorageProvider.CreateDirectory chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, itemPath)
+
+	cleanItemPath, err := cleanPath(itemPath)
+	if err != nil {
+		return fmt.Errorf("path validation error: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lookup(cleanItemPath) != nil {
+		return storage.ErrAlreadyExists
+	}
+	if _, err := p.mkdirAll(cleanItemPath, time.Now()); err != nil {
+		return fmt.Errorf("error creating directory '%s': %w", itemPath, err)
+	}
+	return nil
+}
+
+// DeleteItem deletes a file or directory (recursively).
+func (p *MemoryStorageProvider) DeleteItem(ctx context.Context, claims *auth.UserClaims, itemPath string) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("MemoryStorageProvider.DeleteItem chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, itemPath)
+
+	cleanItemPath, err := cleanPath(itemPath)
+	if err != nil {
+		return fmt.Errorf("path validation error: %w", err)
+	}
+	if cleanItemPath == "/" {
+		return fmt.Errorf("%w: cannot delete the storage root", storage.ErrPermissionDenied)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	parent := p.lookup(path.Dir(cleanItemPath))
+	if parent == nil || !parent.isDir {
+		return storage.ErrNotFound
+	}
+	name := path.Base(cleanItemPath)
+	if _, ok := parent.children[name]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(parent.children, name)
+	parent.modTime = time.Now()
+	return nil
+}
+
+// MoveItem moves or renames a file or directory, creating the missing parents of dstPath.
+func (p *MemoryStorageProvider) MoveItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	return p.transferItem(claims, "move", srcPath, dstPath)
+}
+
+// CopyItem copies a file or, recursively, a directory.
+func (p *MemoryStorageProvider) CopyItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error {
+	return p.transferItem(claims, "copy", srcPath, dstPath)
+}
+
+// transferItem implements MoveItem and CopyItem, with the checks of the local provider.
+func (p *MemoryStorageProvider) transferItem(claims *auth.UserClaims, operation string, srcPath string, dstPath string) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("MemoryStorageProvider.%s chiamato da utente '%s' per storage '%s', da '%s' a '%s'", operation, userIdent, p.name, srcPath, dstPath)
+
+	cleanSrcPath, err := cleanPath(srcPath)
+	if err != nil {
+		return fmt.Errorf("source path validation error: %w", err)
+	}
+	cleanDstPath, err := cleanPath(dstPath)
+	if err != nil {
+		return fmt.Errorf("destination path validation error: %w", err)
+	}
+	if cleanDstPath == "/" || (operation == "move" && cleanSrcPath == "/") {
+		return fmt.Errorf("%w: cannot %s the storage root", storage.ErrPermissionDenied, operation)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	src := p.lookup(cleanSrcPath)
+	if src == nil {
+		return storage.ErrNotFound
+	}
+	if p.lookup(cleanDstPath) != nil {
+		return storage.ErrAlreadyExists
+	}
+	if src.isDir && strings.HasPrefix(cleanDstPath, strings.TrimSuffix(cleanSrcPath, "/")+"/") {
+		return fmt.Errorf("cannot %s directory '%s' into itself", operation, srcPath)
+	}
+
+	now := time.Now()
+	dstParent, err := p.mkdirAll(path.Dir(cleanDstPath), now)
+	if err != nil {
+		return fmt.Errorf("error creating parent directory of '%s': %w", dstPath, err)
+	}
+	if operation == "move" {
+		srcParent := p.lookup(path.Dir(cleanSrcPath))
+		delete(srcParent.children, path.Base(cleanSrcPath))
+		srcParent.modTime = now
+		dstParent.children[path.Base(cleanDstPath)] = src
+	} else {
+		dstParent.children[path.Base(cleanDstPath)] = src.clone(now)
+	}
+	dstParent.modTime = now
+	return nil
+}
+
+// GetUsedBytes returns the total size of the files of the storage. I chunk degli upload in corso non
+// sono contati, come per gli altri provider remoti.
+func (p *MemoryStorageProvider) GetUsedBytes(ctx context.Context, claims *auth.UserClaims) (int64, error) {
+	totalBytes, _, err := p.GetDirectorySize(ctx, claims, "/")
+	return totalBytes, err
+}
+
+// CanWrite always returns true: lo storage in memoria non ha ACL proprie.
+func (p *MemoryStorageProvider) CanWrite(ctx context.Context, claims *auth.UserClaims, path string) (bool, error) {
+	return true, nil
+}
+
+// GetDirectorySize returns the total size and the number of the files under dirPath.
+func (p *MemoryStorageProvider) GetDirectorySize(ctx context.Context, claims *auth.UserClaims, dirPath string) (int64, int64, error) {
+	rootPath, err := cleanPath(dirPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("path validation error: %w", err)
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	dir := p.lookup(rootPath)
+	if dir == nil || !dir.isDir {
+		return 0, 0, storage.ErrNotFound
+	}
+	var totalBytes, fileCount int64
+	pending := []*memoryNode{dir}
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, child := range current.children {
+			if child.isDir {
+				pending = append(pending, child)
+			} else {
+				totalBytes += int64(len(child.data))
+				fileCount++
+			}
+		}
+	}
+	return totalBytes, fileCount, nil
+}
+
+// Search returns the items under basePath whose name matches pattern, visiting the directories in
+// name order so that the results are stable.
+func (p *MemoryStorageProvider) Search(ctx context.Context, claims *auth.UserClaims, basePath string, pattern string, modTimeRange *storage.ModTimeRange, maxResults int) ([]storage.ItemInfo, error) {
+	p.logger.Infof("MemoryStorageProvider.Search per storage '%s', basePath '%s', pattern '%s', maxResults %d", p.name, basePath, pattern, maxResults)
+
+	nameRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+	rootPath, err := cleanPath(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("path validation error: %w", err)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	root := p.lookup(rootPath)
+	if root == nil || !root.isDir {
+		return nil, storage.ErrNotFound
+	}
+	found := []storage.ItemInfo{}
+	pending := []string{rootPath}
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dirPath := pending[0]
+		pending = pending[1:]
+		dir := p.lookup(dirPath)
+		names := make([]string, 0, len(dir.children))
+		for name := range dir.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := dir.children[name]
+			childPath := path.Join(dirPath, name)
+			if child.isDir {
+				pending = append(pending, childPath)
+			}
+			if nameRegexp.MatchString(name) && modTimeRange.Contains(child.modTime) {
+				found = append(found, child.itemInfo(name, childPath))
+				if len(found) >= maxResults {
+					return found, nil
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// Walk visits the directories under basePath in name order, like Search. Il lock viene rilasciato prima
+// di chiamare fn, che può bloccarsi (es. l'invio dei messaggi a un client lento).
+func (p *MemoryStorageProvider) Walk(ctx context.Context, claims *auth.UserClaims, basePath string, maxDepth int, fn storage.WalkFunc) error {
+	p.logger.Infof("MemoryStorageProvider.Walk per storage '%s', basePath '%s', maxDepth %d", p.name, basePath, maxDepth)
+
+	rootPath, err := cleanPath(basePath)
+	if err != nil {
+		return fmt.Errorf("path validation error: %w", err)
+	}
+	type pendingDir struct {
+		path     string
+		relative string
+		depth    int
+	}
+	pending := []pendingDir{{path: rootPath}}
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		current := pending[0]
+		pending = pending[1:]
+
+		p.mu.RLock()
+		dir := p.lookup(current.path)
+		if dir == nil || !dir.isDir {
+			p.mu.RUnlock()
+			if current.path == rootPath {
+				return storage.ErrNotFound
+			}
+			// Rimossa durante la visita.
+			continue
+		}
+		names := make([]string, 0, len(dir.children))
+		for name := range dir.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		children := make([]storage.ItemInfo, 0, len(names))
+		for _, name := range names {
+			children = append(children, dir.children[name].itemInfo(name, path.Join(current.path, name)))
+		}
+		p.mu.RUnlock()
+
+		for _, child := range children {
+			relative := path.Join(current.relative, child.Name)
+			if err := fn(child, relative); err != nil {
+				if errors.Is(err, fs.SkipDir) {
+					continue
+				}
+				if errors.Is(err, fs.SkipAll) {
+					return nil
+				}
+				return err
+			}
+			if child.IsDir && (maxDepth <= 0 || current.depth+1 < maxDepth) {
+				pending = append(pending, pendingDir{path: child.Path, relative: relative, depth: current.depth + 1})
+			}
+		}
+	}
+	return nil
+}
+
+// StoreChecksum saves a SHA256 computed outside of an upload (e.g. by compute_hash).
+// hashed is the item as read before hashing: se il file è cambiato nel frattempo il checksum non
+// viene salvato (ErrPreconditionFailed). Non fa nulla se store_checksums non è abilitato per lo storage.
+func (p *MemoryStorageProvider) StoreChecksum(ctx context.Context, claims *auth.UserClaims, itemPath string, sha256Hex string, hashed *storage.ItemInfo) error {
+	if !p.storeChecksums {
+		return nil
+	}
+	cleanItemPath, err := cleanPath(itemPath)
+	if err != nil {
+		return fmt.Errorf("path validation error: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	node := p.lookup(cleanItemPath)
+	if node == nil || node.isDir {
+		return storage.ErrNotFound
+	}
+	if int64(len(node.data)) != hashed.Size || !node.modTime.Equal(hashed.ModTime) {
+		return fmt.Errorf("%w: '%s' changed while its checksum was computed", storage.ErrPreconditionFailed, cleanItemPath)
+	}
+	node.sha256 = sha256Hex
+	return nil
+}
+
+// --- Upload ---
+
+// I chunk restano nella sessione fino al finalize, che crea il file in un solo passo: un upload
+// annullato o fallito non lascia file parziali nell'albero.
+
+// InitiateUpload starts (or resumes) the upload uploadID and returns the bytes already received.
+func (p *MemoryStorageProvider) InitiateUpload(ctx context.Context, claims *auth.UserClaims, uploadID string, filePath string, totalFileSize int64, chunkSize int64) (int64, error) {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("MemoryStorageProvider.InitiateUpload chiamato da utente '%s' per storage '%s', path '%s', size %d", userIdent, p.name, filePath, totalFileSize)
+	if totalFileSize < 0 || chunkSize <= 0 {
+		return 0, fmt.Errorf("%w: invalid total size %d or chunk size %d", storage.ErrInvalidChunk, totalFileSize, chunkSize)
+	}
+	if err := storage.CheckUploadSize(p.maxUploadBytes, totalFileSize); err != nil {
+		return 0, err
+	}
+	cleanFilePath, err := cleanPath(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("path validation error: %w", err)
+	}
+	if cleanFilePath == "/" {
+		return 0, storage.ErrIsDirectory
+	}
+
+	p.uploadsMu.Lock()
+	defer p.uploadsMu.Unlock()
+	if session, ok := p.uploads[uploadID]; ok {
+		if session.expectedSize != totalFileSize || session.chunkSize != chunkSize {
+			return 0, fmt.Errorf("%w: an upload of '%s' with size %d and chunk size %d is already in progress", storage.ErrInvalidChunk, filePath, session.expectedSize, session.chunkSize)
+		}
+		return session.received(), nil
+	}
+	p.uploads[uploadID] = &uploadSession{
+		expectedSize:   totalFileSize,
+		chunkSize:      chunkSize,
+		expectedChunks: (totalFileSize + chunkSize - 1) / chunkSize,
+		chunks:         make(map[int64][]byte),
+	}
+	return 0, nil
+}
+
+// received returns the bytes received by the session. Il chiamante deve tenere p.uploadsMu.
+func (s *uploadSession) received() int64 {
+	var total int64
+	for _, chunk := range s.chunks {
+		total += int64(len(chunk))
+	}
+	return total
+}
+
+// WriteChunk stores a chunk in the upload session, validating it against the declared layout.
+func (p *MemoryStorageProvider) WriteChunk(ctx context.Context, claims *auth.UserClaims, uploadID string, chunkData []byte, chunkIndex int64, chunkSize int64, checksum storage.ChunkChecksum) error {
+	if err := checksum.VerifyChunk(chunkIndex, chunkData); err != nil {
+		return err
+	}
+	p.uploadsMu.Lock()
+	defer p.uploadsMu.Unlock()
+	session, ok := p.uploads[uploadID]
+	if !ok {
+		return fmt.Errorf("%w: memory upload '%s'", storage.ErrUploadNotFound, uploadID)
+	}
+
+	if chunkSize != session.chunkSize {
+		return fmt.Errorf("%w: chunk size %d differs from the chunk size %d declared at initiate", storage.ErrInvalidChunk, chunkSize, session.chunkSize)
+	}
+	if chunkIndex < 0 || chunkIndex >= session.expectedChunks {
+		return fmt.Errorf("%w: chunk index %d out of range [0, %d)", storage.ErrInvalidChunk, chunkIndex, session.expectedChunks)
+	}
+	if int64(len(chunkData)) > chunkSize {
+		return fmt.Errorf("%w: chunk %d has %d bytes, chunk size is %d", storage.ErrInvalidChunk, chunkIndex, len(chunkData), chunkSize)
+	}
+	if chunkEnd := chunkIndex*chunkSize + int64(len(chunkData)); chunkEnd > session.expectedSize {
+		return fmt.Errorf("%w: chunk %d ends at byte %d, declared size is %d", storage.ErrSizeExceeded, chunkIndex, chunkEnd, session.expectedSize)
+	}
+	// I chunk restano entro la dimensione dichiarata, verificata all'initiate: nessun controllo cumulativo.
+	// Copia: il chiamante può riusare il buffer del chunk.
+	session.chunks[chunkIndex] = append([]byte(nil), chunkData...)
+	return nil
+}
+
+// FinalizeUpload assembles the chunks, verifies the size and the SHA256 and stores the file at filePath.
+// Un file esistente viene sostituito solo con overwrite, altrimenti ErrAlreadyExists: il controllo avviene
+// sotto lo stesso lock della scrittura e la sessione resta valida per ripetere il finalize.
+func (p *MemoryStorageProvider) FinalizeUpload(ctx context.Context, claims *auth.UserClaims, uploadID string, filePath string, expectedSHA256 string, overwrite bool) error {
+	userIdent := "unauthenticated"
+	if claims != nil {
+		userIdent = claims.Email
+	}
+	p.logger.Infof("MemoryStorageProvider.FinalizeUpload chiamato da utente '%s' per storage '%s', path '%s'", userIdent, p.name, filePath)
+	cleanFilePath, err := cleanPath(filePath)
+	if err != nil {
+		return fmt.Errorf("path validation error: %w", err)
+	}
+	p.uploadsMu.Lock()
+	session, ok := p.uploads[uploadID]
+	delete(p.uploads, uploadID)
+	p.uploadsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: memory upload '%s'", storage.ErrUploadNotFound, uploadID)
+	}
+	// Rimessa nella mappa se la destinazione è occupata, per un nuovo finalize.
+	keepSession := func() {
+		p.uploadsMu.Lock()
+		p.uploads[uploadID] = session
+		p.uploadsMu.Unlock()
+	}
+
+	// I chunk mancanti lasciano un buco: lo rileva il controllo sulla dimensione ricevuta.
+	data := make([]byte, session.expectedSize)
+	for chunkIndex, chunk := range session.chunks {
+		copy(data[chunkIndex*session.chunkSize:], chunk)
+	}
+	if received := session.received(); received != session.expectedSize {
+		return fmt.Errorf("%w: received %d bytes, declared size is %d", storage.ErrSizeMismatch, received, session.expectedSize)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var checksum string
+	if expectedSHA256 != "" {
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), expectedSHA256) {
+			return storage.ErrIntegrityCheckFailed
+		}
+		if p.storeChecksums {
+			checksum = strings.ToLower(expectedSHA256)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing := p.lookup(cleanFilePath); existing != nil && existing.isDir {
+		keepSession()
+		return storage.ErrIsDirectory
+	} else if existing != nil && !overwrite {
+		keepSession()
+		return storage.ErrAlreadyExists
+	}
+	now := time.Now()
+	parent, err := p.mkdirAll(path.Dir(cleanFilePath), now)
+	if err != nil {
+		return fmt.Errorf("error creating parent directory of '%s': %w", filePath, err)
+	}
+	node := &memoryNode{data: data, sha256: checksum, modTime: now}
+	if p.recordUploader {
+		node.uploadedBy = storage.UploaderName(claims)
+		node.uploadedAt = now.UTC()
+	}
+	parent.children[path.Base(cleanFilePath)] = node
+	parent.modTime = now
+	return nil
+}
+
+// CancelUpload discards an upload session.
+func (p *MemoryStorageProvider) CancelUpload(claims *auth.UserClaims, uploadID string) error {
+	p.uploadsMu.Lock()
+	delete(p.uploads, uploadID)
+	p.uploadsMu.Unlock()
+	return nil
+}
+
+// GetUploadedSize returns the bytes received for an ongoing upload (0 if there is none).
+func (p *MemoryStorageProvider) GetUploadedSize(claims *auth.UserClaims, uploadID string) (int64, error) {
+	p.uploadsMu.Lock()
+	defer p.uploadsMu.Unlock()
+	session, ok := p.uploads[uploadID]
+	if !ok {
+		return 0, nil
+	}
+	return session.received(), nil
+}
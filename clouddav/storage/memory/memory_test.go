@@ -0,0 +1,369 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+func newTestProvider(t *testing.T) *MemoryStorageProvider {
+	t.Helper()
+	p, err := NewProvider(context.Background(), &config.StorageConfig{Name: "mem", Type: "memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// writeFile uploads data to filePath in chunks of 4 bytes.
+func writeFile(t *testing.T, p *MemoryStorageProvider, filePath string, data string) {
+	t.Helper()
+	ctx := context.Background()
+	const chunkSize = 4
+	if _, err := p.InitiateUpload(ctx, nil, filePath, filePath, int64(len(data)), chunkSize); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i += chunkSize {
+		end := i + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := p.WriteChunk(ctx, nil, filePath, []byte(data[i:end]), int64(i/chunkSize), chunkSize, storage.ChunkChecksum{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.FinalizeUpload(ctx, nil, filePath, filePath, "", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, p *MemoryStorageProvider, filePath string) string {
+	t.Helper()
+	reader, err := p.OpenReader(context.Background(), nil, filePath)
+	if err != nil {
+		t.Fatalf("OpenReader(%s): %v", filePath, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"", "/", false},
+		{"/", "/", false},
+		{"docs", "/docs", false},
+		{"/docs/", "/docs", false},
+		{"//docs//a.txt", "/docs/a.txt", false},
+		{"/docs/./a.txt", "/docs/a.txt", false},
+		{"/..", "", true},
+		{"/docs/../../etc", "", true},
+		{"../secret", "", true},
+		{"/docs/..", "", true},
+		{"/docs/..hidden", "/docs/..hidden", false},
+	}
+	for _, tt := range tests {
+		got, err := cleanPath(tt.path)
+		if tt.wantErr {
+			if !errors.Is(err, storage.ErrPermissionDenied) {
+				t.Errorf("cleanPath(%q) = %q, %v, want ErrPermissionDenied", tt.path, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("cleanPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	ctx := context.Background()
+	content := "0123456789"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name        string
+		chunks      []int64 // Indici dei chunk inviati, nell'ordine
+		sha256      string
+		existing    bool // Il file di destinazione esiste già
+		overwrite   bool
+		finalizeErr error
+	}{
+		{name: "in order", chunks: []int64{0, 1, 2}},
+		{name: "out of order", chunks: []int64{2, 0, 1}, sha256: checksum},
+		{name: "resent chunk", chunks: []int64{0, 1, 1, 2}},
+		{name: "missing chunk", chunks: []int64{0, 2}, finalizeErr: storage.ErrSizeMismatch},
+		{name: "wrong sha256", chunks: []int64{0, 1, 2}, sha256: strings.Repeat("0", 64), finalizeErr: storage.ErrIntegrityCheckFailed},
+		{name: "existing file", chunks: []int64{0, 1, 2}, existing: true, finalizeErr: storage.ErrAlreadyExists},
+		{name: "overwrite", chunks: []int64{0, 1, 2}, existing: true, overwrite: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			if tt.existing {
+				writeFile(t, p, "/dir/file.bin", "old")
+			}
+			if _, err := p.InitiateUpload(ctx, nil, "up", "/dir/file.bin", int64(len(content)), 4); err != nil {
+				t.Fatal(err)
+			}
+			for _, index := range tt.chunks {
+				end := (index + 1) * 4
+				if end > int64(len(content)) {
+					end = int64(len(content))
+				}
+				if err := p.WriteChunk(ctx, nil, "up", []byte(content[index*4:end]), index, 4, storage.ChunkChecksum{}); err != nil {
+					t.Fatalf("WriteChunk(%d): %v", index, err)
+				}
+			}
+			err := p.FinalizeUpload(ctx, nil, "up", "/dir/file.bin", tt.sha256, tt.overwrite)
+			if tt.finalizeErr != nil {
+				if !errors.Is(err, tt.finalizeErr) {
+					t.Fatalf("FinalizeUpload = %v, want %v", err, tt.finalizeErr)
+				}
+				if tt.existing {
+					if err := p.FinalizeUpload(ctx, nil, "up", "/dir/file.bin", tt.sha256, true); err != nil {
+						t.Errorf("FinalizeUpload retried with overwrite: %v", err)
+					}
+					if got := readFile(t, p, "/dir/file.bin"); got != content {
+						t.Errorf("content after the retried finalize = %q, want %q", got, content)
+					}
+				} else if _, err := p.GetItem(ctx, nil, "/dir/file.bin"); !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("GetItem after a failed upload = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FinalizeUpload: %v", err)
+			}
+			if got := readFile(t, p, "/dir/file.bin"); got != content {
+				t.Errorf("content = %q, want %q", got, content)
+			}
+			p.uploadsMu.Lock()
+			_, kept := p.uploads["up"]
+			p.uploadsMu.Unlock()
+			if kept {
+				t.Error("upload session kept after a successful finalize")
+			}
+		})
+	}
+}
+
+func TestWriteChunkRejectsInvalidChunks(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		data      string
+		index     int64
+		chunkSize int64
+		want      error
+	}{
+		{"index out of range", "ab", 3, 4, storage.ErrInvalidChunk},
+		{"negative index", "ab", -1, 4, storage.ErrInvalidChunk},
+		{"different chunk size", "ab", 0, 8, storage.ErrInvalidChunk},
+		{"larger than the chunk size", "abcde", 0, 4, storage.ErrInvalidChunk},
+		{"past the declared size", "abcd", 2, 4, storage.ErrSizeExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			if _, err := p.InitiateUpload(ctx, nil, "up", "/file.bin", 10, 4); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.WriteChunk(ctx, nil, "up", []byte(tt.data), tt.index, tt.chunkSize, storage.ChunkChecksum{}); !errors.Is(err, tt.want) {
+				t.Errorf("WriteChunk = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	p := newTestProvider(t)
+	if err := p.WriteChunk(ctx, nil, "missing", []byte("a"), 0, 4, storage.ChunkChecksum{}); !errors.Is(err, storage.ErrUploadNotFound) {
+		t.Errorf("WriteChunk without InitiateUpload = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestListItems(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t)
+	for _, dir := range []string{"/b-dir", "/a-dir"} {
+		if err := p.CreateDirectory(ctx, nil, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"/c.txt", "/a.txt", "/b.log"} {
+		writeFile(t, p, file, "data")
+	}
+
+	tests := []struct {
+		name            string
+		page            int
+		itemsPerPage    int
+		nameFilter      string
+		onlyDirectories bool
+		onlyFiles       bool
+		want            string // Nomi della pagina, separati da virgole
+		wantTotal       int
+	}{
+		{name: "directories first, then by name", page: 1, itemsPerPage: 10, want: "a-dir,b-dir,a.txt,b.log,c.txt", wantTotal: 5},
+		{name: "first page", page: 1, itemsPerPage: 2, want: "a-dir,b-dir", wantTotal: 5},
+		{name: "last page", page: 3, itemsPerPage: 2, want: "c.txt", wantTotal: 5},
+		{name: "page past the end", page: 4, itemsPerPage: 2, want: "", wantTotal: 5},
+		{name: "name filter", page: 1, itemsPerPage: 10, nameFilter: `\.txt$`, want: "a.txt,c.txt", wantTotal: 2},
+		{name: "only directories", page: 1, itemsPerPage: 10, onlyDirectories: true, want: "a-dir,b-dir", wantTotal: 2},
+		{name: "only files", page: 1, itemsPerPage: 10, onlyFiles: true, want: "a.txt,b.log,c.txt", wantTotal: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := p.ListItems(ctx, nil, "/", tt.page, tt.itemsPerPage, tt.nameFilter, nil, tt.onlyDirectories, tt.onlyFiles)
+			if err != nil {
+				t.Fatalf("ListItems: %v", err)
+			}
+			var names []string
+			for _, item := range response.Items {
+				names = append(names, item.Name)
+			}
+			if got := strings.Join(names, ","); got != tt.want || response.TotalItems != tt.wantTotal {
+				t.Errorf("ListItems = %s (total %d), want %s (total %d)", got, response.TotalItems, tt.want, tt.wantTotal)
+			}
+		})
+	}
+
+	if _, err := p.ListItems(ctx, nil, "/missing", 1, 10, "", nil, false, false); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("ListItems on a missing directory = %v, want ErrNotFound", err)
+	}
+	if _, err := p.ListItems(ctx, nil, "/a.txt", 1, 10, "", nil, false, false); err == nil {
+		t.Error("ListItems on a file succeeded, want an error")
+	}
+}
+
+func TestMoveCopyDelete(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t)
+	writeFile(t, p, "/src/a.txt", "aaaa")
+	writeFile(t, p, "/src/sub/b.txt", "bbbbbb")
+
+	if err := p.CopyItem(ctx, nil, "/src", "/copy"); err != nil {
+		t.Fatalf("CopyItem: %v", err)
+	}
+	if err := p.DeleteItem(ctx, nil, "/copy/sub/b.txt"); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+	if got := readFile(t, p, "/src/sub/b.txt"); got != "bbbbbb" {
+		t.Errorf("source after deleting from the copy = %q, want it unchanged", got)
+	}
+	if err := p.MoveItem(ctx, nil, "/src", "/moved/deep"); err != nil {
+		t.Fatalf("MoveItem: %v", err)
+	}
+	if _, err := p.GetItem(ctx, nil, "/src"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetItem of the moved source = %v, want ErrNotFound", err)
+	}
+	if got := readFile(t, p, "/moved/deep/sub/b.txt"); got != "bbbbbb" {
+		t.Errorf("moved file = %q, want %q", got, "bbbbbb")
+	}
+
+	size, count, err := p.GetDirectorySize(ctx, nil, "/")
+	if err != nil || size != 14 || count != 3 {
+		t.Errorf("GetDirectorySize(/) = %d, %d, %v, want 14, 3", size, count, err)
+	}
+
+	errorTests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"copy of a missing item", p.CopyItem(ctx, nil, "/missing", "/x"), storage.ErrNotFound},
+		{"move onto an existing item", p.MoveItem(ctx, nil, "/copy", "/moved"), storage.ErrAlreadyExists},
+		{"move of the root", p.MoveItem(ctx, nil, "/", "/x"), storage.ErrPermissionDenied},
+		{"copy onto the root", p.CopyItem(ctx, nil, "/copy", "/"), storage.ErrPermissionDenied},
+		{"delete of the root", p.DeleteItem(ctx, nil, "/"), storage.ErrPermissionDenied},
+		{"delete of a missing item", p.DeleteItem(ctx, nil, "/missing"), storage.ErrNotFound},
+		{"create of an existing directory", p.CreateDirectory(ctx, nil, "/copy"), storage.ErrAlreadyExists},
+		{"read of a directory", func() error { _, err := p.OpenReader(ctx, nil, "/copy"); return err }(), storage.ErrIsDirectory},
+	}
+	for _, tt := range errorTests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+	if err := p.MoveItem(ctx, nil, "/copy", "/copy/inside"); err == nil {
+		t.Error("moving a directory into itself succeeded")
+	}
+
+	if err := p.DeleteItem(ctx, nil, "/moved"); err != nil {
+		t.Fatalf("recursive DeleteItem: %v", err)
+	}
+	if used, err := p.GetUsedBytes(ctx, nil); err != nil || used != 4 {
+		t.Errorf("GetUsedBytes = %d, %v, want 4", used, err)
+	}
+}
+
+func TestTraversalIsRejected(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t)
+	writeFile(t, p, "/docs/a.txt", "a")
+	operations := map[string]error{
+		"GetItem":          func() error { _, err := p.GetItem(ctx, nil, "/docs/../../a.txt"); return err }(),
+		"ListItems":        func() error { _, err := p.ListItems(ctx, nil, "/..", 1, 10, "", nil, false, false); return err }(),
+		"OpenReader":       func() error { _, err := p.OpenReader(ctx, nil, "../docs/a.txt"); return err }(),
+		"CreateDirectory":  p.CreateDirectory(ctx, nil, "/docs/../x"),
+		"DeleteItem":       p.DeleteItem(ctx, nil, "/docs/.."),
+		"MoveItem source":  p.MoveItem(ctx, nil, "/docs/../docs", "/x"),
+		"CopyItem target":  p.CopyItem(ctx, nil, "/docs", "/../x"),
+		"Search":           func() error { _, err := p.Search(ctx, nil, "/..", ".*", nil, 10); return err }(),
+		"GetDirectorySize": func() error { _, _, err := p.GetDirectorySize(ctx, nil, "/docs/.."); return err }(),
+		"InitiateUpload":   func() error { _, err := p.InitiateUpload(ctx, nil, "up", "/../x", 1, 1); return err }(),
+	}
+	for name, err := range operations {
+		if !errors.Is(err, storage.ErrPermissionDenied) {
+			t.Errorf("%s with \"..\" = %v, want ErrPermissionDenied", name, err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvider(t)
+	for _, file := range []string{"/b/report.txt", "/a/report.txt", "/a/notes.md", "/report.txt"} {
+		writeFile(t, p, file, "x")
+	}
+	tests := []struct {
+		basePath   string
+		pattern    string
+		maxResults int
+		want       string // Path trovati, separati da virgole
+	}{
+		{"/", `^report`, 10, "/report.txt,/a/report.txt,/b/report.txt"},
+		{"/", `^report`, 2, "/report.txt,/a/report.txt"},
+		{"/a", `\.md$`, 10, "/a/notes.md"},
+		{"/", `^a$`, 10, "/a"},
+	}
+	for _, tt := range tests {
+		items, err := p.Search(ctx, nil, tt.basePath, tt.pattern, nil, tt.maxResults)
+		if err != nil {
+			t.Fatalf("Search(%s, %s): %v", tt.basePath, tt.pattern, err)
+		}
+		var paths []string
+		for _, item := range items {
+			paths = append(paths, item.Path)
+		}
+		if got := strings.Join(paths, ","); got != tt.want {
+			t.Errorf("Search(%s, %s, %d) = %s, want %s", tt.basePath, tt.pattern, tt.maxResults, got, tt.want)
+		}
+	}
+	if _, err := p.Search(ctx, nil, "/missing", ".*", nil, 10); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Search under a missing directory = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"clouddav/auth"
+)
+
+// ErrPreconditionFailed is returned when an item changed since the client last read it: la mod_time o
+// l'ETag attesi (expected_mod_time, expected_etag) non sono più quelli dell'elemento.
+var ErrPreconditionFailed = errors.New("item changed since it was last read")
+
+// ItemPrecondition is the version of an item expected by a delete or an overwrite (concorrenza ottimistica):
+// la modifica viene rifiutata con ErrPreconditionFailed se nel frattempo un altro client ha cambiato l'elemento.
+type ItemPrecondition struct {
+	ModTime *time.Time
+	ETag    string
+}
+
+// ParseItemPrecondition parses the expected_mod_time (RFC 3339) and expected_etag of a request; both may be empty.
+func ParseItemPrecondition(modTime, etag string) (ItemPrecondition, error) {
+	precondition := ItemPrecondition{ETag: strings.TrimSpace(etag)}
+	if modTime = strings.TrimSpace(modTime); modTime != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, modTime)
+		if err != nil {
+			return ItemPrecondition{}, fmt.Errorf("invalid expected_mod_time '%s': %w", modTime, err)
+		}
+		precondition.ModTime = &parsed
+	}
+	return precondition, nil
+}
+
+// IsZero reports whether no version is expected.
+func (c ItemPrecondition) IsZero() bool {
+	return c.ModTime == nil && c.ETag == ""
+}
+
+// Matches reports whether item is the expected version. La mod_time è confrontata al millisecondo, la
+// precisione delle date JavaScript; l'ETag senza virgolette e prefisso W/.
+func (c ItemPrecondition) Matches(item *ItemInfo) bool {
+	if c.ModTime != nil && !c.ModTime.Truncate(time.Millisecond).Equal(item.ModTime.Truncate(time.Millisecond)) {
+		return false
+	}
+	if c.ETag != "" && normalizeETag(c.ETag) != normalizeETag(item.ETag) {
+		return false
+	}
+	return true
+}
+
+func normalizeETag(etag string) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), `"`)
+}
+
+// CheckItemPrecondition reads path with GetItem and verifies it against precondition, returning the current
+// item. Un elemento eliminato nel frattempo restituisce ErrNotFound; expected_etag su uno storage che non
+// espone ETag (es. local) restituisce ErrNotImplemented invece di essere ignorato.
+func CheckItemPrecondition(ctx context.Context, provider StorageProvider, claims *auth.UserClaims, path string, precondition ItemPrecondition) (*ItemInfo, error) {
+	item, err := provider.GetItem(ctx, claims, path)
+	if err != nil {
+		return nil, err
+	}
+	if precondition.ETag != "" && item.ETag == "" {
+		return nil, fmt.Errorf("%w: expected_etag, storage '%s' does not report ETags", ErrNotImplemented, provider.Name())
+	}
+	if !precondition.Matches(item) {
+		return nil, fmt.Errorf("%w: '%s' was modified at %s", ErrPreconditionFailed, path, item.ModTime.UTC().Format(time.RFC3339Nano))
+	}
+	return item, nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PrefixSearch implements Search over the flat listing of an object store (Azure, GCS): ogni nome
+// sotto prefix viene confrontato con il pattern, e le directory virtuali sono ricavate sia dai marker
+// ("dir/") sia dai segmenti intermedi dei nomi dei file. Il range di date vale solo per i file: le directory
+// virtuali non hanno una data di modifica, come in ListItems.
+type PrefixSearch struct {
+	prefix     string
+	pattern    *regexp.Regexp
+	modTime    *ModTimeRange
+	maxResults int
+	seenDirs   map[string]bool // Directory già restituite: compaiono come prefisso di più oggetti
+	items      []ItemInfo
+}
+
+// NewPrefixSearch returns a PrefixSearch for the objects under prefix (ending with "/", or "" for the root).
+func NewPrefixSearch(prefix string, pattern string, modTimeRange *ModTimeRange, maxResults int) (*PrefixSearch, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+	return &PrefixSearch{prefix: prefix, pattern: re, modTime: modTimeRange, maxResults: maxResults, seenDirs: make(map[string]bool)}, nil
+}
+
+// Add matches an object of the listing. Il Path degli elementi è il nome dell'oggetto senza "/" finale,
+// come in ListItems.
+func (s *PrefixSearch) Add(name string, size int64, modTime time.Time) {
+	relative := strings.TrimPrefix(name, s.prefix)
+	start := 0
+	for i := 0; i < len(relative) && !s.Full(); i++ {
+		if relative[i] != '/' {
+			continue
+		}
+		dirPath := s.prefix + relative[:i]
+		dirName := relative[start:i]
+		start = i + 1
+		if dirName == "" || s.seenDirs[dirPath] || !s.pattern.MatchString(dirName) {
+			continue
+		}
+		s.seenDirs[dirPath] = true
+		s.items = append(s.items, ItemInfo{Name: dirName, IsDir: true, Path: dirPath})
+	}
+	fileName := relative[start:]
+	if fileName == "" || s.Full() || !s.pattern.MatchString(fileName) || !s.modTime.Contains(modTime) {
+		return
+	}
+	s.items = append(s.items, ItemInfo{Name: fileName, Size: size, ModTime: modTime, Path: name})
+}
+
+// Full reports whether maxResults items have been found: il chiamante può interrompere il listing.
+func (s *PrefixSearch) Full() bool {
+	return len(s.items) >= s.maxResults
+}
+
+// Items returns the items found so far.
+func (s *PrefixSearch) Items() []ItemInfo {
+	return s.items
+}
@@ -2,6 +2,9 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -15,11 +18,57 @@ import (
 
 // ItemInfo rappresenta le informazioni su un elemento (file o directory/blob virtuale) in uno storage.
 type ItemInfo struct {
-	Name    string      `json:"name"`
-	IsDir   bool        `json:"is_dir"`
-	Size    int64       `json:"size"`
-	ModTime time.Time   `json:"mod_time"`
-	Path    string      `json:"path"`
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Path    string    `json:"path"`
+	SHA256  string    `json:"sha256,omitempty"` // Checksum salvato, se disponibile e ancora valido
+	// IsSymlink e LinkTarget descrivono i link simbolici degli storage locali; LinkTarget è il target
+	// così come è scritto nel link. Con follow_symlinks gli altri campi sono quelli del target.
+	IsSymlink  bool   `json:"is_symlink,omitempty"`
+	LinkTarget string `json:"link_target,omitempty"`
+	// ETag è l'ETag nativo dello storage (es. quello del blob Azure), se disponibile; vuoto altrimenti.
+	ETag string `json:"etag,omitempty"`
+	// UploadedBy e UploadedAt indicano chi ha caricato il file e quando, se lo storage ha record_uploader;
+	// mancano per i file scritti prima di abilitarlo o modificati fuori da CloudDAV.
+	UploadedBy string     `json:"uploaded_by,omitempty"`
+	UploadedAt *time.Time `json:"uploaded_at,omitempty"`
+}
+
+// MarshalJSON serializes an unknown ModTime (zero, es. le directory virtuali di Azure) as null instead of
+// "0001-01-01T00:00:00Z", così i client possono nasconderla.
+func (i ItemInfo) MarshalJSON() ([]byte, error) {
+	type itemInfoFields ItemInfo
+	item := struct {
+		itemInfoFields
+		ModTime *time.Time `json:"mod_time"`
+	}{itemInfoFields: itemInfoFields(i)}
+	if !i.ModTime.IsZero() {
+		item.ModTime = &i.ModTime
+	}
+	return json.Marshal(item)
+}
+
+// AnonymousUploader is the uploader recorded for uploads made without an authenticated user.
+const AnonymousUploader = "anonymous"
+
+// UploaderName returns the uploader to record for claims (AnonymousUploader senza autenticazione).
+func UploaderName(claims *auth.UserClaims) string {
+	if claims == nil || claims.Email == "" {
+		return AnonymousUploader
+	}
+	return claims.Email
+}
+
+// NewUploadID returns a random opaque upload ID, con cui i metodi di upload dei provider e l'Hub
+// identificano una sessione indipendentemente dal path finale.
+func NewUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating upload ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
 
 // ListItemsResponse è la struttura per la risposta del metodo ListItems.
@@ -30,20 +79,114 @@ type ListItemsResponse struct {
 	ItemsPerPage int        `json:"items_per_page"`
 }
 
+// ModTimeRange filters items by modification time. After è esclusivo (elementi modificati strettamente dopo,
+// come il timestamp_filter storico di list_directory), Before è inclusivo; un limite nil non viene applicato.
+type ModTimeRange struct {
+	After  *time.Time
+	Before *time.Time
+}
+
+// Contains reports whether modTime is in the range. Un range nil contiene qualsiasi data.
+func (r *ModTimeRange) Contains(modTime time.Time) bool {
+	if r == nil {
+		return true
+	}
+	if r.After != nil && !modTime.After(*r.After) {
+		return false
+	}
+	if r.Before != nil && modTime.After(*r.Before) {
+		return false
+	}
+	return true
+}
+
+// Capabilities describes optional features of a storage provider, used by the handlers to choose
+// the most efficient strategy for an operation.
+type Capabilities struct {
+	// RandomAccess è true se OpenReaderAt legge range arbitrari senza scaricare l'intero elemento.
+	RandomAccess bool `json:"random_access"`
+	// BlockSize is the preferred read size for ranged downloads (0 = no preference, stream the item).
+	BlockSize int64 `json:"block_size"`
+	// UploadPathAtFinalize è true se i dati di un upload sono in staging indipendentemente dal path, quindi
+	// la destinazione può ancora cambiare al finalize (auto_rename risolto alla pubblicazione). Con false
+	// (blocchi Azure, sessioni resumable GCS) la destinazione è fissata all'initiate.
+	UploadPathAtFinalize bool `json:"upload_path_at_finalize"`
+}
+
+// Metodi con cui uno spostamento può essere eseguito (MoveResult.Method).
+const (
+	MoveMethodRename     = "rename"      // Rename nativo dello storage
+	MoveMethodServerCopy = "server_copy" // Copia server-side seguita dalla cancellazione della sorgente
+	MoveMethodCopy       = "copy"        // Copia dei dati (o comando esterno) seguita dalla cancellazione della sorgente
+)
+
+// MoveResult describes how a move was carried out, reported by move_item.
+type MoveResult struct {
+	Method string `json:"method"`
+	// Instant è true se lo spostamento non ha richiesto di attendere la copia dei dati: rename nativo
+	// o copie server-side completate dal servizio già nella risposta.
+	Instant bool `json:"instant"`
+	// PendingCopies è il numero di copie che il servizio ha completato in modo asincrono (attese con il polling).
+	PendingCopies int `json:"pending_copies,omitempty"`
+}
+
+// ReaderAtCloser is a random-access reader over a stored file, returned by OpenReaderAt.
+type ReaderAtCloser interface {
+	io.ReaderAt
+	io.Closer
+	// Size returns the size of the file at the time it was opened.
+	Size() int64
+}
+
 // StorageProvider definisce l'interfaccia comune per l'interazione con diversi tipi di storage.
 // I metodi di upload (InitiateUpload, WriteChunk, FinalizeUpload, CancelUpload, GetUploadedSize)
 // NON sono inclusi in questa interfaccia perché la loro implementazione dipende fortemente
-// dal tipo di storage e vengono gestiti specificamente negli handler HTTP.
+// dal tipo di storage e vengono gestiti specificamente negli handler HTTP. Identificano la sessione con un
+// upload ID opaco scelto all'initiate, distinto dal path finale che FinalizeUpload riceve alla pubblicazione.
 type StorageProvider interface {
 	Type() string
 	Name() string
 
 	// << MODIFICA: Aggiunto il parametro onlyDirectories
-	ListItems(ctx context.Context, claims *auth.UserClaims, path string, page int, itemsPerPage int, nameFilter string, timestampFilter *time.Time, onlyDirectories bool) (*ListItemsResponse, error)
+	// onlyDirectories e onlyFiles sono mutuamente esclusivi (validato da list_directory).
+	ListItems(ctx context.Context, claims *auth.UserClaims, path string, page int, itemsPerPage int, nameFilter string, modTimeRange *ModTimeRange, onlyDirectories bool, onlyFiles bool) (*ListItemsResponse, error)
+	// CountItems conta gli elementi diretti di path il cui nome corrisponde alla regex nameFilter (vuota = tutti),
+	// senza costruirne la lista. Oltre maxCount elementi si ferma e restituisce maxCount con exact false.
+	CountItems(ctx context.Context, claims *auth.UserClaims, path string, nameFilter string, maxCount int) (count int, exact bool, err error)
 	GetItem(ctx context.Context, claims *auth.UserClaims, path string) (*ItemInfo, error)
 	OpenReader(ctx context.Context, claims *auth.UserClaims, path string) (io.ReadCloser, error)
+	OpenReaderAt(ctx context.Context, claims *auth.UserClaims, path string) (ReaderAtCloser, error)
+	Capabilities() Capabilities
 	CreateDirectory(ctx context.Context, claims *auth.UserClaims, path string) error
 	DeleteItem(ctx context.Context, claims *auth.UserClaims, path string) error
+	// MoveItem sposta o rinomina un file o una directory all'interno dello stesso storage.
+	// Restituisce ErrAlreadyExists se dstPath esiste già.
+	MoveItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error
+	// CopyItem copia un file o, ricorsivamente, una directory all'interno dello stesso storage.
+	// Restituisce ErrNotFound se srcPath non esiste ed ErrAlreadyExists se dstPath esiste già.
+	CopyItem(ctx context.Context, claims *auth.UserClaims, srcPath string, dstPath string) error
+	// GetUsedBytes restituisce lo spazio occupato dall'intero storage (somma delle dimensioni dei file),
+	// usato per le quote. Può richiedere la visita di tutto lo storage: il chiamante ne mette in cache il risultato.
+	GetUsedBytes(ctx context.Context, claims *auth.UserClaims) (int64, error)
+	// GetDirectorySize restituisce la somma delle dimensioni e il numero dei file sotto path, ricorsivamente.
+	// Restituisce ErrNotFound se la directory non esiste e si interrompe con ctx.Err() se ctx viene cancellato.
+	// Non usa stato condiviso: più chiamate possono essere eseguite in parallelo.
+	GetDirectorySize(ctx context.Context, claims *auth.UserClaims, path string) (totalBytes int64, fileCount int64, err error)
+	// CanWrite verifica sul backend, senza creare file, se un upload in path sarebbe accettato dalle ACL dello
+	// storage, che i permessi configurati nell'applicazione non riflettono. Restituisce ErrNotImplemented se il
+	// backend non consente una verifica economica.
+	CanWrite(ctx context.Context, claims *auth.UserClaims, path string) (bool, error)
+	// Search cerca ricorsivamente sotto basePath i file e le directory il cui nome corrisponde alla regex
+	// pattern e la cui data di modifica è in modTimeRange (nil = qualsiasi), restituendo al massimo maxResults elementi. Si interrompe con ctx.Err() se ctx viene cancellato.
+	Search(ctx context.Context, claims *auth.UserClaims, basePath string, pattern string, modTimeRange *ModTimeRange, maxResults int) ([]ItemInfo, error)
+	// Walk visita ricorsivamente gli elementi sotto basePath chiamando fn per ciascuno, senza accumularli:
+	// le directory precedono il loro contenuto, l'ordine è altrimenti quello del backend. maxDepth > 0 limita
+	// la profondità (1 = solo gli elementi diretti). Restituisce ErrNotFound se basePath non esiste e si
+	// interrompe con ctx.Err() se ctx viene cancellato; le sottodirectory non leggibili vengono saltate.
+	Walk(ctx context.Context, claims *auth.UserClaims, basePath string, maxDepth int, fn WalkFunc) error
+	// HealthCheck verifica con un'operazione leggera (listing della radice, un elemento) che il backend sia
+	// raggiungibile, per /readyz. Non riguarda i permessi di un utente e deve rispettare la scadenza di ctx.
+	HealthCheck(ctx context.Context) error
 }
 
 // --- Registro degli Storage Provider ---
@@ -93,6 +236,26 @@ func GetAllProviders() []StorageProvider {
 	return providers
 }
 
+// ReplaceProviders replaces all registered storage providers in a single step, so concurrent
+// GetProvider calls see either the old set or the new one (es. al reload della configurazione).
+func ReplaceProviders(providers []StorageProvider) error {
+	registry := make(map[string]StorageProvider, len(providers))
+	for _, provider := range providers {
+		if _, exists := registry[provider.Name()]; exists {
+			return fmt.Errorf("storage provider with name '%s' already registered", provider.Name())
+		}
+		registry[provider.Name()] = provider
+	}
+
+	registryMutex.Lock()
+	storageRegistry = registry
+	registryMutex.Unlock()
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Storage registry replaced: %d providers registered", len(providers))
+	}
+	return nil
+}
+
 // ClearRegistry clears all registered storage providers.
 func ClearRegistry() {
 	registryMutex.Lock()
@@ -111,3 +274,20 @@ var ErrPermissionDenied = errors.New("permission denied")
 var ErrAlreadyExists = errors.New("item already exists")
 var ErrNotImplemented = errors.New("operation not implemented for this storage type")
 var ErrIntegrityCheckFailed = errors.New("file integrity check failed")
+var ErrSizeExceeded = errors.New("upload exceeds the declared file size")
+var ErrSizeMismatch = errors.New("uploaded size does not match the declared file size")
+var ErrIsDirectory = errors.New("item is a directory")
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+var ErrIsSymlink = errors.New("item is a symbolic link")
+var ErrInvalidChunk = errors.New("chunk does not match the declared upload layout")
+var ErrUploadNotFound = errors.New("upload session not found")
+var ErrChunkChecksumMismatch = errors.New("chunk checksum mismatch")
+var ErrUploadTooLarge = errors.New("upload exceeds the maximum upload size")
+
+// CheckUploadSize returns ErrUploadTooLarge if size exceeds maxUploadBytes (0 = nessun limite).
+func CheckUploadSize(maxUploadBytes int64, size int64) error {
+	if maxUploadBytes > 0 && size > maxUploadBytes {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrUploadTooLarge, size, maxUploadBytes)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModTimeRangeContains(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 1, 31, 23, 59, 59, 0, time.UTC)
+	tests := []struct {
+		name    string
+		r       *ModTimeRange
+		modTime time.Time
+		want    bool
+	}{
+		{"nil range", nil, after, true},
+		{"empty range", &ModTimeRange{}, after, true},
+		{"exactly at After is excluded", &ModTimeRange{After: &after}, after, false},
+		{"just after After", &ModTimeRange{After: &after}, after.Add(time.Nanosecond), true},
+		{"before After", &ModTimeRange{After: &after}, after.Add(-time.Second), false},
+		{"exactly at Before is included", &ModTimeRange{Before: &before}, before, true},
+		{"just after Before", &ModTimeRange{Before: &before}, before.Add(time.Nanosecond), false},
+		{"inside both bounds", &ModTimeRange{After: &after, Before: &before}, after.Add(time.Hour), true},
+		{"at After with both bounds", &ModTimeRange{After: &after, Before: &before}, after, false},
+		{"at Before with both bounds", &ModTimeRange{After: &after, Before: &before}, before, true},
+		{"equal bounds", &ModTimeRange{After: &after, Before: &after}, after, false},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Contains(tt.modTime); got != tt.want {
+			t.Errorf("%s: Contains(%s) = %t, want %t", tt.name, tt.modTime, got, tt.want)
+		}
+	}
+}
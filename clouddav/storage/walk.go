@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// WalkFunc receives an item visited by Walk with its path relative to the base path ("/" come separatore).
+// Restituire fs.SkipDir per una directory ne salta il contenuto, fs.SkipAll termina la visita senza errore;
+// ogni altro errore interrompe la visita e viene restituito da Walk.
+type WalkFunc func(item ItemInfo, relative string) error
+
+// PrefixWalk implements Walk over the flat listing of an object store (Azure, GCS), come PrefixSearch:
+// le directory virtuali sono ricavate sia dai marker ("dir/") sia dai segmenti intermedi dei nomi e vengono
+// passate a fn prima del loro contenuto. Il listing flat restituisce comunque tutti gli oggetti sotto il
+// prefisso: maxDepth limita gli elementi passati a fn, non il listing.
+type PrefixWalk struct {
+	prefix      string
+	maxDepth    int
+	fn          WalkFunc
+	seenDirs    map[string]bool // Directory già passate a fn: compaiono come prefisso di più oggetti
+	skippedDirs []string        // Prefissi ("dir/") delle directory per cui fn ha restituito fs.SkipDir
+	done        bool
+	found       bool
+}
+
+// NewPrefixWalk returns a PrefixWalk for the objects under prefix (ending with "/", or "" for the root).
+func NewPrefixWalk(prefix string, maxDepth int, fn WalkFunc) *PrefixWalk {
+	return &PrefixWalk{prefix: prefix, maxDepth: maxDepth, fn: fn, seenDirs: make(map[string]bool)}
+}
+
+// Add visits an object of the listing. Il Path degli elementi è il nome dell'oggetto senza "/" finale,
+// come in ListItems. Restituisce l'errore di fn, diverso da fs.SkipDir e fs.SkipAll.
+func (w *PrefixWalk) Add(name string, size int64, modTime time.Time) error {
+	if w.done || !strings.HasPrefix(name, w.prefix) {
+		return nil
+	}
+	w.found = true
+	relative := strings.TrimPrefix(name, w.prefix)
+	for _, skipped := range w.skippedDirs {
+		if strings.HasPrefix(relative, skipped) {
+			return nil
+		}
+	}
+	start, depth := 0, 0
+	for i := 0; i < len(relative); i++ {
+		if relative[i] != '/' {
+			continue
+		}
+		dirName := relative[start:i]
+		start = i + 1
+		if dirName == "" {
+			continue
+		}
+		depth++
+		if w.maxDepth > 0 && depth > w.maxDepth {
+			return nil
+		}
+		if w.seenDirs[relative[:i]] {
+			continue
+		}
+		w.seenDirs[relative[:i]] = true
+		err := w.fn(ItemInfo{Name: dirName, IsDir: true, Path: w.prefix + relative[:i]}, relative[:i])
+		if errors.Is(err, fs.SkipDir) {
+			w.skippedDirs = append(w.skippedDirs, relative[:i+1])
+			return nil
+		}
+		if err != nil {
+			return w.stop(err)
+		}
+	}
+	fileName := relative[start:]
+	if fileName == "" || (w.maxDepth > 0 && depth+1 > w.maxDepth) {
+		return nil
+	}
+	err := w.fn(ItemInfo{Name: fileName, Size: size, ModTime: modTime, Path: name}, relative)
+	if errors.Is(err, fs.SkipDir) {
+		return nil
+	}
+	return w.stop(err)
+}
+
+func (w *PrefixWalk) stop(err error) error {
+	if err == nil {
+		return nil
+	}
+	w.done = true
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// Done reports whether fn ended the walk: il chiamante può interrompere il listing.
+func (w *PrefixWalk) Done() bool {
+	return w.done
+}
+
+// Found reports whether at least an object exists under the prefix, cioè se la directory esiste.
+func (w *PrefixWalk) Found() bool {
+	return w.found
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"runtime"
+)
+
+// WorkerPool bounds the number of goroutines doing one kind of work across all requests.
+type WorkerPool struct {
+	slots chan struct{}
+}
+
+// NewWorkerPool creates a pool of size workers (at least 1).
+func NewWorkerPool(size int) *WorkerPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &WorkerPool{slots: make(chan struct{}, size)}
+}
+
+// Acquire waits for a free worker, or returns ctx.Err() if the context ends first.
+// Ogni Acquire riuscito va seguito da un Release.
+func (w *WorkerPool) Acquire(ctx context.Context) error {
+	select {
+	case w.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a worker obtained with Acquire.
+func (w *WorkerPool) Release() {
+	<-w.slots
+}
+
+// DeleteWorkers is the budget of goroutines shared by the recursive deletes of every provider:
+// più cancellazioni concorrenti si dividono questi worker invece di avviarne ognuna NumCPU*4.
+var DeleteWorkers = NewWorkerPool(runtime.NumCPU() * 8)
+
+// SetDeleteWorkers sets the size of DeleteWorkers (global_delete_workers). Va chiamata all'avvio, prima di
+// servire richieste: le cancellazioni in corso continuerebbero a usare il pool precedente.
+func SetDeleteWorkers(size int) {
+	DeleteWorkers = NewWorkerPool(size)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolBoundsConcurrency(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want int
+	}{
+		{"one worker", 1, 1},
+		{"three workers", 3, 3},
+		{"zero falls back to one", 0, 1},
+		{"negative falls back to one", -2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := NewWorkerPool(tt.size)
+			var running, peak int32
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := pool.Acquire(context.Background()); err != nil {
+						t.Error(err)
+						return
+					}
+					defer pool.Release()
+					n := atomic.AddInt32(&running, 1)
+					for {
+						p := atomic.LoadInt32(&peak)
+						if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+							break
+						}
+					}
+					time.Sleep(2 * time.Millisecond)
+					atomic.AddInt32(&running, -1)
+				}()
+			}
+			wg.Wait()
+			if got := int(atomic.LoadInt32(&peak)); got > tt.want {
+				t.Errorf("peak concurrency = %d, want at most %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolAcquireHonoursContext(t *testing.T) {
+	pool := NewWorkerPool(1)
+	if err := pool.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire on a full pool = %v, want %v", err, context.DeadlineExceeded)
+	}
+	pool.Release()
+	if err := pool.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire after Release: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+)
+
+// accessLossDisconnectDelay leaves time to deliver permissions_changed before a client that lost all
+// access is disconnected (l'unregister chiude subito la connessione).
+const accessLossDisconnectDelay = 2 * time.Second
+
+// ReevaluateClientAccess asks the Hub to recompute the accessible storages of every connected client
+// against the current configuration. Va chiamata dopo aver applicato una nuova configurazione (es. un
+// reload): i client il cui insieme di storage è cambiato ricevono permissions_changed e, con
+// config_reload.disconnect_on_access_loss, quelli senza più accesso vengono disconnessi.
+// Richieste ravvicinate vengono accorpate in un'unica rivalutazione.
+func (h *Hub) ReevaluateClientAccess() {
+	select {
+	case h.reevaluateAccess <- struct{}{}:
+	default:
+	}
+}
+
+// accessibleStorageNames returns the sorted names of the storages accessible to the user.
+func accessibleStorageNames(ctx context.Context, claims *auth.UserClaims, cfg *config.Config) []string {
+	storages := authz.GetAccessibleStorages(ctx, claims, cfg)
+	names := make([]string, 0, len(storages))
+	for _, storageCfg := range storages {
+		names = append(names, storageCfg.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reevaluateClientsAccess runs in the Run goroutine, which owns h.clients and Client.accessibleStorages.
+func (h *Hub) reevaluateClientsAccess() {
+	cfg := h.Config()
+	reloadCfg := cfg.ConfigReload
+	changed := 0
+	notifiedUsers := make(map[string]bool) // Una notifica per utente anche con più client connessi
+	for client := range h.clients {
+		current := accessibleStorageNames(client.ctx, client.claims, cfg)
+		added, removed := diffStorageNames(client.accessibleStorages, current)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		changed++
+		client.accessibleStorages = current
+		disconnect := reloadCfg.DisconnectOnAccessLoss && len(current) == 0
+
+		if config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Accessible storages changed for client (User: %s): added %v, removed %v, disconnect: %t", client.userIdentifier, added, removed, disconnect)
+		}
+		if !reloadCfg.NotifyClients && !disconnect {
+			continue
+		}
+		// Anche nell'inbox, così resta visibile dopo una riconnessione o nelle altre schede dell'utente.
+		if user := userKeyFromClaims(client.claims); !notifiedUsers[user] {
+			notifiedUsers[user] = true
+			h.notifyFromRun(user, Notification{
+				Type:    NotificationPermissionsChanged,
+				Message: "Your accessible storages changed",
+				Details: map[string]interface{}{"storages": current, "added": added, "removed": removed},
+			})
+		}
+
+		msg := Message{
+			Type: "permissions_changed",
+			Payload: map[string]interface{}{
+				"storages":   current,
+				"added":      added,
+				"removed":    removed,
+				"disconnect": disconnect,
+			},
+		}
+		go func(c *Client, msg Message, disconnect bool) {
+			select {
+			case c.send <- msg:
+			case <-time.After(5 * time.Second):
+				log.Printf("Timeout sending permissions_changed to client (User: %s)", c.userIdentifier)
+			case <-c.ctx.Done():
+				return
+			}
+			if !disconnect {
+				return
+			}
+			select {
+			case <-time.After(accessLossDisconnectDelay):
+			case <-c.ctx.Done():
+				return
+			}
+			select {
+			case h.unregister <- c:
+			case <-h.ctx.Done():
+			}
+		}(client, msg, disconnect)
+	}
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Client access re-evaluated: %d of %d clients affected", changed, len(h.clients))
+	}
+}
+
+// diffStorageNames compares two sorted lists of storage names.
+func diffStorageNames(previous []string, current []string) (added []string, removed []string) {
+	previousSet := make(map[string]bool, len(previous))
+	for _, name := range previous {
+		previousSet[name] = true
+	}
+	currentSet := make(map[string]bool, len(current))
+	for _, name := range current {
+		currentSet[name] = true
+		if !previousSet[name] {
+			added = append(added, name)
+		}
+	}
+	for _, name := range previous {
+		if !currentSet[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,164 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// countItemsCacheTTL is how long the item count of a directory is reused by count_items.
+	countItemsCacheTTL = 10 * time.Second
+	// countItemsDefaultMax and countItemsMaxLimit bound max_count: oltre il limite il conteggio si ferma
+	// e la risposta ha exact false.
+	countItemsDefaultMax = 100000
+	countItemsMaxLimit   = 1000000
+)
+
+// countItemsResult is the count of a directory cached by count_items.
+type countItemsResult struct {
+	count int
+	exact bool
+}
+
+// countItemsCache caches the item counts of count_items. Come per directoryStatsCache il conteggio non
+// dipende dall'utente: l'accesso viene verificato prima di leggere la cache.
+type countItemsCache struct {
+	mu      sync.Mutex
+	entries map[string]countItemsCacheEntry
+}
+
+type countItemsCacheEntry struct {
+	result   countItemsResult
+	cachedAt time.Time
+}
+
+func newCountItemsCache() *countItemsCache {
+	return &countItemsCache{entries: make(map[string]countItemsCacheEntry)}
+}
+
+func countItemsCacheKey(storageName string, dirPath string, nameFilter string, maxCount int) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%d", storageName, dirPath, nameFilter, maxCount)
+}
+
+func (c *countItemsCache) get(key string) (countItemsResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.cachedAt) > countItemsCacheTTL {
+		return countItemsResult{}, false
+	}
+	return entry.result, true
+}
+
+// put stores the count of key, rimuovendo le voci scadute come directoryStatsCache.put.
+func (c *countItemsCache) put(key string, result countItemsResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for cachedKey, entry := range c.entries {
+		if now.Sub(entry.cachedAt) > countItemsCacheTTL {
+			delete(c.entries, cachedKey)
+		}
+	}
+	c.entries[key] = countItemsCacheEntry{result: result, cachedAt: now}
+}
+
+// countItems handles count_items: the number of items directly under dir_path matching filter, per le UI
+// che mostrano "N elementi" senza caricare tutte le pagine di list_directory. Il filtro ha la stessa forma
+// e precedenza di list_directory (filter, oppure la regexp legacy name_filter).
+func (h *Hub) countItems(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "count_items_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string      `json:"storage_name"`
+		DirPath     string      `json:"dir_path"`
+		NameFilter  string      `json:"name_filter"` // Legacy: ignorato se è presente Filter
+		Filter      *NameFilter `json:"filter,omitempty"`
+		MaxCount    int         `json:"max_count,omitempty"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for count_items: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid count_items payload: %w", err)
+	}
+	if payload.DirPath == "" {
+		payload.DirPath = "/"
+	}
+	if payload.MaxCount == 0 {
+		payload.MaxCount = countItemsDefaultMax
+	}
+	if payload.MaxCount < 1 || payload.MaxCount > countItemsMaxLimit {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid max_count: must be between 1 and %d", countItemsMaxLimit)}
+		return response, nil
+	}
+	nameFilter, err := resolveNameFilter(payload.Filter, payload.NameFilter)
+	if err != nil {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": err.Error()}
+		return response, nil
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for count_items: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	cacheKey := countItemsCacheKey(payload.StorageName, payload.DirPath, nameFilter, payload.MaxCount)
+	result, cached := h.countItemsCache.get(cacheKey)
+	if !cached {
+		start := time.Now()
+		result.count, result.exact, err = provider.CountItems(ctx, claims, payload.DirPath, nameFilter, payload.MaxCount)
+		if err != nil {
+			h.RecordError(claims, "count_items", payload.StorageName, payload.DirPath, err)
+			if errors.Is(err, storage.ErrNotFound) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Directory not found"}
+				return response, nil
+			}
+			if errors.Is(err, storage.ErrIsSymlink) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Cannot count the items of a symbolic link: follow_symlinks is disabled", "error_code": "IS_A_SYMLINK"}
+				return response, nil
+			}
+			if ctx.Err() != nil {
+				return response, ctx.Err()
+			}
+			return response, fmt.Errorf("error counting items of '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.DirPath, userIdentifier, msg.RequestID, err)
+		}
+		h.countItemsCache.put(cacheKey, result)
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("count_items_response (User: %s, ReqID: %s): %d items (exact %t) in %s/%s in %v", userIdentifier, msg.RequestID, result.count, result.exact, payload.StorageName, payload.DirPath, time.Since(start))
+		}
+	}
+
+	response.Payload = map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"dir_path":     payload.DirPath,
+		"count":        result.count,
+		"exact":        result.exact,
+		"cached":       cached,
+	}
+	return response, nil
+}
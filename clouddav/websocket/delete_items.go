@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"clouddav/auth"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// maxDeleteItemsRequest limits the number of paths accepted by a single delete_items message.
+	maxDeleteItemsRequest = 200
+	// deleteItemsWorkers is the number of concurrent DeleteItem calls of a delete_items message. Non si usa
+	// storage.DeleteWorkers: la cancellazione ricorsiva di una directory ne occupa già gli slot, e un batch
+	// che li trattenesse potrebbe bloccarla.
+	deleteItemsWorkers = 8
+)
+
+// deleteItemResult is the outcome of the deletion of one path, returned in the same position as the request.
+type deleteItemResult struct {
+	ItemPath string `json:"item_path"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// deleteItems deletes the paths of a storage with a bounded pool of workers, checking write access per
+// path. Un errore su un path non interrompe gli altri.
+func (h *Hub) deleteItems(ctx context.Context, claims *auth.UserClaims, storageName string, itemPaths []string) []deleteItemResult {
+	results := make([]deleteItemResult, len(itemPaths))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := deleteItemsWorkers
+	if len(itemPaths) < workers {
+		workers = len(itemPaths)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = h.deleteItem(ctx, claims, storageName, itemPaths[i])
+			}
+		}()
+	}
+	for i := range itemPaths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+func (h *Hub) deleteItem(ctx context.Context, claims *auth.UserClaims, storageName string, itemPath string) deleteItemResult {
+	result := deleteItemResult{ItemPath: itemPath}
+	err := h.deleteItemChecked(ctx, claims, storageName, itemPath)
+	if err == nil {
+		result.Status = itemStatusOK
+		return result
+	}
+
+	h.RecordError(claims, "delete_items", storageName, itemPath, err)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		result.Status = itemStatusNotFound
+		result.Error = "Item not found"
+	case errors.Is(err, storage.ErrPermissionDenied):
+		result.Status = itemStatusDenied
+		result.Error = "Access denied: write permission required"
+	case errors.Is(err, storage.ErrNotImplemented):
+		result.Status = itemStatusError
+		result.Error = "Delete not supported for this storage type"
+	default:
+		log.Printf("Error deleting item '%s/%s' for delete_items: %v", storageName, itemPath, err)
+		result.Status = itemStatusError
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// deleteItemChecked checks write access to itemPath and to everything under it, and deletes it.
+func (h *Hub) deleteItemChecked(ctx context.Context, claims *auth.UserClaims, storageName string, itemPath string) error {
+	if err := authz.CheckRecursiveWriteAccess(ctx, claims, storageName, itemPath, h.Config()); err != nil {
+		return err
+	}
+	provider, ok := storage.GetProvider(storageName)
+	if !ok {
+		return errors.New("storage provider not found")
+	}
+	return provider.DeleteItem(ctx, claims, itemPath)
+}
@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+// directoryChangesBuffer is the capacity of Hub.directoryChanges: oltre, le notifiche vengono scartate
+// invece di rallentare le richieste che modificano gli storage (i client si riallineano con list_directory).
+const directoryChangesBuffer = 1024
+
+// directoryChangedEvent is a change recorded in the change log of a storage, to fan out to the clients.
+type directoryChangedEvent struct {
+	storageName string
+	change      storage.Change
+}
+
+// NotifyDirectoryChanged queues a recorded change for the clients whose active directory (set_active_directory)
+// contains it. È lo storage.ChangeListener registrato da main: non blocca la richiesta che ha fatto la modifica.
+func (h *Hub) NotifyDirectoryChanged(storageName string, change storage.Change) {
+	select {
+	case h.directoryChanges <- directoryChangedEvent{storageName: storageName, change: change}:
+	default:
+		if config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Dropping directory_changed for '%s/%s': notification queue full", storageName, change.Path)
+		}
+	}
+}
+
+// deliverDirectoryChanged sends directory_changed to the clients viewing the parent directory of the changed
+// item, or a directory inside an item deleted or replaced (es. la directory corrente spostata altrove).
+// Runs in the Run goroutine, which owns h.clients; anche il client che ha fatto la modifica riceve il messaggio.
+func (h *Hub) deliverDirectoryChanged(event directoryChangedEvent) {
+	parentDir := path.Dir(event.change.Path)
+	for client := range h.clients {
+		if client.activeStorage != event.storageName || !hasStorageName(client.accessibleStorages, event.storageName) {
+			continue
+		}
+		viewingParent := client.activeDirectory == parentDir
+		viewingInside := event.change.Op != storage.ChangeCreated && (client.activeDirectory == event.change.Path || strings.HasPrefix(client.activeDirectory, event.change.Path+"/"))
+		if !viewingParent && !viewingInside {
+			continue
+		}
+		msg := Message{
+			Type: "directory_changed",
+			Payload: map[string]interface{}{
+				"storage_name": event.storageName,
+				"path":         client.activeDirectory,
+				"item_path":    event.change.Path,
+				"op":           event.change.Op,
+				"seq":          event.change.Seq,
+			},
+		}
+		select {
+		case client.send <- msg:
+		default:
+			if config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("[DEBUG] Send buffer full, directory_changed for '%s/%s' not sent to client (User: %s)", event.storageName, event.change.Path, client.userIdentifier)
+			}
+		}
+	}
+}
+
+// hasStorageName reports whether the sorted names contain name.
+func hasStorageName(names []string, name string) bool {
+	i := sort.SearchStrings(names, name)
+	return i < len(names) && names[i] == name
+}
+
+// setActiveDirectory handles set_active_directory: the directory shown by a WebSocket client, for which it
+// receives directory_changed. Uno storage_name vuoto azzera la directory attiva. La risposta contiene il seq
+// corrente del change log, da usare come since_seq di list_directory per riallinearsi.
+func (h *Hub) setActiveDirectory(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: msg.Type + "_response", RequestID: msg.RequestID}
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		Path        string `json:"path"`
+	}
+	if msg.Payload != nil {
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for set_active_directory: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid set_active_directory payload: %w", err)
+		}
+	}
+	dirPath := payload.Path
+	if payload.StorageName != "" {
+		dirPath = path.Clean("/" + dirPath) // Stessa forma dei path del change log
+		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, dirPath, "read", h.Config()); err != nil {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": fmt.Sprintf("Access denied: %v", err)}
+			return response, nil
+		}
+	} else {
+		dirPath = ""
+	}
+
+	sessionID, _ := ctx.Value(sessionIDKey{}).(string)
+	found := false
+	err := h.queryClients(ctx, func(clients map[*Client]bool) {
+		for client := range clients {
+			if sessionID != "" && client.sessionID == sessionID {
+				client.activeStorage = payload.StorageName
+				client.activeDirectory = dirPath
+				found = true
+				return
+			}
+		}
+	})
+	if err != nil {
+		return response, err
+	}
+	if !found {
+		// I client Long Polling non hanno una connessione su cui ricevere directory_changed.
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "set_active_directory requires a WebSocket connection", "error_code": "WEBSOCKET_REQUIRED"}
+		return response, nil
+	}
+
+	result := map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"path":         dirPath,
+	}
+	if payload.StorageName != "" {
+		if changeLog, exists := storage.GetChangeLog(payload.StorageName); exists {
+			result["seq"] = changeLog.CurrentSeq()
+		}
+	}
+	response.Payload = result
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("set_active_directory (User: %s, Session: %s, ReqID: %s): '%s/%s'", userIdentifier, sessionID, msg.RequestID, payload.StorageName, dirPath)
+	}
+	return response, nil
+}
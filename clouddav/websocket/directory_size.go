@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+// getDirectorySize handles get_directory_size: returns the total size and the number of files under dir_path,
+// ricorsivamente. Su alberi grandi può richiedere la visita di molti elementi: la cancellazione del contesto
+// (timeout del messaggio o disconnessione del client) interrompe la visita.
+func (h *Hub) getDirectorySize(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "get_directory_size_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		DirPath     string `json:"dir_path"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for get_directory_size: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid get_directory_size payload: %w", err)
+	}
+	if payload.DirPath == "" {
+		payload.DirPath = "/"
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for get_directory_size: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	start := time.Now()
+	totalBytes, fileCount, err := provider.GetDirectorySize(ctx, claims, payload.DirPath)
+	if err != nil {
+		h.RecordError(claims, "get_directory_size", payload.StorageName, payload.DirPath, err)
+		if errors.Is(err, storage.ErrNotFound) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Directory not found"}
+			return response, nil
+		}
+		if errors.Is(err, storage.ErrIsSymlink) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Cannot compute the size of a symbolic link: follow_symlinks is disabled", "error_code": "IS_A_SYMLINK"}
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return response, ctx.Err()
+		}
+		return response, fmt.Errorf("error computing size of '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.DirPath, userIdentifier, msg.RequestID, err)
+	}
+
+	response.Payload = map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"dir_path":     payload.DirPath,
+		"total_bytes":  totalBytes,
+		"file_count":   fileCount,
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("get_directory_size_response (User: %s, ReqID: %s): %d files, %d bytes under %s/%s in %v", userIdentifier, msg.RequestID, fileCount, totalBytes, payload.StorageName, payload.DirPath, time.Since(start))
+	}
+	return response, nil
+}
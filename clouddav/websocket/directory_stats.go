@@ -0,0 +1,267 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// directoryStatsCacheTTL is how long the statistics of a directory are reused by directory_stats.
+	directoryStatsCacheTTL = 30 * time.Second
+	// directoryStatsPageSize is the page size of the listings done to compute the statistics.
+	directoryStatsPageSize = 1000
+	// directoryStatsDefaultMaxItems and directoryStatsMaxItems bound the items visited (max_items).
+	directoryStatsDefaultMaxItems = 10000
+	directoryStatsMaxItems        = 100000
+	// directoryStatsDefaultTop and directoryStatsMaxTop bound the types returned (top); gli altri
+	// confluiscono nel gruppo "other".
+	directoryStatsDefaultTop = 10
+	directoryStatsMaxTop     = 50
+)
+
+// directoryTypeStats is the count and total size of the files of a type (estensione in minuscolo,
+// "" per i file senza estensione).
+type directoryTypeStats struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// directoryStats is the result of a visit, before the top N types are selected.
+type directoryStats struct {
+	totalFiles  int64
+	totalBytes  int64
+	directories int64
+	truncated   bool // Visita interrotta al raggiungimento di max_items
+	types       []directoryTypeStats
+}
+
+// directoryStatsCache caches the statistics of the directories visited by directory_stats. Come per
+// rootCountsCache le statistiche non dipendono dall'utente: l'accesso viene verificato prima di leggerla.
+type directoryStatsCache struct {
+	mu      sync.Mutex
+	entries map[string]directoryStatsCacheEntry
+}
+
+type directoryStatsCacheEntry struct {
+	stats    *directoryStats
+	cachedAt time.Time
+}
+
+func newDirectoryStatsCache() *directoryStatsCache {
+	return &directoryStatsCache{entries: make(map[string]directoryStatsCacheEntry)}
+}
+
+func directoryStatsCacheKey(storageName string, dirPath string, recursive bool, maxItems int) string {
+	return fmt.Sprintf("%s\x00%s\x00%t\x00%d", storageName, dirPath, recursive, maxItems)
+}
+
+func (c *directoryStatsCache) get(key string) (*directoryStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.cachedAt) > directoryStatsCacheTTL {
+		return nil, false
+	}
+	return entry.stats, true
+}
+
+// put stores the statistics of key, rimuovendo le voci scadute perché le chiavi (una per directory) non
+// sono limitate.
+func (c *directoryStatsCache) put(key string, stats *directoryStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for cachedKey, entry := range c.entries {
+		if now.Sub(entry.cachedAt) > directoryStatsCacheTTL {
+			delete(c.entries, cachedKey)
+		}
+	}
+	c.entries[key] = directoryStatsCacheEntry{stats: stats, cachedAt: now}
+}
+
+// directoryStats handles directory_stats: counts and total size of the files of dir_path grouped by
+// extension, per il grafico "cosa occupa spazio". Con recursive vengono visitate anche le sottodirectory,
+// fino a max_items elementi; vengono restituiti i top tipi per dimensione e il resto in "other".
+func (h *Hub) directoryStats(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "directory_stats_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		DirPath     string `json:"dir_path"`
+		Recursive   bool   `json:"recursive,omitempty"`
+		MaxItems    int    `json:"max_items,omitempty"`
+		Top         int    `json:"top,omitempty"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for directory_stats: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid directory_stats payload: %w", err)
+	}
+	if payload.DirPath == "" {
+		payload.DirPath = "/"
+	}
+	if payload.MaxItems == 0 {
+		payload.MaxItems = directoryStatsDefaultMaxItems
+	}
+	if payload.MaxItems < 0 || payload.MaxItems > directoryStatsMaxItems {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid max_items: must be between 1 and %d", directoryStatsMaxItems)}
+		return response, nil
+	}
+	if payload.Top == 0 {
+		payload.Top = directoryStatsDefaultTop
+	}
+	if payload.Top < 0 || payload.Top > directoryStatsMaxTop {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid top: must be between 1 and %d", directoryStatsMaxTop)}
+		return response, nil
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for directory_stats: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	cacheKey := directoryStatsCacheKey(payload.StorageName, payload.DirPath, payload.Recursive, payload.MaxItems)
+	stats, cached := h.directoryStatsCache.get(cacheKey)
+	if !cached {
+		stats, err = collectDirectoryStats(ctx, provider, claims, payload.DirPath, payload.Recursive, payload.MaxItems)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Directory not found"}
+				return response, nil
+			}
+			if ctx.Err() != nil {
+				return response, ctx.Err()
+			}
+			return response, fmt.Errorf("error computing statistics of '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.DirPath, userIdentifier, msg.RequestID, err)
+		}
+		h.directoryStatsCache.put(cacheKey, stats)
+	}
+
+	types := stats.types
+	var other *directoryTypeStats
+	if len(types) > payload.Top {
+		other = &directoryTypeStats{Type: "other"}
+		for _, typeStats := range types[payload.Top:] {
+			other.Count += typeStats.Count
+			other.Bytes += typeStats.Bytes
+		}
+		types = types[:payload.Top]
+	}
+
+	response.Payload = map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"dir_path":     payload.DirPath,
+		"recursive":    payload.Recursive,
+		"total_files":  stats.totalFiles,
+		"total_bytes":  stats.totalBytes,
+		"directories":  stats.directories,
+		"types":        types,
+		"other":        other,
+		"truncated":    stats.truncated,
+		"cached":       cached,
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("directory_stats_response (User: %s, ReqID: %s): %d files, %d bytes, %d types under %s/%s (recursive %t, truncated %t, cached %t)", userIdentifier, msg.RequestID, stats.totalFiles, stats.totalBytes, len(stats.types), payload.StorageName, payload.DirPath, payload.Recursive, stats.truncated, cached)
+	}
+	return response, nil
+}
+
+// collectDirectoryStats visits dirPath (and with recursive its subdirectories) with ListItems, fermandosi
+// dopo maxItems elementi. I tipi sono ordinati per dimensione decrescente.
+func collectDirectoryStats(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, dirPath string, recursive bool, maxItems int) (*directoryStats, error) {
+	stats := &directoryStats{}
+	byType := make(map[string]*directoryTypeStats)
+	visited := 0
+	pending := []string{dirPath}
+	for len(pending) > 0 && !stats.truncated {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for page := 1; ; page++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			listResponse, err := provider.ListItems(ctx, claims, current, page, directoryStatsPageSize, "", nil, false, false)
+			if err != nil {
+				if current == dirPath {
+					return nil, err
+				}
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				// Sottodirectory rimossa o non leggibile durante la visita: si prosegue con le altre.
+				log.Printf("Warning: directory_stats: skipping '%s': %v", current, err)
+				break
+			}
+			for _, item := range listResponse.Items {
+				if visited >= maxItems {
+					stats.truncated = true
+					break
+				}
+				visited++
+				if item.IsDir {
+					stats.directories++
+					if recursive {
+						pending = append(pending, item.Path)
+					}
+					continue
+				}
+				itemType := strings.ToLower(path.Ext(item.Name))
+				typeStats, ok := byType[itemType]
+				if !ok {
+					typeStats = &directoryTypeStats{Type: itemType}
+					byType[itemType] = typeStats
+				}
+				typeStats.Count++
+				typeStats.Bytes += item.Size
+				stats.totalFiles++
+				stats.totalBytes += item.Size
+			}
+			if stats.truncated || len(listResponse.Items) < directoryStatsPageSize {
+				break
+			}
+		}
+	}
+
+	stats.types = make([]directoryTypeStats, 0, len(byType))
+	for _, typeStats := range byType {
+		stats.types = append(stats.types, *typeStats)
+	}
+	sort.Slice(stats.types, func(i, j int) bool {
+		if stats.types[i].Bytes != stats.types[j].Bytes {
+			return stats.types[i].Bytes > stats.types[j].Bytes
+		}
+		if stats.types[i].Count != stats.types[j].Count {
+			return stats.types[i].Count > stats.types[j].Count
+		}
+		return stats.types[i].Type < stats.types[j].Type
+	})
+	return stats, nil
+}
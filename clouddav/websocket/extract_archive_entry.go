@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+// extractArchiveEntryMaxInlineBytes is the maximum decompressed size of an entry returned in base64 by
+// extract_archive_entry; le voci più grandi si scaricano da /archive-entry.
+const extractArchiveEntryMaxInlineBytes = 4 * 1024 * 1024
+
+// ErrArchiveEntryNotFound is returned by OpenArchiveEntry when the archive has no entry with that name.
+var ErrArchiveEntryNotFound = fmt.Errorf("archive entry %w", storage.ErrNotFound)
+
+// errArchiveEntryTooLarge is returned for the entries that extract_archive_entry cannot return inline.
+var errArchiveEntryTooLarge = errors.New("archive entry too large")
+
+// archiveEntryReader is the decompressed content of an entry: Close chiude anche l'archivio.
+type archiveEntryReader struct {
+	io.ReadCloser
+	archive io.Closer
+}
+
+// Read reports the CRC mismatch detected by archive/zip at the end of the entry as ErrInvalidArchive.
+func (r *archiveEntryReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = zipOpenError(err)
+	}
+	return n, err
+}
+
+func (r *archiveEntryReader) Close() error {
+	err := r.ReadCloser.Close()
+	if archiveErr := r.archive.Close(); err == nil {
+		err = archiveErr
+	}
+	return err
+}
+
+// OpenArchiveEntry opens the entry entryName of the ZIP at archivePath and returns its decompressed
+// content. La voce viene individuata dal central directory: sugli storage ad accesso casuale vengono letti
+// solo il central directory e i byte compressi della voce, non l'intero archivio. Solo i ZIP sono
+// supportati, perché un tar andrebbe letto in sequenza fino alla voce.
+func OpenArchiveEntry(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, archivePath string, entryName string) (io.ReadCloser, *zip.FileHeader, error) {
+	if archiveFormatOf(archivePath) != archiveFormatZip {
+		return nil, nil, fmt.Errorf("%w: single entries can only be extracted from .zip archives", ErrUnsupportedArchive)
+	}
+	zipReader, closer, err := openZipArchive(ctx, provider, claims, archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := strings.TrimPrefix(entryName, "/")
+	for _, file := range zipReader.File {
+		if file.Name != name {
+			continue
+		}
+		if file.FileInfo().IsDir() {
+			closer.Close()
+			return nil, nil, fmt.Errorf("%w: '%s' is a directory of the archive", storage.ErrIsDirectory, name)
+		}
+		entry, err := file.Open()
+		if err != nil {
+			closer.Close()
+			return nil, nil, zipOpenError(err)
+		}
+		return &archiveEntryReader{ReadCloser: entry, archive: closer}, &file.FileHeader, nil
+	}
+	closer.Close()
+	return nil, nil, fmt.Errorf("%w: '%s' is not in '%s'", ErrArchiveEntryNotFound, name, archivePath)
+}
+
+// extractArchiveEntry handles extract_archive_entry: the decompressed content of a single entry of a
+// stored ZIP, in base64. Le voci più grandi di extractArchiveEntryMaxInlineBytes ricevono ENTRY_TOO_LARGE
+// e vanno scaricate da /archive-entry, che le invia in streaming.
+func (h *Hub) extractArchiveEntry(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "extract_archive_entry_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		ArchivePath string `json:"archive_path"`
+		EntryName   string `json:"entry_name"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for extract_archive_entry: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid extract_archive_entry payload: %w", err)
+	}
+	if payload.EntryName == "" {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "entry_name is required"}
+		return response, nil
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ArchivePath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for extract_archive_entry: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	var content []byte
+	entry, header, err := OpenArchiveEntry(ctx, provider, claims, payload.ArchivePath, payload.EntryName)
+	if err == nil {
+		content, err = readArchiveEntryInline(entry, header)
+		entry.Close()
+	}
+	if err != nil {
+		var errorPayload map[string]string
+		switch {
+		case errors.Is(err, ErrArchiveEntryNotFound):
+			errorPayload = map[string]string{"error": fmt.Sprintf("Entry '%s' not found in the archive", payload.EntryName), "error_code": "NOT_FOUND"}
+		case errors.Is(err, storage.ErrNotFound):
+			errorPayload = map[string]string{"error": "Item not found", "error_code": "NOT_FOUND"}
+		case errors.Is(err, storage.ErrPermissionDenied):
+			errorPayload = map[string]string{"error": "Access denied: read permission required"}
+		case errors.Is(err, storage.ErrIsDirectory):
+			errorPayload = map[string]string{"error": "Cannot read a directory", "error_code": "IS_A_DIRECTORY"}
+		case errors.Is(err, storage.ErrIsSymlink):
+			errorPayload = map[string]string{"error": "Cannot read a symbolic link: follow_symlinks is disabled", "error_code": "IS_A_SYMLINK"}
+		case errors.Is(err, ErrUnsupportedArchive):
+			errorPayload = map[string]string{"error": err.Error(), "error_code": "UNSUPPORTED_ARCHIVE"}
+		case errors.Is(err, ErrInvalidArchive):
+			errorPayload = map[string]string{"error": err.Error(), "error_code": "INVALID_ARCHIVE"}
+		case errors.Is(err, errArchiveEntryTooLarge):
+			errorPayload = map[string]string{"error": err.Error() + ", download it from /archive-entry", "error_code": "ENTRY_TOO_LARGE"}
+		case errors.Is(err, ErrArchiveTooLarge):
+			errorPayload = map[string]string{"error": err.Error(), "error_code": "ARCHIVE_TOO_LARGE"}
+		default:
+			if ctx.Err() != nil {
+				return response, ctx.Err()
+			}
+			return response, fmt.Errorf("error extracting '%s' from archive '%s/%s' (User: %s, ReqID: %s): %w", payload.EntryName, payload.StorageName, payload.ArchivePath, userIdentifier, msg.RequestID, err)
+		}
+		response.Type = "error"
+		response.Payload = errorPayload
+		return response, nil
+	}
+
+	response.Payload = map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"archive_path": payload.ArchivePath,
+		"entry_name":   header.Name,
+		"size":         len(content),
+		"mod_time":     header.Modified,
+		"data":         base64.StdEncoding.EncodeToString(content),
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("extract_archive_entry_response (User: %s, ReqID: %s): %d bytes of '%s' from %s/%s", userIdentifier, msg.RequestID, len(content), header.Name, payload.StorageName, payload.ArchivePath)
+	}
+	return response, nil
+}
+
+// readArchiveEntryInline reads the whole entry, up to extractArchiveEntryMaxInlineBytes. La dimensione del
+// central directory potrebbe non essere quella reale, quindi anche la lettura è limitata.
+func readArchiveEntryInline(entry io.Reader, header *zip.FileHeader) ([]byte, error) {
+	if header.UncompressedSize64 > extractArchiveEntryMaxInlineBytes {
+		return nil, fmt.Errorf("%w: '%s' is %d bytes, the limit is %d", errArchiveEntryTooLarge, header.Name, header.UncompressedSize64, extractArchiveEntryMaxInlineBytes)
+	}
+	content, err := io.ReadAll(io.LimitReader(entry, extractArchiveEntryMaxInlineBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > extractArchiveEntryMaxInlineBytes {
+		return nil, fmt.Errorf("%w: '%s' is larger than its declared size", ErrInvalidArchive, header.Name)
+	}
+	return content, nil
+}
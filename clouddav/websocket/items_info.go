@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"clouddav/auth"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// maxItemsInfoRequest limits the number of paths accepted by a single get_items_info message.
+	maxItemsInfoRequest = 200
+	// itemsInfoWorkers is the number of concurrent GetItem calls of a get_items_info message.
+	itemsInfoWorkers = 8
+)
+
+// Status of a single entry in a get_items_info response.
+const (
+	itemStatusOK       = "ok"
+	itemStatusNotFound = "not_found"
+	itemStatusDenied   = "denied"
+	itemStatusError    = "error"
+)
+
+// itemInfoRequest identifies one item requested by get_items_info.
+type itemInfoRequest struct {
+	StorageName string `json:"storage_name"`
+	ItemPath    string `json:"item_path"`
+}
+
+// itemInfoResult is the outcome for one requested item, returned in the same position as the request.
+type itemInfoResult struct {
+	StorageName string            `json:"storage_name"`
+	ItemPath    string            `json:"item_path"`
+	Status      string            `json:"status"`
+	Item        *storage.ItemInfo `json:"item,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// getItemsInfo resolves the requested items with a bounded pool of workers, checking read access per item.
+func (h *Hub) getItemsInfo(ctx context.Context, claims *auth.UserClaims, requests []itemInfoRequest) []itemInfoResult {
+	results := make([]itemInfoResult, len(requests))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := itemsInfoWorkers
+	if len(requests) < workers {
+		workers = len(requests)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = h.getItemInfo(ctx, claims, requests[i])
+			}
+		}()
+	}
+	for i := range requests {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+func (h *Hub) getItemInfo(ctx context.Context, claims *auth.UserClaims, req itemInfoRequest) itemInfoResult {
+	result := itemInfoResult{StorageName: req.StorageName, ItemPath: req.ItemPath}
+
+	if err := authz.CheckStorageAccess(ctx, claims, req.StorageName, req.ItemPath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			result.Status = itemStatusDenied
+			result.Error = "Access denied: read permission required"
+		} else {
+			result.Status = itemStatusError
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	provider, ok := storage.GetProvider(req.StorageName)
+	if !ok {
+		result.Status = itemStatusError
+		result.Error = "storage provider not found"
+		return result
+	}
+
+	itemInfo, err := provider.GetItem(ctx, claims, req.ItemPath)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			result.Status = itemStatusNotFound
+			result.Error = "Item not found"
+		case errors.Is(err, storage.ErrPermissionDenied):
+			result.Status = itemStatusDenied
+			result.Error = "Access denied: read permission required"
+		default:
+			log.Printf("Error getting item '%s/%s' for get_items_info: %v", req.StorageName, req.ItemPath, err)
+			result.Status = itemStatusError
+			result.Error = err.Error()
+		}
+		return result
+	}
+	result.Status = itemStatusOK
+	result.Item = itemInfo
+	return result
+}
@@ -0,0 +1,323 @@
+package websocket
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// listArchiveMaxEntries is the maximum number of entries returned by list_archive.
+	listArchiveMaxEntries = 10000
+	// listArchiveMaxTarBytes is the maximum number of bytes of a tar read to list its entries: un tar non
+	// ha un indice, quindi va letto per intero; oltre il limite l'elenco è troncato.
+	listArchiveMaxTarBytes = 512 * 1024 * 1024
+	// listArchiveMaxBufferedZipBytes is the maximum size of a ZIP read into memory on storages without
+	// random access, dove il central directory non può essere letto da solo.
+	listArchiveMaxBufferedZipBytes = 64 * 1024 * 1024
+	// listArchiveReadBlockSize is the size of the ranged reads of a ZIP when the storage has no preference.
+	listArchiveReadBlockSize = 64 * 1024
+)
+
+// Formati di archivio supportati da list_archive, riconosciuti dall'estensione.
+const (
+	archiveFormatZip   = "zip"
+	archiveFormatTar   = "tar"
+	archiveFormatTarGz = "tar.gz"
+)
+
+// Errori della lettura degli archivi, esportati per il download di una singola voce (/archive-entry).
+var (
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	ErrInvalidArchive     = errors.New("invalid or corrupted archive")
+	ErrArchiveTooLarge    = errors.New("archive too large")
+)
+
+// archiveEntry is an entry of an archive as returned by list_archive.
+type archiveEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	IsDir   bool      `json:"is_dir"`
+}
+
+// archiveFormatOf returns the archive format of itemPath from its extension, or "" if it is not supported.
+func archiveFormatOf(itemPath string) string {
+	name := strings.ToLower(itemPath)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveFormatZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveFormatTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveFormatTarGz
+	}
+	return ""
+}
+
+// listArchive handles list_archive: the entries of a ZIP or tar (anche .tar.gz/.tgz) senza estrarli. Dei
+// ZIP viene letto solo il central directory, individuato dal record end-of-central-directory in fondo al
+// file; i tar vengono letti in sequenza saltando il contenuto, fino a listArchiveMaxTarBytes. Vengono
+// restituite al massimo listArchiveMaxEntries voci: truncated indica che l'archivio ne contiene altre.
+func (h *Hub) listArchive(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "list_archive_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		ItemPath    string `json:"item_path"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for list_archive: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid list_archive payload: %w", err)
+	}
+
+	format := archiveFormatOf(payload.ItemPath)
+	if format == "" {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "Unsupported archive format: expected .zip, .tar, .tar.gz or .tgz", "error_code": "UNSUPPORTED_ARCHIVE"}
+		return response, nil
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ItemPath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for list_archive: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	var entries []archiveEntry
+	var truncated bool
+	totalEntries := -1 // Noto solo per i ZIP, dal central directory
+	if format == archiveFormatZip {
+		entries, totalEntries, truncated, err = listZipEntries(ctx, provider, claims, payload.ItemPath)
+	} else {
+		entries, truncated, err = listTarEntries(ctx, provider, claims, payload.ItemPath, format == archiveFormatTarGz)
+	}
+	if err != nil {
+		var errorPayload map[string]string
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			errorPayload = map[string]string{"error": "Item not found"}
+		case errors.Is(err, storage.ErrPermissionDenied):
+			errorPayload = map[string]string{"error": "Access denied: read permission required"}
+		case errors.Is(err, storage.ErrIsDirectory):
+			errorPayload = map[string]string{"error": "Cannot read a directory", "error_code": "IS_A_DIRECTORY"}
+		case errors.Is(err, storage.ErrIsSymlink):
+			errorPayload = map[string]string{"error": "Cannot read a symbolic link: follow_symlinks is disabled", "error_code": "IS_A_SYMLINK"}
+		case errors.Is(err, ErrInvalidArchive):
+			errorPayload = map[string]string{"error": err.Error(), "error_code": "INVALID_ARCHIVE"}
+		case errors.Is(err, ErrArchiveTooLarge):
+			errorPayload = map[string]string{"error": err.Error(), "error_code": "ARCHIVE_TOO_LARGE"}
+		default:
+			if ctx.Err() != nil {
+				return response, ctx.Err()
+			}
+			return response, fmt.Errorf("error listing archive '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
+		}
+		response.Type = "error"
+		response.Payload = errorPayload
+		return response, nil
+	}
+
+	result := map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"item_path":    payload.ItemPath,
+		"format":       format,
+		"entries":      entries,
+		"truncated":    truncated,
+	}
+	if totalEntries >= 0 {
+		result["total_entries"] = totalEntries
+	}
+	response.Payload = result
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("list_archive_response (User: %s, ReqID: %s): %d entries (truncated %t) in %s archive %s/%s", userIdentifier, msg.RequestID, len(entries), truncated, format, payload.StorageName, payload.ItemPath)
+	}
+	return response, nil
+}
+
+// openZipArchive opens the ZIP at itemPath. Con l'accesso casuale vengono letti solo il record
+// end-of-central-directory e il central directory (archive/zip li cerca in fondo al file); gli storage
+// sequenziali leggono in memoria l'intero file, fino a listArchiveMaxBufferedZipBytes.
+func openZipArchive(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, itemPath string) (*zip.Reader, io.Closer, error) {
+	caps := provider.Capabilities()
+	if caps.RandomAccess {
+		readerAt, err := provider.OpenReaderAt(ctx, claims, itemPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		blockSize := caps.BlockSize
+		if blockSize <= 0 {
+			blockSize = listArchiveReadBlockSize
+		}
+		zipReader, err := zip.NewReader(&blockReaderAt{r: readerAt, size: readerAt.Size(), blockSize: blockSize}, readerAt.Size())
+		if err != nil {
+			readerAt.Close()
+			return nil, nil, zipOpenError(err)
+		}
+		return zipReader, readerAt, nil
+	}
+
+	reader, err := provider.OpenReader(ctx, claims, itemPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, listArchiveMaxBufferedZipBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > listArchiveMaxBufferedZipBytes {
+		return nil, nil, fmt.Errorf("%w: ZIP archives larger than %d MB cannot be read on this storage", ErrArchiveTooLarge, listArchiveMaxBufferedZipBytes/(1024*1024))
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, zipOpenError(err)
+	}
+	return zipReader, io.NopCloser(nil), nil
+}
+
+// zipOpenError reports the format errors of archive/zip as ErrInvalidArchive.
+func zipOpenError(err error) error {
+	if errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrAlgorithm) || errors.Is(err, zip.ErrChecksum) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return err
+}
+
+// listZipEntries returns the entries of the ZIP at itemPath and their total number.
+func listZipEntries(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, itemPath string) ([]archiveEntry, int, bool, error) {
+	zipReader, closer, err := openZipArchive(ctx, provider, claims, itemPath)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer closer.Close()
+
+	entries := make([]archiveEntry, 0, min(len(zipReader.File), listArchiveMaxEntries))
+	for _, file := range zipReader.File {
+		if len(entries) >= listArchiveMaxEntries {
+			return entries, len(zipReader.File), true, nil
+		}
+		entries = append(entries, archiveEntry{
+			Name:    file.Name,
+			Size:    int64(file.UncompressedSize64),
+			ModTime: file.Modified,
+			IsDir:   file.FileInfo().IsDir(),
+		})
+	}
+	return entries, len(zipReader.File), false, nil
+}
+
+// listTarEntries returns the entries of the tar at itemPath (compresso con gzip se gzipped), leggendo al
+// massimo listArchiveMaxTarBytes byte del file.
+func listTarEntries(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, itemPath string, gzipped bool) ([]archiveEntry, bool, error) {
+	reader, err := provider.OpenReader(ctx, claims, itemPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+
+	limited := &io.LimitedReader{R: reader, N: listArchiveMaxTarBytes}
+	var archive io.Reader = limited
+	if gzipped {
+		gzipReader, err := gzip.NewReader(limited)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer gzipReader.Close()
+		archive = gzipReader
+	}
+
+	entries := []archiveEntry{}
+	tarReader := tar.NewReader(archive)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries, false, nil
+		}
+		if err != nil {
+			if limited.N == 0 {
+				// Limite di lettura raggiunto a metà di una voce: l'elenco è troncato, non corrotto.
+				return entries, true, nil
+			}
+			if ctx.Err() != nil {
+				return nil, false, ctx.Err()
+			}
+			if errors.Is(err, tar.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) {
+				return nil, false, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+			}
+			return nil, false, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if len(entries) >= listArchiveMaxEntries {
+			return entries, true, nil
+		}
+		entries = append(entries, archiveEntry{
+			Name:    header.Name,
+			Size:    header.Size,
+			ModTime: header.ModTime,
+			IsDir:   header.Typeflag == tar.TypeDir,
+		})
+	}
+}
+
+// blockReaderAt reads r in aligned blocks of blockSize and keeps the last one: archive/zip legge il
+// central directory a piccoli pezzi consecutivi, che sugli storage remoti sarebbero una richiesta ciascuno.
+type blockReaderAt struct {
+	r           io.ReaderAt
+	size        int64
+	blockSize   int64
+	block       []byte
+	blockOffset int64
+}
+
+func (b *blockReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= b.size {
+			return n, io.EOF
+		}
+		start := pos - pos%b.blockSize
+		if b.block == nil || start != b.blockOffset {
+			block := make([]byte, min(b.blockSize, b.size-start))
+			read, err := b.r.ReadAt(block, start)
+			if err != nil && !(err == io.EOF && read == len(block)) {
+				b.block = nil
+				return n, err
+			}
+			b.block, b.blockOffset = block, start
+		}
+		n += copy(p[n:], b.block[pos-start:])
+	}
+	return n, nil
+}
@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+
+	"clouddav/auth"
+	"clouddav/storage"
+)
+
+// maxIncrementalChanges is the maximum number of changes of an incremental list_directory: oltre conviene
+// l'elenco completo, che il client riceve al loro posto.
+const maxIncrementalChanges = 1000
+
+// directoryChange is a change of a list_directory with since_seq. Item è lo stato attuale dell'elemento
+// creato o modificato; un "deleted" è un tombstone, senza Item.
+type directoryChange struct {
+	storage.Change
+	Item *storage.ItemInfo `json:"item,omitempty"`
+}
+
+// listDirectoryChanges returns the changes of the direct children of dirPath after sinceSeq, read from the
+// change log of the storage, and the cursor for the next call. ok è false quando serve l'elenco completo:
+// cursore troppo vecchio o sconosciuto, directory (o una sua antenata) ricreata o spostata, troppe modifiche.
+// Ogni elemento creato o modificato viene riletto con GetItem: se nel frattempo è sparito diventa un
+// tombstone, e onlyDirectories/onlyFiles si applicano agli elementi esistenti.
+func listDirectoryChanges(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, storageName string, dirPath string, sinceSeq uint64, onlyDirectories bool, onlyFiles bool) ([]directoryChange, uint64, bool, error) {
+	changeLog, exists := storage.GetChangeLog(storageName)
+	if !exists {
+		return nil, 0, false, nil
+	}
+	changes, seq, ok := changeLog.Since(dirPath, sinceSeq)
+	if !ok || len(changes) > maxIncrementalChanges {
+		return nil, seq, false, nil
+	}
+
+	result := make([]directoryChange, 0, len(changes))
+	for _, change := range changes {
+		entry := directoryChange{Change: change}
+		if change.Op != storage.ChangeDeleted {
+			item, err := provider.GetItem(ctx, claims, change.Path)
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				entry.Op = storage.ChangeDeleted
+			case err != nil:
+				return nil, seq, false, err
+			case onlyDirectories && !item.IsDir, onlyFiles && item.IsDir:
+				continue
+			default:
+				entry.Item = item
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, seq, true, nil
+}
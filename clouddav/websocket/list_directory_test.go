@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+)
+
+func TestListDirectoryTypeFilters(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	localCfg := config.StorageConfig{Name: "loc", Type: "local"}
+	localCfg.Path = root
+	memoryCfg := config.StorageConfig{Name: "mem", Type: "memory"}
+	localProvider, err := local.NewProvider(ctx, &localCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryProvider, err := memory.NewProvider(ctx, &memoryCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memoryProvider.CreateDirectory(ctx, nil, "/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memoryProvider.InitiateUpload(ctx, nil, "a", "/a.txt", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := memoryProvider.WriteChunk(ctx, nil, "a", []byte("a"), 0, 1, storage.ChunkChecksum{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memoryProvider.FinalizeUpload(ctx, nil, "a", "/a.txt", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{localProvider, memoryProvider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, &config.Config{Storages: []config.StorageConfig{localCfg, memoryCfg}})
+	t.Cleanup(h.cancel)
+
+	tests := []struct {
+		name            string
+		onlyDirectories bool
+		onlyFiles       bool
+		want            string // Nomi elencati, separati da virgole; vuoto se è atteso un errore
+	}{
+		{name: "no filter", want: "a.txt,sub"},
+		{name: "only_directories", onlyDirectories: true, want: "sub"},
+		{name: "only_files", onlyFiles: true, want: "a.txt"},
+		{name: "both flags", onlyDirectories: true, onlyFiles: true},
+	}
+	for _, storageName := range []string{"loc", "mem"} {
+		for _, tt := range tests {
+			t.Run(storageName+"/"+tt.name, func(t *testing.T) {
+				msg := &Message{Type: "list_directory", RequestID: "r1", Payload: map[string]interface{}{
+					"storage_name": storageName, "dir_path": "/", "page": 1, "items_per_page": 100, "only_directories": tt.onlyDirectories, "only_files": tt.onlyFiles,
+				}}
+				response, err := h.handleClientMessage(ctx, msg, nil)
+				if err != nil {
+					t.Fatalf("list_directory: %v", err)
+				}
+				if tt.want == "" {
+					if response.Type != "error" {
+						t.Errorf("response type = %q, want error", response.Type)
+					}
+					return
+				}
+				data, err := json.Marshal(response.Payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var listing struct {
+					Items []storage.ItemInfo `json:"items"`
+				}
+				if err := json.Unmarshal(data, &listing); err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, item := range listing.Items {
+					names = append(names, item.Name)
+				}
+				sort.Strings(names)
+				if got := strings.Join(names, ","); got != tt.want {
+					t.Errorf("items = %s, want %s", got, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"fmt"
+	"sort"
+
+	"clouddav/storage"
+)
+
+// listItemFields maps the fields selectable with the "fields" parameter of list_directory (i nomi JSON
+// di storage.ItemInfo) to their value.
+var listItemFields = map[string]func(item *storage.ItemInfo) interface{}{
+	"name":     func(item *storage.ItemInfo) interface{} { return item.Name },
+	"is_dir":   func(item *storage.ItemInfo) interface{} { return item.IsDir },
+	"size":     func(item *storage.ItemInfo) interface{} { return item.Size },
+	"mod_time": func(item *storage.ItemInfo) interface{} { return item.ModTime },
+	"path":     func(item *storage.ItemInfo) interface{} { return item.Path },
+	"sha256":   func(item *storage.ItemInfo) interface{} { return item.SHA256 },
+
+	"is_symlink":  func(item *storage.ItemInfo) interface{} { return item.IsSymlink },
+	"link_target": func(item *storage.ItemInfo) interface{} { return item.LinkTarget },
+}
+
+// validateListFields checks the "fields" of a list_directory request; nessun campo = ItemInfo completo.
+func validateListFields(fields []string) error {
+	for _, field := range fields {
+		if _, ok := listItemFields[field]; !ok {
+			names := make([]string, 0, len(listItemFields))
+			for name := range listItemFields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("invalid field '%s': must be one of %v", field, names)
+		}
+	}
+	return nil
+}
+
+// projectListItems returns the items with only the requested fields. Come in ItemInfo, sha256 e link_target
+// sono omessi quando non sono disponibili.
+func projectListItems(items []storage.ItemInfo, fields []string) []map[string]interface{} {
+	projected := make([]map[string]interface{}, len(items))
+	for i := range items {
+		item := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			if (field == "sha256" && items[i].SHA256 == "") || (field == "link_target" && items[i].LinkTarget == "") {
+				continue
+			}
+			item[field] = listItemFields[field](&items[i])
+		}
+		projected[i] = item
+	}
+	return projected
+}
@@ -0,0 +1,194 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// listRecursiveBatchSize is the number of items of each list_directory_recursive_batch message.
+	listRecursiveBatchSize = 500
+	// listRecursiveDefaultMaxItems and listRecursiveMaxItems bound the items returned (max_items).
+	listRecursiveDefaultMaxItems = 10000
+	listRecursiveMaxItems        = 100000
+)
+
+// recursiveItem is an item of list_directory_recursive: l'ItemInfo con in più relative_path, il path
+// relativo a base_path.
+type recursiveItem struct {
+	storage.ItemInfo
+	RelativePath string
+}
+
+// MarshalJSON adds relative_path to the fields of the ItemInfo: il MarshalJSON di ItemInfo, promosso
+// dall'embedding, ignorerebbe gli altri campi.
+func (i recursiveItem) MarshalJSON() ([]byte, error) {
+	itemJSON, err := json.Marshal(i.ItemInfo)
+	if err != nil {
+		return nil, err
+	}
+	relativeJSON, err := json.Marshal(i.RelativePath)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`{"relative_path":`)
+	buf.Write(relativeJSON)
+	buf.WriteByte(',')
+	buf.Write(itemJSON[1:])
+	return buf.Bytes(), nil
+}
+
+// listDirectoryRecursive handles list_directory_recursive: every item under base_path, fino a max_depth
+// livelli (0 = nessun limite) e al massimo max_items elementi, per costruire un albero completo o esportarne
+// l'elenco. Le directory precedono il loro contenuto; quelle non leggibili dall'utente vengono saltate con il
+// loro contenuto. Su WebSocket gli elementi sono inviati come list_directory_recursive_batch ({seq, items}) e
+// la risposta, che segna la fine della visita, ne riporta il riepilogo; con il long polling sono tutti nella risposta.
+func (h *Hub) listDirectoryRecursive(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "list_directory_recursive_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		BasePath    string `json:"base_path"`
+		MaxDepth    int    `json:"max_depth,omitempty"`
+		MaxItems    int    `json:"max_items,omitempty"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for list_directory_recursive: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid list_directory_recursive payload: %w", err)
+	}
+	if payload.BasePath == "" {
+		payload.BasePath = "/"
+	}
+	if payload.MaxDepth < 0 {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "Invalid max_depth: cannot be negative"}
+		return response, nil
+	}
+	if payload.MaxItems == 0 {
+		payload.MaxItems = listRecursiveDefaultMaxItems
+	}
+	if payload.MaxItems < 0 || payload.MaxItems > listRecursiveMaxItems {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid max_items: must be between 1 and %d", listRecursiveMaxItems)}
+		return response, nil
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.BasePath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for list_directory_recursive: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	send, streaming := messageSenderFrom(ctx)
+	var items []recursiveItem
+	var batch []recursiveItem
+	totalItems, batches := 0, 0
+	truncated := false
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		batchMsg := Message{
+			Type:      "list_directory_recursive_batch",
+			RequestID: msg.RequestID,
+			Payload: map[string]interface{}{
+				"seq":   batches,
+				"items": batch,
+			},
+		}
+		if err := send(ctx, batchMsg); err != nil {
+			return err
+		}
+		batches++
+		batch = nil
+		return nil
+	}
+
+	err = provider.Walk(ctx, claims, payload.BasePath, payload.MaxDepth, func(item storage.ItemInfo, relative string) error {
+		if authz.CheckStorageAccess(ctx, claims, payload.StorageName, item.Path, "read", h.Config()) != nil {
+			if item.IsDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if totalItems >= payload.MaxItems {
+			truncated = true
+			return fs.SkipAll
+		}
+		totalItems++
+		entry := recursiveItem{ItemInfo: item, RelativePath: relative}
+		if !streaming {
+			items = append(items, entry)
+			return nil
+		}
+		batch = append(batch, entry)
+		if len(batch) >= listRecursiveBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Base path not found"}
+			return response, nil
+		}
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return response, ctx.Err()
+		}
+		return response, fmt.Errorf("error listing '%s/%s' recursively (User: %s, ReqID: %s): %w", payload.StorageName, payload.BasePath, userIdentifier, msg.RequestID, err)
+	}
+	if streaming {
+		if err := flush(); err != nil {
+			return response, err
+		}
+	}
+
+	result := map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"base_path":    payload.BasePath,
+		"max_depth":    payload.MaxDepth,
+		"total_items":  totalItems,
+		"truncated":    truncated,
+	}
+	if streaming {
+		result["batches"] = batches
+	} else {
+		if items == nil {
+			items = []recursiveItem{}
+		}
+		result["items"] = items
+	}
+	response.Payload = result
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("list_directory_recursive_response (User: %s, ReqID: %s): %d items (truncated %t, max_depth %d) under %s/%s", userIdentifier, msg.RequestID, totalItems, truncated, payload.MaxDepth, payload.StorageName, payload.BasePath)
+	}
+	return response, nil
+}
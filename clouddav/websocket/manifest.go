@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+	"clouddav/storage/azureblob"
+	"clouddav/storage/gcs"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+)
+
+const (
+	// manifestPageSize is the page size of the listings done to build a manifest.
+	manifestPageSize = 1000
+	// manifestBatchSize is the number of entries of each manifest_batch message.
+	manifestBatchSize = 500
+	// manifestDefaultMaxEntries and manifestMaxEntries bound the files returned (max_entries).
+	manifestDefaultMaxEntries = 10000
+	manifestMaxEntries        = 100000
+	// manifestDefaultHashBudgetMB and manifestMaxHashBudgetMB bound the bytes read to compute the
+	// checksums missing from the storage (hash_budget_mb).
+	manifestDefaultHashBudgetMB = 256
+	manifestMaxHashBudgetMB     = 10240
+)
+
+// manifestEntry is a file of a manifest. Path è relativo a base_path; SHA256 manca se lo storage non ha
+// un checksum salvato e il file non rientrava nel budget di calcolo.
+type manifestEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// getManifest handles get_manifest: the files under base_path with relative path, size, modification time
+// and SHA256, per i tool di backup/sync che confrontano una copia locale con lo storage. Lo SHA256 è quello
+// salvato dallo storage (store_checksums); se manca viene calcolato, e salvato, finché i byte letti restano
+// entro hash_budget_mb (negativo = solo checksum salvati). Le sottodirectory non leggibili vengono saltate.
+// Su WebSocket le voci sono inviate come manifest_batch ({seq, entries}) e la risposta ne riporta il
+// riepilogo; con il long polling sono tutte nella risposta.
+func (h *Hub) getManifest(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "get_manifest_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName  string `json:"storage_name"`
+		BasePath     string `json:"base_path"`
+		MaxEntries   int    `json:"max_entries,omitempty"`
+		HashBudgetMB int64  `json:"hash_budget_mb,omitempty"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for get_manifest: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid get_manifest payload: %w", err)
+	}
+	if payload.BasePath == "" {
+		payload.BasePath = "/"
+	}
+	if payload.MaxEntries == 0 {
+		payload.MaxEntries = manifestDefaultMaxEntries
+	}
+	if payload.MaxEntries < 0 || payload.MaxEntries > manifestMaxEntries {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid max_entries: must be between 1 and %d", manifestMaxEntries)}
+		return response, nil
+	}
+	if payload.HashBudgetMB == 0 {
+		payload.HashBudgetMB = manifestDefaultHashBudgetMB
+	}
+	if payload.HashBudgetMB > manifestMaxHashBudgetMB {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid hash_budget_mb: cannot exceed %d", manifestMaxHashBudgetMB)}
+		return response, nil
+	}
+	hashBudget := payload.HashBudgetMB * 1024 * 1024
+	if hashBudget < 0 {
+		hashBudget = 0
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.BasePath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for get_manifest: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	send, streaming := messageSenderFrom(ctx)
+	var entries []manifestEntry
+	var batch []manifestEntry
+	totalEntries, batches, missingChecksums := 0, 0, 0
+	var hashedBytes int64
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		batchMsg := Message{
+			Type:      "manifest_batch",
+			RequestID: msg.RequestID,
+			Payload: map[string]interface{}{
+				"seq":     batches,
+				"entries": batch,
+			},
+		}
+		if err := send(ctx, batchMsg); err != nil {
+			return err
+		}
+		batches++
+		batch = nil
+		return nil
+	}
+
+	type pendingDir struct {
+		path     string
+		relative string
+	}
+	pending := []pendingDir{{path: payload.BasePath}}
+	truncated := false
+	for len(pending) > 0 && !truncated {
+		current := pending[0]
+		pending = pending[1:]
+		for page := 1; ; page++ {
+			if err := ctx.Err(); err != nil {
+				return response, err
+			}
+			listResponse, err := provider.ListItems(ctx, claims, current.path, page, manifestPageSize, "", nil, false, false)
+			if err != nil {
+				if current.path == payload.BasePath {
+					if errors.Is(err, storage.ErrNotFound) {
+						response.Type = "error"
+						response.Payload = map[string]string{"error": "Base path not found"}
+						return response, nil
+					}
+					if errors.Is(err, storage.ErrPermissionDenied) {
+						response.Type = "error"
+						response.Payload = map[string]string{"error": "Access denied: read permission required"}
+						return response, nil
+					}
+					return response, fmt.Errorf("error listing '%s/%s' for get_manifest (User: %s, ReqID: %s): %w", payload.StorageName, current.path, userIdentifier, msg.RequestID, err)
+				}
+				if ctx.Err() != nil {
+					return response, ctx.Err()
+				}
+				// Sottodirectory rimossa o non leggibile durante la visita: si prosegue con le altre.
+				log.Printf("Warning: get_manifest: skipping '%s': %v", current.path, err)
+				break
+			}
+			for _, item := range listResponse.Items {
+				if authz.CheckStorageAccess(ctx, claims, payload.StorageName, item.Path, "read", h.Config()) != nil {
+					continue
+				}
+				relative := path.Join(current.relative, item.Name)
+				if item.IsDir {
+					pending = append(pending, pendingDir{path: item.Path, relative: relative})
+					continue
+				}
+				if totalEntries >= payload.MaxEntries {
+					truncated = true
+					break
+				}
+
+				entry := manifestEntry{Path: relative, Size: item.Size, ModTime: item.ModTime, SHA256: item.SHA256}
+				if entry.SHA256 == "" {
+					// I listing non riportano il checksum salvato: lo legge GetItem (sidecar o metadata).
+					if itemInfo, err := provider.GetItem(ctx, claims, item.Path); err == nil {
+						entry.SHA256 = itemInfo.SHA256
+					}
+				}
+				if entry.SHA256 == "" && hashBudget > 0 && item.Size <= hashBudget-hashedBytes {
+					sha256Hex, err := hashAndStoreChecksum(ctx, provider, claims, payload.StorageName, item.Path)
+					if err != nil {
+						if ctx.Err() != nil {
+							return response, ctx.Err()
+						}
+						log.Printf("Warning: get_manifest: cannot compute the checksum of '%s/%s': %v", payload.StorageName, item.Path, err)
+					} else {
+						entry.SHA256 = sha256Hex
+					}
+					hashedBytes += item.Size
+				}
+				if entry.SHA256 == "" {
+					missingChecksums++
+				}
+
+				totalEntries++
+				if streaming {
+					batch = append(batch, entry)
+					if len(batch) >= manifestBatchSize {
+						if err := flush(); err != nil {
+							return response, err
+						}
+					}
+				} else {
+					entries = append(entries, entry)
+				}
+			}
+			if truncated || len(listResponse.Items) < manifestPageSize {
+				break
+			}
+		}
+	}
+	if streaming {
+		if err := flush(); err != nil {
+			return response, err
+		}
+	}
+
+	result := map[string]interface{}{
+		"storage_name":      payload.StorageName,
+		"base_path":         payload.BasePath,
+		"total_entries":     totalEntries,
+		"missing_checksums": missingChecksums,
+		"hashed_bytes":      hashedBytes,
+		"truncated":         truncated,
+	}
+	if streaming {
+		result["batches"] = batches
+	} else {
+		if entries == nil {
+			entries = []manifestEntry{}
+		}
+		result["entries"] = entries
+	}
+	response.Payload = result
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("get_manifest_response (User: %s, ReqID: %s): %d entries (%d without checksum, %d bytes hashed, truncated %t) under %s/%s", userIdentifier, msg.RequestID, totalEntries, missingChecksums, hashedBytes, truncated, payload.StorageName, payload.BasePath)
+	}
+	return response, nil
+}
+
+// hashAndStoreChecksum reads the item to compute its SHA256 and saves it with the provider's StoreChecksum,
+// così compute_hash e get_manifest non rileggono il file la volta successiva. Dimensione, data di modifica
+// ed ETag vengono letti prima del contenuto: il provider salva il checksum solo se non sono cambiati.
+// Un errore nel salvataggio viene solo registrato: lo SHA256 calcolato resta valido.
+func hashAndStoreChecksum(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, storageName string, itemPath string) (string, error) {
+	hashed, err := provider.GetItem(ctx, claims, itemPath)
+	if err != nil {
+		return "", err
+	}
+	reader, err := provider.OpenReader(ctx, claims, itemPath)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	_, copyErr := io.Copy(hasher, reader)
+	reader.Close()
+	if copyErr != nil {
+		return "", fmt.Errorf("error reading item content: %w", copyErr)
+	}
+	sha256Hex := hex.EncodeToString(hasher.Sum(nil))
+
+	var storeErr error
+	switch p := storage.Unwrap(provider).(type) {
+	case *local.LocalFilesystemProvider:
+		storeErr = p.StoreChecksum(ctx, claims, itemPath, sha256Hex, hashed)
+	case *azureblob.AzureBlobStorageProvider:
+		storeErr = p.StoreChecksum(ctx, claims, itemPath, sha256Hex, hashed)
+	case *gcs.GCSStorageProvider:
+		storeErr = p.StoreChecksum(ctx, claims, itemPath, sha256Hex, hashed)
+	case *memory.MemoryStorageProvider:
+		storeErr = p.StoreChecksum(ctx, claims, itemPath, sha256Hex, hashed)
+	}
+	if storeErr != nil {
+		log.Printf("Warning: Failed to store computed checksum for '%s/%s': %v", storageName, itemPath, storeErr)
+	}
+	return sha256Hex, nil
+}
@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"fmt"
+	"time"
+
+	"clouddav/storage"
+)
+
+// parseModTimeRange builds the modification date filter of list_directory and search from modified_after
+// (esclusivo) e modified_before (inclusivo), in RFC 3339. Il timestamp_filter storico equivale a
+// modified_after e viene usato solo se modified_after è assente; un timestamp_filter non valido resta
+// ignorato come nelle versioni precedenti. Restituisce nil se non è richiesto alcun filtro.
+func parseModTimeRange(modifiedAfter string, modifiedBefore string, legacyTimestampFilter string) (*storage.ModTimeRange, error) {
+	var modTimeRange storage.ModTimeRange
+	if modifiedAfter != "" {
+		after, err := time.Parse(time.RFC3339, modifiedAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modified_after: expected an RFC 3339 date (e.g. 2024-01-31T00:00:00Z)")
+		}
+		modTimeRange.After = &after
+	} else if legacyTimestampFilter != "" {
+		if after, err := time.Parse(time.RFC3339, legacyTimestampFilter); err == nil {
+			modTimeRange.After = &after
+		}
+	}
+	if modifiedBefore != "" {
+		before, err := time.Parse(time.RFC3339, modifiedBefore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modified_before: expected an RFC 3339 date (e.g. 2024-01-31T23:59:59Z)")
+		}
+		modTimeRange.Before = &before
+	}
+	if modTimeRange.After == nil && modTimeRange.Before == nil {
+		return nil, nil
+	}
+	if modTimeRange.After != nil && modTimeRange.Before != nil && modTimeRange.After.After(*modTimeRange.Before) {
+		return nil, fmt.Errorf("invalid date range: modified_after (%s) is later than modified_before (%s)", modifiedAfter, modifiedBefore)
+	}
+	return &modTimeRange, nil
+}
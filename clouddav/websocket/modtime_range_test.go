@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/local"
+)
+
+func TestParseModTimeRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		after      string
+		before     string
+		legacy     string
+		wantAfter  string // RFC 3339; vuoto se il limite non è atteso
+		wantBefore string
+		wantNil    bool
+		wantErr    bool
+	}{
+		{name: "no filter", wantNil: true},
+		{name: "after only", after: "2024-01-01T00:00:00Z", wantAfter: "2024-01-01T00:00:00Z"},
+		{name: "before only", before: "2024-01-31T00:00:00Z", wantBefore: "2024-01-31T00:00:00Z"},
+		{name: "full range", after: "2024-01-01T00:00:00Z", before: "2024-01-31T00:00:00Z", wantAfter: "2024-01-01T00:00:00Z", wantBefore: "2024-01-31T00:00:00Z"},
+		{name: "equal bounds", after: "2024-01-01T00:00:00Z", before: "2024-01-01T00:00:00Z", wantAfter: "2024-01-01T00:00:00Z", wantBefore: "2024-01-01T00:00:00Z"},
+		{name: "after later than before", after: "2024-02-01T00:00:00Z", before: "2024-01-01T00:00:00Z", wantErr: true},
+		{name: "invalid after", after: "yesterday", wantErr: true},
+		{name: "invalid before", before: "2024-13-01", wantErr: true},
+		{name: "legacy timestamp_filter", legacy: "2024-01-01T00:00:00Z", wantAfter: "2024-01-01T00:00:00Z"},
+		{name: "modified_after wins over legacy", after: "2024-02-01T00:00:00Z", legacy: "2024-01-01T00:00:00Z", wantAfter: "2024-02-01T00:00:00Z"},
+		{name: "invalid legacy is ignored", legacy: "not a date", wantNil: true},
+	}
+	format := func(bound *time.Time) string {
+		if bound == nil {
+			return ""
+		}
+		return bound.Format(time.RFC3339)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseModTimeRange(tt.after, tt.before, tt.legacy)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseModTimeRange = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseModTimeRange: %v", err)
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("parseModTimeRange = %+v, want nil", got)
+				}
+				return
+			}
+			if format(got.After) != tt.wantAfter || format(got.Before) != tt.wantBefore {
+				t.Errorf("range = (%s, %s], want (%s, %s]", format(got.After), format(got.Before), tt.wantAfter, tt.wantBefore)
+			}
+		})
+	}
+}
+
+func TestModTimeRangeFilters(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	files := map[string]time.Time{
+		"early.txt":    start.Add(-time.Second),
+		"at-start.txt": start,
+		"inside.txt":   start.Add(time.Hour),
+		"at-end.txt":   end,
+		"late.txt":     end.Add(time.Second),
+	}
+	for name, modTime := range files {
+		fullPath := filepath.Join(root, name)
+		if err := os.WriteFile(fullPath, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fullPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storageCfg := config.StorageConfig{Name: "loc", Type: "local"}
+	storageCfg.Path = root
+	provider, err := local.NewProvider(ctx, &storageCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, &config.Config{Storages: []config.StorageConfig{storageCfg}})
+	t.Cleanup(h.cancel)
+
+	startText, endText := start.Format(time.RFC3339), end.Format(time.RFC3339)
+	tests := []struct {
+		name   string
+		filter map[string]interface{}
+		want   string // Nomi trovati, separati da virgole; vuoto se è atteso un errore
+	}{
+		{"full range", map[string]interface{}{"modified_after": startText, "modified_before": endText}, "at-end.txt,inside.txt"},
+		{"after only", map[string]interface{}{"modified_after": startText}, "at-end.txt,inside.txt,late.txt"},
+		{"before only", map[string]interface{}{"modified_before": endText}, "at-end.txt,at-start.txt,early.txt,inside.txt"},
+		{"inverted range", map[string]interface{}{"modified_after": endText, "modified_before": startText}, ""},
+	}
+	for _, messageType := range []string{"list_directory", "search"} {
+		for _, tt := range tests {
+			t.Run(messageType+"/"+tt.name, func(t *testing.T) {
+				payload := map[string]interface{}{"storage_name": "loc"}
+				if messageType == "list_directory" {
+					payload["dir_path"] = "/"
+					payload["page"] = 1
+					payload["items_per_page"] = 100
+				} else {
+					payload["base_path"] = "/"
+					payload["name_pattern"] = `\.txt$`
+				}
+				for key, value := range tt.filter {
+					payload[key] = value
+				}
+				response, err := h.handleClientMessage(ctx, &Message{Type: messageType, RequestID: "r1", Payload: payload}, nil)
+				if err != nil {
+					t.Fatalf("%s: %v", messageType, err)
+				}
+				if tt.want == "" {
+					if response.Type != "error" {
+						t.Errorf("response type = %q, want error", response.Type)
+					}
+					return
+				}
+				data, err := json.Marshal(response.Payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var result struct {
+					Items []storage.ItemInfo `json:"items"`
+				}
+				if err := json.Unmarshal(data, &result); err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, item := range result.Items {
+					names = append(names, item.Name)
+				}
+				sort.Strings(names)
+				if got := strings.Join(names, ","); got != tt.want {
+					t.Errorf("items = %s, want %s", got, tt.want)
+				}
+			})
+		}
+	}
+
+	legacy := &Message{Type: "list_directory", RequestID: "r2", Payload: map[string]interface{}{
+		"storage_name": "loc", "dir_path": "/", "page": 1, "items_per_page": 100, "timestamp_filter": endText,
+	}}
+	response, err := h.handleClientMessage(ctx, legacy, nil)
+	if err != nil {
+		t.Fatalf("list_directory with timestamp_filter: %v", err)
+	}
+	if data, _ := json.Marshal(response.Payload); !strings.Contains(string(data), "late.txt") || strings.Contains(string(data), "at-end.txt") {
+		t.Errorf("timestamp_filter listing = %s, want only late.txt", data)
+	}
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"clouddav/auth"
+	"clouddav/internal/logging"
+	"clouddav/storage"
+)
+
+// maxTransferOutcomesReturned limits the per-item outcomes of a move_item/copy_item with conflict_strategy;
+// i conteggi in "counts" riguardano sempre tutti gli elementi.
+const maxTransferOutcomesReturned = 1000
+
+// conflictStrategyOptions validates conflict_strategy and file_conflict of move_item/copy_item and returns
+// them with their defaults ("fail" e, per il merge, "skip"), or an error message for the client.
+func conflictStrategyOptions(strategy string, fileConflict string) (string, string, string) {
+	if strategy == "" {
+		strategy = storage.ConflictFail
+	}
+	switch strategy {
+	case storage.ConflictFail, storage.ConflictOverwrite, storage.ConflictMerge, storage.ConflictRename:
+	default:
+		return "", "", fmt.Sprintf("Invalid conflict_strategy '%s': use fail, overwrite, merge or rename", strategy)
+	}
+	if fileConflict == "" {
+		fileConflict = storage.ConflictSkip
+	}
+	switch fileConflict {
+	case storage.ConflictFail, storage.ConflictOverwrite, storage.ConflictRename, storage.ConflictSkip:
+	default:
+		return "", "", fmt.Sprintf("Invalid file_conflict '%s': use fail, overwrite, rename or skip", fileConflict)
+	}
+	return strategy, fileConflict, ""
+}
+
+// transferWithConflicts handles a move_item (move true) or copy_item whose conflict_strategy is not "fail",
+// with storage.TransferWithConflicts. La risposta contiene gli esiti per elemento (al massimo
+// maxTransferOutcomesReturned) e i conteggi per esito; lo status è "partial" se qualche elemento è fallito.
+func (h *Hub) transferWithConflicts(ctx context.Context, msg *Message, response Message, provider storage.StorageProvider, claims *auth.UserClaims, userIdentifier string, storageName string, srcPath string, dstPath string, move bool, strategy string, fileConflict string) (Message, error) {
+	operation := "copy"
+	if move {
+		operation = "move"
+	}
+	outcomes, err := storage.TransferWithConflicts(ctx, provider, claims, srcPath, dstPath, move, strategy, fileConflict, h.Config().UploadAutoRename)
+	if err != nil {
+		var errorPayload map[string]string
+		switch {
+		case errors.Is(err, storage.ErrDestinationInsideSource):
+			errorPayload = map[string]string{"error": fmt.Sprintf("Cannot %s an item into itself", operation), "error_code": "INVALID_DESTINATION"}
+		case errors.Is(err, storage.ErrTreeTooLarge):
+			errorPayload = map[string]string{"error": err.Error(), "error_code": "TREE_TOO_LARGE"}
+		case errors.Is(err, storage.ErrNotFound):
+			errorPayload = map[string]string{"error": "Item not found"}
+		case errors.Is(err, storage.ErrAlreadyExists):
+			errorPayload = map[string]string{"error": "Destination already exists", "error_code": "ALREADY_EXISTS"}
+		case errors.Is(err, storage.ErrPermissionDenied):
+			errorPayload = map[string]string{"error": fmt.Sprintf("Access denied: %v", err)}
+		case errors.Is(err, storage.ErrNotImplemented):
+			errorPayload = map[string]string{"error": fmt.Sprintf("The %s is not supported for this storage type", operation)}
+		default:
+			if ctx.Err() != nil {
+				return response, ctx.Err()
+			}
+			return response, fmt.Errorf("error during %s of '%s/%s' to '%s' with conflict_strategy %s (User: %s, ReqID: %s): %w", operation, storageName, srcPath, dstPath, strategy, userIdentifier, msg.RequestID, err)
+		}
+		response.Type = "error"
+		response.Payload = errorPayload
+		return response, nil
+	}
+
+	counts := make(map[string]int)
+	for _, outcome := range outcomes {
+		counts[outcome.Outcome]++
+		if outcome.Outcome == storage.OutcomeFailed {
+			h.RecordError(claims, msg.Type, storageName, outcome.SourcePath, errors.New(outcome.Error))
+		}
+	}
+	status := "success"
+	if counts[storage.OutcomeFailed] > 0 {
+		status = "partial"
+	}
+	returned := outcomes
+	if len(returned) > maxTransferOutcomesReturned {
+		returned = returned[:maxTransferOutcomesReturned]
+	}
+	payload := map[string]interface{}{
+		"status":             status,
+		"storage_name":       storageName,
+		"source_path":        srcPath,
+		"destination_path":   dstPath,
+		"conflict_strategy":  strategy,
+		"file_conflict":      fileConflict,
+		"outcomes":           returned,
+		"outcomes_truncated": len(outcomes) > len(returned),
+		"counts":             counts,
+	}
+	if move {
+		moveResult := defaultMoveResult(provider)
+		payload["method"] = moveResult.Method
+		payload["instant"] = moveResult.Instant
+	}
+	response.Payload = payload
+	slog.Info("Transferred item with conflict strategy", logging.KeyUser, userIdentifier, logging.KeyRequestID, msg.RequestID, logging.KeyStorage, storageName, logging.KeyPath, srcPath, "destination", dstPath, "move", move, "conflict_strategy", strategy, "file_conflict", fileConflict, "items", len(outcomes), "failed", counts[storage.OutcomeFailed])
+	return response, nil
+}
@@ -0,0 +1,265 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+)
+
+func TestConflictStrategyOptions(t *testing.T) {
+	tests := []struct {
+		strategy, fileConflict         string
+		wantStrategy, wantFileConflict string
+		wantInvalid                    bool
+	}{
+		{"", "", storage.ConflictFail, storage.ConflictSkip, false},
+		{"merge", "", storage.ConflictMerge, storage.ConflictSkip, false},
+		{"merge", "rename", storage.ConflictMerge, storage.ConflictRename, false},
+		{"overwrite", "fail", storage.ConflictOverwrite, storage.ConflictFail, false},
+		{"skip", "", "", "", true}, // skip vale solo come file_conflict
+		{"merge", "merge", "", "", true},
+		{"replace", "", "", "", true},
+	}
+	for _, tt := range tests {
+		strategy, fileConflict, invalid := conflictStrategyOptions(tt.strategy, tt.fileConflict)
+		if (invalid != "") != tt.wantInvalid || strategy != tt.wantStrategy || fileConflict != tt.wantFileConflict {
+			t.Errorf("conflictStrategyOptions(%q, %q) = %q, %q, %q", tt.strategy, tt.fileConflict, strategy, fileConflict, invalid)
+		}
+	}
+}
+
+// conflictTrees are the overlapping source and destination trees of the merge tests (path -> content).
+var conflictTrees = map[string]string{
+	"/src/a.txt":        "src-a",
+	"/src/only-src.txt": "src-only",
+	"/src/sub/b.txt":    "src-b",
+	"/src/sub/new.txt":  "src-new",
+	"/src/newdir/c.txt": "src-c",
+	"/dst/a.txt":        "dst-a",
+	"/dst/sub/b.txt":    "dst-b",
+	"/dst/sub/keep.txt": "dst-keep",
+	"/dst/other.txt":    "dst-other",
+}
+
+// newConflictHub registers a local and a memory storage holding conflictTrees.
+func newConflictHub(t *testing.T) (*Hub, map[string]storage.StorageProvider) {
+	t.Helper()
+	ctx := context.Background()
+	root := t.TempDir()
+	localCfg := config.StorageConfig{Name: "loc", Type: "local"}
+	localCfg.Path = root
+	memoryCfg := config.StorageConfig{Name: "mem", Type: "memory"}
+	localProvider, err := local.NewProvider(ctx, &localCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryProvider, err := memory.NewProvider(ctx, &memoryCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for itemPath, content := range conflictTrees {
+		fullPath := filepath.Join(root, filepath.FromSlash(itemPath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		size := int64(len(content))
+		if _, err := memoryProvider.InitiateUpload(ctx, nil, itemPath, itemPath, size, size); err != nil {
+			t.Fatal(err)
+		}
+		if err := memoryProvider.WriteChunk(ctx, nil, itemPath, []byte(content), 0, size, storage.ChunkChecksum{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := memoryProvider.FinalizeUpload(ctx, nil, itemPath, itemPath, "", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{localProvider, memoryProvider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	cfg := &config.Config{
+		Storages:         []config.StorageConfig{localCfg, memoryCfg},
+		UploadAutoRename: config.UploadAutoRenameConfig{Pattern: "{name} ({n}){ext}", MaxAttempts: 10},
+	}
+	h := NewHub(ctx, cfg)
+	t.Cleanup(h.cancel)
+	return h, map[string]storage.StorageProvider{"loc": localProvider, "mem": memoryProvider}
+}
+
+// readItem returns the content of a file, or "" if it does not exist.
+func readItem(t *testing.T, provider storage.StorageProvider, itemPath string) string {
+	t.Helper()
+	reader, err := provider.OpenReader(context.Background(), nil, itemPath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ""
+	}
+	if err != nil {
+		t.Fatalf("OpenReader(%s): %v", itemPath, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMergeOverlappingTrees(t *testing.T) {
+	tests := []struct {
+		fileConflict string
+		wantStatus   string
+		wantCounts   map[string]int
+		wantDst      map[string]string // Contenuto atteso a destinazione ("" = assente)
+		wantSrcLeft  map[string]string // Contenuto atteso nella sorgente dopo un move ("" = assente)
+	}{
+		{
+			fileConflict: storage.ConflictSkip,
+			wantStatus:   "success",
+			wantCounts:   map[string]int{storage.OutcomeTransferred: 3, storage.OutcomeSkipped: 2},
+			wantDst:      map[string]string{"/dst/a.txt": "dst-a", "/dst/sub/b.txt": "dst-b", "/dst/only-src.txt": "src-only", "/dst/sub/new.txt": "src-new", "/dst/newdir/c.txt": "src-c"},
+			wantSrcLeft:  map[string]string{"/src/a.txt": "src-a", "/src/sub/b.txt": "src-b", "/src/only-src.txt": "", "/src/sub/new.txt": "", "/src/newdir/c.txt": ""},
+		},
+		{
+			fileConflict: storage.ConflictOverwrite,
+			wantStatus:   "success",
+			wantCounts:   map[string]int{storage.OutcomeTransferred: 3, storage.OutcomeOverwritten: 2},
+			wantDst:      map[string]string{"/dst/a.txt": "src-a", "/dst/sub/b.txt": "src-b", "/dst/only-src.txt": "src-only"},
+			wantSrcLeft:  map[string]string{"/src/a.txt": "", "/src/sub/b.txt": ""},
+		},
+		{
+			fileConflict: storage.ConflictRename,
+			wantStatus:   "success",
+			wantCounts:   map[string]int{storage.OutcomeTransferred: 3, storage.OutcomeRenamed: 2},
+			wantDst:      map[string]string{"/dst/a.txt": "dst-a", "/dst/a (1).txt": "src-a", "/dst/sub/b.txt": "dst-b", "/dst/sub/b (1).txt": "src-b"},
+			wantSrcLeft:  map[string]string{"/src/a.txt": "", "/src/sub/b.txt": ""},
+		},
+		{
+			fileConflict: storage.ConflictFail,
+			wantStatus:   "partial",
+			wantCounts:   map[string]int{storage.OutcomeTransferred: 3, storage.OutcomeFailed: 2},
+			wantDst:      map[string]string{"/dst/a.txt": "dst-a", "/dst/sub/b.txt": "dst-b", "/dst/newdir/c.txt": "src-c"},
+			wantSrcLeft:  map[string]string{"/src/a.txt": "src-a", "/src/sub/b.txt": "src-b"},
+		},
+	}
+	for _, storageName := range []string{"loc", "mem"} {
+		for _, messageType := range []string{"copy_item", "move_item"} {
+			for _, tt := range tests {
+				t.Run(storageName+"/"+messageType+"/"+tt.fileConflict, func(t *testing.T) {
+					h, providers := newConflictHub(t)
+					provider := providers[storageName]
+					msg := &Message{Type: messageType, RequestID: "r1", Payload: map[string]interface{}{
+						"storage_name": storageName, "source_path": "/src", "destination_path": "/dst",
+						"conflict_strategy": storage.ConflictMerge, "file_conflict": tt.fileConflict,
+					}}
+					response, err := h.handleClientMessage(context.Background(), msg, nil)
+					if err != nil {
+						t.Fatalf("%s: %v", messageType, err)
+					}
+					data, _ := json.Marshal(response.Payload)
+					var result struct {
+						Status   string                    `json:"status"`
+						Counts   map[string]int            `json:"counts"`
+						Outcomes []storage.TransferOutcome `json:"outcomes"`
+					}
+					if err := json.Unmarshal(data, &result); err != nil {
+						t.Fatal(err)
+					}
+					if result.Status != tt.wantStatus || len(result.Counts) != len(tt.wantCounts) {
+						t.Fatalf("response = %s, want status %s and counts %v", data, tt.wantStatus, tt.wantCounts)
+					}
+					for outcome, count := range tt.wantCounts {
+						if result.Counts[outcome] != count {
+							t.Errorf("counts[%s] = %d, want %d (response %s)", outcome, result.Counts[outcome], count, data)
+						}
+					}
+
+					// Gli elementi che esistevano solo nella destinazione non vengono toccati.
+					wantDst := map[string]string{"/dst/sub/keep.txt": "dst-keep", "/dst/other.txt": "dst-other"}
+					for itemPath, content := range tt.wantDst {
+						wantDst[itemPath] = content
+					}
+					for itemPath, want := range wantDst {
+						if got := readItem(t, provider, itemPath); got != want {
+							t.Errorf("%s = %q, want %q", itemPath, got, want)
+						}
+					}
+					for itemPath, want := range tt.wantSrcLeft {
+						if messageType == "copy_item" {
+							want = conflictTrees[itemPath] // La copia lascia intatta la sorgente
+						}
+						if got := readItem(t, provider, itemPath); got != want {
+							t.Errorf("source %s = %q, want %q", itemPath, got, want)
+						}
+					}
+					_, srcErr := provider.GetItem(context.Background(), nil, "/src")
+					srcRemoved := errors.Is(srcErr, storage.ErrNotFound)
+					wantRemoved := messageType == "move_item" && tt.wantStatus == "success" && tt.fileConflict != storage.ConflictSkip
+					if srcRemoved != wantRemoved {
+						t.Errorf("source directory removed = %t, want %t", srcRemoved, wantRemoved)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestConflictStrategiesOnDirectory(t *testing.T) {
+	tests := []struct {
+		name          string
+		strategy      string
+		srcPath       string
+		wantErrorCode string            // error_code atteso; vuoto se il trasferimento riesce
+		wantItems     map[string]string // Contenuto atteso dopo la copia ("" = assente)
+	}{
+		{name: "fail", strategy: storage.ConflictFail, srcPath: "/src", wantErrorCode: "ALREADY_EXISTS"},
+		{name: "overwrite", strategy: storage.ConflictOverwrite, srcPath: "/src", wantItems: map[string]string{"/dst/a.txt": "src-a", "/dst/other.txt": "", "/dst/sub/keep.txt": ""}},
+		{name: "rename", strategy: storage.ConflictRename, srcPath: "/src", wantItems: map[string]string{"/dst/a.txt": "dst-a", "/dst (1)/a.txt": "src-a"}},
+		{name: "into itself", strategy: storage.ConflictMerge, srcPath: "/dst/sub", wantErrorCode: "INVALID_DESTINATION"},
+		{name: "invalid strategy", strategy: "replace", srcPath: "/src", wantErrorCode: "INVALID_CONFLICT_STRATEGY"},
+	}
+	for _, storageName := range []string{"loc", "mem"} {
+		for _, tt := range tests {
+			t.Run(storageName+"/"+tt.name, func(t *testing.T) {
+				h, providers := newConflictHub(t)
+				destination := "/dst"
+				if tt.name == "into itself" {
+					destination = "/dst/sub/inner"
+				}
+				msg := &Message{Type: "copy_item", RequestID: "r1", Payload: map[string]interface{}{
+					"storage_name": storageName, "source_path": tt.srcPath, "destination_path": destination, "conflict_strategy": tt.strategy,
+				}}
+				response, err := h.handleClientMessage(context.Background(), msg, nil)
+				if err != nil {
+					t.Fatalf("copy_item: %v", err)
+				}
+				if tt.wantErrorCode != "" {
+					payload, _ := response.Payload.(map[string]string)
+					if response.Type != "error" || payload["error_code"] != tt.wantErrorCode {
+						t.Errorf("copy_item = %s %v, want error_code %s", response.Type, response.Payload, tt.wantErrorCode)
+					}
+					return
+				}
+				if response.Type != "copy_item_response" {
+					t.Fatalf("copy_item = %s %v", response.Type, response.Payload)
+				}
+				for itemPath, want := range tt.wantItems {
+					if got := readItem(t, providers[storageName], itemPath); got != want {
+						t.Errorf("%s = %q, want %q", itemPath, got, want)
+					}
+				}
+			})
+		}
+	}
+}
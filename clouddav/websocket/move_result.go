@@ -0,0 +1,21 @@
+package websocket
+
+import (
+	"clouddav/storage"
+	"clouddav/storage/gcs"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+)
+
+// defaultMoveResult describes the moves of the providers that don't report how a move was carried out
+// (Azure usa MoveItemWithResult). Instant è true solo dove è garantito da un rename nativo.
+func defaultMoveResult(provider storage.StorageProvider) *storage.MoveResult {
+	switch storage.Unwrap(provider).(type) {
+	case *local.LocalFilesystemProvider, *memory.MemoryStorageProvider:
+		return &storage.MoveResult{Method: storage.MoveMethodRename, Instant: true}
+	case *gcs.GCSStorageProvider:
+		return &storage.MoveResult{Method: storage.MoveMethodServerCopy}
+	default:
+		return &storage.MoveResult{Method: storage.MoveMethodCopy}
+	}
+}
@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+)
+
+func TestMoveItemReportsMethod(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	localCfg := config.StorageConfig{Name: "loc", Type: "local"}
+	localCfg.Path = root
+	memoryCfg := config.StorageConfig{Name: "mem", Type: "memory"}
+	localProvider, err := local.NewProvider(ctx, &localCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoryProvider, err := memory.NewProvider(ctx, &memoryCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memoryProvider.CreateDirectory(ctx, nil, "/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{localProvider, memoryProvider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, &config.Config{Storages: []config.StorageConfig{localCfg, memoryCfg}})
+	t.Cleanup(h.cancel)
+
+	for _, storageName := range []string{"loc", "mem"} {
+		msg := &Message{Type: "move_item", RequestID: "r1", Payload: map[string]interface{}{
+			"storage_name": storageName, "source_path": "/docs", "destination_path": "/archive",
+		}}
+		response, err := h.handleClientMessage(ctx, msg, nil)
+		if err != nil {
+			t.Fatalf("move_item on %s: %v", storageName, err)
+		}
+		payload, _ := response.Payload.(map[string]interface{})
+		if response.Type != "move_item_response" || payload["method"] != storage.MoveMethodRename || payload["instant"] != true || payload["pending_copies"] != 0 {
+			t.Errorf("move_item on %s = %s %v, want an instant rename", storageName, response.Type, response.Payload)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+// writeProbeTimeout bounds the backend write probe done by my_permissions.
+const writeProbeTimeout = 5 * time.Second
+
+// Operations on a storage allowed by read and by write access, reported by my_permissions.
+var (
+	readOperations = []string{
+		"list_directory", "read_file", "read_file_stream", "read_file_lines", "get_item_metadata",
+		"get_items_info", "compute_hash", "search", "download", "download_zip", "thumbnail",
+	}
+	writeOperations = []string{
+		"upload", "create_directory", "delete_item", "delete_items", "move_item", "copy_item",
+	}
+)
+
+// myPermissions handles my_permissions: the effective permissions of the caller on a storage (and
+// optionally on item_path), valutate con le stesse regole di CheckStorageAccess tramite ExplainAccess.
+// A differenza di explain_access risponde solo per i claims del chiamante e non espone le regole.
+// Se la configurazione consente la scrittura, le ACL del backend vengono verificate con CanWrite:
+// backend_writable è false se il backend rifiuterebbe un upload (e allora can_write è false), assente se
+// il provider non consente una verifica economica o la verifica non è riuscita.
+func (h *Hub) myPermissions(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "my_permissions_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		ItemPath    string `json:"item_path,omitempty"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for my_permissions: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid my_permissions payload: %w", err)
+	}
+
+	cfg := h.Config()
+	if cfg.GetStorageConfig(payload.StorageName) == nil {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "Storage not found"}
+		return response, nil
+	}
+
+	explanation := authz.ExplainAccess(claims, payload.StorageName, payload.ItemPath, cfg)
+	canRead := explanation.Read == authz.DecisionAllow
+	canWrite := explanation.Write == authz.DecisionAllow
+	var backendWritable *bool
+	if canWrite {
+		backendWritable = h.probeBackendWrite(ctx, claims, payload.StorageName, payload.ItemPath)
+		if backendWritable != nil && !*backendWritable {
+			canWrite = false
+		}
+	}
+	allowedOperations := []string{}
+	if canRead {
+		allowedOperations = append(allowedOperations, readOperations...)
+	}
+	if canWrite {
+		allowedOperations = append(allowedOperations, writeOperations...)
+	}
+
+	result := map[string]interface{}{
+		"storage_name":       payload.StorageName,
+		"item_path":          payload.ItemPath,
+		"can_read":           canRead,
+		"can_write":          canWrite,
+		"is_admin":           authz.IsGlobalAdmin(claims, cfg),
+		"read_only":          canRead && !canWrite,
+		"allowed_operations": allowedOperations,
+	}
+	if backendWritable != nil {
+		result["backend_writable"] = *backendWritable
+	}
+	response.Payload = result
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("my_permissions_response (User: %s, ReqID: %s): storage '%s', path '%s': read=%t write=%t", userIdentifier, msg.RequestID, payload.StorageName, payload.ItemPath, canRead, canWrite)
+	}
+	return response, nil
+}
+
+// probeBackendWrite returns the result of the provider write probe on itemPath, or nil if the provider does
+// not support it or the probe failed (in tal caso vale solo la configurazione).
+func (h *Hub) probeBackendWrite(ctx context.Context, claims *auth.UserClaims, storageName string, itemPath string) *bool {
+	provider, ok := storage.GetProvider(storageName)
+	if !ok {
+		return nil
+	}
+	if itemPath == "" {
+		itemPath = "/"
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, writeProbeTimeout)
+	defer cancel()
+	writable, err := provider.CanWrite(probeCtx, claims, itemPath)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotImplemented) {
+			log.Printf("Warning: write probe failed for storage '%s', path '%s': %v", storageName, itemPath, err)
+		}
+		return nil
+	}
+	return &writable
+}
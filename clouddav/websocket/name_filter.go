@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Modes of the structured name filter of list_directory.
+const (
+	NameFilterModeRegex    = "regex"    // Espressione regolare (default); pattern vuoto = tutti gli elementi
+	NameFilterModeGlob     = "glob"     // '*' e '?' come wildcard sull'intero nome; pattern vuoto = nessun elemento
+	NameFilterModeExact    = "exact"    // Nome identico al pattern; pattern vuoto = nessun elemento
+	NameFilterModeContains = "contains" // Il nome contiene il pattern letterale; pattern vuoto = tutti gli elementi
+)
+
+// matchNothingRegexp matches only an empty name, which no storage item has.
+const matchNothingRegexp = "^$"
+
+// NameFilter is the structured name filter of list_directory.
+//
+// Precedenza: se il campo "filter" è presente viene usato e "name_filter" viene ignorato; se è assente
+// si usa "name_filter" (regexp, stringa vuota = nessun filtro) come nelle versioni precedenti. A differenza
+// di name_filter, un filter con pattern vuoto non significa "nessun filtro": dipende dalla modalità.
+type NameFilter struct {
+	Pattern string `json:"pattern"`
+	Mode    string `json:"mode,omitempty"`
+}
+
+// toRegexp converts the filter to the regular expression expected by StorageProvider.ListItems.
+func (f *NameFilter) toRegexp() (string, error) {
+	switch f.Mode {
+	case "", NameFilterModeRegex:
+		if _, err := regexp.Compile(f.Pattern); err != nil {
+			return "", fmt.Errorf("invalid regular expression in filter: %w", err)
+		}
+		return f.Pattern, nil
+	case NameFilterModeContains:
+		return regexp.QuoteMeta(f.Pattern), nil
+	case NameFilterModeExact:
+		if f.Pattern == "" {
+			return matchNothingRegexp, nil
+		}
+		return "^" + regexp.QuoteMeta(f.Pattern) + "$", nil
+	case NameFilterModeGlob:
+		if f.Pattern == "" {
+			return matchNothingRegexp, nil
+		}
+		quoted := regexp.QuoteMeta(f.Pattern)
+		quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+		quoted = strings.ReplaceAll(quoted, `\?`, ".")
+		return "^" + quoted + "$", nil
+	default:
+		return "", fmt.Errorf("unknown filter mode '%s' (expected %s, %s, %s or %s)", f.Mode, NameFilterModeRegex, NameFilterModeGlob, NameFilterModeExact, NameFilterModeContains)
+	}
+}
+
+// resolveNameFilter returns the name filter regexp of a list_directory request, applying the
+// precedence of filter over the legacy name_filter.
+func resolveNameFilter(filter *NameFilter, legacyNameFilter string) (string, error) {
+	if filter == nil {
+		return legacyNameFilter, nil
+	}
+	return filter.toRegexp()
+}
@@ -0,0 +1,288 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+)
+
+// Tipi di notifica.
+const (
+	NotificationUploadCancelled    = "upload_cancelled"
+	NotificationPermissionsChanged = "permissions_changed"
+)
+
+// Notification is an event for a user, kept in the inbox until it is marked as read.
+type Notification struct {
+	ID          int64                  `json:"id"`
+	Type        string                 `json:"type"`
+	StorageName string                 `json:"storage_name,omitempty"`
+	Path        string                 `json:"path,omitempty"`
+	Message     string                 `json:"message"`
+	Details     map[string]interface{} `json:"details,omitempty"`
+	Time        time.Time              `json:"time"`
+}
+
+// notificationStore keeps the unread notifications of every user, bounded like recentErrorStore
+// (le più vecchie oltre maxPerUser o maxAge vengono scartate).
+type notificationStore struct {
+	mu         sync.Mutex
+	byUser     map[string][]Notification
+	latest     map[string]int64 // ID dell'ultima notifica di ogni utente, anche se già letta (cursor del long polling)
+	nextID     int64
+	maxPerUser int
+	maxAge     time.Duration
+}
+
+func newNotificationStore(cfg *config.Config) *notificationStore {
+	maxAge, err := cfg.GetNotificationsMaxAge()
+	if err != nil {
+		log.Printf("Error getting notifications max age from config, using default 7 days: %v", err)
+		maxAge = 7 * 24 * time.Hour
+	}
+	maxPerUser := cfg.Notifications.MaxPerUser
+	if maxPerUser <= 0 {
+		maxPerUser = 100
+	}
+	return &notificationStore{
+		byUser:     make(map[string][]Notification),
+		latest:     make(map[string]int64),
+		maxPerUser: maxPerUser,
+		maxAge:     maxAge,
+	}
+}
+
+// add stores a notification for the user, assigning its ID and time.
+func (s *notificationStore) add(user string, notification Notification) Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	notification.ID = s.nextID
+	notification.Time = time.Now()
+	s.latest[user] = notification.ID
+	entries := append(s.pruneLocked(user, notification.Time), notification)
+	if len(entries) > s.maxPerUser {
+		entries = entries[len(entries)-s.maxPerUser:]
+	}
+	s.byUser[user] = entries
+	return notification
+}
+
+// unread returns the unread notifications of the user with ID greater than since, most recent first.
+func (s *notificationStore) unread(user string, since int64) []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.pruneLocked(user, time.Now())
+	result := make([]Notification, 0, len(entries))
+	for i := len(entries) - 1; i >= 0 && entries[i].ID > since; i-- {
+		result = append(result, entries[i])
+	}
+	return result
+}
+
+// cursor returns the ID of the latest notification of the user (0 se non ne ha mai ricevute). Gli ID
+// crescono sempre, quindi un cursor maggiore dell'ultimo visto indica notifiche arrivate nel frattempo.
+func (s *notificationStore) cursor(user string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest[user]
+}
+
+// markRead removes the notifications of the user with the given IDs (tutte con all) and returns how
+// many were removed and how many remain unread.
+func (s *notificationStore) markRead(user string, ids []int64, all bool) (marked int, remaining int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.pruneLocked(user, time.Now())
+	if all {
+		delete(s.byUser, user)
+		return len(entries), 0
+	}
+	toMark := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		toMark[id] = true
+	}
+	kept := make([]Notification, 0, len(entries))
+	for _, entry := range entries {
+		if toMark[entry.ID] {
+			marked++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if len(kept) == 0 {
+		delete(s.byUser, user)
+	} else {
+		s.byUser[user] = kept
+	}
+	return marked, len(kept)
+}
+
+// pruneLocked drops expired notifications of the user. Must be called with s.mu held.
+func (s *notificationStore) pruneLocked(user string, now time.Time) []Notification {
+	entries := s.byUser[user]
+	firstValid := 0
+	for firstValid < len(entries) && now.Sub(entries[firstValid].Time) > s.maxAge {
+		firstValid++
+	}
+	if firstValid == len(entries) {
+		delete(s.byUser, user)
+		return nil
+	}
+	entries = entries[firstValid:]
+	s.byUser[user] = entries
+	return entries
+}
+
+// pruneAll removes expired notifications of all users.
+func (s *notificationStore) pruneAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for user := range s.byUser {
+		s.pruneLocked(user, now)
+	}
+}
+
+// cleanupNotifications periodically expires old notifications, so that users who never come back
+// don't keep memory allocated.
+func (h *Hub) cleanupNotifications() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.notifications.pruneAll()
+		case <-h.ctx.Done():
+			if config.IsLogLevel(config.LogLevelInfo) {
+				log.Println("Notifications cleanup goroutine context cancelled, stopping.")
+			}
+			return
+		}
+	}
+}
+
+// userNotification is a notification to deliver to the connected clients of a user.
+type userNotification struct {
+	user         string
+	notification Notification
+}
+
+// Notify stores a notification in the inbox of the user (chiave di userKeyFromClaims) and delivers it
+// to the user's connected WebSocket clients. Non va chiamata dalla goroutine Run, che usa deliverNotification.
+func (h *Hub) Notify(user string, notification Notification) {
+	stored := h.notifications.add(user, notification)
+	select {
+	case h.notificationDeliveries <- userNotification{user: user, notification: stored}:
+	case <-h.ctx.Done():
+	}
+}
+
+// notifyFromRun is Notify for the Run goroutine, which owns h.clients.
+func (h *Hub) notifyFromRun(user string, notification Notification) {
+	h.deliverNotification(user, h.notifications.add(user, notification))
+}
+
+// deliverNotification sends a stored notification to the connected clients of the user. Runs in the
+// Run goroutine; i client Long Polling la leggeranno con get_notifications.
+func (h *Hub) deliverNotification(user string, notification Notification) {
+	msg := Message{Type: "notification", Payload: notification}
+	for client := range h.clients {
+		if userKeyFromClaims(client.claims) != user {
+			continue
+		}
+		go func(c *Client) {
+			select {
+			case c.send <- msg:
+			case <-time.After(5 * time.Second):
+				log.Printf("Timeout sending notification %d to client (User: %s)", notification.ID, c.userIdentifier)
+			case <-c.ctx.Done():
+			}
+		}(client)
+	}
+}
+
+// notifyUploadCancelled notifies the owner of an upload that the server cancelled it.
+func (h *Hub) notifyUploadCancelled(sessionState *UploadSessionState, reason string, message string) {
+	h.Notify(userKeyFromClaims(sessionState.Claims), Notification{
+		Type:        NotificationUploadCancelled,
+		StorageName: sessionState.StorageName,
+		Path:        sessionState.ItemPath,
+		Message:     message,
+		Details:     map[string]interface{}{"reason": reason},
+	})
+}
+
+// getNotifications handles get_notifications: the unread notifications of the caller, most recent first.
+// Con "since" (il cursor di una risposta long polling già vista) restituisce solo quelle successive.
+func (h *Hub) getNotifications(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "get_notifications_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		Since int64 `json:"since"`
+	}
+	if msg.Payload != nil {
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for get_notifications: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid get_notifications payload: %w", err)
+		}
+	}
+
+	user := userKeyFromClaims(claims)
+	notifications := h.notifications.unread(user, payload.Since)
+	response.Payload = map[string]interface{}{
+		"notifications": notifications,
+		"unread":        len(notifications),
+		"cursor":        h.notifications.cursor(user),
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("get_notifications_response (User: %s, ReqID: %s): %d unread notifications", userIdentifier, msg.RequestID, len(notifications))
+	}
+	return response, nil
+}
+
+// markNotificationsRead handles mark_notifications_read: removes from the inbox the notifications in
+// ids, or all of them with all: true.
+func (h *Hub) markNotificationsRead(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "mark_notifications_read_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		IDs []int64 `json:"ids"`
+		All bool    `json:"all"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for mark_notifications_read: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid mark_notifications_read payload: %w", err)
+	}
+	if len(payload.IDs) == 0 && !payload.All {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "ids or all is required"}
+		return response, nil
+	}
+
+	marked, remaining := h.notifications.markRead(userKeyFromClaims(claims), payload.IDs, payload.All)
+	response.Payload = map[string]interface{}{
+		"marked": marked,
+		"unread": remaining,
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("mark_notifications_read_response (User: %s, ReqID: %s): %d marked, %d unread", userIdentifier, msg.RequestID, marked, remaining)
+	}
+	return response, nil
+}
@@ -0,0 +1,45 @@
+package websocket
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"clouddav/config"
+)
+
+// checkOrigin is the CheckOrigin of the WebSocket upgrader. Confronta l'header Origin con allowed_origins
+// della configurazione corrente (match esatto o "*"); con la lista vuota accetta solo lo stesso host se
+// l'autenticazione è attiva, qualsiasi origine altrimenti. Le richieste senza Origin (client non browser)
+// sono accettate: il controllo protegge dal cross-site WebSocket hijacking, che richiede un browser.
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	cfg := h.Config()
+	allowed := originAllowed(origin, r.Host, cfg.AllowedOrigins, cfg.EnableAuth)
+	if !allowed && config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("WebSocket upgrade rejected: origin '%s' not allowed for host '%s'", origin, r.Host)
+	}
+	return allowed
+}
+
+// originAllowed reports whether origin may open a WebSocket to host.
+func originAllowed(origin string, host string, allowedOrigins []string, enableAuth bool) bool {
+	if len(allowedOrigins) == 0 {
+		if !enableAuth {
+			return true
+		}
+		originURL, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(originURL.Host, host)
+	}
+	origin = strings.TrimSuffix(origin, "/")
+	for _, allowedOrigin := range allowedOrigins {
+		if allowedOrigin == "*" || strings.EqualFold(strings.TrimSuffix(allowedOrigin, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package websocket
+
+import "clouddav/config"
+
+// clientPathKeys are the payload keys holding a storage path sent by the client.
+var clientPathKeys = []string{"item_path", "dir_path", "path", "source_path", "destination_path", "base_path", "archive_path"}
+
+// normalizePayloadPaths normalizes the paths in the payload of msg with Config.NormalizeStoragePath (backslash,
+// barre ripetute e finali), prima che i path arrivino a validatePath o ai prefissi dei blob.
+// Gli elementi di get_items_info hanno ognuno il proprio storage_name; item_paths (delete_items) usa
+// quello del messaggio.
+func normalizePayloadPaths(payload interface{}, cfg *config.Config) {
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if storageName, ok := fields["storage_name"].(string); ok && storageName != "" {
+		for _, key := range clientPathKeys {
+			if p, ok := fields[key].(string); ok {
+				fields[key] = cfg.NormalizeStoragePath(storageName, p)
+			}
+		}
+		if paths, ok := fields["item_paths"].([]interface{}); ok {
+			for i := range paths {
+				if p, ok := paths[i].(string); ok {
+					paths[i] = cfg.NormalizeStoragePath(storageName, p)
+				}
+			}
+		}
+	}
+	if items, ok := fields["items"].([]interface{}); ok {
+		for _, item := range items {
+			normalizePayloadPaths(item, cfg)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/local"
+)
+
+func TestNormalizePayloadPaths(t *testing.T) {
+	cfg := &config.Config{Storages: []config.StorageConfig{{Name: "data", Type: "local"}, {Name: "other", Type: "local", KeepTrailingSlashes: true}}}
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "root",
+			payload: map[string]interface{}{"storage_name": "data", "dir_path": "//"},
+			want:    map[string]interface{}{"storage_name": "data", "dir_path": "/"},
+		},
+		{
+			name:    "nested paths",
+			payload: map[string]interface{}{"storage_name": "data", "source_path": "/a//b/", "destination_path": "/c/d//"},
+			want:    map[string]interface{}{"storage_name": "data", "source_path": "/a/b", "destination_path": "/c/d"},
+		},
+		{
+			name:    "archive path",
+			payload: map[string]interface{}{"storage_name": "data", "archive_path": "//docs//a.zip", "entry_name": "dir//b.txt"},
+			want:    map[string]interface{}{"storage_name": "data", "archive_path": "/docs/a.zip", "entry_name": "dir//b.txt"},
+		},
+		{
+			name:    "item_paths",
+			payload: map[string]interface{}{"storage_name": "data", "item_paths": []interface{}{"/x/", "//y"}},
+			want:    map[string]interface{}{"storage_name": "data", "item_paths": []interface{}{"/x", "/y"}},
+		},
+		{
+			name: "items with their own storage",
+			payload: map[string]interface{}{"items": []interface{}{
+				map[string]interface{}{"storage_name": "data", "item_path": "/dir/"},
+				map[string]interface{}{"storage_name": "other", "item_path": "//dir//"},
+			}},
+			want: map[string]interface{}{"items": []interface{}{
+				map[string]interface{}{"storage_name": "data", "item_path": "/dir"},
+				map[string]interface{}{"storage_name": "other", "item_path": "/dir/"},
+			}},
+		},
+		{
+			name:    "no storage name",
+			payload: map[string]interface{}{"item_path": "/dir/"},
+			want:    map[string]interface{}{"item_path": "/dir/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizePayloadPaths(tt.payload, cfg)
+			if !reflect.DeepEqual(tt.payload, tt.want) {
+				t.Errorf("payload = %v, want %v", tt.payload, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackslashPathsReachProvider(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs", "2024"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "2024", "report.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	windowsCfg := config.StorageConfig{Name: "windows", Type: "local", NormalizeBackslashes: true}
+	windowsCfg.Path = root
+	plainCfg := config.StorageConfig{Name: "plain", Type: "local"}
+	plainCfg.Path = root
+	cfg := &config.Config{Storages: []config.StorageConfig{windowsCfg, plainCfg}}
+	ctx := context.Background()
+	var providers []storage.StorageProvider
+	for i := range cfg.Storages {
+		provider, err := local.NewProvider(ctx, &cfg.Storages[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+	if err := storage.ReplaceProviders(providers); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, cfg)
+	t.Cleanup(h.cancel)
+
+	tests := []struct {
+		storageName string
+		itemPath    string
+		wantFound   bool
+	}{
+		{"windows", `\docs\2024\report.json`, true},
+		{"windows", `/docs\2024/report.json`, true},
+		{"windows", `\docs/2024\\report.json`, true},
+		{"windows", "/docs/2024/report.json", true},
+		{"plain", `/docs\2024/report.json`, false},
+		{"plain", "/docs/2024/report.json", true},
+	}
+	for _, tt := range tests {
+		msg := &Message{Type: "read_file", RequestID: "r1", Payload: map[string]interface{}{"storage_name": tt.storageName, "item_path": tt.itemPath}}
+		response, err := h.handleClientMessage(ctx, msg, nil)
+		if err != nil {
+			t.Fatalf("read_file %s:%s: %v", tt.storageName, tt.itemPath, err)
+		}
+		if found := response.Type == "read_file_response" && response.Payload == "{}"; found != tt.wantFound {
+			t.Errorf("read_file %s:%s = %s %v, want found %t", tt.storageName, tt.itemPath, response.Type, response.Payload, tt.wantFound)
+		}
+	}
+
+	msg := &Message{Type: "create_directory", RequestID: "r2", Payload: map[string]interface{}{"storage_name": "windows", "dir_path": `\docs\new`}}
+	if response, err := h.handleClientMessage(ctx, msg, nil); err != nil || response.Type != "create_directory_response" {
+		t.Fatalf("create_directory = %s %v, %v", response.Type, response.Payload, err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "docs", "new")); err != nil || !info.IsDir() {
+		t.Errorf("create_directory with backslashes did not create docs/new: %v", err)
+	}
+}
+
+func TestBackslashArchivePathReachesProvider(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.Create(filepath.Join(root, "docs", "a.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(archive)
+	entry, err := zw.Create("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write([]byte("entry")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	windowsCfg := config.StorageConfig{Name: "windows", Type: "local", NormalizeBackslashes: true}
+	windowsCfg.Path = root
+	cfg := &config.Config{Storages: []config.StorageConfig{windowsCfg}}
+	ctx := context.Background()
+	provider, err := local.NewProvider(ctx, &cfg.Storages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, cfg)
+	t.Cleanup(h.cancel)
+
+	msg := &Message{Type: "extract_archive_entry", RequestID: "r1", Payload: map[string]interface{}{"storage_name": "windows", "archive_path": `\docs\a.zip\`, "entry_name": "b.txt"}}
+	response, err := h.handleClientMessage(ctx, msg, nil)
+	if err != nil {
+		t.Fatalf("extract_archive_entry: %v", err)
+	}
+	payload, ok := response.Payload.(map[string]interface{})
+	if response.Type != "extract_archive_entry_response" || !ok {
+		t.Fatalf("extract_archive_entry = %s %v, want extract_archive_entry_response", response.Type, response.Payload)
+	}
+	if payload["archive_path"] != "/docs/a.zip" || payload["data"] != base64.StdEncoding.EncodeToString([]byte("entry")) {
+		t.Errorf("extract_archive_entry = archive_path %v, data %v; want /docs/a.zip and the entry content", payload["archive_path"], payload["data"])
+	}
+}
@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"context"
+
+	"clouddav/auth"
+	"clouddav/storage"
+	"clouddav/storage/azureblob"
+)
+
+// deleteItemIfUnchanged deletes itemPath only if it still matches precondition (expected_mod_time,
+// expected_etag di delete_item). Per i blob Azure il controllo è atomico (If-Match sull'ETag letto dalla
+// verifica); per gli altri storage e per le directory virtuali resta una breve finestra tra la verifica e
+// DeleteItem.
+func deleteItemIfUnchanged(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, storageName, itemPath string, precondition storage.ItemPrecondition) error {
+	item, err := storage.CheckItemPrecondition(ctx, provider, claims, itemPath, precondition)
+	if err != nil {
+		return err
+	}
+	p, isAzure := storage.Unwrap(provider).(*azureblob.AzureBlobStorageProvider)
+	if !isAzure || item.IsDir || item.ETag == "" {
+		return provider.DeleteItem(ctx, claims, itemPath)
+	}
+	// DeleteBlobIfMatch non fa parte dell'interfaccia: slot di max_concurrent_operations e change log vanno gestiti qui.
+	releaseSlot, err := storage.AcquireSlot(ctx, provider)
+	if err != nil {
+		return err
+	}
+	err = p.DeleteBlobIfMatch(ctx, claims, itemPath, item.ETag)
+	releaseSlot()
+	if err != nil {
+		return err
+	}
+	storage.RecordChange(storageName, storage.ChangeDeleted, itemPath)
+	return nil
+}
@@ -0,0 +1,99 @@
+package websocket
+
+// ProtocolVersion is the version of the client/server message protocol.
+// Va incrementata ogni volta che cambia l'insieme dei messaggi o delle azioni di upload,
+// così i client possono rilevare le funzionalità disponibili senza tentativi.
+const ProtocolVersion = 29
+
+// supportedMessageTypes lists the client message types handled by handleClientMessage.
+var supportedMessageTypes = []string{
+	"get_filesystems",
+	"root_counts",
+	"list_directory",
+	"list_directory_recursive",
+	"count_items",
+	"read_file",
+	"read_file_stream",
+	"read_file_lines",
+	"list_archive",
+	"extract_archive_entry",
+	"search",
+	"get_directory_size",
+	"directory_stats",
+	"get_manifest",
+	"create_directory",
+	"delete_item",
+	"delete_items",
+	"list_trash",
+	"restore_item",
+	"empty_trash",
+	"move_item",
+	"copy_item",
+	"check_directory_contents_request",
+	"get_items_info",
+	"get_item_metadata",
+	"compute_hash",
+	"my_recent_errors",
+	"get_notifications",
+	"mark_notifications_read",
+	"explain_access",
+	"my_permissions",
+	"cancel_all_uploads",
+	"upload_eta",
+	"protocol_info",
+	"server_status",
+	"list_sessions",
+	"disconnect_session",
+	"set_active_directory",
+	"ping",
+}
+
+// messageTypeLabel returns the message type used as metric label: i tipi non supportati sono
+// raggruppati in "unknown", così un client non può creare serie arbitrarie.
+func messageTypeLabel(messageType string) string {
+	for _, supported := range supportedMessageTypes {
+		if messageType == supported {
+			return messageType
+		}
+	}
+	return "unknown"
+}
+
+// uploadActions lists the actions accepted by the HTTP /upload endpoint (handlers.handleUpload).
+var uploadActions = []string{
+	"initiate",
+	"chunk",
+	"finalize",
+	"cancel",
+	"status",
+}
+
+// deprecatedMessageTypes maps deprecated message types or upload actions to a short note on what to use instead.
+var deprecatedMessageTypes = map[string]string{}
+
+// ProtocolInfo describes the protocol supported by the server, as returned by protocol_info
+// and included in the initial config_update.
+type ProtocolInfo struct {
+	Version       int               `json:"version"`
+	MessageTypes  []string          `json:"message_types"`
+	UploadActions []string          `json:"upload_actions"`
+	Deprecated    map[string]string `json:"deprecated"`
+}
+
+func currentProtocolInfo() ProtocolInfo {
+	return ProtocolInfo{
+		Version:       ProtocolVersion,
+		MessageTypes:  supportedMessageTypes,
+		UploadActions: uploadActions,
+		Deprecated:    deprecatedMessageTypes,
+	}
+}
+
+// initialConfigPayload is the payload of the config_update message sent right after a client connects.
+func (h *Hub) initialConfigPayload() map[string]interface{} {
+	return map[string]interface{}{
+		"client_ping_interval_ms": h.Config().ClientPingIntervalMs,
+		"next_poll_after_ms":      h.nextPollAfterMs(),
+		"protocol":                currentProtocolInfo(),
+	}
+}
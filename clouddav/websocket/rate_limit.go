@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/metrics"
+	"clouddav/internal/ratelimit"
+)
+
+// rateLimitExemptMessages are not counted by rate_limit_per_minute: il ping serve solo a tenere viva la
+// connessione e non raggiunge gli storage.
+var rateLimitExemptMessages = map[string]bool{
+	"ping": true,
+}
+
+// remoteAddressKey is the context key of the address of the client that sent the message being processed.
+type remoteAddressKey struct{}
+
+func withRemoteAddress(ctx context.Context, remoteAddr string) context.Context {
+	return context.WithValue(ctx, remoteAddressKey{}, remoteAddr)
+}
+
+// rateLimitMessage applies rate_limit_per_minute to a message of the user, con lo stesso bucket delle
+// richieste HTTP (per i client anonimi, quello del loro indirizzo). Se il limite è superato restituisce
+// il payload di errore da inviare al client.
+func (h *Hub) rateLimitMessage(ctx context.Context, claims *auth.UserClaims, msg *Message) (map[string]interface{}, bool) {
+	cfg := h.Config()
+	if cfg.RateLimitPerMinute <= 0 || rateLimitExemptMessages[msg.Type] {
+		return nil, false
+	}
+	var email string
+	if claims != nil {
+		email = claims.Email
+	}
+	remoteAddr, _ := ctx.Value(remoteAddressKey{}).(string)
+	user := ratelimit.Key(email, remoteAddr)
+	allowed, retryAfter := ratelimit.Allow(user, cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+	if allowed {
+		return nil, false
+	}
+	metrics.RateLimited.Inc("message")
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Message %s (ReqID: %s) of user '%s' rejected: rate_limit_per_minute reached", msg.Type, msg.RequestID, user)
+	}
+	return map[string]interface{}{
+		"error":          "Too many requests, retry later",
+		"error_code":     "RATE_LIMITED",
+		"retry_after_ms": retryAfter.Milliseconds(),
+	}, true
+}
+
+// cleanupRateLimiters periodically drops the per-user buckets that have refilled completely.
+func (h *Hub) cleanupRateLimiters() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if removed := ratelimit.Prune(); removed > 0 && config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("[DEBUG] Rate limit cleanup: removed %d idle buckets", removed)
+			}
+		case <-h.ctx.Done():
+			if config.IsLogLevel(config.LogLevelInfo) {
+				log.Println("Rate limiters cleanup goroutine context cancelled, stopping.")
+			}
+			return
+		}
+	}
+}
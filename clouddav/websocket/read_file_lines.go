@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// readFileLinesMaxLines is the maximum number of lines a read_file_lines request can ask for.
+	readFileLinesMaxLines = 1000
+	// readFileLinesMaxBytes is the maximum number of bytes of the file scanned by read_file_lines:
+	// oltre questo limite la lettura si ferma e has_more è true.
+	readFileLinesMaxBytes = 1024 * 1024
+	// readFileLinesBlockSize is the size of the blocks read backward from the end of the file in tail mode.
+	readFileLinesBlockSize = 64 * 1024
+)
+
+// readFileLines handles read_file_lines: returns the first (mode "head") or last (mode "tail") N lines
+// of a text file, senza scaricare l'intero file. has_more indica se il file contiene altre righe
+// oltre a quelle restituite.
+func (h *Hub) readFileLines(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "read_file_lines_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		ItemPath    string `json:"item_path"`
+		Mode        string `json:"mode"`
+		Lines       int    `json:"lines"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for read_file_lines: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid read_file_lines payload: %w", err)
+	}
+
+	if payload.Mode != "head" && payload.Mode != "tail" {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "Invalid mode: must be 'head' or 'tail'"}
+		return response, nil
+	}
+	if payload.Lines <= 0 || payload.Lines > readFileLinesMaxLines {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid lines: must be between 1 and %d", readFileLinesMaxLines)}
+		return response, nil
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ItemPath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for read_file_lines: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	var lines []string
+	var hasMore bool
+	if payload.Mode == "head" {
+		lines, hasMore, err = readHeadLines(ctx, provider, claims, payload.ItemPath, payload.Lines)
+	} else {
+		lines, hasMore, err = readTailLines(ctx, provider, claims, payload.ItemPath, payload.Lines)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Item not found"}
+		} else if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+		} else if errors.Is(err, storage.ErrIsDirectory) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Cannot read a directory", "error_code": "IS_A_DIRECTORY"}
+		} else if errors.Is(err, storage.ErrIsSymlink) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Cannot read a symbolic link: follow_symlinks is disabled", "error_code": "IS_A_SYMLINK"}
+		} else {
+			if ctx.Err() != nil {
+				return response, ctx.Err()
+			}
+			return response, fmt.Errorf("error reading lines of '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
+		}
+		return response, nil
+	}
+
+	response.Payload = map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"item_path":    payload.ItemPath,
+		"mode":         payload.Mode,
+		"lines":        lines,
+		"has_more":     hasMore,
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("read_file_lines_response (User: %s, ReqID: %s): Read %d lines (%s, has_more %t) from %s/%s", userIdentifier, msg.RequestID, len(lines), payload.Mode, hasMore, payload.StorageName, payload.ItemPath)
+	}
+	return response, nil
+}
+
+// readHeadLines returns the first n lines of the file, reading at most readFileLinesMaxBytes bytes.
+func readHeadLines(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, itemPath string, n int) ([]string, bool, error) {
+	reader, err := provider.OpenReader(ctx, claims, itemPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+
+	limited := &io.LimitedReader{R: reader, N: readFileLinesMaxBytes}
+	scanner := bufio.NewScanner(limited)
+	scanner.Buffer(make([]byte, 0, 64*1024), readFileLinesMaxBytes+1) // Una riga non supera mai il limite di lettura
+	lines := make([]string, 0, n)
+	for len(lines) < n && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	if scanner.Scan() {
+		return lines, true, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	if limited.N > 0 {
+		return lines, false, nil
+	}
+	// Limite raggiunto: l'ultima riga può essere parziale, il file continua se c'è almeno un altro byte.
+	var probe [1]byte
+	probeN, _ := reader.Read(probe[:])
+	return lines, probeN > 0, nil
+}
+
+// readTailLines returns the last n lines of the file, reading backward from the end in blocks of
+// readFileLinesBlockSize and scanning at most readFileLinesMaxBytes bytes.
+func readTailLines(ctx context.Context, provider storage.StorageProvider, claims *auth.UserClaims, itemPath string, n int) ([]string, bool, error) {
+	reader, err := provider.OpenReaderAt(ctx, claims, itemPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+
+	size := reader.Size()
+	offset := size
+	var data []byte
+	newlines := 0 // Newline in data, escluso quello finale del file
+	for offset > 0 && newlines < n && size-offset < readFileLinesMaxBytes {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		blockSize := int64(readFileLinesBlockSize)
+		if blockSize > offset {
+			blockSize = offset
+		}
+		if remaining := readFileLinesMaxBytes - (size - offset); blockSize > remaining {
+			blockSize = remaining
+		}
+		block := make([]byte, blockSize)
+		if _, err := reader.ReadAt(block, offset-blockSize); err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		offset -= blockSize
+		data = append(block, data...)
+
+		newlines = bytes.Count(data, []byte{'\n'})
+		if bytes.HasSuffix(data, []byte{'\n'}) {
+			newlines--
+		}
+	}
+
+	data = bytes.TrimSuffix(data, []byte{'\n'})
+	if len(data) == 0 {
+		return []string{}, offset > 0, nil
+	}
+	parts := strings.Split(string(data), "\n")
+	hasMore := offset > 0
+	if offset > 0 && len(parts) > 1 {
+		parts = parts[1:] // La prima riga letta è parziale: inizia prima dell'offset raggiunto
+	}
+	if len(parts) > n {
+		parts = parts[len(parts)-n:]
+		hasMore = true
+	}
+	for i, line := range parts {
+		parts[i] = strings.TrimSuffix(line, "\r")
+	}
+	return parts, hasMore, nil
+}
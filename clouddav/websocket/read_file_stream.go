@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+// readFileStreamChunkSize is the size of the buffer read from the provider for each read_file_chunk.
+const readFileStreamChunkSize = 64 * 1024
+
+// messageSenderKey is the context key of the function that queues additional messages to a WebSocket
+// client while a request is being processed (es. i read_file_chunk di read_file_stream).
+type messageSenderKey struct{}
+
+// messageSender queues a message to the client, blocking until it is queued or ctx is done.
+type messageSender func(ctx context.Context, msg Message) error
+
+func withMessageSender(ctx context.Context, send messageSender) context.Context {
+	return context.WithValue(ctx, messageSenderKey{}, send)
+}
+
+func messageSenderFrom(ctx context.Context) (messageSender, bool) {
+	send, ok := ctx.Value(messageSenderKey{}).(messageSender)
+	return send, ok
+}
+
+// readFileStream handles read_file_stream: the content is sent as read_file_chunk messages
+// ({seq, data} con data in base64) and the returned response is read_file_end ({total_bytes, chunks}).
+// Solo su WebSocket: il long polling non può inviare più messaggi per una richiesta.
+func (h *Hub) readFileStream(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "read_file_end", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		ItemPath    string `json:"item_path"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for read_file_stream: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid read_file_stream payload: %w", err)
+	}
+
+	send, ok := messageSenderFrom(ctx)
+	if !ok {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "read_file_stream requires a WebSocket connection, use read_file instead"}
+		return response, nil
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ItemPath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for read_file_stream: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	reader, err := provider.OpenReader(ctx, claims, payload.ItemPath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Item not found"}
+		} else if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+		} else if errors.Is(err, storage.ErrIsDirectory) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Cannot read a directory", "error_code": "IS_A_DIRECTORY"}
+		} else if errors.Is(err, storage.ErrIsSymlink) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Cannot read a symbolic link: follow_symlinks is disabled", "error_code": "IS_A_SYMLINK"}
+		} else {
+			return response, fmt.Errorf("error opening item '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
+		}
+		return response, nil
+	}
+	defer reader.Close()
+
+	buf := make([]byte, readFileStreamChunkSize)
+	var totalBytes int64
+	seq := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			if config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("Context cancelled during read_file_stream of '%s/%s' after %d chunks (User: %s, ReqID: %s): %v", payload.StorageName, payload.ItemPath, seq, userIdentifier, msg.RequestID, err)
+			}
+			return response, err
+		}
+
+		n, readErr := io.ReadFull(reader, buf)
+		if n > 0 {
+			chunk := Message{
+				Type:      "read_file_chunk",
+				RequestID: msg.RequestID,
+				Payload: map[string]interface{}{
+					"seq":  seq,
+					"data": base64.StdEncoding.EncodeToString(buf[:n]),
+				},
+			}
+			if err := send(ctx, chunk); err != nil {
+				return response, err
+			}
+			seq++
+			totalBytes += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			if ctx.Err() != nil {
+				return response, ctx.Err()
+			}
+			return response, fmt.Errorf("error reading item content '%s/%s' after %d bytes (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, totalBytes, userIdentifier, msg.RequestID, readErr)
+		}
+	}
+
+	response.Payload = map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"item_path":    payload.ItemPath,
+		"total_bytes":  totalBytes,
+		"chunks":       seq,
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("read_file_stream (User: %s, ReqID: %s): Sent %d bytes in %d chunks from %s/%s", userIdentifier, msg.RequestID, totalBytes, seq, payload.StorageName, payload.ItemPath)
+	}
+	return response, nil
+}
+
+// queueMessage queues a message on the client's send channel (vedi messageSender).
+func (c *Client) queueMessage(ctx context.Context, msg Message) error {
+	select {
+	case c.send <- msg:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/memory"
+)
+
+func TestReadFileOnDirectory(t *testing.T) {
+	storageCfg := config.StorageConfig{Name: "mem", Type: "memory"}
+	cfg := &config.Config{Storages: []config.StorageConfig{storageCfg}}
+	ctx := context.Background()
+	provider, err := memory.NewProvider(ctx, &storageCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.CreateDirectory(ctx, nil, "/docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, cfg)
+	t.Cleanup(h.cancel)
+
+	for _, itemPath := range []string{"/docs", "/"} {
+		msg := &Message{Type: "read_file", RequestID: "r1", Payload: map[string]interface{}{"storage_name": "mem", "item_path": itemPath}}
+		response, err := h.handleClientMessage(ctx, msg, nil)
+		if err != nil {
+			t.Fatalf("read_file %s: %v", itemPath, err)
+		}
+		payload, _ := response.Payload.(map[string]string)
+		if response.Type != "error" || payload["error_code"] != "IS_A_DIRECTORY" {
+			t.Errorf("read_file %s = %s %v, want an IS_A_DIRECTORY error", itemPath, response.Type, response.Payload)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+)
+
+// RecentError describes a failed operation of a user, as returned by my_recent_errors.
+// Message è il testo fisso associato a ErrorCode: il testo originale dell'errore (percorsi interni,
+// dettagli dello storage) resta solo nel log del server.
+type RecentError struct {
+	Type        string    `json:"type"`
+	StorageName string    `json:"storage_name,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	ErrorCode   string    `json:"error_code"`
+	Message     string    `json:"message"`
+	Time        time.Time `json:"time"`
+}
+
+// recentErrorStore keeps a bounded ring buffer of recent failed operations per user.
+type recentErrorStore struct {
+	mu         sync.Mutex
+	byUser     map[string][]RecentError
+	maxPerUser int
+	maxAge     time.Duration
+}
+
+func newRecentErrorStore(cfg *config.Config) *recentErrorStore {
+	maxAge, err := cfg.GetRecentErrorsMaxAge()
+	if err != nil {
+		log.Printf("Error getting recent errors max age from config, using default 1 hour: %v", err)
+		maxAge = time.Hour
+	}
+	maxPerUser := cfg.RecentErrors.MaxPerUser
+	if maxPerUser <= 0 {
+		maxPerUser = 50
+	}
+	return &recentErrorStore{
+		byUser:     make(map[string][]RecentError),
+		maxPerUser: maxPerUser,
+		maxAge:     maxAge,
+	}
+}
+
+func (s *recentErrorStore) add(user string, entry RecentError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := append(s.pruneLocked(user, entry.Time), entry)
+	if len(entries) > s.maxPerUser {
+		entries = entries[len(entries)-s.maxPerUser:]
+	}
+	s.byUser[user] = entries
+}
+
+// list returns the non-expired errors of the user, most recent first.
+func (s *recentErrorStore) list(user string) []RecentError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.pruneLocked(user, time.Now())
+	result := make([]RecentError, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		result = append(result, entries[i])
+	}
+	return result
+}
+
+// pruneLocked drops expired entries of the user. Must be called with s.mu held.
+func (s *recentErrorStore) pruneLocked(user string, now time.Time) []RecentError {
+	entries := s.byUser[user]
+	firstValid := 0
+	for firstValid < len(entries) && now.Sub(entries[firstValid].Time) > s.maxAge {
+		firstValid++
+	}
+	if firstValid == len(entries) {
+		delete(s.byUser, user)
+		return nil
+	}
+	entries = entries[firstValid:]
+	s.byUser[user] = entries
+	return entries
+}
+
+// pruneAll removes expired entries of all users, so that idle users don't keep memory allocated.
+func (s *recentErrorStore) pruneAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for user := range s.byUser {
+		s.pruneLocked(user, now)
+	}
+}
+
+// cleanupRecentErrors periodically expires old entries of the recent errors buffer.
+func (h *Hub) cleanupRecentErrors() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.recentErrors.pruneAll()
+		case <-h.ctx.Done():
+			if config.IsLogLevel(config.LogLevelInfo) {
+				log.Println("Recent errors cleanup goroutine context cancelled, stopping.")
+			}
+			return
+		}
+	}
+}
+
+// userKeyFromClaims returns the key identifying a user in per-user state (recent errors, uploads).
+func userKeyFromClaims(claims *auth.UserClaims) string {
+	if claims != nil && claims.Email != "" {
+		return claims.Email
+	}
+	return "anonymous"
+}
+
+// RecordError stores a failed operation in the recent errors buffer of the user.
+// È esportata perché anche gli handler HTTP (download/upload) registrano i propri errori.
+func (h *Hub) RecordError(claims *auth.UserClaims, opType string, storageName string, itemPath string, err error) {
+	if err == nil {
+		return
+	}
+	user := userKeyFromClaims(claims)
+	code := errorCode(err)
+	log.Printf("Recorded %s error for user '%s' (%s, storage '%s', path '%s'): %v", code, user, opType, storageName, itemPath, err)
+	h.recentErrors.add(user, RecentError{
+		Type:        opType,
+		StorageName: storageName,
+		Path:        itemPath,
+		ErrorCode:   code,
+		Message:     errorCodeMessage(code),
+		Time:        time.Now(),
+	})
+}
+
+// recordMessageError records a failed client message, either because handleClientMessage returned
+// an error or because it answered with an "error" response.
+func (h *Hub) recordMessageError(claims *auth.UserClaims, msg *Message, response Message, processErr error) {
+	err := processErr
+	if err == nil {
+		if response.Type != "error" {
+			return
+		}
+		err = errors.New(responseErrorText(response))
+	}
+	var storageName, itemPath string
+	if payload, ok := msg.Payload.(map[string]interface{}); ok {
+		storageName, _ = payload["storage_name"].(string)
+		for _, key := range []string{"item_path", "dir_path", "path", "source_path", "base_path"} {
+			if p, ok := payload[key].(string); ok && p != "" {
+				itemPath = p
+				break
+			}
+		}
+	}
+	h.RecordError(claims, msg.Type, storageName, itemPath, err)
+}
+
+func responseErrorText(response Message) string {
+	switch p := response.Payload.(type) {
+	case map[string]string:
+		return p["error"]
+	case map[string]interface{}:
+		if s, ok := p["error"].(string); ok {
+			return s
+		}
+	}
+	return "unknown error"
+}
+
+// errorCodeMessages are the user-facing messages of the error codes returned by errorCode.
+var errorCodeMessages = map[string]string{
+	"not_found":              "The item was not found.",
+	"permission_denied":      "You do not have permission for this operation.",
+	"already_exists":         "An item with the same name already exists.",
+	"not_implemented":        "This operation is not supported by the storage.",
+	"integrity_check_failed": "The file content did not match its expected checksum.",
+	"size_exceeded":          "The file is larger than allowed.",
+	"size_mismatch":          "The file size does not match the declared size.",
+	"is_a_directory":         "The operation is not allowed on a directory.",
+	"is_a_symlink":           "The operation is not allowed on a symbolic link.",
+	"cancelled":              "The operation was cancelled or timed out.",
+	"internal_error":         "The operation failed because of a server error.",
+}
+
+// errorCodeMessage returns the user-facing message of an error code.
+func errorCodeMessage(code string) string {
+	if message, ok := errorCodeMessages[code]; ok {
+		return message
+	}
+	return errorCodeMessages["internal_error"]
+}
+
+// errorCode maps an error to a short, stable code for the client.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, storage.ErrPermissionDenied):
+		return "permission_denied"
+	case errors.Is(err, storage.ErrAlreadyExists):
+		return "already_exists"
+	case errors.Is(err, storage.ErrNotImplemented):
+		return "not_implemented"
+	case errors.Is(err, storage.ErrIntegrityCheckFailed):
+		return "integrity_check_failed"
+	case errors.Is(err, storage.ErrSizeExceeded):
+		return "size_exceeded"
+	case errors.Is(err, storage.ErrSizeMismatch):
+		return "size_mismatch"
+	case errors.Is(err, storage.ErrIsDirectory):
+		return "is_a_directory"
+	case errors.Is(err, storage.ErrIsSymlink):
+		return "is_a_symlink"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "cancelled"
+	}
+	// Le risposte "error" degli handler contengono solo il testo del messaggio.
+	text := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(text, "access denied"):
+		return "permission_denied"
+	case strings.Contains(text, "not found"):
+		return "not_found"
+	case strings.Contains(text, "already exists"):
+		return "already_exists"
+	case strings.Contains(text, "not supported"):
+		return "not_implemented"
+	case strings.Contains(text, "cannot read a directory"):
+		return "is_a_directory"
+	}
+	return "internal_error"
+}
@@ -0,0 +1,46 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/storage"
+)
+
+func TestRecordErrorHidesRawErrorText(t *testing.T) {
+	h := &Hub{recentErrors: &recentErrorStore{byUser: make(map[string][]RecentError), maxPerUser: 10, maxAge: time.Hour}}
+	claims := &auth.UserClaims{Email: "user@example.com"}
+
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{fmt.Errorf("open /srv/data/secret/report.txt: %w", storage.ErrNotFound), "not_found"},
+		{fmt.Errorf("blob 'tenant/x' on account 'acct': %w", storage.ErrPermissionDenied), "permission_denied"},
+		{errors.New("pq: connection refused at 10.0.0.5:5432"), "internal_error"},
+	}
+	for _, tt := range tests {
+		h.RecordError(claims, "download", "data", "/report.txt", tt.err)
+	}
+
+	entries := h.recentErrors.list(userKeyFromClaims(claims))
+	if len(entries) != len(tests) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(tests))
+	}
+	for i, tt := range tests {
+		entry := entries[len(entries)-1-i] // list restituisce prima il più recente
+		if entry.ErrorCode != tt.code {
+			t.Errorf("ErrorCode = %q, want %q", entry.ErrorCode, tt.code)
+		}
+		if entry.Message != errorCodeMessage(tt.code) {
+			t.Errorf("Message = %q, want the fixed message of %q", entry.Message, tt.code)
+		}
+		if strings.Contains(entry.Message, tt.err.Error()) || strings.Contains(entry.Message, "/srv") {
+			t.Errorf("Message %q exposes the raw error", entry.Message)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// rootCountsCacheTTL is how long the child count of a storage root is reused by root_counts.
+	rootCountsCacheTTL = 15 * time.Second
+	// rootCountProbeTimeout bounds the listing probe of a single storage.
+	rootCountProbeTimeout = 10 * time.Second
+)
+
+// rootCount is the entry of a storage in a root_counts response.
+type rootCount struct {
+	StorageName string `json:"storage_name"`
+	ChildCount  int    `json:"child_count"`
+	NonEmpty    bool   `json:"non_empty"`
+	Cached      bool   `json:"cached"`
+	Error       string `json:"error,omitempty"`
+}
+
+// rootCountsCache caches the child count of each storage root. Il conteggio non dipende dall'utente:
+// l'accesso viene verificato per ogni richiesta prima di leggere la cache.
+type rootCountsCache struct {
+	mu      sync.Mutex
+	entries map[string]rootCountsCacheEntry
+}
+
+type rootCountsCacheEntry struct {
+	childCount int
+	cachedAt   time.Time
+}
+
+func newRootCountsCache() *rootCountsCache {
+	return &rootCountsCache{entries: make(map[string]rootCountsCacheEntry)}
+}
+
+func (c *rootCountsCache) get(storageName string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[storageName]
+	if !ok || time.Since(entry.cachedAt) > rootCountsCacheTTL {
+		return 0, false
+	}
+	return entry.childCount, true
+}
+
+func (c *rootCountsCache) put(storageName string, childCount int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[storageName] = rootCountsCacheEntry{childCount: childCount, cachedAt: time.Now()}
+}
+
+// rootCounts returns the number of items directly under the root of each storage the user can read,
+// probing the storages concurrently. Gli storage senza permesso di lettura vengono omessi.
+func (h *Hub) rootCounts(ctx context.Context, claims *auth.UserClaims) []rootCount {
+	var readable []string
+	for _, storageCfg := range authz.GetAccessibleStorages(ctx, claims, h.Config()) {
+		if err := authz.CheckStorageAccess(ctx, claims, storageCfg.Name, "", "read", h.Config()); err == nil {
+			readable = append(readable, storageCfg.Name)
+		}
+	}
+
+	results := make([]rootCount, len(readable))
+	var wg sync.WaitGroup
+	for i, storageName := range readable {
+		wg.Add(1)
+		go func(i int, storageName string) {
+			defer wg.Done()
+			results[i] = h.rootCount(ctx, claims, storageName)
+		}(i, storageName)
+	}
+	wg.Wait()
+	return results
+}
+
+// rootCount returns the child count of a storage root, from the cache or with a one-item listing.
+func (h *Hub) rootCount(ctx context.Context, claims *auth.UserClaims, storageName string) rootCount {
+	result := rootCount{StorageName: storageName}
+	if childCount, ok := h.rootCountsCache.get(storageName); ok {
+		result.ChildCount, result.NonEmpty, result.Cached = childCount, childCount > 0, true
+		return result
+	}
+
+	provider, ok := storage.GetProvider(storageName)
+	if !ok {
+		result.Error = "storage provider not found"
+		return result
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, rootCountProbeTimeout)
+	defer cancel()
+	listResponse, err := provider.ListItems(probeCtx, claims, "", 1, 1, "", nil, false, false)
+	if err != nil {
+		log.Printf("Warning: root_counts probe failed for storage '%s': %v", storageName, err)
+		result.Error = err.Error()
+		return result
+	}
+	h.rootCountsCache.put(storageName, listResponse.TotalItems)
+	result.ChildCount, result.NonEmpty = listResponse.TotalItems, listResponse.TotalItems > 0
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("[DEBUG] root_counts: Storage '%s' has %d items at the root", storageName, listResponse.TotalItems)
+	}
+	return result
+}
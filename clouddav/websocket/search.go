@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+)
+
+const (
+	// searchDefaultMaxResults is the number of results returned when max_results is not set.
+	searchDefaultMaxResults = 100
+	// searchMaxResults is the upper bound of max_results: i risultati sono tenuti in memoria e inviati
+	// in un unico messaggio.
+	searchMaxResults = 1000
+)
+
+// search handles search: finds recursively under base_path the files and directories whose name matches
+// name_pattern, optionally modified within (modified_after, modified_before]. truncated è true se esistono altri risultati oltre a max_results.
+func (h *Hub) search(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "search_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		BasePath    string `json:"base_path"`
+		NamePattern string `json:"name_pattern"`
+		MaxResults  int    `json:"max_results"`
+		// Filtro sulla data di modifica (RFC 3339): modified_after esclusivo, modified_before inclusivo
+		ModifiedAfter  string `json:"modified_after,omitempty"`
+		ModifiedBefore string `json:"modified_before,omitempty"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for search: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid search payload: %w", err)
+	}
+
+	if payload.NamePattern == "" {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "name_pattern is required"}
+		return response, nil
+	}
+	if _, err := regexp.Compile(payload.NamePattern); err != nil {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid name_pattern: %v", err)}
+		return response, nil
+	}
+	modTimeRange, err := parseModTimeRange(payload.ModifiedAfter, payload.ModifiedBefore, "")
+	if err != nil {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": err.Error(), "error_code": "INVALID_DATE_RANGE"}
+		return response, nil
+	}
+	if payload.MaxResults == 0 {
+		payload.MaxResults = searchDefaultMaxResults
+	}
+	if payload.MaxResults < 0 || payload.MaxResults > searchMaxResults {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Invalid max_results: must be between 1 and %d", searchMaxResults)}
+		return response, nil
+	}
+	if payload.BasePath == "" {
+		payload.BasePath = "/"
+	}
+
+	if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.BasePath, "read", h.Config()); err != nil {
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			return response, nil
+		}
+		return response, fmt.Errorf("error checking storage access for search: %w", err)
+	}
+
+	provider, ok := storage.GetProvider(payload.StorageName)
+	if !ok {
+		return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+	}
+
+	// Un risultato in più del limite dice se la ricerca è stata troncata.
+	items, err := provider.Search(ctx, claims, payload.BasePath, payload.NamePattern, modTimeRange, payload.MaxResults+1)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Base path not found"}
+		} else if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: read permission required"}
+		} else {
+			if ctx.Err() != nil {
+				return response, ctx.Err()
+			}
+			return response, fmt.Errorf("error searching '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.BasePath, userIdentifier, msg.RequestID, err)
+		}
+		return response, nil
+	}
+	truncated := len(items) > payload.MaxResults
+	if truncated {
+		items = items[:payload.MaxResults]
+	}
+
+	response.Payload = map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"base_path":    payload.BasePath,
+		"items":        items,
+		"truncated":    truncated,
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("search_response (User: %s, ReqID: %s): Found %d items (truncated %t) for '%s' under %s/%s", userIdentifier, msg.RequestID, len(items), truncated, payload.NamePattern, payload.StorageName, payload.BasePath)
+	}
+	return response, nil
+}
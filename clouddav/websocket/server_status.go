@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"sync/atomic"
+
+	"clouddav/auth"
+	"clouddav/internal/metrics"
+)
+
+// Load levels reported by server_status.
+const (
+	LoadLevelOK         = "ok"         // Nessun limite vicino
+	LoadLevelBusy       = "busy"       // Oltre il 75% di un limite: rallentare le operazioni in background
+	LoadLevelOverloaded = "overloaded" // Limite raggiunto: sospendere le operazioni non urgenti
+)
+
+const (
+	loadBusyThreshold      = 0.75
+	busyRetryAfterMs       = 1000
+	overloadedRetryAfterMs = 5000
+)
+
+// loadGauges are the counters read by server_status. Vengono aggiornati dove cambia lo stato
+// (registrazione dei client, OngoingFileUploads, elaborazione dei messaggi) così server_status
+// legge solo valori atomici senza prendere i lock del Hub.
+type loadGauges struct {
+	activeClients    atomic.Int64
+	ongoingUploads   atomic.Int64
+	inFlightRequests atomic.Int64 // Messaggi WS/LP in elaborazione in handleClientMessage
+}
+
+// serverStatus is the payload of a server_status response.
+type serverStatus struct {
+	ActiveClients    int64   `json:"active_clients"`
+	OngoingUploads   int64   `json:"ongoing_uploads"`
+	InFlightRequests int64   `json:"in_flight_requests"`
+	Load             float64 `json:"load"` // Rapporto più alto tra i valori correnti e i limiti di server_status
+	Level            string  `json:"level"`
+	RetryAfterMs     int     `json:"retry_after_ms,omitempty"`
+}
+
+// UpdateUploadsGauge refreshes the ongoing uploads gauge. Va chiamata con FileUploadsMutex acquisito,
+// dopo ogni modifica di OngoingFileUploads.
+func (h *Hub) UpdateUploadsGauge() {
+	h.load.ongoingUploads.Store(int64(len(h.OngoingFileUploads)))
+}
+
+// registerLoadMetrics exposes the load gauges on /metrics.
+func (h *Hub) registerLoadMetrics() {
+	metrics.NewGaugeFunc("clouddav_active_clients", "Connected WebSocket and long polling clients.", func() float64 {
+		return float64(h.load.activeClients.Load())
+	})
+	metrics.NewGaugeFunc("clouddav_ongoing_uploads", "Upload sessions in OngoingFileUploads.", func() float64 {
+		return float64(h.load.ongoingUploads.Load())
+	})
+	metrics.NewGaugeFunc("clouddav_in_flight_requests", "Client messages being processed.", func() float64 {
+		return float64(h.load.inFlightRequests.Load())
+	})
+}
+
+// serverStatus returns the current load of the server, for client-side backoff.
+func (h *Hub) serverStatus() serverStatus {
+	status := serverStatus{
+		ActiveClients:    h.load.activeClients.Load(),
+		OngoingUploads:   h.load.ongoingUploads.Load(),
+		InFlightRequests: h.load.inFlightRequests.Load(),
+	}
+	limits := h.Config().ServerStatus
+	if limits.MaxInFlightRequests > 0 {
+		status.Load = float64(status.InFlightRequests) / float64(limits.MaxInFlightRequests)
+	}
+	if limits.MaxOngoingUploads > 0 {
+		if uploadsLoad := float64(status.OngoingUploads) / float64(limits.MaxOngoingUploads); uploadsLoad > status.Load {
+			status.Load = uploadsLoad
+		}
+	}
+
+	switch {
+	case status.Load >= 1:
+		status.Level, status.RetryAfterMs = LoadLevelOverloaded, overloadedRetryAfterMs
+	case status.Load >= loadBusyThreshold:
+		status.Level, status.RetryAfterMs = LoadLevelBusy, busyRetryAfterMs
+	default:
+		status.Level = LoadLevelOK
+	}
+	return status
+}
+
+// nextPollAfterMs returns the interval suggested to long polling clients before the next poll:
+// long_polling.poll_interval_ms, moltiplicato per 2 con il server "busy" e per 4 con "overloaded"
+// (al massimo long_polling.max_poll_interval_ms), così i client rallentano insieme sotto carico.
+func (h *Hub) nextPollAfterMs() int {
+	cfg := h.Config().LongPolling
+	interval := cfg.PollIntervalMs
+	switch h.serverStatus().Level {
+	case LoadLevelBusy:
+		interval *= 2
+	case LoadLevelOverloaded:
+		interval *= 4
+	}
+	if interval > cfg.MaxPollIntervalMs {
+		interval = cfg.MaxPollIntervalMs
+	}
+	return interval
+}
+
+// setLongPollingHints adds to a long polling response the suggested polling cadence and the notification
+// cursor of the user, with which the client detects the notifications arrived between two polls.
+func (h *Hub) setLongPollingHints(response *Message, claims *auth.UserClaims) {
+	response.NextPollAfterMs = h.nextPollAfterMs()
+	response.Cursor = h.notifications.cursor(userKeyFromClaims(claims))
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+)
+
+// sessionCounter numbers the WebSocket connections for Client.sessionID.
+var sessionCounter atomic.Uint64
+
+// newSessionID returns a unique identifier for a WebSocket connection: l'email non basta, perché lo
+// stesso utente può avere più schede aperte.
+func newSessionID() string {
+	return "ws-" + strconv.FormatUint(sessionCounter.Add(1), 10)
+}
+
+// sessionIDKey is the context key of the session_id of the client that sent the message being processed.
+type sessionIDKey struct{}
+
+func withSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, sessionID)
+}
+
+// remoteAddress returns the client address of r, the first of X-Forwarded-For behind the proxy.
+func remoteAddress(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sessionInfo is a connected client in the list_sessions response. Transport è sempre "websocket":
+// vedi listSessions per i client Long Polling.
+type sessionInfo struct {
+	SessionID          string    `json:"session_id"`
+	User               string    `json:"user"`
+	Transport          string    `json:"transport"`
+	RemoteAddress      string    `json:"remote_address"`
+	ConnectedAt        time.Time `json:"connected_at"`
+	LastActivity       time.Time `json:"last_activity"`
+	InFlightOperations int64     `json:"in_flight_operations"`
+	Storages           int       `json:"storages"`
+}
+
+// queryClients runs query in the Run goroutine, which owns h.clients, and waits for it to complete. query
+// non deve inviare sugli altri canali del Hub (es. unregister), che Run non legge mentre la esegue.
+func (h *Hub) queryClients(ctx context.Context, query func(clients map[*Client]bool)) error {
+	done := make(chan struct{})
+	select {
+	case h.clientQueries <- func(clients map[*Client]bool) {
+		query(clients)
+		close(done)
+	}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-h.ctx.Done():
+		return h.ctx.Err()
+	}
+	<-done
+	return nil
+}
+
+// listSessions handles list_sessions: the connected WebSocket clients, for the global administrators.
+// I client Long Polling sono fuori dallo scopo di list_sessions e disconnect_session: ogni richiesta /lp è
+// indipendente e non registra un Client nel Hub, quindi non c'è una sessione da elencare né da chiudere
+// (per bloccare un client LP va revocato il suo cookie di sessione).
+func (h *Hub) listSessions(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: msg.Type + "_response", RequestID: msg.RequestID}
+	if !authz.IsGlobalAdmin(claims, h.Config()) {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "Access denied: only administrators can list sessions"}
+		return response, nil
+	}
+
+	var sessions []sessionInfo
+	err := h.queryClients(ctx, func(clients map[*Client]bool) {
+		sessions = make([]sessionInfo, 0, len(clients))
+		for client := range clients {
+			client.mu.Lock()
+			lastActivity := client.lastActivity
+			client.mu.Unlock()
+			sessions = append(sessions, sessionInfo{
+				SessionID:          client.sessionID,
+				User:               client.userIdentifier,
+				Transport:          "websocket",
+				RemoteAddress:      client.remoteAddr,
+				ConnectedAt:        client.connectedAt,
+				LastActivity:       lastActivity,
+				InFlightOperations: client.inFlight.Load(),
+				Storages:           len(client.accessibleStorages),
+			})
+		}
+	})
+	if err != nil {
+		return response, err
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt) })
+
+	response.Payload = map[string]interface{}{
+		"sessions": sessions,
+		"count":    len(sessions),
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("list_sessions_response (Admin: %s, ReqID: %s): %d sessions", userIdentifier, msg.RequestID, len(sessions))
+	}
+	return response, nil
+}
+
+// disconnectSession handles disconnect_session: closes the WebSocket connection with the given session_id
+// (per esempio una sessione compromessa), for the global administrators. Il client può riconnettersi se il suo
+// cookie di sessione è ancora valido.
+func (h *Hub) disconnectSession(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: msg.Type + "_response", RequestID: msg.RequestID}
+	if !authz.IsGlobalAdmin(claims, h.Config()) {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "Access denied: only administrators can disconnect sessions"}
+		return response, nil
+	}
+	var payload struct {
+		SessionID string `json:"session_id"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for disconnect_session: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid disconnect_session payload: %w", err)
+	}
+	if payload.SessionID == "" {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "session_id is required"}
+		return response, nil
+	}
+
+	// La risposta viaggerebbe sulla connessione appena chiusa.
+	if currentSession, _ := ctx.Value(sessionIDKey{}).(string); currentSession == payload.SessionID {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "Cannot disconnect the current session: close the connection instead"}
+		return response, nil
+	}
+
+	var target *Client
+	err = h.queryClients(ctx, func(clients map[*Client]bool) {
+		for client := range clients {
+			if client.sessionID == payload.SessionID {
+				target = client
+				return
+			}
+		}
+	})
+	if err != nil {
+		return response, err
+	}
+	if target == nil {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": fmt.Sprintf("Session '%s' not found", payload.SessionID), "error_code": "SESSION_NOT_FOUND"}
+		return response, nil
+	}
+	// Run ignora l'unregister di un client già disconnesso nel frattempo.
+	select {
+	case h.unregister <- target:
+	case <-ctx.Done():
+		return response, ctx.Err()
+	}
+
+	response.Payload = map[string]interface{}{
+		"status":     "success",
+		"session_id": target.sessionID,
+		"user":       target.userIdentifier,
+	}
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("disconnect_session (Admin: %s, ReqID: %s): disconnected session '%s' of user '%s'", userIdentifier, msg.RequestID, target.sessionID, target.userIdentifier)
+	}
+	return response, nil
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+)
+
+// registerTestClient registers a WebSocket client without a connection with the running Hub h. Attende il
+// config_update iniziale (inviato da una goroutine di Run) e deregistra il client prima dell'arresto del Hub,
+// così nessun invio sul canale send è in corso quando viene chiuso.
+func registerTestClient(t *testing.T, h *Hub, userIdentifier string, claims *auth.UserClaims) *Client {
+	t.Helper()
+	clientCtx, clientCancel := context.WithCancel(h.ctx)
+	client := &Client{send: make(chan Message, 8), isWS: true, claims: claims, ctx: clientCtx, cancel: clientCancel, userIdentifier: userIdentifier, sessionID: "session-" + userIdentifier, connectedAt: time.Now(), remoteAddr: "192.0.2.1", hub: h}
+	h.register <- client
+	<-client.send
+	t.Cleanup(func() { h.unregister <- client }) // Eseguita prima di h.cancel, registrata prima
+	return client
+}
+
+func TestListSessions(t *testing.T) {
+	cfg := &config.Config{EnableAuth: true, GlobalAdminGroups: []string{"admins"}}
+	h := NewHub(context.Background(), cfg)
+	t.Cleanup(h.cancel)
+	go h.Run()
+
+	registerTestClient(t, h, "user@example.com", &auth.UserClaims{Email: "user@example.com"})
+
+	tests := []struct {
+		name     string
+		claims   *auth.UserClaims
+		wantType string
+	}{
+		{"administrator", &auth.UserClaims{Email: "admin@example.com", GroupNames: []string{"admins"}}, "list_sessions_response"},
+		{"regular user", &auth.UserClaims{Email: "user@example.com"}, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := h.listSessions(context.Background(), &Message{Type: "list_sessions"}, tt.claims, tt.claims.Email)
+			if err != nil {
+				t.Fatalf("listSessions: %v", err)
+			}
+			if response.Type != tt.wantType {
+				t.Fatalf("response type = %q, want %q", response.Type, tt.wantType)
+			}
+			if tt.wantType == "error" {
+				return
+			}
+			sessions := response.Payload.(map[string]interface{})["sessions"].([]sessionInfo)
+			if len(sessions) != 1 || sessions[0].SessionID != "session-user@example.com" || sessions[0].Transport != "websocket" || sessions[0].RemoteAddress != "192.0.2.1" {
+				t.Errorf("sessions = %+v, want the registered WebSocket client", sessions)
+			}
+		})
+	}
+}
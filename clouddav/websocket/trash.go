@@ -0,0 +1,224 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+	"clouddav/storage"
+	"clouddav/storage/local"
+)
+
+// trashPurgeInterval is how often cleanupTrash removes the items older than trash_retention.
+const trashPurgeInterval = time.Hour
+
+// trashProvider returns the local provider of storageName if it has a trash_dir, altrimenti la risposta
+// di errore da inviare al client.
+func trashProvider(response Message, storageName string) (*local.LocalFilesystemProvider, Message, bool) {
+	provider, ok := storage.GetProvider(storageName)
+	if ok {
+		if localProvider, isLocal := storage.Unwrap(provider).(*local.LocalFilesystemProvider); isLocal && localProvider.TrashEnabled() {
+			return localProvider, response, true
+		}
+	}
+	response.Type = "error"
+	response.Payload = map[string]string{"error": fmt.Sprintf("Trash is not enabled for storage '%s'", storageName), "error_code": "TRASH_NOT_ENABLED"}
+	return nil, response, false
+}
+
+// canAccessTrashEntry reports whether the user has the given access to the original path of a trash entry.
+// Il cestino è unico per storage, quindi ogni utente vede e gestisce solo gli elementi dei path a cui ha accesso.
+func (h *Hub) canAccessTrashEntry(ctx context.Context, claims *auth.UserClaims, storageName string, entry local.TrashEntry, access string) bool {
+	return authz.CheckStorageAccess(ctx, claims, storageName, entry.OriginalPath, access, h.Config()) == nil
+}
+
+// listTrash handles list_trash: the items deleted on a storage with trash_dir whose original path the user can read.
+func (h *Hub) listTrash(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "list_trash_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for list_trash: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid list_trash payload: %w", err)
+	}
+	provider, errResponse, ok := trashProvider(response, payload.StorageName)
+	if !ok {
+		return errResponse, nil
+	}
+
+	entries, err := provider.ListTrash(ctx, claims)
+	if err != nil {
+		h.RecordError(claims, "list_trash", payload.StorageName, "/", err)
+		return response, fmt.Errorf("error listing trash of '%s' (User: %s, ReqID: %s): %w", payload.StorageName, userIdentifier, msg.RequestID, err)
+	}
+	visible := make([]local.TrashEntry, 0, len(entries))
+	for _, entry := range entries {
+		if h.canAccessTrashEntry(ctx, claims, payload.StorageName, entry, "read") {
+			visible = append(visible, entry)
+		}
+	}
+	response.Payload = map[string]interface{}{
+		"storage_name": payload.StorageName,
+		"items":        visible,
+	}
+	return response, nil
+}
+
+// restoreItem handles restore_item: moves a trash entry back to its original path, che richiede il permesso
+// di scrittura su quel path.
+func (h *Hub) restoreItem(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "restore_item_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string `json:"storage_name"`
+		TrashID     string `json:"trash_id"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for restore_item: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid restore_item payload: %w", err)
+	}
+	if payload.TrashID == "" {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "trash_id is required"}
+		return response, nil
+	}
+	provider, errResponse, ok := trashProvider(response, payload.StorageName)
+	if !ok {
+		return errResponse, nil
+	}
+
+	entry, err := provider.GetTrashEntry(ctx, claims, payload.TrashID)
+	if err == nil && !h.canAccessTrashEntry(ctx, claims, payload.StorageName, *entry, "write") {
+		err = storage.ErrPermissionDenied
+	}
+	if err == nil {
+		entry, err = provider.RestoreItem(ctx, claims, payload.TrashID)
+		if err == nil {
+			storage.RecordChange(payload.StorageName, storage.ChangeCreated, entry.OriginalPath)
+		}
+	}
+	if err != nil {
+		h.RecordError(claims, "restore_item", payload.StorageName, payload.TrashID, err)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Trash item not found"}
+		case errors.Is(err, storage.ErrAlreadyExists):
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "An item already exists at the original path", "error_code": "ALREADY_EXISTS"}
+		case errors.Is(err, storage.ErrPermissionDenied):
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: write permission required"}
+		default:
+			return response, fmt.Errorf("error restoring trash item '%s' of '%s' (User: %s, ReqID: %s): %w", payload.TrashID, payload.StorageName, userIdentifier, msg.RequestID, err)
+		}
+		return response, nil
+	}
+	response.Payload = map[string]interface{}{
+		"status":       "success",
+		"storage_name": payload.StorageName,
+		"trash_id":     entry.ID,
+		"item_path":    entry.OriginalPath,
+		"is_dir":       entry.IsDir,
+	}
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("restore_item_response (User: %s, ReqID: %s): Restored '%s/%s' from the trash", userIdentifier, msg.RequestID, payload.StorageName, entry.OriginalPath)
+	}
+	return response, nil
+}
+
+// emptyTrash handles empty_trash: permanently deletes the trash entries (tutte, o quelle in trash_ids) whose
+// original path the user can write. Gli elementi di altri path restano nel cestino.
+func (h *Hub) emptyTrash(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "empty_trash_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		StorageName string   `json:"storage_name"`
+		TrashIDs    []string `json:"trash_ids,omitempty"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for empty_trash: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid empty_trash payload: %w", err)
+	}
+	provider, errResponse, ok := trashProvider(response, payload.StorageName)
+	if !ok {
+		return errResponse, nil
+	}
+
+	selected := make(map[string]bool, len(payload.TrashIDs))
+	for _, id := range payload.TrashIDs {
+		selected[id] = true
+	}
+	removed, err := provider.EmptyTrash(ctx, claims, func(entry local.TrashEntry) bool {
+		if len(selected) > 0 && !selected[entry.ID] {
+			return false
+		}
+		return h.canAccessTrashEntry(ctx, claims, payload.StorageName, entry, "write")
+	})
+	if err != nil {
+		h.RecordError(claims, "empty_trash", payload.StorageName, "/", err)
+		if errors.Is(err, storage.ErrPermissionDenied) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": fmt.Sprintf("Access denied: %v", err)}
+			return response, nil
+		}
+		return response, fmt.Errorf("error emptying trash of '%s' after %d items (User: %s, ReqID: %s): %w", payload.StorageName, removed, userIdentifier, msg.RequestID, err)
+	}
+	response.Payload = map[string]interface{}{
+		"status":       "success",
+		"storage_name": payload.StorageName,
+		"removed":      removed,
+	}
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("empty_trash_response (User: %s, ReqID: %s): Removed %d trash items of %s", userIdentifier, msg.RequestID, removed, payload.StorageName)
+	}
+	return response, nil
+}
+
+// cleanupTrash periodically removes the items that have been in the trash of the local storages for longer
+// than their trash_retention. I provider vengono riletti a ogni giro, quindi vale la retention dopo un reload.
+func (h *Hub) cleanupTrash() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, provider := range storage.GetAllProviders() {
+			localProvider, ok := storage.Unwrap(provider).(*local.LocalFilesystemProvider)
+			if !ok {
+				continue
+			}
+			removed, err := localProvider.PurgeTrash(h.ctx)
+			if err != nil {
+				log.Printf("Error purging trash of storage '%s': %v", provider.Name(), err)
+			}
+			if removed > 0 && config.IsLogLevel(config.LogLevelInfo) {
+				log.Printf("Purged %d expired trash items of storage '%s'", removed, provider.Name())
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-h.ctx.Done():
+			if config.IsLogLevel(config.LogLevelInfo) {
+				log.Println("Trash cleanup goroutine context cancelled, stopping.")
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/azureblob"
+	"clouddav/storage/command"
+	"clouddav/storage/gcs"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+)
+
+// pendingUploadCancel is an upload session removed from OngoingFileUploads whose provider-level
+// state (temp file, staged blocks) still has to be cleaned up.
+type pendingUploadCancel struct {
+	UploadKey    string // Upload ID
+	SessionState *UploadSessionState
+}
+
+// removeUploadsMatching removes from OngoingFileUploads the sessions for which match returns true
+// and returns them, so that the caller can cancel them at provider level without holding the mutex.
+func (h *Hub) removeUploadsMatching(match func(uploadKey string, sessionState *UploadSessionState) bool) []pendingUploadCancel {
+	h.FileUploadsMutex.Lock()
+	defer h.FileUploadsMutex.Unlock()
+
+	var removed []pendingUploadCancel
+	for uploadKey, sessionState := range h.OngoingFileUploads {
+		if match(uploadKey, sessionState) {
+			removed = append(removed, pendingUploadCancel{UploadKey: uploadKey, SessionState: sessionState})
+		}
+	}
+	for _, upload := range removed {
+		delete(h.OngoingFileUploads, upload.UploadKey)
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("Removed upload %s from OngoingFileUploads", upload.UploadKey)
+		}
+	}
+	h.UpdateUploadsGauge()
+	return removed
+}
+
+// cancelProviderUpload cancels an upload at provider level, using the claims of the user who started it.
+func cancelProviderUpload(upload pendingUploadCancel) error {
+	provider, ok := storage.GetProvider(upload.SessionState.StorageName)
+	if !ok {
+		log.Printf("Warning: Storage provider '%s' not found while cancelling upload '%s'", upload.SessionState.StorageName, upload.SessionState.ItemPath)
+		return nil
+	}
+	cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cleanupCancel()
+
+	switch p := storage.Unwrap(provider).(type) {
+	case *local.LocalFilesystemProvider:
+		return p.CancelUpload(upload.SessionState.Claims, upload.SessionState.UploadID)
+	case *azureblob.AzureBlobStorageProvider:
+		return p.CancelUpload(cleanupCtx, upload.SessionState.Claims, upload.SessionState.UploadID)
+	case *command.CommandStorageProvider:
+		return p.CancelUpload(upload.SessionState.Claims, upload.SessionState.UploadID)
+	case *memory.MemoryStorageProvider:
+		return p.CancelUpload(upload.SessionState.Claims, upload.SessionState.UploadID)
+	case *gcs.GCSStorageProvider:
+		return p.CancelUpload(upload.SessionState.Claims, upload.SessionState.UploadID)
+	default:
+		log.Printf("Warning: CancelUpload not implemented for storage type '%s'.", provider.Type())
+		return nil
+	}
+}
+
+// UploadForPath returns the ongoing upload whose destination is itemPath of storageName, ignorando
+// excludeID (la sessione che sta cercando un nome libero). Va chiamata con FileUploadsMutex acquisito.
+func (h *Hub) UploadForPath(storageName string, itemPath string, excludeID string) (string, *UploadSessionState, bool) {
+	for uploadID, sessionState := range h.OngoingFileUploads {
+		if uploadID != excludeID && sessionState.StorageName == storageName && sessionState.ItemPath == itemPath {
+			return uploadID, sessionState, true
+		}
+	}
+	return "", nil, false
+}
+
+// cancelledUploadResult is the outcome of one cancellation in cancel_all_uploads.
+type cancelledUploadResult struct {
+	StorageName string `json:"storage_name"`
+	ItemPath    string `json:"item_path"`
+	Error       string `json:"error,omitempty"`
+}
+
+// cancelUserUploads removes all the upload sessions of the user and cancels them concurrently.
+func (h *Hub) cancelUserUploads(userKey string) []cancelledUploadResult {
+	uploads := h.removeUploadsMatching(func(_ string, sessionState *UploadSessionState) bool {
+		return userKeyFromClaims(sessionState.Claims) == userKey
+	})
+
+	results := make([]cancelledUploadResult, len(uploads))
+	var wg sync.WaitGroup
+	for i, upload := range uploads {
+		wg.Add(1)
+		go func(i int, upload pendingUploadCancel) {
+			defer wg.Done()
+			results[i] = cancelledUploadResult{StorageName: upload.SessionState.StorageName, ItemPath: upload.SessionState.ItemPath}
+			if err := cancelProviderUpload(upload); err != nil {
+				log.Printf("Error cancelling upload '%s' for user '%s': %v", upload.UploadKey, userKey, err)
+				results[i].Error = err.Error()
+			}
+		}(i, upload)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/memory"
+)
+
+func TestCancelAllUploads(t *testing.T) {
+	alice := &auth.UserClaims{Email: "alice@example.com"}
+	bob := &auth.UserClaims{Email: "bob@example.com"}
+	admin := &auth.UserClaims{Email: "admin@example.com", GroupNames: []string{"admins"}}
+	owners := map[string]*auth.UserClaims{"alice-1": alice, "alice-2": alice, "bob-1": bob}
+
+	tests := []struct {
+		name          string
+		claims        *auth.UserClaims
+		userEmail     string
+		wantError     bool
+		wantCancelled []string
+	}{
+		{name: "own uploads", claims: alice, wantCancelled: []string{"alice-1", "alice-2"}},
+		{name: "own uploads by email", claims: bob, userEmail: "bob@example.com", wantCancelled: []string{"bob-1"}},
+		{name: "another user's uploads", claims: alice, userEmail: "bob@example.com", wantError: true},
+		{name: "administrator for another user", claims: admin, userEmail: "alice@example.com", wantCancelled: []string{"alice-1", "alice-2"}},
+		{name: "user without uploads", claims: admin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storageCfg := config.StorageConfig{Name: "mem", Type: "memory"}
+			cfg := &config.Config{EnableAuth: true, GlobalAdminGroups: []string{"admins"}, Storages: []config.StorageConfig{storageCfg}}
+			ctx := context.Background()
+			provider, err := memory.NewProvider(ctx, &storageCfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.ReplaceProviders([]storage.StorageProvider{provider}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(storage.ClearRegistry)
+			h := NewHub(ctx, cfg)
+			t.Cleanup(h.cancel)
+			go h.Run() // Consegna le notifiche agli utenti di cui un admin annulla gli upload
+
+			for uploadID, owner := range owners {
+				if _, err := provider.InitiateUpload(ctx, owner, uploadID, "/"+uploadID+".bin", 10, 5); err != nil {
+					t.Fatal(err)
+				}
+				if err := provider.WriteChunk(ctx, owner, uploadID, []byte("12345"), 0, 5, storage.ChunkChecksum{}); err != nil {
+					t.Fatal(err)
+				}
+				h.FileUploadsMutex.Lock()
+				h.OngoingFileUploads[uploadID] = &UploadSessionState{Claims: owner, UploadID: uploadID, StorageName: "mem", ItemPath: "/" + uploadID + ".bin", LastActivity: time.Now()}
+				h.FileUploadsMutex.Unlock()
+			}
+
+			payload := map[string]interface{}{}
+			if tt.userEmail != "" {
+				payload["user_email"] = tt.userEmail
+			}
+			response, err := h.handleClientMessage(ctx, &Message{Type: "cancel_all_uploads", RequestID: "r1", Payload: payload}, tt.claims)
+			if err != nil {
+				t.Fatalf("cancel_all_uploads: %v", err)
+			}
+			if tt.wantError {
+				if response.Type != "error" {
+					t.Fatalf("response type = %q, want error", response.Type)
+				}
+			} else if cancelled := response.Payload.(map[string]interface{})["cancelled"]; cancelled != len(tt.wantCancelled) {
+				t.Errorf("cancelled = %v, want %d", cancelled, len(tt.wantCancelled))
+			}
+
+			wantGone := map[string]bool{}
+			for _, uploadID := range tt.wantCancelled {
+				wantGone[uploadID] = true
+			}
+			for uploadID := range owners {
+				h.FileUploadsMutex.Lock()
+				_, tracked := h.OngoingFileUploads[uploadID]
+				h.FileUploadsMutex.Unlock()
+				size, _ := provider.GetUploadedSize(nil, uploadID)
+				if gone := !tracked && size == 0; gone != wantGone[uploadID] {
+					t.Errorf("%s: tracked %t, %d bytes; want cancelled %t", uploadID, tracked, size, wantGone[uploadID])
+				}
+			}
+		})
+	}
+}
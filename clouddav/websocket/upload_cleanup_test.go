@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/memory"
+)
+
+func TestUploadBelongsToClient(t *testing.T) {
+	user := &auth.UserClaims{Email: "user@example.com"}
+	anonymous := &Client{userIdentifier: "anon-ws-1"}
+	tests := []struct {
+		name    string
+		session *UploadSessionState
+		client  *Client
+		want    bool
+	}{
+		{"anonymous upload of the client", &UploadSessionState{ClientID: "anon-ws-1"}, anonymous, true},
+		{"anonymous upload of another client", &UploadSessionState{ClientID: "anon-ws-2"}, anonymous, false},
+		{"anonymous upload without client_id", &UploadSessionState{}, &Client{userIdentifier: ""}, false},
+		{"upload of the same user", &UploadSessionState{Claims: &auth.UserClaims{Email: "user@example.com"}}, &Client{userIdentifier: "user@example.com", claims: user}, true},
+		{"upload of another user", &UploadSessionState{Claims: &auth.UserClaims{Email: "other@example.com"}}, &Client{userIdentifier: "user@example.com", claims: user}, false},
+		{"anonymous upload, authenticated client", &UploadSessionState{ClientID: "user@example.com"}, &Client{userIdentifier: "user@example.com", claims: user}, false},
+	}
+	for _, tt := range tests {
+		if got := uploadBelongsToClient(tt.session, tt.client); got != tt.want {
+			t.Errorf("%s: uploadBelongsToClient = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnonymousDisconnectCancelsItsUploads(t *testing.T) {
+	storageCfg := config.StorageConfig{Name: "mem", Type: "memory"}
+	cfg := &config.Config{Storages: []config.StorageConfig{storageCfg}}
+	ctx := context.Background()
+	provider, err := memory.NewProvider(ctx, &storageCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, cfg)
+	t.Cleanup(h.cancel)
+	go h.Run()
+
+	leaving := registerTestClient(t, h, "anon-ws-1", nil)
+	staying := registerTestClient(t, h, "anon-ws-2", nil)
+
+	for uploadID, clientID := range map[string]string{"leaving": "anon-ws-1", "staying": "anon-ws-2"} {
+		if _, err := provider.InitiateUpload(ctx, nil, uploadID, "/"+uploadID+".bin", 10, 5); err != nil {
+			t.Fatal(err)
+		}
+		if err := provider.WriteChunk(ctx, nil, uploadID, []byte("12345"), 0, 5, storage.ChunkChecksum{}); err != nil {
+			t.Fatal(err)
+		}
+		h.FileUploadsMutex.Lock()
+		h.OngoingFileUploads[uploadID] = &UploadSessionState{UploadID: uploadID, ClientID: clientID, StorageName: "mem", ItemPath: "/" + uploadID + ".bin", LastActivity: time.Now()}
+		h.FileUploadsMutex.Unlock()
+	}
+
+	h.unregister <- leaving // Disconnessione a metà upload
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.FileUploadsMutex.Lock()
+		_, leavingTracked := h.OngoingFileUploads["leaving"]
+		_, stayingTracked := h.OngoingFileUploads["staying"]
+		h.FileUploadsMutex.Unlock()
+		leavingSize, _ := provider.GetUploadedSize(nil, "leaving")
+		stayingSize, _ := provider.GetUploadedSize(nil, "staying")
+		if !leavingTracked && leavingSize == 0 {
+			if !stayingTracked || stayingSize != 5 {
+				t.Fatalf("upload of the connected client: tracked %t, %d bytes; want tracked with 5 bytes", stayingTracked, stayingSize)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upload of the disconnected client: tracked %t, %d bytes; want removed and cancelled", leavingTracked, leavingSize)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// La notifica upload_cancelled va agli utenti anonimi ancora connessi.
+	select {
+	case msg := <-staying.send:
+		if msg.Type != "notification" {
+			t.Errorf("message to the connected anonymous client = %q, want notification", msg.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no upload_cancelled notification delivered")
+	}
+}
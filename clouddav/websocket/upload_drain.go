@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"clouddav/config"
+)
+
+// uploadDrainPollInterval is the interval between checks of the ongoing uploads while draining.
+const uploadDrainPollInterval = 200 * time.Millisecond
+
+// BeginUploadDrain makes the server refuse new uploads (UploadsDraining) while the ongoing ones can still
+// receive chunks and be finalized. Chiamata allo shutdown, prima di fermare il server HTTP.
+func (h *Hub) BeginUploadDrain() {
+	h.uploadsDraining.Store(true)
+}
+
+// UploadsDraining reports whether new uploads must be refused because the server is shutting down.
+func (h *Hub) UploadsDraining() bool {
+	return h.uploadsDraining.Load()
+}
+
+// WaitForUploads waits until OngoingFileUploads is empty (upload finalizzati, annullati o rimossi come
+// orfani) or ctx expires, and returns the number of uploads still in progress. Legge il gauge degli upload,
+// aggiornato a ogni modifica di OngoingFileUploads, senza prendere FileUploadsMutex.
+func (h *Hub) WaitForUploads(ctx context.Context) int {
+	ticker := time.NewTicker(uploadDrainPollInterval)
+	defer ticker.Stop()
+
+	lastLogged := int64(-1)
+	for {
+		remaining := h.load.ongoingUploads.Load()
+		if remaining == 0 {
+			return 0
+		}
+		if remaining != lastLogged && config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Waiting for %d uploads in progress to complete before shutdown", remaining)
+			lastLogged = remaining
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return int(h.load.ongoingUploads.Load())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/internal/authz"
+)
+
+// uploadThroughputWindow is the sliding window over which the throughput of an upload is measured:
+// abbastanza lungo da assorbire chunk grandi e upload paralleli, abbastanza corto da seguire i cambi di banda.
+const uploadThroughputWindow = 30 * time.Second
+
+// chunkArrival is a chunk written for an upload session.
+type chunkArrival struct {
+	at    time.Time
+	bytes int64
+}
+
+// uploadThroughput keeps the chunk arrivals of an upload within uploadThroughputWindow.
+type uploadThroughput struct {
+	arrivals []chunkArrival
+}
+
+// record adds a chunk arrival and drops the arrivals older than the window.
+func (t *uploadThroughput) record(now time.Time, bytes int64) {
+	t.arrivals = append(t.arrivals, chunkArrival{at: now, bytes: bytes})
+	t.prune(now)
+}
+
+func (t *uploadThroughput) prune(now time.Time) {
+	cutoff := now.Add(-uploadThroughputWindow)
+	drop := 0
+	for drop < len(t.arrivals) && t.arrivals[drop].at.Before(cutoff) {
+		drop++
+	}
+	t.arrivals = t.arrivals[drop:]
+}
+
+// bytesPerSecond returns the throughput over the window, or over the time since startedAt if the upload
+// started more recently. Senza chunk nella finestra (upload fermo o appena avviato) restituisce 0.
+func (t *uploadThroughput) bytesPerSecond(now time.Time, startedAt time.Time) float64 {
+	t.prune(now)
+	if len(t.arrivals) == 0 {
+		return 0
+	}
+	var bytes int64
+	for _, arrival := range t.arrivals {
+		bytes += arrival.bytes
+	}
+	elapsed := uploadThroughputWindow
+	if sinceStart := now.Sub(startedAt); sinceStart < elapsed {
+		elapsed = sinceStart
+	}
+	if elapsed < time.Second {
+		elapsed = time.Second // Il primo chunk arriva subito dopo l'initiate: evita stime enormi
+	}
+	return float64(bytes) / elapsed.Seconds()
+}
+
+// RecordChunk records a chunk of bytes written for the session at time now, updating ReceivedBytes and
+// the throughput. Va chiamata con FileUploadsMutex acquisito.
+func (s *UploadSessionState) RecordChunk(now time.Time, bytes int64) {
+	s.ReceivedBytes += bytes
+	if s.TotalSize > 0 && s.ReceivedBytes > s.TotalSize {
+		s.ReceivedBytes = s.TotalSize // Chunk ritrasmessi dal client dopo un errore
+	}
+	s.throughput.record(now, bytes)
+}
+
+// uploadETA handles upload_eta: returns the remaining bytes, the recent throughput and the estimated
+// seconds to completion of an upload of the user (o di qualsiasi utente per gli admin).
+// eta_seconds è null finché non si può stimare: nessun chunk ancora ricevuto o upload fermo da più
+// di uploadThroughputWindow (stalled è true).
+func (h *Hub) uploadETA(ctx context.Context, msg *Message, claims *auth.UserClaims, userIdentifier string) (Message, error) {
+	response := Message{Type: "upload_eta_response", RequestID: msg.RequestID}
+
+	var payload struct {
+		UploadID    string `json:"upload_id,omitempty"` // Se assente, l'upload viene cercato per storage_name e item_path
+		StorageName string `json:"storage_name"`
+		ItemPath    string `json:"item_path"`
+	}
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal payload for upload_eta: %w", err)
+	}
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return response, fmt.Errorf("invalid upload_eta payload: %w", err)
+	}
+
+	now := time.Now()
+	h.FileUploadsMutex.Lock()
+	var sessionState *UploadSessionState
+	var exists bool
+	if payload.UploadID != "" {
+		sessionState, exists = h.OngoingFileUploads[payload.UploadID]
+	} else {
+		payload.UploadID, sessionState, exists = h.UploadForPath(payload.StorageName, payload.ItemPath, "")
+	}
+	var owner string
+	var totalSize, receivedBytes int64
+	var throughputBps float64
+	var stalled bool
+	if exists {
+		owner = userKeyFromClaims(sessionState.Claims)
+		payload.StorageName, payload.ItemPath = sessionState.StorageName, sessionState.ItemPath
+		totalSize = sessionState.TotalSize
+		receivedBytes = sessionState.ReceivedBytes
+		throughputBps = sessionState.throughput.bytesPerSecond(now, sessionState.StartedAt)
+		stalled = throughputBps == 0 && now.Sub(sessionState.LastActivity) >= uploadThroughputWindow
+	}
+	h.FileUploadsMutex.Unlock()
+
+	// Un upload di un altro utente viene riportato come inesistente, salvo per gli admin.
+	if !exists || (owner != userKeyFromClaims(claims) && !authz.IsGlobalAdmin(claims, h.Config())) {
+		response.Type = "error"
+		response.Payload = map[string]string{"error": "Upload not found", "error_code": "UPLOAD_NOT_FOUND"}
+		return response, nil
+	}
+
+	bytesRemaining := totalSize - receivedBytes
+	if bytesRemaining < 0 {
+		bytesRemaining = 0
+	}
+	var etaSeconds interface{} // null se non stimabile
+	if bytesRemaining == 0 {
+		etaSeconds = 0
+	} else if throughputBps > 0 {
+		etaSeconds = int64(math.Ceil(float64(bytesRemaining) / throughputBps))
+	}
+
+	response.Payload = map[string]interface{}{
+		"upload_id":       payload.UploadID,
+		"storage_name":    payload.StorageName,
+		"item_path":       payload.ItemPath,
+		"total_bytes":     totalSize,
+		"bytes_remaining": bytesRemaining,
+		"throughput_bps":  int64(throughputBps),
+		"eta_seconds":     etaSeconds,
+		"stalled":         stalled,
+	}
+	if config.IsLogLevel(config.LogLevelDebug) {
+		log.Printf("upload_eta_response (User: %s, ReqID: %s): '%s' %d bytes remaining at %.0f B/s (stalled %t)", userIdentifier, msg.RequestID, payload.UploadID, bytesRemaining, throughputBps, stalled)
+	}
+	return response, nil
+}
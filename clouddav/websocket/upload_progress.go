@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/azureblob"
+	"clouddav/storage/command"
+	"clouddav/storage/gcs"
+	"clouddav/storage/local"
+	"clouddav/storage/memory"
+)
+
+// uploadSizeCheckTimeout bounds the provider call made for each session by recordUploadProgress.
+const uploadSizeCheckTimeout = 10 * time.Second
+
+// streamedBodyCounter counts the bytes read from a request body into the session's streamedBytes.
+type streamedBodyCounter struct {
+	io.ReadCloser
+	sessionState *UploadSessionState
+}
+
+func (c *streamedBodyCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.sessionState.streamedBytes.Add(int64(n))
+	return n, err
+}
+
+// CountStreamedBytes wraps the body of a chunk request of the session, so that the bytes are counted as
+// progress while they arrive: il body viene letto interamente prima che il provider scriva il chunk, e su un
+// collegamento lento un chunk grande può richiedere più di upload_cleanup_timeout.
+func (s *UploadSessionState) CountStreamedBytes(body io.ReadCloser) io.ReadCloser {
+	return &streamedBodyCounter{ReadCloser: body, sessionState: s}
+}
+
+// recordUploadProgress asks the providers for the uploaded size of every ongoing session and sets
+// LastProgress when it grew since the previous check, or when bytes of a chunk request arrived
+// (CountStreamedBytes). Le chiamate ai provider avvengono senza FileUploadsMutex, che protegge solo la
+// lettura e l'aggiornamento dello stato.
+func (h *Hub) recordUploadProgress() {
+	h.FileUploadsMutex.Lock()
+	sessions := make(map[string]*UploadSessionState, len(h.OngoingFileUploads))
+	for uploadKey, sessionState := range h.OngoingFileUploads {
+		sessions[uploadKey] = sessionState
+	}
+	h.FileUploadsMutex.Unlock()
+
+	for uploadKey, sessionState := range sessions {
+		size, err := uploadedSizeOf(h.ctx, sessionState)
+		if err != nil {
+			if config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("[DEBUG] recordUploadProgress: Cannot get uploaded size of '%s': %v", uploadKey, err)
+			}
+			size = -1 // Conta comunque i byte in arrivo
+		}
+		streamed := sessionState.streamedBytes.Load()
+
+		h.FileUploadsMutex.Lock()
+		// La sessione potrebbe essere stata finalizzata o annullata nel frattempo.
+		if current, exists := h.OngoingFileUploads[uploadKey]; exists && current == sessionState && (size > sessionState.ObservedSize || streamed > sessionState.observedStreamed) {
+			sessionState.ObservedSize = max(sessionState.ObservedSize, size)
+			sessionState.observedStreamed = streamed
+			sessionState.LastProgress = time.Now()
+			if config.IsLogLevel(config.LogLevelDebug) {
+				log.Printf("[DEBUG] recordUploadProgress: Upload '%s' progressed to %d bytes (%d bytes streamed)", uploadKey, size, streamed)
+			}
+		}
+		h.FileUploadsMutex.Unlock()
+	}
+}
+
+// isOrphanedUpload reports whether a session had neither requests nor progress within the cleanup
+// timeout of its storage (defaultTimeout se lo storage non lo sovrascrive).
+func (h *Hub) isOrphanedUpload(sessionState *UploadSessionState, now time.Time, defaultTimeout time.Duration) (bool, time.Time, time.Duration) {
+	timeout := h.uploadCleanupTimeoutFor(sessionState.StorageName, defaultTimeout)
+	lastActivity := sessionState.LastActivity
+	if sessionState.LastProgress.After(lastActivity) {
+		lastActivity = sessionState.LastProgress
+	}
+	return now.Sub(lastActivity) > timeout, lastActivity, timeout
+}
+
+// uploadedSizeOf returns the number of bytes the provider has received for an upload session
+// (per Azure i byte dei blocchi in staging).
+func uploadedSizeOf(ctx context.Context, sessionState *UploadSessionState) (int64, error) {
+	provider, ok := storage.GetProvider(sessionState.StorageName)
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, uploadSizeCheckTimeout)
+	defer cancel()
+
+	switch p := storage.Unwrap(provider).(type) {
+	case *local.LocalFilesystemProvider:
+		return p.GetUploadedSize(sessionState.Claims, sessionState.UploadID)
+	case *azureblob.AzureBlobStorageProvider:
+		return p.GetUploadedSize(ctx, sessionState.Claims, sessionState.UploadID)
+	case *command.CommandStorageProvider:
+		return p.GetUploadedSize(sessionState.Claims, sessionState.UploadID)
+	case *memory.MemoryStorageProvider:
+		return p.GetUploadedSize(sessionState.Claims, sessionState.UploadID)
+	case *gcs.GCSStorageProvider:
+		return p.GetUploadedSize(ctx, sessionState.Claims, sessionState.UploadID)
+	default:
+		return 0, storage.ErrNotImplemented
+	}
+}
+
+// AddRestoredUpload registers an upload session reloaded from disk after a restart, so that it is tracked
+// (status, conflitti, pulizia degli orfani) come quelle create da initiate. LastActivity parte da ora:
+// il client ha upload_cleanup_timeout per riprendere l'upload.
+func (h *Hub) AddRestoredUpload(uploadID string, storageName string, itemPath string, userEmail string, providerType string, uploadedSize int64, totalSize int64) {
+	now := time.Now()
+	h.FileUploadsMutex.Lock()
+	defer h.FileUploadsMutex.Unlock()
+	h.OngoingFileUploads[uploadID] = &UploadSessionState{
+		Claims:        &auth.UserClaims{Email: userEmail},
+		UploadID:      uploadID,
+		StorageName:   storageName,
+		ItemPath:      itemPath,
+		LastActivity:  now,
+		ProviderType:  providerType,
+		ObservedSize:  uploadedSize,
+		LastProgress:  now,
+		TotalSize:     totalSize,
+		ReceivedBytes: uploadedSize,
+		StartedAt:     now,
+	}
+	h.UpdateUploadsGauge()
+}
+
+// uploadCleanupTimeoutFor returns the orphan timeout of a storage, falling back to the global one.
+func (h *Hub) uploadCleanupTimeoutFor(storageName string, defaultTimeout time.Duration) time.Duration {
+	storageCfg := h.Config().GetStorageConfig(storageName)
+	if storageCfg == nil {
+		return defaultTimeout
+	}
+	timeout, err := storageCfg.GetUploadCleanupTimeout(defaultTimeout)
+	if err != nil {
+		return defaultTimeout
+	}
+	return timeout
+}
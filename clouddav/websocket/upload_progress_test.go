@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/memory"
+)
+
+func TestRecordUploadProgressKeepsSlowUploads(t *testing.T) {
+	storageCfg := config.StorageConfig{Name: "mem", Type: "memory", UploadCleanupTimeout: "10m"}
+	cfg := &config.Config{Storages: []config.StorageConfig{storageCfg}}
+	ctx := context.Background()
+	provider, err := memory.NewProvider(ctx, &storageCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, cfg)
+	t.Cleanup(h.cancel)
+
+	writeChunk := func(uploadID string, index int64) {
+		t.Helper()
+		if err := provider.WriteChunk(ctx, nil, uploadID, []byte("12345"), index, 5, storage.ChunkChecksum{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name        string
+		beforeCheck func(uploadID string, sessionState *UploadSessionState)
+		registered  func(uploadID string) int64 // Byte già presenti alla registrazione della sessione
+		orphaned    bool
+	}{
+		{name: "no progress", orphaned: true},
+		{name: "chunk still arriving", beforeCheck: func(uploadID string, sessionState *UploadSessionState) {
+			// Il body di un chunk lento: solo una parte è arrivata al momento del controllo.
+			body := sessionState.CountStreamedBytes(io.NopCloser(strings.NewReader("partial chunk")))
+			io.ReadFull(body, make([]byte, 4))
+		}},
+		{name: "provider size grew", beforeCheck: func(uploadID string, sessionState *UploadSessionState) {
+			writeChunk(uploadID, 0)
+		}},
+		{name: "bytes present at registration", registered: func(uploadID string) int64 {
+			writeChunk(uploadID, 0)
+			return 5
+		}, orphaned: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadID := "upload-" + string(rune('a'+i))
+			if _, err := provider.InitiateUpload(ctx, nil, uploadID, "/"+uploadID+".bin", 10, 5); err != nil {
+				t.Fatal(err)
+			}
+			var registeredSize int64
+			if tt.registered != nil {
+				registeredSize = tt.registered(uploadID)
+			}
+			stale := time.Now().Add(-time.Hour)
+			sessionState := &UploadSessionState{UploadID: uploadID, StorageName: "mem", LastActivity: stale, LastProgress: stale, ObservedSize: registeredSize}
+			h.FileUploadsMutex.Lock()
+			h.OngoingFileUploads[uploadID] = sessionState
+			h.FileUploadsMutex.Unlock()
+			if tt.beforeCheck != nil {
+				tt.beforeCheck(uploadID, sessionState)
+			}
+
+			h.recordUploadProgress()
+			h.FileUploadsMutex.Lock()
+			orphaned, _, timeout := h.isOrphanedUpload(sessionState, time.Now(), time.Minute)
+			h.FileUploadsMutex.Unlock()
+			if timeout != 10*time.Minute {
+				t.Errorf("timeout = %s, want the storage upload_cleanup_timeout 10m", timeout)
+			}
+			if orphaned != tt.orphaned {
+				t.Errorf("orphaned = %t, want %t (LastProgress %s)", orphaned, tt.orphaned, sessionState.LastProgress)
+			}
+		})
+	}
+}
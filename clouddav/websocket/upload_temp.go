@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/local"
+)
+
+// cleanupUploadTempFiles periodically removes stale upload temp files of the local storages and warns
+// when the space they use exceeds upload_temp.max_total_size_mb. A differenza di cleanupOrphanedUploads,
+// che annulla le sessioni inattive, qui si recuperano i file rimasti senza sessione (es. dopo un riavvio).
+func (h *Hub) cleanupUploadTempFiles() {
+	maxAge, err := h.Config().GetUploadTempMaxAge()
+	if err != nil {
+		log.Printf("Error getting upload temp max age from config, using default 24 hours: %v", err)
+		maxAge = 24 * time.Hour
+	}
+	checkInterval, err := h.Config().GetUploadTempCheckInterval()
+	if err != nil {
+		log.Printf("Error getting upload temp check interval from config, using default 15 minutes: %v", err)
+		checkInterval = 15 * time.Minute
+	}
+	if config.IsLogLevel(config.LogLevelInfo) {
+		log.Printf("Upload temp files cleanup started. Max age: %s, Interval: %s", maxAge, checkInterval)
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		h.checkUploadTempFiles(maxAge)
+		select {
+		case <-ticker.C:
+		case <-h.ctx.Done():
+			if config.IsLogLevel(config.LogLevelInfo) {
+				log.Println("Upload temp files cleanup goroutine context cancelled, stopping.")
+			}
+			return
+		}
+	}
+}
+
+func (h *Hub) checkUploadTempFiles(maxAge time.Duration) {
+	maxTotalBytes := h.Config().UploadTemp.MaxTotalSizeMB << 20
+	for _, provider := range storage.GetAllProviders() {
+		localProvider, ok := storage.Unwrap(provider).(*local.LocalFilesystemProvider)
+		if !ok {
+			continue // Gli upload Azure non usano file temporanei
+		}
+		removed, remainingBytes, err := localProvider.CleanupStaleTempFiles(h.ctx, maxAge)
+		if err != nil {
+			log.Printf("Error cleaning up upload temp files of storage '%s': %v", provider.Name(), err)
+			continue
+		}
+		if removed > 0 && config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("Removed %d stale upload temp files of storage '%s'", removed, provider.Name())
+		}
+		if maxTotalBytes > 0 && remainingBytes > maxTotalBytes {
+			log.Printf("Warning: Upload temp files of storage '%s' use %d MB, above upload_temp.max_total_size_mb (%d MB)",
+				provider.Name(), remainingBytes>>20, h.Config().UploadTemp.MaxTotalSizeMB)
+		}
+	}
+}
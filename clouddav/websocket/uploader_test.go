@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"clouddav/auth"
+	"clouddav/config"
+	"clouddav/storage"
+	"clouddav/storage/memory"
+)
+
+func TestUploaderInMetadataAndListing(t *testing.T) {
+	ctx := context.Background()
+	storageCfg := config.StorageConfig{Name: "mem", Type: "memory", RecordUploader: true}
+	provider, err := memory.NewProvider(ctx, &storageCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &auth.UserClaims{Email: "alice@example.com"}
+	for _, upload := range []struct {
+		path   string
+		claims *auth.UserClaims
+	}{{"/alice.txt", claims}, {"/anonymous.txt", nil}} {
+		if _, err := provider.InitiateUpload(ctx, upload.claims, upload.path, upload.path, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := provider.WriteChunk(ctx, upload.claims, upload.path, []byte("x"), 0, 1, storage.ChunkChecksum{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := provider.FinalizeUpload(ctx, upload.claims, upload.path, upload.path, "", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.ReplaceProviders([]storage.StorageProvider{provider}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.ClearRegistry)
+	h := NewHub(ctx, &config.Config{Storages: []config.StorageConfig{storageCfg}})
+	t.Cleanup(h.cancel)
+
+	for path, want := range map[string]string{"/alice.txt": "alice@example.com", "/anonymous.txt": storage.AnonymousUploader} {
+		msg := &Message{Type: "get_item_metadata", RequestID: "r1", Payload: map[string]interface{}{"storage_name": "mem", "item_path": path}}
+		response, err := h.handleClientMessage(ctx, msg, nil)
+		if err != nil {
+			t.Fatalf("get_item_metadata %s: %v", path, err)
+		}
+		metadata, _ := response.Payload.(map[string]interface{})
+		if metadata["uploaded_by"] != want || metadata["uploaded_at"] == nil {
+			t.Errorf("get_item_metadata %s = %v, want uploaded_by %q and uploaded_at", path, response.Payload, want)
+		}
+	}
+
+	msg := &Message{Type: "list_directory", RequestID: "r2", Payload: map[string]interface{}{"storage_name": "mem", "dir_path": "/", "page": 1, "items_per_page": 10}}
+	response, err := h.handleClientMessage(ctx, msg, nil)
+	if err != nil {
+		t.Fatalf("list_directory: %v", err)
+	}
+	data, err := json.Marshal(response.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"uploaded_by":"alice@example.com"`) {
+		t.Errorf("list_directory = %s, want the uploader of alice.txt", data)
+	}
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,52 +9,92 @@ import (
 	"io"
 	"io/ioutil" // ioutil è deprecato da Go 1.16, considera "io" e "os"
 	"log"
+	"log/slog"
 	"net/http"
 	"path/filepath"
 	"strings" // Aggiunto per strings.Contains in readPump error handling
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"clouddav/auth"
 	"clouddav/config"
 	"clouddav/internal/authz"
+	"clouddav/internal/logging"
+	"clouddav/internal/metrics"
 	"clouddav/storage"
 	"clouddav/storage/azureblob"
-	"clouddav/storage/local"
 
 	"github.com/gorilla/websocket"
 )
 
-// Upgrader for WebSocket
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Permetti tutte le origini per semplicità, da rivedere in produzione
-	},
+// newUpgrader returns the WebSocket upgrader of the hub; CheckOrigin legge allowed_origins dalla
+// configurazione corrente, quindi un reload ha effetto sulle connessioni successive.
+func (h *Hub) newUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     h.checkOrigin,
+	}
 }
 
 // Client represents a single WebSocket/Long Polling client.
 type Client struct {
-	conn           *websocket.Conn
-	send           chan Message
-	mu             sync.Mutex        // Protegge conn durante la scrittura
-	isWS           bool              // True se è una connessione WebSocket
-	lastActivity   time.Time         // Ultima attività per client Long Polling
-	claims         *auth.UserClaims  // Claims dell'utente autenticato
-	ctx            context.Context   // Contesto del client, derivato dal Hub
-	cancel         context.CancelFunc// Funzione per cancellare il contesto del client
-	userIdentifier string            // Identificatore univoco per il client (email o ID generato)
-	hub            *Hub              
+	conn               *websocket.Conn
+	send               chan Message
+	mu                 sync.Mutex         // Protegge conn durante la scrittura
+	isWS               bool               // True se è una connessione WebSocket
+	lastActivity       time.Time          // Ultima attività per client Long Polling
+	claims             *auth.UserClaims   // Claims dell'utente autenticato
+	ctx                context.Context    // Contesto del client, derivato dal Hub
+	cancel             context.CancelFunc // Funzione per cancellare il contesto del client
+	userIdentifier     string             // Identificatore univoco per il client (email o ID generato)
+	accessibleStorages []string           // Storage accessibili alla registrazione o all'ultima rivalutazione (solo goroutine Run)
+	sessionID          string             // Identificatore univoco della connessione, per list_sessions e disconnect_session
+	connectedAt        time.Time
+	remoteAddr         string
+	inFlight           atomic.Int64 // Messaggi del client in elaborazione
+	activeStorage      string       // Directory mostrata dal client (set_active_directory), per directory_changed (solo goroutine Run)
+	activeDirectory    string
+	hub                *Hub
 }
 
 // UploadSessionState tracks the state of an ongoing file upload.
 type UploadSessionState struct {
-	Claims       *auth.UserClaims
-	StorageName  string
-	ItemPath     string
+	Claims *auth.UserClaims
+	// ClientID è l'identificatore del client WebSocket/Long Polling che ha avviato l'upload (client_id di
+	// config_update, inviato dal client all'initiate). Per i client anonimi (claims nil) è l'unico modo di
+	// associare l'upload al client, per annullarlo quando si disconnette.
+	ClientID string
+	// UploadID è la chiave della sessione in OngoingFileUploads e nei provider, restituita al client
+	// dall'initiate. ItemPath è la destinazione richiesta, che con auto_rename può cambiare al finalize.
+	UploadID    string
+	StorageName string
+	ItemPath    string
+	// Overwrite e AutoRename sono le opzioni dell'initiate, applicate al finalize se il client non le ripete.
+	Overwrite  bool
+	AutoRename bool
+	// Precondition è la versione del file da sovrascrivere (expected_mod_time, expected_etag dell'initiate),
+	// verificata di nuovo al finalize.
+	Precondition storage.ItemPrecondition
 	LastActivity time.Time
 	ProviderType string
+	// ObservedSize e LastProgress sono aggiornati da cleanupOrphanedUploads interrogando il provider e
+	// contando i byte dei chunk in arrivo (CountStreamedBytes): un upload lento i cui byte continuano a
+	// crescere, anche a metà di un chunk, non viene considerato orfano. ObservedSize va inizializzato con i
+	// byte già presenti quando la sessione viene registrata.
+	ObservedSize int64
+	LastProgress time.Time
+	// streamedBytes conta i byte letti dai body delle richieste di chunk, observedStreamed il valore
+	// dell'ultimo controllo.
+	streamedBytes    atomic.Int64
+	observedStreamed int64
+	// TotalSize è la dimensione dichiarata all'initiate, ReceivedBytes i byte ricevuti (compresi quelli già
+	// presenti alla ripresa): con throughput, aggiornato da RecordChunk, servono a upload_eta.
+	TotalSize     int64
+	ReceivedBytes int64
+	StartedAt     time.Time
+	throughput    uploadThroughput
 }
 
 // Message represents a message sent or received via WebSocket/Long Polling.
@@ -61,6 +102,9 @@ type Message struct {
 	Type      string      `json:"type"`
 	Payload   interface{} `json:"payload"`
 	RequestID string      `json:"request_id,omitempty"`
+	// Solo nelle risposte long polling (vedi setLongPollingHints).
+	NextPollAfterMs int   `json:"next_poll_after_ms,omitempty"`
+	Cursor          int64 `json:"cursor,omitempty"`
 }
 
 // Hub manages WebSocket and Long Polling clients.
@@ -69,33 +113,82 @@ type Hub struct {
 	register           chan *Client
 	unregister         chan *Client
 	broadcast          chan Message
-	config             *config.Config
+	config             atomic.Pointer[config.Config] // Sostituita da SetConfig a ogni reload: leggere con Config()
+	upgrader           *websocket.Upgrader
 	ctx                context.Context
 	cancel             context.CancelFunc
-	OngoingFileUploads map[string]*UploadSessionState
+	OngoingFileUploads map[string]*UploadSessionState // Per upload ID
 	FileUploadsMutex   sync.Mutex
+	recentErrors       *recentErrorStore
+	notifications      *notificationStore
+	// notificationDeliveries passa alla goroutine Run, proprietaria di clients, le notifiche da inviare ai client connessi.
+	notificationDeliveries chan userNotification
+	// knownClaims contiene gli ultimi claims visti per ogni utente (per email), usati da explain_access
+	// per valutare i permessi di un utente diverso dal chiamante.
+	knownClaims         map[string]*auth.UserClaims
+	knownClaimsMu       sync.RWMutex
+	rootCountsCache     *rootCountsCache
+	directoryStatsCache *directoryStatsCache
+	countItemsCache     *countItemsCache
+	reevaluateAccess    chan struct{}
+	load                loadGauges
+	// uploadsDraining è impostato allo shutdown (BeginUploadDrain): i nuovi upload vengono rifiutati.
+	uploadsDraining atomic.Bool
+	// clientQueries passa alla goroutine Run le funzioni che leggono clients (list_sessions, disconnect_session).
+	clientQueries chan func(map[*Client]bool)
+	// directoryChanges passa alla goroutine Run le modifiche registrate nei change log (NotifyDirectoryChanged).
+	directoryChanges chan directoryChangedEvent
 }
 
 // NewHub creates a new Hub.
 func NewHub(ctx context.Context, cfg *config.Config) *Hub {
 	hubCtx, hubCancel := context.WithCancel(ctx)
-	return &Hub{
-		clients:            make(map[*Client]bool),
-		register:           make(chan *Client),
-		unregister:         make(chan *Client),
-		broadcast:          make(chan Message),
-		config:             cfg,
-		ctx:                hubCtx,
-		cancel:             hubCancel,
-		OngoingFileUploads: make(map[string]*UploadSessionState),
-		FileUploadsMutex:   sync.Mutex{},
+	h := &Hub{
+		clients:                make(map[*Client]bool),
+		register:               make(chan *Client),
+		unregister:             make(chan *Client),
+		broadcast:              make(chan Message),
+		ctx:                    hubCtx,
+		cancel:                 hubCancel,
+		OngoingFileUploads:     make(map[string]*UploadSessionState),
+		FileUploadsMutex:       sync.Mutex{},
+		recentErrors:           newRecentErrorStore(cfg),
+		notifications:          newNotificationStore(cfg),
+		notificationDeliveries: make(chan userNotification),
+		knownClaims:            make(map[string]*auth.UserClaims),
+		rootCountsCache:        newRootCountsCache(),
+		directoryStatsCache:    newDirectoryStatsCache(),
+		countItemsCache:        newCountItemsCache(),
+		reevaluateAccess:       make(chan struct{}, 1),
+		clientQueries:          make(chan func(map[*Client]bool)),
+		directoryChanges:       make(chan directoryChangedEvent, directoryChangesBuffer),
 	}
+	h.config.Store(cfg)
+	h.upgrader = h.newUpgrader()
+	h.registerLoadMetrics()
+	return h
+}
+
+// Config returns the configuration currently in use.
+func (h *Hub) Config() *config.Config {
+	return h.config.Load()
+}
+
+// SetConfig replaces the configuration used by the Hub and by the HTTP handlers (es. dopo un reload).
+// Le richieste già in corso completano con la configurazione che avevano letto.
+func (h *Hub) SetConfig(cfg *config.Config) {
+	h.config.Store(cfg)
 }
 
 // Run starts the Hub, managing client registration/deregistration.
 func (h *Hub) Run() {
 	go h.cleanupLongPollingClients()
 	go h.cleanupOrphanedUploads()
+	go h.cleanupRecentErrors()
+	go h.cleanupNotifications()
+	go h.cleanupUploadTempFiles()
+	go h.cleanupRateLimiters()
+	go h.cleanupTrash()
 
 	if config.IsLogLevel(config.LogLevelInfo) {
 		log.Println("Hub running...")
@@ -105,14 +198,16 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
+			client.accessibleStorages = accessibleStorageNames(client.ctx, client.claims, h.Config())
 			if config.IsLogLevel(config.LogLevelInfo) {
 				log.Printf("Client registered (User: %s, WS: %t). Total clients: %d", client.userIdentifier, client.isWS, len(h.clients))
 			}
+			initialPayload := h.initialConfigPayload()
+			initialPayload["client_id"] = client.userIdentifier
+			initialPayload["session_id"] = client.sessionID
 			initialConfigMsg := Message{
-				Type: "config_update",
-				Payload: map[string]interface{}{
-					"client_ping_interval_ms": h.config.ClientPingIntervalMs,
-				},
+				Type:    "config_update",
+				Payload: initialPayload,
 			}
 			go func(c *Client, msg Message) {
 				select {
@@ -142,79 +237,34 @@ func (h *Hub) Run() {
 					log.Printf("Client unregistered (User: %s, WS: %t). Total clients: %d", client.userIdentifier, client.isWS, len(h.clients))
 				}
 
-				uploadsToCancelForProvider := []struct{ UploadKey string; SessionState *UploadSessionState }{}
-				h.FileUploadsMutex.Lock()
-				if config.IsLogLevel(config.LogLevelDebug) {
-					log.Printf("Unregister: Locked FileUploadsMutex for client %s", client.userIdentifier)
-				}
-				tempKeysToDelete := []string{}
-				for uploadKey, sessionState := range h.OngoingFileUploads {
-					clientMatch := false
-					if client.claims != nil && sessionState.Claims != nil && client.claims.Email == sessionState.Claims.Email {
-						clientMatch = true
-					} else if client.claims == nil && sessionState.Claims != nil && client.userIdentifier != "" && client.userIdentifier == sessionState.Claims.Subject {
-						// Gestione per client anonimi se userIdentifier è stato usato in Claims.Subject
-						clientMatch = true
-					}
-					// Aggiungere altre logiche di match se necessario per client anonimi
-
-					if clientMatch {
-						uploadsToCancelForProvider = append(uploadsToCancelForProvider, struct{ UploadKey string; SessionState *UploadSessionState }{uploadKey, sessionState})
-						tempKeysToDelete = append(tempKeysToDelete, uploadKey)
-						if config.IsLogLevel(config.LogLevelDebug) {
-							log.Printf("Unregister: Identified upload %s for cancellation for client %s", uploadKey, client.userIdentifier)
-						}
-					}
-				}
-
-				for _, key := range tempKeysToDelete {
-					delete(h.OngoingFileUploads, key)
-					if config.IsLogLevel(config.LogLevelDebug) {
-						log.Printf("Unregister: Removed upload %s from OngoingFileUploads for client %s", key, client.userIdentifier)
-					}
-				}
-				h.FileUploadsMutex.Unlock()
-				if config.IsLogLevel(config.LogLevelDebug) {
-					log.Printf("Unregister: Unlocked FileUploadsMutex for client %s", client.userIdentifier)
-				}
+				uploadsToCancelForProvider := h.removeUploadsMatching(func(_ string, sessionState *UploadSessionState) bool {
+					return uploadBelongsToClient(sessionState, client)
+				})
 
 				if len(uploadsToCancelForProvider) > 0 {
 					if config.IsLogLevel(config.LogLevelInfo) {
 						log.Printf("Initiating cleanup for %d uploads from disconnected client '%s'", len(uploadsToCancelForProvider), client.userIdentifier)
 					}
-					go func(uploads []struct{ UploadKey string; SessionState *UploadSessionState }, disconnectedClientIdentifier string) {
+					go func(uploads []pendingUploadCancel, disconnectedClientIdentifier string) {
 						for _, upload := range uploads {
-							claimsForCleanup := upload.SessionState.Claims
-							provider, ok := storage.GetProvider(upload.SessionState.StorageName)
-							if !ok {
-								log.Printf("Warning: Storage provider '%s' not found during disconnected client cleanup for '%s'", upload.SessionState.StorageName, upload.SessionState.ItemPath)
-								continue
+							if cancelErr := cancelProviderUpload(upload); cancelErr != nil {
+								log.Printf("Error during cleanup of upload '%s' (storage: %s, path: %s) for disconnected client '%s': %v", upload.UploadKey, upload.SessionState.StorageName, upload.SessionState.ItemPath, disconnectedClientIdentifier, cancelErr)
+							} else if config.IsLogLevel(config.LogLevelInfo) {
+								log.Printf("Successfully cleaned up upload '%s' (storage: %s, path: %s) for disconnected client '%s'", upload.UploadKey, upload.SessionState.StorageName, upload.SessionState.ItemPath, disconnectedClientIdentifier)
 							}
-							var cancelErr error
-							cleanupCtx, cleanupCancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
-							func() {
-								defer cleanupCancelFunc()
-								switch p := provider.(type) {
-								case *local.LocalFilesystemProvider:
-									cancelErr = p.CancelUpload(claimsForCleanup, upload.SessionState.ItemPath)
-								case *azureblob.AzureBlobStorageProvider:
-									cancelErr = p.CancelUpload(cleanupCtx, claimsForCleanup, upload.SessionState.ItemPath)
-								default:
-									log.Printf("Warning: CancelUpload not implemented for storage type '%s' during disconnected client cleanup.", provider.Type())
-									return
-								}
-								if cancelErr != nil {
-									log.Printf("Error during cleanup of upload '%s' (storage: %s, path: %s) for disconnected client '%s': %v", upload.UploadKey, upload.SessionState.StorageName, upload.SessionState.ItemPath, disconnectedClientIdentifier, cancelErr)
-								} else {
-									if config.IsLogLevel(config.LogLevelInfo) {
-										log.Printf("Successfully cleaned up upload '%s' (storage: %s, path: %s) for disconnected client '%s'", upload.UploadKey, upload.SessionState.StorageName, upload.SessionState.ItemPath, disconnectedClientIdentifier)
-									}
-								}
-							}()
+							h.notifyUploadCancelled(upload.SessionState, "client_disconnected", "Upload cancelled because the client disconnected")
 						}
 					}(uploadsToCancelForProvider, client.userIdentifier)
 				}
 			}
+		case <-h.reevaluateAccess:
+			h.reevaluateClientsAccess()
+		case query := <-h.clientQueries:
+			query(h.clients)
+		case event := <-h.directoryChanges:
+			h.deliverDirectoryChanged(event)
+		case delivery := <-h.notificationDeliveries:
+			h.deliverNotification(delivery.user, delivery.notification)
 		case message := <-h.broadcast:
 			for client := range h.clients {
 				select {
@@ -249,6 +299,7 @@ func (h *Hub) Run() {
 			log.Println("Hub shutdown complete.")
 			return
 		}
+		h.load.activeClients.Store(int64(len(h.clients)))
 	}
 }
 
@@ -289,7 +340,7 @@ func (h *Hub) cleanupOrphanedUploads() {
 	ticker := time.NewTicker(cleanupInterval)
 	defer ticker.Stop()
 
-	uploadCleanupTimeout, err := h.config.GetUploadCleanupTimeout()
+	uploadCleanupTimeout, err := h.Config().GetUploadCleanupTimeout()
 	if err != nil {
 		log.Printf("Error getting upload cleanup timeout from config, using default 10 minutes: %v", err)
 		uploadCleanupTimeout = 10 * time.Minute
@@ -304,73 +355,36 @@ func (h *Hub) cleanupOrphanedUploads() {
 			if config.IsLogLevel(config.LogLevelDebug) {
 				log.Println("Running orphaned uploads cleanup check...")
 			}
+			h.recordUploadProgress()
 			now := time.Now()
-			uploadsToCancelForProvider := []struct{ UploadKey string; SessionState *UploadSessionState }{}
-
-			h.FileUploadsMutex.Lock()
-			if config.IsLogLevel(config.LogLevelDebug) {
-				log.Println("CleanupOrphaned: Locked FileUploadsMutex")
-			}
-			tempKeysToDelete := []string{}
-			for uploadKey, sessionState := range h.OngoingFileUploads {
-				if now.Sub(sessionState.LastActivity) > uploadCleanupTimeout {
-					userEmail := "anonymous"
-					if sessionState.Claims != nil {
-						userEmail = sessionState.Claims.Email
-					}
-					if config.IsLogLevel(config.LogLevelInfo) {
-						log.Printf("Detected orphaned upload: %s (User: %s, Storage: %s, Path: %s, LastActivity: %s, Timeout: %s)",
-							uploadKey, userEmail, sessionState.StorageName, sessionState.ItemPath, sessionState.LastActivity.Format(time.RFC3339), uploadCleanupTimeout.String())
-					}
-					uploadsToCancelForProvider = append(uploadsToCancelForProvider, struct{ UploadKey string; SessionState *UploadSessionState }{uploadKey, sessionState})
-					tempKeysToDelete = append(tempKeysToDelete, uploadKey)
+			uploadsToCancelForProvider := h.removeUploadsMatching(func(uploadKey string, sessionState *UploadSessionState) bool {
+				orphaned, lastActivity, timeout := h.isOrphanedUpload(sessionState, now, uploadCleanupTimeout)
+				if !orphaned {
+					return false
 				}
-			}
-
-			for _, key := range tempKeysToDelete {
-				delete(h.OngoingFileUploads, key)
-				if config.IsLogLevel(config.LogLevelDebug) {
-					log.Printf("CleanupOrphaned: Removed orphaned upload %s from OngoingFileUploads map", key)
+				userEmail := "anonymous"
+				if sessionState.Claims != nil {
+					userEmail = sessionState.Claims.Email
 				}
-			}
-			h.FileUploadsMutex.Unlock()
-			if config.IsLogLevel(config.LogLevelDebug) {
-				log.Println("CleanupOrphaned: Unlocked FileUploadsMutex")
-			}
+				if config.IsLogLevel(config.LogLevelInfo) {
+					log.Printf("Detected orphaned upload: %s (User: %s, Storage: %s, Path: %s, LastActivity: %s, Timeout: %s)",
+						uploadKey, userEmail, sessionState.StorageName, sessionState.ItemPath, lastActivity.Format(time.RFC3339), timeout.String())
+				}
+				return true
+			})
 
 			if len(uploadsToCancelForProvider) > 0 {
 				if config.IsLogLevel(config.LogLevelInfo) {
 					log.Printf("Initiating provider-level cleanup for %d orphaned uploads.", len(uploadsToCancelForProvider))
 				}
-				go func(uploads []struct{ UploadKey string; SessionState *UploadSessionState }) {
+				go func(uploads []pendingUploadCancel) {
 					for _, upload := range uploads {
-						claimsForCleanup := upload.SessionState.Claims
-						provider, ok := storage.GetProvider(upload.SessionState.StorageName)
-						if !ok {
-							log.Printf("Warning: Storage provider '%s' not found during orphaned upload cleanup for '%s'", upload.SessionState.StorageName, upload.SessionState.ItemPath)
-							continue
+						if cancelErr := cancelProviderUpload(upload); cancelErr != nil {
+							log.Printf("Error during cleanup of orphaned upload '%s' (storage: %s, path: %s): %v", upload.UploadKey, upload.SessionState.StorageName, upload.SessionState.ItemPath, cancelErr)
+						} else if config.IsLogLevel(config.LogLevelInfo) {
+							log.Printf("Successfully cleaned up orphaned upload '%s' (storage: %s, path: %s)", upload.UploadKey, upload.SessionState.StorageName, upload.SessionState.ItemPath)
 						}
-						var cancelErr error
-						cleanupCtx, cleanupCancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
-						func() {
-							defer cleanupCancelFunc()
-							switch p := provider.(type) {
-							case *local.LocalFilesystemProvider:
-								cancelErr = p.CancelUpload(claimsForCleanup, upload.SessionState.ItemPath)
-							case *azureblob.AzureBlobStorageProvider:
-								cancelErr = p.CancelUpload(cleanupCtx, claimsForCleanup, upload.SessionState.ItemPath)
-							default:
-								log.Printf("Warning: CancelUpload not implemented for storage type '%s' during orphaned upload cleanup.", provider.Type())
-								return
-							}
-							if cancelErr != nil {
-								log.Printf("Error during cleanup of orphaned upload '%s' (storage: %s, path: %s): %v", upload.UploadKey, upload.SessionState.StorageName, upload.SessionState.ItemPath, cancelErr)
-							} else {
-								if config.IsLogLevel(config.LogLevelInfo) {
-									log.Printf("Successfully cleaned up orphaned upload '%s' (storage: %s, path: %s)", upload.UploadKey, upload.SessionState.StorageName, upload.SessionState.ItemPath)
-								}
-							}
-						}()
+						h.notifyUploadCancelled(upload.SessionState, "inactive", "Upload cancelled after a period of inactivity")
 					}
 				}(uploadsToCancelForProvider)
 			} else {
@@ -387,12 +401,22 @@ func (h *Hub) cleanupOrphanedUploads() {
 	}
 }
 
+// uploadBelongsToClient reports whether an upload is cancelled when client disconnects: per gli utenti
+// autenticati gli upload con la stessa email, per i client anonimi (sessioni con claims nil) quelli
+// avviati con il suo client_id.
+func uploadBelongsToClient(sessionState *UploadSessionState, client *Client) bool {
+	if client.claims != nil {
+		return sessionState.Claims != nil && client.claims.Email == sessionState.Claims.Email
+	}
+	return sessionState.ClientID != "" && sessionState.ClientID == client.userIdentifier
+}
+
 // ServeWs handles WebSocket connection requests after user authentication checks.
 func (h *Hub) ServeWs(w http.ResponseWriter, r *http.Request, claims *auth.UserClaims) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		// Upgrade ha già risposto al client (es. 403 per un'origine non consentita).
 		log.Printf("Error upgrading to WebSocket: %v", err)
-		http.Error(w, "Unable to establish WebSocket connection", http.StatusInternalServerError)
 		return
 	}
 
@@ -412,7 +436,10 @@ func (h *Hub) ServeWs(w http.ResponseWriter, r *http.Request, claims *auth.UserC
 		cancel:         clientCancel,
 		userIdentifier: userIdent,
 		lastActivity:   time.Now(),
-		hub:            h, 
+		sessionID:      newSessionID(),
+		connectedAt:    time.Now(),
+		remoteAddr:     remoteAddress(r),
+		hub:            h,
 	}
 	h.register <- client
 
@@ -440,18 +467,20 @@ func (h *Hub) ServeLongPolling(w http.ResponseWriter, r *http.Request, claims *a
 		if config.IsLogLevel(config.LogLevelDebug) {
 			log.Printf("LP Incoming Message (User: %s, Server): Type=%s, RequestID=%s, Payload=%+v", userIdent, msg.Type, msg.RequestID, msg.Payload)
 		}
-		reqCtx := r.Context()
+		reqCtx := withRemoteAddress(r.Context(), remoteAddress(r))
 		response, processErr := h.handleClientMessage(reqCtx, &msg, claims)
+		h.recordMessageError(claims, &msg, response, processErr)
 		if processErr != nil {
-			log.Printf("Error processing Long Polling message (User: %s): %v", userIdent, processErr)
+			slog.Error("Error processing long polling message", logging.KeyUser, userIdent, logging.KeyMessageType, msg.Type, logging.KeyRequestID, msg.RequestID, logging.KeyError, processErr)
 			response = Message{
 				Type:      "error",
-				Payload:   map[string]string{"error": processErr.Error()},
+				Payload:   processErrorPayload(processErr),
 				RequestID: msg.RequestID,
 			}
 		}
+		h.setLongPollingHints(&response, claims)
 		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("LP Outgoing Response (User: %s, Server): Type=%s, RequestID=%s, Payload=%+v", userIdent, response.Type, response.RequestID, response.Payload)
+			log.Printf("LP Outgoing Response (User: %s, Server): Type=%s, RequestID=%s, NextPollAfterMs=%d, Cursor=%d, Payload=%+v", userIdent, response.Type, response.RequestID, response.NextPollAfterMs, response.Cursor, response.Payload)
 		}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -460,11 +489,10 @@ func (h *Hub) ServeLongPolling(w http.ResponseWriter, r *http.Request, claims *a
 	} else if r.Method == http.MethodGet {
 		w.Header().Set("Content-Type", "application/json")
 		initialConfigMsg := Message{
-			Type: "config_update",
-			Payload: map[string]interface{}{
-				"client_ping_interval_ms": h.config.ClientPingIntervalMs,
-			},
+			Type:    "config_update",
+			Payload: h.initialConfigPayload(),
 		}
+		h.setLongPollingHints(&initialConfigMsg, claims)
 		if config.IsLogLevel(config.LogLevelDebug) {
 			log.Printf("LP GET request (User: %s), sending initial config.", userIdent)
 		}
@@ -480,7 +508,7 @@ func (c *Client) readPump() {
 		c.hub.unregister <- c 
 	}()
 
-	pongWait := time.Duration(c.hub.config.ClientPingIntervalMs*3) * time.Millisecond 
+	pongWait := time.Duration(c.hub.Config().ClientPingIntervalMs*3) * time.Millisecond
 	if pongWait <= 0 {
 		pongWait = 60 * time.Second
 	}
@@ -532,14 +560,20 @@ func (c *Client) readPump() {
 
 		msgCtx, cancelMsgCtx := context.WithTimeout(c.ctx, 60*time.Second)
 
+		msgCtx = withMessageSender(msgCtx, c.queueMessage)
+		msgCtx = withSessionID(msgCtx, c.sessionID)
+		msgCtx = withRemoteAddress(msgCtx, c.remoteAddr)
+		c.inFlight.Add(1)
 		go func(ctx context.Context, message Message) {
 			defer cancelMsgCtx()
-			response, processErr := c.hub.handleClientMessage(ctx, &message, c.claims) 
+			defer c.inFlight.Add(-1)
+			response, processErr := c.hub.handleClientMessage(ctx, &message, c.claims)
+			c.hub.recordMessageError(c.claims, &message, response, processErr)
 			if processErr != nil {
-				log.Printf("Error processing message (User: %s, Type: %s, ReqID: %s): %v", c.userIdentifier, message.Type, message.RequestID, processErr)
+				slog.Error("Error processing message", logging.KeyUser, c.userIdentifier, logging.KeyMessageType, message.Type, logging.KeyRequestID, message.RequestID, logging.KeyError, processErr)
 				response = Message{
 					Type:      "error",
-					Payload:   map[string]string{"error": processErr.Error()},
+					Payload:   processErrorPayload(processErr),
 					RequestID: message.RequestID,
 				}
 			}
@@ -564,7 +598,7 @@ func (c *Client) readPump() {
 // writePump sends messages to the WebSocket client.
 func (c *Client) writePump() {
 	// Intervallo di ping inviato dal server al client WebSocket
-	pingPeriod := time.Duration(c.hub.config.ClientPingIntervalMs) * time.Millisecond 
+	pingPeriod := time.Duration(c.hub.Config().ClientPingIntervalMs) * time.Millisecond
 	if pingPeriod <= 0 {
 		pingPeriod = 30 * time.Second // Fallback
 	}
@@ -621,6 +655,15 @@ func (c *Client) writePump() {
 	}
 }
 
+// processErrorPayload is the payload of the error message sent for an error returned by a handler. Uno
+// storage saturo (max_concurrent_operations) ha un error_code, così il client sa che può riprovare.
+func processErrorPayload(err error) map[string]string {
+	if errors.Is(err, storage.ErrUnavailable) {
+		return map[string]string{"error": err.Error(), "error_code": "STORAGE_BUSY"}
+	}
+	return map[string]string{"error": err.Error()}
+}
+
 // handleClientMessage processes messages received from clients (WS or LP).
 func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *auth.UserClaims) (Message, error) {
 	var response Message
@@ -645,27 +688,104 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 		userIdentifier = "anonymous"
 	}
 
-	if config.IsLogLevel(config.LogLevelDebug) {
-		log.Printf("Processing message (User: %s, Type: %s, ReqID: %s)", userIdentifier, msg.Type, msg.RequestID)
+	h.rememberClaims(claims)
+
+	if payload, limited := h.rateLimitMessage(ctx, claims, msg); limited {
+		response.Type = "error"
+		response.Payload = payload
+		return response, nil
 	}
 
+	h.load.inFlightRequests.Add(1)
+	defer h.load.inFlightRequests.Add(-1)
+	metrics.WebSocketMessages.Inc(messageTypeLabel(msg.Type))
+	normalizePayloadPaths(msg.Payload, h.Config())
+
+	slog.Debug("Processing message", logging.KeyUser, userIdentifier, logging.KeyMessageType, msg.Type, logging.KeyRequestID, msg.RequestID)
+
 	switch msg.Type {
 	case "get_filesystems":
-		accessibleStorages := authz.GetAccessibleStorages(ctx, claims, h.config)
+		accessibleStorages := authz.GetAccessibleStorages(ctx, claims, h.Config())
 		response.Payload = accessibleStorages
 		if config.IsLogLevel(config.LogLevelDebug) {
 			log.Printf("get_filesystems_response (User: %s, ReqID: %s): Found %d accessible storages", userIdentifier, msg.RequestID, len(accessibleStorages))
 		}
 
+	case "root_counts":
+		counts := h.rootCounts(ctx, claims)
+		response.Payload = map[string]interface{}{
+			"storages": counts,
+		}
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("root_counts_response (User: %s, ReqID: %s): Counted %d storages", userIdentifier, msg.RequestID, len(counts))
+		}
+
+	case "read_file_stream":
+		return h.readFileStream(ctx, msg, claims, userIdentifier)
+
+	case "read_file_lines":
+		return h.readFileLines(ctx, msg, claims, userIdentifier)
+
+	case "upload_eta":
+		return h.uploadETA(ctx, msg, claims, userIdentifier)
+
+	case "search":
+		return h.search(ctx, msg, claims, userIdentifier)
+
+	case "get_directory_size":
+		return h.getDirectorySize(ctx, msg, claims, userIdentifier)
+
+	case "count_items":
+		return h.countItems(ctx, msg, claims, userIdentifier)
+
+	case "directory_stats":
+		return h.directoryStats(ctx, msg, claims, userIdentifier)
+
+	case "get_manifest":
+		return h.getManifest(ctx, msg, claims, userIdentifier)
+
+	case "list_directory_recursive":
+		return h.listDirectoryRecursive(ctx, msg, claims, userIdentifier)
+
+	case "list_archive":
+		return h.listArchive(ctx, msg, claims, userIdentifier)
+
+	case "extract_archive_entry":
+		return h.extractArchiveEntry(ctx, msg, claims, userIdentifier)
+
+	case "get_notifications":
+		return h.getNotifications(ctx, msg, claims, userIdentifier)
+
+	case "mark_notifications_read":
+		return h.markNotificationsRead(ctx, msg, claims, userIdentifier)
+
+	case "server_status":
+		response.Payload = h.serverStatus()
+
+	case "list_sessions":
+		return h.listSessions(ctx, msg, claims, userIdentifier)
+
+	case "disconnect_session":
+		return h.disconnectSession(ctx, msg, claims, userIdentifier)
+
+	case "set_active_directory":
+		return h.setActiveDirectory(ctx, msg, claims, userIdentifier)
+
 	case "list_directory":
 		var payload struct {
-			StorageName     string `json:"storage_name"`
-			DirPath         string `json:"dir_path"`
-			Page            int    `json:"page"`
-			ItemsPerPage    int    `json:"items_per_page"`
-			NameFilter      string `json:"name_filter"`
-			TimestampFilter string `json:"timestamp_filter"`
-			OnlyDirectories bool   `json:"only_directories,omitempty"` // << MODIFICA: Campo aggiunto
+			StorageName     string      `json:"storage_name"`
+			DirPath         string      `json:"dir_path"`
+			Page            int         `json:"page"`
+			ItemsPerPage    int         `json:"items_per_page"`
+			NameFilter      string      `json:"name_filter"` // Legacy: ignorato se è presente Filter
+			Filter          *NameFilter `json:"filter,omitempty"`
+			TimestampFilter string      `json:"timestamp_filter"`           // Legacy: equivale a modified_after
+			ModifiedAfter   string      `json:"modified_after,omitempty"`   // RFC 3339, esclusivo
+			ModifiedBefore  string      `json:"modified_before,omitempty"`  // RFC 3339, inclusivo
+			OnlyDirectories bool        `json:"only_directories,omitempty"` // << MODIFICA: Campo aggiunto
+			OnlyFiles       bool        `json:"only_files,omitempty"`
+			Fields          []string    `json:"fields,omitempty"`    // Campi di ogni elemento da restituire (es. ["name","is_dir"]); vuoto = tutti
+			SinceSeq        *uint64     `json:"since_seq,omitempty"` // Cursore "seq" di una risposta precedente: restituisce solo le modifiche
 		}
 		payloadBytes, err := json.Marshal(msg.Payload)
 		if err != nil {
@@ -674,8 +794,35 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
 			return response, fmt.Errorf("invalid list_directory payload: %w", err)
 		}
+		if payload.OnlyDirectories && payload.OnlyFiles {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "only_directories and only_files are mutually exclusive"}
+			return response, nil
+		}
+		nameFilter, err := resolveNameFilter(payload.Filter, payload.NameFilter)
+		if err != nil {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": err.Error()}
+			return response, nil
+		}
+		if err := validateListFields(payload.Fields); err != nil {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": err.Error()}
+			return response, nil
+		}
+		if payload.TimestampFilter != "" && payload.ModifiedAfter == "" {
+			if _, parseErr := time.Parse(time.RFC3339, payload.TimestampFilter); parseErr != nil {
+				log.Printf("Warning: Invalid timestamp filter format for list_directory (User: %s, ReqID: %s): %v", userIdentifier, msg.RequestID, parseErr)
+			}
+		}
+		modTimeRange, err := parseModTimeRange(payload.ModifiedAfter, payload.ModifiedBefore, payload.TimestampFilter)
+		if err != nil {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": err.Error(), "error_code": "INVALID_DATE_RANGE"}
+			return response, nil
+		}
 
-		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "read", h.config); err != nil {
+		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "read", h.Config()); err != nil {
 			if errors.Is(err, storage.ErrPermissionDenied) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Access denied: read permission required"}
@@ -689,7 +836,7 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
 		}
 
-		itemsPerPage := h.config.Pagination.ItemsPerPage
+		itemsPerPage := h.Config().Pagination.ItemsPerPage
 		if payload.ItemsPerPage > 0 {
 			itemsPerPage = payload.ItemsPerPage
 		}
@@ -698,38 +845,68 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			page = 1
 		}
 
-		var tFilter *time.Time
-		if payload.TimestampFilter != "" {
-			parsedTime, parseErr := time.Parse(time.RFC3339, payload.TimestampFilter)
-			if parseErr != nil {
-				log.Printf("Warning: Invalid timestamp filter format for list_directory (User: %s, ReqID: %s): %v", userIdentifier, msg.RequestID, parseErr)
-			} else {
-				tFilter = &parsedTime
+		// Il cursore va letto prima dell'elenco: le modifiche concorrenti verranno riproposte, non perse.
+		var seq uint64
+		if changeLog, ok := storage.GetChangeLog(payload.StorageName); ok {
+			seq = changeLog.CurrentSeq()
+		}
+		if payload.SinceSeq != nil {
+			changes, changesSeq, incremental, err := listDirectoryChanges(ctx, provider, claims, payload.StorageName, payload.DirPath, *payload.SinceSeq, payload.OnlyDirectories, payload.OnlyFiles)
+			if err != nil {
+				return response, fmt.Errorf("error reading changes of '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.DirPath, userIdentifier, msg.RequestID, err)
+			}
+			if incremental {
+				response.Payload = map[string]interface{}{
+					"storage_name": payload.StorageName,
+					"dir_path":     payload.DirPath,
+					"incremental":  true,
+					"since_seq":    *payload.SinceSeq,
+					"seq":          changesSeq,
+					"changes":      changes,
+				}
+				slog.Debug("Listed directory changes", logging.KeyUser, userIdentifier, logging.KeyRequestID, msg.RequestID, logging.KeyStorage, payload.StorageName, logging.KeyPath, payload.DirPath, "since_seq", *payload.SinceSeq, "changes", len(changes))
+				return response, nil
 			}
 		}
 
 		// << MODIFICA: Passa payload.OnlyDirectories al provider
-		listResponse, err := provider.ListItems(ctx, claims, payload.DirPath, page, itemsPerPage, payload.NameFilter, tFilter, payload.OnlyDirectories)
+		listStart := time.Now()
+		listResponse, err := provider.ListItems(ctx, claims, payload.DirPath, page, itemsPerPage, nameFilter, modTimeRange, payload.OnlyDirectories, payload.OnlyFiles)
+		metrics.ListItemsDuration.ObserveSince(listStart, payload.StorageName)
 		if err != nil {
 			if errors.Is(err, storage.ErrNotFound) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Directory not found"}
 				return response, nil
 			}
+			if errors.Is(err, storage.ErrIsSymlink) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Cannot list a symbolic link: follow_symlinks is disabled", "error_code": "IS_A_SYMLINK"}
+				return response, nil
+			}
 			return response, fmt.Errorf("error listing items from storage '%s' (User: %s, ReqID: %s): %w", payload.StorageName, userIdentifier, msg.RequestID, err)
 		}
+		// Items, al livello più esterno, prevale su ListItemsResponse.Items nella serializzazione JSON.
+		var items interface{} = listResponse.Items
+		if len(payload.Fields) > 0 {
+			items = projectListItems(listResponse.Items, payload.Fields)
+		}
 		response.Payload = struct {
 			*storage.ListItemsResponse
-			StorageName string `json:"storage_name"`
-			DirPath     string `json:"dir_path"`
+			Items       interface{} `json:"items"`
+			StorageName string      `json:"storage_name"`
+			DirPath     string      `json:"dir_path"`
+			Seq         uint64      `json:"seq,omitempty"`   // Cursore per since_seq
+			Reset       bool        `json:"reset,omitempty"` // since_seq richiesto ma non servibile: elenco completo
 		}{
 			ListItemsResponse: listResponse,
-			StorageName:       payload.StorageName, 
-			DirPath:           payload.DirPath,     
-		}
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("list_directory_response (User: %s, ReqID: %s): Listed %d items for %s/%s", userIdentifier, msg.RequestID, len(listResponse.Items), payload.StorageName, payload.DirPath)
+			Items:             items,
+			StorageName:       payload.StorageName,
+			DirPath:           payload.DirPath,
+			Seq:               seq,
+			Reset:             payload.SinceSeq != nil,
 		}
+		slog.Debug("Listed directory", logging.KeyUser, userIdentifier, logging.KeyRequestID, msg.RequestID, logging.KeyStorage, payload.StorageName, logging.KeyPath, payload.DirPath, "items", len(listResponse.Items))
 
 	case "read_file":
 		var payload struct {
@@ -744,7 +921,7 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			return response, fmt.Errorf("invalid read_file payload: %w", err)
 		}
 
-		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ItemPath, "read", h.config); err != nil {
+		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ItemPath, "read", h.Config()); err != nil {
 			if errors.Is(err, storage.ErrPermissionDenied) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Access denied: read permission required"}
@@ -766,6 +943,12 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			} else if errors.Is(err, storage.ErrPermissionDenied) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			} else if errors.Is(err, storage.ErrIsDirectory) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Cannot read a directory", "error_code": "IS_A_DIRECTORY"}
+			} else if errors.Is(err, storage.ErrIsSymlink) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Cannot read a symbolic link: follow_symlinks is disabled", "error_code": "IS_A_SYMLINK"}
 			} else {
 				return response, fmt.Errorf("error opening item '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
 			}
@@ -786,9 +969,7 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			return response, fmt.Errorf("error reading item content '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
 		}
 		response.Payload = string(content)
-		if config.IsLogLevel(config.LogLevelDebug) {
-			log.Printf("read_file_response (User: %s, ReqID: %s): Read %d bytes from %s/%s", userIdentifier, msg.RequestID, len(content), payload.StorageName, payload.ItemPath)
-		}
+		slog.Debug("Read file", logging.KeyUser, userIdentifier, logging.KeyRequestID, msg.RequestID, logging.KeyStorage, payload.StorageName, logging.KeyPath, payload.ItemPath, "bytes", len(content))
 
 	case "create_directory":
 		var payload struct {
@@ -803,7 +984,7 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			return response, fmt.Errorf("invalid create_directory payload: %w", err)
 		}
 
-		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "write", h.config); err != nil {
+		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "write", h.Config()); err != nil {
 			if errors.Is(err, storage.ErrPermissionDenied) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Access denied: write permission required"}
@@ -834,14 +1015,16 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			return response, nil
 		}
 		response.Payload = map[string]string{"status": "success", "dir_path": payload.DirPath, "name": filepath.Base(payload.DirPath)}
-		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("create_directory_response (User: %s, ReqID: %s): Successfully created directory %s/%s", userIdentifier, msg.RequestID, payload.StorageName, payload.DirPath)
-		}
+		slog.Info("Created directory", logging.KeyUser, userIdentifier, logging.KeyRequestID, msg.RequestID, logging.KeyStorage, payload.StorageName, logging.KeyPath, payload.DirPath)
 
 	case "delete_item":
 		var payload struct {
 			StorageName string `json:"storage_name"`
 			ItemPath    string `json:"item_path"`
+			// ExpectedModTime ed ExpectedETag sono la versione dell'elemento vista dal client: se è cambiata
+			// l'eliminazione viene rifiutata con PRECONDITION_FAILED.
+			ExpectedModTime string `json:"expected_mod_time"`
+			ExpectedETag    string `json:"expected_etag"`
 		}
 		payloadBytes, err := json.Marshal(msg.Payload)
 		if err != nil {
@@ -851,7 +1034,8 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			return response, fmt.Errorf("invalid delete_item payload: %w", err)
 		}
 
-		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ItemPath, "write", h.config); err != nil {
+		// L'eliminazione di una directory è ricorsiva: serve il permesso di scrittura anche sulle regole sotto di essa.
+		if err := authz.CheckRecursiveWriteAccess(ctx, claims, payload.StorageName, payload.ItemPath, h.Config()); err != nil {
 			if errors.Is(err, storage.ErrPermissionDenied) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Access denied: write permission required"}
@@ -864,27 +1048,273 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 		if !ok {
 			return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
 		}
+		precondition, err := storage.ParseItemPrecondition(payload.ExpectedModTime, payload.ExpectedETag)
+		if err != nil {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": err.Error()}
+			return response, nil
+		}
 		itemName := filepath.Base(payload.ItemPath)
-		err = provider.DeleteItem(ctx, claims, payload.ItemPath)
+		if precondition.IsZero() {
+			err = provider.DeleteItem(ctx, claims, payload.ItemPath)
+		} else {
+			err = deleteItemIfUnchanged(ctx, provider, claims, payload.StorageName, payload.ItemPath, precondition)
+		}
 		if err != nil {
 			if errors.Is(err, storage.ErrNotFound) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Item not found"}
+			} else if errors.Is(err, storage.ErrPreconditionFailed) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": err.Error(), "error_code": "PRECONDITION_FAILED"}
 			} else if errors.Is(err, storage.ErrPermissionDenied) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Access denied: write permission required"}
 			} else if errors.Is(err, storage.ErrNotImplemented) {
 				response.Type = "error"
-				response.Payload = map[string]string{"error": "Delete not supported for this storage type"}
+				if precondition.ETag != "" {
+					response.Payload = map[string]string{"error": err.Error()} // expected_etag su uno storage senza ETag
+				} else {
+					response.Payload = map[string]string{"error": "Delete not supported for this storage type"}
+				}
 			} else {
 				return response, fmt.Errorf("error deleting item '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
 			}
 			return response, nil
 		}
 		response.Payload = map[string]string{"status": "success", "item_path": payload.ItemPath, "name": itemName}
+		slog.Info("Deleted item", logging.KeyUser, userIdentifier, logging.KeyRequestID, msg.RequestID, logging.KeyStorage, payload.StorageName, logging.KeyPath, payload.ItemPath)
+
+	case "delete_items":
+		var payload struct {
+			StorageName string   `json:"storage_name"`
+			ItemPaths   []string `json:"item_paths"`
+		}
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for delete_items: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid delete_items payload: %w", err)
+		}
+		if len(payload.ItemPaths) == 0 {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "item_paths is required"}
+			return response, nil
+		}
+		if len(payload.ItemPaths) > maxDeleteItemsRequest {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": fmt.Sprintf("too many items requested: %d (max %d)", len(payload.ItemPaths), maxDeleteItemsRequest)}
+			return response, nil
+		}
+
+		results := h.deleteItems(ctx, claims, payload.StorageName, payload.ItemPaths)
+		deleted := 0
+		for _, result := range results {
+			if result.Status == itemStatusOK {
+				deleted++
+			}
+		}
+		response.Payload = map[string]interface{}{
+			"storage_name": payload.StorageName,
+			"deleted":      deleted,
+			"failed":       len(results) - deleted,
+			"items":        results,
+		}
 		if config.IsLogLevel(config.LogLevelInfo) {
-			log.Printf("delete_item_response (User: %s, ReqID: %s): Successfully deleted item %s/%s", userIdentifier, msg.RequestID, payload.StorageName, payload.ItemPath)
+			log.Printf("delete_items_response (User: %s, ReqID: %s): Deleted %d of %d items in %s", userIdentifier, msg.RequestID, deleted, len(results), payload.StorageName)
+		}
+
+	case "list_trash":
+		return h.listTrash(ctx, msg, claims, userIdentifier)
+
+	case "restore_item":
+		return h.restoreItem(ctx, msg, claims, userIdentifier)
+
+	case "empty_trash":
+		return h.emptyTrash(ctx, msg, claims, userIdentifier)
+
+	case "move_item":
+		var payload struct {
+			StorageName      string `json:"storage_name"`
+			SourcePath       string `json:"source_path"`
+			DestinationPath  string `json:"destination_path"`
+			ConflictStrategy string `json:"conflict_strategy"` // fail (predefinito), overwrite, merge, rename
+			FileConflict     string `json:"file_conflict"`     // Conflitti sui file di un merge: skip (predefinito), overwrite, rename, fail
+		}
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for move_item: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid move_item payload: %w", err)
+		}
+		if payload.SourcePath == "" || payload.DestinationPath == "" {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "source_path and destination_path are required"}
+			return response, nil
+		}
+		conflictStrategy, fileConflict, invalidMessage := conflictStrategyOptions(payload.ConflictStrategy, payload.FileConflict)
+		if invalidMessage != "" {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": invalidMessage, "error_code": "INVALID_CONFLICT_STRATEGY"}
+			return response, nil
+		}
+
+		// Lo spostamento modifica entrambe le directory padre: serve il permesso di scrittura su tutte e due.
+		for _, parentPath := range []string{filepath.Dir(payload.SourcePath), filepath.Dir(payload.DestinationPath)} {
+			if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, parentPath, "write", h.Config()); err != nil {
+				if errors.Is(err, storage.ErrPermissionDenied) {
+					response.Type = "error"
+					response.Payload = map[string]string{"error": "Access denied: write permission required on source and destination"}
+					return response, nil
+				}
+				return response, fmt.Errorf("error checking storage access for move_item: %w", err)
+			}
+		}
+		// L'elemento spostato (con tutto il suo contenuto) e la destinazione non devono contenere percorsi in sola lettura.
+		for _, itemPath := range []string{payload.SourcePath, payload.DestinationPath} {
+			if err := authz.CheckRecursiveWriteAccess(ctx, claims, payload.StorageName, itemPath, h.Config()); err != nil {
+				if errors.Is(err, storage.ErrPermissionDenied) {
+					response.Type = "error"
+					response.Payload = map[string]string{"error": "Access denied: write permission required on source and destination"}
+					return response, nil
+				}
+				return response, fmt.Errorf("error checking storage access for move_item: %w", err)
+			}
+		}
+
+		provider, ok := storage.GetProvider(payload.StorageName)
+		if !ok {
+			return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+		}
+		if conflictStrategy != storage.ConflictFail {
+			return h.transferWithConflicts(ctx, msg, response, provider, claims, userIdentifier, payload.StorageName, payload.SourcePath, payload.DestinationPath, true, conflictStrategy, fileConflict)
+		}
+		var moveResult *storage.MoveResult
+		switch p := storage.Unwrap(provider).(type) {
+		case *azureblob.AzureBlobStorageProvider:
+			// MoveItemWithResult non fa parte dell'interfaccia: lo slot di max_concurrent_operations va preso qui.
+			var releaseSlot func()
+			if releaseSlot, err = storage.AcquireSlot(ctx, provider); err == nil {
+				moveResult, err = p.MoveItemWithResult(ctx, claims, payload.SourcePath, payload.DestinationPath)
+				releaseSlot()
+				if err == nil {
+					storage.RecordChange(payload.StorageName, storage.ChangeDeleted, payload.SourcePath)
+					storage.RecordChange(payload.StorageName, storage.ChangeCreated, payload.DestinationPath)
+				}
+			}
+		default:
+			err = provider.MoveItem(ctx, claims, payload.SourcePath, payload.DestinationPath)
+			moveResult = defaultMoveResult(provider)
+		}
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Item not found"}
+			} else if errors.Is(err, storage.ErrAlreadyExists) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Destination already exists", "error_code": "ALREADY_EXISTS"}
+			} else if errors.Is(err, storage.ErrPermissionDenied) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": fmt.Sprintf("Access denied: %v", err)}
+			} else if errors.Is(err, storage.ErrNotImplemented) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Move not supported for this storage type"}
+			} else {
+				return response, fmt.Errorf("error moving item '%s/%s' to '%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.SourcePath, payload.DestinationPath, userIdentifier, msg.RequestID, err)
+			}
+			return response, nil
+		}
+		response.Payload = map[string]interface{}{
+			"status":           "success",
+			"storage_name":     payload.StorageName,
+			"source_path":      payload.SourcePath,
+			"destination_path": payload.DestinationPath,
+			"method":           moveResult.Method,
+			"instant":          moveResult.Instant,
+			"pending_copies":   moveResult.PendingCopies,
 		}
+		slog.Info("Moved item", logging.KeyUser, userIdentifier, logging.KeyRequestID, msg.RequestID, logging.KeyStorage, payload.StorageName, logging.KeyPath, payload.SourcePath, "destination", payload.DestinationPath, "method", moveResult.Method, "instant", moveResult.Instant)
+
+	case "copy_item":
+		var payload struct {
+			StorageName      string `json:"storage_name"`
+			SourcePath       string `json:"source_path"`
+			DestinationPath  string `json:"destination_path"`
+			ConflictStrategy string `json:"conflict_strategy"` // fail (predefinito), overwrite, merge, rename
+			FileConflict     string `json:"file_conflict"`     // Conflitti sui file di un merge: skip (predefinito), overwrite, rename, fail
+		}
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for copy_item: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid copy_item payload: %w", err)
+		}
+		if payload.SourcePath == "" || payload.DestinationPath == "" {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "source_path and destination_path are required"}
+			return response, nil
+		}
+		conflictStrategy, fileConflict, invalidMessage := conflictStrategyOptions(payload.ConflictStrategy, payload.FileConflict)
+		if invalidMessage != "" {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": invalidMessage, "error_code": "INVALID_CONFLICT_STRATEGY"}
+			return response, nil
+		}
+
+		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.SourcePath, "read", h.Config()); err != nil {
+			if errors.Is(err, storage.ErrPermissionDenied) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Access denied: read permission required on source"}
+				return response, nil
+			}
+			return response, fmt.Errorf("error checking storage access for copy_item: %w", err)
+		}
+		// La copia di una directory, il merge e la sovrascrittura scrivono in tutto l'albero della destinazione.
+		if err := authz.CheckRecursiveWriteAccess(ctx, claims, payload.StorageName, payload.DestinationPath, h.Config()); err != nil {
+			if errors.Is(err, storage.ErrPermissionDenied) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Access denied: write permission required on destination"}
+				return response, nil
+			}
+			return response, fmt.Errorf("error checking storage access for copy_item: %w", err)
+		}
+
+		provider, ok := storage.GetProvider(payload.StorageName)
+		if !ok {
+			return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+		}
+		if conflictStrategy != storage.ConflictFail {
+			return h.transferWithConflicts(ctx, msg, response, provider, claims, userIdentifier, payload.StorageName, payload.SourcePath, payload.DestinationPath, false, conflictStrategy, fileConflict)
+		}
+		err = provider.CopyItem(ctx, claims, payload.SourcePath, payload.DestinationPath)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Item not found"}
+			} else if errors.Is(err, storage.ErrAlreadyExists) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Destination already exists", "error_code": "ALREADY_EXISTS"}
+			} else if errors.Is(err, storage.ErrPermissionDenied) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": fmt.Sprintf("Access denied: %v", err)}
+			} else if errors.Is(err, storage.ErrNotImplemented) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Copy not supported for this storage type"}
+			} else {
+				return response, fmt.Errorf("error copying item '%s/%s' to '%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.SourcePath, payload.DestinationPath, userIdentifier, msg.RequestID, err)
+			}
+			return response, nil
+		}
+		response.Payload = map[string]string{
+			"status":           "success",
+			"storage_name":     payload.StorageName,
+			"source_path":      payload.SourcePath,
+			"destination_path": payload.DestinationPath,
+		}
+		slog.Info("Copied item", logging.KeyUser, userIdentifier, logging.KeyRequestID, msg.RequestID, logging.KeyStorage, payload.StorageName, logging.KeyPath, payload.SourcePath, "destination", payload.DestinationPath)
 
 	case "check_directory_contents_request":
 		var payload struct {
@@ -899,7 +1329,7 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			return response, fmt.Errorf("invalid check_directory_contents_request payload: %w", err)
 		}
 
-		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "read", h.config); err != nil {
+		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.DirPath, "read", h.Config()); err != nil {
 			if errors.Is(err, storage.ErrPermissionDenied) {
 				response.Type = "error"
 				response.Payload = map[string]string{"error": "Access denied: read permission required to check directory contents"}
@@ -913,7 +1343,7 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 			return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
 		}
 
-		listResponse, err := provider.ListItems(ctx, claims, payload.DirPath, 1, 1, "", nil, false) // onlyDirectories è false qui, perché vogliamo sapere se c'è *qualsiasi* contenuto
+		listResponse, err := provider.ListItems(ctx, claims, payload.DirPath, 1, 1, "", nil, false, false) // onlyDirectories è false qui, perché vogliamo sapere se c'è *qualsiasi* contenuto
 		if err != nil {
 			if errors.Is(err, storage.ErrNotFound) {
 				response.Payload = map[string]bool{"has_contents": false}
@@ -924,6 +1354,300 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 		response.Payload = map[string]bool{"has_contents": listResponse.TotalItems > 0}
 		return response, nil
 
+	case "get_items_info":
+		var payload struct {
+			Items []itemInfoRequest `json:"items"`
+		}
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for get_items_info: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid get_items_info payload: %w", err)
+		}
+		if len(payload.Items) > maxItemsInfoRequest {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": fmt.Sprintf("too many items requested: %d (max %d)", len(payload.Items), maxItemsInfoRequest)}
+			return response, nil
+		}
+
+		results := h.getItemsInfo(ctx, claims, payload.Items)
+		response.Payload = map[string]interface{}{
+			"items": results,
+		}
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("get_items_info_response (User: %s, ReqID: %s): Resolved %d items", userIdentifier, msg.RequestID, len(results))
+		}
+
+	case "get_item_metadata":
+		var payload struct {
+			StorageName string `json:"storage_name"`
+			ItemPath    string `json:"item_path"`
+		}
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for get_item_metadata: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid get_item_metadata payload: %w", err)
+		}
+
+		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ItemPath, "read", h.Config()); err != nil {
+			if errors.Is(err, storage.ErrPermissionDenied) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Access denied: read permission required"}
+				return response, nil
+			}
+			return response, fmt.Errorf("error checking storage access for get_item_metadata: %w", err)
+		}
+
+		provider, ok := storage.GetProvider(payload.StorageName)
+		if !ok {
+			return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+		}
+
+		itemInfo, err := provider.GetItem(ctx, claims, payload.ItemPath)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Item not found"}
+			} else if errors.Is(err, storage.ErrPermissionDenied) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Access denied: read permission required"}
+			} else {
+				return response, fmt.Errorf("error getting item '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
+			}
+			return response, nil
+		}
+		// ItemInfo ha un proprio MarshalJSON, che incorporato in una struct ne nasconderebbe gli altri campi:
+		// storage_name e item_path vengono aggiunti ai campi già serializzati.
+		itemJSON, err := json.Marshal(itemInfo)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal item metadata: %w", err)
+		}
+		var metadata map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(itemJSON))
+		decoder.UseNumber() // Le dimensioni restano int64 esatti
+		if err := decoder.Decode(&metadata); err != nil {
+			return response, fmt.Errorf("failed to marshal item metadata: %w", err)
+		}
+		metadata["storage_name"] = payload.StorageName
+		metadata["item_path"] = payload.ItemPath
+		response.Payload = metadata
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("get_item_metadata_response (User: %s, ReqID: %s): %s/%s (dir: %t, size: %d)", userIdentifier, msg.RequestID, payload.StorageName, payload.ItemPath, itemInfo.IsDir, itemInfo.Size)
+		}
+
+	case "compute_hash":
+		var payload struct {
+			StorageName string `json:"storage_name"`
+			ItemPath    string `json:"item_path"`
+		}
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for compute_hash: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid compute_hash payload: %w", err)
+		}
+
+		if err := authz.CheckStorageAccess(ctx, claims, payload.StorageName, payload.ItemPath, "read", h.Config()); err != nil {
+			if errors.Is(err, storage.ErrPermissionDenied) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Access denied: read permission required"}
+				return response, nil
+			}
+			return response, fmt.Errorf("error checking storage access for compute_hash: %w", err)
+		}
+
+		provider, ok := storage.GetProvider(payload.StorageName)
+		if !ok {
+			return response, fmt.Errorf("storage provider '%s' not found", payload.StorageName)
+		}
+
+		itemInfo, err := provider.GetItem(ctx, claims, payload.ItemPath)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Item not found"}
+				return response, nil
+			}
+			return response, fmt.Errorf("error getting item '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
+		}
+		if itemInfo.IsDir {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Cannot compute the hash of a directory"}
+			return response, nil
+		}
+
+		// Se il provider ha un checksum salvato e ancora valido, non serve rileggere il file.
+		sha256Hex := itemInfo.SHA256
+		cached := sha256Hex != ""
+		if !cached {
+			sha256Hex, err = hashAndStoreChecksum(ctx, provider, claims, payload.StorageName, payload.ItemPath)
+			if err != nil {
+				if errors.Is(err, storage.ErrPermissionDenied) {
+					response.Type = "error"
+					response.Payload = map[string]string{"error": "Access denied: read permission required"}
+					return response, nil
+				}
+				return response, fmt.Errorf("error hashing item '%s/%s' (User: %s, ReqID: %s): %w", payload.StorageName, payload.ItemPath, userIdentifier, msg.RequestID, err)
+			}
+		}
+
+		response.Payload = map[string]interface{}{
+			"storage_name": payload.StorageName,
+			"item_path":    payload.ItemPath,
+			"size":         itemInfo.Size,
+			"sha256":       sha256Hex,
+			"cached":       cached,
+		}
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("compute_hash_response (User: %s, ReqID: %s): %s/%s sha256=%s cached=%t", userIdentifier, msg.RequestID, payload.StorageName, payload.ItemPath, sha256Hex, cached)
+		}
+
+	case "my_recent_errors":
+		var payload struct {
+			UserEmail string `json:"user_email,omitempty"` // Solo per gli admin: errori di un altro utente
+		}
+		if msg.Payload != nil {
+			payloadBytes, err := json.Marshal(msg.Payload)
+			if err != nil {
+				return response, fmt.Errorf("failed to marshal payload for my_recent_errors: %w", err)
+			}
+			if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+				return response, fmt.Errorf("invalid my_recent_errors payload: %w", err)
+			}
+		}
+
+		targetUser := userKeyFromClaims(claims)
+		if payload.UserEmail != "" && payload.UserEmail != targetUser {
+			if !authz.IsGlobalAdmin(claims, h.Config()) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Access denied: only administrators can read other users' errors"}
+				return response, nil
+			}
+			targetUser = payload.UserEmail
+		}
+		recentErrors := h.recentErrors.list(targetUser)
+		response.Payload = map[string]interface{}{
+			"user_email": targetUser,
+			"errors":     recentErrors,
+		}
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("my_recent_errors_response (User: %s, ReqID: %s): Returned %d errors for '%s'", userIdentifier, msg.RequestID, len(recentErrors), targetUser)
+		}
+
+	case "my_permissions":
+		return h.myPermissions(ctx, msg, claims, userIdentifier)
+
+	case "explain_access":
+		if !authz.IsGlobalAdmin(claims, h.Config()) {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "Access denied: only administrators can use explain_access"}
+			return response, nil
+		}
+		var payload struct {
+			StorageName string   `json:"storage_name"`
+			ItemPath    string   `json:"item_path"`
+			UserEmail   string   `json:"user_email"`
+			GroupNames  []string `json:"group_names,omitempty"` // Opzionale: gruppi da usare se l'utente non è mai stato visto
+		}
+		payloadBytes, err := json.Marshal(msg.Payload)
+		if err != nil {
+			return response, fmt.Errorf("failed to marshal payload for explain_access: %w", err)
+		}
+		if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+			return response, fmt.Errorf("invalid explain_access payload: %w", err)
+		}
+		if payload.StorageName == "" || payload.UserEmail == "" {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": "storage_name and user_email are required"}
+			return response, nil
+		}
+
+		// I gruppi dell'utente sono noti solo dai suoi claims: usiamo quelli esplicitamente forniti,
+		// altrimenti gli ultimi claims visti per quell'utente.
+		var targetClaims *auth.UserClaims
+		groupsSource := "payload"
+		if payload.GroupNames != nil {
+			targetClaims = &auth.UserClaims{Email: payload.UserEmail, GroupNames: payload.GroupNames}
+		} else if known := h.lookupClaims(payload.UserEmail); known != nil {
+			targetClaims = known
+			groupsSource = "session"
+		} else if h.Config().EnableAuth {
+			response.Type = "error"
+			response.Payload = map[string]string{"error": fmt.Sprintf("no session known for user '%s': provide group_names to evaluate their access", payload.UserEmail)}
+			return response, nil
+		} else {
+			targetClaims = &auth.UserClaims{Email: payload.UserEmail}
+			groupsSource = "none"
+		}
+
+		explanation := authz.ExplainAccess(targetClaims, payload.StorageName, payload.ItemPath, h.Config())
+		response.Payload = map[string]interface{}{
+			"explanation":   explanation,
+			"groups_source": groupsSource,
+		}
+		if config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("explain_access (Admin: %s, ReqID: %s): user '%s' on storage '%s', path '%s': read=%s write=%s (%s)", userIdentifier, msg.RequestID, payload.UserEmail, payload.StorageName, payload.ItemPath, explanation.Read, explanation.Write, explanation.Reason)
+		}
+
+	case "cancel_all_uploads":
+		var payload struct {
+			UserEmail string `json:"user_email,omitempty"` // Solo per gli admin: upload di un altro utente
+		}
+		if msg.Payload != nil {
+			payloadBytes, err := json.Marshal(msg.Payload)
+			if err != nil {
+				return response, fmt.Errorf("failed to marshal payload for cancel_all_uploads: %w", err)
+			}
+			if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+				return response, fmt.Errorf("invalid cancel_all_uploads payload: %w", err)
+			}
+		}
+
+		targetUser := userKeyFromClaims(claims)
+		if payload.UserEmail != "" && payload.UserEmail != targetUser {
+			if !authz.IsGlobalAdmin(claims, h.Config()) {
+				response.Type = "error"
+				response.Payload = map[string]string{"error": "Access denied: only administrators can cancel other users' uploads"}
+				return response, nil
+			}
+			targetUser = payload.UserEmail
+		}
+
+		results := h.cancelUserUploads(targetUser)
+		cancelled := 0
+		for _, result := range results {
+			if result.Error == "" {
+				cancelled++
+				if targetUser != userKeyFromClaims(claims) {
+					h.Notify(targetUser, Notification{
+						Type:        NotificationUploadCancelled,
+						StorageName: result.StorageName,
+						Path:        result.ItemPath,
+						Message:     "Upload cancelled by an administrator",
+						Details:     map[string]interface{}{"reason": "cancelled_by_admin"},
+					})
+				}
+			}
+		}
+		response.Payload = map[string]interface{}{
+			"user_email": targetUser,
+			"cancelled":  cancelled,
+			"uploads":    results,
+		}
+		if config.IsLogLevel(config.LogLevelInfo) {
+			log.Printf("cancel_all_uploads (User: %s, ReqID: %s): Cancelled %d of %d uploads of '%s'", userIdentifier, msg.RequestID, cancelled, len(results), targetUser)
+		}
+
+	case "protocol_info":
+		response.Payload = currentProtocolInfo()
+		if config.IsLogLevel(config.LogLevelDebug) {
+			log.Printf("protocol_info_response (User: %s, ReqID: %s): version %d", userIdentifier, msg.RequestID, ProtocolVersion)
+		}
+
 	case "ping":
 		response.Type = "pong"
 		response.Payload = msg.Payload
@@ -941,7 +1665,7 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 	default:
 		response.Type = "error"
 		response.Payload = map[string]string{"error": fmt.Sprintf("unsupported message type: %s", msg.Type)}
-		log.Printf("Unsupported message type received (User: %s, Type: %s, ReqID: %s)", userIdentifier, msg.Type, msg.RequestID)
+		slog.Warn("Unsupported message type received", logging.KeyUser, userIdentifier, logging.KeyMessageType, msg.Type, logging.KeyRequestID, msg.RequestID)
 		return response, fmt.Errorf("unsupported message type: %s", msg.Type)
 	}
 
@@ -958,3 +1682,20 @@ func (h *Hub) handleClientMessage(ctx context.Context, msg *Message, claims *aut
 
 	return response, nil
 }
+
+// rememberClaims records the latest claims of a user, so that admin diagnostics can evaluate their access.
+func (h *Hub) rememberClaims(claims *auth.UserClaims) {
+	if claims == nil || claims.Email == "" {
+		return
+	}
+	h.knownClaimsMu.Lock()
+	h.knownClaims[strings.ToLower(claims.Email)] = claims
+	h.knownClaimsMu.Unlock()
+}
+
+// lookupClaims returns the latest claims seen for the given email, or nil.
+func (h *Hub) lookupClaims(email string) *auth.UserClaims {
+	h.knownClaimsMu.RLock()
+	defer h.knownClaimsMu.RUnlock()
+	return h.knownClaims[strings.ToLower(email)]
+}